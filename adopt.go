@@ -0,0 +1,61 @@
+package rustybuffer
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// AdoptExternal wraps memory owned by another library, an LMDB or Bolt read
+// transaction's pages say, or a region mapped with mmap, in an entry with
+// one buffer per size laid out back to back from ptr, so that code which
+// takes entries and views can use it without caring where it came from.
+// Releasing the entry (or any copy) calls free, if it isn't nil, exactly
+// once, and until then the memory must stay valid. Like RegisterGoBuffer's
+// entries, adopted ones aren't counted by any pool, and the memory is
+// never wiped, it not being the package's to write to.
+func AdoptExternal(ptr unsafe.Pointer, sizes []uint64, free func()) (RBEntry, error) {
+	num_bytes, err := totalSize(sizes)
+	if err != nil {
+		return RBEntry{}, err
+	}
+	if ptr == nil {
+		return RBEntry{}, errors.New("rustybuffer: can't adopt a nil pointer")
+	}
+	buffers, err := carveBuffers(ptr, num_bytes, sizes)
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	debugAcquired(ptr, num_bytes)
+	state := &entryState{
+		pool:   defaultPool,
+		handle: nextHandle(),
+		data:   ptr,
+		size:   num_bytes,
+		alloc:  &externalAllocator{free: free},
+
+		entryPins: &entryPins{},
+	}
+	return RBEntry{ptr, buffers, state}, nil
+}
+
+// externalAllocator owns the memory of an entry from AdoptExternal, which
+// it gives back by calling free.
+type externalAllocator struct {
+	free func()
+}
+
+func (alloc *externalAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	return nil, newError(codeAllocationFailed, "external memory can't be acquired")
+}
+
+func (alloc *externalAllocator) Release(data unsafe.Pointer) error {
+	if alloc.free != nil {
+		alloc.free()
+	}
+	return nil
+}
+
+func (alloc *externalAllocator) Stats() Stats {
+	return Stats{}
+}
@@ -0,0 +1,56 @@
+package rustybuffer
+
+import (
+	"errors"
+	"testing"
+	"unsafe"
+)
+
+func TestAdoptExternal(t *testing.T) {
+	region := []byte("headerpayload!")
+	frees := 0
+	entry, err := AdoptExternal(unsafe.Pointer(&region[0]), []uint64{6, 7}, func() { frees++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Buffers) != 2 || string(entry.Buffers[0]) != "header" || string(entry.Buffers[1]) != "payload" {
+		t.Fatalf("unexpected buffers %q", entry.Buffers)
+	}
+	if entry.Handle() == 0 {
+		t.Fatal("expected the entry to have a handle")
+	}
+	view := entry.View(1)
+	if view.CopyString() != "payload" {
+		t.Fatalf("unexpected view %q", view.CopyString())
+	}
+
+	// Pinning holds off the free, and copies share the one release.
+	_, unpin := entry.Pin()
+	copied := entry
+	copied.Release()
+	if frees != 0 {
+		t.Fatal("the memory was freed while pinned")
+	}
+	unpin()
+	entry.Release()
+	if frees != 1 {
+		t.Fatalf("expected one free, got %d", frees)
+	}
+	expectPanic(t, "released", func() { view.Bytes() })
+	if string(region) != "headerpayload!" {
+		t.Fatalf("the adopted memory was written to: %q", region)
+	}
+
+	entry, err = AdoptExternal(unsafe.Pointer(&region[0]), []uint64{uint64(len(region))}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+
+	if _, err := AdoptExternal(nil, []uint64{1}, nil); err == nil {
+		t.Fatal("expected a nil pointer to be refused")
+	}
+	if _, err := AdoptExternal(unsafe.Pointer(&region[0]), []uint64{1 << 63, 1 << 63}, nil); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+}
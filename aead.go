@@ -0,0 +1,62 @@
+package rustybuffer
+
+import (
+	"crypto/cipher"
+	"fmt"
+)
+
+// SealInPlace encrypts and authenticates the first plaintext_len bytes of
+// view with aead, overwriting them with the ciphertext and writing the tag
+// after it, so view needs aead.Overhead() bytes of room past the
+// plaintext. It returns the view of the sealed message. The plaintext is
+// never copied, so it doesn't end up on the Go heap.
+//
+// Any cipher.AEAD works, e.g., AES-GCM from crypto/aes and crypto/cipher
+// or ChaCha20-Poly1305 from golang.org/x/crypto. As with aead.Seal, a
+// nonce must never be reused with the same key.
+func SealInPlace(
+	aead cipher.AEAD,
+	nonce []byte,
+	view View,
+	plaintext_len int,
+	additional []byte,
+) (View, error) {
+	if err := checkNonce(aead, nonce); err != nil {
+		return View{}, err
+	}
+	if plaintext_len < 0 || plaintext_len > view.Len()-aead.Overhead() {
+		return View{}, fmt.Errorf(
+			"rustybuffer: sealing %d bytes needs %d bytes of room, the view has %d",
+			plaintext_len, plaintext_len+aead.Overhead(), view.Len())
+	}
+
+	plaintext := view.Bytes()[:plaintext_len]
+	sealed := aead.Seal(plaintext[:0], nonce, plaintext, additional)
+	return view.Slice(0, len(sealed)), nil
+}
+
+// OpenInPlace authenticates and decrypts a message sealed by SealInPlace,
+// overwriting it with the plaintext, and returns the view of the
+// plaintext. If authentication fails the error is from aead.Open and the
+// contents of view are undefined.
+func OpenInPlace(aead cipher.AEAD, nonce []byte, view View, additional []byte) (View, error) {
+	if err := checkNonce(aead, nonce); err != nil {
+		return View{}, err
+	}
+
+	sealed := view.Bytes()
+	plaintext, err := aead.Open(sealed[:0], nonce, sealed, additional)
+	if err != nil {
+		return View{}, err
+	}
+	return view.Slice(0, len(plaintext)), nil
+}
+
+// checkNonce turns the panic aead would raise into an error.
+func checkNonce(aead cipher.AEAD, nonce []byte) error {
+	if len(nonce) != aead.NonceSize() {
+		return fmt.Errorf("rustybuffer: nonce is %d bytes, expected %d",
+			len(nonce), aead.NonceSize())
+	}
+	return nil
+}
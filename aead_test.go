@@ -0,0 +1,64 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+func TestSealInPlace(t *testing.T) {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+	message := []byte("attack at dawn")
+	entry, err := pool.AllocBuffers([]uint64{uint64(len(message) + aead.Overhead())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	view := entry.View(0)
+	copy(view.Bytes(), message)
+	nonce := make([]byte, aead.NonceSize())
+
+	sealed, err := SealInPlace(aead, nonce, view, len(message), []byte("header"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed.Len() != view.Len() || bytes.Contains(view.Bytes(), message) {
+		t.Fatal("the message wasn't encrypted in place")
+	}
+	if &sealed.Bytes()[0] != &view.Bytes()[0] {
+		t.Fatal("the sealed message isn't in the entry")
+	}
+
+	if _, err = OpenInPlace(aead, nonce, sealed, []byte("other")); err == nil {
+		t.Fatal("opened with the wrong additional data")
+	}
+
+	// A failed open leaves the buffer undefined, so seal again.
+	copy(view.Bytes(), message)
+	sealed, _ = SealInPlace(aead, nonce, view, len(message), []byte("header"))
+	opened, err := OpenInPlace(aead, nonce, sealed, []byte("header"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened.Bytes(), message) {
+		t.Fatalf("opened %q", opened.Bytes())
+	}
+
+	if _, err = SealInPlace(aead, nonce, view, view.Len(), nil); err == nil {
+		t.Fatal("expected an error without room for the tag")
+	}
+	if _, err = SealInPlace(aead, nonce[1:], view, len(message), nil); err == nil {
+		t.Fatal("expected an error for a short nonce")
+	}
+}
@@ -2,81 +2,174 @@ package rustybuffer
 
 import (
 	"fmt"
-	"runtime"
+	"strings"
 	"unsafe"
 )
 
-/*
-#cgo LDFLAGS: ./lib/librustybuffer.a
-#include <stdint.h>
-#include "./lib/rustybuffer.h"
-*/
-import "C"
-
-// max_total_size - The total number of bytes hat RustyBuffers will allocate
-// max_buffer_size - The maximum number of bytes in a single buffer
-func Configure(max_total_size uint64, max_buffer_size uint64) {
-	c_max_total := C.uint64_t(max_total_size)
-	c_max_buffer := C.uint64_t(max_buffer_size)
-	res := C.rustybuffer_config(c_max_total, c_max_buffer)
-	if res != 0 {
-		panic("something something return (nil, err) thing")
+// RBEntry is a set of buffers carved out of a single acquisition. Entries
+// are values, and copies share their release state: whichever copy is
+// released first gives the memory back and releasing the rest is a no-op.
+// That holds across goroutines, so copies of an entry can be handed to
+// several goroutines and released by whichever finishes last, or first.
+// A single RBEntry variable is no more safe for concurrent use than any
+// other Go value though, and the buffers must not be touched once any copy
+// has been released. Entries are identified by their Handle, the address
+// of the memory behind them is the package's business.
+type RBEntry struct {
+	data    unsafe.Pointer
+	Buffers [][]uint8
+
+	state *entryState
+}
+
+// NewRBEntry wraps memory acquired from the Rust library. If the default
+// pool has the leak finalizer enabled (see EnableLeakFinalizer) the entry
+// gets one too.
+func NewRBEntry(data unsafe.Pointer, buffers [][]uint8) RBEntry {
+	var size uint64 = 0
+	for _, buffer := range buffers {
+		size += uint64(len(buffer))
 	}
+
+	debugAcquired(data, size)
+	return RBEntry{data, buffers, newEntryState(defaultPool, data, size, rustAllocator{})}
 }
 
-type RBEntry struct {
-	Data    unsafe.Pointer
-	Buffers []unsafe.Pointer
+func (entry *RBEntry) Release() {
+	if err := entry.release(); err != nil {
+		panic("a thing broke")
+	}
 }
 
-func NewRBEntry(data unsafe.Pointer, buffers []unsafe.Pointer) RBEntry {
-	ret := RBEntry{data, buffers}
-	runtime.SetFinalizer(ret, ret.Release)
+// Close is Release for code that expects an io.Closer, except that an
+// error from the allocator is returned rather than panicking. Like
+// Release, closing an entry (or any copy of it) more than once does
+// nothing and returns nil.
+func (entry *RBEntry) Close() error {
+	return entry.release()
+}
 
-	return ret
+func (entry *RBEntry) release() error {
+	if entry.data == nil {
+		return nil
+	}
+
+	// Every copy of an entry shares its state, so releasing one copy
+	// makes releasing the others a no-op rather than a double free, even
+	// if they race. A pinned entry is given back once it's unpinned.
+	if entry.state != nil &&
+		(!entry.state.released.CompareAndSwap(false, true) || entry.state.deferRelease()) {
+		entry.data = nil
+		entry.Buffers = make([][]uint8, 0)
+		return nil
+	}
+
+	if err := entry.releaseMemory(); err != nil {
+		return err
+	}
+
+	entry.data = nil
+	entry.Buffers = make([][]uint8, 0)
+
+	return nil
 }
 
-func (entry *RBEntry) Release() {
-	if entry.Data == nil {
-		return
+// releaseMemory gives the entry's memory back to its allocator, once the
+// entry has been marked released.
+func (entry *RBEntry) releaseMemory() error {
+	if entry.state != nil {
+		if _, unowned := entry.state.alloc.(unownedAllocator); !unowned {
+			debugReleased(entry.data)
+		}
+		if entry.state.pool.shadows != nil {
+			entry.state.pool.shadows.release(entry.data)
+		}
+		entry.state.wipe()
 	}
+	err := entry.allocator().Release(entry.data)
 
-	res := C.rustybuffer_release(entry.Data)
+	if err != nil {
+		return err
+	}
+	if entry.state != nil {
+		if entry.state.tracker != nil {
+			entry.state.tracker.forget(entry.data)
+		}
+		entry.state.pool.forget(entry.data)
+	}
 
-	if res != 0 {
-		panic("a thing broke")
+	// Wake up anything blocked on an exhausted pool.
+	releases.notify()
+	if entry.state != nil {
+		entry.state.pool.checkWatermarks()
 	}
 
-	entry.Data = nil
-	entry.Buffers = make([]unsafe.Pointer, 0)
+	return nil
 }
 
-func AllocBuffers(sizes []uint64) RBEntry {
-	fmt.Println("[Go]:", sizes)
+// Entries summarise at most this many buffer sizes in String.
+const stringMaxSizes = 8
 
-	var num_bytes uint64 = 0
-	for _, size := range sizes {
-		num_bytes += size
+// String summarises the entry as its handle, total size and buffer sizes,
+// or that it's been released, so that printing an entry (or a struct
+// holding one) doesn't dump every byte of its buffers.
+func (entry RBEntry) String() string {
+	if entry.state != nil && entry.state.released.Load() {
+		return fmt.Sprintf("RBEntry{%s released}", entry.state.handle)
+	}
+	if entry.data == nil {
+		return "RBEntry{nil}"
 	}
 
-	fmt.Println("[Go]: Total Bytes:", num_bytes)
+	var size uint64 = 0
+	sizes := make([]string, 0, min(len(entry.Buffers), stringMaxSizes+1))
+	for idx, buffer := range entry.Buffers {
+		size += uint64(len(buffer))
+		if idx < stringMaxSizes {
+			sizes = append(sizes, fmt.Sprint(len(buffer)))
+		}
+	}
+	if len(entry.Buffers) > stringMaxSizes {
+		sizes = append(sizes, fmt.Sprintf("and %d more", len(entry.Buffers)-stringMaxSizes))
+	}
 
-	c_num_bytes := C.uint64_t(num_bytes)
-	var data unsafe.Pointer
+	return fmt.Sprintf("RBEntry{%s %d bytes in %d buffers [%s]}",
+		entry.Handle(), size, len(entry.Buffers), strings.Join(sizes, " "))
+}
 
-	res := C.rustybuffer_acquire(c_num_bytes, &data)
+// GoString is String, so %#v doesn't dump the buffers either.
+func (entry RBEntry) GoString() string {
+	return entry.String()
+}
 
-	if res != 0 {
-		panic("lol error handling")
+// allocator returns the Allocator that owns the entry's memory. Entries
+// created without one (i.e., as a struct literal) belong to the Rust
+// library.
+func (entry *RBEntry) allocator() Allocator {
+	if entry.state == nil || entry.state.alloc == nil {
+		return rustAllocator{}
 	}
+	return entry.state.alloc
+}
 
-	var curr_offset uint64 = 0
-	var buffers = make([]unsafe.Pointer, len(sizes))
-	for idx, size := range sizes {
-		ptr := unsafe.Add(data, curr_offset)
-		buffers[idx] = unsafe.SliceData(unsafe.Slice(ptr, size))
-		curr_offset += size
+func AllocBuffers(sizes []uint64) RBEntry {
+	fmt.Println("[Go]:", sizes)
+
+	entry, err := allocBuffers(sizes)
+	if err != nil {
+		panic("lol error handling")
 	}
 
-	return NewRBEntry(data, buffers)
+	return entry
+}
+
+// WithEntry is WithEntry on the default pool.
+func WithEntry(sizes []uint64, fn func(entry RBEntry) error) error {
+	return defaultPool.WithEntry(sizes, fn)
+}
+
+// allocBuffers is AllocBuffers without the panic so that callers with a
+// sensible fallback (e.g., BufferPool) can handle an exhausted pool.
+func allocBuffers(sizes []uint64) (RBEntry, error) {
+	return defaultPool.AllocBuffers(sizes)
 }
@@ -1,5 +1,14 @@
 package rustybuffer
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"testing"
+	"unsafe"
+)
+
 func ExampleAllocBuffers() {
 	Configure(8*1024*1024*1024, 2*1024*1024*1024)
 	sizes := [...]uint64{5, 10, 15}
@@ -15,3 +24,74 @@ func AllocBuffersSpeed() {
 		entry.Release()
 	}
 }
+
+func TestEntryString(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	entry, err := pool.AllocBuffers([]uint64{5, 10, 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(entry.Buffers[0], "hello")
+
+	expected := regexp.MustCompile(`^RBEntry\{#[0-9]+ 30 bytes in 3 buffers \[5 10 15\]\}$`)
+	for _, str := range []string{fmt.Sprint(entry), fmt.Sprintf("%v", &entry), fmt.Sprintf("%#v", entry)} {
+		if !expected.MatchString(str) {
+			t.Fatalf("unexpected summary %q", str)
+		}
+	}
+
+	// Both the released copy and any other show as released.
+	other := entry
+	entry.Release()
+	released := regexp.MustCompile(`^RBEntry\{#[0-9]+ released\}$`)
+	for _, str := range []string{entry.String(), other.String()} {
+		if !released.MatchString(str) {
+			t.Fatalf("unexpected summary %q", str)
+		}
+	}
+
+	if str := (RBEntry{}).String(); str != "RBEntry{nil}" {
+		t.Fatalf("unexpected summary %q", str)
+	}
+
+	many, err := pool.AllocBuffers(make([]uint64, stringMaxSizes+3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer many.Release()
+	if str := many.String(); !regexp.MustCompile(`in 11 buffers \[0 0 0 0 0 0 0 0 and 3 more\]\}$`).MatchString(str) {
+		t.Fatalf("unexpected summary %q", str)
+	}
+}
+
+func TestEntryClose(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc))
+
+	entry, err := pool.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var closer io.Closer = &entry
+	copied := entry
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", alloc.Stats())
+	}
+	if err := closer.Close(); err != nil {
+		t.Fatalf("closing twice: %v", err)
+	}
+	if err := copied.Close(); err != nil {
+		t.Fatalf("closing a copy: %v", err)
+	}
+
+	// Errors are returned rather than panicking.
+	var data uint64
+	bogus := RBEntry{data: unsafe.Pointer(&data)}
+	if err := bogus.Close(); !errors.Is(err, ErrInvalidPointer) {
+		t.Fatalf("expected ErrInvalidPointer, got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package rustybuffer
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// Allocator is the backend a Pool acquires its memory from. The Rust
+// library is the default (see NewRustAllocator), NewMallocAllocator and
+// NewHeapAllocator exist for testing, benchmarking alternatives and gradual
+// rollout.
+//
+// Acquire must return at least size zeroed bytes, ErrBufferTooLarge when
+// size exceeds the allocator's per-buffer limit, ErrNoBufferAvailable when
+// the allocator is full and ErrAllocationFailed when the memory couldn't be
+// had at all. Release must return ErrInvalidPointer for pointers it didn't
+// hand out. Errors should be an *Error wrapping those to say why.
+// Implementations must be safe for concurrent use.
+type Allocator interface {
+	Acquire(size uint64) (unsafe.Pointer, error)
+	Release(data unsafe.Pointer) error
+	Stats() Stats
+}
+
+// handleLister is implemented by allocators that can list the addresses of
+// the buffers they currently have handed out, see Pool.Reconcile.
+type handleLister interface {
+	LiveHandles() ([]uintptr, error)
+}
+
+// trimmer is implemented by allocators that cache released memory and can
+// give it back on demand, see FreeOSMemory. Trim returns the bytes freed,
+// as far as the allocator can tell.
+type trimmer interface {
+	Trim() uint64
+}
+
+// extentLister is implemented by allocators that can describe their free
+// space, see Pool.FragmentationReport: the sizes of the extents an
+// acquire can be carved from whole, and of the buffers cached for reuse.
+type extentLister interface {
+	FreeExtents() (extents []uint64, cached []uint64, err error)
+}
+
+// Stats describes the state of an Allocator.
+type Stats struct {
+	// The configured limits.
+	MaxTotalSize  uint64
+	MaxBufferSize uint64
+
+	// Bytes held by the allocator, whether in use or cached for reuse.
+	BytesAllocated uint64
+
+	// Bytes currently handed out to callers.
+	BytesInUse uint64
+
+	// The number of buffers held, and how many of those are available for
+	// reuse.
+	NumBuffers   uint64
+	NumAvailable uint64
+
+	// Bytes in use that a Pool took from the Go heap because its
+	// allocator couldn't provide them (see ExhaustionHeap). Allocators
+	// themselves always report zero.
+	FallbackBytes uint64
+
+	// Bytes in use that a Pool mapped from temporary files, see
+	// ExhaustionSpill and WithSpillThreshold.
+	SpilledBytes uint64
+
+	// Bytes in use that a Pool mapped in huge pages, see WithHugePages.
+	HugePageBytes uint64
+
+	// Releases a Pool verified it had wiped, and those where the memory
+	// wasn't all zeros when read back, see WithVerifiedWipe. Allocators
+	// themselves always report zero.
+	WipesVerified uint64
+	WipesFailed   uint64
+
+	// What a Pool has acquired by size, smallest first, if it was created
+	// with WithSizeClassStats. Allocators themselves report none.
+	SizeClasses []SizeClassStats
+
+	// Released memory a Pool is holding in quarantine, which is still in
+	// the allocator's BytesInUse, and how often its poison was found
+	// written over, see WithQuarantine. Allocators themselves always
+	// report zero.
+	QuarantinedBytes     uint64
+	NumQuarantined       uint64
+	QuarantineViolations uint64
+
+	// The acquires a Pool has blocked by ExhaustionBlock, or deferring to
+	// them, by tag and priority. Allocators themselves report none.
+	WaitQueue []WaitQueueStats
+}
+
+// sizeTracker enforces max_total_size/max_buffer_size limits for the
+// allocators that don't cache buffers, i.e., everything is released back
+// to the underlying allocator immediately.
+type sizeTracker struct {
+	mu              sync.Mutex
+	max_total_size  uint64
+	max_buffer_size uint64
+	bytes_in_use    uint64
+	sizes           map[unsafe.Pointer]uint64
+}
+
+func newSizeTracker(max_total_size uint64, max_buffer_size uint64) *sizeTracker {
+	return &sizeTracker{
+		max_total_size:  max_total_size,
+		max_buffer_size: max_buffer_size,
+		sizes:           make(map[unsafe.Pointer]uint64),
+	}
+}
+
+// acquire calls alloc with the lock held if size fits in the limits.
+func (tracker *sizeTracker) acquire(
+	size uint64,
+	alloc func(size uint64) unsafe.Pointer,
+) (unsafe.Pointer, error) {
+	if size > tracker.max_buffer_size {
+		return nil, newError(codeBufferTooLarge,
+			"requested %d bytes but max_buffer_size is %d",
+			size, tracker.max_buffer_size)
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.bytes_in_use+size > tracker.max_total_size {
+		return nil, newError(codeNoBufferAvailable,
+			"requested %d bytes with %d of max_total_size %d in use",
+			size, tracker.bytes_in_use, tracker.max_total_size)
+	}
+
+	data := alloc(size)
+	if data == nil {
+		return nil, newError(codeAllocationFailed,
+			"the system allocator refused %d bytes", size)
+	}
+
+	tracker.sizes[data] = size
+	tracker.bytes_in_use += size
+	if debugAssertions {
+		tracker.check()
+	}
+
+	return data, nil
+}
+
+// release calls free with the lock held if data is being tracked.
+func (tracker *sizeTracker) release(
+	data unsafe.Pointer,
+	free func(data unsafe.Pointer),
+) error {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	size, ok := tracker.sizes[data]
+	if !ok {
+		return newError(codeInvalidPointer,
+			"%p was not acquired from this allocator", data)
+	}
+
+	free(data)
+	delete(tracker.sizes, data)
+	tracker.bytes_in_use -= size
+	if debugAssertions {
+		tracker.check()
+	}
+
+	return nil
+}
+
+// check asserts the bookkeeping adds up, with the lock held.
+func (tracker *sizeTracker) check() {
+	var total uint64 = 0
+	for _, size := range tracker.sizes {
+		total += size
+	}
+	assertf(total == tracker.bytes_in_use,
+		"%d buffers add up to %d bytes but %d are in use",
+		len(tracker.sizes), total, tracker.bytes_in_use)
+	assertf(tracker.bytes_in_use <= tracker.max_total_size,
+		"%d bytes in use exceeds max_total_size %d",
+		tracker.bytes_in_use, tracker.max_total_size)
+}
+
+func (tracker *sizeTracker) liveHandles() []uintptr {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	live := make([]uintptr, 0, len(tracker.sizes))
+	for data := range tracker.sizes {
+		live = append(live, uintptr(data))
+	}
+	return live
+}
+
+func (tracker *sizeTracker) stats() Stats {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	return Stats{
+		MaxTotalSize:   tracker.max_total_size,
+		MaxBufferSize:  tracker.max_buffer_size,
+		BytesAllocated: tracker.bytes_in_use,
+		BytesInUse:     tracker.bytes_in_use,
+		NumBuffers:     uint64(len(tracker.sizes)),
+		NumAvailable:   0,
+	}
+}
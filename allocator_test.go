@@ -0,0 +1,89 @@
+package rustybuffer
+
+import (
+	"errors"
+	"testing"
+	"unsafe"
+)
+
+func testAllocators() map[string]Allocator {
+	Configure(1024*1024, 64*1024)
+	return map[string]Allocator{
+		"rust":   NewRustAllocator(),
+		"malloc": NewMallocAllocator(1024*1024, 64*1024),
+		"heap":   NewHeapAllocator(1024*1024, 64*1024),
+	}
+}
+
+func TestAllocatorAcquireRelease(t *testing.T) {
+	for name, alloc := range testAllocators() {
+		t.Run(name, func(t *testing.T) {
+			before := alloc.Stats()
+
+			data, err := alloc.Acquire(4096)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := unsafe.Slice((*uint8)(data), 4096)
+			for _, b := range buf {
+				if b != 0 {
+					t.Fatal("acquired memory was not zeroed")
+				}
+			}
+			buf[4095] = 1
+
+			during := alloc.Stats()
+			if during.BytesInUse < before.BytesInUse+4096 {
+				t.Fatalf("bytes in use did not grow: %+v", during)
+			}
+			if during.MaxBufferSize != 64*1024 {
+				t.Fatalf("unexpected limits: %+v", during)
+			}
+
+			if err := alloc.Release(data); err != nil {
+				t.Fatal(err)
+			}
+			if alloc.Stats().BytesInUse != before.BytesInUse {
+				t.Fatal("bytes in use did not shrink")
+			}
+		})
+	}
+}
+
+func TestAllocatorErrors(t *testing.T) {
+	for name, alloc := range testAllocators() {
+		t.Run(name, func(t *testing.T) {
+			_, err := alloc.Acquire(64*1024 + 1)
+			if !errors.Is(err, ErrBufferTooLarge) {
+				t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+			}
+
+			var held []unsafe.Pointer
+			for {
+				data, err := alloc.Acquire(64 * 1024)
+				if errors.Is(err, ErrNoBufferAvailable) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				held = append(held, data)
+				if len(held) > 16 {
+					t.Fatal("allocator exceeded its total size")
+				}
+			}
+
+			for _, data := range held {
+				if err := alloc.Release(data); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var bogus uint8
+			err = alloc.Release(unsafe.Pointer(&bogus))
+			if !errors.Is(err, ErrInvalidPointer) {
+				t.Fatalf("expected ErrInvalidPointer, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package rustybuffer
+
+import (
+	"io"
+	"net"
+)
+
+// AppendBuffers acquire memory from their pool in chunks of this size,
+// unless told otherwise.
+const defaultAppendChunkSize = 64 * 1024
+
+// AppendBuffer accumulates bytes in pooled chunks, acquiring another chunk
+// when the last one fills up rather than reallocating and copying what's
+// there, for output that grows to a size not known up front. Once it's
+// complete it's handed on as the chunks themselves with Chain, or copied
+// into a single buffer with Entry. Like Arena, it isn't safe for concurrent
+// use.
+type AppendBuffer struct {
+	pool       *Pool
+	chunk_size uint64
+
+	// The chunks acquired so far, how much of the last one is used, and
+	// the total.
+	chunks []RBEntry
+	offset int
+	length int
+}
+
+// NewAppendBuffer is NewAppendBuffer on the default pool.
+func NewAppendBuffer(chunk_size uint64) *AppendBuffer {
+	return defaultPool.NewAppendBuffer(chunk_size)
+}
+
+// NewAppendBuffer returns an append buffer that acquires chunk_size bytes
+// at a time from the pool, or 64KiB if it's zero.
+func (pool *Pool) NewAppendBuffer(chunk_size uint64) *AppendBuffer {
+	if chunk_size == 0 {
+		chunk_size = defaultAppendChunkSize
+	}
+	return &AppendBuffer{pool: pool, chunk_size: chunk_size}
+}
+
+// Append appends p, acquiring as many chunks as it takes. If acquiring one
+// fails, what fitted in the chunks already held is kept.
+func (buf *AppendBuffer) Append(p []byte) error {
+	for len(p) > 0 {
+		if len(buf.chunks) == 0 || buf.offset == len(buf.current()) {
+			chunk, err := buf.pool.AllocBuffers([]uint64{buf.chunk_size})
+			if err != nil {
+				return err
+			}
+			buf.chunks = append(buf.chunks, chunk)
+			buf.offset = 0
+		}
+
+		copied := copy(buf.current()[buf.offset:], p)
+		buf.offset += copied
+		buf.length += copied
+		p = p[copied:]
+	}
+	return nil
+}
+
+// Write is Append, for an io.Writer.
+func (buf *AppendBuffer) Write(p []byte) (int, error) {
+	length := buf.length
+	err := buf.Append(p)
+	return buf.length - length, err
+}
+
+func (buf *AppendBuffer) current() []byte {
+	return buf.chunks[len(buf.chunks)-1].Buffers[0]
+}
+
+// Len returns how many bytes have been appended.
+func (buf *AppendBuffer) Len() int {
+	return buf.length
+}
+
+// Bytes returns what's been appended, as the used part of each chunk in
+// order. They're only valid until the buffer's next Append, Chain, Entry
+// or Release.
+func (buf *AppendBuffer) Bytes() [][]byte {
+	bytes := make([][]byte, len(buf.chunks))
+	for idx, chunk := range buf.chunks {
+		bytes[idx] = chunk.Buffers[0]
+	}
+	if len(bytes) > 0 {
+		bytes[len(bytes)-1] = buf.current()[:buf.offset]
+	}
+	return bytes
+}
+
+// Chain hands what's been appended over as a Chain of the chunks, copying
+// nothing, and leaves the buffer empty.
+func (buf *AppendBuffer) Chain() *Chain {
+	chain := &Chain{Buffers: buf.Bytes(), entries: buf.chunks}
+	buf.chunks = nil
+	buf.offset = 0
+	buf.length = 0
+	return chain
+}
+
+// Entry copies what's been appended into an entry with a single buffer
+// and leaves the append buffer empty. If that fails the append buffer is
+// left as it was.
+func (buf *AppendBuffer) Entry() (RBEntry, error) {
+	entry, err := buf.pool.AllocBuffers([]uint64{uint64(buf.length)})
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	segments := make([]copySegment, 0, len(buf.chunks))
+	offset := 0
+	for _, bytes := range buf.Bytes() {
+		segments = append(segments, copySegment{entry.Buffers[0][offset : offset+len(bytes)], bytes})
+		offset += len(bytes)
+	}
+	copySegments(segments, uint64(buf.length))
+
+	buf.Release()
+	return entry, nil
+}
+
+// Release gives every chunk back to the pool, leaving the buffer empty to
+// be appended to again.
+func (buf *AppendBuffer) Release() {
+	releaseGroup(buf.chunks)
+	buf.chunks = nil
+	buf.offset = 0
+	buf.length = 0
+}
+
+// Chain is a sequence of pooled buffers holding one run of bytes, as
+// AppendBuffer.Chain returns.
+type Chain struct {
+	Buffers [][]byte
+
+	entries []RBEntry
+}
+
+// Len returns the number of bytes in the chain.
+func (chain *Chain) Len() int {
+	length := 0
+	for _, buffer := range chain.Buffers {
+		length += len(buffer)
+	}
+	return length
+}
+
+// WriteTo writes the chain to w, with a single writev where w is a
+// connection that supports it.
+func (chain *Chain) WriteTo(w io.Writer) (int64, error) {
+	buffers := net.Buffers(append([][]byte(nil), chain.Buffers...))
+	return buffers.WriteTo(w)
+}
+
+// Release gives the chain's buffers back to the pool. Releasing a chain
+// more than once does nothing.
+func (chain *Chain) Release() {
+	releaseGroup(chain.entries)
+	chain.entries = nil
+	chain.Buffers = nil
+}
@@ -0,0 +1,72 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAppendBuffer(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc))
+	buf := pool.NewAppendBuffer(16)
+
+	if err := buf.Append([]byte("hello, ")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buf.Write([]byte("world, this spans a few chunks")); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 37 {
+		t.Fatalf("expected 37 bytes, got %d", buf.Len())
+	}
+	chunks := buf.Bytes()
+	if len(chunks) != 3 || len(chunks[0]) != 16 || len(chunks[2]) != 5 {
+		t.Fatalf("unexpected chunks %q", chunks)
+	}
+	if alloc.Stats().BytesInUse != 48 {
+		t.Fatalf("unexpected stats %+v", alloc.Stats())
+	}
+
+	entry, err := buf.Entry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(entry.Buffers[0]) != "hello, world, this spans a few chunks" {
+		t.Fatalf("unexpected entry %q", entry.Buffers[0])
+	}
+	if buf.Len() != 0 || alloc.Stats().BytesInUse != 37 {
+		t.Fatalf("expected the chunks to be released, got %+v", alloc.Stats())
+	}
+	entry.Release()
+
+	buf.Append([]byte(strings.Repeat("x", 20)))
+	chain := buf.Chain()
+	if chain.Len() != 20 || len(chain.Buffers) != 2 || buf.Len() != 0 {
+		t.Fatalf("unexpected chain %q", chain.Buffers)
+	}
+	var out bytes.Buffer
+	if n, err := chain.WriteTo(&out); err != nil || n != 20 || out.String() != strings.Repeat("x", 20) {
+		t.Fatalf("unexpected write of %d bytes %q: %v", n, out.String(), err)
+	}
+	chain.Release()
+	chain.Release()
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("expected everything to be released, got %+v", alloc.Stats())
+	}
+}
+
+func TestAppendBufferFull(t *testing.T) {
+	alloc := NewHeapAllocator(32, 32)
+	buf := NewPool(WithAllocator(alloc)).NewAppendBuffer(16)
+	defer buf.Release()
+
+	n, err := buf.Write(make([]byte, 40))
+	if !errors.Is(err, ErrNoBufferAvailable) || n != 32 || buf.Len() != 32 {
+		t.Fatalf("expected 32 bytes and ErrNoBufferAvailable, got %d: %v", n, err)
+	}
+	if _, err := buf.Entry(); !errors.Is(err, ErrNoBufferAvailable) || buf.Len() != 32 {
+		t.Fatalf("expected the buffer to be kept when Entry fails, got %d: %v", buf.Len(), err)
+	}
+}
@@ -0,0 +1,200 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// Arenas acquire memory from their pool in chunks of this size, unless
+// told otherwise.
+const defaultArenaChunkSize = 64 * 1024
+
+// Allocations from an arena are aligned to this, like the deterministic
+// allocator's.
+const arenaAlignment = deterministicAlignment
+
+// Arena hands out many small buffers carved from a few large entries and
+// gives them all back at once, either entirely with Release or back to an
+// earlier point with ReleaseTo. Unlike entries, arenas aren't safe for
+// concurrent use, and nothing stops a buffer being used after it's been
+// released, so it's up to the caller not to.
+type Arena struct {
+	pool       *Pool
+	chunk_size uint64
+
+	// The chunks acquired so far, and how much of the last one is used.
+	chunks []RBEntry
+	offset uint64
+}
+
+// ArenaMark is a point in an arena's allocations to roll back to with
+// ReleaseTo.
+type ArenaMark struct {
+	chunks int
+	offset uint64
+}
+
+// NewArena is NewArena on the default pool.
+func NewArena(chunk_size uint64) *Arena {
+	return defaultPool.NewArena(chunk_size)
+}
+
+// NewArena returns an arena that acquires chunk_size bytes at a time from
+// the pool, or 64KiB if it's zero. Allocations bigger than that get a
+// chunk to themselves.
+func (pool *Pool) NewArena(chunk_size uint64) *Arena {
+	if chunk_size == 0 {
+		chunk_size = defaultArenaChunkSize
+	}
+	return &Arena{pool: pool, chunk_size: chunk_size}
+}
+
+// Alloc returns size zeroed bytes from the arena, acquiring another chunk
+// from the pool if the current one is full.
+func (arena *Arena) Alloc(size uint64) ([]byte, error) {
+	start := (arena.offset + arenaAlignment - 1) &^ (arenaAlignment - 1)
+	if len(arena.chunks) == 0 || start < arena.offset ||
+		start > uint64(len(arena.current())) || size > uint64(len(arena.current()))-start {
+		chunk, err := arena.pool.AllocBuffers([]uint64{max(size, arena.chunk_size)})
+		if err != nil {
+			return nil, err
+		}
+		arena.chunks = append(arena.chunks, chunk)
+		start = 0
+	}
+
+	buf := arena.current()[start : start+size : start+size]
+	clear(buf)
+	arena.offset = start + size
+
+	return buf, nil
+}
+
+func (arena *Arena) current() []byte {
+	return arena.chunks[len(arena.chunks)-1].Buffers[0]
+}
+
+// Mark returns the arena's current point, for ReleaseTo.
+func (arena *Arena) Mark() ArenaMark {
+	return ArenaMark{len(arena.chunks), arena.offset}
+}
+
+// ReleaseTo gives back everything allocated since mark was taken. Chunks
+// acquired since then go back to the pool, and the rest of the space is
+// reused by later allocations. Marks taken after mark mustn't be used
+// afterwards, and rolling back to one that's past the arena's current
+// point panics.
+func (arena *Arena) ReleaseTo(mark ArenaMark) {
+	if mark.chunks > len(arena.chunks) ||
+		(mark.chunks == len(arena.chunks) && mark.offset > arena.offset) {
+		panic(fmt.Sprintf("rustybuffer: arena mark %+v is past the arena's %+v",
+			mark, arena.Mark()))
+	}
+
+	releaseGroup(arena.chunks[mark.chunks:])
+	arena.chunks = arena.chunks[:mark.chunks]
+	arena.offset = mark.offset
+}
+
+// Release gives every chunk back to the pool. The arena can still be used
+// afterwards, and starts again from nothing.
+func (arena *Arena) Release() {
+	arena.ReleaseTo(ArenaMark{})
+}
+
+// Free is Release, under the name the Go arena experiment (and code
+// written against it) uses.
+func (arena *Arena) Free() {
+	arena.Release()
+}
+
+// ArenaNew returns a zeroed T allocated from the arena, like arena.New in
+// the Go arena experiment, so code structured around arena lifetimes can
+// use pooled memory. The garbage collector can't see into pooled memory,
+// so T mustn't contain pointers, and the value mustn't be used once the
+// arena's released past it.
+func ArenaNew[T any](arena *Arena) (*T, error) {
+	var zero T
+	typ := reflect.TypeOf(&zero).Elem()
+	if err := checkArenaType(typ); err != nil {
+		return nil, err
+	}
+
+	buf, err := arena.Alloc(uint64(typ.Size()))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return &zero, nil
+	}
+	return (*T)(unsafe.Pointer(unsafe.SliceData(buf))), nil
+}
+
+// ArenaMakeSlice returns a zeroed []T of length and capacity allocated
+// from the arena, like arena.MakeSlice in the Go arena experiment, with the
+// same restrictions as ArenaNew. Appending past its capacity moves it to
+// the Go heap.
+func ArenaMakeSlice[T any](arena *Arena, length int, capacity int) ([]T, error) {
+	var zero T
+	typ := reflect.TypeOf(&zero).Elem()
+	if err := checkArenaType(typ); err != nil {
+		return nil, err
+	}
+	if length < 0 || capacity < length {
+		return nil, fmt.Errorf("rustybuffer: invalid arena slice length %d and capacity %d",
+			length, capacity)
+	}
+
+	size := uint64(typ.Size())
+	if size != 0 && uint64(capacity) > ^uint64(0)/size {
+		return nil, fmt.Errorf("rustybuffer: arena slice of %d %s is too large",
+			capacity, typ)
+	}
+
+	buf, err := arena.Alloc(size * uint64(capacity))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return make([]T, length, capacity), nil
+	}
+	return unsafe.Slice((*T)(unsafe.Pointer(unsafe.SliceData(buf))), capacity)[:length], nil
+}
+
+// checkArenaType returns an error if values of typ can't live in pooled
+// memory.
+func checkArenaType(typ reflect.Type) error {
+	if !pointerFree(typ) {
+		return fmt.Errorf("rustybuffer: %s contains pointers and can't be allocated from an arena", typ)
+	}
+	if uintptr(typ.Align()) > arenaAlignment {
+		return fmt.Errorf("rustybuffer: %s needs %d byte alignment, arenas only provide %d",
+			typ, typ.Align(), arenaAlignment)
+	}
+	return nil
+}
+
+// pointerFree reports whether values of typ hold no pointers the garbage
+// collector would need to see, i.e., whether they can safely live outside
+// the Go heap.
+func pointerFree(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Uintptr, reflect.Float32, reflect.Float64,
+		reflect.Complex64, reflect.Complex128:
+		return true
+	case reflect.Array:
+		return typ.Len() == 0 || pointerFree(typ.Elem())
+	case reflect.Struct:
+		for idx := 0; idx < typ.NumField(); idx++ {
+			if !pointerFree(typ.Field(idx).Type) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
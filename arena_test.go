@@ -0,0 +1,163 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestArena(t *testing.T) {
+	alloc := NewHeapAllocator(1<<20, 1<<20)
+	pool := NewPool(WithAllocator(alloc))
+	arena := pool.NewArena(1024)
+
+	first, err := arena.Alloc(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := arena.Alloc(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 10 || cap(first) != 10 || len(second) != 100 {
+		t.Fatalf("unexpected buffers of %d and %d bytes", len(first), len(second))
+	}
+	if uintptr(unsafe.Pointer(&second[0]))%arenaAlignment != 0 {
+		t.Fatal("allocation isn't aligned")
+	}
+	if alloc.Stats().NumBuffers != 1 {
+		t.Fatalf("expected both from one chunk: %+v", alloc.Stats())
+	}
+
+	// Another chunk once the first is full, and a chunk of its own for
+	// anything bigger than a chunk.
+	if _, err := arena.Alloc(1000); err != nil {
+		t.Fatal(err)
+	}
+	big, err := arena.Alloc(5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(big) != 5000 || alloc.Stats().BytesInUse != 1024+1024+5000 {
+		t.Fatalf("unexpected stats: %+v", alloc.Stats())
+	}
+
+	arena.Release()
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", alloc.Stats())
+	}
+
+	// Still usable after being released.
+	if _, err := arena.Alloc(10); err != nil {
+		t.Fatal(err)
+	}
+	arena.Release()
+}
+
+func TestArenaReleaseTo(t *testing.T) {
+	alloc := NewHeapAllocator(1<<20, 1<<20)
+	pool := NewPool(WithAllocator(alloc))
+	arena := pool.NewArena(256)
+	defer arena.Release()
+
+	kept, err := arena.Alloc(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(kept, "kept")
+
+	// Speculatively parse something, filling this chunk and another, then
+	// back out.
+	mark := arena.Mark()
+	speculative, err := arena.Alloc(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(speculative, bytes.Repeat([]byte{0xFF}, 100))
+	if _, err := arena.Alloc(200); err != nil {
+		t.Fatal(err)
+	}
+	inner := arena.Mark()
+	if alloc.Stats().NumBuffers != 2 {
+		t.Fatalf("unexpected stats: %+v", alloc.Stats())
+	}
+
+	arena.ReleaseTo(mark)
+	if alloc.Stats().NumBuffers != 1 {
+		t.Fatalf("expected the second chunk back: %+v", alloc.Stats())
+	}
+	if !bytes.HasPrefix(kept, []byte("kept")) {
+		t.Fatal("allocation before the mark was touched")
+	}
+
+	// The space is reused, zeroed.
+	again, err := arena.Alloc(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &again[0] != &speculative[0] || !bytes.Equal(again, make([]byte, 100)) {
+		t.Fatal("expected the rolled back space, zeroed")
+	}
+
+	defer func() {
+		if err := recover(); err == nil || !strings.Contains(err.(string), "past the arena") {
+			t.Fatalf("expected a panic about the mark, got %v", err)
+		}
+	}()
+	arena.ReleaseTo(inner)
+}
+
+func TestArenaTyped(t *testing.T) {
+	alloc := NewHeapAllocator(1<<20, 1<<20)
+	pool := NewPool(WithAllocator(alloc))
+	arena := pool.NewArena(1024)
+
+	type point struct {
+		X, Y  int64
+		Flags [4]uint8
+	}
+	p, err := ArenaNew[point](arena)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *p != (point{}) {
+		t.Fatal("value was not zeroed")
+	}
+	p.X, p.Y = 1, 2
+
+	points, err := ArenaMakeSlice[point](arena, 3, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 || cap(points) != 10 {
+		t.Fatalf("unexpected length %d and capacity %d", len(points), cap(points))
+	}
+	points[2].Y = 5
+	if p.X != 1 || p.Y != 2 {
+		t.Fatal("allocations overlap")
+	}
+	if alloc.Stats().NumBuffers != 1 {
+		t.Fatalf("expected one chunk: %+v", alloc.Stats())
+	}
+
+	empty, err := ArenaMakeSlice[struct{}](arena, 5, 5)
+	if err != nil || len(empty) != 5 {
+		t.Fatalf("unexpected empty slice %v: %v", empty, err)
+	}
+
+	if _, err := ArenaNew[*point](arena); err == nil || !strings.Contains(err.Error(), "pointers") {
+		t.Fatalf("expected an error for a pointer, got %v", err)
+	}
+	if _, err := ArenaMakeSlice[string](arena, 1, 1); err == nil {
+		t.Fatal("expected an error for strings")
+	}
+	if _, err := ArenaMakeSlice[int](arena, 2, 1); err == nil {
+		t.Fatal("expected an error for a capacity less than the length")
+	}
+
+	arena.Free()
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", alloc.Stats())
+	}
+}
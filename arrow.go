@@ -0,0 +1,51 @@
+package rustybuffer
+
+// ArrowAlignment is the buffer alignment recommended by the Arrow columnar
+// format, and the alignment used by arrow/memory.GoAllocator.
+const ArrowAlignment = 64
+
+// ArrowAllocator implements the arrow/memory.Allocator interface on top of
+// a BufferPool so that Arrow arrays and record batches live in RustyBuffers
+// memory:
+//
+//	mem := rustybuffer.NewArrowAllocator()
+//	bldr := array.NewInt64Builder(mem)
+//
+// Buffers are zeroed and aligned to ArrowAlignment bytes. Like BufferPool,
+// allocations the Rust side can't satisfy come from the Go heap.
+type ArrowAllocator struct {
+	pool *BufferPool
+}
+
+func NewArrowAllocator() *ArrowAllocator {
+	return &ArrowAllocator{pool: NewBufferPool()}
+}
+
+func (alloc *ArrowAllocator) Allocate(size int) []byte {
+	return *alloc.pool.getAligned(size, ArrowAlignment)
+}
+
+func (alloc *ArrowAllocator) Reallocate(size int, b []byte) []byte {
+	if size == len(b) {
+		return b
+	}
+
+	if size <= cap(b) {
+		// Shrinking (or growing within capacity) has to leave any bytes
+		// beyond the old length zeroed, same as a fresh allocation.
+		grown := b[:size]
+		if size > len(b) {
+			clear(grown[len(b):])
+		}
+		return grown
+	}
+
+	buf := alloc.Allocate(size)
+	copy(buf, b)
+	alloc.Free(b)
+	return buf
+}
+
+func (alloc *ArrowAllocator) Free(b []byte) {
+	alloc.pool.Put(&b)
+}
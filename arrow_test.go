@@ -0,0 +1,54 @@
+package rustybuffer
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestArrowAllocator(t *testing.T) {
+	Configure(64*1024*1024, 1024*1024)
+	mem := NewArrowAllocator()
+
+	buf := mem.Allocate(100)
+	if len(buf) != 100 {
+		t.Fatalf("expected 100 bytes, got %d", len(buf))
+	}
+	if uintptr(unsafe.Pointer(unsafe.SliceData(buf)))%ArrowAlignment != 0 {
+		t.Fatal("buffer is not 64 byte aligned")
+	}
+	if len(mem.pool.entries) != 1 {
+		t.Fatal("expected the buffer to come from the pool")
+	}
+
+	for i := range buf {
+		buf[i] = byte(i)
+	}
+
+	buf = mem.Reallocate(1000, buf)
+	if len(buf) != 1000 {
+		t.Fatalf("expected 1000 bytes, got %d", len(buf))
+	}
+	for i := 0; i < 100; i++ {
+		if buf[i] != byte(i) {
+			t.Fatalf("byte %d was not preserved", i)
+		}
+	}
+	for i := 100; i < 1000; i++ {
+		if buf[i] != 0 {
+			t.Fatalf("byte %d was not zeroed", i)
+		}
+	}
+	if len(mem.pool.entries) != 1 {
+		t.Fatal("expected the old buffer to be freed")
+	}
+
+	buf = mem.Reallocate(10, buf)
+	if len(buf) != 10 || buf[9] != 9 {
+		t.Fatal("unexpected shrink result")
+	}
+
+	mem.Free(buf)
+	if len(mem.pool.entries) != 0 {
+		t.Fatal("expected all buffers to be freed")
+	}
+}
@@ -0,0 +1,335 @@
+package rustybuffer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ArrowMessageType is the kind of an Arrow IPC message, its MessageHeader.
+type ArrowMessageType uint8
+
+const (
+	ArrowSchema          ArrowMessageType = 1
+	ArrowDictionaryBatch ArrowMessageType = 2
+	ArrowRecordBatch     ArrowMessageType = 3
+	ArrowTensor          ArrowMessageType = 4
+	ArrowSparseTensor    ArrowMessageType = 5
+)
+
+func (kind ArrowMessageType) String() string {
+	switch kind {
+	case ArrowSchema:
+		return "schema"
+	case ArrowDictionaryBatch:
+		return "dictionary batch"
+	case ArrowRecordBatch:
+		return "record batch"
+	case ArrowTensor:
+		return "tensor"
+	case ArrowSparseTensor:
+		return "sparse tensor"
+	default:
+		return fmt.Sprintf("ArrowMessageType(%d)", uint8(kind))
+	}
+}
+
+// An IPC stream is a sequence of encapsulated messages, each
+//
+//	u32  0xFFFFFFFF, the continuation marker (missing before Arrow 0.15)
+//	i32  the length of the metadata, a multiple of 8
+//	     the metadata, a Message flatbuffer, padded to its length
+//	     the body, of the Message's bodyLength
+//
+// where a zero metadata length marks the end of the stream, all little
+// endian.
+const arrowContinuation uint32 = 0xFFFFFFFF
+
+// ArrowStreamReader reads the messages of an Arrow IPC stream, e.g., one
+// received from another service, into pooled memory, so datasets can be
+// held off the Go heap from the wire onwards. It frames messages rather
+// than decoding them; hand each to arrow/ipc to do that without copying:
+//
+//	msg, err := reader.Next()
+//	...
+//	m := ipc.NewMessage(memory.NewBufferBytes(msg.Metadata),
+//		memory.NewBufferBytes(msg.Body))
+//
+// Arrow Flight's FlightData carries a message's metadata and body as its
+// data_header and data_body, so Flight streams need no framing; with a
+// gRPC BufferPool those are pooled already.
+type ArrowStreamReader struct {
+	pool *Pool
+	r    io.Reader
+}
+
+// NewArrowStreamReader returns a reader of the IPC stream r whose messages
+// are acquired from pool.
+func NewArrowStreamReader(pool *Pool, r io.Reader) *ArrowStreamReader {
+	return &ArrowStreamReader{pool: pool, r: r}
+}
+
+// ArrowMessage is a message read from an IPC stream, held in one pooled
+// entry with its body first, so the body is as aligned as the pool's
+// memory is. Metadata and Body are only valid until the message is
+// released, which must happen once nothing uses either.
+type ArrowMessage struct {
+	Type ArrowMessageType
+
+	// The Message flatbuffer, padding and all.
+	Metadata []byte
+
+	// The message body: for record and dictionary batches the column
+	// buffers, see BodyBuffers.
+	Body []byte
+
+	entry RBEntry
+}
+
+// Next reads the next message, returning io.EOF at the end of the stream.
+// A stream that's malformed fails with ErrInvalidData, and one that ends
+// part way through a message with io.ErrUnexpectedEOF.
+func (reader *ArrowStreamReader) Next() (*ArrowMessage, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(reader.r, prefix[:]); err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(prefix[:])
+	if length == arrowContinuation {
+		if _, err := io.ReadFull(reader.r, prefix[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		length = binary.LittleEndian.Uint32(prefix[:])
+	}
+	if length == 0 {
+		return nil, io.EOF
+	}
+	if length > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: arrow message metadata of %d bytes", ErrInvalidData, int32(length))
+	}
+
+	// The body's length is in the metadata, so read that onto the heap
+	// first; it's small next to the body.
+	metadata := make([]byte, length)
+	if _, err := io.ReadFull(reader.r, metadata); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	kind, body_length, err := parseArrowMessage(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := reader.pool.AllocBuffers([]uint64{body_length, uint64(length)})
+	if err != nil {
+		return nil, err
+	}
+	copy(entry.Buffers[1], metadata)
+	if _, err := io.ReadFull(reader.r, entry.Buffers[0]); err != nil {
+		entry.Release()
+		return nil, unexpectedEOF(err)
+	}
+
+	return &ArrowMessage{
+		Type:     kind,
+		Metadata: entry.Buffers[1],
+		Body:     entry.Buffers[0],
+		entry:    entry,
+	}, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// BodyBuffers returns a view of each buffer in a record or dictionary
+// batch's body, in the order the batch lists them, without copying. Like
+// the body, the views are only valid until the message is released. A
+// compressed batch's buffers are returned as they are, compressed.
+func (msg *ArrowMessage) BodyBuffers() ([]View, error) {
+	root, err := arrowMessageTable(msg.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	batch, err := root.table(2)
+	if err != nil {
+		return nil, err
+	}
+	switch msg.Type {
+	case ArrowRecordBatch:
+	case ArrowDictionaryBatch:
+		// The dictionary's values are a record batch of one column.
+		if batch, err = batch.table(1); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("rustybuffer: an arrow %v has no buffers", msg.Type)
+	}
+
+	// RecordBatch.buffers is a vector of Buffer structs, each an offset
+	// into the body and a length.
+	start, count, err := batch.vector(2, 16)
+	if err != nil {
+		return nil, err
+	}
+	body := msg.entry.View(0)
+	views := make([]View, count)
+	for idx := range views {
+		pos := start + idx*16
+		offset := binary.LittleEndian.Uint64(batch.buf[pos:])
+		length := binary.LittleEndian.Uint64(batch.buf[pos+8:])
+		if offset > uint64(body.Len()) || length > uint64(body.Len())-offset {
+			return nil, fmt.Errorf("%w: arrow buffer %d at %d of %d bytes is outside the %d byte body",
+				ErrInvalidData, idx, offset, length, body.Len())
+		}
+		views[idx] = body.Slice(int(offset), int(offset+length))
+	}
+	return views, nil
+}
+
+// Release gives the message's memory back to the pool.
+func (msg *ArrowMessage) Release() {
+	msg.entry.Release()
+	msg.Metadata = nil
+	msg.Body = nil
+}
+
+// parseArrowMessage returns the type and body length a Message flatbuffer
+// describes.
+func parseArrowMessage(metadata []byte) (ArrowMessageType, uint64, error) {
+	root, err := arrowMessageTable(metadata)
+	if err != nil {
+		return 0, 0, err
+	}
+	kind, err := root.uint8(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	body_length, err := root.uint64(3)
+	if err != nil {
+		return 0, 0, err
+	}
+	if body_length > math.MaxInt64 {
+		return 0, 0, fmt.Errorf("%w: arrow message body of %d bytes", ErrInvalidData, int64(body_length))
+	}
+	return ArrowMessageType(kind), body_length, nil
+}
+
+func arrowMessageTable(metadata []byte) (flatTable, error) {
+	if len(metadata) < 4 {
+		return flatTable{}, fmt.Errorf("%w: arrow message metadata of %d bytes", ErrInvalidData, len(metadata))
+	}
+	return flatTable{metadata, 0}.at(0)
+}
+
+// flatTable is a table in a flatbuffer, which is just enough of
+// flatbuffers to find the parts of an Arrow message this package needs.
+// Every read is bounds checked, the buffer being from the network.
+type flatTable struct {
+	buf []byte
+	pos int
+}
+
+// at returns the table that the uoffset at pos points to.
+func (table flatTable) at(pos int) (flatTable, error) {
+	target, err := table.offset(pos)
+	if err != nil {
+		return flatTable{}, err
+	}
+	if target > len(table.buf)-4 {
+		return flatTable{}, table.invalid()
+	}
+	return flatTable{table.buf, target}, nil
+}
+
+// offset follows the uoffset at pos.
+func (table flatTable) offset(pos int) (int, error) {
+	if pos < 0 || pos > len(table.buf)-4 {
+		return 0, table.invalid()
+	}
+	target := uint64(pos) + uint64(binary.LittleEndian.Uint32(table.buf[pos:]))
+	if target > uint64(len(table.buf)) {
+		return 0, table.invalid()
+	}
+	return int(target), nil
+}
+
+// field returns where the table's idx'th field is, if it's present, having
+// checked it has size bytes.
+func (table flatTable) field(idx int, size int) (int, bool, error) {
+	vtable := int64(table.pos) - int64(int32(binary.LittleEndian.Uint32(table.buf[table.pos:])))
+	if vtable < 0 || vtable > int64(len(table.buf))-4 {
+		return 0, false, table.invalid()
+	}
+	vtable_size := int(binary.LittleEndian.Uint16(table.buf[vtable:]))
+	if 4+2*idx+2 > vtable_size {
+		return 0, false, nil
+	}
+	if vtable+int64(4+2*idx+2) > int64(len(table.buf)) {
+		return 0, false, table.invalid()
+	}
+	offset := int(binary.LittleEndian.Uint16(table.buf[int(vtable)+4+2*idx:]))
+	if offset == 0 {
+		return 0, false, nil
+	}
+	if table.pos+offset > len(table.buf)-size {
+		return 0, false, table.invalid()
+	}
+	return table.pos + offset, true, nil
+}
+
+func (table flatTable) uint8(idx int) (uint8, error) {
+	pos, ok, err := table.field(idx, 1)
+	if !ok || err != nil {
+		return 0, err
+	}
+	return table.buf[pos], nil
+}
+
+func (table flatTable) uint64(idx int) (uint64, error) {
+	pos, ok, err := table.field(idx, 8)
+	if !ok || err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(table.buf[pos:]), nil
+}
+
+func (table flatTable) table(idx int) (flatTable, error) {
+	pos, ok, err := table.field(idx, 4)
+	if err != nil {
+		return flatTable{}, err
+	}
+	if !ok {
+		return flatTable{}, fmt.Errorf("%w: arrow message is missing field %d", ErrInvalidData, idx)
+	}
+	return table.at(pos)
+}
+
+// vector returns where the elements of the vector of element_size byte
+// structs in the idx'th field start and how many there are, none if the
+// field's missing.
+func (table flatTable) vector(idx int, element_size int) (int, int, error) {
+	pos, ok, err := table.field(idx, 4)
+	if !ok || err != nil {
+		return 0, 0, err
+	}
+	start, err := table.offset(pos)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start > len(table.buf)-4 {
+		return 0, 0, table.invalid()
+	}
+	count := uint64(binary.LittleEndian.Uint32(table.buf[start:]))
+	if count*uint64(element_size) > uint64(len(table.buf)-start-4) {
+		return 0, 0, table.invalid()
+	}
+	return start + 4, int(count), nil
+}
+
+func (table flatTable) invalid() error {
+	return fmt.Errorf("%w: malformed arrow message metadata", ErrInvalidData)
+}
@@ -0,0 +1,156 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// arrowRecordBatch builds the Message flatbuffer of a record batch of
+// length rows whose body holds buffers, each an offset and a length, and
+// is body_length bytes.
+func arrowRecordBatch(length int64, body_length int64, buffers [][2]int64) []byte {
+	buf := make([]byte, 80+16*len(buffers))
+	le := binary.LittleEndian
+
+	le.PutUint32(buf[0:], 16) // the Message table
+	// The Message vtable: version, header_type, header and bodyLength.
+	for idx, value := range []uint16{12, 24, 4, 6, 8, 16} {
+		le.PutUint16(buf[4+2*idx:], value)
+	}
+	le.PutUint32(buf[16:], 12)
+	le.PutUint16(buf[20:], 4) // V5
+	buf[22] = byte(ArrowRecordBatch)
+	le.PutUint32(buf[24:], 56-24)
+	le.PutUint64(buf[32:], uint64(body_length))
+
+	// The RecordBatch vtable: length, no nodes, then buffers.
+	for idx, value := range []uint16{10, 16, 8, 0, 4} {
+		le.PutUint16(buf[40+2*idx:], value)
+	}
+	le.PutUint32(buf[56:], 56-40)
+	le.PutUint32(buf[60:], 76-60)
+	le.PutUint64(buf[64:], uint64(length))
+
+	le.PutUint32(buf[76:], uint32(len(buffers)))
+	for idx, buffer := range buffers {
+		le.PutUint64(buf[80+16*idx:], uint64(buffer[0]))
+		le.PutUint64(buf[88+16*idx:], uint64(buffer[1]))
+	}
+	return buf
+}
+
+func writeArrowMessage(stream *bytes.Buffer, metadata []byte, body []byte, legacy bool) {
+	var prefix [4]byte
+	if !legacy {
+		binary.LittleEndian.PutUint32(prefix[:], arrowContinuation)
+		stream.Write(prefix[:])
+	}
+	binary.LittleEndian.PutUint32(prefix[:], uint32(len(metadata)))
+	stream.Write(prefix[:])
+	stream.Write(metadata)
+	stream.Write(body)
+}
+
+func TestArrowStreamReader(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	body := []byte("validity........values..........")
+	var stream bytes.Buffer
+	writeArrowMessage(&stream, arrowRecordBatch(4, 32, [][2]int64{{0, 8}, {16, 16}}), body, false)
+	writeArrowMessage(&stream, arrowRecordBatch(1, 8, [][2]int64{{0, 8}}), []byte("legacy.."), true)
+	stream.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+
+	reader := NewArrowStreamReader(pool, &stream)
+	msg, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != ArrowRecordBatch || !bytes.Equal(msg.Body, body) || len(msg.Metadata) != 112 {
+		t.Fatalf("unexpected %v of %d bytes: %q", msg.Type, len(msg.Metadata), msg.Body)
+	}
+	views, err := msg.BodyBuffers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(views) != 2 || views[0].CopyString() != "validity" || views[1].CopyString() != "values.........." {
+		t.Fatalf("unexpected buffers: %v", views)
+	}
+	if pool.Stats().BytesInUse != 32+112 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+	msg.Release()
+	expectPanic(t, "released", func() { views[0].Bytes() })
+
+	msg, err = reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Body) != "legacy.." {
+		t.Fatalf("unexpected body %q", msg.Body)
+	}
+	msg.Release()
+
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF after the stream, got %v", err)
+	}
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+}
+
+func TestArrowStreamReaderErrors(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	var stream bytes.Buffer
+	writeArrowMessage(&stream, arrowRecordBatch(4, 32, [][2]int64{{0, 8}}), make([]byte, 16), false)
+	if _, err := NewArrowStreamReader(pool, &stream).Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	stream.Reset()
+	writeArrowMessage(&stream, arrowRecordBatch(4, 8, [][2]int64{{4, 8}}), make([]byte, 8), false)
+	msg, err := NewArrowStreamReader(pool, &stream).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := msg.BodyBuffers(); !errors.Is(err, ErrInvalidData) {
+		t.Fatalf("expected ErrInvalidData for a buffer past the body, got %v", err)
+	}
+	msg.Release()
+
+	stream.Reset()
+	writeArrowMessage(&stream, arrowRecordBatch(0, 1<<30, nil), nil, false)
+	if _, err := NewArrowStreamReader(pool, &stream).Next(); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+
+	// Garbage metadata fails rather than panicking.
+	random := rand.New(rand.NewSource(1))
+	valid := arrowRecordBatch(4, 8, [][2]int64{{0, 8}})
+	for idx := 0; idx < 2000; idx++ {
+		metadata := append([]byte(nil), valid...)
+		for flip := 0; flip < 4; flip++ {
+			metadata[random.Intn(len(metadata))] = byte(random.Intn(256))
+		}
+		metadata = metadata[:random.Intn(len(metadata)+1)]
+
+		stream.Reset()
+		writeArrowMessage(&stream, metadata, make([]byte, 8), false)
+		msg, err := NewArrowStreamReader(pool, &stream).Next()
+		if err != nil {
+			continue
+		}
+		msg.BodyBuffers()
+		msg.Release()
+	}
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+}
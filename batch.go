@@ -0,0 +1,125 @@
+package rustybuffer
+
+import (
+	"errors"
+	"sync"
+)
+
+// Batch groups the entries acquired for one unit of work, a request say,
+// so that they share one lifetime instead of each being tracked and
+// released on its own:
+//
+//	batch := pool.NewBatch()
+//	defer batch.ReleaseAll()
+//	header, err := batch.AllocBuffers(header_sizes)
+//	...
+//	body, err := batch.AllocBuffers(body_sizes)
+//	...
+//
+// Unlike a Tx, a Batch keeps its entries until ReleaseAll however the
+// work ends. The entries are the batch's, and mustn't be released on
+// their own. Batches are safe for concurrent use.
+type Batch struct {
+	pool *Pool
+
+	mu      sync.Mutex
+	entries []RBEntry
+}
+
+// NewBatch is NewBatch on the default pool.
+func NewBatch() *Batch {
+	return defaultPool.NewBatch()
+}
+
+// NewBatch returns an empty Batch acquiring from the pool.
+func (pool *Pool) NewBatch() *Batch {
+	return &Batch{pool: pool}
+}
+
+// AllocBuffers is Pool.AllocBuffers, adding the entry to the batch.
+func (batch *Batch) AllocBuffers(sizes []uint64, opts ...AcquireOption) (RBEntry, error) {
+	// The acquire can block, so it's made without the lock.
+	entry, err := batch.pool.AllocBuffers(sizes, opts...)
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	batch.entries = append(batch.entries, entry)
+	return entry, nil
+}
+
+// Add hands entry, acquired some other way, over to the batch, to be
+// released with the rest. Released entries can't be added.
+func (batch *Batch) Add(entry RBEntry) error {
+	if entry.data == nil || (entry.state != nil && entry.state.released.Load()) {
+		return errors.New("rustybuffer: adding a released entry to a batch")
+	}
+
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	batch.entries = append(batch.entries, entry)
+	return nil
+}
+
+// Len returns the number of entries in the batch.
+func (batch *Batch) Len() int {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	return len(batch.entries)
+}
+
+// Bytes returns the number of bytes in the buffers of every entry in the
+// batch.
+func (batch *Batch) Bytes() uint64 {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	var total uint64 = 0
+	for _, entry := range batch.entries {
+		for _, buffer := range entry.Buffers {
+			total += uint64(len(buffer))
+		}
+	}
+	return total
+}
+
+// Entry returns the idx'th entry in the batch, in the order they were
+// added.
+func (batch *Batch) Entry(idx int) RBEntry {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	return batch.entries[idx]
+}
+
+// Entries returns an iterator over the batch's entries, with their index,
+// in the order they were added, as of when it's called.
+func (batch *Batch) Entries() func(yield func(int, RBEntry) bool) {
+	batch.mu.Lock()
+	entries := append([]RBEntry(nil), batch.entries...)
+	batch.mu.Unlock()
+
+	return func(yield func(int, RBEntry) bool) {
+		for idx, entry := range entries {
+			if !yield(idx, entry) {
+				return
+			}
+		}
+	}
+}
+
+// ReleaseAll releases every entry in the batch, newest first, leaving it
+// empty and ready for reuse, so it can always be deferred.
+func (batch *Batch) ReleaseAll() {
+	batch.mu.Lock()
+	entries := batch.entries
+	batch.entries = nil
+	batch.mu.Unlock()
+
+	releaseGroup(entries)
+}
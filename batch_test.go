@@ -0,0 +1,54 @@
+package rustybuffer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc))
+
+	batch := pool.NewBatch()
+	defer batch.ReleaseAll()
+
+	first, err := batch.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := batch.AllocBuffers([]uint64{2048}); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+	added, err := pool.AllocBuffers([]uint64{200, 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Add(added); err != nil {
+		t.Fatal(err)
+	}
+
+	if second := batch.Entry(1); batch.Len() != 2 || batch.Bytes() != 350 || second.Handle() != added.Handle() {
+		t.Fatalf("unexpected batch of %d entries, %d bytes", batch.Len(), batch.Bytes())
+	}
+	var handles []Handle
+	batch.Entries()(func(idx int, entry RBEntry) bool {
+		handles = append(handles, entry.Handle())
+		return true
+	})
+	if len(handles) != 2 || handles[0] != first.Handle() || handles[1] != added.Handle() {
+		t.Fatalf("unexpected entries %v", handles)
+	}
+
+	batch.ReleaseAll()
+	if alloc.Stats().BytesInUse != 0 || batch.Len() != 0 || batch.Bytes() != 0 {
+		t.Fatalf("unexpected stats: %+v", alloc.Stats())
+	}
+	if err := batch.Add(added); err == nil {
+		t.Fatal("expected adding a released entry to fail")
+	}
+
+	// An emptied batch can be used again.
+	if _, err := batch.AllocBuffers([]uint64{10}); err != nil || alloc.Stats().BytesInUse != 10 {
+		t.Fatalf("unexpected reuse %v, %+v", err, alloc.Stats())
+	}
+}
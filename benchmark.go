@@ -0,0 +1,240 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Workload describes what Benchmark does with each backend. Every
+// goroutine cycles through Sizes, acquiring a buffer of each size, and
+// keeps the Hold most recent buffers alive, releasing the oldest as it
+// goes, until Iterations acquires have been made between them.
+type Workload struct {
+	Sizes      []uint64
+	Iterations int
+	Goroutines int
+	Hold       int
+
+	// Touch writes a byte to every page of each buffer, as a real user
+	// would, so that lazily mapped memory gets paid for.
+	Touch bool
+}
+
+// BenchmarkResult is how one backend did.
+type BenchmarkResult struct {
+	Name     string
+	Acquires int
+	Failures int
+	Elapsed  time.Duration
+
+	// The garbage collections during the run and how long they paused
+	// the program for in total.
+	NumGC      uint32
+	PauseTotal time.Duration
+
+	// Bytes allocated on the Go heap during the run.
+	HeapAlloc uint64
+}
+
+func (res BenchmarkResult) NsPerAcquire() float64 {
+	if res.Acquires == 0 {
+		return 0
+	}
+	return float64(res.Elapsed.Nanoseconds()) / float64(res.Acquires)
+}
+
+type BenchmarkReport struct {
+	Workload Workload
+	Results  []BenchmarkResult
+}
+
+// WriteTo writes the report as a table, fastest first, relative to
+// make([]byte, n).
+func (report BenchmarkReport) WriteTo(w io.Writer) (int64, error) {
+	results := append([]BenchmarkResult(nil), report.Results...)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].NsPerAcquire() < results[j].NsPerAcquire()
+	})
+
+	var baseline float64 = 0
+	for _, res := range results {
+		if res.Name == benchmarkBaseline {
+			baseline = res.NsPerAcquire()
+		}
+	}
+
+	counter := &countingWriter{w: w}
+	table := tabwriter.NewWriter(counter, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "backend\tns/acquire\tvs make\tfailures\tGCs\tGC pause\theap alloc")
+	for _, res := range results {
+		relative := "-"
+		if baseline > 0 {
+			relative = fmt.Sprintf("%.2fx", res.NsPerAcquire()/baseline)
+		}
+		fmt.Fprintf(table, "%s\t%.0f\t%s\t%d\t%d\t%v\t%d\n",
+			res.Name, res.NsPerAcquire(), relative, res.Failures,
+			res.NumGC, res.PauseTotal, res.HeapAlloc)
+	}
+	err := table.Flush()
+
+	return counter.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (counter *countingWriter) Write(data []byte) (int, error) {
+	n, err := counter.w.Write(data)
+	counter.n += int64(n)
+	return n, err
+}
+
+// The name of the make([]byte, n) backend every report includes.
+const benchmarkBaseline = "make"
+
+var benchmarkBackends struct {
+	mu    sync.Mutex
+	pools map[string]*Pool
+}
+
+// RegisterBenchmarkBackend adds pool to every Benchmark run under name.
+func RegisterBenchmarkBackend(name string, pool *Pool) {
+	benchmarkBackends.mu.Lock()
+	defer benchmarkBackends.mu.Unlock()
+
+	if benchmarkBackends.pools == nil {
+		benchmarkBackends.pools = make(map[string]*Pool)
+	}
+	benchmarkBackends.pools[name] = pool
+}
+
+// Benchmark runs workload against pool, plain make([]byte, n) and every
+// registered backend in turn and reports how each did, including the
+// garbage collection it caused.
+func Benchmark(pool *Pool, workload Workload) BenchmarkReport {
+	report := BenchmarkReport{Workload: workload}
+
+	acquire := func(pool *Pool) func(size uint64) ([]byte, func(), error) {
+		return func(size uint64) ([]byte, func(), error) {
+			entry, err := pool.AllocBuffers([]uint64{size})
+			if err != nil {
+				return nil, nil, err
+			}
+			return entry.Buffers[0], entry.Release, nil
+		}
+	}
+
+	report.Results = append(report.Results,
+		runBenchmark("pool", workload, acquire(pool)))
+	report.Results = append(report.Results,
+		runBenchmark(benchmarkBaseline, workload, func(size uint64) ([]byte, func(), error) {
+			return make([]byte, size), func() {}, nil
+		}))
+
+	benchmarkBackends.mu.Lock()
+	names := make([]string, 0, len(benchmarkBackends.pools))
+	for name := range benchmarkBackends.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pools := make([]*Pool, len(names))
+	for idx, name := range names {
+		pools[idx] = benchmarkBackends.pools[name]
+	}
+	benchmarkBackends.mu.Unlock()
+
+	for idx, name := range names {
+		report.Results = append(report.Results,
+			runBenchmark(name, workload, acquire(pools[idx])))
+	}
+
+	return report
+}
+
+func runBenchmark(
+	name string,
+	workload Workload,
+	acquire func(size uint64) ([]byte, func(), error),
+) BenchmarkResult {
+	goroutines := max(workload.Goroutines, 1)
+	hold := max(workload.Hold, 1)
+	sizes := workload.Sizes
+	if len(sizes) == 0 {
+		sizes = []uint64{4096}
+	}
+
+	failures := make([]int, goroutines)
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < goroutines; worker++ {
+		iterations := workload.Iterations / goroutines
+		if worker < workload.Iterations%goroutines {
+			iterations++
+		}
+
+		wg.Add(1)
+		go func(worker int, iterations int) {
+			defer wg.Done()
+
+			held := make([]func(), hold)
+			failed := 0
+			for iter := 0; iter < iterations; iter++ {
+				slot := iter % hold
+				if held[slot] != nil {
+					held[slot]()
+					held[slot] = nil
+				}
+
+				buf, release, err := acquire(sizes[(worker+iter)%len(sizes)])
+				if err != nil {
+					failed++
+					continue
+				}
+				if workload.Touch {
+					for idx := 0; idx < len(buf); idx += 4096 {
+						buf[idx] = 1
+					}
+				}
+				held[slot] = release
+			}
+
+			for _, release := range held {
+				if release != nil {
+					release()
+				}
+			}
+			failures[worker] = failed
+		}(worker, iterations)
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+
+	res := BenchmarkResult{
+		Name:       name,
+		Acquires:   workload.Iterations,
+		Elapsed:    elapsed,
+		NumGC:      after.NumGC - before.NumGC,
+		PauseTotal: time.Duration(after.PauseTotalNs - before.PauseTotalNs),
+		HeapAlloc:  after.TotalAlloc - before.TotalAlloc,
+	}
+	for _, failed := range failures {
+		res.Failures += failed
+	}
+
+	return res
+}
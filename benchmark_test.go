@@ -0,0 +1,47 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBenchmark(t *testing.T) {
+	RegisterBenchmarkBackend("heap", NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<16))))
+
+	pool := NewPool(WithAllocator(NewMallocAllocator(1<<20, 1<<16)))
+	workload := Workload{
+		Sizes:      []uint64{100, 5000, 1 << 16, 1 << 17},
+		Iterations: 1000,
+		Goroutines: 3,
+		Hold:       4,
+		Touch:      true,
+	}
+
+	report := Benchmark(pool, workload)
+	if len(report.Results) != 3 {
+		t.Fatalf("expected 3 results, got %+v", report.Results)
+	}
+	for _, res := range report.Results {
+		if res.Acquires != 1000 {
+			t.Fatalf("unexpected result: %+v", res)
+		}
+		// The 128KiB buffers are too large for the pools.
+		if (res.Name == benchmarkBaseline) != (res.Failures == 0) {
+			t.Fatalf("unexpected failures: %+v", res)
+		}
+	}
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+
+	var out bytes.Buffer
+	if _, err := report.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"pool", "make", "heap", "1.00x"} {
+		if !strings.Contains(out.String(), name) {
+			t.Fatalf("%q missing from report:\n%s", name, out.String())
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// BroadcastView is one consumer's share of an entry handed out by
+// Broadcast, with its own Release. Views are used through the pointers
+// Broadcast returns, so each consumer's release is counted once.
+type BroadcastView struct {
+	group    *broadcastGroup
+	released atomic.Bool
+}
+
+// broadcastGroup is what the views of one Broadcast share.
+type broadcastGroup struct {
+	handle    Handle
+	buffers   [][]byte
+	remaining atomic.Int64
+	unpin     func()
+}
+
+// Broadcast hands entry out to n consumers, a pub/sub server's
+// connections say, as n views that each consumer releases when it's done
+// with, in any order and from any goroutine, without them having to agree
+// on who releases the entry: its memory goes back to the pool exactly
+// when the last view is released, and with n zero, at once.
+//
+// Broadcast takes the entry over. It and every copy of it are released
+// (see RBEntry.Pin for how the memory's held on to), so nothing can write
+// to the payload while the consumers read it, and the views are read
+// only by convention: their buffers are the entry's, and mustn't be
+// written to. The views of a large fan-out are allocated together, so
+// broadcasting to thousands of consumers costs two allocations, not
+// thousands.
+func Broadcast(entry RBEntry, n int) ([]*BroadcastView, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("rustybuffer: broadcast to %d consumers", n)
+	}
+	if entry.state == nil || entry.data == nil || entry.state.released.Load() {
+		return nil, errors.New("rustybuffer: broadcast of a released entry")
+	}
+	if n == 0 {
+		entry.Release()
+		return nil, nil
+	}
+
+	_, unpin := entry.Pin()
+	group := &broadcastGroup{handle: entry.state.handle, buffers: entry.Buffers, unpin: unpin}
+	group.remaining.Store(int64(n))
+	entry.Release()
+
+	views := make([]BroadcastView, n)
+	pointers := make([]*BroadcastView, n)
+	for idx := range views {
+		views[idx].group = group
+		pointers[idx] = &views[idx]
+	}
+	return pointers, nil
+}
+
+// check panics if the view has been released.
+func (view *BroadcastView) check() {
+	if view.released.Load() {
+		panic("rustybuffer: use of a released broadcast view")
+	}
+}
+
+// Handle returns the broadcast entry's handle.
+func (view *BroadcastView) Handle() Handle {
+	return view.group.handle
+}
+
+// Buffers returns the entry's buffers, which every view shares, so
+// neither the slice nor the memory may be modified. They mustn't be used
+// once the view is released.
+func (view *BroadcastView) Buffers() [][]byte {
+	view.check()
+	return view.group.buffers
+}
+
+// View returns a view of the entry's idx'th buffer, valid until this view
+// is released, as the entry itself already is.
+func (view *BroadcastView) View(idx int) View {
+	view.check()
+	return ViewOf(view.group.buffers[idx])
+}
+
+// Len returns the number of bytes in the entry's buffers.
+func (view *BroadcastView) Len() int {
+	length := 0
+	for _, buffer := range view.Buffers() {
+		length += len(buffer)
+	}
+	return length
+}
+
+// WriteTo writes the entry's buffers to w, with a single writev where w
+// is a connection that supports it.
+func (view *BroadcastView) WriteTo(w io.Writer) (int64, error) {
+	buffers := net.Buffers(append([][]byte(nil), view.Buffers()...))
+	return buffers.WriteTo(w)
+}
+
+// Release ends this consumer's use of the entry, giving its memory back
+// to the pool if it's the last. Releasing a view more than once does
+// nothing.
+func (view *BroadcastView) Release() {
+	if !view.released.CompareAndSwap(false, true) {
+		return
+	}
+	if view.group.remaining.Add(-1) == 0 {
+		view.group.unpin()
+	}
+}
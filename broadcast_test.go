@@ -0,0 +1,101 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestBroadcast(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc))
+	entry, err := pool.AllocBuffers([]uint64{5, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(entry.Buffers[0], "hello")
+	copy(entry.Buffers[1], "!!!")
+	copied := entry
+
+	views, err := Broadcast(entry, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(views) != 3 || views[1].Handle() != entry.Handle() || views[2].Len() != 8 {
+		t.Fatalf("unexpected views %v", views)
+	}
+
+	// The producer's copies are released, the memory isn't.
+	copied.Release()
+	if stats := alloc.Stats(); stats.BytesInUse != 8 {
+		t.Fatalf("expected the memory to be held, got %+v", stats)
+	}
+
+	var out bytes.Buffer
+	if _, err := views[0].WriteTo(&out); err != nil || out.String() != "hello!!!" {
+		t.Fatalf("unexpected write %q (%v)", out.String(), err)
+	}
+	if view := views[1].View(1); view.CopyString() != "!!!" {
+		t.Fatalf("unexpected view %q", view.CopyString())
+	}
+
+	views[2].Release()
+	views[0].Release()
+	views[0].Release()
+	if stats := alloc.Stats(); stats.BytesInUse != 8 {
+		t.Fatalf("expected the memory to be held by the last view, got %+v", stats)
+	}
+	expectPanic(t, "released broadcast view", func() { views[0].Buffers() })
+
+	views[1].Release()
+	if stats := alloc.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("expected the memory to be released with the last view, got %+v", stats)
+	}
+}
+
+func TestBroadcastConcurrent(t *testing.T) {
+	alloc := NewHeapAllocator(1<<20, 1<<20)
+	entry, err := NewPool(WithAllocator(alloc)).AllocBuffers([]uint64{4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	views, err := Broadcast(entry, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, view := range views {
+		wg.Add(1)
+		go func(view *BroadcastView) {
+			defer wg.Done()
+			if view.Len() != 4096 {
+				t.Error("unexpected length")
+			}
+			view.Release()
+		}(view)
+	}
+	wg.Wait()
+	if stats := alloc.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("expected the memory to be released, got %+v", stats)
+	}
+}
+
+func TestBroadcastEdges(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc))
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Broadcast(entry, -1); err == nil {
+		t.Fatal("expected a negative fan-out to fail")
+	}
+	if views, err := Broadcast(entry, 0); err != nil || views != nil || alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("expected no consumers to release the entry, got %v (%v)", views, err)
+	}
+	if _, err := Broadcast(entry, 2); err == nil {
+		t.Fatal("expected broadcasting a released entry to fail")
+	}
+}
@@ -0,0 +1,342 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// A host budget lives in a small shared segment laid out as follows, in
+// the host's byte order with every u64 accessed atomically:
+//
+//	0    u64  magic, 0x5242425544470001 ("RBBUDG" and version 1), stored
+//	          last when the budget is created
+//	8    u64  the limit in bytes
+//	16   u64  bytes in use, by every process
+//	64   the process slots, one per process using the budget:
+//	       u64  pid, or zero if the slot is free
+//	       u64  bytes in use
+//	       u64  buffers in use
+//	       u64  acquires refused for want of budget
+//
+// Acquires charge the total with a compare and swap that never takes it
+// past the limit, so no lock is needed. Each process only updates its own
+// slot, apart from taking over the slots of processes that have died. On
+// Linux a process holds a lock on the first byte of its slot, see
+// lockSlot, for as long as it's using it.
+const (
+	budgetMagic     uint64 = 0x52424255_44470001 // "RBBUDG" then 0x0001
+	budgetSlots            = 64
+	budgetSlot             = 32
+	budgetProcesses        = 256
+	budgetSize             = budgetSlots + budgetProcesses*budgetSlot
+)
+
+// HostBudget is a limit on the bytes every process on the host that opens
+// it can have acquired at once, across all of their pools, so that
+// together they stay within what the host can spare rather than each up
+// to a limit of its own and the host swapping. Pools are charged to it
+// with its Interceptor. A HostBudget is safe for concurrent use.
+//
+// The memory of a process that dies is given back to the budget the next
+// time another process runs short. On Linux that's found from a lock the
+// kernel drops when the process dies, so it works between processes in
+// different PID namespaces, containers sharing /dev/shm say. Elsewhere
+// it's found from the process's pid, which only works between processes
+// that can see each other's. Up to 256 processes can share a budget, or
+// use it through separate mappings.
+type HostBudget struct {
+	mapping []byte
+	slot    uint64
+
+	// The budget's file, held open for the lock on this mapping's slot.
+	file *os.File
+}
+
+// budgetSlotEntry is a process's slot in the budget.
+type budgetSlotEntry struct {
+	pid     uint64
+	bytes   uint64
+	buffers uint64
+	refused uint64
+}
+
+// OpenHostBudget opens the budget at path, e.g., under /dev/shm, or
+// creates one of limit bytes if there isn't one, and takes a slot in it
+// for this process. limit is ignored for an existing budget. Two processes
+// creating the same budget at once can see the other's half made budget
+// and fail to open it.
+func OpenHostBudget(path string, limit uint64) (*HostBudget, error) {
+	mapping, file, err := mapSegmentFile(path, 0, false)
+	if errors.Is(err, fs.ErrNotExist) {
+		mapping, file, err = mapSegmentFile(path, budgetSize, true)
+		if err == nil {
+			atomic.StoreUint64((*uint64)(unsafe.Pointer(&mapping[8])), limit)
+			atomic.StoreUint64((*uint64)(unsafe.Pointer(&mapping[0])), budgetMagic)
+		} else if errors.Is(err, fs.ErrExist) {
+			mapping, file, err = mapSegmentFile(path, 0, false)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// No slot, until it's claimed one.
+	budget := &HostBudget{mapping: mapping, slot: budgetProcesses, file: file}
+	fail := func(err error) (*HostBudget, error) {
+		unmapSegment(mapping)
+		file.Close()
+		return nil, err
+	}
+	if len(mapping) != budgetSize {
+		return fail(fmt.Errorf("rustybuffer: %s isn't a host budget: %d bytes, expected %d",
+			path, len(mapping), budgetSize))
+	}
+	if magic := atomic.LoadUint64(budget.word(0)); magic != budgetMagic {
+		return fail(fmt.Errorf("rustybuffer: %s isn't a host budget: magic %#x, expected %#x",
+			path, magic, budgetMagic))
+	}
+
+	slot, ok := budget.claim()
+	if !ok {
+		return fail(fmt.Errorf("rustybuffer: host budget %s has no free process slots", path))
+	}
+	budget.slot = slot
+
+	return budget, nil
+}
+
+// Close gives back whatever this mapping still has charged to the budget
+// and its slot, then unmaps the budget. Allocators charged to it mustn't
+// be used afterwards.
+func (budget *HostBudget) Close() error {
+	budget.free(budget.entry(budget.slot))
+	budget.file.Close()
+	return unmapSegment(budget.mapping)
+}
+
+// Limit is the most every process together can have acquired.
+func (budget *HostBudget) Limit() uint64 {
+	return atomic.LoadUint64(budget.word(8))
+}
+
+func (budget *HostBudget) word(offset uint64) *uint64 {
+	return (*uint64)(unsafe.Pointer(&budget.mapping[offset]))
+}
+
+func (budget *HostBudget) entry(slot uint64) *budgetSlotEntry {
+	return (*budgetSlotEntry)(unsafe.Pointer(&budget.mapping[budgetSlotOffset(slot)]))
+}
+
+func budgetSlotOffset(slot uint64) int64 {
+	return int64(budgetSlots + slot*budgetSlot)
+}
+
+// claim takes a free slot for this process, or one whose process has died.
+// The slot is locked before it's taken, so no process ever sees it taken
+// and unlocked unless its process has died.
+func (budget *HostBudget) claim() (uint64, bool) {
+	pid := uint64(os.Getpid())
+	for pass := 0; pass < 2; pass++ {
+		for slot := uint64(0); slot < budgetProcesses; slot++ {
+			entry := budget.entry(slot)
+			if atomic.LoadUint64(&entry.pid) != 0 {
+				continue
+			}
+			locked := lockSlot(budget.file, budgetSlotOffset(slot))
+			if atomic.CompareAndSwapUint64(&entry.pid, 0, pid) {
+				return slot, true
+			}
+			if locked {
+				unlockSlot(budget.file, budgetSlotOffset(slot))
+			}
+		}
+		budget.reap()
+	}
+	return 0, false
+}
+
+// alive reports whether the process using slot is running. The mapping's
+// own slot can't be checked with its lock, which it holds itself.
+func (budget *HostBudget) alive(slot uint64, pid uint64) bool {
+	if slot == budget.slot {
+		return true
+	}
+	if locked, ok := slotLocked(budget.file, budgetSlotOffset(slot)); ok {
+		return locked
+	}
+	return processAlive(int(pid))
+}
+
+// reap gives back the memory of processes that have died, returning
+// whether there was any.
+func (budget *HostBudget) reap() bool {
+	reaped := false
+	for slot := uint64(0); slot < budgetProcesses; slot++ {
+		entry := budget.entry(slot)
+		pid := atomic.LoadUint64(&entry.pid)
+		if pid == 0 || pid == ^uint64(0) || budget.alive(slot, pid) {
+			continue
+		}
+		// Whoever takes the slot away from the dead process frees it.
+		if atomic.CompareAndSwapUint64(&entry.pid, pid, ^uint64(0)) {
+			budget.free(entry)
+			reaped = true
+		}
+	}
+	return reaped
+}
+
+// free gives back everything charged to entry and frees the slot.
+func (budget *HostBudget) free(entry *budgetSlotEntry) {
+	bytes := atomic.SwapUint64(&entry.bytes, 0)
+	atomic.StoreUint64(&entry.buffers, 0)
+	atomic.StoreUint64(&entry.refused, 0)
+	subtractSaturating(budget.word(16), bytes)
+	atomic.StoreUint64(&entry.pid, 0)
+}
+
+// subtractSaturating takes delta from *word, stopping at zero rather than
+// wrapping, as a process whose slot was taken away from it while it was
+// still running gives back bytes the budget has already been given.
+func subtractSaturating(word *uint64, delta uint64) {
+	for {
+		value := atomic.LoadUint64(word)
+		if atomic.CompareAndSwapUint64(word, value, value-min(value, delta)) {
+			return
+		}
+	}
+}
+
+// charge takes size bytes from the budget if they fit.
+func (budget *HostBudget) charge(size uint64) error {
+	total := budget.word(16)
+	limit := budget.Limit()
+	entry := budget.entry(budget.slot)
+	for reaped := false; ; {
+		in_use := atomic.LoadUint64(total)
+		if in_use+size < in_use || in_use+size > limit {
+			if !reaped && budget.reap() {
+				reaped = true
+				continue
+			}
+			atomic.AddUint64(&entry.refused, 1)
+			return newError(codeNoBufferAvailable,
+				"requested %d bytes with %d of the host budget %d in use", size, in_use, limit)
+		}
+		if atomic.CompareAndSwapUint64(total, in_use, in_use+size) {
+			break
+		}
+	}
+	atomic.AddUint64(&entry.bytes, size)
+	atomic.AddUint64(&entry.buffers, 1)
+	return nil
+}
+
+// uncharge gives size bytes back to the budget.
+func (budget *HostBudget) uncharge(size uint64) {
+	entry := budget.entry(budget.slot)
+	subtractSaturating(&entry.bytes, size)
+	subtractSaturating(&entry.buffers, 1)
+	subtractSaturating(budget.word(16), size)
+}
+
+// HostBudgetStats describes a host budget and each process using it.
+type HostBudgetStats struct {
+	Limit      uint64
+	BytesInUse uint64
+	Processes  []ProcessBudgetStats
+}
+
+// ProcessBudgetStats describes one process's use of a host budget.
+type ProcessBudgetStats struct {
+	Pid        int
+	BytesInUse uint64
+	NumBuffers uint64
+
+	// Acquires refused because the budget was spent.
+	NumRefused uint64
+}
+
+// Stats describes the budget as every process sees it. A process that
+// opened the budget more than once is listed once per mapping.
+func (budget *HostBudget) Stats() HostBudgetStats {
+	stats := HostBudgetStats{
+		Limit:      budget.Limit(),
+		BytesInUse: atomic.LoadUint64(budget.word(16)),
+	}
+	for slot := uint64(0); slot < budgetProcesses; slot++ {
+		entry := budget.entry(slot)
+		pid := atomic.LoadUint64(&entry.pid)
+		if pid == 0 || pid == ^uint64(0) {
+			continue
+		}
+		stats.Processes = append(stats.Processes, ProcessBudgetStats{
+			Pid:        int(pid),
+			BytesInUse: atomic.LoadUint64(&entry.bytes),
+			NumBuffers: atomic.LoadUint64(&entry.buffers),
+			NumRefused: atomic.LoadUint64(&entry.refused),
+		})
+	}
+	return stats
+}
+
+// Interceptor charges every acquire from a pool to the budget, failing it
+// with ErrNoBufferAvailable if the budget is spent, see Pool.Use.
+func (budget *HostBudget) Interceptor() Interceptor {
+	return func(next Allocator) Allocator {
+		return &budgetAllocator{
+			Allocator: next,
+			budget:    budget,
+			sizes:     make(map[unsafe.Pointer]uint64),
+		}
+	}
+}
+
+// budgetAllocator charges acquires from the allocator it wraps to a host
+// budget.
+type budgetAllocator struct {
+	Allocator
+	budget *HostBudget
+
+	mu    sync.Mutex
+	sizes map[unsafe.Pointer]uint64
+}
+
+func (alloc *budgetAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	if err := alloc.budget.charge(size); err != nil {
+		return nil, err
+	}
+
+	data, err := alloc.Allocator.Acquire(size)
+	if err != nil {
+		alloc.budget.uncharge(size)
+		return nil, err
+	}
+
+	alloc.mu.Lock()
+	alloc.sizes[data] = size
+	alloc.mu.Unlock()
+
+	return data, nil
+}
+
+func (alloc *budgetAllocator) Release(data unsafe.Pointer) error {
+	if err := alloc.Allocator.Release(data); err != nil {
+		return err
+	}
+
+	alloc.mu.Lock()
+	size, ok := alloc.sizes[data]
+	delete(alloc.sizes, data)
+	alloc.mu.Unlock()
+
+	if ok {
+		alloc.budget.uncharge(size)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+//go:build unix
+
+package rustybuffer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHostBudget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget")
+	budget, err := OpenHostBudget(path, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer budget.Close()
+
+	// Another process, as far as the budget can tell.
+	other, err := OpenHostBudget(path, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if other.Limit() != 1000 {
+		t.Fatalf("reopening changed the limit to %d", other.Limit())
+	}
+
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+	pool.Use(budget.Interceptor())
+	other_pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+	other_pool.Use(other.Interceptor())
+
+	entry, err := pool.AllocBuffers([]uint64{600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other_pool.AllocBuffers([]uint64{500}); !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+	other_entry, err := other_pool.AllocBuffers([]uint64{400})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := budget.Stats()
+	if stats.Limit != 1000 || stats.BytesInUse != 1000 || len(stats.Processes) != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	expected := []ProcessBudgetStats{
+		{Pid: os.Getpid(), BytesInUse: 600, NumBuffers: 1},
+		{Pid: os.Getpid(), BytesInUse: 400, NumBuffers: 1, NumRefused: 1},
+	}
+	for idx, process := range stats.Processes {
+		if process != expected[idx] {
+			t.Fatalf("expected %+v, got %+v", expected[idx], process)
+		}
+	}
+
+	entry.Release()
+	other_entry.Release()
+	if stats := other.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("releasing didn't give the budget back: %+v", stats)
+	}
+}
+
+func TestHostBudgetDeadProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget")
+	budget, err := OpenHostBudget(path, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer budget.Close()
+
+	// A process that died holding most of the budget.
+	other, err := OpenHostBudget(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.charge(900); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreUint64(&other.entry(other.slot).pid, uint64(deadPid(t)))
+	unmapSegment(other.mapping)
+	other.file.Close()
+
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+	pool.Use(budget.Interceptor())
+	entry, err := pool.AllocBuffers([]uint64{500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	if stats := budget.Stats(); stats.BytesInUse != 500 || len(stats.Processes) != 1 {
+		t.Fatalf("the dead process's memory wasn't given back: %+v", stats)
+	}
+}
+
+func TestHostBudgetOtherNamespace(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("slots are only locked on Linux")
+	}
+
+	path := filepath.Join(t.TempDir(), "budget")
+	budget, err := OpenHostBudget(path, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer budget.Close()
+
+	// A running process whose pid means nothing here, as one in another
+	// PID namespace would be.
+	other, err := OpenHostBudget(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := other.charge(900); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreUint64(&other.entry(other.slot).pid, uint64(deadPid(t)))
+
+	if err := budget.charge(500); !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+	if stats := budget.Stats(); stats.BytesInUse != 900 || len(stats.Processes) != 2 {
+		t.Fatalf("a running process's memory was given back: %+v", stats)
+	}
+}
+
+func TestHostBudgetUnchargeSaturates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget")
+	budget, err := OpenHostBudget(path, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer budget.Close()
+
+	if err := budget.charge(100); err != nil {
+		t.Fatal(err)
+	}
+	// As if the slot had been taken away and its bytes given back.
+	budget.free(budget.entry(budget.slot))
+	atomic.StoreUint64(&budget.entry(budget.slot).pid, uint64(os.Getpid()))
+	budget.uncharge(100)
+
+	if stats := budget.Stats(); stats.BytesInUse != 0 || stats.Processes[0].BytesInUse != 0 ||
+		stats.Processes[0].NumBuffers != 0 {
+		t.Fatalf("uncharging wrapped around: %+v", stats)
+	}
+}
@@ -0,0 +1,264 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Buffer locks are kept in stripes chosen by the entry's address, so an
+// entry's buffers share a stripe and locking one entry's buffers doesn't
+// contend with another's unless they happen to share one.
+const bufferLockStripes = 64
+
+type bufferLockKey struct {
+	data   unsafe.Pointer
+	buffer int
+}
+
+// bufferLock is the state of one buffer's lock, which only exists while
+// it's held or waited for.
+type bufferLock struct {
+	handle          Handle
+	readers         int
+	writer          bool
+	waiting_readers int
+	waiting_writers int
+	since           time.Time
+}
+
+type bufferLockStripe struct {
+	mu    sync.Mutex
+	cond  sync.Cond
+	locks map[bufferLockKey]*bufferLock
+}
+
+type bufferLockTable struct {
+	stripes [bufferLockStripes]bufferLockStripe
+}
+
+func newBufferLockTable() *bufferLockTable {
+	table := &bufferLockTable{}
+	for idx := range table.stripes {
+		stripe := &table.stripes[idx]
+		stripe.cond.L = &stripe.mu
+		stripe.locks = make(map[bufferLockKey]*bufferLock)
+	}
+	return table
+}
+
+func (table *bufferLockTable) stripe(data unsafe.Pointer) *bufferLockStripe {
+	// The low bits of an address are mostly alignment.
+	return &table.stripes[(uintptr(data)>>6)%bufferLockStripes]
+}
+
+// bufferLocks returns the pool's lock table, creating it the first time a
+// buffer is locked.
+func (pool *Pool) bufferLocks() *bufferLockTable {
+	if table := pool.buffer_locks.Load(); table != nil {
+		return table
+	}
+	pool.buffer_locks.CompareAndSwap(nil, newBufferLockTable())
+	return pool.buffer_locks.Load()
+}
+
+// lockKey checks the entry's buffer can be locked, returning its lock's
+// stripe and key.
+func (entry *RBEntry) lockKey(buffer int) (*bufferLockStripe, bufferLockKey) {
+	if entry.data == nil || entry.state == nil || entry.state.released.Load() {
+		panic("rustybuffer: lock of a released entry")
+	}
+	if buffer < 0 || buffer >= len(entry.Buffers) {
+		panic(fmt.Sprintf("rustybuffer: lock of buffer %d of an entry with %d", buffer, len(entry.Buffers)))
+	}
+	key := bufferLockKey{entry.data, buffer}
+	return entry.state.pool.bufferLocks().stripe(entry.data), key
+}
+
+func (stripe *bufferLockStripe) lock(key bufferLockKey, handle Handle) *bufferLock {
+	lock := stripe.locks[key]
+	if lock == nil {
+		lock = &bufferLock{handle: handle}
+		stripe.locks[key] = lock
+	}
+	return lock
+}
+
+// forget drops a lock that's no longer held or waited for.
+func (stripe *bufferLockStripe) forget(key bufferLockKey, lock *bufferLock) {
+	if lock.readers == 0 && !lock.writer && lock.waiting_readers == 0 && lock.waiting_writers == 0 {
+		delete(stripe.locks, key)
+	}
+}
+
+// RLock locks the entry's buffer-th buffer for reading, waiting while it
+// has a writer or one is waiting, so readers can't starve a writer. Like
+// sync.RWMutex, it mustn't be locked for reading twice by one goroutine:
+// a writer waiting in between would deadlock them both. The locks are
+// advisory, they stop nothing that doesn't take them, and releasing the
+// entry drops them, so it mustn't be released while they're in use.
+func (entry *RBEntry) RLock(buffer int) {
+	stripe, key := entry.lockKey(buffer)
+
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+
+	lock := stripe.lock(key, entry.state.handle)
+	lock.waiting_readers++
+	for lock.writer || lock.waiting_writers > 0 {
+		stripe.cond.Wait()
+	}
+	lock.waiting_readers--
+	if lock.readers == 0 {
+		lock.since = time.Now()
+	}
+	lock.readers++
+}
+
+// RUnlock undoes a single RLock of the buffer-th buffer. It panics if the
+// buffer isn't locked for reading.
+func (entry *RBEntry) RUnlock(buffer int) {
+	stripe, key := entry.lockKey(buffer)
+
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+
+	lock := stripe.locks[key]
+	if lock == nil || lock.readers == 0 {
+		panic(fmt.Sprintf("rustybuffer: RUnlock of buffer %d of %s, which isn't read locked", buffer, entry.state.handle))
+	}
+	lock.readers--
+	stripe.forget(key, lock)
+	stripe.cond.Broadcast()
+}
+
+// Lock locks the entry's buffer-th buffer for writing, waiting until it
+// has no readers or writer.
+func (entry *RBEntry) Lock(buffer int) {
+	stripe, key := entry.lockKey(buffer)
+
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+
+	lock := stripe.lock(key, entry.state.handle)
+	lock.waiting_writers++
+	for lock.writer || lock.readers > 0 {
+		stripe.cond.Wait()
+	}
+	lock.waiting_writers--
+	lock.writer = true
+	lock.since = time.Now()
+}
+
+// TryLock locks the buffer-th buffer for writing if that doesn't mean
+// waiting, reporting whether it did.
+func (entry *RBEntry) TryLock(buffer int) bool {
+	stripe, key := entry.lockKey(buffer)
+
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+
+	lock := stripe.lock(key, entry.state.handle)
+	if lock.writer || lock.readers > 0 {
+		return false
+	}
+	lock.writer = true
+	lock.since = time.Now()
+	return true
+}
+
+// Unlock undoes Lock, or a TryLock that succeeded, of the buffer-th
+// buffer. It panics if the buffer isn't locked for writing.
+func (entry *RBEntry) Unlock(buffer int) {
+	stripe, key := entry.lockKey(buffer)
+
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+
+	lock := stripe.locks[key]
+	if lock == nil || !lock.writer {
+		panic(fmt.Sprintf("rustybuffer: Unlock of buffer %d of %s, which isn't locked", buffer, entry.state.handle))
+	}
+	lock.writer = false
+	stripe.forget(key, lock)
+	stripe.cond.Broadcast()
+}
+
+// forgetLocks drops the locks of a released entry, so whatever reuses
+// its memory doesn't inherit them.
+func (table *bufferLockTable) forgetLocks(data unsafe.Pointer) {
+	stripe := table.stripe(data)
+
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+
+	for key := range stripe.locks {
+		if key.data == data {
+			delete(stripe.locks, key)
+		}
+	}
+	stripe.cond.Broadcast()
+}
+
+// BufferLockState is a buffer lock that's held or waited for, see
+// Pool.BufferLocks.
+type BufferLockState struct {
+	Entry  Handle `json:"entry"`
+	Buffer int    `json:"buffer"`
+
+	Readers int  `json:"readers,omitempty"`
+	Writer  bool `json:"writer,omitempty"`
+
+	WaitingReaders int `json:"waiting_readers,omitempty"`
+	WaitingWriters int `json:"waiting_writers,omitempty"`
+
+	// When the lock was taken, zero if it's only waited for.
+	Since time.Time `json:"since,omitempty"`
+}
+
+// BufferLocks lists the buffer locks of the pool's entries that are held,
+// oldest first, and then those only waited for, to find who's holding up
+// whom.
+func (pool *Pool) BufferLocks() []BufferLockState {
+	table := pool.buffer_locks.Load()
+	if table == nil {
+		return nil
+	}
+
+	var states []BufferLockState
+	for idx := range table.stripes {
+		stripe := &table.stripes[idx]
+		stripe.mu.Lock()
+		for key, lock := range stripe.locks {
+			state := BufferLockState{
+				Entry:          lock.handle,
+				Buffer:         key.buffer,
+				Readers:        lock.readers,
+				Writer:         lock.writer,
+				WaitingReaders: lock.waiting_readers,
+				WaitingWriters: lock.waiting_writers,
+			}
+			if lock.readers > 0 || lock.writer {
+				state.Since = lock.since
+			}
+			states = append(states, state)
+		}
+		stripe.mu.Unlock()
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		if held_i, held_j := !states[i].Since.IsZero(), !states[j].Since.IsZero(); held_i != held_j {
+			return held_i
+		}
+		if !states[i].Since.Equal(states[j].Since) {
+			return states[i].Since.Before(states[j].Since)
+		}
+		if states[i].Entry != states[j].Entry {
+			return states[i].Entry < states[j].Entry
+		}
+		return states[i].Buffer < states[j].Buffer
+	})
+	return states
+}
@@ -0,0 +1,110 @@
+package rustybuffer
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBufferLocks(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	entry, err := pool.AllocBuffers([]uint64{10, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	if pool.BufferLocks() != nil {
+		t.Fatal("expected no locks before any are taken")
+	}
+
+	// Readers share, and a writer waits for them.
+	entry.RLock(0)
+	entry.RLock(0)
+	entry.Lock(1)
+	locked := make(chan struct{})
+	go func() {
+		entry.Lock(0)
+		close(locked)
+	}()
+	for len(pool.BufferLocks()) < 2 || pool.BufferLocks()[0].WaitingWriters == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	locks := pool.BufferLocks()
+	if len(locks) != 2 || locks[0].Buffer != 0 || locks[0].Readers != 2 ||
+		locks[1].Buffer != 1 || !locks[1].Writer || locks[0].Entry != entry.Handle() {
+		t.Fatalf("unexpected locks %+v", locks)
+	}
+	if entry.TryLock(0) || entry.TryLock(1) {
+		t.Fatal("expected TryLock of locked buffers to fail")
+	}
+
+	entry.RUnlock(0)
+	select {
+	case <-locked:
+		t.Fatal("the writer didn't wait for every reader")
+	case <-time.After(10 * time.Millisecond):
+	}
+	entry.RUnlock(0)
+	<-locked
+	entry.Unlock(0)
+	entry.Unlock(1)
+	if locks := pool.BufferLocks(); len(locks) != 0 {
+		t.Fatalf("expected unlocked buffers to be forgotten, got %+v", locks)
+	}
+
+	expectPanic(t, "isn't locked", func() { entry.Unlock(0) })
+	expectPanic(t, "isn't read locked", func() { entry.RUnlock(1) })
+	expectPanic(t, "buffer 2 of an entry with 2", func() { entry.Lock(2) })
+
+	if !entry.TryLock(1) {
+		t.Fatal("expected TryLock of an unlocked buffer to succeed")
+	}
+	copied := entry
+	copied.Release()
+	if locks := pool.BufferLocks(); len(locks) != 0 {
+		t.Fatalf("expected releasing the entry to drop its locks, got %+v", locks)
+	}
+	expectPanic(t, "released entry", func() { entry.RLock(0) })
+}
+
+func TestBufferLocksConcurrent(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	entry, err := pool.AllocBuffers([]uint64{8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 8; idx++ {
+		wg.Add(1)
+		go func(writer bool) {
+			defer wg.Done()
+			for round := 0; round < 200; round++ {
+				if writer {
+					entry.Lock(0)
+					for idx := range entry.Buffers[0] {
+						entry.Buffers[0][idx]++
+					}
+					entry.Unlock(0)
+					continue
+				}
+				entry.RLock(0)
+				for _, value := range entry.Buffers[0] {
+					if value != entry.Buffers[0][0] {
+						t.Error("read a half written buffer")
+					}
+				}
+				entry.RUnlock(0)
+			}
+		}(idx%2 == 0)
+	}
+	wg.Wait()
+
+	// Four writers, 200 times each, wrapping at 256.
+	if entry.Buffers[0][0] != 4*200%256 {
+		t.Fatalf("expected %d, got %d", 4*200%256, entry.Buffers[0][0])
+	}
+}
@@ -0,0 +1,82 @@
+package rustybuffer
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// BufferPool hands out byte slices backed by RustyBuffers memory. Its method
+// set matches grpc-go's mem.BufferPool so it can be handed to
+// experimental.WithBufferPool (client) or experimental.BufferPool (server)
+// and every message buffer gRPC reads or encodes into comes from here.
+//
+// When the Rust side can't satisfy a request (the pool is full or the
+// buffer is larger than max_buffer_size) Get falls back to the Go heap
+// rather than failing the RPC. Put silently ignores buffers it didn't hand
+// out.
+type BufferPool struct {
+	mu      sync.Mutex
+	entries map[*uint8]RBEntry
+}
+
+func NewBufferPool() *BufferPool {
+	return &BufferPool{
+		entries: make(map[*uint8]RBEntry),
+	}
+}
+
+// Get returns a buffer of exactly length bytes.
+func (pool *BufferPool) Get(length int) *[]byte {
+	return pool.getAligned(length, 1)
+}
+
+// getAligned returns a buffer of length bytes whose first byte is aligned to
+// align, which must be a power of two. Heap fallbacks are aligned as well.
+func (pool *BufferPool) getAligned(length int, align int) *[]byte {
+	if length <= 0 {
+		buf := make([]byte, 0)
+		return &buf
+	}
+
+	padded := uint64(length) + uint64(align-1)
+	entry, err := allocBuffers([]uint64{padded})
+	if err != nil {
+		buf := alignSlice(make([]byte, padded), length, align)
+		return &buf
+	}
+
+	buf := alignSlice(entry.Buffers[0], length, align)
+
+	pool.mu.Lock()
+	pool.entries[unsafe.SliceData(buf)] = entry
+	pool.mu.Unlock()
+
+	return &buf
+}
+
+// Put returns a buffer obtained from Get. The slice may have been
+// re-sliced as long as it still starts at the same address.
+func (pool *BufferPool) Put(buf *[]byte) {
+	if buf == nil || cap(*buf) == 0 {
+		return
+	}
+
+	key := unsafe.SliceData(*buf)
+
+	pool.mu.Lock()
+	entry, ok := pool.entries[key]
+	delete(pool.entries, key)
+	pool.mu.Unlock()
+
+	if ok {
+		entry.Release()
+	}
+
+	*buf = nil
+}
+
+func alignSlice(buf []byte, length int, align int) []byte {
+	addr := uintptr(unsafe.Pointer(unsafe.SliceData(buf)))
+	offset := int((uintptr(align) - addr%uintptr(align)) % uintptr(align))
+	return buf[offset : offset+length : offset+length]
+}
@@ -0,0 +1,54 @@
+package rustybuffer
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestBufferPoolReuse(t *testing.T) {
+	Configure(64*1024*1024, 1024*1024)
+	pool := NewBufferPool()
+
+	buf := pool.Get(4096)
+	if len(*buf) != 4096 {
+		t.Fatalf("expected 4096 bytes, got %d", len(*buf))
+	}
+	(*buf)[0] = 42
+	first := unsafe.SliceData(*buf)
+	pool.Put(buf)
+
+	if *buf != nil {
+		t.Fatal("Put should clear the returned slice")
+	}
+
+	buf = pool.Get(4096)
+	if unsafe.SliceData(*buf) != first {
+		t.Fatal("expected the released buffer to be reused")
+	}
+	if (*buf)[0] != 0 {
+		t.Fatal("reused buffer was not zeroed")
+	}
+	pool.Put(buf)
+}
+
+func TestBufferPoolHeapFallback(t *testing.T) {
+	Configure(64*1024*1024, 1024*1024)
+	pool := NewBufferPool()
+
+	buf := pool.Get(2 * 1024 * 1024)
+	if len(*buf) != 2*1024*1024 {
+		t.Fatalf("expected 2MiB, got %d", len(*buf))
+	}
+	if len(pool.entries) != 0 {
+		t.Fatal("oversized buffer should not be tracked by the pool")
+	}
+
+	// Returning a heap buffer is harmless.
+	pool.Put(buf)
+
+	empty := pool.Get(0)
+	if len(*empty) != 0 {
+		t.Fatal("expected an empty buffer")
+	}
+	pool.Put(empty)
+}
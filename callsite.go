@@ -0,0 +1,146 @@
+package rustybuffer
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// How many return addresses are kept for each acquire, which has to be
+// enough to get past the package's own frames.
+const callSiteDepth = 8
+
+// WithCallSiteStats has the pool attribute every live entry to the code
+// that acquired it, for CallSiteStats. Recording the call stack costs a
+// few hundred nanoseconds per acquire, turning it into a function name and
+// line is left until CallSiteStats is called.
+func WithCallSiteStats() PoolOption {
+	return func(pool *Pool) {
+		pool.sites = &siteRegistry{
+			live:   make(map[unsafe.Pointer]siteEntry),
+			totals: make(map[callStack]LabelStats),
+		}
+	}
+}
+
+// CallSiteStats is what CallSiteStats reports for one call site.
+type CallSiteStats struct {
+	// The function, file and line that acquired the entries, the first
+	// one outside of this package.
+	Site string
+
+	Entries uint64
+	Bytes   uint64
+
+	// When the site's oldest live entry was acquired.
+	Oldest time.Time
+}
+
+// CallSiteStats breaks down the pool's live entries by where they were
+// acquired, most bytes first. It's empty unless the pool was created with
+// WithCallSiteStats.
+func (pool *Pool) CallSiteStats() []CallSiteStats {
+	if pool.sites == nil {
+		return nil
+	}
+	return pool.sites.group()
+}
+
+type callStack [callSiteDepth]uintptr
+
+type siteEntry struct {
+	stack    callStack
+	size     uint64
+	acquired time.Time
+}
+
+// siteRegistry keeps a running total for each distinct call stack, so
+// breaking them down only has to symbolise each stack once.
+type siteRegistry struct {
+	mu     sync.Mutex
+	live   map[unsafe.Pointer]siteEntry
+	totals map[callStack]LabelStats
+}
+
+// track records who acquired data, skip frames above the caller.
+func (registry *siteRegistry) track(data unsafe.Pointer, size uint64, skip int) {
+	var stack callStack
+	runtime.Callers(skip+2, stack[:])
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.live[data] = siteEntry{stack, size, time.Now()}
+	totals := registry.totals[stack]
+	totals.Entries++
+	totals.Bytes += size
+	registry.totals[stack] = totals
+}
+
+func (registry *siteRegistry) forget(data unsafe.Pointer) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	entry, ok := registry.live[data]
+	if !ok {
+		return
+	}
+	delete(registry.live, data)
+
+	totals := registry.totals[entry.stack]
+	totals.Entries--
+	totals.Bytes -= entry.size
+	if totals.Entries == 0 {
+		delete(registry.totals, entry.stack)
+	} else {
+		registry.totals[entry.stack] = totals
+	}
+}
+
+func (registry *siteRegistry) group() []CallSiteStats {
+	registry.mu.Lock()
+	totals := make(map[callStack]LabelStats, len(registry.totals))
+	for stack, stats := range registry.totals {
+		totals[stack] = stats
+	}
+	oldest := make(map[callStack]time.Time, len(registry.totals))
+	for _, entry := range registry.live {
+		if acquired, ok := oldest[entry.stack]; !ok || entry.acquired.Before(acquired) {
+			oldest[entry.stack] = entry.acquired
+		}
+	}
+	registry.mu.Unlock()
+
+	// Different stacks can come down to the same call site.
+	sites := make(map[string]*CallSiteStats)
+	for stack, stats := range totals {
+		callers := stack[:]
+		for len(callers) > 0 && callers[len(callers)-1] == 0 {
+			callers = callers[:len(callers)-1]
+		}
+
+		site := callersSite(callers)
+		if sites[site] == nil {
+			sites[site] = &CallSiteStats{Site: site}
+		}
+		sites[site].Entries += stats.Entries
+		sites[site].Bytes += stats.Bytes
+		if sites[site].Oldest.IsZero() || oldest[stack].Before(sites[site].Oldest) {
+			sites[site].Oldest = oldest[stack]
+		}
+	}
+
+	grouped := make([]CallSiteStats, 0, len(sites))
+	for _, stats := range sites {
+		grouped = append(grouped, *stats)
+	}
+	sort.Slice(grouped, func(i, j int) bool {
+		if grouped[i].Bytes != grouped[j].Bytes {
+			return grouped[i].Bytes > grouped[j].Bytes
+		}
+		return grouped[i].Site < grouped[j].Site
+	})
+	return grouped
+}
@@ -0,0 +1,86 @@
+package rustybuffer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func acquireForCallSite(t *testing.T, pool *Pool, size uint64) RBEntry {
+	entry, err := pool.AllocBuffers([]uint64{size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+func acquireElsewhere(t *testing.T, pool *Pool) RBEntry {
+	return acquireForCallSite(t, pool, 5000)
+}
+
+func TestCallSiteStats(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)), WithCallSiteStats())
+
+	// Followed through the package's own functions.
+	leased := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)), WithCallSiteStats())
+	lease, err := leased.AcquireLease([]uint64{10}, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lease.Release()
+	if sites := leased.CallSiteStats(); len(sites) != 1 ||
+		!strings.Contains(sites[0].Site, "TestCallSiteStats") {
+		t.Fatalf("unexpected call sites: %+v", sites)
+	}
+
+	var entries []RBEntry
+	for idx := 0; idx < 3; idx++ {
+		entries = append(entries, acquireForCallSite(t, pool, 100))
+	}
+	entries = append(entries, acquireElsewhere(t, pool))
+
+	// acquireElsewhere's entry is attributed to acquireForCallSite too,
+	// being the first frame outside of the package, but it's the bigger
+	// one.
+	sites := pool.CallSiteStats()
+	if len(sites) != 1 {
+		t.Fatalf("unexpected call sites: %+v", sites)
+	}
+	if !strings.Contains(sites[0].Site, "acquireForCallSite") ||
+		sites[0].Entries != 4 || sites[0].Bytes != 5300 {
+		t.Fatalf("unexpected call site: %+v", sites[0])
+	}
+
+	first := sites[0].Oldest
+	if first.IsZero() || time.Since(first) > time.Minute {
+		t.Fatalf("unexpected oldest entry %v", first)
+	}
+
+	direct, err := pool.AllocBuffers([]uint64{10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sites = pool.CallSiteStats()
+	if len(sites) != 2 || !strings.Contains(sites[0].Site, "TestCallSiteStats") ||
+		sites[0].Bytes != 10000 || sites[1].Bytes != 5300 {
+		t.Fatalf("unexpected call sites: %+v", sites)
+	}
+	if !sites[1].Oldest.Equal(first) || sites[0].Oldest.Before(first) {
+		t.Fatalf("unexpected oldest entries: %+v", sites)
+	}
+
+	direct.Release()
+	for _, entry := range entries {
+		entry.Release()
+	}
+	if sites := pool.CallSiteStats(); len(sites) != 0 {
+		t.Fatalf("unexpected call sites: %+v", sites)
+	}
+	if len(pool.sites.totals) != 0 {
+		t.Fatalf("still tracking %v", pool.sites.totals)
+	}
+
+	if sites := NewPool().CallSiteStats(); sites != nil {
+		t.Fatalf("unexpected call sites: %+v", sites)
+	}
+}
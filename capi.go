@@ -0,0 +1,183 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// The pools exported to C, by name.
+var exportedPools struct {
+	mu    sync.Mutex
+	pools map[string]*exportedPool
+}
+
+// exportedPool is a pool exported to C and the entries C has acquired
+// from it, by address, since C only hands back the pointer.
+type exportedPool struct {
+	pool *Pool
+
+	mu      sync.Mutex
+	entries map[unsafe.Pointer]RBEntry
+}
+
+// The codes the exported functions return, see lib/rustybuffer_pool.h.
+const (
+	codeUnknownPool uint8 = 64
+	codeFailed      uint8 = 65
+)
+
+// ExportPool makes pool available to C code in the same process, and
+// other languages through their FFI (e.g., Python's ctypes), as name, so
+// they allocate from the same budget as the Go side rather than competing
+// with it for memory. lib/rustybuffer_pool.h declares the functions, which
+// need a cgo build. C keeps pointers to what it acquires, so the pool's
+// memory mustn't come from the Go heap (NewHeapAllocator or
+// ExhaustionHeap). Exporting another pool under the same name replaces it
+// for new acquires.
+func ExportPool(name string, pool *Pool) {
+	exportedPools.mu.Lock()
+	defer exportedPools.mu.Unlock()
+
+	if exportedPools.pools == nil {
+		exportedPools.pools = make(map[string]*exportedPool)
+	}
+	exportedPools.pools[name] = &exportedPool{pool: pool, entries: make(map[unsafe.Pointer]RBEntry)}
+}
+
+// UnexportPool stops C acquiring from the pool exported as name. Buffers
+// C already acquired from it can no longer be released through the
+// exported functions, so should be released first.
+func UnexportPool(name string) {
+	exportedPools.mu.Lock()
+	defer exportedPools.mu.Unlock()
+
+	delete(exportedPools.pools, name)
+}
+
+func exportedPoolNamed(name string) (*exportedPool, bool) {
+	exportedPools.mu.Lock()
+	defer exportedPools.mu.Unlock()
+
+	exported, ok := exportedPools.pools[name]
+	return exported, ok
+}
+
+// exportedAcquire acquires size bytes for C from the pool exported as name.
+func exportedAcquire(name string, size uint64) (unsafe.Pointer, uint8) {
+	exported, ok := exportedPoolNamed(name)
+	if !ok {
+		return nil, codeUnknownPool
+	}
+
+	entry, err := exported.pool.AllocBuffers([]uint64{size})
+	if err != nil {
+		return nil, exportedCode(err)
+	}
+
+	exported.mu.Lock()
+	exported.entries[entry.data] = entry
+	exported.mu.Unlock()
+
+	return entry.data, 0
+}
+
+// exportedRelease releases what C acquired at data from the pool exported
+// as name.
+func exportedRelease(name string, data unsafe.Pointer) uint8 {
+	exported, ok := exportedPoolNamed(name)
+	if !ok {
+		return codeUnknownPool
+	}
+
+	exported.mu.Lock()
+	entry, ok := exported.entries[data]
+	delete(exported.entries, data)
+	exported.mu.Unlock()
+
+	if !ok {
+		return codeInvalidPointer
+	}
+	if err := entry.Close(); err != nil {
+		return exportedCode(err)
+	}
+	return 0
+}
+
+// CEntry is an entry handed over to C by ExportC, laid out like
+// lib/rustybuffer_pool.h's rbgo_entry_t so it can be passed as one.
+type CEntry struct {
+	Data  unsafe.Pointer
+	Len   uint64
+	Token uint64
+}
+
+// The entries handed over to C, by token.
+var exportedEntries struct {
+	mu      sync.Mutex
+	entries map[uint64]exportedEntry
+}
+
+type exportedEntry struct {
+	entry RBEntry
+	unpin func()
+}
+
+// ExportC hands the entry's memory, all of its buffers as one, over to a
+// C library that takes ownership of it and frees it in its own time, e.g.,
+// from an asynchronous codec's completion callback, by calling
+// rbgo_entry_release with the token (see lib/rustybuffer_pool.h). Only
+// then is the memory given back to the pool, with everything a release
+// from Go would do. Releasing the entry from Go in the meantime is
+// harmless: the memory is kept until C is done with it (see Pin). The
+// same restrictions on Go heap memory apply as for ExportPool.
+func (entry *RBEntry) ExportC() (CEntry, error) {
+	if entry.state == nil || entry.data == nil || entry.state.released.Load() {
+		return CEntry{}, fmt.Errorf("rustybuffer: export of a released entry")
+	}
+	if err := entry.checkTaint("ExportC"); err != nil {
+		return CEntry{}, err
+	}
+
+	token := uint64(entry.Handle())
+	exportedEntries.mu.Lock()
+	defer exportedEntries.mu.Unlock()
+
+	if _, exported := exportedEntries.entries[token]; exported {
+		return CEntry{}, fmt.Errorf("rustybuffer: entry %s is already exported", entry.Handle())
+	}
+	if exportedEntries.entries == nil {
+		exportedEntries.entries = make(map[uint64]exportedEntry)
+	}
+
+	data, unpin := entry.Pin()
+	exportedEntries.entries[token] = exportedEntry{*entry, unpin}
+
+	return CEntry{data, entry.state.size, token}, nil
+}
+
+// exportedEntryRelease releases the entry handed over to C as token.
+func exportedEntryRelease(token uint64) uint8 {
+	exportedEntries.mu.Lock()
+	exported, ok := exportedEntries.entries[token]
+	delete(exportedEntries.entries, token)
+	exportedEntries.mu.Unlock()
+
+	if !ok {
+		return codeInvalidPointer
+	}
+	// Pinned, so this only marks it released and the unpin gives it back.
+	exported.entry.Close()
+	exported.unpin()
+	return 0
+}
+
+// exportedCode is the code for err, as far as C is concerned.
+func exportedCode(err error) uint8 {
+	var rb_err *Error
+	if errors.As(err, &rb_err) && rb_err.Code != codeInvalidData {
+		return rb_err.Code
+	}
+	return codeFailed
+}
@@ -0,0 +1,56 @@
+//go:build cgo
+
+package rustybuffer
+
+/*
+#include "./lib/rustybuffer_pool.h"
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+// The functions lib/rustybuffer_pool.h declares, for pools exported with
+// ExportPool and entries exported with ExportC. They copy pool names, so
+// they only have to last for the call.
+
+//export rbgo_pool_acquire
+func rbgo_pool_acquire(name *C.char, size C.uint64_t, data *unsafe.Pointer) C.uint8_t {
+	ptr, code := exportedAcquire(C.GoString(name), uint64(size))
+	if code == 0 {
+		*data = ptr
+	}
+	return C.uint8_t(code)
+}
+
+//export rbgo_pool_release
+func rbgo_pool_release(name *C.char, data unsafe.Pointer) C.uint8_t {
+	return C.uint8_t(exportedRelease(C.GoString(name), data))
+}
+
+//export rbgo_entry_release
+func rbgo_entry_release(token C.uint64_t) C.uint8_t {
+	return C.uint8_t(exportedEntryRelease(uint64(token)))
+}
+
+//export rbgo_pool_stats
+func rbgo_pool_stats(name *C.char, stats *C.rbgo_pool_stats_t) C.uint8_t {
+	exported, ok := exportedPoolNamed(C.GoString(name))
+	if !ok {
+		return C.uint8_t(codeUnknownPool)
+	}
+
+	pool_stats := exported.pool.Stats()
+	*stats = C.rbgo_pool_stats_t{
+		max_total_size:  C.uint64_t(pool_stats.MaxTotalSize),
+		max_buffer_size: C.uint64_t(pool_stats.MaxBufferSize),
+		bytes_allocated: C.uint64_t(pool_stats.BytesAllocated),
+		bytes_in_use:    C.uint64_t(pool_stats.BytesInUse),
+		num_buffers:     C.uint64_t(pool_stats.NumBuffers),
+		num_available:   C.uint64_t(pool_stats.NumAvailable),
+		fallback_bytes:  C.uint64_t(pool_stats.FallbackBytes),
+		spilled_bytes:   C.uint64_t(pool_stats.SpilledBytes),
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package rustybuffer
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestExportPool(t *testing.T) {
+	pool := NewPool(WithAllocator(NewMallocAllocator(1000, 1000)))
+	ExportPool("capi-test", pool)
+	defer UnexportPool("capi-test")
+
+	data, code := exportedAcquire("capi-test", 600)
+	if code != 0 || data == nil {
+		t.Fatalf("acquire failed with %d", code)
+	}
+	if _, code := exportedAcquire("capi-test", 600); code != codeNoBufferAvailable {
+		t.Fatalf("expected code %d, got %d", codeNoBufferAvailable, code)
+	}
+	if _, code := exportedAcquire("capi-test", 2000); code != codeBufferTooLarge {
+		t.Fatalf("expected code %d, got %d", codeBufferTooLarge, code)
+	}
+	if stats := pool.Stats(); stats.BytesInUse != 600 {
+		t.Fatalf("C's acquire isn't in the pool's stats: %+v", stats)
+	}
+
+	if code := exportedRelease("capi-test", data); code != 0 {
+		t.Fatalf("release failed with %d", code)
+	}
+	if code := exportedRelease("capi-test", data); code != codeInvalidPointer {
+		t.Fatalf("expected code %d releasing twice, got %d", codeInvalidPointer, code)
+	}
+	if stats := pool.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("release didn't reach the pool: %+v", stats)
+	}
+
+	if _, code := exportedAcquire("missing", 10); code != codeUnknownPool {
+		t.Fatalf("expected code %d, got %d", codeUnknownPool, code)
+	}
+	if code := exportedRelease("missing", data); code != codeUnknownPool {
+		t.Fatalf("expected code %d, got %d", codeUnknownPool, code)
+	}
+}
+
+func TestExportC(t *testing.T) {
+	pool := NewPool(WithAllocator(NewMallocAllocator(1000, 1000)))
+	entry, err := pool.AllocBuffers([]uint64{100, 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := entry.ExportC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported.Data != unsafe.Pointer(&entry.Buffers[0][0]) || exported.Len != 300 ||
+		exported.Token != uint64(entry.Handle()) {
+		t.Fatalf("unexpected export %+v", exported)
+	}
+	if _, err := entry.ExportC(); err == nil {
+		t.Fatal("exported an entry twice")
+	}
+
+	// Go letting go of it first doesn't take it away from C.
+	entry.Release()
+	if stats := pool.Stats(); stats.BytesInUse != 300 {
+		t.Fatalf("released while C still had it: %+v", stats)
+	}
+
+	if code := exportedEntryRelease(exported.Token); code != 0 {
+		t.Fatalf("release failed with %d", code)
+	}
+	if stats := pool.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("C's release didn't reach the pool: %+v", stats)
+	}
+	if code := exportedEntryRelease(exported.Token); code != codeInvalidPointer {
+		t.Fatalf("expected code %d releasing twice, got %d", codeInvalidPointer, code)
+	}
+
+	if _, err := entry.ExportC(); err == nil {
+		t.Fatal("exported a released entry")
+	}
+}
@@ -0,0 +1,120 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// The paths that call into the Rust library, each counted separately.
+type cgoPath int
+
+const (
+	cgoAcquire cgoPath = iota
+	cgoRelease
+	cgoStats
+	cgoFill
+	cgoFillRandom
+	cgoCopy
+	cgoCompare
+	cgoConstantTimeEqual
+	cgoChecksum
+	cgoTransform
+	cgoCompress
+	cgoTrim
+	cgoEvents
+	cgoLiveHandles
+	cgoCachedSizes
+	cgoWritevChecksum
+	numCgoPaths
+)
+
+var cgoPathNames = [numCgoPaths]string{
+	"acquire", "release", "stats", "fill", "fill random", "copy", "compare",
+	"constant time equal", "checksum", "transform", "compress", "trim",
+	"events", "live handles", "cached sizes", "writev checksum",
+}
+
+var cgoCalls struct {
+	paths [numCgoPaths]atomic.Uint64
+
+	// Calls that one batched call per group would have made unnecessary.
+	batchable_releases  atomic.Uint64
+	batchable_checksums atomic.Uint64
+}
+
+// countCgo counts calls made into the library by path.
+func countCgo(path cgoPath, calls uint64) {
+	cgoCalls.paths[path].Add(calls)
+}
+
+// CgoCalls is how many calls into the Rust library the package has made
+// since the process started, by path. Builds without the library make
+// none.
+type CgoCalls struct {
+	Paths map[string]uint64
+
+	// The calls that batching could have saved: releasing each group of
+	// entries released together (a Tx rolling back, an Arena releasing its
+	// chunks, ...) took one call per entry where a batched release would
+	// take one for the group, and checksumming several buffers took one
+	// per buffer. These are estimates, other goroutines releasing at the
+	// same time as a group count towards it.
+	BatchableReleases  uint64
+	BatchableChecksums uint64
+}
+
+// CgoCallCounts returns the calls made into the Rust library so far, to
+// weigh the cost of the cgo transitions a workload makes.
+func CgoCallCounts() CgoCalls {
+	calls := CgoCalls{
+		Paths:              make(map[string]uint64, numCgoPaths),
+		BatchableReleases:  cgoCalls.batchable_releases.Load(),
+		BatchableChecksums: cgoCalls.batchable_checksums.Load(),
+	}
+	for path, name := range cgoPathNames {
+		calls.Paths[name] = cgoCalls.paths[path].Load()
+	}
+	return calls
+}
+
+// Total is the number of calls made over every path.
+func (calls CgoCalls) Total() uint64 {
+	var total uint64
+	for _, count := range calls.Paths {
+		total += count
+	}
+	return total
+}
+
+// Report describes the calls made, the busiest paths first, and what
+// batching would have saved.
+func (calls CgoCalls) Report() string {
+	var report strings.Builder
+	fmt.Fprintf(&report, "%d cgo calls\n", calls.Total())
+
+	names := append([]string(nil), cgoPathNames[:]...)
+	sort.SliceStable(names, func(i, j int) bool { return calls.Paths[names[i]] > calls.Paths[names[j]] })
+	for _, name := range names {
+		if calls.Paths[name] != 0 {
+			fmt.Fprintf(&report, "  %s: %d\n", name, calls.Paths[name])
+		}
+	}
+
+	fmt.Fprintf(&report, "release batching would have saved %d calls\n", calls.BatchableReleases)
+	fmt.Fprintf(&report, "checksum batching would have saved %d calls\n", calls.BatchableChecksums)
+	return report.String()
+}
+
+// releaseGroup releases entries, newest first, counting how many more
+// release calls it took than a batched release would have.
+func releaseGroup(entries []RBEntry) {
+	before := cgoCalls.paths[cgoRelease].Load()
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		entries[idx].Release()
+	}
+	if made := cgoCalls.paths[cgoRelease].Load() - before; made > 1 {
+		cgoCalls.batchable_releases.Add(made - 1)
+	}
+}
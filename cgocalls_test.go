@@ -0,0 +1,55 @@
+package rustybuffer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCgoCallCounts(t *testing.T) {
+	if info, err := LibraryInfo(); err != nil || !info.Native {
+		t.Skip("the Rust library isn't available")
+	}
+	pool := NewPool(WithAllocator(NewRustAllocator()))
+	before := CgoCallCounts()
+
+	tx := pool.Begin()
+	for idx := 0; idx < 3; idx++ {
+		if _, err := tx.AllocBuffers([]uint64{100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx.Rollback()
+
+	entry, err := pool.AllocBuffers([]uint64{checksumNativeThreshold, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	if _, err := entry.Checksum(ChecksumCRC32C); err != nil {
+		t.Fatal(err)
+	}
+
+	after := CgoCallCounts()
+	if made := after.Paths["acquire"] - before.Paths["acquire"]; made < 4 {
+		t.Fatalf("expected at least 4 acquires, got %d", made)
+	}
+	if made := after.Paths["release"] - before.Paths["release"]; made < 3 {
+		t.Fatalf("expected at least 3 releases, got %d", made)
+	}
+	if saved := after.BatchableReleases - before.BatchableReleases; saved < 2 {
+		t.Fatalf("expected batching to save at least 2 releases, got %d", saved)
+	}
+	if saved := after.BatchableChecksums - before.BatchableChecksums; saved < 1 {
+		t.Fatalf("expected batching to save at least 1 checksum call, got %d", saved)
+	}
+	if after.Total() <= before.Total() {
+		t.Fatalf("expected the total to grow from %d, got %d", before.Total(), after.Total())
+	}
+
+	report := after.Report()
+	for _, line := range []string{"cgo calls\n", "  acquire: ", "release batching would have saved "} {
+		if !strings.Contains(report, line) {
+			t.Fatalf("expected %q in the report:\n%s", line, report)
+		}
+	}
+}
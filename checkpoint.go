@@ -0,0 +1,332 @@
+package rustybuffer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// Checkpoints start with this, then the format's version.
+const (
+	checkpointMagic   = "RBCP"
+	checkpointVersion = 1
+)
+
+// Bounds on what a checkpoint entry can claim to have, so a corrupt one
+// can't have RestorePool allocate without limit before reading its data.
+const (
+	checkpointMaxLabels  = 1 << 16
+	checkpointMaxString  = 1 << 20
+	checkpointMaxBuffers = 1 << 20
+)
+
+// WithCheckpoints has the pool keep track of every live entry and its
+// buffers, for Pool.Checkpoint.
+func WithCheckpoints() PoolOption {
+	return func(pool *Pool) {
+		pool.checkpoints = &checkpointRegistry{live: make(map[unsafe.Pointer]checkpointEntry)}
+	}
+}
+
+// checkpointEntry is what a checkpoint needs of a live entry, pinning it
+// through its pins rather than holding on to its state, so that the
+// registry doesn't keep a leaked entry alive.
+type checkpointEntry struct {
+	handle Handle
+	data   unsafe.Pointer
+	size   uint64
+	sizes  []uint64
+	pins   *entryPins
+	labels *entryLabels
+}
+
+type checkpointRegistry struct {
+	mu   sync.Mutex
+	live map[unsafe.Pointer]checkpointEntry
+}
+
+func (registry *checkpointRegistry) track(state *entryState, sizes []uint64) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.live[state.data] = checkpointEntry{
+		handle: state.handle,
+		data:   state.data,
+		size:   state.size,
+		sizes:  append([]uint64(nil), sizes...),
+		pins:   state.entryPins,
+	}
+}
+
+// label records the labels a live entry's been given.
+func (registry *checkpointRegistry) label(data unsafe.Pointer, labels *entryLabels) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if entry, ok := registry.live[data]; ok {
+		entry.labels = labels
+		registry.live[data] = entry
+	}
+}
+
+func (registry *checkpointRegistry) forget(data unsafe.Pointer) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	delete(registry.live, data)
+}
+
+// entries returns the live entries oldest first.
+func (registry *checkpointRegistry) entries() []checkpointEntry {
+	registry.mu.Lock()
+	entries := make([]checkpointEntry, 0, len(registry.live))
+	for _, entry := range registry.live {
+		entries = append(entries, entry)
+	}
+	registry.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].handle < entries[j].handle })
+	return entries
+}
+
+// Checkpoint writes every live entry in the pool, its handle, labels,
+// buffer sizes and contents, to w for RestorePool to rebuild, so a cache
+// can be restarted warm rather than refilled from upstream. The pool has
+// to have been created WithCheckpoints. Entries aren't stopped from being
+// changed while they're written, so anything writing to them concurrently
+// may leave a mix of old and new bytes in the checkpoint, and those
+// acquired or released while it's taken may or may not be in it.
+func (pool *Pool) Checkpoint(w io.Writer) error {
+	if pool.checkpoints == nil {
+		return errors.New("rustybuffer: checkpoint of a pool created without WithCheckpoints")
+	}
+
+	if _, err := io.WriteString(w, checkpointMagic); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{checkpointVersion}); err != nil {
+		return err
+	}
+
+	// Each entry's pinned, as Pin does, while it's written.
+	for _, entry := range pool.checkpoints.entries() {
+		if !entry.pins.tryPin() {
+			continue
+		}
+		err := writeCheckpointEntry(w, entry)
+		entry.pins.unpin()
+		if err != nil {
+			return err
+		}
+	}
+
+	// The end of the checkpoint.
+	_, err := w.Write([]byte{0})
+	return err
+}
+
+// writeCheckpointEntry writes an entry as a 1, its handle, labels and
+// buffer sizes, as uvarints and length prefixed strings, then its buffers
+// and their little endian CRC32C.
+func writeCheckpointEntry(w io.Writer, entry checkpointEntry) error {
+	header := []byte{1}
+	header = binary.AppendUvarint(header, uint64(entry.handle))
+
+	var labels map[string]string
+	if entry.labels != nil {
+		labels = entry.labels.get()
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	header = binary.AppendUvarint(header, uint64(len(keys)))
+	for _, key := range keys {
+		header = binary.AppendUvarint(header, uint64(len(key)))
+		header = append(header, key...)
+		header = binary.AppendUvarint(header, uint64(len(labels[key])))
+		header = append(header, labels[key]...)
+	}
+
+	header = binary.AppendUvarint(header, uint64(len(entry.sizes)))
+	for _, size := range entry.sizes {
+		header = binary.AppendUvarint(header, size)
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	buffers, err := carveBuffers(entry.data, max(entry.size, 1), entry.sizes)
+	if err != nil {
+		return err
+	}
+	for _, buffer := range buffers {
+		if _, err := w.Write(buffer); err != nil {
+			return err
+		}
+	}
+	crc := uint32(checksumBuffers(ChecksumCRC32C, buffers, entry.size))
+	_, err = w.Write(binary.LittleEndian.AppendUint32(nil, crc))
+	return err
+}
+
+// RestorePool creates a pool with opts, and WithCheckpoints, and rebuilds
+// the entries of a checkpoint (see Pool.Checkpoint) in it, with their
+// labels and contents. Handles are never reused, so the entries get new
+// ones, and are returned keyed by the handles they were checkpointed
+// with. A checkpoint that's malformed, or whose contents don't match
+// their checksum, fails with ErrInvalidData and one that ends part way
+// through with io.ErrUnexpectedEOF, having released whatever was rebuilt.
+func RestorePool(r io.Reader, opts ...PoolOption) (*Pool, map[Handle]RBEntry, error) {
+	pool := NewPool(append(opts[:len(opts):len(opts)], WithCheckpoints())...)
+
+	reader, ok := r.(io.ByteReader)
+	if !ok {
+		buffered := bufio.NewReader(r)
+		r, reader = buffered, buffered
+	}
+
+	var header [len(checkpointMagic) + 1]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, unexpectedEOF(err)
+	}
+	if string(header[:len(checkpointMagic)]) != checkpointMagic {
+		return nil, nil, fmt.Errorf("%w: not a checkpoint", ErrInvalidData)
+	}
+	if version := header[len(checkpointMagic)]; version != checkpointVersion {
+		return nil, nil, fmt.Errorf("%w: checkpoint version %d", ErrInvalidData, version)
+	}
+
+	entries := make(map[Handle]RBEntry)
+	release := func() {
+		for _, entry := range entries {
+			entry.Release()
+		}
+	}
+	for {
+		more, err := reader.ReadByte()
+		if err != nil {
+			release()
+			return nil, nil, unexpectedEOF(err)
+		}
+		if more == 0 {
+			return pool, entries, nil
+		}
+		if more != 1 {
+			release()
+			return nil, nil, fmt.Errorf("%w: checkpoint entry marker %d", ErrInvalidData, more)
+		}
+
+		handle, entry, err := readCheckpointEntry(pool, r, reader)
+		if err == nil {
+			if _, duplicate := entries[handle]; duplicate {
+				entry.Release()
+				err = fmt.Errorf("%w: checkpoint has entry %s twice", ErrInvalidData, handle)
+			}
+		}
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		entries[handle] = entry
+	}
+}
+
+// readCheckpointEntry reads what writeCheckpointEntry wrote after the 1,
+// acquiring the entry from pool.
+func readCheckpointEntry(pool *Pool, r io.Reader, reader io.ByteReader) (Handle, RBEntry, error) {
+	handle, err := readCheckpointUvarint(reader, "handle", 1<<64-1)
+	if err != nil {
+		return 0, RBEntry{}, err
+	}
+
+	num_labels, err := readCheckpointUvarint(reader, "label count", checkpointMaxLabels)
+	if err != nil {
+		return 0, RBEntry{}, err
+	}
+	var labels map[string]string
+	for idx := uint64(0); idx < num_labels; idx++ {
+		key, err := readCheckpointString(r, reader)
+		if err != nil {
+			return 0, RBEntry{}, err
+		}
+		value, err := readCheckpointString(r, reader)
+		if err != nil {
+			return 0, RBEntry{}, err
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[key] = value
+	}
+
+	num_buffers, err := readCheckpointUvarint(reader, "buffer count", checkpointMaxBuffers)
+	if err != nil {
+		return 0, RBEntry{}, err
+	}
+	sizes := make([]uint64, num_buffers)
+	for idx := range sizes {
+		if sizes[idx], err = readCheckpointUvarint(reader, "buffer size", 1<<64-1); err != nil {
+			return 0, RBEntry{}, err
+		}
+	}
+
+	var acquire_opts []AcquireOption
+	if labels != nil {
+		acquire_opts = append(acquire_opts, WithLabels(labels))
+	}
+	entry, err := pool.AllocBuffers(sizes, acquire_opts...)
+	if err != nil {
+		return 0, RBEntry{}, err
+	}
+	for _, buffer := range entry.Buffers {
+		if _, err := io.ReadFull(r, buffer); err != nil {
+			entry.Release()
+			return 0, RBEntry{}, unexpectedEOF(err)
+		}
+	}
+
+	var crc [4]byte
+	if _, err := io.ReadFull(r, crc[:]); err != nil {
+		entry.Release()
+		return 0, RBEntry{}, unexpectedEOF(err)
+	}
+	checksum, _ := entry.Checksum(ChecksumCRC32C)
+	if uint32(checksum) != binary.LittleEndian.Uint32(crc[:]) {
+		entry.Release()
+		return 0, RBEntry{}, fmt.Errorf("%w: checkpoint entry %s doesn't match its checksum", ErrInvalidData, Handle(handle))
+	}
+	return Handle(handle), entry, nil
+}
+
+func readCheckpointUvarint(reader io.ByteReader, what string, limit uint64) (uint64, error) {
+	value, err := binary.ReadUvarint(reader)
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, fmt.Errorf("%w: checkpoint entry %s: %w", ErrInvalidData, what, err)
+	}
+	if err == nil && value > limit {
+		return 0, fmt.Errorf("%w: checkpoint entry %s of %d", ErrInvalidData, what, value)
+	}
+	return value, err
+}
+
+func readCheckpointString(r io.Reader, reader io.ByteReader) (string, error) {
+	length, err := readCheckpointUvarint(reader, "label length", checkpointMaxString)
+	if err != nil {
+		return "", err
+	}
+	bytes := make([]byte, length)
+	if _, err := io.ReadFull(r, bytes); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	return string(bytes), nil
+}
@@ -0,0 +1,132 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointRestore(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)), WithCheckpoints())
+
+	first, err := pool.AllocBuffers([]uint64{3, 0, 5}, WithLabels(map[string]string{"tenant": "a", "kind": "page"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(first.Buffers[0], "abc")
+	copy(first.Buffers[2], "defgh")
+	defer first.Release()
+
+	second, err := pool.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Fill(7)
+	second.SetLabel("tenant", "b")
+	defer second.Release()
+
+	released, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	released.Release()
+
+	var checkpoint bytes.Buffer
+	if err := pool.Checkpoint(&checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	restored_pool, entries, err := RestorePool(bytes.NewReader(checkpoint.Bytes()), WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	}
+
+	restored := entries[first.Handle()]
+	if restored.Handle() == first.Handle() {
+		t.Fatal("expected a new handle")
+	}
+	if !reflect.DeepEqual(restored.Buffers, [][]byte{[]byte("abc"), {}, []byte("defgh")}) {
+		t.Fatalf("unexpected buffers %q", restored.Buffers)
+	}
+	if labels := restored.Labels(); !reflect.DeepEqual(labels, first.Labels()) {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+	if buffer := entries[second.Handle()].Buffers[0]; !bytes.Equal(buffer, bytes.Repeat([]byte{7}, 100)) {
+		t.Fatalf("unexpected buffer %v", buffer)
+	}
+	restored = entries[second.Handle()]
+	if labels := restored.Labels(); labels["tenant"] != "b" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+
+	// The restored pool can be checkpointed in turn.
+	var again bytes.Buffer
+	if err := restored_pool.Checkpoint(&again); err != nil {
+		t.Fatal(err)
+	}
+	if again.Len() != checkpoint.Len() {
+		t.Fatalf("expected a %d byte checkpoint, got %d", checkpoint.Len(), again.Len())
+	}
+
+	for _, entry := range entries {
+		entry.Release()
+	}
+	if stats := restored_pool.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCheckpointWithoutTracking(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	if err := pool.Checkpoint(io.Discard); err == nil {
+		t.Fatal("expected the checkpoint to fail")
+	}
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)), WithCheckpoints())
+	entry, err := pool.AllocBuffers([]uint64{20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	var checkpoint bytes.Buffer
+	if err := pool.Checkpoint(&checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	valid := checkpoint.Bytes()
+
+	alloc := NewHeapAllocator(1024, 1024)
+	restore := func(data []byte) error {
+		_, _, err := RestorePool(bytes.NewReader(data), WithAllocator(alloc))
+		return err
+	}
+
+	corrupt := append([]byte(nil), valid...)
+	corrupt[len(corrupt)-10] ^= 1
+	if err := restore(corrupt); !errors.Is(err, ErrInvalidData) {
+		t.Fatalf("expected ErrInvalidData, got %v", err)
+	}
+	if err := restore([]byte("RBCX\x01\x00")); !errors.Is(err, ErrInvalidData) {
+		t.Fatalf("expected ErrInvalidData, got %v", err)
+	}
+	if err := restore([]byte("RBCP\x02\x00")); !errors.Is(err, ErrInvalidData) {
+		t.Fatalf("expected ErrInvalidData, got %v", err)
+	}
+	for _, length := range []int{3, 5, 8, len(valid) - 1} {
+		if err := restore(valid[:length]); err != io.ErrUnexpectedEOF {
+			t.Fatalf("expected io.ErrUnexpectedEOF at %d bytes, got %v", length, err)
+		}
+	}
+
+	// Nothing's left behind by the failures.
+	if stats := alloc.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,173 @@
+package rustybuffer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+)
+
+// ChecksumAlgorithm selects the checksum RBEntry.Checksum computes.
+type ChecksumAlgorithm int
+
+const (
+	// CRC32C (Castagnoli), as used by iSCSI, ext4 and many storage
+	// formats. The result fits in 32 bits.
+	ChecksumCRC32C ChecksumAlgorithm = iota + 1
+
+	// xxHash64 with a seed of zero. Not cryptographic, but much faster
+	// than anything that is.
+	ChecksumXXH64
+)
+
+func (algo ChecksumAlgorithm) String() string {
+	switch algo {
+	case ChecksumCRC32C:
+		return "crc32c"
+	case ChecksumXXH64:
+		return "xxh64"
+	default:
+		return fmt.Sprintf("ChecksumAlgorithm(%d)", int(algo))
+	}
+}
+
+// Below this many bytes in total checksumming in Go is quicker than
+// calling into the Rust library.
+const checksumNativeThreshold = 64 * 1024
+
+// Checksum computes algo over the entry's buffers in order, as if they
+// were one. Large entries are checksummed by the Rust library, in place,
+// using the CPU's CRC32C instruction where it has one, rather than
+// streaming the data back through Go.
+func (entry *RBEntry) Checksum(algo ChecksumAlgorithm) (uint64, error) {
+	if algo != ChecksumCRC32C && algo != ChecksumXXH64 {
+		return 0, fmt.Errorf("rustybuffer: unknown checksum algorithm %v", algo)
+	}
+
+	var size uint64 = 0
+	for _, buffer := range entry.Buffers {
+		size += uint64(len(buffer))
+	}
+
+	return checksumBuffers(algo, entry.Buffers, size), nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// goChecksum is Checksum in pure Go.
+func goChecksum(algo ChecksumAlgorithm, buffers [][]byte) uint64 {
+	if algo == ChecksumCRC32C {
+		var crc uint32 = 0
+		for _, buffer := range buffers {
+			crc = crc32.Update(crc, castagnoli, buffer)
+		}
+		return uint64(crc)
+	}
+
+	var state xxh64State
+	state.reset(0)
+	for _, buffer := range buffers {
+		state.update(buffer)
+	}
+	return state.digest()
+}
+
+const (
+	xxhPrime1 uint64 = 0x9E3779B185EBCA87
+	xxhPrime2 uint64 = 0xC2B2AE3D27D4EB4F
+	xxhPrime3 uint64 = 0x165667B19E3779F9
+	xxhPrime4 uint64 = 0x85EBCA77C2B2AE63
+	xxhPrime5 uint64 = 0x27D4EB2F165667C5
+)
+
+// xxh64State is the streaming xxHash64 from the Rust library's
+// checksum.rs, for the pure Go build and libraries without
+// CapabilityChecksum.
+type xxh64State struct {
+	total_len uint64
+	seed      uint64
+	v         [4]uint64
+	mem       [32]byte
+	mem_size  int
+}
+
+func xxh64Round(acc uint64, input uint64) uint64 {
+	return bits.RotateLeft64(acc+input*xxhPrime2, 31) * xxhPrime1
+}
+
+func xxh64MergeRound(acc uint64, val uint64) uint64 {
+	return (acc^xxh64Round(0, val))*xxhPrime1 + xxhPrime4
+}
+
+func (state *xxh64State) reset(seed uint64) {
+	*state = xxh64State{
+		seed: seed,
+		v:    [4]uint64{seed + xxhPrime1 + xxhPrime2, seed + xxhPrime2, seed, seed - xxhPrime1},
+	}
+}
+
+func (state *xxh64State) stripe(stripe []byte) {
+	for lane := range state.v {
+		state.v[lane] = xxh64Round(state.v[lane], binary.LittleEndian.Uint64(stripe[lane*8:]))
+	}
+}
+
+func (state *xxh64State) update(data []byte) {
+	state.total_len += uint64(len(data))
+
+	if state.mem_size+len(data) < len(state.mem) {
+		state.mem_size += copy(state.mem[state.mem_size:], data)
+		return
+	}
+
+	if state.mem_size > 0 {
+		data = data[copy(state.mem[state.mem_size:], data):]
+		state.stripe(state.mem[:])
+		state.mem_size = 0
+	}
+
+	for len(data) >= 32 {
+		state.stripe(data)
+		data = data[32:]
+	}
+
+	state.mem_size = copy(state.mem[:], data)
+}
+
+func (state *xxh64State) digest() uint64 {
+	var hash uint64
+	if state.total_len >= 32 {
+		v := state.v
+		hash = bits.RotateLeft64(v[0], 1) + bits.RotateLeft64(v[1], 7) +
+			bits.RotateLeft64(v[2], 12) + bits.RotateLeft64(v[3], 18)
+		for _, lane := range v {
+			hash = xxh64MergeRound(hash, lane)
+		}
+	} else {
+		hash = state.seed + xxhPrime5
+	}
+	hash += state.total_len
+
+	rest := state.mem[:state.mem_size]
+	for ; len(rest) >= 8; rest = rest[8:] {
+		hash ^= xxh64Round(0, binary.LittleEndian.Uint64(rest))
+		hash = bits.RotateLeft64(hash, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(rest) >= 4 {
+		hash ^= uint64(binary.LittleEndian.Uint32(rest)) * xxhPrime1
+		hash = bits.RotateLeft64(hash, 23)*xxhPrime2 + xxhPrime3
+		rest = rest[4:]
+	}
+	for _, b := range rest {
+		hash ^= uint64(b) * xxhPrime5
+		hash = bits.RotateLeft64(hash, 11) * xxhPrime1
+	}
+
+	hash ^= hash >> 33
+	hash *= xxhPrime2
+	hash ^= hash >> 29
+	hash *= xxhPrime3
+	hash ^= hash >> 32
+
+	return hash
+}
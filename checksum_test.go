@@ -0,0 +1,84 @@
+package rustybuffer
+
+import (
+	"testing"
+)
+
+func TestChecksumVectors(t *testing.T) {
+	vectors := []struct {
+		algo     ChecksumAlgorithm
+		input    string
+		expected uint64
+	}{
+		{ChecksumCRC32C, "", 0},
+		{ChecksumCRC32C, "123456789", 0xE3069283},
+		{ChecksumXXH64, "", 0xEF46DB3751D8E999},
+		{ChecksumXXH64, "a", 0xD24EC4F1A98C6E5B},
+		{ChecksumXXH64, "abc", 0x44BC2CF5AD770999},
+		{ChecksumXXH64, "Nobody inspects the spammish repetition", 0xFBCEA83C8A378BF1},
+	}
+
+	for _, vector := range vectors {
+		entry := RBEntry{Buffers: [][]uint8{[]byte(vector.input)}}
+		sum, err := entry.Checksum(vector.algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != vector.expected {
+			t.Fatalf("%v(%q) = %#x, expected %#x", vector.algo, vector.input, sum, vector.expected)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<24, 1<<24)))
+
+	// Buffers that don't line up with xxHash's 32 byte stripes, big enough
+	// to be checksummed by the library.
+	sizes := []uint64{5, 0, checksumNativeThreshold + 27, 40, 1}
+	entry, err := pool.AllocBuffers(sizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	var whole []byte
+	for idx, buffer := range entry.Buffers {
+		for pos := range buffer {
+			buffer[pos] = byte(idx*31 + pos*7)
+		}
+		whole = append(whole, buffer...)
+	}
+
+	for _, algo := range []ChecksumAlgorithm{ChecksumCRC32C, ChecksumXXH64} {
+		sum, err := entry.Checksum(algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := goChecksum(algo, [][]byte{whole}); sum != expected {
+			t.Fatalf("%v over the buffers is %#x, over one copy %#x", algo, sum, expected)
+		}
+	}
+
+	if _, err := entry.Checksum(ChecksumAlgorithm(0)); err == nil {
+		t.Fatal("expected an error for an unknown algorithm")
+	}
+}
+
+func BenchmarkChecksum(b *testing.B) {
+	buffers := [][]byte{make([]byte, 64<<20)}
+	for _, algo := range []ChecksumAlgorithm{ChecksumCRC32C, ChecksumXXH64} {
+		b.Run(algo.String(), func(b *testing.B) {
+			b.SetBytes(int64(len(buffers[0])))
+			for i := 0; i < b.N; i++ {
+				checksumBuffers(algo, buffers, uint64(len(buffers[0])))
+			}
+		})
+		b.Run(algo.String()+"-go", func(b *testing.B) {
+			b.SetBytes(int64(len(buffers[0])))
+			for i := 0; i < b.N; i++ {
+				goChecksum(algo, buffers)
+			}
+		})
+	}
+}
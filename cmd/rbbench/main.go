@@ -0,0 +1,52 @@
+// Command rbbench answers "is this faster than make([]byte, n)?" by running
+// the same workload against the Rust library, malloc, the Go heap via
+// NewHeapAllocator and plain make, and printing a comparison including the
+// garbage collection each one caused.
+//
+//	rbbench -sizes 4096,65536,1048576 -iterations 1000000 -goroutines 8
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/davisp/rustybuffer"
+)
+
+func main() {
+	max_total_size := flag.Uint64("max-total-size", 1<<30, "each pool's total size limit")
+	max_buffer_size := flag.Uint64("max-buffer-size", 10<<20, "each pool's buffer size limit")
+	sizes := flag.String("sizes", "4096,65536,1048576", "comma separated buffer sizes to cycle through")
+	iterations := flag.Int("iterations", 100000, "total acquires per backend")
+	goroutines := flag.Int("goroutines", 4, "concurrent workers")
+	hold := flag.Int("hold", 8, "buffers each worker keeps alive")
+	touch := flag.Bool("touch", true, "write to every page of each buffer")
+	flag.Parse()
+
+	var workload = rustybuffer.Workload{
+		Iterations: *iterations,
+		Goroutines: *goroutines,
+		Hold:       *hold,
+		Touch:      *touch,
+	}
+	for _, size := range strings.Split(*sizes, ",") {
+		parsed, err := strconv.ParseUint(strings.TrimSpace(size), 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbbench: invalid size %q: %v\n", size, err)
+			os.Exit(2)
+		}
+		workload.Sizes = append(workload.Sizes, parsed)
+	}
+
+	rustybuffer.Configure(*max_total_size, *max_buffer_size)
+	rustybuffer.RegisterBenchmarkBackend("malloc", rustybuffer.NewPool(
+		rustybuffer.WithAllocator(rustybuffer.NewMallocAllocator(*max_total_size, *max_buffer_size))))
+	rustybuffer.RegisterBenchmarkBackend("heap", rustybuffer.NewPool(
+		rustybuffer.WithAllocator(rustybuffer.NewHeapAllocator(*max_total_size, *max_buffer_size))))
+
+	report := rustybuffer.Benchmark(rustybuffer.NewPool(), workload)
+	report.WriteTo(os.Stdout)
+}
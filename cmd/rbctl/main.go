@@ -0,0 +1,192 @@
+// Command rbctl inspects and adjusts the pools of a running process that
+// serves a control socket with rustybuffer.ListenControl.
+//
+//	rbctl -socket /run/app/rustybuffer.sock stats
+//	rbctl -socket /run/app/rustybuffer.sock -n 5 -oldest holders cache
+//	rbctl -socket /run/app/rustybuffer.sock trim
+//	rbctl -socket /run/app/rustybuffer.sock watermarks cache 0.6 0.8
+//	rbctl -socket /run/app/rustybuffer.sock force-release cache '#42' "indexer wedged, INC-123"
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/davisp/rustybuffer"
+)
+
+const usage = `usage: rbctl -socket PATH [flags] COMMAND [ARGS]
+
+commands:
+  stats [POOL]                 each pool's stats
+  holders [POOL]               where each pool's live entries were acquired
+  locks [POOL]                 each pool's held and waited for buffer locks
+  entries [POOL]               each pool's entries holding memory, and their pins
+  trim [POOL]                  give cached memory back to the OS
+  watermarks POOL SOFT HARD    move a pool's watermarks
+  force-release POOL ENTRY REASON
+                               release an entry however it's pinned, logging
+                               why; only for pools that allow it
+
+flags:
+`
+
+// controller runs commands against a control socket.
+type controller struct {
+	socket string
+	limit  int
+	oldest bool
+}
+
+func main() {
+	flags := flag.NewFlagSet("rbctl", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	socket := flags.String("socket", "", "the process's control socket")
+	limit := flags.Int("n", 10, "holders to list per pool, zero for all")
+	oldest := flags.Bool("oldest", false, "list the oldest holders first rather than the largest")
+	flags.Parse(os.Args[1:])
+
+	if *socket == "" || flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctl := controller{*socket, *limit, *oldest}
+	if err := ctl.run(flags.Args(), os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "rbctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func (ctl controller) run(args []string, out io.Writer) error {
+	request, err := parseRequest(args)
+	if err != nil {
+		return err
+	}
+	response, err := rustybuffer.Control(ctl.socket, request)
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer table.Flush()
+
+	switch request.Command {
+	case "stats":
+		fmt.Fprintln(table, "POOL\tIN USE\tALLOCATED\tLIMIT\tBUFFERS\tAVAILABLE\tFALLBACK\tSPILLED")
+		for _, name := range sortedKeys(response.Stats) {
+			stats := response.Stats[name]
+			fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", name,
+				stats.BytesInUse, stats.BytesAllocated, stats.MaxTotalSize,
+				stats.NumBuffers, stats.NumAvailable, stats.FallbackBytes, stats.SpilledBytes)
+		}
+	case "holders":
+		fmt.Fprintln(table, "POOL\tBYTES\tENTRIES\tOLDEST\tSITE")
+		for _, name := range sortedKeys(response.Holders) {
+			holders := response.Holders[name]
+			if ctl.oldest {
+				sort.SliceStable(holders, func(i, j int) bool {
+					return holders[i].Oldest.Before(holders[j].Oldest)
+				})
+			}
+			if ctl.limit > 0 && len(holders) > ctl.limit {
+				holders = holders[:ctl.limit]
+			}
+			for _, holder := range holders {
+				age := time.Since(holder.Oldest).Truncate(time.Millisecond)
+				fmt.Fprintf(table, "%s\t%d\t%d\t%v\t%s\n", name,
+					holder.Bytes, holder.Entries, age, holder.Site)
+			}
+		}
+	case "locks":
+		fmt.Fprintln(table, "POOL\tENTRY\tBUFFER\tREADERS\tWRITER\tWAITING\tHELD FOR")
+		for _, name := range sortedKeys(response.Locks) {
+			for _, lock := range response.Locks[name] {
+				held := "-"
+				if !lock.Since.IsZero() {
+					held = time.Since(lock.Since).Truncate(time.Millisecond).String()
+				}
+				fmt.Fprintf(table, "%s\t%s\t%d\t%d\t%t\t%d\t%s\n", name, lock.Entry, lock.Buffer,
+					lock.Readers, lock.Writer, lock.WaitingReaders+lock.WaitingWriters, held)
+			}
+		}
+	case "entries":
+		fmt.Fprintln(table, "POOL\tENTRY\tBYTES\tPINS\tVIEWS\tRELEASED\tLABELS")
+		for _, name := range sortedKeys(response.Entries) {
+			for _, entry := range response.Entries[name] {
+				labels := make([]string, 0, len(entry.Labels))
+				for _, key := range sortedKeys(entry.Labels) {
+					labels = append(labels, key+"="+entry.Labels[key])
+				}
+				fmt.Fprintf(table, "%s\t%s\t%d\t%d\t%d\t%t\t%s\n", name, entry.Entry, entry.Bytes,
+					entry.Pins, entry.Views, entry.Released, strings.Join(labels, ","))
+			}
+		}
+	case "trim":
+		fmt.Fprintf(table, "freed %d bytes\n", response.BytesFreed)
+	case "force-release":
+		fmt.Fprintf(table, "released %s from %s\n", request.Entry, request.Pool)
+	}
+	return nil
+}
+
+// parseRequest turns a command line into a request.
+func parseRequest(args []string) (rustybuffer.ControlRequest, error) {
+	request := rustybuffer.ControlRequest{Command: args[0]}
+	switch request.Command {
+	case "stats", "holders", "locks", "entries", "trim":
+		if len(args) > 2 {
+			return request, fmt.Errorf("%s takes at most a pool", request.Command)
+		}
+		if len(args) == 2 {
+			request.Pool = args[1]
+		}
+	case "watermarks":
+		if len(args) != 4 {
+			return request, fmt.Errorf("watermarks takes a pool and the soft and hard watermarks")
+		}
+		request.Pool = args[1]
+		soft, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return request, fmt.Errorf("soft watermark %q: %w", args[2], err)
+		}
+		hard, err := strconv.ParseFloat(args[3], 64)
+		if err != nil {
+			return request, fmt.Errorf("hard watermark %q: %w", args[3], err)
+		}
+		request.Soft, request.Hard = soft, hard
+	case "force-release":
+		if len(args) < 4 {
+			return request, fmt.Errorf("force-release takes a pool, an entry and why")
+		}
+		request.Pool = args[1]
+		entry, err := strconv.ParseUint(strings.TrimPrefix(args[2], "#"), 10, 64)
+		if err != nil || entry == 0 {
+			return request, fmt.Errorf("entry %q isn't a handle", args[2])
+		}
+		request.Entry = rustybuffer.Handle(entry)
+		request.Reason = strings.Join(args[3:], " ")
+	default:
+		return request, fmt.Errorf("unknown command %q", request.Command)
+	}
+	return request, nil
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
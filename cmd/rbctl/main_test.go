@@ -0,0 +1,108 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/davisp/rustybuffer"
+)
+
+func TestParseRequest(t *testing.T) {
+	request, err := parseRequest([]string{"watermarks", "cache", "0.6", "0.8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := rustybuffer.ControlRequest{Command: "watermarks", Pool: "cache", Soft: 0.6, Hard: 0.8}
+	if request != expected {
+		t.Fatalf("expected %+v, got %+v", expected, request)
+	}
+
+	request, err = parseRequest([]string{"force-release", "cache", "#42", "indexer", "wedged"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = rustybuffer.ControlRequest{Command: "force-release", Pool: "cache", Entry: 42, Reason: "indexer wedged"}
+	if request != expected {
+		t.Fatalf("expected %+v, got %+v", expected, request)
+	}
+
+	for _, args := range [][]string{
+		{"stats", "one", "two"},
+		{"force-release", "cache", "#42"},
+		{"force-release", "cache", "entry", "wedged"},
+		{"watermarks", "cache"},
+		{"watermarks", "cache", "high", "0.8"},
+		{"explode"},
+	} {
+		if _, err := parseRequest(args); err == nil {
+			t.Fatalf("%q should be rejected", args)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control")
+	pool := rustybuffer.NewPool(
+		rustybuffer.WithAllocator(rustybuffer.NewHeapAllocator(1000, 1000)),
+		rustybuffer.WithWatermarks(0.5, 0.9),
+		rustybuffer.WithCallSiteStats(),
+		rustybuffer.WithEntryDiagnostics(),
+	)
+	server, err := rustybuffer.ListenControl(path, map[string]*rustybuffer.Pool{"cache": pool})
+	if err != nil {
+		t.Skipf("can't listen on a unix socket: %v", err)
+	}
+	defer server.Close()
+
+	entry, err := pool.AllocBuffers([]uint64{300}, rustybuffer.WithLabels(map[string]string{"owner": "indexer"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	ctl := controller{socket: path, limit: 10}
+	var out strings.Builder
+	if err := ctl.run([]string{"stats"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 ||
+		strings.Join(strings.Fields(lines[1]), " ") != "cache 300 300 1000 1 0 0 0" {
+		t.Fatalf("unexpected stats:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := ctl.run([]string{"holders", "cache"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "TestRun") {
+		t.Fatalf("unexpected holders:\n%s", out.String())
+	}
+
+	entry.Lock(0)
+	out.Reset()
+	if err := ctl.run([]string{"locks"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 ||
+		!strings.HasPrefix(strings.Join(strings.Fields(lines[1]), " "), "cache "+entry.Handle().String()+" 0 0 true 0 ") {
+		t.Fatalf("unexpected locks:\n%s", out.String())
+	}
+	entry.Unlock(0)
+
+	out.Reset()
+	if err := ctl.run([]string{"entries", "cache"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 ||
+		strings.Join(strings.Fields(lines[1]), " ") != "cache "+entry.Handle().String()+" 300 0 0 false owner=indexer" {
+		t.Fatalf("unexpected entries:\n%s", out.String())
+	}
+	if err := ctl.run([]string{"force-release", "cache", entry.Handle().String(), "testing"}, &out); err == nil {
+		t.Fatal("expected force release to be refused")
+	}
+
+	if err := ctl.run([]string{"watermarks", "cache", "0.9", "0.5"}, &out); err == nil {
+		t.Fatal("expected inverted watermarks to be refused")
+	}
+}
@@ -0,0 +1,237 @@
+// Command rbstress runs a concurrent acquire/release workload against a
+// pool and reports throughput, acquire latency and fragmentation, to check
+// a configuration holds up before it goes anywhere near production.
+//
+//	rbstress -goroutines 64 -sizes uniform:4096-1048576 -hold 1ms -duration 30s
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davisp/rustybuffer"
+)
+
+// sizeDist draws request sizes.
+type sizeDist func(rng *rand.Rand) uint64
+
+// parseSizes understands fixed:N, uniform:MIN-MAX and exp:MEAN.
+func parseSizes(spec string) (sizeDist, error) {
+	kind, args, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("size distribution %q should look like kind:args", spec)
+	}
+
+	switch kind {
+	case "fixed":
+		size, err := strconv.ParseUint(args, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("fixed size %q: %w", args, err)
+		}
+		return func(rng *rand.Rand) uint64 { return size }, nil
+	case "uniform":
+		lo_str, hi_str, ok := strings.Cut(args, "-")
+		if !ok {
+			return nil, fmt.Errorf("uniform sizes %q should look like MIN-MAX", args)
+		}
+		lo, err := strconv.ParseUint(lo_str, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("uniform minimum %q: %w", lo_str, err)
+		}
+		hi, err := strconv.ParseUint(hi_str, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("uniform maximum %q: %w", hi_str, err)
+		}
+		if hi < lo {
+			return nil, fmt.Errorf("uniform maximum %d is less than the minimum %d", hi, lo)
+		}
+		return func(rng *rand.Rand) uint64 {
+			return lo + uint64(rng.Int63n(int64(hi-lo+1)))
+		}, nil
+	case "exp":
+		mean, err := strconv.ParseFloat(args, 64)
+		if err != nil || mean <= 0 {
+			return nil, fmt.Errorf("exponential mean %q must be a positive number", args)
+		}
+		return func(rng *rand.Rand) uint64 {
+			return uint64(rng.ExpFloat64()*mean) + 1
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown size distribution %q", kind)
+	}
+}
+
+func newAllocator(name string, max_total_size uint64, max_buffer_size uint64) (rustybuffer.Allocator, error) {
+	switch name {
+	case "rust":
+		rustybuffer.Configure(max_total_size, max_buffer_size)
+		return rustybuffer.NewRustAllocator(), nil
+	case "malloc":
+		return rustybuffer.NewMallocAllocator(max_total_size, max_buffer_size), nil
+	case "heap":
+		return rustybuffer.NewHeapAllocator(max_total_size, max_buffer_size), nil
+	case "deterministic":
+		return rustybuffer.NewDeterministicAllocator(max_total_size, max_buffer_size, 1), nil
+	default:
+		return nil, fmt.Errorf("unknown allocator %q", name)
+	}
+}
+
+type workload struct {
+	pool       *rustybuffer.Pool
+	sizes      sizeDist
+	goroutines int
+	hold       time.Duration
+	duration   time.Duration
+	seed       int64
+}
+
+type result struct {
+	acquires  int
+	exhausted int
+	failed    int
+	latencies []time.Duration
+	elapsed   time.Duration
+
+	// Peak and end-of-run pool state.
+	peak  rustybuffer.Stats
+	final rustybuffer.Stats
+}
+
+func (load workload) run() result {
+	var mu sync.Mutex
+	var res result
+	var wg sync.WaitGroup
+
+	deadline := time.Now().Add(load.duration)
+	start := time.Now()
+
+	for idx := 0; idx < load.goroutines; idx++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+
+			var local result
+			for time.Now().Before(deadline) {
+				size := load.sizes(rng)
+
+				begin := time.Now()
+				entry, err := load.pool.AllocBuffers([]uint64{size})
+				local.latencies = append(local.latencies, time.Since(begin))
+				local.acquires++
+
+				if errors.Is(err, rustybuffer.ErrNoBufferAvailable) {
+					local.exhausted++
+					continue
+				} else if err != nil {
+					local.failed++
+					continue
+				}
+
+				if load.hold > 0 {
+					time.Sleep(time.Duration(rng.Int63n(int64(load.hold)*2 + 1)))
+				}
+
+				stats := load.pool.Stats()
+				entry.Release()
+
+				if stats.BytesInUse > local.peak.BytesInUse {
+					local.peak = stats
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.acquires += local.acquires
+			res.exhausted += local.exhausted
+			res.failed += local.failed
+			res.latencies = append(res.latencies, local.latencies...)
+			if local.peak.BytesInUse > res.peak.BytesInUse {
+				res.peak = local.peak
+			}
+		}(rand.New(rand.NewSource(load.seed + int64(idx))))
+	}
+
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	res.final = load.pool.Stats()
+
+	return res
+}
+
+func percentile(sorted []time.Duration, pct float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * pct / 100)
+	return sorted[idx]
+}
+
+func (res result) report(out *os.File) {
+	sort.Slice(res.latencies, func(i, j int) bool {
+		return res.latencies[i] < res.latencies[j]
+	})
+
+	seconds := res.elapsed.Seconds()
+	fmt.Fprintf(out, "acquires:    %d (%.0f/s)\n", res.acquires, float64(res.acquires)/seconds)
+	fmt.Fprintf(out, "exhausted:   %d\n", res.exhausted)
+	fmt.Fprintf(out, "failed:      %d\n", res.failed)
+	fmt.Fprintf(out, "latency:     p50 %v  p90 %v  p99 %v  p99.9 %v  max %v\n",
+		percentile(res.latencies, 50),
+		percentile(res.latencies, 90),
+		percentile(res.latencies, 99),
+		percentile(res.latencies, 99.9),
+		percentile(res.latencies, 100))
+	fmt.Fprintf(out, "peak:        %d bytes in use of %d allocated\n",
+		res.peak.BytesInUse, res.peak.BytesAllocated)
+
+	// Once everything is released, what's left is the allocator's cache.
+	fmt.Fprintf(out, "at rest:     %d bytes allocated in %d buffers (%d available)\n",
+		res.final.BytesAllocated, res.final.NumBuffers, res.final.NumAvailable)
+	if res.peak.BytesAllocated > 0 {
+		fmt.Fprintf(out, "fragmentation: %.1f%% of allocated bytes unused at peak\n",
+			100*(1-float64(res.peak.BytesInUse)/float64(res.peak.BytesAllocated)))
+	}
+}
+
+func main() {
+	allocator := flag.String("allocator", "rust", "rust, malloc, heap or deterministic")
+	max_total_size := flag.Uint64("max-total-size", 1<<30, "the pool's total size limit")
+	max_buffer_size := flag.Uint64("max-buffer-size", 10<<20, "the pool's buffer size limit")
+	sizes := flag.String("sizes", "uniform:1024-1048576", "fixed:N, uniform:MIN-MAX or exp:MEAN")
+	goroutines := flag.Int("goroutines", 16, "concurrent workers")
+	hold := flag.Duration("hold", time.Millisecond, "mean time each entry is held")
+	duration := flag.Duration("duration", 10*time.Second, "how long to run for")
+	seed := flag.Int64("seed", 1, "seed for the size and hold time draws")
+	flag.Parse()
+
+	dist, err := parseSizes(*sizes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rbstress:", err)
+		os.Exit(2)
+	}
+
+	alloc, err := newAllocator(*allocator, *max_total_size, *max_buffer_size)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rbstress:", err)
+		os.Exit(2)
+	}
+
+	load := workload{
+		pool:       rustybuffer.NewPool(rustybuffer.WithAllocator(alloc)),
+		sizes:      dist,
+		goroutines: *goroutines,
+		hold:       *hold,
+		duration:   *duration,
+		seed:       *seed,
+	}
+	load.run().report(os.Stdout)
+}
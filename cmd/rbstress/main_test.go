@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/davisp/rustybuffer"
+)
+
+func TestParseSizes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	fixed, err := parseSizes("fixed:4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixed(rng) != 4096 {
+		t.Fatal("fixed size isn't fixed")
+	}
+
+	uniform, err := parseSizes("uniform:10-20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := 0; idx < 1000; idx++ {
+		if size := uniform(rng); size < 10 || size > 20 {
+			t.Fatalf("uniform size %d out of range", size)
+		}
+	}
+
+	if _, err := parseSizes("exp:1024"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, spec := range []string{"fixed", "uniform:20-10", "exp:-1", "normal:1"} {
+		if _, err := parseSizes(spec); err == nil {
+			t.Fatalf("%q should be rejected", spec)
+		}
+	}
+}
+
+func TestWorkload(t *testing.T) {
+	sizes, _ := parseSizes("uniform:1-4096")
+	load := workload{
+		pool:       rustybuffer.NewPool(rustybuffer.WithAllocator(rustybuffer.NewHeapAllocator(1<<16, 1<<12))),
+		sizes:      sizes,
+		goroutines: 4,
+		duration:   20 * time.Millisecond,
+		seed:       1,
+	}
+
+	res := load.run()
+	if res.acquires == 0 || len(res.latencies) != res.acquires || res.failed != 0 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if res.final.BytesInUse != 0 {
+		t.Fatalf("entries left behind: %+v", res.final)
+	}
+}
@@ -0,0 +1,34 @@
+package rustybuffer
+
+// Below this many bytes comparing in Go is quicker than calling into the
+// Rust library.
+const compareNativeThreshold = 64 * 1024
+
+// Equal reports whether a and b hold the same bytes. Large views are
+// compared by the Rust library, with SIMD where the CPU has it, directly
+// in pooled memory.
+func Equal(a View, b View) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	return Compare(a, b) == 0
+}
+
+// Compare compares a and b lexicographically, returning -1, 0 or 1 like
+// bytes.Compare.
+func Compare(a View, b View) int {
+	return compareBytes(a.Bytes(), b.Bytes())
+}
+
+// ConstantTimeEqual reports whether a and b hold the same bytes, taking
+// time that depends only on their lengths, never on their contents, so
+// MACs and tokens in pooled memory can be checked without copying them
+// onto the Go heap. Views of different lengths return false immediately.
+// The comparison is done by the Rust library, which doesn't branch on the
+// data, or by crypto/subtle where the library can't.
+func ConstantTimeEqual(a View, b View) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	return constantTimeEqual(a.Bytes(), b.Bytes())
+}
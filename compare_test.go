@@ -0,0 +1,95 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<24, 1<<24)))
+
+	// Both sides of the threshold for calling into the library.
+	for _, size := range []uint64{100, compareNativeThreshold * 2} {
+		entry, err := pool.AllocBuffers([]uint64{size, size})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, b := entry.View(0), entry.View(1)
+		if !Equal(a, b) || Compare(a, b) != 0 {
+			t.Fatalf("%d: zeroed buffers differ", size)
+		}
+
+		b.Bytes()[size-1] = 1
+		if Equal(a, b) || Compare(a, b) != -1 || Compare(b, a) != 1 {
+			t.Fatalf("%d: a difference in the last byte wasn't found", size)
+		}
+
+		if Equal(a, b.Slice(0, int(size)-1)) {
+			t.Fatalf("%d: views of different lengths are equal", size)
+		}
+		if Compare(a.Slice(0, int(size)-1), a) != -1 {
+			t.Fatalf("%d: a prefix doesn't compare as less", size)
+		}
+
+		if !Equal(a, ViewOf(make([]byte, size))) {
+			t.Fatalf("%d: not equal to a Go slice of zeros", size)
+		}
+
+		entry.Release()
+	}
+
+	if !Equal(View{}, ViewOf(nil)) {
+		t.Fatal("empty views differ")
+	}
+}
+
+func TestConstantTimeEqual(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	entry, err := pool.AllocBuffers([]uint64{32, 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	mac, other := entry.View(0), entry.View(1)
+	copy(mac.Bytes(), "0123456789abcdef0123456789abcdef")
+	copy(other.Bytes(), mac.Bytes())
+	if !ConstantTimeEqual(mac, other) {
+		t.Fatal("equal views differ")
+	}
+
+	for _, idx := range []int{0, 17, 31} {
+		other.Bytes()[idx] ^= 0x80
+		if ConstantTimeEqual(mac, other) {
+			t.Fatalf("a difference at byte %d wasn't found", idx)
+		}
+		other.Bytes()[idx] ^= 0x80
+	}
+
+	if ConstantTimeEqual(mac, other.Slice(0, 31)) {
+		t.Fatal("views of different lengths are equal")
+	}
+	if !ConstantTimeEqual(View{}, ViewOf([]byte{})) {
+		t.Fatal("empty views differ")
+	}
+}
+
+func BenchmarkCompare(b *testing.B) {
+	x := bytes.Repeat([]byte{1}, 64<<20)
+	y := bytes.Repeat([]byte{1}, 64<<20)
+
+	b.Run("library", func(b *testing.B) {
+		b.SetBytes(int64(len(x)))
+		for i := 0; i < b.N; i++ {
+			compareBytes(x, y)
+		}
+	})
+	b.Run("go", func(b *testing.B) {
+		b.SetBytes(int64(len(x)))
+		for i := 0; i < b.N; i++ {
+			bytes.Compare(x, y)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package rustybuffer
+
+import "fmt"
+
+// Compression selects the format Compress and Decompress use.
+type Compression int
+
+const (
+	// An LZ4 frame, as written by the lz4 command line tool, recording
+	// its decompressed size so Decompress knows how much to acquire.
+	CompressionLZ4 Compression = iota + 1
+)
+
+func (algo Compression) String() string {
+	switch algo {
+	case CompressionLZ4:
+		return "lz4"
+	default:
+		return fmt.Sprintf("Compression(%d)", int(algo))
+	}
+}
+
+// Compress is Compress on the default pool.
+func Compress(algo Compression, src View) (RBEntry, error) {
+	return defaultPool.Compress(algo, src)
+}
+
+// Decompress is Decompress on the default pool.
+func Decompress(algo Compression, src View) (RBEntry, error) {
+	return defaultPool.Decompress(algo, src)
+}
+
+// Compress compresses src into a new entry from the pool, with a single
+// buffer holding the compressed data. The entry is acquired at the most
+// the data could compress to, so it may hold more memory than the buffer
+// shows. Both sides stay in pooled memory, the Rust library does the work
+// in place.
+func (pool *Pool) Compress(algo Compression, src View) (RBEntry, error) {
+	if err := checkCompression(algo); err != nil {
+		return RBEntry{}, err
+	}
+
+	data := src.Bytes()
+	bound, err := compressBound(uint64(len(data)))
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	entry, err := pool.AllocBuffers([]uint64{bound})
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	size, err := compressInto(data, entry.Buffers[0])
+	if err != nil {
+		entry.Release()
+		return RBEntry{}, err
+	}
+
+	entry.Buffers[0] = entry.Buffers[0][:size:size]
+	return entry, nil
+}
+
+// Decompress decompresses src into a new entry from the pool, sized from
+// what the compressed data says it holds. Corrupt data fails with
+// ErrInvalidData.
+func (pool *Pool) Decompress(algo Compression, src View) (RBEntry, error) {
+	if err := checkCompression(algo); err != nil {
+		return RBEntry{}, err
+	}
+
+	data := src.Bytes()
+	size, err := decompressedSize(data)
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	entry, err := pool.AllocBuffers([]uint64{size})
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	if _, err := decompressInto(data, entry.Buffers[0]); err != nil {
+		entry.Release()
+		return RBEntry{}, err
+	}
+
+	return entry, nil
+}
+
+// checkCompression makes sure algo is known and the library can do it.
+// LZ4 is the only format and is implemented in the Rust library without
+// any dependencies, so it isn't available in builds without cgo.
+func checkCompression(algo Compression) error {
+	if algo != CompressionLZ4 {
+		return fmt.Errorf("rustybuffer: unknown compression %v", algo)
+	}
+
+	info, err := LibraryInfo()
+	if err != nil {
+		return err
+	}
+	if !info.Has(CapabilityLZ4) {
+		return fmt.Errorf("rustybuffer: library %s can't compress with %v", info.Version, algo)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<26, 1<<26)))
+
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), 100_000)
+	if info, _ := LibraryInfo(); !info.Has(CapabilityLZ4) {
+		if _, err := pool.Compress(CompressionLZ4, ViewOf(text)); err == nil {
+			t.Fatal("expected an error without an LZ4 capable library")
+		}
+		t.Skip("the library can't compress")
+	}
+
+	for _, src := range [][]byte{nil, []byte("hello"), text} {
+		compressed, err := pool.Compress(CompressionLZ4, ViewOf(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decompressed, err := pool.Decompress(CompressionLZ4, compressed.View(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decompressed.Buffers[0], src) {
+			t.Fatalf("%d bytes didn't survive a round trip", len(src))
+		}
+
+		compressed.Release()
+		decompressed.Release()
+	}
+
+	compressed, err := pool.Compress(CompressionLZ4, ViewOf(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer compressed.Release()
+	if len(compressed.Buffers[0]) > len(text)/10 {
+		t.Fatalf("%d bytes only compressed to %d", len(text), len(compressed.Buffers[0]))
+	}
+
+	corrupt := bytes.Clone(compressed.Buffers[0])
+	corrupt[len(corrupt)/2] ^= 0xFF
+	if _, err = pool.Decompress(CompressionLZ4, ViewOf(corrupt)); !errors.Is(err, ErrInvalidData) {
+		t.Fatalf("expected ErrInvalidData, got %v", err)
+	}
+	if _, err = pool.Decompress(CompressionLZ4, ViewOf([]byte("nope"))); !errors.Is(err, ErrInvalidData) {
+		t.Fatalf("expected ErrInvalidData, got %v", err)
+	}
+
+	if stats := pool.Stats(); stats.NumBuffers != 1 {
+		t.Fatalf("a failed decompress leaked: %+v", stats)
+	}
+
+	if _, err = pool.Compress(Compression(0), ViewOf(text)); err == nil {
+		t.Fatal("expected an error for an unknown compression")
+	}
+}
+
+func TestCompressLZ4Tool(t *testing.T) {
+	if info, _ := LibraryInfo(); !info.Has(CapabilityLZ4) {
+		t.Skip("the library can't compress")
+	}
+
+	// What the lz4 command writes for an empty file.
+	frame := []byte{0x04, 0x22, 0x4D, 0x18, 0x64, 0x40, 0xA7, 0, 0, 0, 0, 0x05, 0x5D, 0xCC, 0x02}
+	if _, err := Decompress(CompressionLZ4, ViewOf(frame)); !errors.Is(err, ErrInvalidData) {
+		t.Fatalf("expected ErrInvalidData for a frame without its size, got %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package rustybuffer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// A control socket speaks newline delimited JSON: each line a client
+// writes is a ControlRequest, answered by a line holding a
+// ControlResponse, until the client hangs up.
+
+// ControlRequest is a command sent to a control socket, see ListenControl.
+type ControlRequest struct {
+	// "stats", "holders", "locks", "entries", "trim", "watermarks" or
+	// "force-release".
+	Command string `json:"command"`
+
+	// The pool the command is for, or every pool if empty. Only
+	// "watermarks" and "force-release" need one.
+	Pool string `json:"pool,omitempty"`
+
+	// The watermarks "watermarks" sets, see Pool.SetWatermarks.
+	Soft float64 `json:"soft,omitempty"`
+	Hard float64 `json:"hard,omitempty"`
+
+	// The entry "force-release" releases, and why, which it requires,
+	// see Pool.ForceRelease.
+	Entry  Handle `json:"entry,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ControlResponse is a control socket's answer to a ControlRequest.
+type ControlResponse struct {
+	// Set if the command failed.
+	Error string `json:"error,omitempty"`
+
+	// Each pool's stats, for "stats".
+	Stats map[string]Stats `json:"stats,omitempty"`
+
+	// Each pool's call site stats, for "holders". Pools created without
+	// WithCallSiteStats have none.
+	Holders map[string][]CallSiteStats `json:"holders,omitempty"`
+
+	// Each pool's held and waited for buffer locks, for "locks".
+	Locks map[string][]BufferLockState `json:"locks,omitempty"`
+
+	// Each pool's entries holding memory, for "entries". Pools created
+	// without WithEntryDiagnostics have none.
+	Entries map[string][]EntryDiagnostic `json:"entries,omitempty"`
+
+	// The bytes given back to the OS, for "trim".
+	BytesFreed uint64 `json:"bytes_freed,omitempty"`
+}
+
+// ListenControl serves a control socket for pools, by name, at path, for
+// operators who can't attach a debugger to inspect and adjust a running
+// process (see cmd/rbctl). The socket is only accessible to the user the
+// process runs as. Close stops serving and removes it.
+func ListenControl(path string, pools map[string]*Pool) (io.Closer, error) {
+	fail := func(err error) (io.Closer, error) {
+		return nil, fmt.Errorf("rustybuffer: listening for control connections: %w", err)
+	}
+
+	// The socket's made in a fresh directory only this user can get into,
+	// and only linked in at path once nobody else can connect to it. Like
+	// listening there would, linking fails if something's already there.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".rbcontrol")
+	if err != nil {
+		return fail(err)
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "control")
+	listener, err := net.Listen("unix", private)
+	if err != nil {
+		return fail(err)
+	}
+	if err = os.Chmod(private, 0o600); err == nil {
+		err = os.Link(private, path)
+	}
+	if err != nil {
+		listener.Close()
+		return fail(err)
+	}
+
+	go ServeControl(listener, pools)
+	return &controlListener{listener, path}, nil
+}
+
+// controlListener removes the control socket from where it was linked in
+// once it's closed.
+type controlListener struct {
+	listener net.Listener
+	path     string
+}
+
+func (listener *controlListener) Close() error {
+	err := listener.listener.Close()
+	os.Remove(listener.path)
+	return err
+}
+
+// ServeControl serves control connections accepted from listener until
+// it's closed, for a control socket somewhere other than a unix socket.
+// Whoever can connect can trim and adjust the pools, and force the
+// release of entries from those created WithForceRelease.
+func ServeControl(listener net.Listener, pools map[string]*Pool) error {
+	server := &controlServer{pools: pools}
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go server.serve(conn)
+	}
+}
+
+type controlServer struct {
+	pools map[string]*Pool
+
+	// Commands are run one at a time, whichever connection they're from.
+	mu sync.Mutex
+}
+
+func (server *controlServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var request ControlRequest
+		var response ControlResponse
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			response.Error = fmt.Sprintf("invalid request: %v", err)
+		} else {
+			response = server.run(request)
+		}
+		if err := encoder.Encode(response); err != nil {
+			return
+		}
+	}
+}
+
+func (server *controlServer) run(request ControlRequest) ControlResponse {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	names := make([]string, 0, len(server.pools))
+	if request.Pool != "" {
+		if _, ok := server.pools[request.Pool]; !ok {
+			return ControlResponse{Error: fmt.Sprintf("unknown pool %q", request.Pool)}
+		}
+		names = append(names, request.Pool)
+	} else {
+		for name := range server.pools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var response ControlResponse
+	switch request.Command {
+	case "stats":
+		response.Stats = make(map[string]Stats, len(names))
+		for _, name := range names {
+			response.Stats[name] = server.pools[name].Stats()
+		}
+	case "holders":
+		response.Holders = make(map[string][]CallSiteStats, len(names))
+		for _, name := range names {
+			response.Holders[name] = server.pools[name].CallSiteStats()
+		}
+	case "locks":
+		response.Locks = make(map[string][]BufferLockState, len(names))
+		for _, name := range names {
+			response.Locks[name] = server.pools[name].BufferLocks()
+		}
+	case "entries":
+		response.Entries = make(map[string][]EntryDiagnostic, len(names))
+		for _, name := range names {
+			response.Entries[name] = server.pools[name].EntryDiagnostics()
+		}
+	case "trim":
+		pools := make([]*Pool, 0, len(names))
+		for _, name := range names {
+			pools = append(pools, server.pools[name])
+		}
+		response.BytesFreed = FreeOSMemory(pools...)
+	case "watermarks":
+		if request.Pool == "" {
+			response.Error = "watermarks needs a pool"
+		} else if err := server.pools[request.Pool].SetWatermarks(request.Soft, request.Hard); err != nil {
+			response.Error = err.Error()
+		}
+	case "force-release":
+		if request.Pool == "" {
+			response.Error = "force-release needs a pool"
+		} else if request.Reason == "" {
+			response.Error = "force-release needs a reason"
+		} else if err := server.pools[request.Pool].ForceRelease(request.Entry, request.Reason); err != nil {
+			response.Error = err.Error()
+		}
+	default:
+		response.Error = fmt.Sprintf("unknown command %q", request.Command)
+	}
+	return response
+}
+
+// Control sends request to the control socket at path and returns its
+// response, failing if the command did.
+func Control(path string, request ControlRequest) (ControlResponse, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return ControlResponse{}, fmt.Errorf("rustybuffer: connecting to control socket: %w", err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return ControlResponse{}, fmt.Errorf("rustybuffer: sending control request: %w", err)
+	}
+	var response ControlResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return ControlResponse{}, fmt.Errorf("rustybuffer: reading control response: %w", err)
+	}
+	if response.Error != "" {
+		return response, fmt.Errorf("rustybuffer: %s: %s", request.Command, response.Error)
+	}
+	return response, nil
+}
@@ -0,0 +1,130 @@
+package rustybuffer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestControlSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control")
+	cache := NewPool(
+		WithAllocator(NewHeapAllocator(1000, 1000)),
+		WithWatermarks(0.5, 0.9),
+		WithCallSiteStats(),
+	)
+	other := NewPool(WithAllocator(NewHeapAllocator(1000, 1000)), WithForceRelease())
+	server, err := ListenControl(path, map[string]*Pool{"cache": cache, "other": other})
+	if err != nil {
+		t.Skipf("can't listen on a unix socket: %v", err)
+	}
+	defer server.Close()
+
+	entry, err := cache.AllocBuffers([]uint64{400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	response, err := Control(path, ControlRequest{Command: "stats"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Stats) != 2 || response.Stats["cache"].BytesInUse != 400 ||
+		response.Stats["other"].BytesInUse != 0 {
+		t.Fatalf("unexpected stats %+v", response.Stats)
+	}
+
+	response, err = Control(path, ControlRequest{Command: "holders", Pool: "cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	holders := response.Holders["cache"]
+	if len(response.Holders) != 1 || len(holders) != 1 ||
+		!strings.Contains(holders[0].Site, "TestControlSocket") || holders[0].Bytes != 400 {
+		t.Fatalf("unexpected holders %+v", response.Holders)
+	}
+
+	var events []WatermarkEvent
+	cache.OnWatermark(func(event WatermarkEvent) {
+		events = append(events, event)
+	})
+	if _, err := Control(path, ControlRequest{Command: "watermarks", Pool: "cache", Soft: 0.25, Hard: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Watermark != WatermarkSoft {
+		t.Fatalf("unexpected events %v", events)
+	}
+
+	if _, err := Control(path, ControlRequest{Command: "trim"}); err != nil {
+		t.Fatal(err)
+	}
+
+	wedged, err := other.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wedged.Pin()
+	response, err = Control(path, ControlRequest{Command: "entries"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Entries["cache"]) != 0 || len(response.Entries["other"]) != 1 || response.Entries["other"][0].Pins != 1 {
+		t.Fatalf("unexpected entries %+v", response.Entries)
+	}
+	if _, err := Control(path, ControlRequest{Command: "force-release", Pool: "other", Entry: wedged.Handle(), Reason: "wedged"}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := other.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("expected the entry to be released, got %+v", stats)
+	}
+
+	for _, request := range []ControlRequest{
+		{Command: "watermarks", Pool: "other", Soft: 0.5, Hard: 0.9},
+		{Command: "watermarks"},
+		{Command: "force-release", Pool: "cache", Entry: entry.Handle(), Reason: "not allowed"},
+		{Command: "force-release", Pool: "other", Entry: wedged.Handle()},
+		{Command: "force-release", Entry: wedged.Handle(), Reason: "no pool"},
+		{Command: "stats", Pool: "missing"},
+		{Command: "explode"},
+	} {
+		if _, err := Control(path, request); err == nil {
+			t.Fatalf("expected %+v to fail", request)
+		}
+	}
+}
+
+func TestControlSocketPermissions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "control")
+	server, err := ListenControl(path, map[string]*Pool{})
+	if err != nil {
+		t.Skipf("can't listen on a unix socket: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected mode %v", info.Mode())
+	}
+	if _, err := Control(path, ControlRequest{Command: "stats"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing's left behind but the socket, and something already there
+	// isn't replaced.
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("unexpected files %v", entries)
+	}
+	if _, err := ListenControl(path, map[string]*Pool{}); err == nil {
+		t.Fatal("expected listening on an existing path to fail")
+	}
+
+	server.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("the socket wasn't removed: %v", err)
+	}
+}
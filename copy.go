@@ -0,0 +1,181 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Below this many bytes in total the cost of calling into the Rust library
+// is more than copying in Go.
+const copyNativeThreshold = 64 * 1024
+
+// Range is Length bytes to copy from SrcOffset bytes into one entry to
+// DstOffset bytes into another. Like RBEntry.FillRange, offsets count
+// through an entry's buffers in order as if they were one.
+type Range struct {
+	SrcOffset uint64
+	DstOffset uint64
+	Length    uint64
+}
+
+// copySegment is the part of a Range that falls within a single buffer of
+// both entries.
+type copySegment struct {
+	dst []byte
+	src []byte
+}
+
+// Copy copies every range from src to dst, in order, with a single call
+// into the Rust library when there's enough to copy. dst and src can be
+// the same entry, in which case each range behaves like memmove and
+// copies correctly even if its source and destination overlap; a range
+// sees whatever earlier ranges wrote. Every range is checked before
+// anything is copied, so a range past the end of either entry copies
+// nothing and returns an error.
+func Copy(dst RBEntry, src RBEntry, ranges []Range) error {
+	dst_ends := bufferEnds(dst.Buffers)
+	src_ends := bufferEnds(src.Buffers)
+	dst_size := dst_ends[len(dst_ends)-1]
+	src_size := src_ends[len(src_ends)-1]
+
+	for idx, rng := range ranges {
+		if rng.SrcOffset > src_size || rng.Length > src_size-rng.SrcOffset {
+			return fmt.Errorf("rustybuffer: copy range %d source [%d:%d] out of range with length %d",
+				idx, rng.SrcOffset, rng.SrcOffset+rng.Length, src_size)
+		}
+		if rng.DstOffset > dst_size || rng.Length > dst_size-rng.DstOffset {
+			return fmt.Errorf("rustybuffer: copy range %d destination [%d:%d] out of range with length %d",
+				idx, rng.DstOffset, rng.DstOffset+rng.Length, dst_size)
+		}
+	}
+
+	same := dst.data != nil && dst.data == src.data
+
+	var size uint64 = 0
+	segments := make([]copySegment, 0, len(ranges))
+	for _, rng := range ranges {
+		start := len(segments)
+		segments = appendSegments(segments, dst.Buffers, dst_ends, src.Buffers, src_ends, rng)
+		size += rng.Length
+
+		// A range that spans buffers is copied a segment at a time, so
+		// moving it forwards within an entry has to start from the end or
+		// the first segments would overwrite what the later ones read.
+		if same && rng.DstOffset > rng.SrcOffset &&
+			rng.DstOffset-rng.SrcOffset < rng.Length {
+			reversed := segments[start:]
+			for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+				reversed[i], reversed[j] = reversed[j], reversed[i]
+			}
+		}
+	}
+
+	shadow := dst.state.shadowing()
+	copySegments(segments, size)
+	if shadow != nil {
+		written := make([][]byte, len(segments))
+		for idx, seg := range segments {
+			written[idx] = seg.dst
+		}
+		shadow.wrote(written...)
+	}
+	return nil
+}
+
+// CopyInto copies the entry into a new entry from pool, with the same
+// buffer sizes, labels and taint (see WithTaint), in a single call into
+// the Rust library when there's enough to copy, so a payload can be kept
+// for later without passing it through the Go heap. pool can be the
+// entry's own. Copying a released entry is an error.
+func (entry *RBEntry) CopyInto(pool *Pool) (RBEntry, error) {
+	if entry.data == nil || (entry.state != nil && entry.state.released.Load()) {
+		return RBEntry{}, errors.New("rustybuffer: copy of a released entry")
+	}
+
+	sizes := make([]uint64, len(entry.Buffers))
+	var size uint64 = 0
+	for idx, buffer := range entry.Buffers {
+		sizes[idx] = uint64(len(buffer))
+		size += sizes[idx]
+	}
+	var opts []AcquireOption
+	if labels := entry.Labels(); len(labels) > 0 {
+		opts = append(opts, WithLabels(labels))
+	}
+	if entry.state != nil {
+		if reason := entry.state.taint.Load(); reason != nil {
+			opts = append(opts, WithTaint(*reason))
+		}
+	}
+
+	copied, err := pool.AllocBuffers(sizes, opts...)
+	if err != nil {
+		return RBEntry{}, err
+	}
+	segments := make([]copySegment, 0, len(entry.Buffers))
+	for idx, buffer := range entry.Buffers {
+		if len(buffer) > 0 {
+			segments = append(segments, copySegment{copied.Buffers[idx], buffer})
+		}
+	}
+	shadow := copied.state.shadowing()
+	copySegments(segments, size)
+	shadow.wrote(copied.Buffers...)
+	return copied, nil
+}
+
+// bufferEnds returns the offset just past each buffer, with a leading
+// zero so that the last element is the total size.
+func bufferEnds(buffers [][]byte) []uint64 {
+	ends := make([]uint64, len(buffers)+1)
+	for idx, buffer := range buffers {
+		ends[idx+1] = ends[idx] + uint64(len(buffer))
+	}
+	return ends
+}
+
+// locate returns the buffer holding offset and where in it offset is.
+// ends is from bufferEnds, and empty buffers are skipped.
+func locate(ends []uint64, offset uint64) (int, uint64) {
+	idx := sort.Search(len(ends)-1, func(i int) bool {
+		return ends[i+1] > offset
+	})
+	return idx, offset - ends[idx]
+}
+
+// appendSegments splits rng wherever it crosses a buffer boundary in
+// either entry.
+func appendSegments(segments []copySegment, dst [][]byte, dst_ends []uint64,
+	src [][]byte, src_ends []uint64, rng Range) []copySegment {
+	dst_offset := rng.DstOffset
+	src_offset := rng.SrcOffset
+	length := rng.Length
+
+	for length > 0 {
+		dst_idx, dst_pos := locate(dst_ends, dst_offset)
+		src_idx, src_pos := locate(src_ends, src_offset)
+
+		count := min(length,
+			uint64(len(dst[dst_idx]))-dst_pos,
+			uint64(len(src[src_idx]))-src_pos)
+		segments = append(segments, copySegment{
+			dst: dst[dst_idx][dst_pos : dst_pos+count],
+			src: src[src_idx][src_pos : src_pos+count],
+		})
+
+		dst_offset += count
+		src_offset += count
+		length -= count
+	}
+
+	return segments
+}
+
+// goCopySegments is the pure Go copy. Like the library's, each segment is
+// a memmove.
+func goCopySegments(segments []copySegment) {
+	for _, seg := range segments {
+		copy(seg.dst, seg.src)
+	}
+}
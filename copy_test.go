@@ -0,0 +1,189 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// flatten returns the entry's buffers as one slice.
+func flatten(entry RBEntry) []byte {
+	var flat []byte
+	for _, buffer := range entry.Buffers {
+		flat = append(flat, buffer...)
+	}
+	return flat
+}
+
+// fillPattern writes a different pattern, depending on seed, to each byte.
+func fillPattern(entry RBEntry, seed int64) {
+	rng := rand.New(rand.NewSource(seed))
+	for _, buffer := range entry.Buffers {
+		rng.Read(buffer)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<24, 1<<24)))
+
+	sizes := []uint64{100, 0, copyNativeThreshold, 7, copyNativeThreshold / 2}
+	src, err := pool.AllocBuffers(sizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Release()
+	dst, err := pool.AllocBuffers([]uint64{copyNativeThreshold * 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Release()
+
+	fillPattern(src, 1)
+	expected := flatten(dst)
+	flat := flatten(src)
+
+	// Some small, one across every buffer boundary and one big enough for
+	// the library on its own.
+	ranges := []Range{
+		{SrcOffset: 0, DstOffset: 10, Length: 5},
+		{SrcOffset: 90, DstOffset: 1000, Length: copyNativeThreshold + 20},
+		{SrcOffset: 50, DstOffset: 0, Length: 0},
+		{SrcOffset: 3, DstOffset: 12, Length: 2},
+	}
+	for _, rng := range ranges {
+		copy(expected[rng.DstOffset:], flat[rng.SrcOffset:rng.SrcOffset+rng.Length])
+	}
+
+	if err := Copy(dst, src, ranges); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(flatten(dst), expected) {
+		t.Fatal("destination doesn't match")
+	}
+	if !bytes.Equal(flatten(src), flat) {
+		t.Fatal("source was modified")
+	}
+}
+
+func TestCopyOverlapping(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<24, 1<<24)))
+
+	entry, err := pool.AllocBuffers([]uint64{1000, 3, copyNativeThreshold, 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	fillPattern(entry, 2)
+	expected := flatten(entry)
+	size := uint64(len(expected))
+
+	rng := rand.New(rand.NewSource(3))
+	for round := 0; round < 200; round++ {
+		// Mostly short moves within and across buffers, so source and
+		// destination usually overlap, with the odd long one.
+		length := uint64(rng.Int63n(2000))
+		if round%10 == 0 {
+			length = uint64(rng.Int63n(int64(size)))
+		}
+		src_offset := uint64(rng.Int63n(int64(size - length + 1)))
+		dst_offset := uint64(rng.Int63n(int64(size - length + 1)))
+		if round%2 == 0 {
+			delta := uint64(rng.Int63n(100))
+			if src_offset+delta+length <= size {
+				dst_offset = src_offset + delta
+			}
+		}
+
+		ranges := []Range{{SrcOffset: src_offset, DstOffset: dst_offset, Length: length}}
+		copy(expected[dst_offset:], expected[src_offset:src_offset+length])
+		if err := Copy(entry, entry, ranges); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(flatten(entry), expected) {
+			t.Fatalf("round %d copying %+v doesn't match", round, ranges[0])
+		}
+	}
+}
+
+func TestCopyOutOfRange(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<24, 1<<24)))
+
+	src, err := pool.AllocBuffers([]uint64{10, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Release()
+	dst, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Release()
+
+	fillPattern(src, 4)
+	before := flatten(dst)
+
+	for _, tc := range []struct {
+		rng      Range
+		expected string
+	}{
+		{Range{SrcOffset: 15, DstOffset: 0, Length: 6}, "source [15:21]"},
+		{Range{SrcOffset: 0, DstOffset: 5, Length: 6}, "destination [5:11]"},
+		{Range{SrcOffset: 21, DstOffset: 0, Length: 0}, "source [21:21]"},
+	} {
+		// The valid first range mustn't be copied either.
+		ranges := []Range{{SrcOffset: 0, DstOffset: 0, Length: 10}, tc.rng}
+		err := Copy(dst, src, ranges)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("expected an error about %s, got %v", tc.expected, err)
+		}
+		if !bytes.Equal(flatten(dst), before) {
+			t.Fatal("destination was modified")
+		}
+	}
+
+	// Ranges can end exactly at the end of either entry.
+	if err := Copy(dst, src, []Range{{SrcOffset: 10, DstOffset: 0, Length: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(flatten(dst), src.Buffers[1]) {
+		t.Fatal("destination doesn't match")
+	}
+}
+
+func TestCopyInto(t *testing.T) {
+	src_pool := NewPool(WithAllocator(NewHeapAllocator(1<<24, 1<<24)))
+	dst_alloc := NewHeapAllocator(1<<24, 1<<24)
+	dst_pool := NewPool(WithAllocator(dst_alloc))
+
+	src, err := src_pool.AllocBuffers([]uint64{100, 0, copyNativeThreshold},
+		WithLabels(map[string]string{"tenant": "a"}), WithTaint("from the network"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillPattern(src, 5)
+
+	copied, err := src.CopyInto(dst_pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Release()
+
+	// The copy outlives the original.
+	flat := flatten(src)
+	src.Release()
+	if !bytes.Equal(flatten(copied), flat) || len(copied.Buffers) != 3 || len(copied.Buffers[1]) != 0 {
+		t.Fatal("copy doesn't match")
+	}
+	if copied.Labels()["tenant"] != "a" || !copied.Tainted() {
+		t.Fatalf("expected the labels and taint copied, got %v", copied.Labels())
+	}
+	if stats := dst_alloc.Stats(); stats.BytesInUse != uint64(len(flat)) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	if _, err := src.CopyInto(dst_pool); err == nil {
+		t.Fatal("expected copying a released entry to fail")
+	}
+}
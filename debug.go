@@ -0,0 +1,49 @@
+//go:build rustybuffer_debug
+
+package rustybuffer
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// Building with the rustybuffer_debug tag turns on internal consistency
+// checks that are too slow to leave on: offset arithmetic, every
+// allocator's bookkeeping and a record of every pointer handed out to
+// catch double frees. Failures panic with a description of what went
+// wrong. Without the tag (nodebug.go) all of this compiles away.
+const debugAssertions = true
+
+func assertf(cond bool, format string, args ...any) {
+	if !cond {
+		panic("rustybuffer: assertion failed: " + fmt.Sprintf(format, args...))
+	}
+}
+
+// The pointers currently handed out by pools, across every allocator.
+var debugLive struct {
+	mu   sync.Mutex
+	data map[unsafe.Pointer]uint64
+}
+
+func debugAcquired(data unsafe.Pointer, size uint64) {
+	debugLive.mu.Lock()
+	defer debugLive.mu.Unlock()
+
+	if debugLive.data == nil {
+		debugLive.data = make(map[unsafe.Pointer]uint64)
+	}
+	prev, ok := debugLive.data[data]
+	assertf(!ok, "%p was handed out for %d bytes while still live with %d", data, size, prev)
+	debugLive.data[data] = size
+}
+
+func debugReleased(data unsafe.Pointer) {
+	debugLive.mu.Lock()
+	defer debugLive.mu.Unlock()
+
+	_, ok := debugLive.data[data]
+	assertf(ok, "%p released but it isn't live (double free?)", data)
+	delete(debugLive.data, data)
+}
@@ -0,0 +1,102 @@
+//go:build rustybuffer_debug
+
+package rustybuffer
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func expectAssertion(t *testing.T, contains string, fn func()) {
+	t.Helper()
+
+	defer func() {
+		msg, _ := recover().(string)
+		if !strings.Contains(msg, contains) {
+			t.Fatalf("expected an assertion about %q, got %q", contains, msg)
+		}
+	}()
+	fn()
+}
+
+func TestDebugDoubleFree(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wrapping memory that's already in an entry is caught straight away.
+	expectAssertion(t, "while still live", func() {
+		NewRBEntry(entry.data, entry.Buffers)
+	})
+
+	data := entry.data
+	entry.Release()
+	expectAssertion(t, "double free", func() {
+		debugReleased(data)
+	})
+}
+
+// duplicateAllocator hands out the same memory every time.
+type duplicateAllocator struct {
+	Allocator
+	buf []byte
+}
+
+func (alloc *duplicateAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	return unsafe.Pointer(unsafe.SliceData(alloc.buf)), nil
+}
+
+func TestDebugDuplicatePointer(t *testing.T) {
+	pool := NewPool(WithAllocator(&duplicateAllocator{buf: make([]byte, 64)}))
+
+	if _, err := pool.AllocBuffers([]uint64{10}); err != nil {
+		t.Fatal(err)
+	}
+	expectAssertion(t, "while still live", func() {
+		pool.AllocBuffers([]uint64{10})
+	})
+}
+
+func TestDebugChecks(t *testing.T) {
+	// The checks run on every call, so exercising the allocators is the
+	// test. The Rust library's bookkeeping is checked on its side, and
+	// its cache may already be full of other tests' buffers.
+	allocs := map[string]Allocator{
+		"malloc":        NewMallocAllocator(1024*1024, 64*1024),
+		"heap":          NewHeapAllocator(1024*1024, 64*1024),
+		"deterministic": NewDeterministicAllocator(1024*1024, 64*1024, 0),
+		"randomized":    NewRandomizedAllocator(1024*1024, 64*1024),
+	}
+	for name, alloc := range allocs {
+		pool := NewPool(WithAllocator(alloc))
+		var entries []RBEntry
+		for idx := 0; idx < 50; idx++ {
+			entry, err := pool.AllocBuffers([]uint64{uint64(idx * 10), 0, 7})
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			entries = append(entries, entry)
+			if idx%3 == 0 {
+				entries[idx/2].Release()
+			}
+		}
+		for idx := range entries {
+			entries[idx].Release()
+		}
+	}
+
+	det := NewDeterministicAllocator(1<<16, 1<<16, 3)
+	for idx := 0; idx < 50; idx++ {
+		data, err := det.Acquire(uint64(idx) * 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idx%2 == 0 {
+			det.Release(data)
+		}
+	}
+}
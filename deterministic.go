@@ -0,0 +1,284 @@
+package rustybuffer
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// Allocations from a deterministic allocator are rounded up to this so
+// every buffer is as aligned as malloc's.
+const deterministicAlignment = 16
+
+type span struct {
+	offset uint64
+	size   uint64
+}
+
+// deterministicAllocator carves every buffer out of one arena, so where a
+// buffer ends up depends only on the sequence of acquires and releases
+// (and the seed), never on the system allocator, the GC or other
+// goroutines' use of the Rust library.
+type deterministicAllocator struct {
+	mu              sync.Mutex
+	arena           []byte
+	max_buffer_size uint64
+	rng             *rand.Rand
+	bytes_in_use    uint64
+
+	// Whether buffers go anywhere in the free space, not just at the start
+	// of a hole, see NewRandomizedAllocator.
+	scatter bool
+
+	// The free space sorted by offset, and what's handed out.
+	free      []span
+	allocated map[uint64]uint64
+}
+
+// NewDeterministicAllocator returns an Allocator whose placement is
+// reproducible run to run: given the same sequence of calls a buffer is
+// always at the same offset from the start of a single max_total_size
+// arena (see ArenaOffset), which makes fragmentation bugs and flaky tests
+// bisectable. With a seed of zero buffers go in the first free space
+// that's big enough. Any other seed picks pseudo-randomly, but
+// reproducibly, among the free spaces that are big enough, to shake out
+// placement assumptions.
+//
+// The arena is allocated from the Go heap up front.
+func NewDeterministicAllocator(max_total_size uint64, max_buffer_size uint64, seed int64) Allocator {
+	alloc := &deterministicAllocator{
+		arena:           make([]byte, max_total_size),
+		max_buffer_size: max_buffer_size,
+		allocated:       make(map[uint64]uint64),
+	}
+	if max_total_size > 0 {
+		alloc.free = []span{{0, max_total_size}}
+	}
+	if seed != 0 {
+		alloc.rng = rand.New(rand.NewSource(seed))
+	}
+
+	return alloc
+}
+
+// ArenaOffset returns the offset of data from the start of a deterministic
+// allocator's arena. It's false if alloc isn't one or data isn't from it.
+func ArenaOffset(alloc Allocator, data unsafe.Pointer) (uint64, bool) {
+	det, ok := alloc.(*deterministicAllocator)
+	if !ok {
+		return 0, false
+	}
+
+	offset, ok := det.offset(data)
+	if !ok {
+		return 0, false
+	}
+
+	det.mu.Lock()
+	defer det.mu.Unlock()
+
+	_, ok = det.allocated[offset]
+	return offset, ok
+}
+
+func (alloc *deterministicAllocator) offset(data unsafe.Pointer) (uint64, bool) {
+	if len(alloc.arena) == 0 {
+		return 0, false
+	}
+
+	base := uintptr(unsafe.Pointer(unsafe.SliceData(alloc.arena)))
+	addr := uintptr(data)
+	if addr < base || addr >= base+uintptr(len(alloc.arena)) {
+		return 0, false
+	}
+	return uint64(addr - base), true
+}
+
+func (alloc *deterministicAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	if size > alloc.max_buffer_size {
+		return nil, newError(codeBufferTooLarge,
+			"requested %d bytes but max_buffer_size is %d",
+			size, alloc.max_buffer_size)
+	}
+
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	rounded := (size + deterministicAlignment - 1) &^ (deterministicAlignment - 1)
+	if rounded < size {
+		rounded = size
+	}
+	// Even an empty buffer takes up space, or its pointer would be the
+	// same as the next buffer's.
+	if rounded == 0 {
+		rounded = deterministicAlignment
+	}
+
+	if alloc.scatter {
+		return alloc.scatterAcquire(size, rounded)
+	}
+
+	fits := make([]int, 0, len(alloc.free))
+	for idx, hole := range alloc.free {
+		if hole.size >= rounded {
+			fits = append(fits, idx)
+			if alloc.rng == nil {
+				break
+			}
+		}
+	}
+	if len(fits) == 0 {
+		return nil, newError(codeNoBufferAvailable,
+			"requested %d bytes with %d of max_total_size %d in use",
+			size, alloc.bytes_in_use, len(alloc.arena))
+	}
+
+	idx := fits[0]
+	if alloc.rng != nil {
+		idx = fits[alloc.rng.Intn(len(fits))]
+	}
+
+	offset := alloc.free[idx].offset
+	alloc.carve(idx, offset, rounded)
+	return alloc.handOut(offset, rounded), nil
+}
+
+// carve takes rounded bytes at offset out of the idx'th hole, leaving
+// whatever's either side of them free, with the lock held.
+func (alloc *deterministicAllocator) carve(idx int, offset uint64, rounded uint64) {
+	hole := alloc.free[idx]
+	before := span{hole.offset, offset - hole.offset}
+	after := span{offset + rounded, hole.offset + hole.size - offset - rounded}
+
+	switch {
+	case before.size == 0 && after.size == 0:
+		alloc.free = append(alloc.free[:idx], alloc.free[idx+1:]...)
+	case before.size == 0:
+		alloc.free[idx] = after
+	case after.size == 0:
+		alloc.free[idx] = before
+	default:
+		alloc.free = append(alloc.free, span{})
+		copy(alloc.free[idx+2:], alloc.free[idx+1:])
+		alloc.free[idx] = before
+		alloc.free[idx+1] = after
+	}
+}
+
+// handOut zeroes and records rounded bytes at offset, with the lock held.
+func (alloc *deterministicAllocator) handOut(offset uint64, rounded uint64) unsafe.Pointer {
+	buf := alloc.arena[offset : offset+rounded]
+	clear(buf)
+
+	alloc.allocated[offset] = rounded
+	alloc.bytes_in_use += rounded
+	if debugAssertions {
+		alloc.check()
+	}
+
+	return unsafe.Pointer(unsafe.SliceData(buf))
+}
+
+func (alloc *deterministicAllocator) Release(data unsafe.Pointer) error {
+	offset, ok := alloc.offset(data)
+
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	size, allocated := alloc.allocated[offset]
+	if !ok || !allocated {
+		return newError(codeInvalidPointer,
+			"%p was not acquired from this allocator", data)
+	}
+
+	delete(alloc.allocated, offset)
+	alloc.bytes_in_use -= size
+
+	// Put the space back in order, merging it with its neighbours.
+	idx := sort.Search(len(alloc.free), func(i int) bool {
+		return alloc.free[i].offset > offset
+	})
+	alloc.free = append(alloc.free, span{})
+	copy(alloc.free[idx+1:], alloc.free[idx:])
+	alloc.free[idx] = span{offset, size}
+
+	if idx+1 < len(alloc.free) && offset+size == alloc.free[idx+1].offset {
+		alloc.free[idx].size += alloc.free[idx+1].size
+		alloc.free = append(alloc.free[:idx+1], alloc.free[idx+2:]...)
+	}
+	if idx > 0 && alloc.free[idx-1].offset+alloc.free[idx-1].size == offset {
+		alloc.free[idx-1].size += alloc.free[idx].size
+		alloc.free = append(alloc.free[:idx], alloc.free[idx+1:]...)
+	}
+	if debugAssertions {
+		alloc.check()
+	}
+
+	return nil
+}
+
+// check asserts the free list is sorted, disjoint and accounts for
+// everything not allocated, with the lock held.
+func (alloc *deterministicAllocator) check() {
+	var free uint64 = 0
+	for idx, hole := range alloc.free {
+		assertf(hole.size > 0, "free span %d at %d is empty", idx, hole.offset)
+		if idx > 0 {
+			prev := alloc.free[idx-1]
+			assertf(prev.offset+prev.size < hole.offset,
+				"free spans at %d+%d and %d overlap or weren't merged",
+				prev.offset, prev.size, hole.offset)
+		}
+		_, allocated := alloc.allocated[hole.offset]
+		assertf(!allocated, "free span at %d is also allocated", hole.offset)
+		free += hole.size
+	}
+
+	var in_use uint64 = 0
+	for _, size := range alloc.allocated {
+		in_use += size
+	}
+	assertf(in_use == alloc.bytes_in_use,
+		"allocations add up to %d bytes but %d are in use", in_use, alloc.bytes_in_use)
+	assertf(free+in_use == uint64(len(alloc.arena)),
+		"%d free and %d in use don't add up to the %d byte arena",
+		free, in_use, len(alloc.arena))
+}
+
+func (alloc *deterministicAllocator) LiveHandles() ([]uintptr, error) {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	base := uintptr(unsafe.Pointer(unsafe.SliceData(alloc.arena)))
+	live := make([]uintptr, 0, len(alloc.allocated))
+	for offset := range alloc.allocated {
+		live = append(live, base+uintptr(offset))
+	}
+	return live, nil
+}
+
+func (alloc *deterministicAllocator) FreeExtents() ([]uint64, []uint64, error) {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	extents := make([]uint64, len(alloc.free))
+	for idx, free := range alloc.free {
+		extents[idx] = free.size
+	}
+	return extents, nil, nil
+}
+
+func (alloc *deterministicAllocator) Stats() Stats {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	return Stats{
+		MaxTotalSize:   uint64(len(alloc.arena)),
+		MaxBufferSize:  alloc.max_buffer_size,
+		BytesAllocated: uint64(len(alloc.arena)),
+		BytesInUse:     alloc.bytes_in_use,
+		NumBuffers:     uint64(len(alloc.allocated)),
+		NumAvailable:   0,
+	}
+}
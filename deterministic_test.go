@@ -0,0 +1,96 @@
+package rustybuffer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// deterministicRun acquires and releases in a fixed pattern and returns
+// the offset of every acquire.
+func deterministicRun(t *testing.T, seed int64) []uint64 {
+	alloc := NewDeterministicAllocator(4096, 4096, seed)
+	pool := NewPool(WithAllocator(alloc))
+
+	var offsets []uint64
+	var entries []RBEntry
+	for idx := 0; idx < 40; idx++ {
+		entry, err := pool.AllocBuffers([]uint64{uint64(idx*7%100) + 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset, ok := ArenaOffset(alloc, entry.data)
+		if !ok {
+			t.Fatal("entry isn't in the arena")
+		}
+		offsets = append(offsets, offset)
+		entries = append(entries, entry)
+
+		if idx%3 == 2 {
+			entries[idx/2].Release()
+		}
+	}
+
+	for idx := range entries {
+		entries[idx].Release()
+	}
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", alloc.Stats())
+	}
+
+	return offsets
+}
+
+func TestDeterministicAllocator(t *testing.T) {
+	for _, seed := range []int64{0, 42} {
+		first := deterministicRun(t, seed)
+		second := deterministicRun(t, seed)
+		if !reflect.DeepEqual(first, second) {
+			t.Fatalf("seed %d: placement differs between runs:\n%v\n%v", seed, first, second)
+		}
+		for _, offset := range first {
+			if offset%deterministicAlignment != 0 {
+				t.Fatalf("seed %d: offset %d isn't aligned", seed, offset)
+			}
+		}
+	}
+}
+
+func TestDeterministicAllocatorLimits(t *testing.T) {
+	alloc := NewDeterministicAllocator(64, 48, 0)
+
+	if _, err := alloc.Acquire(49); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+
+	first, err := alloc.Acquire(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := alloc.Acquire(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alloc.Acquire(20); !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+
+	// Releasing both merges the space back into one.
+	if err := alloc.Release(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := alloc.Release(second); err != nil {
+		t.Fatal(err)
+	}
+	if err := alloc.Release(second); !errors.Is(err, ErrInvalidPointer) {
+		t.Fatalf("expected ErrInvalidPointer, got %v", err)
+	}
+
+	whole, err := alloc.Acquire(48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, _ := ArenaOffset(alloc, whole); offset != 0 {
+		t.Fatalf("free space wasn't merged, got offset %d", offset)
+	}
+}
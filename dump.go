@@ -0,0 +1,56 @@
+package rustybuffer
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DumpOptions control RBEntry.Dump.
+type DumpOptions struct {
+	// Only the first MaxBytes of each buffer are dumped, or all of them if
+	// it's zero.
+	MaxBytes uint64
+}
+
+// Dump writes each of the entry's buffers to w as a classic offset, hex and
+// ASCII dump, the same as hexdump -C, under a line giving its index and
+// size. Dumping an entry that's been released is an error rather than a
+// read of memory that's been handed to someone else.
+func (entry *RBEntry) Dump(w io.Writer, opts DumpOptions) error {
+	if entry.state != nil && entry.state.released.Load() {
+		return errors.New("rustybuffer: dump of a released entry")
+	}
+
+	for idx, buffer := range entry.Buffers {
+		shown := buffer
+		header := fmt.Sprintf("buffer %d, %d bytes:\n", idx, len(buffer))
+		if opts.MaxBytes > 0 && uint64(len(buffer)) > opts.MaxBytes {
+			shown = buffer[:opts.MaxBytes]
+			header = fmt.Sprintf("buffer %d, %d bytes, first %d shown:\n",
+				idx, len(buffer), opts.MaxBytes)
+		}
+
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+
+		dumper := hex.Dumper(w)
+		if _, err := dumper.Write(shown); err != nil {
+			return err
+		}
+		if err := dumper.Close(); err != nil {
+			return err
+		}
+
+		if len(shown) < len(buffer) {
+			_, err := fmt.Fprintf(w, "... %d more bytes\n", len(buffer)-len(shown))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
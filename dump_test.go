@@ -0,0 +1,53 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	entry, err := pool.AllocBuffers([]uint64{20, 0, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(entry.Buffers[0], "Hello, rustybuffer!\n")
+	entry.Buffers[2][0] = 0xFF
+
+	var out bytes.Buffer
+	if err := entry.Dump(&out, DumpOptions{MaxBytes: 32}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"buffer 0, 20 bytes:",
+		"00000000  48 65 6c 6c 6f 2c 20 72  75 73 74 79 62 75 66 66  |Hello, rustybuff|",
+		"00000010  65 72 21 0a                                       |er!.|",
+		"buffer 1, 0 bytes:",
+		"buffer 2, 100 bytes, first 32 shown:",
+		"00000000  ff 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|",
+		"00000010  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|",
+		"... 68 more bytes",
+		"",
+	}, "\n")
+	if out.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	// Without a limit everything is shown.
+	out.Reset()
+	if err := entry.Dump(&out, DumpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "\n00000060  00 00 00 00") || strings.Contains(out.String(), "more bytes") {
+		t.Fatalf("expected all of buffer 2, got:\n%s", out.String())
+	}
+
+	released := entry
+	entry.Release()
+	if err := released.Dump(&out, DumpOptions{}); err == nil {
+		t.Fatal("expected dumping a released entry to fail")
+	}
+}
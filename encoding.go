@@ -0,0 +1,175 @@
+package rustybuffer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrShortView is returned, wrapped, when a value doesn't fit between
+	// its offset and the end of a view.
+	ErrShortView = errors.New("rustybuffer: view too short")
+
+	// ErrVarintOverflow is returned when a varint in a view is longer than
+	// any 64 bit value's.
+	ErrVarintOverflow = errors.New("rustybuffer: varint overflows 64 bits")
+)
+
+// The encoding helpers below read and write values at an offset into a
+// view, so a wire format can be encoded without reslicing by hand. A value
+// that doesn't fit returns an error wrapping ErrShortView and, for a put,
+// writes nothing. order is any of encoding/binary's byte orders.
+
+// window returns the size bytes at offset, checking they're in the view.
+func (view View) window(offset int, size int) ([]byte, error) {
+	view.check()
+	if offset < 0 || offset > len(view.buf) || size > len(view.buf)-offset {
+		return nil, fmt.Errorf("%w: %d bytes at offset %d with length %d",
+			ErrShortView, size, offset, len(view.buf))
+	}
+	return view.buf[offset : offset+size], nil
+}
+
+// Uint8 reads the 8 bit value at offset.
+func (view View) Uint8(offset int) (uint8, error) {
+	buf, err := view.window(offset, 1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// PutUint8 writes a 8 bit value at offset.
+func (view View) PutUint8(offset int, value uint8) error {
+	buf, err := view.window(offset, 1)
+	if err != nil {
+		return err
+	}
+	shadow := view.state.shadowing()
+	buf[0] = value
+	shadow.wrote(buf)
+	return nil
+}
+
+// Uint16 reads the 16 bit value at offset.
+func (view View) Uint16(offset int, order binary.ByteOrder) (uint16, error) {
+	buf, err := view.window(offset, 2)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint16(buf), nil
+}
+
+// PutUint16 writes a 16 bit value at offset.
+func (view View) PutUint16(offset int, order binary.ByteOrder, value uint16) error {
+	buf, err := view.window(offset, 2)
+	if err != nil {
+		return err
+	}
+	shadow := view.state.shadowing()
+	order.PutUint16(buf, value)
+	shadow.wrote(buf)
+	return nil
+}
+
+// Uint32 reads the 32 bit value at offset.
+func (view View) Uint32(offset int, order binary.ByteOrder) (uint32, error) {
+	buf, err := view.window(offset, 4)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint32(buf), nil
+}
+
+// PutUint32 writes a 32 bit value at offset.
+func (view View) PutUint32(offset int, order binary.ByteOrder, value uint32) error {
+	buf, err := view.window(offset, 4)
+	if err != nil {
+		return err
+	}
+	shadow := view.state.shadowing()
+	order.PutUint32(buf, value)
+	shadow.wrote(buf)
+	return nil
+}
+
+// Uint64 reads the 64 bit value at offset.
+func (view View) Uint64(offset int, order binary.ByteOrder) (uint64, error) {
+	buf, err := view.window(offset, 8)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint64(buf), nil
+}
+
+// PutUint64 writes a 64 bit value at offset.
+func (view View) PutUint64(offset int, order binary.ByteOrder, value uint64) error {
+	buf, err := view.window(offset, 8)
+	if err != nil {
+		return err
+	}
+	shadow := view.state.shadowing()
+	order.PutUint64(buf, value)
+	shadow.wrote(buf)
+	return nil
+}
+
+// Uvarint reads an unsigned varint at offset and returns it along with how
+// many bytes it took.
+func (view View) Uvarint(offset int) (uint64, int, error) {
+	if _, err := view.window(offset, 0); err != nil {
+		return 0, 0, err
+	}
+	value, n := binary.Uvarint(view.buf[offset:])
+	return value, n, varintError(n, offset, len(view.buf)-offset)
+}
+
+// PutUvarint writes value as an unsigned varint at offset and returns how
+// many bytes it took.
+func (view View) PutUvarint(offset int, value uint64) (int, error) {
+	var encoded [binary.MaxVarintLen64]byte
+	return view.putVarint(offset, encoded[:binary.PutUvarint(encoded[:], value)])
+}
+
+// Varint reads a zig-zag encoded signed varint at offset and returns it
+// along with how many bytes it took.
+func (view View) Varint(offset int) (int64, int, error) {
+	if _, err := view.window(offset, 0); err != nil {
+		return 0, 0, err
+	}
+	value, n := binary.Varint(view.buf[offset:])
+	return value, n, varintError(n, offset, len(view.buf)-offset)
+}
+
+// PutVarint writes value as a zig-zag encoded signed varint at offset and
+// returns how many bytes it took.
+func (view View) PutVarint(offset int, value int64) (int, error) {
+	var encoded [binary.MaxVarintLen64]byte
+	return view.putVarint(offset, encoded[:binary.PutVarint(encoded[:], value)])
+}
+
+func (view View) putVarint(offset int, encoded []byte) (int, error) {
+	buf, err := view.window(offset, len(encoded))
+	if err != nil {
+		return 0, err
+	}
+	shadow := view.state.shadowing()
+	defer shadow.wrote(buf)
+	return copy(buf, encoded), nil
+}
+
+// varintError turns what encoding/binary returns for a bad varint into an
+// error. A varint that runs off the end of the view is short, one that's
+// too long to be a 64 bit value overflows.
+func varintError(n int, offset int, available int) error {
+	switch {
+	case n > 0:
+		return nil
+	case n == 0:
+		return fmt.Errorf("%w: unterminated varint at offset %d with %d bytes left",
+			ErrShortView, offset, available)
+	default:
+		return fmt.Errorf("%w at offset %d", ErrVarintOverflow, offset)
+	}
+}
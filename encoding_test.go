@@ -0,0 +1,154 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestEncodingFixedWidth(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	entry, err := pool.AllocBuffers([]uint64{15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	view := entry.View(0)
+
+	orders := []interface {
+		binary.ByteOrder
+		binary.AppendByteOrder
+	}{binary.BigEndian, binary.LittleEndian}
+	for _, order := range orders {
+		if err := view.PutUint8(0, 0xAB); err != nil {
+			t.Fatal(err)
+		}
+		if err := view.PutUint16(1, order, 0x0102); err != nil {
+			t.Fatal(err)
+		}
+		if err := view.PutUint32(3, order, 0x03040506); err != nil {
+			t.Fatal(err)
+		}
+		if err := view.PutUint64(7, order, 0x0708090A0B0C0D0E); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []byte{0xAB}
+		expected = order.AppendUint16(expected, 0x0102)
+		expected = order.AppendUint32(expected, 0x03040506)
+		expected = order.AppendUint64(expected, 0x0708090A0B0C0D0E)
+		if !bytes.Equal(entry.Buffers[0], expected) {
+			t.Fatalf("%s: wrote %x, expected %x", order, entry.Buffers[0], expected)
+		}
+
+		if v, err := view.Uint8(0); err != nil || v != 0xAB {
+			t.Fatalf("%s: read %#x, %v", order, v, err)
+		}
+		if v, err := view.Uint16(1, order); err != nil || v != 0x0102 {
+			t.Fatalf("%s: read %#x, %v", order, v, err)
+		}
+		if v, err := view.Uint32(3, order); err != nil || v != 0x03040506 {
+			t.Fatalf("%s: read %#x, %v", order, v, err)
+		}
+		if v, err := view.Uint64(7, order); err != nil || v != 0x0708090A0B0C0D0E {
+			t.Fatalf("%s: read %#x, %v", order, v, err)
+		}
+	}
+}
+
+func TestEncodingOutOfRange(t *testing.T) {
+	buf := []byte{1, 2, 3, 4, 5, 6, 7}
+	view := ViewOf(buf)
+
+	checks := map[string]error{}
+	checks["Uint8 past the end"] = view.PutUint8(7, 0)
+	checks["negative offset"] = view.PutUint16(-1, binary.BigEndian, 0)
+	checks["PutUint32 over the end"] = view.PutUint32(4, binary.BigEndian, 0)
+	checks["PutUint64 bigger than the view"] = view.PutUint64(0, binary.LittleEndian, 0)
+	_, checks["Uint64"] = view.Uint64(0, binary.LittleEndian)
+	_, checks["Uint16 over the end"] = view.Uint16(6, binary.BigEndian)
+	_, checks["Uint32 past the end"] = view.Uint32(100, binary.BigEndian)
+	_, checks["PutUvarint"] = view.Slice(5, 7).PutUvarint(0, math.MaxUint64)
+
+	for name, err := range checks {
+		if !errors.Is(err, ErrShortView) {
+			t.Errorf("%s: expected ErrShortView, got %v", name, err)
+		}
+	}
+	if !bytes.Equal(buf, []byte{1, 2, 3, 4, 5, 6, 7}) {
+		t.Fatalf("failed puts wrote to the view: %v", buf)
+	}
+
+	// Exactly filling the view is fine.
+	if err := view.Slice(3, 7).PutUint32(0, binary.BigEndian, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodingVarints(t *testing.T) {
+	view := ViewOf(make([]byte, 64))
+
+	unsigned := []uint64{0, 1, 127, 128, 300, math.MaxUint32, math.MaxUint64}
+	offset := 0
+	for _, value := range unsigned {
+		n, err := view.PutUvarint(offset, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, m, err := view.Uvarint(offset)
+		if err != nil || read != value || m != n {
+			t.Fatalf("wrote %d in %d bytes, read %d in %d bytes: %v", value, n, read, m, err)
+		}
+		offset += n
+	}
+
+	signed := []int64{0, -1, 63, -64, 64, math.MinInt64, math.MaxInt64}
+	for _, value := range signed {
+		n, err := view.PutVarint(offset, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, m, err := view.Varint(offset)
+		if err != nil || read != value || m != n {
+			t.Fatalf("wrote %d in %d bytes, read %d in %d bytes: %v", value, n, read, m, err)
+		}
+		offset += n
+	}
+
+	// Running off the end, and more continuation bytes than a uint64 has
+	// room for.
+	if _, _, err := ViewOf([]byte{0x80, 0x80}).Uvarint(0); !errors.Is(err, ErrShortView) {
+		t.Fatalf("expected ErrShortView, got %v", err)
+	}
+	if _, _, err := ViewOf([]byte{0x80}).Varint(1); !errors.Is(err, ErrShortView) {
+		t.Fatalf("expected ErrShortView, got %v", err)
+	}
+	overflow := bytes.Repeat([]byte{0xFF}, binary.MaxVarintLen64+1)
+	if _, _, err := ViewOf(overflow).Uvarint(0); !errors.Is(err, ErrVarintOverflow) {
+		t.Fatalf("expected ErrVarintOverflow, got %v", err)
+	}
+	if _, _, err := ViewOf(overflow).Varint(0); !errors.Is(err, ErrVarintOverflow) {
+		t.Fatalf("expected ErrVarintOverflow, got %v", err)
+	}
+}
+
+func TestEncodingReleased(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	entry, err := pool.AllocBuffers([]uint64{8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	view := entry.View(0)
+	entry.Release()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	view.PutUint64(0, binary.BigEndian, 1)
+}
@@ -0,0 +1,137 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Changes this close together are merged into one range, to keep diffs
+// short where most bytes of a region changed.
+const diffMergeGap = 8
+
+// Changed ranges show at most this many bytes from where they start.
+const diffShownBytes = 16
+
+// EntrySnapshot is a copy of an entry's contents at a point in time, on
+// the Go heap, for comparing with a later one, see DiffSnapshots.
+type EntrySnapshot struct {
+	Handle Handle
+	Time   time.Time
+
+	buffers [][]byte
+}
+
+// Snapshot copies the entry's contents, to find out later what changed
+// them. Snapshotting a released entry panics, as reading it would.
+func (entry *RBEntry) Snapshot() EntrySnapshot {
+	if entry.state != nil && entry.state.released.Load() {
+		panic("rustybuffer: snapshot of a released entry")
+	}
+
+	buffers := make([][]byte, len(entry.Buffers))
+	for idx, buffer := range entry.Buffers {
+		buffers[idx] = append([]byte{}, buffer...)
+	}
+	return EntrySnapshot{Handle: entry.Handle(), Time: time.Now(), buffers: buffers}
+}
+
+// ChangedRange is Length bytes starting Offset bytes into an entry's
+// Buffer'th buffer that differ between two snapshots, though not every
+// byte in between need have. Before and After are the first of them, as
+// each snapshot has them.
+type ChangedRange struct {
+	Buffer int
+	Offset int
+	Length int
+	Before []byte
+	After  []byte
+}
+
+// SnapshotDiff is what changed between two snapshots of an entry.
+type SnapshotDiff struct {
+	Handle  Handle
+	Elapsed time.Duration
+
+	// The bytes that differ, and the ranges they're in, in order.
+	BytesChanged int
+	Ranges       []ChangedRange
+}
+
+// DiffSnapshots compares snapshot a of an entry with the later snapshot b
+// of the same entry. Changes closer together than a few bytes are
+// reported as a single range, and none cross from one buffer to the next.
+// Snapshots of different entries, or of entries whose buffers have been
+// resliced to different sizes in between, can't be compared.
+func DiffSnapshots(a EntrySnapshot, b EntrySnapshot) (SnapshotDiff, error) {
+	if a.Handle != b.Handle {
+		return SnapshotDiff{}, fmt.Errorf("rustybuffer: diff of snapshots of entries %s and %s", a.Handle, b.Handle)
+	}
+	if len(a.buffers) != len(b.buffers) {
+		return SnapshotDiff{}, errors.New("rustybuffer: diff of snapshots with different numbers of buffers")
+	}
+
+	diff := SnapshotDiff{Handle: a.Handle, Elapsed: b.Time.Sub(a.Time)}
+	for idx, before := range a.buffers {
+		after := b.buffers[idx]
+		if len(before) != len(after) {
+			return SnapshotDiff{}, fmt.Errorf("rustybuffer: diff of snapshots with buffer %d of %d and %d bytes", idx, len(before), len(after))
+		}
+
+		start, end := -1, -1
+		for offset := range before {
+			if before[offset] == after[offset] {
+				continue
+			}
+			diff.BytesChanged++
+			if start >= 0 && offset-end > diffMergeGap {
+				diff.Ranges = append(diff.Ranges, changedRange(idx, before, after, start, end))
+				start = -1
+			}
+			if start < 0 {
+				start = offset
+			}
+			end = offset + 1
+		}
+		if start >= 0 {
+			diff.Ranges = append(diff.Ranges, changedRange(idx, before, after, start, end))
+		}
+	}
+	return diff, nil
+}
+
+func changedRange(buffer int, before []byte, after []byte, start int, end int) ChangedRange {
+	shown := min(end, start+diffShownBytes)
+	return ChangedRange{
+		Buffer: buffer,
+		Offset: start,
+		Length: end - start,
+		Before: append([]byte(nil), before[start:shown]...),
+		After:  append([]byte(nil), after[start:shown]...),
+	}
+}
+
+// Changed reports whether anything changed.
+func (diff SnapshotDiff) Changed() bool {
+	return diff.BytesChanged > 0
+}
+
+// String summarises the diff on one line, e.g., "entry #3: 5 bytes changed
+// in 2 ranges over 1.2ms: buffer 0 [4:6] 0102 -> 0304, buffer 1 [0:3]
+// 000000 -> 616263".
+func (diff SnapshotDiff) String() string {
+	if !diff.Changed() {
+		return fmt.Sprintf("entry %s: unchanged over %v", diff.Handle, diff.Elapsed)
+	}
+
+	ranges := make([]string, len(diff.Ranges))
+	for idx, rng := range diff.Ranges {
+		ranges[idx] = fmt.Sprintf("buffer %d [%d:%d] %x -> %x", rng.Buffer, rng.Offset, rng.Offset+rng.Length, rng.Before, rng.After)
+		if rng.Length > len(rng.Before) {
+			ranges[idx] += "..."
+		}
+	}
+	return fmt.Sprintf("entry %s: %d bytes changed in %d ranges over %v: %s",
+		diff.Handle, diff.BytesChanged, len(diff.Ranges), diff.Elapsed, strings.Join(ranges, ", "))
+}
@@ -0,0 +1,79 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	entry, err := pool.AllocBuffers([]uint64{64, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	entry.Clear()
+
+	a := entry.Snapshot()
+	if diff, err := DiffSnapshots(a, entry.Snapshot()); err != nil || diff.Changed() || len(diff.Ranges) != 0 {
+		t.Fatalf("expected no changes, got %v (%v)", diff, err)
+	}
+
+	// Two changes close together, one further on and one in the next
+	// buffer.
+	entry.Buffers[0][4] = 1
+	entry.Buffers[0][10] = 2
+	copy(entry.Buffers[0][40:], "abc")
+	entry.Buffers[1][7] = 9
+	b := entry.Snapshot()
+
+	diff, err := DiffSnapshots(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.BytesChanged != 6 || len(diff.Ranges) != 3 {
+		t.Fatalf("unexpected diff %v", diff)
+	}
+	expected := []ChangedRange{
+		{Buffer: 0, Offset: 4, Length: 7},
+		{Buffer: 0, Offset: 40, Length: 3},
+		{Buffer: 1, Offset: 7, Length: 1},
+	}
+	for idx, rng := range diff.Ranges {
+		if rng.Buffer != expected[idx].Buffer || rng.Offset != expected[idx].Offset || rng.Length != expected[idx].Length {
+			t.Fatalf("expected range %d to be %+v, got %+v", idx, expected[idx], rng)
+		}
+	}
+	if !bytes.Equal(diff.Ranges[1].Before, []byte{0, 0, 0}) || string(diff.Ranges[1].After) != "abc" {
+		t.Fatalf("unexpected range %+v", diff.Ranges[1])
+	}
+	if summary := diff.String(); !strings.Contains(summary, "6 bytes changed in 3 ranges") ||
+		!strings.Contains(summary, "buffer 0 [40:43] 000000 -> 616263") {
+		t.Fatalf("unexpected summary %q", summary)
+	}
+
+	other, err := pool.AllocBuffers([]uint64{64, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Release()
+	if _, err := DiffSnapshots(a, other.Snapshot()); err == nil {
+		t.Fatal("expected snapshots of different entries not to compare")
+	}
+
+	entry.Buffers[0] = entry.Buffers[0][:10]
+	if _, err := DiffSnapshots(a, entry.Snapshot()); err == nil {
+		t.Fatal("expected snapshots of different sizes not to compare")
+	}
+}
+
+func TestSnapshotReleased(t *testing.T) {
+	entry, err := NewPool(WithAllocator(NewHeapAllocator(1024, 1024))).AllocBuffers([]uint64{8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := entry
+	entry.Release()
+	expectPanic(t, "snapshot of a released entry", func() { copied.Snapshot() })
+}
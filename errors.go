@@ -0,0 +1,73 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// These mirror the RBError codes returned by the Rust side. Allocators
+// return an *Error wrapping one of them, so use errors.Is to check.
+var (
+	ErrNoBufferAvailable = errors.New("rustybuffer: no buffer available")
+	ErrBufferTooLarge    = errors.New("rustybuffer: buffer too large")
+	ErrInvalidPointer    = errors.New("rustybuffer: invalid pointer")
+	ErrAllocationFailed  = errors.New("rustybuffer: allocation failed")
+	ErrInvalidData       = errors.New("rustybuffer: invalid data")
+)
+
+// The RBError codes.
+const (
+	codeNoBufferAvailable uint8 = 1
+	codeBufferTooLarge    uint8 = 2
+	codeInvalidPointer    uint8 = 3
+	codeAllocationFailed  uint8 = 4
+	codeInvalidData       uint8 = 5
+)
+
+// Error is a failure reported by an allocator with the details of what
+// went wrong, e.g. how many bytes were requested against what limit. It
+// unwraps to the sentinel error for its Code:
+//
+//   - ErrNoBufferAvailable: the pool's total size limit was reached.
+//   - ErrBufferTooLarge: the request exceeds the per-buffer limit.
+//   - ErrInvalidPointer: the released pointer wasn't acquired there.
+//   - ErrAllocationFailed: the system allocator itself ran out of memory.
+//   - ErrInvalidData: data to be decompressed is corrupt.
+type Error struct {
+	Code    uint8
+	Message string
+}
+
+func (err *Error) Error() string {
+	if err.Message == "" {
+		return err.Unwrap().Error()
+	}
+	return "rustybuffer: " + err.Message
+}
+
+func (err *Error) Unwrap() error {
+	return errorFromCode(err.Code)
+}
+
+func newError(code uint8, format string, args ...any) error {
+	return &Error{code, fmt.Sprintf(format, args...)}
+}
+
+func errorFromCode(code uint8) error {
+	switch code {
+	case 0:
+		return nil
+	case codeNoBufferAvailable:
+		return ErrNoBufferAvailable
+	case codeBufferTooLarge:
+		return ErrBufferTooLarge
+	case codeInvalidPointer:
+		return ErrInvalidPointer
+	case codeAllocationFailed:
+		return ErrAllocationFailed
+	case codeInvalidData:
+		return ErrInvalidData
+	default:
+		return errors.New("rustybuffer: unknown error")
+	}
+}
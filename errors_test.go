@@ -0,0 +1,57 @@
+package rustybuffer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestErrorDetails(t *testing.T) {
+	for name, alloc := range testAllocators() {
+		t.Run(name, func(t *testing.T) {
+			_, err := alloc.Acquire(100 * 1024)
+			var rb_err *Error
+			if !errors.As(err, &rb_err) || !errors.Is(err, ErrBufferTooLarge) {
+				t.Fatalf("expected a detailed ErrBufferTooLarge, got %v", err)
+			}
+			if !strings.Contains(err.Error(), "requested 102400 bytes") {
+				t.Fatalf("missing details: %q", err)
+			}
+
+			var held []unsafe.Pointer
+			for err == nil || errors.Is(err, ErrBufferTooLarge) {
+				var data unsafe.Pointer
+				data, err = alloc.Acquire(64 * 1024)
+				if err == nil {
+					held = append(held, data)
+				}
+			}
+			if !errors.Is(err, ErrNoBufferAvailable) {
+				t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+			}
+			if !strings.Contains(err.Error(), "max_total_size 1048576") {
+				t.Fatalf("missing details: %q", err)
+			}
+			for _, data := range held {
+				alloc.Release(data)
+			}
+
+			var bogus uint8
+			err = alloc.Release(unsafe.Pointer(&bogus))
+			if !errors.Is(err, ErrInvalidPointer) || !strings.Contains(err.Error(), "0x") {
+				t.Fatalf("expected a detailed ErrInvalidPointer, got %v", err)
+			}
+		})
+	}
+}
+
+func TestErrorWithoutMessage(t *testing.T) {
+	err := &Error{Code: codeAllocationFailed}
+	if err.Error() != ErrAllocationFailed.Error() {
+		t.Fatalf("unexpected message %q", err)
+	}
+	if !errors.Is(err, ErrAllocationFailed) {
+		t.Fatal("expected the error to unwrap to its sentinel")
+	}
+}
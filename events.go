@@ -0,0 +1,100 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"sync"
+)
+
+// LibraryEventKind is what a LibraryEvent reports.
+type LibraryEventKind uint32
+
+const (
+	// Cached buffers were freed; Value is how many bytes.
+	LibraryEventTrimmed LibraryEventKind = iota + 1
+
+	// An acquire failed because the library's limit was reached; Value is
+	// the size asked for.
+	LibraryEventPressure
+
+	// Memory that wasn't acquired from the library, or was already
+	// released, was released to it, i.e., something's bookkeeping is
+	// corrupt; Value is the address.
+	LibraryEventInvalidRelease
+)
+
+func (kind LibraryEventKind) String() string {
+	switch kind {
+	case LibraryEventTrimmed:
+		return "trimmed"
+	case LibraryEventPressure:
+		return "pressure"
+	case LibraryEventInvalidRelease:
+		return "invalid release"
+	}
+	return fmt.Sprintf("LibraryEventKind(%d)", uint32(kind))
+}
+
+// LibraryEvent is something that happened in the allocator library, see
+// SubscribeLibraryEvents.
+type LibraryEvent struct {
+	Kind  LibraryEventKind
+	Value uint64
+}
+
+var libraryEvents struct {
+	once        sync.Once
+	mu          sync.Mutex
+	subscribers map[chan LibraryEvent]struct{}
+}
+
+// SubscribeLibraryEvents delivers the library's events, e.g., the end of a
+// TrimInBackground, to the returned channel until the returned func is
+// called, which closes it. Events arrive as they happen, whichever thread
+// they happen on, and the library never waits for them: an event that
+// doesn't fit in buffer is dropped. Libraries without CapabilityEvents have
+// none to deliver.
+func SubscribeLibraryEvents(buffer int) (<-chan LibraryEvent, func()) {
+	libraryEvents.once.Do(func() {
+		reportEvents()
+	})
+
+	events := make(chan LibraryEvent, buffer)
+	libraryEvents.mu.Lock()
+	if libraryEvents.subscribers == nil {
+		libraryEvents.subscribers = make(map[chan LibraryEvent]struct{})
+	}
+	libraryEvents.subscribers[events] = struct{}{}
+	libraryEvents.mu.Unlock()
+
+	var unsubscribe sync.Once
+	return events, func() {
+		unsubscribe.Do(func() {
+			libraryEvents.mu.Lock()
+			delete(libraryEvents.subscribers, events)
+			libraryEvents.mu.Unlock()
+			close(events)
+		})
+	}
+}
+
+// emitLibraryEvent hands event to every subscriber with room for it.
+func emitLibraryEvent(event LibraryEvent) {
+	libraryEvents.mu.Lock()
+	defer libraryEvents.mu.Unlock()
+
+	for events := range libraryEvents.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// TrimInBackground frees the library's cached buffers, like FreeOSMemory
+// does, without waiting for it to finish. Subscribers are sent a
+// LibraryEventTrimmed once it has.
+func TrimInBackground() {
+	if !trimAsync() {
+		go rustAllocator{}.Trim()
+	}
+}
@@ -0,0 +1,16 @@
+//go:build cgo
+
+package rustybuffer
+
+// The export is kept apart from rust.go, whose preamble defines functions,
+// which a file with exports can't.
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+//export rbgo_library_event
+func rbgo_library_event(kind C.uint32_t, value C.uint64_t) {
+	emitLibraryEvent(LibraryEvent{LibraryEventKind(kind), uint64(value)})
+}
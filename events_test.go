@@ -0,0 +1,71 @@
+package rustybuffer
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// nextEvent waits for the next event of kind, skipping others.
+func nextEvent(t *testing.T, events <-chan LibraryEvent, kind LibraryEventKind) LibraryEvent {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Kind == kind {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %v event", kind)
+		}
+	}
+}
+
+func TestLibraryEvents(t *testing.T) {
+	info, err := LibraryInfo()
+	if err != nil {
+		t.Skip(err)
+	}
+	if !info.Capabilities.Has(CapabilityEvents) {
+		t.Skip("the library doesn't report events")
+	}
+
+	events, unsubscribe := SubscribeLibraryEvents(16)
+	defer unsubscribe()
+
+	var bogus uint8
+	alloc := NewRustAllocator()
+	if err := alloc.Release(unsafe.Pointer(&bogus)); err == nil {
+		t.Fatal("expected the release to fail")
+	}
+	event := nextEvent(t, events, LibraryEventInvalidRelease)
+	if event.Value != uint64(uintptr(unsafe.Pointer(&bogus))) {
+		t.Fatalf("expected %p, got %#x", &bogus, event.Value)
+	}
+
+	data, err := alloc.Acquire(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alloc.Release(data); err != nil {
+		t.Fatal(err)
+	}
+	TrimInBackground()
+	nextEvent(t, events, LibraryEventTrimmed)
+}
+
+func TestUnsubscribeLibraryEvents(t *testing.T) {
+	events, unsubscribe := SubscribeLibraryEvents(1)
+	unsubscribe()
+	unsubscribe()
+
+	emitLibraryEvent(LibraryEvent{LibraryEventPressure, 1})
+	if _, ok := <-events; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+
+	if name := LibraryEventKind(9).String(); name != "LibraryEventKind(9)" {
+		t.Fatalf("unexpected name %q", name)
+	}
+}
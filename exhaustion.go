@@ -0,0 +1,263 @@
+package rustybuffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// ExhaustionPolicy decides what a Pool does when its allocator is full,
+// i.e., Acquire fails with ErrNoBufferAvailable.
+type ExhaustionPolicy int
+
+const (
+	// ExhaustionError returns the allocator's error. This is the default.
+	ExhaustionError ExhaustionPolicy = iota
+
+	// ExhaustionBlock waits for entries to be released and tries again
+	// until it succeeds or the acquire's context (see WithContext) is done.
+	// Higher priority acquires go first (see WithPriority).
+	ExhaustionBlock
+
+	// ExhaustionHeap hands out Go heap memory instead, whenever the
+	// allocator fails and not just when it's full, so that a service
+	// degrades to ordinary slices during a spike rather than erroring. The
+	// pool reports these as Stats.FallbackBytes.
+	ExhaustionHeap
+
+	// ExhaustionSpill hands out memory backed by an unlinked temporary
+	// file, which is only supported on unix platforms.
+	ExhaustionSpill
+)
+
+func (policy ExhaustionPolicy) String() string {
+	switch policy {
+	case ExhaustionError:
+		return "error"
+	case ExhaustionBlock:
+		return "block"
+	case ExhaustionHeap:
+		return "heap"
+	case ExhaustionSpill:
+		return "spill"
+	default:
+		return fmt.Sprintf("ExhaustionPolicy(%d)", int(policy))
+	}
+}
+
+// WithExhaustionPolicy sets the pool's default ExhaustionPolicy, which
+// individual acquires can override with OnExhaustion.
+func WithExhaustionPolicy(policy ExhaustionPolicy) PoolOption {
+	return func(pool *Pool) {
+		pool.policy = policy
+	}
+}
+
+// WithSpillThreshold sends requests larger than size bytes straight to a
+// temporary file whatever the exhaustion policy, so that the occasional
+// huge payload doesn't need a pool sized for it. Zero, the default,
+// disables this.
+func WithSpillThreshold(size uint64) PoolOption {
+	return func(pool *Pool) {
+		pool.spill_threshold = size
+	}
+}
+
+// WithSpillDir sets the directory spill files are created in. The default
+// is os.TempDir(). The files are unlinked as soon as they're created so
+// nothing is ever left behind.
+func WithSpillDir(dir string) PoolOption {
+	return func(pool *Pool) {
+		pool.spill = newSpillAllocator(dir)
+	}
+}
+
+type acquireOptions struct {
+	ctx      context.Context
+	policy   ExhaustionPolicy
+	priority Priority
+	tag      string
+	labels   map[string]string
+
+	// The page size of an acquire WithHugePages, or zero.
+	huge_page_size uint64
+
+	provenance *Provenance
+	taint      *string
+}
+
+// AcquireOption tweaks a single Pool.AllocBuffers call.
+type AcquireOption func(opts *acquireOptions)
+
+// OnExhaustion overrides the pool's ExhaustionPolicy for one acquire.
+func OnExhaustion(policy ExhaustionPolicy) AcquireOption {
+	return func(opts *acquireOptions) {
+		opts.policy = policy
+	}
+}
+
+// WithContext bounds how long ExhaustionBlock waits. Without it a blocked
+// acquire waits for as long as it takes.
+func WithContext(ctx context.Context) AcquireOption {
+	return func(opts *acquireOptions) {
+		opts.ctx = ctx
+	}
+}
+
+// acquireOptions applies opts on top of the pool's defaults.
+func (pool *Pool) acquireOptions(opts []AcquireOption) acquireOptions {
+	options := acquireOptions{
+		ctx:    context.Background(),
+		policy: pool.policy,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if pool.fixed_policy {
+		options.policy = pool.policy
+	}
+	return options
+}
+
+// acquire gets size bytes from the pool's allocator, applying the
+// exhaustion policy if it's full. It returns the Allocator the memory has
+// to be released to, which isn't the pool's for heap, spilled or huge page
+// memory.
+func (pool *Pool) acquire(
+	size uint64,
+	options acquireOptions,
+) (Allocator, unsafe.Pointer, error) {
+	if options.huge_page_size != 0 {
+		data, err := pool.huge_pages.acquirePages(size, options.huge_page_size)
+		return pool.huge_pages, data, err
+	}
+	if pool.spill_threshold != 0 && size > pool.spill_threshold {
+		data, err := pool.spill.Acquire(size)
+		return pool.spill, data, err
+	}
+
+	// Set once the acquire has had to wait, see WithPriority, along with
+	// the timer for it to check again after priorityAging.
+	var queued *waiter
+	var aging *time.Timer
+	defer func() {
+		if queued != nil {
+			waiting.leave(queued)
+		}
+		if aging != nil {
+			aging.Stop()
+		}
+	}()
+
+	for {
+		// Grab the channel before trying so that a release between the
+		// failed Acquire and the wait below isn't missed.
+		released := releases.wait()
+
+		if options.policy == ExhaustionBlock &&
+			waiting.outranked(pool, options.priority, queued) {
+			if queued == nil {
+				queued = waiting.join(pool, options, size)
+			}
+
+			// Check again once aging might have changed the order, even
+			// if nothing is released. A timer that fired while something
+			// else woke the wait is drained, so the next wait is a whole
+			// priorityAging.
+			if aging == nil {
+				aging = time.NewTimer(priorityAging)
+			} else {
+				if !aging.Stop() {
+					select {
+					case <-aging.C:
+					default:
+					}
+				}
+				aging.Reset(priorityAging)
+			}
+			select {
+			case <-released:
+			case <-aging.C:
+			case <-options.ctx.Done():
+				return pool.alloc, nil, fmt.Errorf("%w: %w",
+					newError(codeNoBufferAvailable,
+						"waiting for %d bytes behind higher priority acquires", size),
+					options.ctx.Err())
+			}
+			continue
+		}
+
+		data, err := pool.alloc.Acquire(size)
+		if err == nil {
+			return pool.alloc, data, nil
+		}
+
+		if options.policy == ExhaustionHeap {
+			data, err := pool.heap.Acquire(size)
+			return pool.heap, data, err
+		}
+
+		if !errors.Is(err, ErrNoBufferAvailable) {
+			return pool.alloc, nil, err
+		}
+
+		switch options.policy {
+		case ExhaustionSpill:
+			data, err := pool.spill.Acquire(size)
+			return pool.spill, data, err
+		case ExhaustionBlock:
+			// Nothing will ever be released that makes room for a
+			// request larger than the whole pool.
+			stats := pool.alloc.Stats()
+			if stats.MaxTotalSize != 0 && size > stats.MaxTotalSize {
+				return pool.alloc, nil, err
+			}
+			if queued == nil {
+				queued = waiting.join(pool, options, size)
+			}
+
+			select {
+			case <-released:
+			case <-options.ctx.Done():
+				return pool.alloc, nil, fmt.Errorf("%w: %w", err, options.ctx.Err())
+			}
+		default:
+			return pool.alloc, nil, err
+		}
+	}
+}
+
+// releaseNotifier wakes up acquires blocked by ExhaustionBlock. Every
+// release wakes every waiter, whichever pool they're waiting on, and they
+// just try again. That's simpler than tracking which pools share an
+// allocator (all the Rust backed ones do) and waiters are rare.
+type releaseNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+var releases releaseNotifier
+
+// wait returns a channel that's closed by the next notify.
+func (notifier *releaseNotifier) wait() <-chan struct{} {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	if notifier.ch == nil {
+		notifier.ch = make(chan struct{})
+	}
+	return notifier.ch
+}
+
+func (notifier *releaseNotifier) notify() {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	if notifier.ch != nil {
+		close(notifier.ch)
+		notifier.ch = nil
+	}
+}
@@ -0,0 +1,210 @@
+package rustybuffer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExhaustionError(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	entry, err := pool.AllocBuffers([]uint64{1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	_, err = pool.AllocBuffers([]uint64{1})
+	if !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+}
+
+func TestExhaustionHeap(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc), WithExhaustionPolicy(ExhaustionHeap))
+
+	first, err := pool.AllocBuffers([]uint64{1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Release()
+
+	second, err := pool.AllocBuffers([]uint64{10, 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Buffers) != 2 || len(second.Buffers[1]) != 20 {
+		t.Fatalf("unexpected buffers: %v", second.Buffers)
+	}
+	if alloc.Stats().BytesInUse != 1024 {
+		t.Fatalf("fallback came from the pool: %+v", alloc.Stats())
+	}
+	if pool.Stats().FallbackBytes != 30 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+
+	// Oversized requests fall back too.
+	third, err := pool.AllocBuffers([]uint64{2048})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool.Stats().FallbackBytes != 2078 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+
+	second.Release()
+	third.Release()
+	if pool.Stats().FallbackBytes != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+
+	// The policy can be overridden per acquire.
+	_, err = pool.AllocBuffers([]uint64{1}, OnExhaustion(ExhaustionError))
+	if !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+}
+
+func TestExhaustionSpill(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 8192)))
+
+	first, err := pool.AllocBuffers([]uint64{1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Release()
+
+	entry, err := pool.AllocBuffers([]uint64{4096, 100}, OnExhaustion(ExhaustionSpill))
+	if errors.Is(err, ErrAllocationFailed) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, buf := range entry.Buffers {
+		for idx := range buf {
+			if buf[idx] != 0 {
+				t.Fatal("spilled memory isn't zeroed")
+			}
+			buf[idx] = 0xff
+		}
+	}
+	if pool.spill.Stats().BytesInUse != 4196 {
+		t.Fatalf("unexpected spill stats: %+v", pool.spill.Stats())
+	}
+
+	entry.Release()
+	if pool.spill.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected spill stats: %+v", pool.spill.Stats())
+	}
+}
+
+func TestExhaustionBlock(t *testing.T) {
+	pool := NewPool(
+		WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithExhaustionPolicy(ExhaustionBlock),
+	)
+
+	first, err := pool.AllocBuffers([]uint64{1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		entry, err := pool.AllocBuffers([]uint64{512})
+		entry.Release()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("acquire didn't block: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	first.Release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExhaustionBlockContext(t *testing.T) {
+	pool := NewPool(
+		WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithExhaustionPolicy(ExhaustionBlock),
+	)
+
+	first, err := pool.AllocBuffers([]uint64{1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = pool.AllocBuffers([]uint64{1}, WithContext(ctx))
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Requests that could never fit fail straight away.
+	big := NewPool(
+		WithAllocator(NewHeapAllocator(1024, 4096)),
+		WithExhaustionPolicy(ExhaustionBlock),
+	)
+	_, err = big.AllocBuffers([]uint64{2048})
+	if !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+}
+
+func TestSpillThreshold(t *testing.T) {
+	dir := t.TempDir()
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(
+		WithAllocator(alloc),
+		WithSpillThreshold(512),
+		WithSpillDir(dir),
+	)
+
+	small, err := pool.AllocBuffers([]uint64{512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer small.Release()
+
+	big, err := pool.AllocBuffers([]uint64{1 << 20})
+	if errors.Is(err, ErrAllocationFailed) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := pool.Stats()
+	if stats.BytesInUse != 512 || stats.SpilledBytes != 1<<20 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// The spill file is unlinked straight away.
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("spill files left behind: %v", names)
+	}
+
+	big.Buffers[0][1<<19] = 1
+	big.Release()
+	if pool.Stats().SpilledBytes != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+}
@@ -0,0 +1,151 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"unsafe"
+)
+
+// How many released entries a pool with fault diagnostics remembers.
+const faultReleased = 64
+
+// WithFaultDiagnostics has the pool remember where its live and recently
+// released entries are, so that ContainFaults can say which entry a
+// memory fault was in rather than the process dying with nothing but an
+// address.
+func WithFaultDiagnostics() PoolOption {
+	return func(pool *Pool) {
+		pool.faults = &faultRegistry{live: make(map[unsafe.Pointer]faultEntry)}
+	}
+}
+
+// FaultError describes a memory fault caught by ContainFaults.
+type FaultError struct {
+	// The address that faulted.
+	Addr uintptr
+
+	// The entry it was in or next to, if the pool knows of one.
+	Handle Handle
+
+	// What the address was: "released" entry memory, a "guard page"
+	// beside a live entry, "live" entry memory (written through a read
+	// only view, say) or "unknown".
+	State string
+
+	// The stack of the goroutine that faulted.
+	Stack []byte
+}
+
+func (fault *FaultError) Error() string {
+	if fault.Handle == 0 {
+		return fmt.Sprintf("rustybuffer: memory fault at %#x (%s)", fault.Addr, fault.State)
+	}
+	return fmt.Sprintf("rustybuffer: memory fault at %#x (%s, entry %s)",
+		fault.Addr, fault.State, fault.Handle)
+}
+
+// ContainFaults runs fn with a memory fault on memory Go doesn't manage,
+// e.g., an unmapped or guard page, turned into a panic carrying a
+// *FaultError that says which of the pool's entries it was in, instead of
+// crashing the process. Only memory that's actually inaccessible faults:
+// a released buffer the allocator keeps cached can still be read, and
+// only allocators that unmap released memory or surround it with guard
+// pages (NewSecurePool's, spill files, shared segments once closed)
+// produce faults to contain. Other panics pass through untouched.
+//
+// Faults are only turned into panics for the goroutine running fn (see
+// debug.SetPanicOnFault), and the pool needs WithFaultDiagnostics to name
+// the entry.
+func (pool *Pool) ContainFaults(fn func()) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		fault, ok := recovered.(interface {
+			runtime.Error
+			Addr() uintptr
+		})
+		if !ok {
+			panic(recovered)
+		}
+
+		err := &FaultError{Addr: fault.Addr(), State: "unknown", Stack: debug.Stack()}
+		if pool.faults != nil {
+			err.Handle, err.State = pool.faults.describe(fault.Addr())
+		}
+		panic(err)
+	}()
+
+	fn()
+}
+
+type faultEntry struct {
+	data   uintptr
+	size   uint64
+	handle Handle
+}
+
+func (entry faultEntry) contains(addr uintptr, slack uintptr) bool {
+	return addr+slack >= entry.data && addr < entry.data+uintptr(entry.size)+slack
+}
+
+// faultRegistry is every live entry in a pool, and the last few released.
+type faultRegistry struct {
+	mu       sync.Mutex
+	live     map[unsafe.Pointer]faultEntry
+	released [faultReleased]faultEntry
+	next     int
+}
+
+func (registry *faultRegistry) track(state *entryState) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.live[state.data] = faultEntry{uintptr(state.data), state.size, state.handle}
+}
+
+func (registry *faultRegistry) forget(data unsafe.Pointer) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	entry, ok := registry.live[data]
+	if !ok {
+		return
+	}
+	delete(registry.live, data)
+	registry.released[registry.next] = entry
+	registry.next = (registry.next + 1) % faultReleased
+}
+
+// describe says what addr is: the most recent release wins over a live
+// entry's guard pages, since that's the memory that's likely unmapped.
+func (registry *faultRegistry) describe(addr uintptr) (Handle, string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for _, entry := range registry.live {
+		if entry.contains(addr, 0) {
+			return entry.handle, "live"
+		}
+	}
+	for idx := 1; idx <= faultReleased; idx++ {
+		entry := registry.released[(registry.next-idx+faultReleased)%faultReleased]
+		if entry.handle != 0 && entry.contains(addr, 0) {
+			return entry.handle, "released"
+		}
+	}
+	// A guard page can be up to a page before the padding in front of the
+	// buffer, see NewSecurePool.
+	page := uintptr(os.Getpagesize())
+	for _, entry := range registry.live {
+		if entry.contains(addr, 2*page) {
+			return entry.handle, "guard page"
+		}
+	}
+	return 0, "unknown"
+}
@@ -0,0 +1,71 @@
+//go:build linux || darwin
+
+package rustybuffer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// catchFault runs fn under pool.ContainFaults, returning the fault.
+func catchFault(t *testing.T, pool *Pool, fn func()) (fault *FaultError) {
+	t.Helper()
+	defer func() {
+		recovered := recover()
+		var ok bool
+		if fault, ok = recovered.(*FaultError); !ok {
+			t.Fatalf("expected a *FaultError, got %v", recovered)
+		}
+	}()
+	pool.ContainFaults(fn)
+	return nil
+}
+
+func TestContainFaults(t *testing.T) {
+	secure := NewSecurePool(1<<20, 1<<20, WithFaultDiagnostics())
+	pool := secure.Pool
+	entry, err := pool.AllocBuffers([]uint64{100})
+	if err != nil {
+		if strings.Contains(err.Error(), "RLIMIT_MEMLOCK") {
+			t.Skip(err)
+		}
+		t.Fatal(err)
+	}
+
+	data := unsafe.SliceData(entry.Buffers[0])
+	fault := catchFault(t, pool, func() {
+		*(*byte)(unsafe.Add(unsafe.Pointer(data), 200)) = 1
+	})
+	if fault.Handle != entry.Handle() || fault.State != "guard page" ||
+		!strings.Contains(string(fault.Stack), "TestContainFaults") {
+		t.Fatalf("unexpected fault %v", fault)
+	}
+
+	handle := entry.Handle()
+	entry.Release()
+	var sink byte
+	fault = catchFault(t, pool, func() {
+		sink = *data
+	})
+	_ = sink
+	if fault.Handle != handle || fault.State != "released" {
+		t.Fatalf("unexpected fault %v", fault)
+	}
+	if !strings.Contains(fault.Error(), handle.String()) {
+		t.Fatalf("unexpected message %q", fault.Error())
+	}
+}
+
+func TestContainFaultsOtherPanics(t *testing.T) {
+	sentinel := errors.New("not a fault")
+	defer func() {
+		if recovered := recover(); recovered != sentinel {
+			t.Fatalf("expected the panic to pass through, got %v", recovered)
+		}
+	}()
+	NewPool().ContainFaults(func() {
+		panic(sentinel)
+	})
+}
@@ -0,0 +1,97 @@
+package rustybuffer
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Below this many bytes the cost of calling into the Rust library is more
+// than filling the buffer in Go.
+const fillNativeThreshold = 64 * 1024
+
+// Fill sets every byte of every buffer in the entry to value. Large
+// buffers are filled by the Rust library, with SIMD where the CPU has it,
+// rather than a byte at a time in Go.
+func (entry *RBEntry) Fill(value byte) {
+	shadow := entry.state.shadowing()
+	for _, buffer := range entry.Buffers {
+		fillBytes(buffer, value)
+	}
+	shadow.wrote(entry.Buffers...)
+}
+
+// FillRange sets length bytes to value starting offset bytes into the
+// entry, counting through its buffers in order as if they were one. Like
+// slicing, a range that goes past the end of the entry panics.
+func (entry *RBEntry) FillRange(offset uint64, length uint64, value byte) {
+	var size uint64 = 0
+	for _, buffer := range entry.Buffers {
+		size += uint64(len(buffer))
+	}
+	if offset > size || length > size-offset {
+		panic(fmt.Sprintf("rustybuffer: fill range [%d:%d] out of range with length %d",
+			offset, offset+length, size))
+	}
+
+	shadow := entry.state.shadowing()
+	defer shadow.wrote(entry.Buffers...)
+
+	for _, buffer := range entry.Buffers {
+		if length == 0 {
+			return
+		}
+		if offset >= uint64(len(buffer)) {
+			offset -= uint64(len(buffer))
+			continue
+		}
+
+		count := min(length, uint64(len(buffer))-offset)
+		fillBytes(buffer[offset:offset+count], value)
+		offset = 0
+		length -= count
+	}
+}
+
+// FillRandom fills every buffer in the entry with random bytes from the
+// OS's cryptographically secure generator, fit for keys, nonces and
+// padding. The bytes are read by the Rust library straight into the
+// entry's memory, a pool's locked memory say (see NewSecurePool), so
+// they're never staged on the Go heap. Where the library can't, the same
+// is done with crypto/rand.
+func (entry *RBEntry) FillRandom() error {
+	shadow := entry.state.shadowing()
+	defer shadow.wrote(entry.Buffers...)
+
+	for _, buffer := range entry.Buffers {
+		if err := fillRandom(buffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// goFillRandom reads buf's random bytes with crypto/rand, which also writes
+// them straight into buf.
+func goFillRandom(buf []byte) error {
+	if _, err := rand.Read(buf); err != nil {
+		return newError(codeAllocationFailed, "reading %d random bytes: %v", len(buf), err)
+	}
+	return nil
+}
+
+// goFill is the pure Go fill, doubling what's been filled with each copy so
+// that it runs at memmove speed rather than a byte at a time.
+func goFill(buf []byte, value byte) {
+	if value == 0 {
+		clear(buf)
+		return
+	}
+	if len(buf) == 0 {
+		return
+	}
+
+	buf[0] = value
+	for filled := 1; filled < len(buf); filled *= 2 {
+		copy(buf[filled:], buf[:filled])
+	}
+}
@@ -0,0 +1,114 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFill(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<24, 1<<24)))
+
+	// Big enough for the library, small enough not to be, and empty.
+	sizes := []uint64{fillNativeThreshold * 3, 100, 0, 7}
+	entry, err := pool.AllocBuffers(sizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	for _, value := range []byte{0xA5, 0} {
+		entry.Fill(value)
+		for idx, buffer := range entry.Buffers {
+			if !bytes.Equal(buffer, bytes.Repeat([]byte{value}, len(buffer))) {
+				t.Fatalf("buffer %d isn't filled with %#x", idx, value)
+			}
+		}
+	}
+}
+
+func TestFillRange(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<24, 1<<24)))
+
+	entry, err := pool.AllocBuffers([]uint64{10, 0, fillNativeThreshold * 2, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	// From the middle of the first buffer into the last.
+	start := uint64(5)
+	end := uint64(10 + fillNativeThreshold*2 + 3)
+	entry.FillRange(start, end-start, 1)
+
+	var offset uint64 = 0
+	for idx, buffer := range entry.Buffers {
+		for pos, b := range buffer {
+			expected := byte(0)
+			if offset >= start && offset < end {
+				expected = 1
+			}
+			if b != expected {
+				t.Fatalf("buffer %d byte %d (offset %d) is %d", idx, pos, offset, b)
+			}
+			offset++
+		}
+	}
+
+	entry.FillRange(offset, 0, 2)
+
+	defer func() {
+		msg, _ := recover().(string)
+		if !strings.Contains(msg, "out of range") {
+			t.Fatalf("expected an out of range panic, got %q", msg)
+		}
+	}()
+	entry.FillRange(offset-1, 2, 2)
+}
+
+func TestGoFill(t *testing.T) {
+	for _, size := range []int{0, 1, 2, 3, 1000, 1025} {
+		buf := make([]byte, size)
+		goFill(buf, 9)
+		if !bytes.Equal(buf, bytes.Repeat([]byte{9}, size)) {
+			t.Fatalf("%d bytes weren't filled", size)
+		}
+	}
+}
+
+func BenchmarkFill(b *testing.B) {
+	buf := make([]byte, 64<<20)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		fillBytes(buf, byte(i))
+	}
+}
+
+func TestFillRandom(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+	entry, err := pool.AllocBuffers([]uint64{4096, 0, 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	if err := entry.FillRandom(); err != nil {
+		t.Fatal(err)
+	}
+	for idx, buffer := range entry.Buffers {
+		// Any 32 bytes being all zeros would be astronomically unlikely.
+		for offset := 0; offset+32 <= len(buffer); offset += 32 {
+			if bytes.Equal(buffer[offset:offset+32], make([]byte, 32)) {
+				t.Fatalf("buffer %d wasn't filled at %d", idx, offset)
+			}
+		}
+	}
+
+	first := bytes.Clone(entry.Buffers[2])
+	if err := entry.FillRandom(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, entry.Buffers[2]) {
+		t.Fatal("the same bytes twice")
+	}
+}
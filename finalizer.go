@@ -0,0 +1,145 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+)
+
+// entryState is shared by every copy of an RBEntry, which are passed
+// around by value, so it's the one thing a finalizer can be attached to
+// that only becomes unreachable once the entry really has been dropped.
+type entryState struct {
+	pool   *Pool
+	handle Handle
+	data   unsafe.Pointer
+	size   uint64
+	alloc  Allocator
+
+	// Whether the entry's been released, and its pins.
+	*entryPins
+
+	// Set once the entry has labels, see WithLabels.
+	labels atomic.Pointer[entryLabels]
+
+	// Set if the entry was acquired WithProvenance.
+	provenance *Provenance
+
+	// Why the entry is tainted, until it's sanitized, see WithTaint.
+	taint atomic.Pointer[string]
+
+	// Where the entry was acquired, only recorded for the finalizer.
+	callers []uintptr
+
+	// Set if the pool tracks leaks, in which case the finalizer leaves
+	// releasing the entry to Pool.Reconcile.
+	tracker *leakTracker
+
+	// A copy of the entry's memory, if its pool keeps them, see
+	// WithShadowVerification.
+	shadow *shadowCopy
+}
+
+func newEntryState(pool *Pool, data unsafe.Pointer, size uint64, alloc Allocator) *entryState {
+	state := &entryState{
+		pool:   pool,
+		handle: nextHandle(),
+		data:   data,
+		size:   size,
+		alloc:  alloc,
+
+		entryPins: &entryPins{},
+	}
+
+	if pool.tracker == nil && !pool.finalize.Load() {
+		return state
+	}
+
+	state.callers = make([]uintptr, 32)
+	state.callers = state.callers[:runtime.Callers(2, state.callers)]
+	runtime.SetFinalizer(state, (*entryState).finalize)
+
+	if pool.tracker != nil {
+		state.tracker = pool.tracker
+		pool.tracker.track(data, trackedEntry{state.handle, alloc, size, state.callers})
+	}
+
+	return state
+}
+
+// WithLeakFinalizer gives every entry from the pool a finalizer that
+// releases it, logging where it was acquired, if it's garbage collected
+// without having been released.
+//
+// This is a safety net for leaks, not a replacement for Release: the
+// finalizer can't see the Buffers slices, so an entry dropped while a
+// slice of its memory is still in use will have that memory freed out
+// from under it.
+func WithLeakFinalizer() PoolOption {
+	return func(pool *Pool) {
+		pool.finalize.Store(true)
+	}
+}
+
+// EnableLeakFinalizer turns on WithLeakFinalizer for the default pool,
+// affecting entries allocated from then on.
+func EnableLeakFinalizer() {
+	defaultPool.finalize.Store(true)
+}
+
+func (state *entryState) finalize() {
+	// Something holding on to only the entry's pins, a checkpoint say, may
+	// still be reading its memory, in which case it's left for the next
+	// collection.
+	state.pin_mu.Lock()
+	if state.released.Load() {
+		state.pin_mu.Unlock()
+		return
+	}
+	if state.pins > 0 {
+		state.pin_mu.Unlock()
+		runtime.SetFinalizer(state, (*entryState).finalize)
+		return
+	}
+	state.released.Store(true)
+	state.pin_mu.Unlock()
+
+	if state.tracker != nil {
+		state.tracker.orphan(state.data)
+		return
+	}
+
+	debugReleased(state.data)
+	state.wipe()
+	err := state.alloc.Release(state.data)
+	labels := state.pool.forget(state.data)
+	releases.notify()
+	state.pool.checkWatermarks()
+
+	site := callersSite(state.callers)
+	if len(labels) > 0 {
+		site += " (" + formatLabels(labels) + ")"
+	}
+	if err != nil {
+		log.Printf("rustybuffer: failed to release leaked entry acquired at %s: %v",
+			site, err)
+		return
+	}
+	log.Printf("rustybuffer: released leaked entry acquired at %s", site)
+}
+
+// callersSite is the first caller outside of this package, or its tests.
+func callersSite(callers []uintptr) string {
+	frames := runtime.CallersFrames(callers)
+	for {
+		frame, more := frames.Next()
+		internal := strings.HasPrefix(frame.Function, "github.com/davisp/rustybuffer.") &&
+			!strings.HasSuffix(frame.File, "_test.go")
+		if !internal || !more {
+			return fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer the finalizer goroutine can log to.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (buf *syncBuffer) Write(data []byte) (int, error) {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	return buf.buf.Write(data)
+}
+
+func (buf *syncBuffer) String() string {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	return buf.buf.String()
+}
+
+func leakEntry(t *testing.T, pool *Pool, opts ...AcquireOption) {
+	_, err := pool.AllocBuffers([]uint64{100}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLeakFinalizer(t *testing.T) {
+	var output syncBuffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&output)
+
+	pool := NewPool(
+		WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithLeakFinalizer(),
+	)
+
+	leakEntry(t, pool)
+	if pool.Stats().BytesInUse != 100 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+
+	for idx := 0; idx < 100 && pool.Stats().BytesInUse != 0; idx++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatal("the leaked entry was never released")
+	}
+
+	for idx := 0; idx < 100 && output.String() == ""; idx++ {
+		time.Sleep(time.Millisecond)
+	}
+	if !strings.Contains(output.String(), "leakEntry") {
+		t.Fatalf("the acquisition site wasn't logged: %q", output.String())
+	}
+}
+
+func TestEntryCopies(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	entry, err := pool.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copied := entry
+	entry.Release()
+	copied.Release()
+	if copied.data != nil || pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+}
+
+func TestEntryConcurrentRelease(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	for iter := 0; iter < 100; iter++ {
+		entry, err := pool.AllocBuffers([]uint64{100})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for idx := 0; idx < 8; idx++ {
+			wg.Add(1)
+			go func(copied RBEntry) {
+				defer wg.Done()
+				copied.Release()
+			}(entry)
+		}
+		wg.Wait()
+	}
+
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+}
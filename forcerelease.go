@@ -0,0 +1,203 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// WithEntryDiagnostics has the pool keep track of its live entries, and
+// those released but still pinned, for Pool.EntryDiagnostics.
+func WithEntryDiagnostics() PoolOption {
+	return func(pool *Pool) {
+		if pool.entries == nil {
+			pool.entries = &entryRegistry{live: make(map[unsafe.Pointer]registeredEntry)}
+		}
+	}
+}
+
+// WithForceRelease is WithEntryDiagnostics that also allows
+// Pool.ForceRelease, which nothing else does: forcing an entry's release
+// is for an operator reclaiming memory from a wedged component, and left
+// off the pool can't be talked into it over a control socket.
+func WithForceRelease() PoolOption {
+	return func(pool *Pool) {
+		WithEntryDiagnostics()(pool)
+		pool.entries.allow_force = true
+	}
+}
+
+// registeredEntry is what the registry needs of a live entry to describe
+// it and force its release, with its pins in place of its state, which
+// would keep a leaked entry alive.
+type registeredEntry struct {
+	handle  Handle
+	data    unsafe.Pointer
+	size    uint64
+	alloc   Allocator
+	tracker *leakTracker
+	pins    *entryPins
+	labels  *entryLabels
+}
+
+type entryRegistry struct {
+	allow_force bool
+
+	mu   sync.Mutex
+	live map[unsafe.Pointer]registeredEntry
+}
+
+func (registry *entryRegistry) track(state *entryState) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.live[state.data] = registeredEntry{
+		handle:  state.handle,
+		data:    state.data,
+		size:    state.size,
+		alloc:   state.alloc,
+		tracker: state.tracker,
+		pins:    state.entryPins,
+	}
+}
+
+// label records the labels a live entry's been given.
+func (registry *entryRegistry) label(data unsafe.Pointer, labels *entryLabels) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if entry, ok := registry.live[data]; ok {
+		entry.labels = labels
+		registry.live[data] = entry
+	}
+}
+
+func (registry *entryRegistry) forget(data unsafe.Pointer) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	delete(registry.live, data)
+}
+
+// lookup returns the entry with handle, if it's still holding memory.
+func (registry *entryRegistry) lookup(handle Handle) (registeredEntry, bool) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for _, entry := range registry.live {
+		if entry.handle == handle {
+			return entry, true
+		}
+	}
+	return registeredEntry{}, false
+}
+
+// EntryDiagnostic is an entry holding memory in its pool, see
+// Pool.EntryDiagnostics.
+type EntryDiagnostic struct {
+	Entry Handle `json:"entry"`
+	Bytes uint64 `json:"bytes"`
+
+	// Outstanding pins (see RBEntry.Pin), counting those of checkpoints,
+	// exports and read only views, and how many are read only views.
+	Pins  int `json:"pins,omitempty"`
+	Views int `json:"views,omitempty"`
+
+	// Set if the entry's been released, and its memory is only held on
+	// to until its pins are gone.
+	Released bool `json:"released,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// EntryDiagnostics lists the entries holding memory in the pool, oldest
+// first, with their pins, for finding what's holding on to memory the
+// pool's callers think they've released. Pools created without
+// WithEntryDiagnostics list nothing.
+func (pool *Pool) EntryDiagnostics() []EntryDiagnostic {
+	if pool.entries == nil {
+		return nil
+	}
+
+	pool.entries.mu.Lock()
+	entries := make([]registeredEntry, 0, len(pool.entries.live))
+	for _, entry := range pool.entries.live {
+		entries = append(entries, entry)
+	}
+	pool.entries.mu.Unlock()
+
+	diagnostics := make([]EntryDiagnostic, len(entries))
+	for idx, entry := range entries {
+		pins := entry.pins
+		pins.pin_mu.Lock()
+		diagnostics[idx] = EntryDiagnostic{
+			Entry:    entry.handle,
+			Bytes:    entry.size,
+			Pins:     pins.pins,
+			Views:    pins.views,
+			Released: pins.released.Load(),
+		}
+		pins.pin_mu.Unlock()
+		if entry.labels != nil {
+			diagnostics[idx].Labels = entry.labels.get()
+		}
+	}
+	sort.Slice(diagnostics, func(i, j int) bool { return diagnostics[i].Entry < diagnostics[j].Entry })
+	return diagnostics
+}
+
+// ForceRelease gives back the memory of the entry with handle whether or
+// not it's pinned, or already released and waiting for its pins, logging
+// the entry and why. It's a last resort, for an operator reclaiming memory
+// from a component that's wedged holding on to it, during an incident,
+// without restarting the process: whatever still has the entry, or its
+// pinned address, is left with memory that can be handed to someone else,
+// exactly what Release and Pin are there to prevent. Unpinning the entry
+// afterwards does nothing.
+//
+// Only pools created WithForceRelease allow it.
+func (pool *Pool) ForceRelease(handle Handle, reason string) error {
+	if pool.entries == nil || !pool.entries.allow_force {
+		return errors.New("rustybuffer: force release from a pool created without WithForceRelease")
+	}
+	registered, ok := pool.entries.lookup(handle)
+	if !ok {
+		return fmt.Errorf("rustybuffer: force release of entry %s, which isn't holding memory in the pool", handle)
+	}
+
+	// A release or last unpin racing this one either gets the memory
+	// first, or finds there's nothing left for it to do.
+	entry_pins := registered.pins
+	entry_pins.pin_mu.Lock()
+	owned := entry_pins.released.CompareAndSwap(false, true) || entry_pins.release_pinned != nil
+	pins, views := entry_pins.pins, entry_pins.views
+	if owned {
+		entry_pins.forced = true
+		entry_pins.pins, entry_pins.views = 0, 0
+		entry_pins.release_pinned = nil
+	}
+	entry_pins.pin_mu.Unlock()
+	if !owned {
+		return fmt.Errorf("rustybuffer: force release of entry %s, which is already being released", handle)
+	}
+
+	log.Printf("rustybuffer: force releasing entry %s of %d bytes with %d pins (%d views): %s",
+		handle, registered.size, pins, views, reason)
+
+	// The entry itself may well be out of reach, so its memory is given
+	// back through a stand in sharing its pins, which are what its own
+	// release and finalizer check.
+	entry := RBEntry{data: registered.data, state: &entryState{
+		pool:      pool,
+		handle:    registered.handle,
+		data:      registered.data,
+		size:      registered.size,
+		alloc:     registered.alloc,
+		tracker:   registered.tracker,
+		entryPins: registered.pins,
+	}}
+	return entry.releaseMemory()
+}
@@ -0,0 +1,94 @@
+package rustybuffer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEntryDiagnostics(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)), WithEntryDiagnostics())
+
+	entry, err := pool.AllocBuffers([]uint64{100}, WithLabels(map[string]string{"owner": "indexer"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := pool.AllocBuffers([]uint64{50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Release()
+
+	_, unpin := entry.Pin()
+	_, unpin_again := entry.Pin()
+	diagnostics := pool.EntryDiagnostics()
+	if len(diagnostics) != 2 || diagnostics[0].Entry != entry.Handle() || diagnostics[0].Bytes != 100 ||
+		diagnostics[0].Pins != 2 || diagnostics[0].Released || diagnostics[0].Labels["owner"] != "indexer" {
+		t.Fatalf("unexpected diagnostics %+v", diagnostics)
+	}
+
+	// Released while pinned, its memory is still held.
+	handle := entry.Handle()
+	entry.Release()
+	unpin()
+	if diagnostics := pool.EntryDiagnostics(); len(diagnostics) != 2 || diagnostics[0].Pins != 1 || !diagnostics[0].Released {
+		t.Fatalf("unexpected diagnostics %+v", diagnostics)
+	}
+	unpin_again()
+	if diagnostics := pool.EntryDiagnostics(); len(diagnostics) != 1 || diagnostics[0].Entry == handle {
+		t.Fatalf("unexpected diagnostics %+v", diagnostics)
+	}
+
+	if err := pool.ForceRelease(other.Handle(), "testing"); err == nil || !strings.Contains(err.Error(), "WithForceRelease") {
+		t.Fatalf("expected force release to be refused, got %v", err)
+	}
+	if NewPool(WithAllocator(NewHeapAllocator(1024, 1024))).EntryDiagnostics() != nil {
+		t.Fatal("expected no diagnostics without WithEntryDiagnostics")
+	}
+}
+
+func TestForceRelease(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc), WithForceRelease())
+
+	// A wedged component's entry, pinned and released, that will never
+	// be unpinned.
+	wedged, err := pool.AllocBuffers([]uint64{300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, unpin := wedged.Pin()
+	copied := wedged
+	copied.Release()
+
+	// And one it hasn't even released.
+	held, err := pool.AllocBuffers([]uint64{200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, unpin_held := held.Pin()
+
+	if err := pool.ForceRelease(wedged.Handle(), "wedged"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.ForceRelease(held.Handle(), "wedged"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := alloc.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("expected everything to be given back, got %+v", stats)
+	}
+	if diagnostics := pool.EntryDiagnostics(); len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics %+v", diagnostics)
+	}
+
+	// What the component does afterwards doesn't release anything twice.
+	unpin()
+	unpin_held()
+	held.Release()
+	if stats := alloc.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if err := pool.ForceRelease(held.Handle(), "again"); err == nil {
+		t.Fatal("expected a second force release to fail")
+	}
+}
@@ -0,0 +1,113 @@
+package rustybuffer
+
+import (
+	"fmt"
+)
+
+// FragmentationReport describes a pool's free space, to tell whether an
+// acquire that fails is asking for more than is free (a capacity problem)
+// or for more than any one piece of what's free (a fragmentation problem),
+// see Fragmented.
+//
+// Allocators that cache buffers, the Rust library included, evict cached
+// buffers to make room, so their free space is a single extent of
+// everything not in use and their cache is reported separately. Whatever
+// fragmentation they suffer is in the system allocator beneath them. The
+// allocators carving buffers out of one arena, NewDeterministicAllocator
+// and NewRandomizedAllocator, report their holes.
+type FragmentationReport struct {
+	MaxBufferSize uint64
+
+	// The free bytes, and the largest extent of them.
+	FreeBytes         uint64
+	LargestFreeExtent uint64
+
+	// The largest acquire that would succeed now, from a free extent or
+	// by reusing a cached buffer.
+	LargestSatisfiable uint64
+
+	// The free extents and cached buffers by power of two size, smallest
+	// first.
+	FreeExtents   []ExtentBucket
+	CachedBuffers []ExtentBucket
+
+	// How broken up the free space is, from zero when it's all one extent
+	// to nearly one when the largest extent is a sliver of it.
+	Score float64
+}
+
+// ExtentBucket is the extents of up to Size bytes, and more than half
+// that.
+type ExtentBucket struct {
+	Size  uint64
+	Count uint64
+	Bytes uint64
+}
+
+// FragmentationReport describes the pool's free space. It fails if the
+// pool's allocator can't list its free extents, as interceptors (see Use)
+// and the Rust library before CapabilityCachedSizes can't.
+func (pool *Pool) FragmentationReport() (FragmentationReport, error) {
+	lister, ok := pool.alloc.(extentLister)
+	if !ok {
+		return FragmentationReport{}, fmt.Errorf("rustybuffer: %T can't list its free extents", pool.alloc)
+	}
+	extents, cached, err := lister.FreeExtents()
+	if err != nil {
+		return FragmentationReport{}, err
+	}
+
+	report := FragmentationReport{
+		MaxBufferSize: pool.alloc.Stats().MaxBufferSize,
+		FreeExtents:   extentHistogram(extents),
+		CachedBuffers: extentHistogram(cached),
+	}
+	for _, size := range extents {
+		report.FreeBytes += size
+		report.LargestFreeExtent = max(report.LargestFreeExtent, size)
+	}
+	report.LargestSatisfiable = report.LargestFreeExtent
+	for _, size := range cached {
+		report.LargestSatisfiable = max(report.LargestSatisfiable, size)
+	}
+	report.LargestSatisfiable = min(report.LargestSatisfiable, report.MaxBufferSize)
+	if report.FreeBytes > 0 {
+		report.Score = 1 - float64(report.LargestFreeExtent)/float64(report.FreeBytes)
+	}
+
+	return report, nil
+}
+
+// Fragmented reports whether an acquire of size bytes would fail only
+// because the free space is broken up: there are enough free bytes, and
+// size is within MaxBufferSize, but no extent or cached buffer holds it.
+func (report FragmentationReport) Fragmented(size uint64) bool {
+	return size > report.LargestSatisfiable && size <= report.FreeBytes && size <= report.MaxBufferSize
+}
+
+func extentHistogram(sizes []uint64) []ExtentBucket {
+	var buckets [64]ExtentBucket
+	for _, size := range sizes {
+		bucket := &buckets[sizeClassIndex(size)]
+		bucket.Count++
+		bucket.Bytes += size
+	}
+
+	var histogram []ExtentBucket
+	for idx, bucket := range buckets {
+		if bucket.Count > 0 {
+			bucket.Size = uint64(1) << idx
+			histogram = append(histogram, bucket)
+		}
+	}
+	return histogram
+}
+
+// cacheExtents is the free space of an allocator that makes room by
+// evicting what it caches: one extent of everything not in use.
+func cacheExtents(stats Stats) []uint64 {
+	if stats.BytesInUse >= stats.MaxTotalSize {
+		return nil
+	}
+	return []uint64{stats.MaxTotalSize - stats.BytesInUse}
+}
@@ -0,0 +1,82 @@
+package rustybuffer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFragmentationReport(t *testing.T) {
+	pool := NewPool(WithAllocator(NewDeterministicAllocator(1024, 1024, 0)))
+
+	var entries []RBEntry
+	for idx := 0; idx < 4; idx++ {
+		entry, err := pool.AllocBuffers([]uint64{256})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	entries[0].Release()
+	entries[2].Release()
+	defer entries[1].Release()
+	defer entries[3].Release()
+
+	report, err := pool.FragmentationReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := FragmentationReport{
+		MaxBufferSize:      1024,
+		FreeBytes:          512,
+		LargestFreeExtent:  256,
+		LargestSatisfiable: 256,
+		FreeExtents:        []ExtentBucket{{256, 2, 512}},
+		Score:              0.5,
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, report)
+	}
+	if report.Fragmented(256) || !report.Fragmented(257) || report.Fragmented(513) {
+		t.Fatalf("unexpected diagnoses from %+v", report)
+	}
+}
+
+func TestFragmentationReportCache(t *testing.T) {
+	pool := NewPool()
+	entry, err := pool.AllocBuffers([]uint64{3000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+
+	report, err := pool.FragmentationReport()
+	if info, _ := LibraryInfo(); !info.Has(CapabilityCachedSizes) {
+		if err == nil {
+			t.Fatal("expected an error without CapabilityCachedSizes")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := pool.Stats()
+	if report.Score != 0 || report.FreeBytes != stats.MaxTotalSize-stats.BytesInUse ||
+		report.LargestSatisfiable != min(report.FreeBytes, stats.MaxBufferSize) {
+		t.Fatalf("unexpected report %+v for %+v", report, stats)
+	}
+	found := false
+	for _, bucket := range report.CachedBuffers {
+		found = found || bucket.Size == 4096
+	}
+	if !found {
+		t.Fatalf("expected a cached 4096 byte bucket, got %+v", report.CachedBuffers)
+	}
+
+	logged := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	var log []string
+	logged.Use(recording("log", &log))
+	if _, err := logged.FragmentationReport(); err == nil {
+		t.Fatal("expected an error through an interceptor")
+	}
+}
@@ -0,0 +1,228 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// FramePlane is one plane of a frame's pixels or samples: Rows rows of
+// Stride bytes each.
+type FramePlane struct {
+	Stride int
+	Rows   int
+}
+
+// FrameFormat describes the planes of a frame, e.g., from YUV420Format.
+// Frames are recycled between formats that are the same in every field.
+type FrameFormat struct {
+	// The format's name, ffmpeg's pix_fmt say, for the caller's benefit.
+	Name   string
+	Width  int
+	Height int
+	Planes []FramePlane
+}
+
+// YUV420Format is ffmpeg's yuv420p for width by height frames: a full
+// size luma plane and two chroma planes of half the width and height,
+// rounded up, with every stride rounded up to a multiple of align, which
+// must be a power of two (32 or 64 suit codecs' SIMD).
+func YUV420Format(width int, height int, align int) FrameFormat {
+	chroma_width, chroma_height := (width+1)/2, (height+1)/2
+	return FrameFormat{
+		Name:   "yuv420p",
+		Width:  width,
+		Height: height,
+		Planes: []FramePlane{
+			{Stride: alignStride(width, align), Rows: height},
+			{Stride: alignStride(chroma_width, align), Rows: chroma_height},
+			{Stride: alignStride(chroma_width, align), Rows: chroma_height},
+		},
+	}
+}
+
+// PackedFormat is a format with a single plane of bytes_per_pixel bytes
+// per pixel, e.g., "rgba" with 4 or interleaved "s16" stereo audio with 4
+// and a height of one, with the stride rounded up to a multiple of align.
+func PackedFormat(name string, width int, height int, bytes_per_pixel int, align int) FrameFormat {
+	return FrameFormat{
+		Name:   name,
+		Width:  width,
+		Height: height,
+		Planes: []FramePlane{{Stride: alignStride(width*bytes_per_pixel, align), Rows: height}},
+	}
+}
+
+func alignStride(stride int, align int) int {
+	if align <= 1 {
+		return stride
+	}
+	return (stride + align - 1) &^ (align - 1)
+}
+
+// sizes returns each plane's size in bytes and the key frames of the
+// format are recycled under.
+func (format FrameFormat) sizes() ([]uint64, string, error) {
+	if len(format.Planes) == 0 {
+		return nil, "", fmt.Errorf("rustybuffer: frame format %q has no planes", format.Name)
+	}
+	sizes := make([]uint64, len(format.Planes))
+	for idx, plane := range format.Planes {
+		if plane.Stride < 0 || plane.Rows < 0 {
+			return nil, "", fmt.Errorf("rustybuffer: frame format %q has an invalid plane %d: %+v",
+				format.Name, idx, plane)
+		}
+		high, size := bits.Mul64(uint64(plane.Stride), uint64(plane.Rows))
+		if high != 0 {
+			return nil, "", newError(codeBufferTooLarge, "frame format %q plane %d is too large", format.Name, idx)
+		}
+		sizes[idx] = size
+	}
+	return sizes, fmt.Sprintf("%s %dx%d %v", format.Name, format.Width, format.Height, format.Planes), nil
+}
+
+// FramePool hands out frames of fixed size, strided planes for media
+// pipelines, e.g., to decode into with a codec's cgo bindings, and keeps
+// released frames to hand out again for the same format rather than going
+// back to the allocator for every one. Frames are reference counted so
+// that a frame shared between stages (an encoder and a preview, say) goes
+// back once the last of them is done with it. A FramePool is safe for
+// concurrent use.
+type FramePool struct {
+	pool *Pool
+	keep int
+
+	mu     sync.Mutex
+	idle   map[string][]RBEntry
+	closed bool
+}
+
+// NewFramePool returns a frame pool drawing on pool that keeps up to keep
+// released frames of each format.
+func NewFramePool(pool *Pool, keep int) *FramePool {
+	return &FramePool{
+		pool: pool,
+		keep: keep,
+		idle: make(map[string][]RBEntry),
+	}
+}
+
+// Frame is a frame from a FramePool with one reference, which Release
+// gives up. Its planes hold whatever the last frame of the format left in
+// them, or whatever the allocator did for a new one.
+type Frame struct {
+	Format FrameFormat
+
+	frames *FramePool
+	key    string
+	entry  RBEntry
+	refs   atomic.Int32
+}
+
+// Get returns a frame of format, recycled if there's one to hand.
+func (frames *FramePool) Get(format FrameFormat) (*Frame, error) {
+	sizes, key, err := format.sizes()
+	if err != nil {
+		return nil, err
+	}
+
+	frame := &Frame{Format: format, frames: frames, key: key}
+
+	frames.mu.Lock()
+	if idle := frames.idle[key]; len(idle) > 0 {
+		frame.entry = idle[len(idle)-1]
+		frames.idle[key] = idle[:len(idle)-1]
+	}
+	frames.mu.Unlock()
+
+	if frame.entry.data == nil {
+		frame.entry, err = frames.pool.AllocBuffers(sizes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	frame.refs.Store(1)
+	return frame, nil
+}
+
+// Idle is the number of released frames the pool is keeping, of every
+// format.
+func (frames *FramePool) Idle() int {
+	frames.mu.Lock()
+	defer frames.mu.Unlock()
+
+	num_idle := 0
+	for _, idle := range frames.idle {
+		num_idle += len(idle)
+	}
+	return num_idle
+}
+
+// Close releases the frames the pool is keeping. Frames released later go
+// straight back to the allocator.
+func (frames *FramePool) Close() error {
+	frames.mu.Lock()
+	idle := frames.idle
+	frames.idle = make(map[string][]RBEntry)
+	frames.closed = true
+	frames.mu.Unlock()
+
+	for _, entries := range idle {
+		releaseGroup(entries)
+	}
+	return nil
+}
+
+func (frames *FramePool) recycle(key string, entry RBEntry) {
+	frames.mu.Lock()
+	if !frames.closed && len(frames.idle[key]) < frames.keep {
+		frames.idle[key] = append(frames.idle[key], entry)
+		entry = RBEntry{}
+	}
+	frames.mu.Unlock()
+
+	if entry.data != nil {
+		entry.Release()
+	}
+}
+
+// Planes returns the frame's planes, each Stride times Rows bytes of the
+// format's plane, for as long as the frame has a reference. It panics
+// once the last one has been released.
+func (frame *Frame) Planes() [][]byte {
+	if frame.refs.Load() <= 0 {
+		panic("rustybuffer: use of a released frame")
+	}
+	return frame.entry.Buffers
+}
+
+// Plane returns the frame's idx'th plane, like Planes.
+func (frame *Frame) Plane(idx int) []byte {
+	return frame.Planes()[idx]
+}
+
+// Ref takes another reference to the frame, for a second stage to hold
+// on to it, and returns it. It panics if the frame's been released.
+func (frame *Frame) Ref() *Frame {
+	if frame.refs.Add(1) <= 1 {
+		panic("rustybuffer: Ref of a released frame")
+	}
+	return frame
+}
+
+// Release gives up a reference to the frame. Once the last is given up the
+// frame goes back to its pool and mustn't be used again. Releasing more
+// references than were taken panics.
+func (frame *Frame) Release() {
+	refs := frame.refs.Add(-1)
+	if refs < 0 {
+		panic("rustybuffer: frame released too many times")
+	}
+	if refs == 0 {
+		entry := frame.entry
+		frame.entry = RBEntry{}
+		frame.frames.recycle(frame.key, entry)
+	}
+}
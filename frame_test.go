@@ -0,0 +1,129 @@
+package rustybuffer
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestFrameFormats(t *testing.T) {
+	format := YUV420Format(101, 51, 32)
+	expected := []FramePlane{{128, 51}, {64, 26}, {64, 26}}
+	if format.Name != "yuv420p" || len(format.Planes) != 3 {
+		t.Fatalf("unexpected format: %+v", format)
+	}
+	for idx, plane := range format.Planes {
+		if plane != expected[idx] {
+			t.Fatalf("plane %d is %+v, expected %+v", idx, plane, expected[idx])
+		}
+	}
+
+	if packed := PackedFormat("rgba", 10, 4, 4, 64); len(packed.Planes) != 1 || packed.Planes[0] != (FramePlane{64, 4}) {
+		t.Fatalf("unexpected format: %+v", packed)
+	}
+}
+
+func TestFramePool(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+	frames := NewFramePool(pool, 1)
+	format := YUV420Format(64, 32, 32)
+
+	frame, err := frames.Get(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planes := frame.Planes()
+	if len(planes) != 3 || len(planes[0]) != 64*32 || len(planes[1]) != 32*16 || len(planes[2]) != 32*16 {
+		t.Fatalf("unexpected planes: %d %d %d", len(planes[0]), len(planes[1]), len(planes[2]))
+	}
+	luma := unsafe.SliceData(frame.Plane(0))
+
+	// A second stage holds on to the frame after the first is done.
+	frame.Ref()
+	frame.Release()
+	frame.Plane(0)[0] = 1
+	frame.Release()
+	expectPanic(t, "released frame", func() { frame.Planes() })
+	expectPanic(t, "too many times", func() { frame.Release() })
+	if frames.Idle() != 1 || pool.Stats().BytesInUse != 64*32+2*32*16 {
+		t.Fatalf("expected the frame to be kept: %d idle, %+v", frames.Idle(), pool.Stats())
+	}
+
+	// The next frame of the same format is the same memory.
+	recycled, err := frames.Get(YUV420Format(64, 32, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unsafe.SliceData(recycled.Plane(0)) != luma || frames.Idle() != 0 {
+		t.Fatal("expected the frame to be recycled")
+	}
+
+	// Another format gets new memory, and only one frame of each is kept.
+	other, err := frames.Get(PackedFormat("rgba", 16, 16, 4, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := frames.Get(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recycled.Release()
+	again.Release()
+	other.Release()
+	if frames.Idle() != 2 {
+		t.Fatalf("expected 2 idle frames, got %d", frames.Idle())
+	}
+
+	frames.Close()
+	if frames.Idle() != 0 || pool.Stats().BytesInUse != 0 {
+		t.Fatalf("expected close to release idle frames: %+v", pool.Stats())
+	}
+	late, err := frames.Get(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	late.Release()
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("expected frames released after close to go back: %+v", pool.Stats())
+	}
+
+	if _, err := frames.Get(FrameFormat{Name: "empty"}); err == nil {
+		t.Fatal("expected a format without planes to be refused")
+	}
+	if _, err := frames.Get(FrameFormat{Name: "huge", Planes: []FramePlane{{math.MaxInt / 2, math.MaxInt / 2}}}); err == nil {
+		t.Fatal("expected an oversized format to be refused")
+	}
+}
+
+func TestFramePoolConcurrent(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<22, 1<<20)))
+	frames := NewFramePool(pool, 4)
+	defer frames.Close()
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := 0; idx < 200; idx++ {
+				frame, err := frames.Get(YUV420Format(32, 32, 16))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				shared := frame.Ref()
+				done := make(chan struct{})
+				go func() {
+					shared.Plane(1)[0]++
+					shared.Release()
+					close(done)
+				}()
+				frame.Plane(0)[0]++
+				<-done
+				frame.Release()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,147 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+// GapBuffer holds a run of bytes in one pooled buffer with a gap at the
+// cursor, so inserting or deleting there only touches the gap and moving
+// the cursor only moves the bytes between the old and new positions. That
+// suits patching a large document or binary in memory one edit at a time,
+// where rewriting the whole buffer per edit would cost too much. When the
+// gap fills up the bytes move to a buffer twice the size. Like Arena, it
+// isn't safe for concurrent use.
+type GapBuffer struct {
+	pool  *Pool
+	entry RBEntry
+
+	// The gap is buf[start:end], the cursor is at start.
+	start int
+	end   int
+}
+
+// NewGapBuffer is NewGapBuffer on the default pool.
+func NewGapBuffer(capacity uint64) (*GapBuffer, error) {
+	return defaultPool.NewGapBuffer(capacity)
+}
+
+// NewGapBuffer returns an empty gap buffer with room for capacity bytes
+// before it has to grow.
+func (pool *Pool) NewGapBuffer(capacity uint64) (*GapBuffer, error) {
+	entry, err := pool.AllocBuffers([]uint64{max(capacity, 1)})
+	if err != nil {
+		return nil, err
+	}
+	return &GapBuffer{pool: pool, entry: entry, end: len(entry.Buffers[0])}, nil
+}
+
+func (gap *GapBuffer) buf() []byte {
+	if gap.entry.data == nil {
+		panic("rustybuffer: use of a released gap buffer")
+	}
+	return gap.entry.Buffers[0]
+}
+
+// Len returns the number of bytes held, not counting the gap.
+func (gap *GapBuffer) Len() int {
+	return len(gap.buf()) - (gap.end - gap.start)
+}
+
+// Cursor returns the offset of the cursor.
+func (gap *GapBuffer) Cursor() int {
+	return gap.start
+}
+
+// MoveTo moves the cursor to offset, which panics if it's past the end.
+func (gap *GapBuffer) MoveTo(offset int) {
+	buf := gap.buf()
+	if offset < 0 || offset > gap.Len() {
+		panic(fmt.Sprintf("rustybuffer: cursor %d out of range with %d bytes", offset, gap.Len()))
+	}
+
+	// Move the bytes between the cursor and offset across the gap.
+	length := gap.end - gap.start
+	if offset < gap.start {
+		moved := gap.start - offset
+		copySegments([]copySegment{{buf[offset+length : gap.end], buf[offset:gap.start]}}, uint64(moved))
+	} else if offset > gap.start {
+		moved := offset - gap.start
+		copySegments([]copySegment{{buf[gap.start:offset], buf[gap.end : gap.end+moved]}}, uint64(moved))
+	}
+	gap.start = offset
+	gap.end = offset + length
+}
+
+// Insert inserts p at the cursor, leaving the cursor after it. If the gap
+// is too small the bytes move to a bigger buffer, and if that can't be had
+// nothing is inserted.
+func (gap *GapBuffer) Insert(p []byte) error {
+	if len(p) > gap.end-gap.start {
+		if err := gap.grow(len(p)); err != nil {
+			return err
+		}
+	}
+	copy(gap.buf()[gap.start:], p)
+	gap.start += len(p)
+	return nil
+}
+
+// grow moves the bytes to a buffer with a gap of at least extra bytes.
+func (gap *GapBuffer) grow(extra int) error {
+	buf := gap.buf()
+	length := gap.Len()
+	entry, err := gap.pool.AllocBuffers([]uint64{uint64(max(2*len(buf), length+extra))})
+	if err != nil {
+		return err
+	}
+
+	grown := entry.Buffers[0]
+	end := len(grown) - (len(buf) - gap.end)
+	copySegments([]copySegment{
+		{grown[:gap.start], buf[:gap.start]},
+		{grown[end:], buf[gap.end:]},
+	}, uint64(length))
+
+	gap.entry.Release()
+	gap.entry = entry
+	gap.end = end
+	return nil
+}
+
+// Delete deletes up to n bytes after the cursor, returning how many it
+// deleted.
+func (gap *GapBuffer) Delete(n int) int {
+	n = max(min(n, len(gap.buf())-gap.end), 0)
+	gap.end += n
+	return n
+}
+
+// Backspace deletes up to n bytes before the cursor, returning how many it
+// deleted.
+func (gap *GapBuffer) Backspace(n int) int {
+	n = max(min(n, gap.start), 0)
+	gap.start -= n
+	return n
+}
+
+// Bytes returns the bytes held as the parts before and after the cursor.
+// They're only valid until the next edit, move or Release.
+func (gap *GapBuffer) Bytes() ([]byte, []byte) {
+	buf := gap.buf()
+	return buf[:gap.start], buf[gap.end:]
+}
+
+// WriteTo writes the bytes held to w, skipping the gap.
+func (gap *GapBuffer) WriteTo(w io.Writer) (int64, error) {
+	before, after := gap.Bytes()
+	buffers := net.Buffers{before, after}
+	return buffers.WriteTo(w)
+}
+
+// Release gives the buffer back to the pool. The gap buffer can't be used
+// afterwards.
+func (gap *GapBuffer) Release() {
+	gap.entry.Release()
+}
@@ -0,0 +1,87 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func gapContents(gap *GapBuffer) string {
+	var out bytes.Buffer
+	gap.WriteTo(&out)
+	return out.String()
+}
+
+func TestGapBuffer(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	gap, err := NewPool(WithAllocator(alloc)).NewGapBuffer(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gap.Insert([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	gap.MoveTo(5)
+	gap.Insert([]byte(","))
+	gap.MoveTo(gap.Len())
+	gap.Insert([]byte("!"))
+	gap.MoveTo(7)
+	if deleted := gap.Delete(5); deleted != 5 {
+		t.Fatalf("expected 5 bytes deleted, got %d", deleted)
+	}
+	gap.Insert([]byte("there"))
+	if got := gapContents(gap); got != "hello, there!" || gap.Len() != 13 || gap.Cursor() != 12 {
+		t.Fatalf("unexpected contents %q with cursor %d", got, gap.Cursor())
+	}
+	if deleted := gap.Backspace(100); deleted != 12 || gapContents(gap) != "!" {
+		t.Fatalf("unexpected backspace of %d leaving %q", deleted, gapContents(gap))
+	}
+	if before, after := gap.Bytes(); len(before) != 0 || string(after) != "!" {
+		t.Fatalf("unexpected halves %q %q", before, after)
+	}
+	expectPanic(t, "out of range", func() { gap.MoveTo(2) })
+
+	if err := gap.Insert(make([]byte, 2000)); !errors.Is(err, ErrBufferTooLarge) || gapContents(gap) != "!" {
+		t.Fatalf("expected a failed insert to change nothing, got %v", err)
+	}
+
+	gap.Release()
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats %+v", alloc.Stats())
+	}
+	expectPanic(t, "released gap buffer", func() { gap.Len() })
+}
+
+func TestGapBufferRandomEdits(t *testing.T) {
+	gap, err := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20))).NewGapBuffer(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gap.Release()
+
+	rng := rand.New(rand.NewSource(1))
+	var model []byte
+	for idx := 0; idx < 2000; idx++ {
+		gap.MoveTo(rng.Intn(len(model) + 1))
+		cursor := gap.Cursor()
+		switch rng.Intn(3) {
+		case 0:
+			text := []byte{byte('a' + rng.Intn(26)), byte('a' + rng.Intn(26))}
+			if err := gap.Insert(text); err != nil {
+				t.Fatal(err)
+			}
+			model = append(model[:cursor], append(text, model[cursor:]...)...)
+		case 1:
+			deleted := gap.Delete(rng.Intn(4))
+			model = append(model[:cursor], model[cursor+deleted:]...)
+		case 2:
+			deleted := gap.Backspace(rng.Intn(4))
+			model = append(model[:cursor-deleted], model[cursor:]...)
+		}
+		if got := gapContents(gap); got != string(model) {
+			t.Fatalf("edit %d: expected %q, got %q", idx, model, got)
+		}
+	}
+}
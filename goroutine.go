@@ -0,0 +1,155 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"unsafe"
+)
+
+// ErrGoroutineLimit is returned, wrapped, by an acquire that would take
+// its goroutine over the pool's GoroutineLimit.
+var ErrGoroutineLimit = errors.New("rustybuffer: goroutine limit exceeded")
+
+// GoroutineLimit caps what a single goroutine can hold from a pool at once,
+// as a guard against a runaway loop taking the whole pool. Entries count
+// against the goroutine that acquired them until they're released, by
+// whoever. Zero means no limit for either.
+type GoroutineLimit struct {
+	MaxBytes   uint64
+	MaxEntries uint64
+}
+
+// WithGoroutineLimit applies limit to every goroutine acquiring from the
+// pool. Finding out which goroutine is acquiring costs about a microsecond
+// per acquire.
+func WithGoroutineLimit(limit GoroutineLimit) PoolOption {
+	return func(pool *Pool) {
+		pool.goroutines = &goroutineLimits{
+			limit:  limit,
+			held:   make(map[uint64]goroutineHeld),
+			owners: make(map[unsafe.Pointer]ownedEntry),
+		}
+	}
+}
+
+type goroutineHeld struct {
+	bytes   uint64
+	entries uint64
+}
+
+type ownedEntry struct {
+	goroutine uint64
+	size      uint64
+}
+
+// goroutineLimits tracks what each goroutine holds, and which goroutine
+// holds each entry.
+type goroutineLimits struct {
+	limit GoroutineLimit
+
+	mu     sync.Mutex
+	held   map[uint64]goroutineHeld
+	owners map[unsafe.Pointer]ownedEntry
+}
+
+// reserve counts size bytes against goroutine before they're acquired.
+func (limits *goroutineLimits) reserve(goroutine uint64, size uint64) error {
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+
+	return limits.add(goroutine, size)
+}
+
+// add counts an entry of size bytes against goroutine if it's within the
+// limit, with the lock held.
+func (limits *goroutineLimits) add(goroutine uint64, size uint64) error {
+	held := limits.held[goroutine]
+	if limits.limit.MaxEntries > 0 && held.entries+1 > limits.limit.MaxEntries {
+		return fmt.Errorf("%w: goroutine %d already holds %d entries",
+			ErrGoroutineLimit, goroutine, held.entries)
+	}
+	if limits.limit.MaxBytes > 0 &&
+		(held.bytes+size > limits.limit.MaxBytes || held.bytes+size < size) {
+		return fmt.Errorf("%w: goroutine %d holds %d bytes and requested %d of its %d",
+			ErrGoroutineLimit, goroutine, held.bytes, size, limits.limit.MaxBytes)
+	}
+
+	limits.held[goroutine] = goroutineHeld{held.bytes + size, held.entries + 1}
+	return nil
+}
+
+// transfer moves data from the goroutine that holds it to goroutine, if
+// that's within goroutine's limit.
+func (limits *goroutineLimits) transfer(data unsafe.Pointer, goroutine uint64) error {
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+
+	owned, ok := limits.owners[data]
+	if !ok || owned.goroutine == goroutine {
+		return nil
+	}
+	if err := limits.add(goroutine, owned.size); err != nil {
+		return err
+	}
+
+	limits.unreserve(owned.goroutine, owned.size)
+	limits.owners[data] = ownedEntry{goroutine, owned.size}
+	return nil
+}
+
+// cancel undoes a reservation that wasn't acquired.
+func (limits *goroutineLimits) cancel(goroutine uint64, size uint64) {
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+
+	limits.unreserve(goroutine, size)
+}
+
+// own records that goroutine's reservation was acquired as data.
+func (limits *goroutineLimits) own(goroutine uint64, data unsafe.Pointer, size uint64) {
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+
+	limits.owners[data] = ownedEntry{goroutine, size}
+}
+
+// forget stops counting a released entry against its goroutine.
+func (limits *goroutineLimits) forget(data unsafe.Pointer) {
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+
+	owned, ok := limits.owners[data]
+	if !ok {
+		return
+	}
+	delete(limits.owners, data)
+	limits.unreserve(owned.goroutine, owned.size)
+}
+
+func (limits *goroutineLimits) unreserve(goroutine uint64, size uint64) {
+	held := limits.held[goroutine]
+	held.bytes -= size
+	held.entries--
+	if held.entries == 0 {
+		delete(limits.held, goroutine)
+	} else {
+		limits.held[goroutine] = held
+	}
+}
+
+// goroutineID returns the calling goroutine's ID, from the header of its
+// stack trace ("goroutine 123 [running]:"). Go deliberately doesn't offer
+// a better way.
+func goroutineID() uint64 {
+	var buf [64]byte
+	stack := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if end := bytes.IndexByte(stack, ' '); end >= 0 {
+		stack = stack[:end]
+	}
+	id, _ := strconv.ParseUint(string(stack), 10, 64)
+	return id
+}
@@ -0,0 +1,92 @@
+package rustybuffer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGoroutineLimit(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)),
+		WithGoroutineLimit(GoroutineLimit{MaxBytes: 1000, MaxEntries: 3}))
+
+	first, err := pool.AllocBuffers([]uint64{600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pool.AllocBuffers([]uint64{500})
+	if !errors.Is(err, ErrGoroutineLimit) {
+		t.Fatalf("expected ErrGoroutineLimit, got %v", err)
+	}
+
+	// Another goroutine has its own limit, and what it acquires still
+	// counts against it when it's released here.
+	done := make(chan struct{})
+	var other RBEntry
+	go func() {
+		defer close(done)
+		other, err = pool.AllocBuffers([]uint64{900})
+	}()
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Running out of entries.
+	second, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pool.AllocBuffers([]uint64{10})
+	if !errors.Is(err, ErrGoroutineLimit) {
+		t.Fatalf("expected ErrGoroutineLimit, got %v", err)
+	}
+
+	other.Release()
+	first.Release()
+	second.Release()
+	third.Release()
+
+	entry, err := pool.AllocBuffers([]uint64{1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+
+	if len(pool.goroutines.held) != 0 || len(pool.goroutines.owners) != 0 {
+		t.Fatalf("still tracking %v and %v", pool.goroutines.held, pool.goroutines.owners)
+	}
+}
+
+func TestGoroutineLimitFailedAcquire(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1000, 1000)),
+		WithGoroutineLimit(GoroutineLimit{MaxEntries: 1}))
+
+	// An acquire that fails doesn't count.
+	_, err := pool.AllocBuffers([]uint64{2000})
+	if !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+
+	entry, err := pool.AllocBuffers([]uint64{1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+}
+
+func TestGoroutineID(t *testing.T) {
+	id := goroutineID()
+	if id == 0 || goroutineID() != id {
+		t.Fatalf("unexpected goroutine ID %d", id)
+	}
+
+	other := make(chan uint64)
+	go func() { other <- goroutineID() }()
+	if other_id := <-other; other_id == 0 || other_id == id {
+		t.Fatalf("expected a different goroutine ID to %d, got %d", id, other_id)
+	}
+}
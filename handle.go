@@ -0,0 +1,36 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Handle identifies an entry without exposing its memory's address, for
+// logging, map keys and telling entries apart. Every acquisition gets a
+// new handle, copies of an entry share it, and it's never reused, so a
+// handle from a released entry won't match whatever later gets the same
+// memory. The zero Handle is no entry.
+type Handle uint64
+
+// The last handle handed out.
+var lastHandle atomic.Uint64
+
+func nextHandle() Handle {
+	return Handle(lastHandle.Add(1))
+}
+
+func (handle Handle) String() string {
+	if handle == 0 {
+		return "nil"
+	}
+	return fmt.Sprintf("#%d", uint64(handle))
+}
+
+// Handle returns the entry's handle, which stays the same once it's
+// released, or zero for the zero RBEntry.
+func (entry *RBEntry) Handle() Handle {
+	if entry.state == nil {
+		return 0
+	}
+	return entry.state.handle
+}
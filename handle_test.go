@@ -0,0 +1,52 @@
+package rustybuffer
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestHandle(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	first, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handle := first.Handle()
+	copied := first
+	if handle == 0 || copied.Handle() != handle || second.Handle() == handle {
+		t.Fatalf("unexpected handles %v, %v and %v", handle, copied.Handle(), second.Handle())
+	}
+
+	// Handles aren't reused along with the memory.
+	first.Release()
+	second.Release()
+	again, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Release()
+	if again.Handle() == handle || again.Handle() == second.Handle() {
+		t.Fatal("a handle was reused")
+	}
+	if first.Handle() != handle {
+		t.Fatal("the handle changed on release")
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	registered := RegisterGoBuffer(&pinner, make([]byte, 8))
+	if registered.Handle() == 0 {
+		t.Fatal("registered memory has no handle")
+	}
+
+	var zero RBEntry
+	if zero.Handle() != 0 || zero.Handle().String() != "nil" {
+		t.Fatalf("unexpected handle %v for the zero entry", zero.Handle())
+	}
+}
@@ -0,0 +1,40 @@
+package rustybuffer
+
+import "unsafe"
+
+// heapAllocator hands out ordinary Go heap memory. The tracker's map keeps
+// a reference to every buffer so the GC can't collect it while in use.
+type heapAllocator struct {
+	tracker *sizeTracker
+}
+
+// NewHeapAllocator returns an Allocator backed by the Go heap, with the same
+// limits the Rust library enforces. Useful as a baseline to compare against
+// and wherever the Rust library isn't wanted.
+func NewHeapAllocator(max_total_size uint64, max_buffer_size uint64) Allocator {
+	return &heapAllocator{
+		tracker: newSizeTracker(max_total_size, max_buffer_size),
+	}
+}
+
+func (alloc *heapAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	return alloc.tracker.acquire(size, func(size uint64) unsafe.Pointer {
+		return unsafe.Pointer(unsafe.SliceData(make([]byte, size)))
+	})
+}
+
+func (alloc *heapAllocator) Release(data unsafe.Pointer) error {
+	return alloc.tracker.release(data, func(data unsafe.Pointer) {})
+}
+
+func (alloc *heapAllocator) Stats() Stats {
+	return alloc.tracker.stats()
+}
+
+func (alloc *heapAllocator) FreeExtents() ([]uint64, []uint64, error) {
+	return cacheExtents(alloc.tracker.stats()), nil, nil
+}
+
+func (alloc *heapAllocator) LiveHandles() ([]uintptr, error) {
+	return alloc.tracker.liveHandles(), nil
+}
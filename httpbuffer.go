@@ -0,0 +1,73 @@
+package rustybuffer
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// httpBufferSize is the size of buffer httputil.ReverseProxy allocates to
+// copy each body when it isn't given a BufferPool.
+const httpBufferSize = 32 * 1024
+
+// HTTPBufferPool implements net/http/httputil.BufferPool on top of a Pool,
+// so a gateway's ReverseProxy copies request and response bodies through
+// pooled memory rather than a fresh 32KiB heap buffer per body:
+//
+//	proxy := httputil.NewSingleHostReverseProxy(target)
+//	proxy.BufferPool = rustybuffer.NewHTTPBufferPool(pool, 0)
+//
+// Like BufferPool, buffers the pool can't supply come from the Go heap,
+// so a full pool slows proxying down rather than failing requests. An
+// HTTPBufferPool is safe for concurrent use.
+//
+// golang.org/x/net/http2 gives no say over its frame buffers, its Framer
+// reuses one read buffer per connection already.
+type HTTPBufferPool struct {
+	pool *Pool
+	size int
+
+	mu      sync.Mutex
+	entries map[*uint8]RBEntry
+}
+
+// NewHTTPBufferPool returns a buffer pool handing out buffers of size
+// bytes from pool, or ReverseProxy's 32KiB if size is zero.
+func NewHTTPBufferPool(pool *Pool, size int) *HTTPBufferPool {
+	if size <= 0 {
+		size = httpBufferSize
+	}
+	return &HTTPBufferPool{
+		pool:    pool,
+		size:    size,
+		entries: make(map[*uint8]RBEntry),
+	}
+}
+
+func (buffers *HTTPBufferPool) Get() []byte {
+	entry, err := buffers.pool.AllocBuffers([]uint64{uint64(buffers.size)})
+	if err != nil {
+		return make([]byte, buffers.size)
+	}
+
+	buf := entry.Buffers[0]
+	buffers.mu.Lock()
+	buffers.entries[unsafe.SliceData(buf)] = entry
+	buffers.mu.Unlock()
+	return buf
+}
+
+// Put gives back a buffer from Get, ignoring those it didn't hand out.
+func (buffers *HTTPBufferPool) Put(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
+
+	buffers.mu.Lock()
+	entry, ok := buffers.entries[unsafe.SliceData(buf)]
+	delete(buffers.entries, unsafe.SliceData(buf))
+	buffers.mu.Unlock()
+
+	if ok {
+		entry.Release()
+	}
+}
@@ -0,0 +1,84 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+type countingAllocator struct {
+	Allocator
+	acquires *atomic.Int32
+}
+
+func (alloc countingAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	alloc.acquires.Add(1)
+	return alloc.Allocator.Acquire(size)
+}
+
+func TestHTTPBufferPool(t *testing.T) {
+	var acquires atomic.Int32
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+	pool.Use(func(next Allocator) Allocator { return countingAllocator{next, &acquires} })
+	buffers := NewHTTPBufferPool(pool, 0)
+
+	body := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer backend.Close()
+	target, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.BufferPool = buffers
+	gateway := httptest.NewServer(proxy)
+	defer gateway.Close()
+
+	resp, err := http.Get(gateway.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxied, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(proxied, body) {
+		t.Fatalf("proxied %d bytes, expected %d", len(proxied), len(body))
+	}
+	if acquires.Load() == 0 {
+		t.Fatal("expected the proxy to copy through pooled buffers")
+	}
+	// The proxy puts its buffer back after the last write.
+	for idx := 0; idx < 100 && pool.Stats().BytesInUse != 0; idx++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("expected every buffer back: %+v", pool.Stats())
+	}
+
+	// Buffers the pool can't supply come from the heap and are ignored
+	// when they're put back.
+	small := NewHTTPBufferPool(NewPool(WithAllocator(NewHeapAllocator(1024, 1024))), 4096)
+	buf := small.Get()
+	if len(buf) != 4096 {
+		t.Fatalf("expected 4096 bytes, got %d", len(buf))
+	}
+	small.Put(buf)
+	small.Put(nil)
+
+	pooled := buffers.Get()
+	if len(pooled) != httpBufferSize || unsafe.SliceData(pooled) == nil || pool.Stats().BytesInUse != httpBufferSize {
+		t.Fatalf("unexpected buffer: %+v", pool.Stats())
+	}
+	buffers.Put(pooled)
+}
@@ -0,0 +1,105 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+)
+
+// DefaultHugePageSize is the huge page size WithHugePages uses by default,
+// the smallest on x86-64 and most arm64 kernels.
+const DefaultHugePageSize = 2 << 20
+
+// WithHugePages has the acquire map the entry by itself in pages of
+// page_size bytes, or DefaultHugePageSize if it's zero, for registering it
+// with something that needs whole huge page aligned extents, a DPDK
+// mempool (rte_mempool_populate_virt) or a vhost-user consumer say. The
+// entry starts at the start of a huge page and its size is rounded up to
+// whole pages, so nothing else shares them, and HugePageLayout reports the
+// pages.
+//
+// On Linux the pages come from the kernel's reserved huge pages where
+// there are enough (see /proc/sys/vm/nr_hugepages), each of which is
+// physically contiguous and never swapped. Otherwise the mapping is only
+// aligned, with the kernel advised to back it with transparent huge pages,
+// which it does as it can, so consumers that need physical contiguity
+// should check HugePageLayout.Reserved. Such entries come from neither the
+// pool's allocator nor its exhaustion policy, like spilled ones, and are
+// reported in Stats.HugePageBytes. Page sizes that aren't a power of two
+// multiple of the system's page size fail, as does every such acquire on
+// platforms other than unix.
+func WithHugePages(page_size uint64) AcquireOption {
+	if page_size == 0 {
+		page_size = DefaultHugePageSize
+	}
+	return func(opts *acquireOptions) {
+		opts.huge_page_size = page_size
+	}
+}
+
+// HugePageLayout is how an entry acquired WithHugePages is mapped.
+type HugePageLayout struct {
+	PageSize uint64
+
+	// Whether the pages are reserved huge pages (hugetlbfs), rather than
+	// a mapping aligned for transparent huge pages.
+	Reserved bool
+
+	// The entry's pages, in order: the first starts at the entry, and
+	// together they're contiguous in the address space, at least as long
+	// as the entry but possibly longer.
+	Extents []HugePageExtent
+}
+
+// HugePageExtent is one huge page of an entry, see HugePageLayout.
+type HugePageExtent struct {
+	Addr uintptr
+	Len  uint64
+}
+
+// Bytes returns the length of every extent together.
+func (layout HugePageLayout) Bytes() uint64 {
+	return uint64(len(layout.Extents)) * layout.PageSize
+}
+
+// checkHugePageSize reports whether page_size can be mapped.
+func checkHugePageSize(page_size uint64) error {
+	if bits.OnesCount64(page_size) != 1 || page_size < uint64(os.Getpagesize()) {
+		return fmt.Errorf("rustybuffer: a huge page size of %d bytes isn't a power of two multiple of the %d byte page size",
+			page_size, os.Getpagesize())
+	}
+	return nil
+}
+
+// HugePageLayout returns the huge pages the entry occupies, if it was
+// acquired WithHugePages, and an error otherwise or once it's released.
+func (entry *RBEntry) HugePageLayout() (HugePageLayout, error) {
+	if entry.data == nil || entry.state == nil || entry.state.released.Load() {
+		return HugePageLayout{}, errors.New("rustybuffer: huge page layout of a released entry")
+	}
+	alloc, ok := entry.state.alloc.(*hugePageAllocator)
+	if !ok {
+		return HugePageLayout{}, errors.New("rustybuffer: huge page layout of an entry acquired without WithHugePages")
+	}
+
+	mapping, ok := alloc.mapping(entry.data)
+	if !ok {
+		return HugePageLayout{}, errors.New("rustybuffer: huge page layout of a released entry")
+	}
+	layout := HugePageLayout{PageSize: mapping.page_size, Reserved: mapping.reserved}
+	start := uintptr(entry.data)
+	for offset := uint64(0); offset < mapping.size; offset += mapping.page_size {
+		layout.Extents = append(layout.Extents, HugePageExtent{start + uintptr(offset), mapping.page_size})
+	}
+	return layout, nil
+}
+
+// hugePageMapping is an entry mapped by a hugePageAllocator: size bytes of
+// whole huge pages at the entry, somewhere in the mapping.
+type hugePageMapping struct {
+	mapping   []byte
+	size      uint64
+	page_size uint64
+	reserved  bool
+}
@@ -0,0 +1,27 @@
+package rustybuffer
+
+import (
+	"math/bits"
+	"syscall"
+)
+
+// MAP_HUGETLB and MADV_HUGEPAGE, which the syscall package doesn't define
+// on every architecture, and where mmap's flags hold the huge page size.
+const (
+	mapHugeTLB   = 0x40000
+	mapHugeShift = 26
+	madvHugePage = 14
+)
+
+// mapReservedHugePages maps size bytes of the kernel's reserved huge pages of
+// page_size bytes, which fails if it hasn't enough.
+func mapReservedHugePages(size uint64, page_size uint64) ([]byte, error) {
+	flags := syscall.MAP_PRIVATE | syscall.MAP_ANON | mapHugeTLB | bits.TrailingZeros64(page_size)<<mapHugeShift
+	return syscall.Mmap(-1, 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, flags)
+}
+
+// adviseHugePages asks the kernel to back mapping with transparent huge
+// pages. It's only advice, so whether it's taken doesn't matter.
+func adviseHugePages(mapping []byte) {
+	syscall.Madvise(mapping, madvHugePage)
+}
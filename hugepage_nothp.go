@@ -0,0 +1,18 @@
+//go:build unix && !linux
+
+package rustybuffer
+
+import (
+	"errors"
+	"runtime"
+)
+
+// mapReservedHugePages would map reserved huge pages, but only Linux has
+// them for anonymous memory, so it always fails.
+func mapReservedHugePages(size uint64, page_size uint64) ([]byte, error) {
+	return nil, errors.New("rustybuffer: reserved huge pages are not supported on " + runtime.GOOS)
+}
+
+// adviseHugePages would ask for transparent huge pages, which only Linux
+// has, so it does nothing.
+func adviseHugePages(mapping []byte) {}
@@ -0,0 +1,41 @@
+//go:build !unix
+
+package rustybuffer
+
+import (
+	"math"
+	"runtime"
+	"unsafe"
+)
+
+// hugePageAllocator would map buffers in huge pages, but that's only
+// implemented on unix platforms, so every Acquire fails.
+type hugePageAllocator struct{}
+
+func newHugePageAllocator() *hugePageAllocator {
+	return &hugePageAllocator{}
+}
+
+func (alloc *hugePageAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	return alloc.acquirePages(size, DefaultHugePageSize)
+}
+
+func (alloc *hugePageAllocator) acquirePages(size uint64, page_size uint64) (unsafe.Pointer, error) {
+	return nil, newError(codeAllocationFailed,
+		"huge pages are not supported on %s", runtime.GOOS)
+}
+
+func (alloc *hugePageAllocator) mapping(data unsafe.Pointer) (hugePageMapping, bool) {
+	return hugePageMapping{}, false
+}
+
+func (alloc *hugePageAllocator) Release(data unsafe.Pointer) error {
+	return newError(codeInvalidPointer, "%p was not mapped in huge pages", data)
+}
+
+func (alloc *hugePageAllocator) Stats() Stats {
+	return Stats{
+		MaxTotalSize:  math.MaxUint64,
+		MaxBufferSize: math.MaxInt,
+	}
+}
@@ -0,0 +1,79 @@
+//go:build unix
+
+package rustybuffer
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHugePages(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	page_size := uint64(os.Getpagesize()) * 16
+
+	entry, err := pool.AllocBuffers([]uint64{100, page_size}, WithHugePages(page_size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillPattern(entry, 1)
+	if uintptr(entry.data)%uintptr(page_size) != 0 {
+		t.Fatalf("expected %p to be aligned to %d bytes", entry.data, page_size)
+	}
+
+	layout, err := entry.HugePageLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.PageSize != page_size || len(layout.Extents) != 2 || layout.Bytes() != 2*page_size ||
+		layout.Extents[0].Addr != uintptr(entry.data) ||
+		layout.Extents[1].Addr != layout.Extents[0].Addr+uintptr(page_size) {
+		t.Fatalf("unexpected layout %+v", layout)
+	}
+	if stats := pool.Stats(); stats.HugePageBytes != 2*page_size || stats.BytesInUse != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	entry.Release()
+	if stats := pool.Stats(); stats.HugePageBytes != 0 {
+		t.Fatalf("expected the pages to be unmapped, got %+v", stats)
+	}
+	if _, err := entry.HugePageLayout(); err == nil {
+		t.Fatal("expected the layout of a released entry to fail")
+	}
+}
+
+func TestHugePagesDefault(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	entry, err := pool.AllocBuffers([]uint64{10}, WithHugePages(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	if layout, err := entry.HugePageLayout(); err != nil || layout.PageSize != DefaultHugePageSize ||
+		len(layout.Extents) != 1 || uintptr(entry.data)%DefaultHugePageSize != 0 {
+		t.Fatalf("unexpected layout %+v (%v)", layout, err)
+	}
+	if entry.Buffers[0][9] != 0 {
+		t.Fatal("expected the pages to be zeroed")
+	}
+}
+
+func TestHugePagesErrors(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	if _, err := pool.AllocBuffers([]uint64{10}, WithHugePages(3<<20)); err == nil {
+		t.Fatal("expected a page size that isn't a power of two to fail")
+	}
+	if _, err := pool.AllocBuffers([]uint64{10}, WithHugePages(512)); err == nil {
+		t.Fatal("expected a page size smaller than a page to fail")
+	}
+
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	if _, err := entry.HugePageLayout(); err == nil {
+		t.Fatal("expected the layout of an ordinary entry to fail")
+	}
+}
@@ -0,0 +1,120 @@
+//go:build unix
+
+package rustybuffer
+
+import (
+	"math"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// hugePageAllocator maps every buffer by itself in whole huge pages, see
+// WithHugePages.
+type hugePageAllocator struct {
+	mu           sync.Mutex
+	bytes_in_use uint64
+	mappings     map[unsafe.Pointer]hugePageMapping
+}
+
+func newHugePageAllocator() *hugePageAllocator {
+	return &hugePageAllocator{mappings: make(map[unsafe.Pointer]hugePageMapping)}
+}
+
+// Acquire maps size bytes in pages of DefaultHugePageSize.
+func (alloc *hugePageAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	return alloc.acquirePages(size, DefaultHugePageSize)
+}
+
+// acquirePages maps size bytes, rounded up to whole pages of page_size
+// bytes, starting on a page boundary.
+func (alloc *hugePageAllocator) acquirePages(size uint64, page_size uint64) (unsafe.Pointer, error) {
+	if err := checkHugePageSize(page_size); err != nil {
+		return nil, err
+	}
+	if page_size > math.MaxInt/4 || size > math.MaxInt-2*page_size {
+		return nil, newError(codeBufferTooLarge,
+			"requested %d bytes but can only map %d", size, uint64(math.MaxInt))
+	}
+	pages := (max(size, 1) + page_size - 1) / page_size
+
+	entry := hugePageMapping{size: pages * page_size, page_size: page_size, reserved: true}
+	var data unsafe.Pointer
+	if mapping, err := mapReservedHugePages(entry.size, page_size); err == nil {
+		entry.mapping = mapping
+		data = unsafe.Pointer(unsafe.SliceData(mapping))
+	} else {
+		// Map a page more than needed, to be sure of one that starts on a
+		// boundary, and leave the unaligned ends unused.
+		mapping, err := syscall.Mmap(-1, 0, int(entry.size+page_size),
+			syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+		if err != nil {
+			return nil, newError(codeAllocationFailed, "mapping %d bytes of huge pages: %v", size, err)
+		}
+		addr := uintptr(unsafe.Pointer(unsafe.SliceData(mapping)))
+		offset := (uintptr(page_size) - addr%uintptr(page_size)) % uintptr(page_size)
+		adviseHugePages(mapping[offset : uint64(offset)+entry.size])
+
+		entry.mapping = mapping
+		entry.reserved = false
+		data = unsafe.Pointer(&mapping[offset])
+	}
+
+	alloc.mu.Lock()
+	alloc.mappings[data] = entry
+	alloc.bytes_in_use += entry.size
+	alloc.mu.Unlock()
+
+	return data, nil
+}
+
+func (alloc *hugePageAllocator) mapping(data unsafe.Pointer) (hugePageMapping, bool) {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	entry, ok := alloc.mappings[data]
+	return entry, ok
+}
+
+func (alloc *hugePageAllocator) Release(data unsafe.Pointer) error {
+	alloc.mu.Lock()
+	entry, ok := alloc.mappings[data]
+	delete(alloc.mappings, data)
+	alloc.bytes_in_use -= entry.size
+	alloc.mu.Unlock()
+
+	if !ok {
+		return newError(codeInvalidPointer, "%p was not mapped in huge pages", data)
+	}
+
+	if err := syscall.Munmap(entry.mapping); err != nil {
+		return newError(codeInvalidPointer, "unmapping huge pages at %p: %v", data, err)
+	}
+
+	return nil
+}
+
+func (alloc *hugePageAllocator) LiveHandles() ([]uintptr, error) {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	live := make([]uintptr, 0, len(alloc.mappings))
+	for data := range alloc.mappings {
+		live = append(live, uintptr(data))
+	}
+	return live, nil
+}
+
+func (alloc *hugePageAllocator) Stats() Stats {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	return Stats{
+		MaxTotalSize:   math.MaxUint64,
+		MaxBufferSize:  math.MaxInt,
+		BytesAllocated: alloc.bytes_in_use,
+		BytesInUse:     alloc.bytes_in_use,
+		NumBuffers:     uint64(len(alloc.mappings)),
+		NumAvailable:   0,
+	}
+}
@@ -0,0 +1,81 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+)
+
+// NewRGBA returns an image.RGBA covering r whose pixels are pooled memory,
+// e.g., for a resized frame or thumbnail, or for a cgo decoder (say
+// libjpeg-turbo or libwebp) to decode into through Pix and Stride. The
+// image is only valid until the entry is released, which is usually done
+// once per frame. Its pixels start out zeroed.
+func (pool *Pool) NewRGBA(r image.Rectangle) (RBEntry, *image.RGBA, error) {
+	size, err := rgbaSize(r)
+	if err != nil {
+		return RBEntry{}, nil, err
+	}
+
+	entry, err := pool.AllocBuffers([]uint64{size})
+	if err != nil {
+		return RBEntry{}, nil, err
+	}
+	pix := entry.Buffers[0]
+	clear(pix)
+
+	return entry, &image.RGBA{Pix: pix, Stride: 4 * r.Dx(), Rect: r}, nil
+}
+
+// DecodeRGBA decodes an image in any format registered with the image
+// package (import image/jpeg, image/png or golang.org/x/image/webp for
+// them) into an RGBA image backed by pooled memory, returning the format's
+// name as image.Decode does. The header is read first, so images the pool
+// can't hold fail before any pixels are decoded.
+//
+// The standard library's decoders always allocate an image of their own.
+// That one is dropped as soon as it's been converted, so only the pooled
+// copy lives through the rest of the pipeline; decoders that can write
+// into a caller's buffer should be given one from NewRGBA instead.
+func (pool *Pool) DecodeRGBA(r io.Reader) (RBEntry, *image.RGBA, string, error) {
+	var header bytes.Buffer
+	config, format, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return RBEntry{}, nil, "", err
+	}
+
+	entry, img, err := pool.NewRGBA(image.Rect(0, 0, config.Width, config.Height))
+	if err != nil {
+		return RBEntry{}, nil, "", err
+	}
+
+	decoded, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		entry.Release()
+		return RBEntry{}, nil, "", err
+	}
+	if decoded.Bounds().Size() != img.Rect.Size() {
+		entry.Release()
+		return RBEntry{}, nil, "", fmt.Errorf(
+			"rustybuffer: decoded a %v image after a header saying %v",
+			decoded.Bounds().Size(), img.Rect.Size())
+	}
+
+	draw.Draw(img, img.Rect, decoded, decoded.Bounds().Min, draw.Src)
+	return entry, img, format, nil
+}
+
+// rgbaSize is the number of bytes an RGBA image covering r takes.
+func rgbaSize(r image.Rectangle) (uint64, error) {
+	if r.Dx() < 0 || r.Dy() < 0 {
+		return 0, fmt.Errorf("rustybuffer: invalid image bounds %v", r)
+	}
+	width, height := uint64(r.Dx()), uint64(r.Dy())
+	if width != 0 && height > math.MaxInt/4/width {
+		return 0, newError(codeBufferTooLarge, "a %dx%d image is too large", width, height)
+	}
+	return 4 * width * height, nil
+}
@@ -0,0 +1,93 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+	"unsafe"
+)
+
+func TestNewRGBA(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	entry, img, err := pool.NewRGBA(image.Rect(10, 20, 110, 70))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unsafe.SliceData(img.Pix) != unsafe.SliceData(entry.Buffers[0]) ||
+		len(img.Pix) != 100*50*4 || img.Stride != 400 {
+		t.Fatalf("the image isn't backed by the entry: %d bytes, stride %d", len(img.Pix), img.Stride)
+	}
+	if img.RGBAAt(10, 20) != (color.RGBA{}) {
+		t.Fatal("expected zeroed pixels")
+	}
+	img.SetRGBA(109, 69, color.RGBA{1, 2, 3, 4})
+	if got := entry.Buffers[0][len(img.Pix)-4:]; !bytes.Equal(got, []byte{1, 2, 3, 4}) {
+		t.Fatalf("unexpected last pixel: %v", got)
+	}
+	entry.Release()
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+
+	if _, _, err := pool.NewRGBA(image.Rect(0, 0, 1<<20, 1<<20)); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+	if _, _, err := pool.NewRGBA(image.Rect(0, 0, math.MaxInt/2, math.MaxInt/2)); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+	if _, _, err := pool.NewRGBA(image.Rectangle{Min: image.Pt(5, 5)}); err == nil {
+		t.Fatal("expected inverted bounds to be refused")
+	}
+}
+
+func TestDecodeRGBA(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 16), uint8(y * 32), 7, 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, src); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, img, format, err := pool.DecodeRGBA(bytes.NewReader(encoded.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	if format != "png" || img.Rect != src.Rect {
+		t.Fatalf("unexpected %s image %v", format, img.Rect)
+	}
+	if unsafe.SliceData(img.Pix) != unsafe.SliceData(entry.Buffers[0]) {
+		t.Fatal("the image isn't backed by the entry")
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			if got, expected := img.RGBAAt(x, y), (color.RGBA{uint8(x * 16), uint8(y * 32), 7, 255}); got != expected {
+				t.Fatalf("pixel %d,%d is %v, expected %v", x, y, got, expected)
+			}
+		}
+	}
+
+	// Images the pool can't hold fail before they're decoded.
+	small := NewPool(WithAllocator(NewHeapAllocator(256, 256)))
+	if _, _, _, err := small.DecodeRGBA(bytes.NewReader(encoded.Bytes())); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+
+	if _, _, _, err := pool.DecodeRGBA(bytes.NewReader(encoded.Bytes()[:40])); err == nil {
+		t.Fatal("expected a truncated image to fail")
+	}
+	if pool.Stats().BytesInUse != 16*8*4 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+}
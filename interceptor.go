@@ -0,0 +1,22 @@
+package rustybuffer
+
+// Interceptor wraps the Allocator a pool acquires from, the way HTTP
+// middleware wraps a handler, to add accounting, tracing or policy around
+// every Acquire and Release without changing the pool. The returned
+// Allocator should call through to next, and is most easily a struct
+// embedding next that overrides the methods it cares about. Embedding
+// keeps next's Stats, but not its optional methods (e.g., the handle
+// listing Pool.Reconcile uses), which a wrapper has to forward itself.
+type Interceptor func(next Allocator) Allocator
+
+// Use wraps the pool's allocator in interceptor. Each call wraps the
+// allocator built so far, so the last interceptor added sees each Acquire
+// and Release first. Entries are released through the allocator they were
+// acquired from, interceptors and all, and like NewPool's options Use
+// must be called before the pool is used by more than one goroutine.
+//
+// Interceptors only wrap the pool's allocator and not the Go heap or spill
+// files ExhaustionHeap and ExhaustionSpill fall back to.
+func (pool *Pool) Use(interceptor Interceptor) {
+	pool.alloc = interceptor(pool.alloc)
+}
@@ -0,0 +1,97 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+// recordingAllocator logs every call under its name.
+type recordingAllocator struct {
+	Allocator
+	name string
+	log  *[]string
+}
+
+func recording(name string, log *[]string) Interceptor {
+	return func(next Allocator) Allocator {
+		return &recordingAllocator{next, name, log}
+	}
+}
+
+func (alloc *recordingAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	*alloc.log = append(*alloc.log, fmt.Sprintf("%s acquire %d", alloc.name, size))
+	return alloc.Allocator.Acquire(size)
+}
+
+func (alloc *recordingAllocator) Release(data unsafe.Pointer) error {
+	*alloc.log = append(*alloc.log, alloc.name+" release")
+	return alloc.Allocator.Release(data)
+}
+
+func TestInterceptors(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc))
+
+	var log []string
+	pool.Use(recording("inner", &log))
+	pool.Use(recording("outer", &log))
+
+	entry, err := pool.AllocBuffers([]uint64{10, 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alloc.Stats().BytesInUse != 30 || pool.Stats().BytesInUse != 30 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+	entry.Release()
+
+	expected := []string{"outer acquire 30", "inner acquire 30", "outer release", "inner release"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Fatalf("expected %v, got %v", expected, log)
+	}
+}
+
+// refusingAllocator fails anything bigger than limit.
+type refusingAllocator struct {
+	Allocator
+	limit uint64
+}
+
+var errRefused = errors.New("refused")
+
+func (alloc refusingAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	if size > alloc.limit {
+		return nil, errRefused
+	}
+	return alloc.Allocator.Acquire(size)
+}
+
+func TestInterceptorPolicy(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc))
+
+	before, err := pool.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var log []string
+	pool.Use(recording("interceptor", &log))
+	pool.Use(func(next Allocator) Allocator { return refusingAllocator{next, 50} })
+
+	if _, err := pool.AllocBuffers([]uint64{100}); !errors.Is(err, errRefused) {
+		t.Fatalf("expected the interceptor's error, got %v", err)
+	}
+
+	// Entries acquired before Use are released without the interceptors.
+	before.Release()
+	if len(log) != 0 {
+		t.Fatalf("unexpected calls: %v", log)
+	}
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", alloc.Stats())
+	}
+}
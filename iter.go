@@ -0,0 +1,61 @@
+package rustybuffer
+
+import "fmt"
+
+// Iterators here are plain functions of the shape iter.Seq2 expects, so
+// they work with for range on Go 1.23 and later without the package
+// needing it.
+
+// Views returns an iterator over the entry's buffers as views, with their
+// index, so iterating gets the same release checks as using a view does:
+// it panics if the entry is released part way through.
+func (entry *RBEntry) Views() func(yield func(int, View) bool) {
+	buffers := entry.Buffers
+	state := entry.state
+	return func(yield func(int, View) bool) {
+		for idx, buffer := range buffers {
+			view := View{buffer, state}
+			view.check()
+			if !yield(idx, view) {
+				return
+			}
+		}
+	}
+}
+
+// Segments returns an iterator over the chain's buffers as views, with
+// their index, with the same release checks as Views: it panics if the
+// chain is released part way through.
+func (chain *Chain) Segments() func(yield func(int, View) bool) {
+	buffers := chain.Buffers
+	entries := chain.entries
+	return func(yield func(int, View) bool) {
+		for idx, buffer := range buffers {
+			var state *entryState
+			if idx < len(entries) {
+				state = entries[idx].state
+			}
+			view := View{buffer, state}
+			view.check()
+			if !yield(idx, view) {
+				return
+			}
+		}
+	}
+}
+
+// Chunks returns an iterator over consecutive size byte views of the view,
+// with their offsets, the last of which may be shorter. It panics if size
+// isn't positive, or if the entry is released part way through.
+func (view View) Chunks(size int) func(yield func(int, View) bool) {
+	if size <= 0 {
+		panic(fmt.Sprintf("rustybuffer: view chunk size %d isn't positive", size))
+	}
+	return func(yield func(int, View) bool) {
+		for offset := 0; offset < len(view.buf); offset += size {
+			if !yield(offset, view.Slice(offset, min(offset+size, len(view.buf)))) {
+				return
+			}
+		}
+	}
+}
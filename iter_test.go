@@ -0,0 +1,96 @@
+//go:build go1.23
+
+package rustybuffer
+
+import (
+	"testing"
+)
+
+func TestEntryViews(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	entry, err := pool.AllocBuffers([]uint64{4, 8, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lengths []int
+	for idx, view := range entry.Views() {
+		if idx != len(lengths) {
+			t.Fatalf("unexpected index %d", idx)
+		}
+		lengths = append(lengths, view.Len())
+	}
+	if len(lengths) != 3 || lengths[0] != 4 || lengths[1] != 8 || lengths[2] != 2 {
+		t.Fatalf("unexpected lengths %v", lengths)
+	}
+
+	for idx := range entry.Views() {
+		if idx > 0 {
+			t.Fatal("iteration didn't stop at break")
+		}
+		break
+	}
+
+	expectPanic(t, "after its entry was released", func() {
+		copied := entry
+		for idx := range entry.Views() {
+			if idx == 1 {
+				copied.Release()
+			}
+		}
+	})
+}
+
+func TestViewChunks(t *testing.T) {
+	view := ViewOf([]byte("abcdefghij"))
+
+	var chunks []string
+	for offset, chunk := range view.Chunks(4) {
+		if offset != 4*len(chunks) {
+			t.Fatalf("unexpected offset %d", offset)
+		}
+		chunks = append(chunks, string(chunk.Bytes()))
+	}
+	if len(chunks) != 3 || chunks[0] != "abcd" || chunks[1] != "efgh" || chunks[2] != "ij" {
+		t.Fatalf("unexpected chunks %q", chunks)
+	}
+
+	for range (View{}).Chunks(4) {
+		t.Fatal("an empty view has no chunks")
+	}
+	expectPanic(t, "isn't positive", func() { view.Chunks(0) })
+}
+
+func TestChainSegments(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	buf := pool.NewAppendBuffer(4)
+	if _, err := buf.Write([]byte("abcdefghij")); err != nil {
+		t.Fatal(err)
+	}
+	chain := buf.Chain()
+
+	var segments []string
+	for idx, view := range chain.Segments() {
+		if idx != len(segments) {
+			t.Fatalf("unexpected index %d", idx)
+		}
+		segments = append(segments, string(view.Bytes()))
+	}
+	if len(segments) != 3 || segments[0] != "abcd" || segments[1] != "efgh" || segments[2] != "ij" {
+		t.Fatalf("unexpected segments %q", segments)
+	}
+
+	expectPanic(t, "after its entry was released", func() {
+		for idx := range chain.Segments() {
+			if idx == 0 {
+				chain.Release()
+			}
+		}
+	})
+
+	for range chain.Segments() {
+		t.Fatal("a released chain has no segments")
+	}
+}
@@ -0,0 +1,147 @@
+//go:build cgo && rustybuffer_jemalloc
+
+package rustybuffer
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+/*
+#cgo LDFLAGS: -ljemalloc
+#include <stdlib.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <jemalloc/jemalloc.h>
+
+static int
+rb_je_arena_create(unsigned *arena)
+{
+    size_t len = sizeof(*arena);
+    return mallctl("arenas.create", arena, &len, NULL, 0);
+}
+
+static void *
+rb_je_acquire(unsigned arena, size_t size)
+{
+    return mallocx(size, MALLOCX_ARENA(arena) | MALLOCX_ZERO | MALLOCX_TCACHE_NONE);
+}
+
+static void
+rb_je_release(void *data)
+{
+    dallocx(data, MALLOCX_TCACHE_NONE);
+}
+
+// The bytes in active pages for the arena, or -1 if jemalloc was built
+// without statistics.
+static int64_t
+rb_je_arena_active(unsigned arena)
+{
+    uint64_t epoch = 1;
+    size_t len = sizeof(epoch);
+    if (mallctl("epoch", &epoch, &len, &epoch, len) != 0) {
+        return -1;
+    }
+
+    char name[64];
+    size_t pactive = 0;
+    len = sizeof(pactive);
+    snprintf(name, sizeof(name), "stats.arenas.%u.pactive", arena);
+    if (mallctl(name, &pactive, &len, NULL, 0) != 0) {
+        return -1;
+    }
+
+    size_t page = 0;
+    len = sizeof(page);
+    if (mallctl("arenas.page", &page, &len, NULL, 0) != 0) {
+        return -1;
+    }
+
+    return (int64_t) (pactive * page);
+}
+
+// Purge the arena's unused dirty pages, returning how many bytes of them
+// there were, or 0 if jemalloc was built without statistics.
+static uint64_t
+rb_je_arena_purge(unsigned arena)
+{
+    uint64_t epoch = 1;
+    size_t len = sizeof(epoch);
+    size_t pdirty = 0;
+    size_t page = 0;
+    char name[64];
+
+    if (mallctl("epoch", &epoch, &len, &epoch, len) == 0) {
+        len = sizeof(pdirty);
+        snprintf(name, sizeof(name), "stats.arenas.%u.pdirty", arena);
+        if (mallctl(name, &pdirty, &len, NULL, 0) != 0) {
+            pdirty = 0;
+        }
+        len = sizeof(page);
+        if (mallctl("arenas.page", &page, &len, NULL, 0) != 0) {
+            pdirty = 0;
+        }
+    }
+
+    snprintf(name, sizeof(name), "arena.%u.purge", arena);
+    mallctl(name, NULL, NULL, NULL, 0);
+
+    return (uint64_t) (pdirty * page);
+}
+*/
+import "C"
+
+// jemallocAllocator gets its memory from a jemalloc arena of its own so
+// that pools don't share (or fragment) each other's extents.
+type jemallocAllocator struct {
+	arena   C.unsigned
+	tracker *sizeTracker
+}
+
+// NewJemallocAllocator returns an Allocator backed by a new jemalloc arena,
+// with the same limits the Rust library enforces. BytesAllocated in its
+// Stats is the arena's active memory as reported by mallctl, so it includes
+// jemalloc's own rounding and fragmentation.
+//
+// Requires the rustybuffer_jemalloc build tag and an unprefixed jemalloc
+// (the default for distribution packages) to link against.
+func NewJemallocAllocator(
+	max_total_size uint64,
+	max_buffer_size uint64,
+) (Allocator, error) {
+	var arena C.unsigned
+	if res := C.rb_je_arena_create(&arena); res != 0 {
+		return nil, fmt.Errorf("rustybuffer: creating jemalloc arena: error %d", res)
+	}
+
+	return &jemallocAllocator{
+		arena:   arena,
+		tracker: newSizeTracker(max_total_size, max_buffer_size),
+	}, nil
+}
+
+func (alloc *jemallocAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	return alloc.tracker.acquire(size, func(size uint64) unsafe.Pointer {
+		return C.rb_je_acquire(alloc.arena, C.size_t(size))
+	})
+}
+
+func (alloc *jemallocAllocator) Release(data unsafe.Pointer) error {
+	return alloc.tracker.release(data, func(data unsafe.Pointer) {
+		C.rb_je_release(data)
+	})
+}
+
+func (alloc *jemallocAllocator) Stats() Stats {
+	stats := alloc.tracker.stats()
+	if active := C.rb_je_arena_active(alloc.arena); active >= 0 {
+		stats.BytesAllocated = uint64(active)
+	}
+	return stats
+}
+
+// Trim purges the arena's unused pages back to the OS.
+func (alloc *jemallocAllocator) Trim() uint64 {
+	return uint64(C.rb_je_arena_purge(alloc.arena))
+}
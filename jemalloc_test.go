@@ -0,0 +1,42 @@
+//go:build cgo && rustybuffer_jemalloc
+
+package rustybuffer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJemallocAllocator(t *testing.T) {
+	alloc, err := NewJemallocAllocator(1024*1024, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(WithAllocator(alloc))
+
+	entry, err := pool.AllocBuffers([]uint64{1000, 3000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, buf := range entry.Buffers {
+		for _, b := range buf {
+			if b != 0 {
+				t.Fatal("memory was not zeroed")
+			}
+		}
+	}
+
+	stats := pool.Stats()
+	if stats.BytesInUse != 4000 || stats.BytesAllocated < 4000 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	entry.Release()
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+
+	if _, err := pool.AllocBuffers([]uint64{128 * 1024}); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package rustybuffer
+
+import (
+	"encoding/json"
+	"io"
+	"unsafe"
+)
+
+// The first read buffer used by DecodeJSON when the document size isn't
+// known up front.
+const jsonInitialBufferSize = 64 * 1024
+
+// DecodeJSON reads an entire JSON document from r into pooled memory and
+// unmarshals it into v. The document is never duplicated on the Go heap:
+// any JSONString fields in v point directly into the returned entry, so the
+// entry must outlive v's use of them.
+//
+// size is the document length if known (e.g., Content-Length), otherwise
+// pass zero and the pooled buffer is grown as the document is read.
+func DecodeJSON(r io.Reader, size int, v any) (RBEntry, error) {
+	entry, doc, err := readAllPooled(r, size)
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	if err := json.Unmarshal(doc, v); err != nil {
+		entry.Release()
+		return RBEntry{}, err
+	}
+
+	return entry, nil
+}
+
+// JSONString is a string that, when decoded by DecodeJSON, shares memory
+// with the pooled JSON document instead of being copied onto the heap.
+// Strings containing escape sequences have to be unescaped and are copied
+// as usual.
+//
+// A JSONString is only valid until the entry returned by DecodeJSON is
+// released; use strings.Clone to keep one longer.
+type JSONString string
+
+func (s *JSONString) UnmarshalJSON(data []byte) error {
+	if len(data) < 2 || data[0] != '"' {
+		// null, or a type mismatch that json.Unmarshal reports for us.
+		var str *string
+		if err := json.Unmarshal(data, &str); err != nil || str == nil {
+			return err
+		}
+		*s = JSONString(*str)
+		return nil
+	}
+
+	for _, b := range data {
+		if b == '\\' {
+			var str string
+			if err := json.Unmarshal(data, &str); err != nil {
+				return err
+			}
+			*s = JSONString(str)
+			return nil
+		}
+	}
+
+	body := data[1 : len(data)-1]
+	*s = JSONString(unsafe.String(unsafe.SliceData(body), len(body)))
+	return nil
+}
+
+// readAllPooled reads r to EOF into a single pooled buffer. When size is
+// known only one buffer is acquired, otherwise the buffer doubles as needed.
+func readAllPooled(r io.Reader, size int) (RBEntry, []byte, error) {
+	if size > 0 {
+		entry, err := allocBuffers([]uint64{uint64(size)})
+		if err != nil {
+			return RBEntry{}, nil, err
+		}
+		if _, err := io.ReadFull(r, entry.Buffers[0]); err != nil {
+			entry.Release()
+			return RBEntry{}, nil, err
+		}
+		return entry, entry.Buffers[0], nil
+	}
+
+	entry, err := allocBuffers([]uint64{jsonInitialBufferSize})
+	if err != nil {
+		return RBEntry{}, nil, err
+	}
+
+	var num_read int = 0
+	for {
+		buf := entry.Buffers[0]
+		if num_read == len(buf) {
+			bigger, err := allocBuffers([]uint64{2 * uint64(len(buf))})
+			if err != nil {
+				entry.Release()
+				return RBEntry{}, nil, err
+			}
+			copy(bigger.Buffers[0], buf)
+			entry.Release()
+			entry = bigger
+			buf = entry.Buffers[0]
+		}
+
+		n, err := r.Read(buf[num_read:])
+		num_read += n
+		if err == io.EOF {
+			return entry, buf[:num_read], nil
+		}
+		if err != nil {
+			entry.Release()
+			return RBEntry{}, nil, err
+		}
+	}
+}
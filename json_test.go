@@ -0,0 +1,72 @@
+package rustybuffer
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+type jsonDoc struct {
+	Name    JSONString   `json:"name"`
+	Escaped JSONString   `json:"escaped"`
+	Missing JSONString   `json:"missing"`
+	Tags    []JSONString `json:"tags"`
+	Count   int          `json:"count"`
+}
+
+func TestDecodeJSON(t *testing.T) {
+	Configure(64*1024*1024, 1024*1024)
+
+	doc := `{"name": "pooled", "escaped": "a\nb", "missing": null,` +
+		` "tags": ["x", "y"], "count": 3}`
+
+	for _, size := range []int{len(doc), 0} {
+		var v jsonDoc
+		entry, err := DecodeJSON(strings.NewReader(doc), size, &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v.Name != "pooled" || v.Escaped != "a\nb" || v.Missing != "" {
+			t.Fatalf("unexpected strings: %+v", v)
+		}
+		if len(v.Tags) != 2 || v.Tags[1] != "y" || v.Count != 3 {
+			t.Fatalf("unexpected values: %+v", v)
+		}
+
+		start := uintptr(unsafe.Pointer(unsafe.SliceData(entry.Buffers[0])))
+		end := start + uintptr(len(entry.Buffers[0]))
+		name := uintptr(unsafe.Pointer(unsafe.StringData(string(v.Name))))
+		if name < start || name >= end {
+			t.Fatal("expected name to point into the pooled document")
+		}
+
+		entry.Release()
+	}
+}
+
+func TestDecodeJSONGrowsBuffer(t *testing.T) {
+	Configure(64*1024*1024, 1024*1024)
+
+	value := strings.Repeat("z", 3*jsonInitialBufferSize)
+	var v jsonDoc
+	entry, err := DecodeJSON(strings.NewReader(`{"name":"`+value+`"}`), 0, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	if string(v.Name) != value {
+		t.Fatal("large value was not decoded")
+	}
+}
+
+func TestDecodeJSONInvalid(t *testing.T) {
+	Configure(64*1024*1024, 1024*1024)
+
+	var v jsonDoc
+	_, err := DecodeJSON(strings.NewReader(`{"name": 5}`), 0, &v)
+	if err == nil {
+		t.Fatal("expected a type error")
+	}
+}
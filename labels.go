@@ -0,0 +1,166 @@
+package rustybuffer
+
+import (
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// WithLabels attaches labels to the acquired entry, e.g., the tenant or
+// request it's for, so that leak reports and StatsByLabel can say who owns
+// it. The map is copied.
+func WithLabels(labels map[string]string) AcquireOption {
+	return func(opts *acquireOptions) {
+		opts.labels = labels
+	}
+}
+
+// entryLabels is shared by every copy of an entry, and by its pool's
+// registry of labelled entries.
+type entryLabels struct {
+	mu     sync.Mutex
+	labels map[string]string
+}
+
+func (labels *entryLabels) get() map[string]string {
+	labels.mu.Lock()
+	defer labels.mu.Unlock()
+
+	return maps.Clone(labels.labels)
+}
+
+func (labels *entryLabels) set(key string, value string) {
+	labels.mu.Lock()
+	defer labels.mu.Unlock()
+
+	labels.labels[key] = value
+}
+
+// SetLabel sets one of the entry's labels (see WithLabels), for every copy
+// of the entry. It does nothing once the entry is released, or for entries
+// that didn't come from a pool.
+func (entry *RBEntry) SetLabel(key string, value string) {
+	state := entry.state
+	if state == nil || state.released.Load() {
+		return
+	}
+
+	labels := state.labels.Load()
+	if labels == nil {
+		fresh := &entryLabels{labels: make(map[string]string)}
+		if state.labels.CompareAndSwap(nil, fresh) {
+			state.pool.labelled(state, fresh)
+		}
+		labels = state.labels.Load()
+	}
+	labels.set(key, value)
+}
+
+// Labels returns a copy of the entry's labels, which is nil if it has none.
+func (entry *RBEntry) Labels() map[string]string {
+	if entry.state == nil {
+		return nil
+	}
+	if labels := entry.state.labels.Load(); labels != nil {
+		return labels.get()
+	}
+	return nil
+}
+
+// labelled records that a live entry's been given labels, for everything
+// in its pool that reports them.
+func (pool *Pool) labelled(state *entryState, labels *entryLabels) {
+	pool.labels.track(state, labels)
+	if pool.checkpoints != nil {
+		pool.checkpoints.label(state.data, labels)
+	}
+	if pool.entries != nil {
+		pool.entries.label(state.data, labels)
+	}
+}
+
+// formatLabels renders labels sorted by key, for logging.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// LabelStats is what StatsByLabel reports for each value of a label.
+type LabelStats struct {
+	Entries uint64
+	Bytes   uint64
+}
+
+// StatsByLabel breaks down the pool's live entries by the value of their
+// key label. Entries without it aren't counted.
+func (pool *Pool) StatsByLabel(key string) map[string]LabelStats {
+	return pool.labels.group(key)
+}
+
+type labelledEntry struct {
+	size   uint64
+	labels *entryLabels
+}
+
+// labelRegistry is every live labelled entry in a pool.
+type labelRegistry struct {
+	mu   sync.Mutex
+	live map[unsafe.Pointer]labelledEntry
+}
+
+func newLabelRegistry() *labelRegistry {
+	return &labelRegistry{live: make(map[unsafe.Pointer]labelledEntry)}
+}
+
+// track adds an entry, unless it's already been released: releasing
+// forgets the entry after marking it released, and has to wait for the
+// lock to do so.
+func (registry *labelRegistry) track(state *entryState, labels *entryLabels) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if state.released.Load() {
+		return
+	}
+	registry.live[state.data] = labelledEntry{state.size, labels}
+}
+
+// forget drops a released entry, returning its labels.
+func (registry *labelRegistry) forget(data unsafe.Pointer) map[string]string {
+	registry.mu.Lock()
+	entry, ok := registry.live[data]
+	delete(registry.live, data)
+	registry.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return entry.labels.get()
+}
+
+func (registry *labelRegistry) group(key string) map[string]LabelStats {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	groups := make(map[string]LabelStats)
+	for _, entry := range registry.live {
+		entry.labels.mu.Lock()
+		value, ok := entry.labels.labels[key]
+		entry.labels.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		stats := groups[value]
+		stats.Entries++
+		stats.Bytes += entry.size
+		groups[value] = stats
+	}
+	return groups
+}
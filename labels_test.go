@@ -0,0 +1,132 @@
+package rustybuffer
+
+import (
+	"log"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLabels(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	labels := map[string]string{"tenant": "acme", "table": "orders"}
+	first, err := pool.AllocBuffers([]uint64{100}, WithLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The map was copied.
+	labels["tenant"] = "changed"
+	if got := first.Labels(); !reflect.DeepEqual(got, map[string]string{"tenant": "acme", "table": "orders"}) {
+		t.Fatalf("unexpected labels: %v", got)
+	}
+
+	second, err := pool.AllocBuffers([]uint64{50, 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Labels() != nil {
+		t.Fatalf("unexpected labels: %v", second.Labels())
+	}
+
+	// Labels set later are seen by every copy.
+	copied := second
+	copied.SetLabel("tenant", "acme")
+	second.SetLabel("tenant", "initech")
+	if got := copied.Labels(); !reflect.DeepEqual(got, map[string]string{"tenant": "initech"}) {
+		t.Fatalf("unexpected labels: %v", got)
+	}
+
+	third, err := pool.AllocBuffers([]uint64{7}, WithLabels(map[string]string{"tenant": "acme"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlabelled, err := pool.AllocBuffers([]uint64{1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlabelled.Release()
+
+	expected := map[string]LabelStats{
+		"acme":    {Entries: 2, Bytes: 107},
+		"initech": {Entries: 1, Bytes: 100},
+	}
+	if got := pool.StatsByLabel("tenant"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if got := pool.StatsByLabel("table"); !reflect.DeepEqual(got, map[string]LabelStats{"orders": {1, 100}}) {
+		t.Fatalf("unexpected stats: %v", got)
+	}
+
+	first.Release()
+	second.Release()
+	third.Release()
+
+	// Released entries are forgotten, and can't be labelled again.
+	copied.SetLabel("tenant", "acme")
+	if got := pool.StatsByLabel("tenant"); len(got) != 0 {
+		t.Fatalf("unexpected stats: %v", got)
+	}
+	if len(pool.labels.live) != 0 {
+		t.Fatalf("still tracking %v", pool.labels.live)
+	}
+
+	// Entries that aren't from a pool just don't have labels.
+	var literal RBEntry
+	literal.SetLabel("tenant", "acme")
+	if literal.Labels() != nil {
+		t.Fatalf("unexpected labels: %v", literal.Labels())
+	}
+}
+
+func TestLabelsInLeakReports(t *testing.T) {
+	var reported []Reclamation
+	pool := NewPool(
+		WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithLeakTracking(func(reclamation Reclamation) {
+			reported = append(reported, reclamation)
+		}),
+	)
+
+	leakEntry(t, pool, WithLabels(map[string]string{"request": "42"}))
+
+	for idx := 0; idx < 100 && len(reported) == 0; idx++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+		pool.Reconcile()
+	}
+
+	if len(reported) != 1 {
+		t.Fatalf("expected one reclamation, got %v", reported)
+	}
+	if !reflect.DeepEqual(reported[0].Labels, map[string]string{"request": "42"}) {
+		t.Fatalf("unexpected labels: %v", reported[0].Labels)
+	}
+	if len(pool.StatsByLabel("request")) != 0 {
+		t.Fatal("reclaimed entry is still counted")
+	}
+}
+
+func TestLabelsInFinalizerLog(t *testing.T) {
+	var output syncBuffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&output)
+
+	pool := NewPool(
+		WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithLeakFinalizer(),
+	)
+
+	leakEntry(t, pool, WithLabels(map[string]string{"tenant": "acme", "request": "42"}))
+
+	for idx := 0; idx < 100 && !strings.Contains(output.String(), "released leaked entry"); idx++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+
+	if !strings.Contains(output.String(), "(request=42 tenant=acme)") {
+		t.Fatalf("expected the labels to be logged, got %q", output.String())
+	}
+}
@@ -0,0 +1,124 @@
+package rustybuffer
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLeaseExpired is returned by Lease.Renew once the pool has reclaimed
+// the lease's entry.
+var ErrLeaseExpired = errors.New("rustybuffer: lease expired")
+
+// Lease is an entry the pool reclaims by itself unless it's renewed or
+// released before its ttl runs out, bounding how long memory leaked by a
+// forgotten error path stays out of the pool. Leases are safe for
+// concurrent use.
+type Lease struct {
+	entry     RBEntry
+	on_expire func(lease *Lease)
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	deadline time.Time
+	expired  bool
+}
+
+// AcquireLease is AcquireLease on the default pool.
+func AcquireLease(sizes []uint64, ttl time.Duration, on_expire func(lease *Lease),
+	opts ...AcquireOption) (*Lease, error) {
+	return defaultPool.AcquireLease(sizes, ttl, on_expire, opts...)
+}
+
+// AcquireLease acquires an entry like AllocBuffers and leases it for ttl.
+// If the lease expires, on_expire (which may be nil) is called on its own
+// goroutine before the entry is released, so the holder can stop using it;
+// by then it's too late to renew. The entry must not be touched after
+// on_expire returns.
+func (pool *Pool) AcquireLease(sizes []uint64, ttl time.Duration, on_expire func(lease *Lease),
+	opts ...AcquireOption) (*Lease, error) {
+	entry, err := pool.AllocBuffers(sizes, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	lease := &Lease{
+		entry:     entry,
+		on_expire: on_expire,
+		deadline:  time.Now().Add(ttl),
+	}
+
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+	lease.timer = time.AfterFunc(ttl, lease.expire)
+
+	return lease, nil
+}
+
+// Entry returns the leased entry. Releasing it, or any copy of it, ends
+// the lease just like Release.
+func (lease *Lease) Entry() RBEntry {
+	return lease.entry
+}
+
+// Renew extends the lease to ttl from now, or fails with ErrLeaseExpired
+// if it's too late. Renewing a released lease is an error too.
+func (lease *Lease) Renew(ttl time.Duration) error {
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+
+	if lease.expired || lease.released() {
+		return ErrLeaseExpired
+	}
+
+	lease.deadline = time.Now().Add(ttl)
+	lease.timer.Reset(ttl)
+
+	return nil
+}
+
+// Expired reports whether the pool reclaimed the entry.
+func (lease *Lease) Expired() bool {
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+
+	return lease.expired
+}
+
+// Release ends the lease and releases its entry. It's a no-op if the lease
+// has already expired.
+func (lease *Lease) Release() {
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+
+	lease.timer.Stop()
+	if !lease.expired {
+		entry := lease.entry
+		entry.Release()
+	}
+}
+
+func (lease *Lease) released() bool {
+	return lease.entry.state != nil && lease.entry.state.released.Load()
+}
+
+// expire runs when the timer fires, which can race with Renew resetting
+// it, hence checking the deadline.
+func (lease *Lease) expire() {
+	lease.mu.Lock()
+	if lease.expired || lease.released() || time.Now().Before(lease.deadline) {
+		lease.mu.Unlock()
+		return
+	}
+	lease.expired = true
+	lease.mu.Unlock()
+
+	if lease.on_expire != nil {
+		lease.on_expire(lease)
+	}
+
+	// Releasing a copy leaves lease.entry alone for Entry, and the copies
+	// share their release state.
+	entry := lease.entry
+	entry.Release()
+}
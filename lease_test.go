@@ -0,0 +1,116 @@
+package rustybuffer
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLeaseExpires(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc))
+
+	expired := make(chan *Lease, 1)
+	lease, err := pool.AcquireLease([]uint64{100}, 10*time.Millisecond, func(lease *Lease) {
+		// The entry is still held while the callback runs.
+		if alloc.Stats().BytesInUse != 100 {
+			t.Errorf("entry released before the callback: %+v", alloc.Stats())
+		}
+		expired <- lease
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-expired:
+		if got != lease {
+			t.Fatal("callback got a different lease")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lease didn't expire")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for alloc.Stats().BytesInUse != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("entry wasn't released: %+v", alloc.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if !lease.Expired() {
+		t.Fatal("expected the lease to be expired")
+	}
+	if err := lease.Renew(time.Hour); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("expected ErrLeaseExpired, got %v", err)
+	}
+
+	// Releasing an expired lease is a no-op.
+	lease.Release()
+}
+
+func TestLeaseRenew(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc))
+
+	lease, err := pool.AcquireLease([]uint64{100}, 200*time.Millisecond, func(lease *Lease) {
+		t.Error("renewed lease expired")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep renewing for well past the original ttl.
+	for idx := 0; idx < 10; idx++ {
+		time.Sleep(30 * time.Millisecond)
+		if err := lease.Renew(200 * time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if lease.Expired() {
+		t.Fatal("renewed lease expired")
+	}
+	copy(lease.Entry().Buffers[0], "still mine")
+
+	lease.Release()
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("entry wasn't released: %+v", alloc.Stats())
+	}
+	if err := lease.Renew(time.Hour); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("expected ErrLeaseExpired, got %v", err)
+	}
+
+	// Give the timer a chance to fire if it was going to.
+	time.Sleep(50 * time.Millisecond)
+}
+
+func TestLeaseEntryReleased(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	lease, err := pool.AcquireLease([]uint64{100}, 10*time.Millisecond, func(lease *Lease) {
+		t.Error("released lease expired")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Releasing the entry directly ends the lease too.
+	entry := lease.Entry()
+	entry.Release()
+
+	time.Sleep(50 * time.Millisecond)
+	if lease.Expired() {
+		t.Fatal("released lease expired")
+	}
+}
+
+func TestLeaseAcquireFails(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	_, err := pool.AcquireLease([]uint64{2048}, time.Hour, nil)
+	if !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+}
@@ -1,3 +1,68 @@
+typedef struct {
+    uint64_t max_total_size;
+    uint64_t max_buffer_size;
+    uint64_t bytes_allocated;
+    uint64_t bytes_in_use;
+    uint64_t num_buffers;
+    uint64_t num_available;
+} rustybuffer_stats_t;
+
+typedef struct {
+    uint64_t total_len;
+    uint64_t seed;
+    uint64_t v[4];
+    uint8_t mem[32];
+    uint64_t mem_size;
+} rustybuffer_xxh64_t;
+
+typedef struct {
+    uint64_t dst;
+    uint64_t src;
+    uint64_t len;
+} rustybuffer_copy_segment_t;
+
+typedef struct {
+    uint64_t data;
+    uint64_t len;
+} rustybuffer_span_t;
+
+typedef struct {
+    uint64_t id;
+    uint64_t arg;
+    uint64_t result;
+} rustybuffer_transform_step_t;
+
+#define RUSTYBUFFER_EVENT_TRIMMED 1
+#define RUSTYBUFFER_EVENT_PRESSURE 2
+#define RUSTYBUFFER_EVENT_INVALID_RELEASE 3
+
+typedef void (*rustybuffer_event_callback_t)(uint32_t, uint64_t);
+
+uint32_t rustybuffer_version(void);
+uint64_t rustybuffer_capabilities(void);
 uint8_t rustybuffer_config(uint64_t, uint64_t);
 uint8_t rustybuffer_acquire(uint64_t, void **);
 uint8_t rustybuffer_release(void *);
+uint8_t rustybuffer_stats(rustybuffer_stats_t *);
+uint64_t rustybuffer_live_handles(uint64_t *, uint64_t);
+uint64_t rustybuffer_cached_sizes(uint64_t *, uint64_t);
+uint64_t rustybuffer_trim(void);
+void rustybuffer_trim_async(void);
+void rustybuffer_set_event_callback(rustybuffer_event_callback_t);
+void rustybuffer_fill(void *, uint64_t, uint8_t);
+uint8_t rustybuffer_fill_random(void *, uint64_t);
+void rustybuffer_copy(const rustybuffer_copy_segment_t *, uint64_t);
+int32_t rustybuffer_compare(const void *, uint64_t, const void *, uint64_t);
+uint8_t rustybuffer_constant_time_equal(const void *, const void *, uint64_t);
+uint32_t rustybuffer_crc32c(uint32_t, const void *, uint64_t);
+void rustybuffer_xxh64_reset(rustybuffer_xxh64_t *, uint64_t);
+void rustybuffer_xxh64_update(rustybuffer_xxh64_t *, const void *, uint64_t);
+uint64_t rustybuffer_xxh64_digest(const rustybuffer_xxh64_t *);
+int32_t rustybuffer_writev_checksum(int32_t, const rustybuffer_span_t *, uint64_t, uint32_t, uint64_t *, uint64_t *);
+uint64_t rustybuffer_transform_name(uint64_t, char *, uint64_t);
+uint8_t rustybuffer_transform(const rustybuffer_span_t *, uint64_t, rustybuffer_transform_step_t *, uint64_t);
+uint64_t rustybuffer_lz4_bound(uint64_t);
+uint8_t rustybuffer_lz4_compress(const void *, uint64_t, void *, uint64_t, uint64_t *);
+uint8_t rustybuffer_lz4_content_size(const void *, uint64_t, uint64_t *);
+uint8_t rustybuffer_lz4_decompress(const void *, uint64_t, void *, uint64_t, uint64_t *);
+uint64_t rustybuffer_last_error(char *, uint64_t);
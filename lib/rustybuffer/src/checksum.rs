@@ -0,0 +1,391 @@
+//! Checksums computed directly over caller memory. Like rustybuffer_fill
+//! these don't touch the buffer cache, so they take no lock.
+
+/// CRC32C (Castagnoli), reflected, as used by iSCSI, ext4 and friends.
+const CRC32C_POLY: u32 = 0x82F6_3B78;
+
+/// Slicing-by-8 tables for when the CPU has no CRC32C instruction.
+static CRC32C_TABLES: [[u32; 256]; 8] = crc32c_tables();
+
+const fn crc32c_tables() -> [[u32; 256]; 8] {
+    let mut tables = [[0u32; 256]; 8];
+
+    let mut idx = 0;
+    while idx < 256 {
+        let mut crc = idx as u32;
+        let mut bit = 0;
+        while bit < 8 {
+            crc = if crc & 1 == 1 {
+                (crc >> 1) ^ CRC32C_POLY
+            } else {
+                crc >> 1
+            };
+            bit += 1;
+        }
+        tables[0][idx] = crc;
+        idx += 1;
+    }
+
+    let mut table = 1;
+    while table < 8 {
+        let mut idx = 0;
+        while idx < 256 {
+            let prev = tables[table - 1][idx];
+            tables[table][idx] =
+                (prev >> 8) ^ tables[0][(prev & 0xFF) as usize];
+            idx += 1;
+        }
+        table += 1;
+    }
+
+    tables
+}
+
+fn crc32c_table(mut crc: u32, data: &[u8]) -> u32 {
+    let t = &CRC32C_TABLES;
+
+    let mut chunks = data.chunks_exact(8);
+    for chunk in &mut chunks {
+        let lo =
+            crc ^ u32::from_le_bytes([chunk[0], chunk[1], chunk[2], chunk[3]]);
+        crc = t[7][(lo & 0xFF) as usize]
+            ^ t[6][((lo >> 8) & 0xFF) as usize]
+            ^ t[5][((lo >> 16) & 0xFF) as usize]
+            ^ t[4][(lo >> 24) as usize]
+            ^ t[3][chunk[4] as usize]
+            ^ t[2][chunk[5] as usize]
+            ^ t[1][chunk[6] as usize]
+            ^ t[0][chunk[7] as usize];
+    }
+    for &b in chunks.remainder() {
+        crc = (crc >> 8) ^ t[0][((crc ^ b as u32) & 0xFF) as usize];
+    }
+
+    crc
+}
+
+/// The SSE4.2 path checksums three lanes of this many bytes at once, to
+/// keep the CPU's CRC32 unit busy, and then stitches them together.
+#[cfg(target_arch = "x86_64")]
+const CRC32C_LANE: usize = 4096;
+
+/// Tables to advance a raw CRC register over CRC32C_LANE zero bytes, which
+/// is what appending a lane's worth of data does to the lanes before it.
+#[cfg(target_arch = "x86_64")]
+fn crc32c_shift_tables() -> &'static [[u32; 256]; 4] {
+    static TABLES: std::sync::OnceLock<[[u32; 256]; 4]> =
+        std::sync::OnceLock::new();
+
+    TABLES.get_or_init(|| {
+        // Advancing over zeros is linear, so it's enough to know where
+        // each bit of the register ends up.
+        let mut basis = [0u32; 32];
+        for (bit, column) in basis.iter_mut().enumerate() {
+            let mut crc = 1u32 << bit;
+            for _ in 0..CRC32C_LANE {
+                crc = (crc >> 8) ^ CRC32C_TABLES[0][(crc & 0xFF) as usize];
+            }
+            *column = crc;
+        }
+
+        let mut tables = [[0u32; 256]; 4];
+        for (byte, table) in tables.iter_mut().enumerate() {
+            for (value, entry) in table.iter_mut().enumerate() {
+                for bit in 0..8 {
+                    if value & (1 << bit) != 0 {
+                        *entry ^= basis[byte * 8 + bit];
+                    }
+                }
+            }
+        }
+        tables
+    })
+}
+
+#[cfg(target_arch = "x86_64")]
+fn crc32c_shift(tables: &[[u32; 256]; 4], crc: u32) -> u32 {
+    tables[0][(crc & 0xFF) as usize]
+        ^ tables[1][((crc >> 8) & 0xFF) as usize]
+        ^ tables[2][((crc >> 16) & 0xFF) as usize]
+        ^ tables[3][(crc >> 24) as usize]
+}
+
+#[cfg(target_arch = "x86_64")]
+#[target_feature(enable = "sse4.2")]
+unsafe fn crc32c_sse42(crc: u32, data: &[u8]) -> u32 {
+    use std::arch::x86_64::{_mm_crc32_u64, _mm_crc32_u8};
+
+    let mut crc = crc;
+
+    let mut blocks = data.chunks_exact(3 * CRC32C_LANE);
+    if blocks.len() > 0 {
+        let shift = crc32c_shift_tables();
+        for block in &mut blocks {
+            let (a, rest) = block.split_at(CRC32C_LANE);
+            let (b, c) = rest.split_at(CRC32C_LANE);
+
+            let (mut crc_a, mut crc_b, mut crc_c) = (crc as u64, 0u64, 0u64);
+            for idx in (0..CRC32C_LANE).step_by(8) {
+                crc_a = _mm_crc32_u64(crc_a, read_u64(&a[idx..]));
+                crc_b = _mm_crc32_u64(crc_b, read_u64(&b[idx..]));
+                crc_c = _mm_crc32_u64(crc_c, read_u64(&c[idx..]));
+            }
+
+            crc = crc32c_shift(shift, crc_a as u32) ^ crc_b as u32;
+            crc = crc32c_shift(shift, crc) ^ crc_c as u32;
+        }
+    }
+
+    let mut crc = crc as u64;
+    let mut chunks = blocks.remainder().chunks_exact(8);
+    for chunk in &mut chunks {
+        crc = _mm_crc32_u64(crc, read_u64(chunk));
+    }
+    let mut crc = crc as u32;
+    for &b in chunks.remainder() {
+        crc = _mm_crc32_u8(crc, b);
+    }
+
+    crc
+}
+
+/// Continue a CRC32C over data. The crc is the finalised value, so a
+/// checksum over several buffers is each one fed the last one's result,
+/// starting from zero.
+pub fn crc32c(crc: u32, data: &[u8]) -> u32 {
+    let crc = !crc;
+
+    #[cfg(target_arch = "x86_64")]
+    {
+        if is_x86_feature_detected!("sse4.2") {
+            return !unsafe { crc32c_sse42(crc, data) };
+        }
+    }
+
+    !crc32c_table(crc, data)
+}
+
+const XXH_PRIME64_1: u64 = 0x9E37_79B1_85EB_CA87;
+const XXH_PRIME64_2: u64 = 0xC2B2_AE3D_27D4_EB4F;
+const XXH_PRIME64_3: u64 = 0x1656_67B1_9E37_79F9;
+const XXH_PRIME64_4: u64 = 0x85EB_CA77_C2B2_AE63;
+const XXH_PRIME64_5: u64 = 0x27D4_EB2F_1656_67C5;
+
+/// Streaming xxHash64 state, laid out to match rustybuffer_xxh64_t so the
+/// caller can own it.
+#[repr(C)]
+pub struct XXH64State {
+    total_len: u64,
+    seed: u64,
+    v: [u64; 4],
+    mem: [u8; 32],
+    mem_size: u64,
+}
+
+fn xxh64_round(acc: u64, input: u64) -> u64 {
+    acc.wrapping_add(input.wrapping_mul(XXH_PRIME64_2))
+        .rotate_left(31)
+        .wrapping_mul(XXH_PRIME64_1)
+}
+
+fn xxh64_merge_round(acc: u64, val: u64) -> u64 {
+    (acc ^ xxh64_round(0, val))
+        .wrapping_mul(XXH_PRIME64_1)
+        .wrapping_add(XXH_PRIME64_4)
+}
+
+fn read_u64(data: &[u8]) -> u64 {
+    u64::from_le_bytes(data[..8].try_into().expect("8 bytes"))
+}
+
+fn read_u32(data: &[u8]) -> u32 {
+    u32::from_le_bytes(data[..4].try_into().expect("4 bytes"))
+}
+
+impl XXH64State {
+    pub fn new(seed: u64) -> Self {
+        let mut state = XXH64State {
+            total_len: 0,
+            seed: 0,
+            v: [0; 4],
+            mem: [0; 32],
+            mem_size: 0,
+        };
+        state.reset(seed);
+        state
+    }
+
+    fn reset(&mut self, seed: u64) {
+        self.total_len = 0;
+        self.seed = seed;
+        self.v = [
+            seed.wrapping_add(XXH_PRIME64_1).wrapping_add(XXH_PRIME64_2),
+            seed.wrapping_add(XXH_PRIME64_2),
+            seed,
+            seed.wrapping_sub(XXH_PRIME64_1),
+        ];
+        self.mem = [0; 32];
+        self.mem_size = 0;
+    }
+
+    fn stripe(&mut self, stripe: &[u8]) {
+        for (lane, v) in self.v.iter_mut().enumerate() {
+            *v = xxh64_round(*v, read_u64(&stripe[lane * 8..]));
+        }
+    }
+
+    pub fn update(&mut self, mut data: &[u8]) {
+        self.total_len = self.total_len.wrapping_add(data.len() as u64);
+
+        let mem_size = self.mem_size as usize;
+        if mem_size + data.len() < 32 {
+            self.mem[mem_size..mem_size + data.len()].copy_from_slice(data);
+            self.mem_size += data.len() as u64;
+            return;
+        }
+
+        if mem_size > 0 {
+            let (head, rest) = data.split_at(32 - mem_size);
+            self.mem[mem_size..].copy_from_slice(head);
+            let mem = self.mem;
+            self.stripe(&mem);
+            self.mem_size = 0;
+            data = rest;
+        }
+
+        let mut stripes = data.chunks_exact(32);
+        for stripe in &mut stripes {
+            self.stripe(stripe);
+        }
+
+        let rest = stripes.remainder();
+        self.mem[..rest.len()].copy_from_slice(rest);
+        self.mem_size = rest.len() as u64;
+    }
+
+    pub fn digest(&self) -> u64 {
+        let mut hash = if self.total_len >= 32 {
+            let [v1, v2, v3, v4] = self.v;
+            let mut hash = v1
+                .rotate_left(1)
+                .wrapping_add(v2.rotate_left(7))
+                .wrapping_add(v3.rotate_left(12))
+                .wrapping_add(v4.rotate_left(18));
+            for v in self.v {
+                hash = xxh64_merge_round(hash, v);
+            }
+            hash
+        } else {
+            self.seed.wrapping_add(XXH_PRIME64_5)
+        };
+        hash = hash.wrapping_add(self.total_len);
+
+        let mut rest = &self.mem[..self.mem_size as usize];
+        while rest.len() >= 8 {
+            hash ^= xxh64_round(0, read_u64(rest));
+            hash = hash
+                .rotate_left(27)
+                .wrapping_mul(XXH_PRIME64_1)
+                .wrapping_add(XXH_PRIME64_4);
+            rest = &rest[8..];
+        }
+        if rest.len() >= 4 {
+            hash ^= (read_u32(rest) as u64).wrapping_mul(XXH_PRIME64_1);
+            hash = hash
+                .rotate_left(23)
+                .wrapping_mul(XXH_PRIME64_2)
+                .wrapping_add(XXH_PRIME64_3);
+            rest = &rest[4..];
+        }
+        for &b in rest {
+            hash ^= (b as u64).wrapping_mul(XXH_PRIME64_5);
+            hash = hash.rotate_left(11).wrapping_mul(XXH_PRIME64_1);
+        }
+
+        hash ^= hash >> 33;
+        hash = hash.wrapping_mul(XXH_PRIME64_2);
+        hash ^= hash >> 29;
+        hash = hash.wrapping_mul(XXH_PRIME64_3);
+        hash ^= hash >> 32;
+
+        hash
+    }
+}
+
+fn as_slice<'a>(data: *const std::ffi::c_uchar, len: u64) -> &'a [u8] {
+    if data.is_null() || len == 0 {
+        &[]
+    } else {
+        unsafe { std::slice::from_raw_parts(data, len as usize) }
+    }
+}
+
+/// Continue a CRC32C, see crc32c.
+#[no_mangle]
+pub extern "C" fn rustybuffer_crc32c(
+    crc: u32,
+    data: *const std::ffi::c_uchar,
+    len: u64,
+) -> u32 {
+    crc32c(crc, as_slice(data, len))
+}
+
+#[no_mangle]
+pub extern "C" fn rustybuffer_xxh64_reset(state: *mut XXH64State, seed: u64) {
+    unsafe { &mut *state }.reset(seed);
+}
+
+#[no_mangle]
+pub extern "C" fn rustybuffer_xxh64_update(
+    state: *mut XXH64State,
+    data: *const std::ffi::c_uchar,
+    len: u64,
+) {
+    unsafe { &mut *state }.update(as_slice(data, len));
+}
+
+#[no_mangle]
+pub extern "C" fn rustybuffer_xxh64_digest(state: *const XXH64State) -> u64 {
+    unsafe { &*state }.digest()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn crc32c_test() {
+        assert_eq!(crc32c(0, b"123456789"), 0xE306_9283);
+        assert_eq!(crc32c(0, b""), 0);
+
+        // Long enough for several of the SSE4.2 path's blocks of lanes.
+        let data: Vec<u8> = (0..50_000u32).map(|i| (i * 7) as u8).collect();
+        let whole = crc32c(0, &data);
+        assert_eq!(crc32c(crc32c(0, &data[..333]), &data[333..]), whole);
+        assert_eq!(!crc32c_table(!0, &data), whole);
+    }
+
+    fn xxh64(data: &[u8], seed: u64) -> u64 {
+        let mut state = XXH64State {
+            total_len: 0,
+            seed: 0,
+            v: [0; 4],
+            mem: [0; 32],
+            mem_size: 0,
+        };
+        state.reset(seed);
+        state.update(data);
+        state.digest()
+    }
+
+    #[test]
+    fn xxh64_test() {
+        assert_eq!(xxh64(b"", 0), 0xEF46_DB37_51D8_E999);
+        assert_eq!(xxh64(b"a", 0), 0xD24E_C4F1_A98C_6E5B);
+        assert_eq!(xxh64(b"abc", 0), 0x44BC_2CF5_AD77_0999);
+        assert_eq!(
+            xxh64(b"Nobody inspects the spammish repetition", 0),
+            0xFBCE_A83C_8A37_8BF1
+        );
+    }
+}
@@ -0,0 +1,606 @@
+//! LZ4 frame compression between caller buffers, compatible with the lz4
+//! command line tool. Like the checksums this doesn't touch the buffer
+//! cache, so it takes no lock.
+
+use crate::{fail, RBError, Result};
+
+const LZ4_MAGIC: u32 = 0x184D_2204;
+
+/// Blocks are compressed independently, up to 4MiB at a time.
+const LZ4_BLOCK_SIZE: usize = 4 * 1024 * 1024;
+
+/// Magic, FLG, BD, content size and header checksum.
+const LZ4_HEADER_SIZE: usize = 15;
+
+const FLG_VERSION: u8 = 0b0100_0000;
+const FLG_BLOCK_INDEPENDENT: u8 = 1 << 5;
+const FLG_BLOCK_CHECKSUM: u8 = 1 << 4;
+const FLG_CONTENT_SIZE: u8 = 1 << 3;
+const FLG_CONTENT_CHECKSUM: u8 = 1 << 2;
+const FLG_DICT_ID: u8 = 1 << 0;
+
+/// The high bit of a block's size marks it as stored uncompressed.
+const BLOCK_UNCOMPRESSED: u32 = 1 << 31;
+
+const MIN_MATCH: usize = 4;
+const MFLIMIT: usize = 12;
+const LAST_LITERALS: usize = 5;
+const HASH_LOG: u32 = 12;
+const MAX_OFFSET: usize = 65535;
+
+const XXH_PRIME32_1: u32 = 0x9E37_79B1;
+const XXH_PRIME32_2: u32 = 0x85EB_CA77;
+const XXH_PRIME32_3: u32 = 0xC2B2_AE3D;
+const XXH_PRIME32_4: u32 = 0x27D4_EB2F;
+const XXH_PRIME32_5: u32 = 0x1656_67B1;
+
+fn read_u32(data: &[u8], at: usize) -> u32 {
+    u32::from_le_bytes(data[at..at + 4].try_into().expect("4 bytes"))
+}
+
+/// xxHash32, which LZ4 frames use for their checksums.
+fn xxh32(data: &[u8], seed: u32) -> u32 {
+    let round = |acc: u32, lane: u32| {
+        acc.wrapping_add(lane.wrapping_mul(XXH_PRIME32_2))
+            .rotate_left(13)
+            .wrapping_mul(XXH_PRIME32_1)
+    };
+
+    let mut stripes = data.chunks_exact(16);
+    let mut hash = if data.len() >= 16 {
+        let mut v = [
+            seed.wrapping_add(XXH_PRIME32_1).wrapping_add(XXH_PRIME32_2),
+            seed.wrapping_add(XXH_PRIME32_2),
+            seed,
+            seed.wrapping_sub(XXH_PRIME32_1),
+        ];
+        for stripe in &mut stripes {
+            for (lane, acc) in v.iter_mut().enumerate() {
+                *acc = round(*acc, read_u32(stripe, lane * 4));
+            }
+        }
+        v[0].rotate_left(1)
+            .wrapping_add(v[1].rotate_left(7))
+            .wrapping_add(v[2].rotate_left(12))
+            .wrapping_add(v[3].rotate_left(18))
+    } else {
+        seed.wrapping_add(XXH_PRIME32_5)
+    };
+    hash = hash.wrapping_add(data.len() as u32);
+
+    let mut words = stripes.remainder().chunks_exact(4);
+    for word in &mut words {
+        hash = hash
+            .wrapping_add(read_u32(word, 0).wrapping_mul(XXH_PRIME32_3))
+            .rotate_left(17)
+            .wrapping_mul(XXH_PRIME32_4);
+    }
+    for &b in words.remainder() {
+        hash = hash
+            .wrapping_add((b as u32).wrapping_mul(XXH_PRIME32_5))
+            .rotate_left(11)
+            .wrapping_mul(XXH_PRIME32_1);
+    }
+
+    hash ^= hash >> 15;
+    hash = hash.wrapping_mul(XXH_PRIME32_2);
+    hash ^= hash >> 13;
+    hash = hash.wrapping_mul(XXH_PRIME32_3);
+    hash ^= hash >> 16;
+
+    hash
+}
+
+/// The most a frame of len bytes can compress to: every block can be
+/// stored as is, so it's the data plus the framing.
+pub fn lz4_bound(len: usize) -> Option<usize> {
+    let blocks = len.div_ceil(LZ4_BLOCK_SIZE);
+    len.checked_add(LZ4_HEADER_SIZE + 4 + 4)?
+        .checked_add(blocks.checked_mul(4)?)
+}
+
+/// Writes LZ4 sequences into a block, failing if it would need more than
+/// dst has room for.
+struct BlockWriter<'a> {
+    dst: &'a mut [u8],
+    pos: usize,
+}
+
+impl BlockWriter<'_> {
+    fn push(&mut self, byte: u8) -> Option<()> {
+        *self.dst.get_mut(self.pos)? = byte;
+        self.pos += 1;
+        Some(())
+    }
+
+    fn length(&mut self, mut len: usize) -> Option<()> {
+        while len >= 255 {
+            self.push(255)?;
+            len -= 255;
+        }
+        self.push(len as u8)
+    }
+
+    fn sequence(
+        &mut self,
+        literals: &[u8],
+        offset: usize,
+        match_len: usize,
+    ) -> Option<()> {
+        let lit_nibble = literals.len().min(15);
+        let match_nibble = if offset > 0 {
+            (match_len - MIN_MATCH).min(15)
+        } else {
+            0
+        };
+        self.push(((lit_nibble << 4) | match_nibble) as u8)?;
+        if literals.len() >= 15 {
+            self.length(literals.len() - 15)?;
+        }
+
+        let end = self.pos.checked_add(literals.len())?;
+        self.dst.get_mut(self.pos..end)?.copy_from_slice(literals);
+        self.pos = end;
+
+        if offset > 0 {
+            self.push(offset as u8)?;
+            self.push((offset >> 8) as u8)?;
+            if match_len - MIN_MATCH >= 15 {
+                self.length(match_len - MIN_MATCH - 15)?;
+            }
+        }
+        Some(())
+    }
+}
+
+/// Greedy LZ4 block compression with a single hash table of positions.
+/// Returns None if the block doesn't fit in dst.
+fn compress_block(src: &[u8], dst: &mut [u8]) -> Option<usize> {
+    let mut out = BlockWriter { dst, pos: 0 };
+    let mut table = vec![0u32; 1 << HASH_LOG];
+    let hash = |seq: u32| {
+        (seq.wrapping_mul(2_654_435_761) >> (32 - HASH_LOG)) as usize
+    };
+
+    let mut anchor = 0;
+    let mut pos = 0;
+    if src.len() > MFLIMIT {
+        let match_end = src.len() - LAST_LITERALS;
+        while pos < src.len() - MFLIMIT {
+            let seq = read_u32(src, pos);
+            let slot = &mut table[hash(seq)];
+            let candidate = *slot as usize;
+            *slot = pos as u32 + 1;
+
+            if candidate == 0
+                || pos - (candidate - 1) > MAX_OFFSET
+                || read_u32(src, candidate - 1) != seq
+            {
+                // Skip ahead faster the longer nothing has matched.
+                pos += 1 + ((pos - anchor) >> 6);
+                continue;
+            }
+
+            let mut start = pos;
+            let mut from = candidate - 1;
+            while start > anchor && from > 0 && src[start - 1] == src[from - 1]
+            {
+                start -= 1;
+                from -= 1;
+            }
+
+            let mut len = MIN_MATCH + (pos - start);
+            while start + len < match_end && src[from + len] == src[start + len]
+            {
+                len += 1;
+            }
+
+            out.sequence(&src[anchor..start], start - from, len)?;
+            pos = start + len;
+            anchor = pos;
+        }
+    }
+
+    out.sequence(&src[anchor..], 0, 0)?;
+    Some(out.pos)
+}
+
+/// Compress src into dst as a single LZ4 frame and return its length.
+pub fn lz4_compress(src: &[u8], dst: &mut [u8]) -> Result<usize> {
+    let Some(bound) = lz4_bound(src.len()) else {
+        return fail(
+            RBError::SizeTooBig,
+            format_args!("{} bytes is too much to compress", src.len()),
+        );
+    };
+    if dst.len() < bound {
+        return fail(
+            RBError::SizeTooBig,
+            format_args!(
+                "compressing {} bytes needs {} bytes of space, not {}",
+                src.len(),
+                bound,
+                dst.len()
+            ),
+        );
+    }
+
+    dst[0..4].copy_from_slice(&LZ4_MAGIC.to_le_bytes());
+    dst[4] = FLG_VERSION
+        | FLG_BLOCK_INDEPENDENT
+        | FLG_CONTENT_SIZE
+        | FLG_CONTENT_CHECKSUM;
+    dst[5] = 7 << 4; // 4MiB blocks
+    dst[6..14].copy_from_slice(&(src.len() as u64).to_le_bytes());
+    dst[14] = (xxh32(&dst[4..14], 0) >> 8) as u8;
+
+    let mut pos = LZ4_HEADER_SIZE;
+    for block in src.chunks(LZ4_BLOCK_SIZE) {
+        // Blocks that don't get any smaller are stored as they are.
+        let data = &mut dst[pos + 4..pos + 4 + block.len()];
+        let size = match compress_block(block, data) {
+            Some(len) if len < block.len() => len as u32,
+            _ => {
+                data.copy_from_slice(block);
+                block.len() as u32 | BLOCK_UNCOMPRESSED
+            }
+        };
+        dst[pos..pos + 4].copy_from_slice(&size.to_le_bytes());
+        pos += 4 + (size & !BLOCK_UNCOMPRESSED) as usize;
+    }
+
+    dst[pos..pos + 4].copy_from_slice(&0u32.to_le_bytes());
+    dst[pos + 4..pos + 8].copy_from_slice(&xxh32(src, 0).to_le_bytes());
+
+    Ok(pos + 8)
+}
+
+fn corrupt<T>(why: &str) -> Result<T> {
+    fail(
+        RBError::InvalidData,
+        format_args!("invalid LZ4 frame: {}", why),
+    )
+}
+
+struct FrameHeader {
+    flags: u8,
+    block_size: usize,
+    content_size: Option<u64>,
+    len: usize,
+}
+
+fn frame_header(src: &[u8]) -> Result<FrameHeader> {
+    if src.len() < 7 || read_u32(src, 0) != LZ4_MAGIC {
+        return corrupt("bad magic number");
+    }
+
+    let flags = src[4];
+    if flags & 0b1100_0010 != FLG_VERSION || src[5] & 0b1000_1111 != 0 {
+        return corrupt("unsupported version or reserved bits set");
+    }
+    if flags & FLG_DICT_ID != 0 {
+        return corrupt("dictionaries are not supported");
+    }
+    let block_size = match src[5] >> 4 {
+        4 => 64 * 1024,
+        5 => 256 * 1024,
+        6 => 1024 * 1024,
+        7 => 4 * 1024 * 1024,
+        _ => return corrupt("bad block size"),
+    };
+
+    let mut len = 6;
+    let mut content_size = None;
+    if flags & FLG_CONTENT_SIZE != 0 {
+        if src.len() < len + 9 {
+            return corrupt("truncated header");
+        }
+        let size =
+            u64::from_le_bytes(src[len..len + 8].try_into().expect("8 bytes"));
+        content_size = Some(size);
+        len += 8;
+    }
+
+    if (xxh32(&src[4..len], 0) >> 8) as u8 != src[len] {
+        return corrupt("header checksum mismatch");
+    }
+
+    Ok(FrameHeader {
+        flags,
+        block_size,
+        content_size,
+        len: len + 1,
+    })
+}
+
+/// The decompressed size recorded in an LZ4 frame's header.
+pub fn lz4_content_size(src: &[u8]) -> Result<u64> {
+    match frame_header(src)?.content_size {
+        Some(size) => Ok(size),
+        None => corrupt("the frame doesn't record its content size"),
+    }
+}
+
+/// Decompress one block into dst at pos, returning where it ends. Matches
+/// may reach back into earlier blocks, for frames with linked blocks.
+fn decompress_block(
+    src: &[u8],
+    dst: &mut [u8],
+    mut pos: usize,
+) -> Result<usize> {
+    let mut at = 0;
+    let read_length = |at: &mut usize, mut len: usize| -> Result<usize> {
+        loop {
+            let Some(&b) = src.get(*at) else {
+                return corrupt("truncated length");
+            };
+            *at += 1;
+            len += b as usize;
+            if b != 255 {
+                return Ok(len);
+            }
+        }
+    };
+
+    loop {
+        let Some(&token) = src.get(at) else {
+            return corrupt("truncated block");
+        };
+        at += 1;
+
+        let mut lit_len = (token >> 4) as usize;
+        if lit_len == 15 {
+            lit_len = read_length(&mut at, lit_len)?;
+        }
+        let (Some(lit_end), Some(out_end)) =
+            (at.checked_add(lit_len), pos.checked_add(lit_len))
+        else {
+            return corrupt("literals overflow");
+        };
+        if lit_end > src.len() || out_end > dst.len() {
+            return corrupt("literals run past the end");
+        }
+        dst[pos..out_end].copy_from_slice(&src[at..lit_end]);
+        at = lit_end;
+        pos = out_end;
+
+        // The last sequence is only literals.
+        if at == src.len() {
+            return Ok(pos);
+        }
+
+        if at + 2 > src.len() {
+            return corrupt("truncated offset");
+        }
+        let offset = src[at] as usize | (src[at + 1] as usize) << 8;
+        at += 2;
+        if offset == 0 || offset > pos {
+            return corrupt("match offset out of range");
+        }
+
+        let mut match_len = (token & 15) as usize;
+        if match_len == 15 {
+            match_len = read_length(&mut at, match_len)?;
+        }
+        match_len += MIN_MATCH;
+        let Some(out_end) = pos.checked_add(match_len) else {
+            return corrupt("match overflow");
+        };
+        if out_end > dst.len() {
+            return corrupt("match runs past the end");
+        }
+
+        let from = pos - offset;
+        if offset >= match_len {
+            dst.copy_within(from..from + match_len, pos);
+        } else {
+            // Overlapping matches repeat the last offset bytes.
+            for idx in 0..match_len {
+                dst[pos + idx] = dst[from + idx];
+            }
+        }
+        pos = out_end;
+    }
+}
+
+/// Decompress a single LZ4 frame from src into dst, returning the
+/// decompressed length.
+pub fn lz4_decompress(src: &[u8], dst: &mut [u8]) -> Result<usize> {
+    let header = frame_header(src)?;
+    let mut at = header.len;
+    let mut pos = 0;
+
+    loop {
+        if at + 4 > src.len() {
+            return corrupt("truncated block size");
+        }
+        let size = read_u32(src, at);
+        at += 4;
+        if size == 0 {
+            break;
+        }
+
+        let len = (size & !BLOCK_UNCOMPRESSED) as usize;
+        if len > header.block_size || at + len > src.len() {
+            return corrupt("block runs past the end");
+        }
+        let block = &src[at..at + len];
+        at += len;
+
+        if size & BLOCK_UNCOMPRESSED != 0 {
+            if pos + len > dst.len() {
+                return corrupt("block runs past the end of the output");
+            }
+            dst[pos..pos + len].copy_from_slice(block);
+            pos += len;
+        } else {
+            pos = decompress_block(block, dst, pos)?;
+        }
+
+        if header.flags & FLG_BLOCK_CHECKSUM != 0 {
+            if at + 4 > src.len() || read_u32(src, at) != xxh32(block, 0) {
+                return corrupt("block checksum mismatch");
+            }
+            at += 4;
+        }
+    }
+
+    if header.flags & FLG_CONTENT_CHECKSUM != 0 {
+        if at + 4 > src.len() || read_u32(src, at) != xxh32(&dst[..pos], 0) {
+            return corrupt("content checksum mismatch");
+        }
+        at += 4;
+    }
+    if at != src.len() {
+        return corrupt("trailing data after the frame");
+    }
+    if header.content_size.is_some_and(|size| size != pos as u64) {
+        return corrupt("content size mismatch");
+    }
+
+    Ok(pos)
+}
+
+fn as_slice<'a>(data: *const std::ffi::c_uchar, len: u64) -> &'a [u8] {
+    if data.is_null() || len == 0 {
+        &[]
+    } else {
+        unsafe { std::slice::from_raw_parts(data, len as usize) }
+    }
+}
+
+fn as_mut_slice<'a>(data: *mut std::ffi::c_uchar, len: u64) -> &'a mut [u8] {
+    if data.is_null() || len == 0 {
+        &mut []
+    } else {
+        unsafe { std::slice::from_raw_parts_mut(data, len as usize) }
+    }
+}
+
+/// The most compressing len bytes can take, or 0 if that's more than the
+/// address space.
+#[no_mangle]
+pub extern "C" fn rustybuffer_lz4_bound(len: u64) -> u64 {
+    usize::try_from(len)
+        .ok()
+        .and_then(lz4_bound)
+        .map_or(0, |bound| bound as u64)
+}
+
+#[no_mangle]
+pub extern "C" fn rustybuffer_lz4_compress(
+    src: *const std::ffi::c_uchar,
+    src_len: u64,
+    dst: *mut std::ffi::c_uchar,
+    dst_len: u64,
+    out_len: *mut u64,
+) -> std::ffi::c_uchar {
+    let res = lz4_compress(as_slice(src, src_len), as_mut_slice(dst, dst_len));
+    crate::handle_result(res.map(|len| unsafe { *out_len = len as u64 }))
+}
+
+#[no_mangle]
+pub extern "C" fn rustybuffer_lz4_content_size(
+    src: *const std::ffi::c_uchar,
+    src_len: u64,
+    size: *mut u64,
+) -> std::ffi::c_uchar {
+    let res = lz4_content_size(as_slice(src, src_len));
+    crate::handle_result(res.map(|len| unsafe { *size = len }))
+}
+
+#[no_mangle]
+pub extern "C" fn rustybuffer_lz4_decompress(
+    src: *const std::ffi::c_uchar,
+    src_len: u64,
+    dst: *mut std::ffi::c_uchar,
+    dst_len: u64,
+    out_len: *mut u64,
+) -> std::ffi::c_uchar {
+    let res =
+        lz4_decompress(as_slice(src, src_len), as_mut_slice(dst, dst_len));
+    crate::handle_result(res.map(|len| unsafe { *out_len = len as u64 }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn xxh32_test() {
+        assert_eq!(xxh32(b"", 0), 0x02CC_5D05);
+    }
+
+    #[test]
+    fn empty_frame_test() {
+        // What `lz4 -c --content-size /dev/null` writes, give or take the
+        // content size flag.
+        let mut dst = vec![0; lz4_bound(0).unwrap()];
+        let len = lz4_compress(b"", &mut dst).unwrap();
+        assert_eq!(read_u32(&dst, 0), LZ4_MAGIC);
+        assert_eq!(&dst[len - 8..len], &[0, 0, 0, 0, 0x05, 0x5D, 0xCC, 0x02]);
+        assert_eq!(lz4_decompress(&dst[..len], &mut []).unwrap(), 0);
+
+        // The lz4 tool's own output for an empty file, without a content
+        // size.
+        let frame = [
+            0x04, 0x22, 0x4D, 0x18, 0x64, 0x40, 0xA7, 0, 0, 0, 0, 0x05, 0x5D,
+            0xCC, 0x02,
+        ];
+        assert_eq!(lz4_decompress(&frame, &mut []).unwrap(), 0);
+    }
+
+    fn round_trip(src: &[u8]) -> usize {
+        let mut dst = vec![0; lz4_bound(src.len()).unwrap()];
+        let len = lz4_compress(src, &mut dst).unwrap();
+        assert_eq!(lz4_content_size(&dst[..len]).unwrap(), src.len() as u64);
+
+        let mut out = vec![0; src.len()];
+        assert_eq!(lz4_decompress(&dst[..len], &mut out).unwrap(), src.len());
+        assert!(out == src);
+        len
+    }
+
+    #[test]
+    fn round_trip_test() {
+        round_trip(b"hello");
+        round_trip(&[b'a'; 100]);
+
+        let text = b"the quick brown fox jumps over the lazy dog ".repeat(1000);
+        assert!(round_trip(&text) < text.len() / 10);
+
+        // Incompressible data is stored.
+        let mut state = 1u32;
+        let noise: Vec<u8> = (0..100_000)
+            .map(|_| {
+                state ^= state << 13;
+                state ^= state >> 17;
+                state ^= state << 5;
+                state as u8
+            })
+            .collect();
+        assert!(round_trip(&noise) <= lz4_bound(noise.len()).unwrap());
+
+        // More than one block.
+        let big: Vec<u8> = (0..LZ4_BLOCK_SIZE * 2 + 100)
+            .map(|i| (i / 100) as u8)
+            .collect();
+        round_trip(&big);
+    }
+
+    #[test]
+    fn corrupt_test() {
+        let text = b"the quick brown fox jumps over the lazy dog ".repeat(10);
+        let mut dst = vec![0; lz4_bound(text.len()).unwrap()];
+        let len = lz4_compress(&text, &mut dst).unwrap();
+        let mut out = vec![0; text.len()];
+
+        for idx in 0..len {
+            let mut frame = dst[..len].to_vec();
+            frame[idx] ^= 0x55;
+            assert!(lz4_decompress(&frame, &mut out).is_err());
+        }
+        assert!(lz4_decompress(&dst[..len - 1], &mut out).is_err());
+        assert!(lz4_decompress(&dst[..len], &mut out[..10]).is_err());
+    }
+}
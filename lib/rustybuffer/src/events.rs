@@ -0,0 +1,53 @@
+//! Events the library reports as they happen, including from threads of
+//! its own, to a callback the caller registers.
+
+use std::sync::atomic::{AtomicUsize, Ordering};
+
+/// Cached buffers were freed, value is how many bytes.
+pub const EVENT_TRIMMED: u32 = 1;
+
+/// An acquire failed for want of room, value is how many bytes were asked
+/// for.
+pub const EVENT_PRESSURE: u32 = 2;
+
+/// Something that was never acquired, or was already released, was
+/// released, i.e., the caller's bookkeeping is corrupt. value is the
+/// address.
+pub const EVENT_INVALID_RELEASE: u32 = 3;
+
+pub type EventCallback = extern "C" fn(kind: u32, value: u64);
+
+/// The registered callback as an address, zero if there isn't one.
+static CALLBACK: AtomicUsize = AtomicUsize::new(0);
+
+/// Call the registered callback, if there is one. Never called with the
+/// buffer cache locked, so the callback can call back into the library.
+pub fn emit(kind: u32, value: u64) {
+    let callback = CALLBACK.load(Ordering::Acquire);
+    if callback != 0 {
+        let callback: EventCallback = unsafe { std::mem::transmute(callback) };
+        callback(kind, value);
+    }
+}
+
+/// Register callback for every event from now on, replacing any earlier
+/// one, or stop reporting events with NULL. It may be called on any
+/// thread, including ones the caller didn't create, so must not block.
+#[no_mangle]
+pub extern "C" fn rustybuffer_set_event_callback(
+    callback: Option<EventCallback>,
+) {
+    CALLBACK.store(
+        callback.map_or(0, |callback| callback as usize),
+        Ordering::Release,
+    );
+}
+
+/// Free every cached buffer not currently handed out on a thread of the
+/// library's own, reporting EVENT_TRIMMED once it's done.
+#[no_mangle]
+pub extern "C" fn rustybuffer_trim_async() {
+    std::thread::spawn(|| {
+        crate::rustybuffer_trim();
+    });
+}
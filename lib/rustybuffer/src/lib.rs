@@ -1,14 +1,27 @@
+use std::alloc::{self, Layout};
+use std::cell::RefCell;
 use std::collections::{BTreeSet, HashMap};
 use std::fmt;
 use std::sync::{Arc, Mutex};
 
 use lazy_static::lazy_static;
 
+mod checksum;
+mod compress;
+mod events;
+mod random;
+mod transform;
+mod writev;
+
 lazy_static! {
     static ref RUSTY_BUFFERS: Arc<Mutex<RustyBuffers>> =
         Arc::new(Mutex::new(RustyBuffers::new()));
 }
 
+thread_local! {
+    static LAST_ERROR: RefCell<String> = const { RefCell::new(String::new()) };
+}
+
 type Result<T> = core::result::Result<T, RBError>;
 
 #[derive(Debug)]
@@ -17,6 +30,8 @@ enum RBError {
     NoBufferAvailable = 1,
     SizeTooBig = 2,
     InvalidPointer = 3,
+    AllocationFailed = 4,
+    InvalidData = 5,
 }
 
 impl fmt::Display for RBError {
@@ -25,23 +40,70 @@ impl fmt::Display for RBError {
             Self::NoBufferAvailable => "No Buffer Available",
             Self::SizeTooBig => "Size Too Big",
             Self::InvalidPointer => "Invalid Pointer",
+            Self::AllocationFailed => "Allocation Failed",
+            Self::InvalidData => "Invalid Data",
         };
         write!(f, "{}", as_str)
     }
 }
 
+/// Record a description of why we're failing for rustybuffer_last_error,
+/// and fail.
+fn fail<T>(err: RBError, details: fmt::Arguments) -> Result<T> {
+    LAST_ERROR.with(|last| {
+        *last.borrow_mut() = format!("{}: {}", err, details);
+    });
+    Err(err)
+}
+
 struct RBEntry {
     data: Box<[u8]>,
 }
 
 impl RBEntry {
-    fn new(size: usize) -> Self {
-        Self {
-            data: vec![0; size].into_boxed_slice(),
+    fn new(size: usize) -> Result<Self> {
+        if size == 0 {
+            return Ok(Self {
+                data: Vec::new().into_boxed_slice(),
+            });
         }
+
+        // This is vec![0; size] except that running out of memory is an
+        // error rather than an abort. alloc_zeroed keeps the calloc fast
+        // path so large buffers aren't written to up front.
+        let Ok(layout) = Layout::array::<u8>(size) else {
+            return fail(
+                RBError::SizeTooBig,
+                format_args!("{} bytes is not a valid allocation size", size),
+            );
+        };
+
+        let ptr = unsafe { alloc::alloc_zeroed(layout) };
+        if ptr.is_null() {
+            return fail(
+                RBError::AllocationFailed,
+                format_args!("the system allocator refused {} bytes", size),
+            );
+        }
+
+        let data = std::ptr::slice_from_raw_parts_mut(ptr, size);
+        Ok(Self {
+            data: unsafe { Box::from_raw(data) },
+        })
     }
 }
 
+/// Allocator statistics as reported to callers of rustybuffer_stats.
+#[repr(C)]
+pub struct RBStats {
+    max_total_size: u64,
+    max_buffer_size: u64,
+    bytes_allocated: u64,
+    bytes_in_use: u64,
+    num_buffers: u64,
+    num_available: u64,
+}
+
 struct RustyBuffers {
     buffers: HashMap<u64, RBEntry>,
     available: BTreeSet<(usize, u64)>,
@@ -83,7 +145,13 @@ impl RustyBuffers {
         //println!("[Rust]: Acquiring Minimum Bytes: {}", size);
 
         if size > self.max_buffer_size {
-            return Err(RBError::SizeTooBig);
+            return fail(
+                RBError::SizeTooBig,
+                format_args!(
+                    "requested {} bytes but max_buffer_size is {}",
+                    size, self.max_buffer_size
+                ),
+            );
         }
 
         // First the easy case when we have an existing buffer that can handle
@@ -106,7 +174,7 @@ impl RustyBuffers {
 
         // Next, see if we can allocate a new buffer for this request.
         if self.can_allocate(size) {
-            let buffer = RBEntry::new(size);
+            let buffer = RBEntry::new(size)?;
             let ret = buffer.data.as_ptr() as *mut std::ffi::c_uchar;
             let buff_id = ret as u64;
 
@@ -122,7 +190,13 @@ impl RustyBuffers {
             return Ok(ret);
         }
 
-        Err(RBError::NoBufferAvailable)
+        fail(
+            RBError::NoBufferAvailable,
+            format_args!(
+                "requested {} bytes with {} of max_total_size {} in use",
+                size, self.bytes_in_use, self.max_total_size
+            ),
+        )
     }
 
     /// Check if its possible to allocate a buffer of the given size. If
@@ -130,16 +204,22 @@ impl RustyBuffers {
     /// keep us under the total maximum bytes, they will be freed by this
     /// method.
     fn can_allocate(&mut self, size: usize) -> bool {
-        if self.bytes_allocated + size <= self.max_total_size {
+        // Sizes are limited by max_buffer_size but that can be anything, so
+        // the additions here need to be checked.
+        let Some(total) = self.bytes_allocated.checked_add(size) else {
+            return false;
+        };
+
+        if total <= self.max_total_size {
             return true;
         }
 
         let can_free = self.bytes_allocated - self.bytes_in_use;
-        if self.bytes_allocated - can_free + size > self.max_total_size {
+        if self.bytes_in_use.saturating_add(size) > self.max_total_size {
             return false;
         }
 
-        let free_at_least = size - (self.max_total_size - self.bytes_allocated);
+        let free_at_least = total - self.max_total_size;
         assert!(free_at_least <= can_free);
         assert!(!self.available.is_empty());
 
@@ -179,13 +259,63 @@ impl RustyBuffers {
         true
     }
 
+    fn stats(&self) -> RBStats {
+        RBStats {
+            max_total_size: self.max_total_size as u64,
+            max_buffer_size: self.max_buffer_size as u64,
+            bytes_allocated: self.bytes_allocated as u64,
+            bytes_in_use: self.bytes_in_use as u64,
+            num_buffers: self.buffers.len() as u64,
+            num_available: self.available.len() as u64,
+        }
+    }
+
+    /// Free every available buffer, returning how many bytes that gave
+    /// back.
+    fn trim(&mut self) -> usize {
+        let mut bytes_freed = 0;
+        while let Some((buff_size, buff_id)) = self.available.pop_first() {
+            self.buffers
+                .remove(&buff_id)
+                .expect("Unknown buffer id in available.");
+            bytes_freed += buff_size;
+        }
+
+        self.bytes_allocated -= bytes_freed;
+        assert!(self.bytes_in_use == self.bytes_allocated);
+
+        bytes_freed
+    }
+
+    /// The addresses of the buffers currently handed out.
+    fn live_handles(&self) -> Vec<u64> {
+        self.buffers
+            .iter()
+            .filter(|(id, buffer)| {
+                !self.available.contains(&(buffer.data.len(), **id))
+            })
+            .map(|(id, _)| *id)
+            .collect()
+    }
+
+    /// The sizes of the buffers cached for reuse, smallest first.
+    fn cached_sizes(&self) -> Vec<u64> {
+        self.available
+            .iter()
+            .map(|(size, _)| *size as u64)
+            .collect()
+    }
+
     fn release(&mut self, data: *mut std::ffi::c_uchar) -> Result<()> {
         //println!("[Rust]: Released: {:p}", data);
         let buff_id = data as u64;
 
         let buffer = self.buffers.get(&buff_id);
         if buffer.is_none() {
-            return Err(RBError::InvalidPointer);
+            return fail(
+                RBError::InvalidPointer,
+                format_args!("{:p} was not acquired from rustybuffer", data),
+            );
         }
 
         let buff_size = buffer.unwrap().data.len();
@@ -201,7 +331,12 @@ fn rustybuffer_config_impl(
     max_buffer_size: std::ffi::c_ulonglong,
 ) -> Result<()> {
     let mut rb = RUSTY_BUFFERS.lock().unwrap();
-    rb.configure(max_total_size as usize, max_buffer_size as usize)?;
+    // Limits beyond the address space (e.g., 8GiB on a 32-bit platform) are
+    // effectively unlimited.
+    rb.configure(
+        usize::try_from(max_total_size).unwrap_or(usize::MAX),
+        usize::try_from(max_buffer_size).unwrap_or(usize::MAX),
+    )?;
     Ok(())
 }
 
@@ -210,7 +345,13 @@ fn rustybuffer_acquire_impl(
     data: *mut *mut std::ffi::c_uchar,
 ) -> Result<()> {
     let mut rb = RUSTY_BUFFERS.lock().expect("Mutex was poisoned.");
-    let res = rb.acquire(size as usize)?;
+    let Ok(size) = usize::try_from(size) else {
+        return fail(
+            RBError::SizeTooBig,
+            format_args!("{} bytes exceeds the address space", size),
+        );
+    };
+    let res = rb.acquire(size)?;
     unsafe {
         *data = res;
     }
@@ -224,6 +365,69 @@ fn rustybuffer_release_impl(data: *mut std::ffi::c_uchar) -> Result<()> {
     Ok(())
 }
 
+fn rustybuffer_stats_impl(stats: *mut RBStats) -> Result<()> {
+    let rb = RUSTY_BUFFERS.lock().expect("Mutex was poisoned.");
+    unsafe {
+        *stats = rb.stats();
+    }
+
+    Ok(())
+}
+
+/// Feature bits reported by rustybuffer_capabilities. These must match the
+/// Capability constants in the Go bindings.
+const CAPABILITY_STATS: u64 = 1 << 0;
+const CAPABILITY_LIVE_HANDLES: u64 = 1 << 3;
+const CAPABILITY_FILL: u64 = 1 << 4;
+const CAPABILITY_COMPARE: u64 = 1 << 5;
+const CAPABILITY_CONSTANT_TIME_EQUAL: u64 = 1 << 6;
+const CAPABILITY_CHECKSUM: u64 = 1 << 7;
+const CAPABILITY_LZ4: u64 = 1 << 8;
+const CAPABILITY_COPY: u64 = 1 << 9;
+const CAPABILITY_TRIM: u64 = 1 << 10;
+const CAPABILITY_TRANSFORM: u64 = 1 << 11;
+const CAPABILITY_EVENTS: u64 = 1 << 12;
+const CAPABILITY_FILL_RANDOM: u64 = 1 << 13;
+const CAPABILITY_CACHED_SIZES: u64 = 1 << 14;
+const CAPABILITY_WRITEV_CHECKSUM: u64 = 1 << 15;
+
+/// The optional features this build of the library supports.
+#[no_mangle]
+pub extern "C" fn rustybuffer_capabilities() -> u64 {
+    CAPABILITY_STATS
+        | CAPABILITY_LIVE_HANDLES
+        | CAPABILITY_FILL
+        | CAPABILITY_COMPARE
+        | CAPABILITY_CONSTANT_TIME_EQUAL
+        | CAPABILITY_CHECKSUM
+        | CAPABILITY_LZ4
+        | CAPABILITY_COPY
+        | CAPABILITY_TRIM
+        | CAPABILITY_TRANSFORM
+        | CAPABILITY_EVENTS
+        | CAPABILITY_CACHED_SIZES
+        | if writev::SUPPORTED {
+            CAPABILITY_WRITEV_CHECKSUM
+        } else {
+            0
+        }
+        | if random::SUPPORTED {
+            CAPABILITY_FILL_RANDOM
+        } else {
+            0
+        }
+}
+
+/// The library version as (major << 16) | (minor << 8) | patch so that the
+/// Go bindings can refuse to run against an incompatible build.
+#[no_mangle]
+pub extern "C" fn rustybuffer_version() -> u32 {
+    let part = |s: &str| s.parse::<u32>().unwrap_or(0) & 0xFF;
+    (part(env!("CARGO_PKG_VERSION_MAJOR")) << 16)
+        | (part(env!("CARGO_PKG_VERSION_MINOR")) << 8)
+        | part(env!("CARGO_PKG_VERSION_PATCH"))
+}
+
 #[no_mangle]
 pub extern "C" fn rustybuffer_config(
     max_total_size: std::ffi::c_ulonglong,
@@ -237,14 +441,194 @@ pub extern "C" fn rustybuffer_acquire(
     size: std::ffi::c_ulonglong,
     data: *mut *mut std::ffi::c_uchar,
 ) -> std::ffi::c_uchar {
-    handle_result(rustybuffer_acquire_impl(size, data))
+    let res = rustybuffer_acquire_impl(size, data);
+    if matches!(res, Err(RBError::NoBufferAvailable)) {
+        events::emit(events::EVENT_PRESSURE, size);
+    }
+    handle_result(res)
 }
 
 #[no_mangle]
 pub extern "C" fn rustybuffer_release(
     data: *mut std::ffi::c_uchar,
 ) -> std::ffi::c_uchar {
-    handle_result(rustybuffer_release_impl(data))
+    let res = rustybuffer_release_impl(data);
+    if matches!(res, Err(RBError::InvalidPointer)) {
+        events::emit(events::EVENT_INVALID_RELEASE, data as u64);
+    }
+    handle_result(res)
+}
+
+#[no_mangle]
+pub extern "C" fn rustybuffer_stats(stats: *mut RBStats) -> std::ffi::c_uchar {
+    handle_result(rustybuffer_stats_impl(stats))
+}
+
+/// Copy the addresses of up to len buffers currently handed out into
+/// handles. Returns how many there are in total, so a return larger than
+/// len means the caller needs a bigger array.
+#[no_mangle]
+pub extern "C" fn rustybuffer_live_handles(handles: *mut u64, len: u64) -> u64 {
+    let rb = RUSTY_BUFFERS.lock().expect("Mutex was poisoned.");
+    let live = rb.live_handles();
+    if !handles.is_null() {
+        let count = live.len().min(len as usize);
+        unsafe {
+            std::ptr::copy_nonoverlapping(live.as_ptr(), handles, count);
+        }
+    }
+    live.len() as u64
+}
+
+/// Copy the sizes of up to len of the buffers cached for reuse into sizes,
+/// smallest first. Returns how many there are in total, like
+/// rustybuffer_live_handles.
+#[no_mangle]
+pub extern "C" fn rustybuffer_cached_sizes(sizes: *mut u64, len: u64) -> u64 {
+    let rb = RUSTY_BUFFERS.lock().expect("Mutex was poisoned.");
+    let cached = rb.cached_sizes();
+    if !sizes.is_null() {
+        let count = cached.len().min(len as usize);
+        unsafe {
+            std::ptr::copy_nonoverlapping(cached.as_ptr(), sizes, count);
+        }
+    }
+    cached.len() as u64
+}
+
+/// Free every cached buffer not currently handed out. Returns how many
+/// bytes were freed.
+#[no_mangle]
+pub extern "C" fn rustybuffer_trim() -> u64 {
+    let bytes_freed = {
+        let mut rb = RUSTY_BUFFERS.lock().expect("Mutex was poisoned.");
+        rb.trim() as u64
+    };
+    events::emit(events::EVENT_TRIMMED, bytes_freed);
+    bytes_freed
+}
+
+/// Set len bytes starting at data to value. This doesn't touch the buffer
+/// cache, so it takes no lock and data can be any writable memory, not just
+/// a buffer from rustybuffer_acquire.
+#[no_mangle]
+pub extern "C" fn rustybuffer_fill(
+    data: *mut std::ffi::c_uchar,
+    len: u64,
+    value: std::ffi::c_uchar,
+) {
+    if data.is_null() || len == 0 {
+        return;
+    }
+    let data = unsafe { std::slice::from_raw_parts_mut(data, len as usize) };
+    data.fill(value);
+}
+
+/// One span for rustybuffer_copy to move.
+#[repr(C)]
+pub struct RBCopySegment {
+    dst: u64,
+    src: u64,
+    len: u64,
+}
+
+/// Copy every segment in turn, each as a memmove so a segment's source and
+/// destination can overlap. The caller orders the segments so that
+/// overlapping ones don't clobber each other. Like rustybuffer_fill this
+/// takes no lock.
+#[no_mangle]
+pub extern "C" fn rustybuffer_copy(segments: *const RBCopySegment, count: u64) {
+    if segments.is_null() || count == 0 {
+        return;
+    }
+    let segments =
+        unsafe { std::slice::from_raw_parts(segments, count as usize) };
+    for seg in segments {
+        if seg.len == 0 {
+            continue;
+        }
+        unsafe {
+            std::ptr::copy(
+                seg.src as *const u8,
+                seg.dst as *mut u8,
+                seg.len as usize,
+            );
+        }
+    }
+}
+
+/// Compare a_len bytes at a with b_len bytes at b lexicographically,
+/// returning -1, 0 or 1. Like rustybuffer_fill this takes no lock.
+#[no_mangle]
+pub extern "C" fn rustybuffer_compare(
+    a: *const std::ffi::c_uchar,
+    a_len: u64,
+    b: *const std::ffi::c_uchar,
+    b_len: u64,
+) -> i32 {
+    let as_slice = |data: *const std::ffi::c_uchar, len: u64| {
+        if data.is_null() || len == 0 {
+            &[][..]
+        } else {
+            unsafe { std::slice::from_raw_parts(data, len as usize) }
+        }
+    };
+    match as_slice(a, a_len).cmp(as_slice(b, b_len)) {
+        std::cmp::Ordering::Less => -1,
+        std::cmp::Ordering::Equal => 0,
+        std::cmp::Ordering::Greater => 1,
+    }
+}
+
+/// Return 1 if the len bytes at a and b are equal and 0 otherwise, taking
+/// the same time whatever they hold. Every byte is visited and differences
+/// are accumulated without branching on them, black_box keeps the compiler
+/// from turning that back into an early exit.
+#[no_mangle]
+pub extern "C" fn rustybuffer_constant_time_equal(
+    a: *const std::ffi::c_uchar,
+    b: *const std::ffi::c_uchar,
+    len: u64,
+) -> std::ffi::c_uchar {
+    if len == 0 {
+        return 1;
+    }
+    let a = unsafe { std::slice::from_raw_parts(a, len as usize) };
+    let b = unsafe { std::slice::from_raw_parts(b, len as usize) };
+
+    let mut diff: u8 = 0;
+    for (x, y) in a.iter().zip(b.iter()) {
+        diff = std::hint::black_box(diff | (x ^ y));
+    }
+
+    // 1 when diff is zero, without a comparison: (diff - 1) only borrows
+    // into the high byte when diff is zero.
+    (((diff as u32).wrapping_sub(1) >> 8) & 1) as std::ffi::c_uchar
+}
+
+/// Copy a description of the last error on the calling thread into buf as a
+/// NUL terminated string, truncated to fit in len bytes. Returns the length
+/// of the full description.
+#[no_mangle]
+pub extern "C" fn rustybuffer_last_error(
+    buf: *mut std::ffi::c_char,
+    len: u64,
+) -> u64 {
+    LAST_ERROR.with(|last| {
+        let last = last.borrow();
+        if !buf.is_null() && len > 0 {
+            let count = last.len().min(len as usize - 1);
+            unsafe {
+                std::ptr::copy_nonoverlapping(
+                    last.as_ptr(),
+                    buf as *mut u8,
+                    count,
+                );
+                *buf.add(count) = 0;
+            }
+        }
+        last.len() as u64
+    })
 }
 
 fn handle_result(res: Result<()>) -> std::ffi::c_uchar {
@@ -358,4 +742,50 @@ mod tests {
             total_releases,
         )
     }
+
+    #[test]
+    fn fill_test() {
+        let mut data = vec![0u8; 1000];
+        rustybuffer_fill(data.as_mut_ptr().wrapping_add(10), 980, 0xAB);
+        assert!(data[..10].iter().all(|&b| b == 0));
+        assert!(data[10..990].iter().all(|&b| b == 0xAB));
+        assert!(data[990..].iter().all(|&b| b == 0));
+
+        rustybuffer_fill(std::ptr::null_mut(), 10, 1);
+    }
+
+    #[test]
+    fn compare_test() {
+        let cmp = |a: &[u8], b: &[u8]| {
+            rustybuffer_compare(
+                a.as_ptr(),
+                a.len() as u64,
+                b.as_ptr(),
+                b.len() as u64,
+            )
+        };
+        assert_eq!(cmp(b"abc", b"abc"), 0);
+        assert_eq!(cmp(b"abc", b"abd"), -1);
+        assert_eq!(cmp(b"abc", b"ab"), 1);
+        assert_eq!(cmp(b"", b"a"), -1);
+        assert_eq!(
+            rustybuffer_compare(std::ptr::null(), 0, std::ptr::null(), 0),
+            0
+        );
+    }
+
+    #[test]
+    fn constant_time_equal_test() {
+        let eq = |a: &[u8], b: &[u8]| {
+            rustybuffer_constant_time_equal(
+                a.as_ptr(),
+                b.as_ptr(),
+                a.len() as u64,
+            )
+        };
+        assert_eq!(eq(b"secret", b"secret"), 1);
+        assert_eq!(eq(b"secret", b"secreT"), 0);
+        assert_eq!(eq(b"\x00", b"\x80"), 0);
+        assert_eq!(eq(b"", b""), 1);
+    }
 }
@@ -0,0 +1,94 @@
+//! Random bytes from the OS's cryptographically secure generator, written
+//! straight into caller memory. Like fill this doesn't touch the buffer
+//! cache, so it takes no lock.
+
+use crate::{fail, RBError, Result};
+
+/// Whether this platform has a generator os_random knows how to use.
+pub const SUPPORTED: bool = cfg!(unix);
+
+#[cfg(any(target_os = "linux", target_os = "android"))]
+fn os_random(buf: &mut [u8]) -> std::io::Result<()> {
+    extern "C" {
+        fn getrandom(
+            buf: *mut std::ffi::c_void,
+            len: usize,
+            flags: u32,
+        ) -> isize;
+    }
+
+    let mut filled = 0;
+    while filled < buf.len() {
+        let rest = &mut buf[filled..];
+        let res = unsafe { getrandom(rest.as_mut_ptr().cast(), rest.len(), 0) };
+        if res < 0 {
+            let err = std::io::Error::last_os_error();
+            if err.kind() == std::io::ErrorKind::Interrupted {
+                continue;
+            }
+            return Err(err);
+        }
+        filled += res as usize;
+    }
+    Ok(())
+}
+
+#[cfg(all(unix, not(any(target_os = "linux", target_os = "android"))))]
+fn os_random(buf: &mut [u8]) -> std::io::Result<()> {
+    extern "C" {
+        fn getentropy(
+            buf: *mut std::ffi::c_void,
+            len: usize,
+        ) -> std::ffi::c_int;
+    }
+
+    // getentropy hands out at most 256 bytes at a time.
+    for chunk in buf.chunks_mut(256) {
+        if unsafe { getentropy(chunk.as_mut_ptr().cast(), chunk.len()) } != 0 {
+            return Err(std::io::Error::last_os_error());
+        }
+    }
+    Ok(())
+}
+
+#[cfg(not(unix))]
+fn os_random(_buf: &mut [u8]) -> std::io::Result<()> {
+    Err(std::io::ErrorKind::Unsupported.into())
+}
+
+fn fill_random(data: *mut u8, len: u64) -> Result<()> {
+    if data.is_null() || len == 0 {
+        return Ok(());
+    }
+    let buf = unsafe { std::slice::from_raw_parts_mut(data, len as usize) };
+    if let Err(err) = os_random(buf) {
+        return fail(
+            RBError::AllocationFailed,
+            format_args!("reading {} random bytes: {}", len, err),
+        );
+    }
+    Ok(())
+}
+
+/// Fill len bytes starting at data with random bytes from the OS, which
+/// are fit for keys and nonces. data can be any writable memory.
+#[no_mangle]
+pub extern "C" fn rustybuffer_fill_random(
+    data: *mut std::ffi::c_uchar,
+    len: u64,
+) -> std::ffi::c_uchar {
+    crate::handle_result(fill_random(data, len))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn fills_every_chunk() {
+        let mut buf = vec![0u8; 4096];
+        assert_eq!(rustybuffer_fill_random(buf.as_mut_ptr(), 4096), 0);
+        assert!(buf.chunks(256).all(|chunk| chunk.iter().any(|&b| b != 0)));
+        assert_eq!(rustybuffer_fill_random(std::ptr::null_mut(), 10), 0);
+    }
+}
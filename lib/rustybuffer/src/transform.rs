@@ -0,0 +1,204 @@
+//! Named transforms applied in place to caller memory, several stages in
+//! one call, so a large buffer doesn't make a round trip through the
+//! caller per stage. Like the checksums these don't touch the buffer
+//! cache, so they take no lock.
+
+use crate::checksum::{crc32c, XXH64State};
+use crate::{fail, RBError, Result};
+
+/// One span of the memory being transformed, which is treated as if the
+/// spans were one.
+#[repr(C)]
+pub struct RBSpan {
+    pub data: u64,
+    pub len: u64,
+}
+
+/// One stage of rustybuffer_transform: which transform, its argument, and
+/// where its result goes.
+#[repr(C)]
+pub struct RBTransformStep {
+    id: u64,
+    arg: u64,
+    result: u64,
+}
+
+type Transform = fn(spans: &mut [&mut [u8]], arg: u64) -> u64;
+
+/// The transforms, by id. Ids are only meaningful to the library that
+/// handed them out, callers look them up by name.
+const TRANSFORMS: &[(&str, Transform)] = &[
+    ("crc32c", transform_crc32c),
+    ("xxh64", transform_xxh64),
+    ("xor", transform_xor),
+    ("fill", transform_fill),
+];
+
+/// The CRC32C of the memory, which is left alone.
+fn transform_crc32c(spans: &mut [&mut [u8]], _arg: u64) -> u64 {
+    spans.iter().fold(0, |crc, span| crc32c(crc, span)) as u64
+}
+
+/// The xxHash64 of the memory, seeded with arg, which is left alone.
+fn transform_xxh64(spans: &mut [&mut [u8]], arg: u64) -> u64 {
+    let mut state = XXH64State::new(arg);
+    for span in spans.iter() {
+        state.update(span);
+    }
+    state.digest()
+}
+
+/// XOR the memory with the eight little endian bytes of arg, repeated,
+/// e.g., to mask or unmask a WebSocket payload with its key twice over.
+fn transform_xor(spans: &mut [&mut [u8]], arg: u64) -> u64 {
+    let key = arg.to_le_bytes();
+    let mut offset = 0;
+    for span in spans.iter_mut() {
+        for byte in span.iter_mut() {
+            *byte ^= key[offset % 8];
+            offset += 1;
+        }
+    }
+    0
+}
+
+/// Set every byte of the memory to the low byte of arg.
+fn transform_fill(spans: &mut [&mut [u8]], arg: u64) -> u64 {
+    for span in spans.iter_mut() {
+        span.fill(arg as u8);
+    }
+    0
+}
+
+fn transform(
+    spans: &mut [&mut [u8]],
+    steps: &mut [RBTransformStep],
+) -> Result<()> {
+    // Nothing is touched unless every stage can run.
+    if let Some(step) =
+        steps.iter().find(|step| step.id >= TRANSFORMS.len() as u64)
+    {
+        return fail(
+            RBError::InvalidData,
+            format_args!("there is no transform {}", step.id),
+        );
+    }
+
+    for step in steps.iter_mut() {
+        step.result = TRANSFORMS[step.id as usize].1(spans, step.arg);
+    }
+    Ok(())
+}
+
+/// Copy the name of the transform with id into buf as a NUL terminated
+/// string, truncated to fit in len bytes. Returns the length of the full
+/// name, or zero if there's no such transform, so callers can list them
+/// all by counting up from zero.
+#[no_mangle]
+pub extern "C" fn rustybuffer_transform_name(
+    id: u64,
+    buf: *mut std::ffi::c_char,
+    len: u64,
+) -> u64 {
+    let Some((name, _)) = TRANSFORMS.get(id as usize) else {
+        return 0;
+    };
+    if !buf.is_null() && len > 0 {
+        let count = name.len().min(len as usize - 1);
+        unsafe {
+            std::ptr::copy_nonoverlapping(name.as_ptr(), buf as *mut u8, count);
+            *buf.add(count) = 0;
+        }
+    }
+    name.len() as u64
+}
+
+/// Apply each of steps in turn to the memory in spans, storing each
+/// stage's result (a checksum, say) in the step.
+#[no_mangle]
+pub extern "C" fn rustybuffer_transform(
+    spans: *const RBSpan,
+    span_count: u64,
+    steps: *mut RBTransformStep,
+    step_count: u64,
+) -> std::ffi::c_uchar {
+    let spans = if spans.is_null() || span_count == 0 {
+        &[][..]
+    } else {
+        unsafe { std::slice::from_raw_parts(spans, span_count as usize) }
+    };
+    let steps = if steps.is_null() || step_count == 0 {
+        &mut [][..]
+    } else {
+        unsafe { std::slice::from_raw_parts_mut(steps, step_count as usize) }
+    };
+
+    let mut spans: Vec<&mut [u8]> = spans
+        .iter()
+        .filter(|span| span.data != 0 && span.len != 0)
+        .map(|span| unsafe {
+            std::slice::from_raw_parts_mut(
+                span.data as *mut u8,
+                span.len as usize,
+            )
+        })
+        .collect();
+    crate::handle_result(transform(&mut spans, steps))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn xor_twice_is_a_no_op() {
+        let mut first = *b"hello ";
+        let mut second = *b"world";
+        let mut spans: Vec<&mut [u8]> = vec![&mut first, &mut second];
+        let mut steps = [
+            RBTransformStep {
+                id: 2,
+                arg: 0x0102_0304_0506_0708,
+                result: 0,
+            },
+            RBTransformStep {
+                id: 0,
+                arg: 0,
+                result: 0,
+            },
+            RBTransformStep {
+                id: 2,
+                arg: 0x0102_0304_0506_0708,
+                result: 0,
+            },
+        ];
+        transform(&mut spans, &mut steps).unwrap();
+        assert_eq!(&first, b"hello ");
+        assert_eq!(&second, b"world");
+        // The checksum was taken while the data was masked.
+        assert_ne!(
+            steps[1].result,
+            crc32c(crc32c(0, b"hello "), b"world") as u64
+        );
+    }
+
+    #[test]
+    fn unknown_transforms_touch_nothing() {
+        let mut data = *b"data";
+        let mut spans: Vec<&mut [u8]> = vec![&mut data];
+        let mut steps = [
+            RBTransformStep {
+                id: 3,
+                arg: 0,
+                result: 0,
+            },
+            RBTransformStep {
+                id: 99,
+                arg: 0,
+                result: 0,
+            },
+        ];
+        assert!(transform(&mut spans, &mut steps).is_err());
+        assert_eq!(&data, b"data");
+    }
+}
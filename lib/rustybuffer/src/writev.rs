@@ -0,0 +1,283 @@
+//! Writing caller memory to a file descriptor and checksumming it in the
+//! same pass, so a multi-GB buffer is only read from memory once: each
+//! batch is checksummed just before it's written, while it's still in
+//! cache. Like the checksums this doesn't touch the buffer cache, so it
+//! takes no lock.
+
+use crate::checksum::{crc32c, XXH64State};
+use crate::transform::RBSpan;
+
+/// Whether this platform has writev.
+pub const SUPPORTED: bool = cfg!(unix);
+
+/// The checksums, numbered as the Go bindings' ChecksumAlgorithm.
+const ALGO_CRC32C: u32 = 1;
+const ALGO_XXH64: u32 = 2;
+
+/// Each writev is at most this many bytes, small enough that the batch
+/// checksummed before it is still in cache when it's written.
+const BATCH_BYTES: usize = 256 * 1024;
+
+/// And at most this many pieces, well under every platform's IOV_MAX.
+const BATCH_PIECES: usize = 64;
+
+const EINVAL: i32 = 22;
+
+#[cfg(unix)]
+#[repr(C)]
+struct IoVec {
+    base: *const std::ffi::c_void,
+    len: usize,
+}
+
+#[cfg(unix)]
+extern "C" {
+    fn writev(fd: i32, iov: *const IoVec, iovcnt: i32) -> isize;
+}
+
+enum Checksum {
+    CRC32C(u32),
+    XXH64(XXH64State),
+}
+
+impl Checksum {
+    fn update(&mut self, data: &[u8]) {
+        match self {
+            Checksum::CRC32C(crc) => *crc = crc32c(*crc, data),
+            Checksum::XXH64(state) => state.update(data),
+        }
+    }
+
+    fn digest(&self) -> u64 {
+        match self {
+            Checksum::CRC32C(crc) => *crc as u64,
+            Checksum::XXH64(state) => state.digest(),
+        }
+    }
+}
+
+/// Write every piece of batch to fd, however many writev calls it takes,
+/// adding what's written to written. Returns 0 or the errno it failed
+/// with.
+#[cfg(unix)]
+fn write_batch(fd: i32, batch: &mut [&[u8]], written: &mut u64) -> i32 {
+    let mut batch = &mut batch[..];
+    while !batch.is_empty() {
+        let iov: Vec<IoVec> = batch
+            .iter()
+            .map(|piece| IoVec {
+                base: piece.as_ptr().cast(),
+                len: piece.len(),
+            })
+            .collect();
+        let res = unsafe { writev(fd, iov.as_ptr(), iov.len() as i32) };
+        if res < 0 {
+            let err = std::io::Error::last_os_error();
+            if err.kind() == std::io::ErrorKind::Interrupted {
+                continue;
+            }
+            return err.raw_os_error().unwrap_or(EINVAL);
+        }
+
+        let mut res = res as usize;
+        *written += res as u64;
+        while res > 0 {
+            if res >= batch[0].len() {
+                res -= batch[0].len();
+                batch = &mut batch[1..];
+            } else {
+                batch[0] = &batch[0][res..];
+                res = 0;
+            }
+        }
+    }
+    0
+}
+
+#[cfg(not(unix))]
+fn write_batch(_fd: i32, _batch: &mut [&[u8]], _written: &mut u64) -> i32 {
+    EINVAL
+}
+
+fn writev_checksum(
+    fd: i32,
+    spans: &[RBSpan],
+    algo: u32,
+    written: &mut u64,
+    checksum: &mut u64,
+) -> i32 {
+    let mut sum = match algo {
+        ALGO_CRC32C => Checksum::CRC32C(0),
+        ALGO_XXH64 => Checksum::XXH64(XXH64State::new(0)),
+        _ => return EINVAL,
+    };
+
+    let mut batch: Vec<&[u8]> = Vec::with_capacity(BATCH_PIECES);
+    let mut batch_bytes = 0;
+    for span in spans.iter().filter(|span| span.data != 0 && span.len != 0) {
+        let data = unsafe {
+            std::slice::from_raw_parts(
+                span.data as *const u8,
+                span.len as usize,
+            )
+        };
+        let mut rest = data;
+        while !rest.is_empty() {
+            let (piece, after) =
+                rest.split_at(rest.len().min(BATCH_BYTES - batch_bytes));
+            rest = after;
+            sum.update(piece);
+            batch.push(piece);
+            batch_bytes += piece.len();
+            if batch_bytes == BATCH_BYTES || batch.len() == BATCH_PIECES {
+                let res = write_batch(fd, &mut batch, written);
+                if res != 0 {
+                    return res;
+                }
+                batch.clear();
+                batch_bytes = 0;
+            }
+        }
+    }
+    if !batch.is_empty() {
+        let res = write_batch(fd, &mut batch, written);
+        if res != 0 {
+            return res;
+        }
+    }
+
+    *checksum = sum.digest();
+    0
+}
+
+/// Write the memory in spans to fd, in order, as if the spans were one,
+/// computing algo (1 for CRC32C, 2 for xxHash64) over it as it goes. The
+/// bytes written are stored in written, whether or not it fails, and on
+/// success the checksum in checksum. Returns 0 or the errno it failed with,
+/// EINVAL for an unknown algorithm or a platform without writev.
+#[no_mangle]
+pub extern "C" fn rustybuffer_writev_checksum(
+    fd: i32,
+    spans: *const RBSpan,
+    span_count: u64,
+    algo: u32,
+    written: *mut u64,
+    checksum: *mut u64,
+) -> i32 {
+    let spans = if spans.is_null() || span_count == 0 {
+        &[][..]
+    } else {
+        unsafe { std::slice::from_raw_parts(spans, span_count as usize) }
+    };
+
+    let mut total = 0;
+    let mut sum = 0;
+    let res = writev_checksum(fd, spans, algo, &mut total, &mut sum);
+    if !written.is_null() {
+        unsafe { *written = total };
+    }
+    if res == 0 && !checksum.is_null() {
+        unsafe { *checksum = sum };
+    }
+    res
+}
+
+#[cfg(all(test, unix))]
+mod tests {
+    use super::*;
+    use std::io::{Read, Seek};
+    use std::os::unix::io::AsRawFd;
+
+    fn spans(buffers: &[Vec<u8>]) -> Vec<RBSpan> {
+        buffers
+            .iter()
+            .map(|buffer| RBSpan {
+                data: buffer.as_ptr() as u64,
+                len: buffer.len() as u64,
+            })
+            .collect()
+    }
+
+    #[test]
+    fn writes_and_checksums() {
+        // Enough to take several batches, with a piece split across two.
+        let buffers: Vec<Vec<u8>> = vec![
+            (0..300_000u32).map(|i| (i * 7) as u8).collect(),
+            Vec::new(),
+            (0..100_000u32).map(|i| (i * 13) as u8).collect(),
+        ];
+        let whole: Vec<u8> = buffers.concat();
+
+        for (algo, expected) in [
+            (ALGO_CRC32C, crc32c(0, &whole) as u64),
+            (ALGO_XXH64, {
+                let mut state = XXH64State::new(0);
+                state.update(&whole);
+                state.digest()
+            }),
+        ] {
+            let path = std::env::temp_dir().join(format!(
+                "rustybuffer-writev-{}-{}",
+                std::process::id(),
+                algo
+            ));
+            let mut file = std::fs::File::options()
+                .read(true)
+                .write(true)
+                .create(true)
+                .truncate(true)
+                .open(&path)
+                .unwrap();
+            std::fs::remove_file(&path).unwrap();
+
+            let spans = spans(&buffers);
+            let (mut written, mut checksum) = (0, 0);
+            let res = rustybuffer_writev_checksum(
+                file.as_raw_fd(),
+                spans.as_ptr(),
+                spans.len() as u64,
+                algo,
+                &mut written,
+                &mut checksum,
+            );
+            assert_eq!(res, 0);
+            assert_eq!(written, whole.len() as u64);
+            assert_eq!(checksum, expected);
+
+            let mut contents = Vec::new();
+            file.rewind().unwrap();
+            file.read_to_end(&mut contents).unwrap();
+            assert!(contents == whole);
+        }
+    }
+
+    #[test]
+    fn reports_errors() {
+        let buffers = vec![vec![1u8; 10]];
+        let spans = spans(&buffers);
+        let mut written = 1;
+        assert_eq!(
+            rustybuffer_writev_checksum(
+                -1,
+                spans.as_ptr(),
+                1,
+                ALGO_CRC32C,
+                &mut written,
+                std::ptr::null_mut()
+            ),
+            9 // EBADF
+        );
+        assert_eq!(written, 0);
+        assert_eq!(
+            rustybuffer_writev_checksum(
+                -1,
+                spans.as_ptr(),
+                1,
+                7,
+                std::ptr::null_mut(),
+                std::ptr::null_mut()
+            ),
+            EINVAL
+        );
+    }
+}
@@ -0,0 +1,42 @@
+#ifndef RUSTYBUFFER_POOL_H
+#define RUSTYBUFFER_POOL_H
+
+#include <stdint.h>
+
+// Pools a Go program has exported by name with rustybuffer.ExportPool, for
+// C code, and other languages through their FFI, running in the same
+// process. The functions return zero or one of these codes, the first
+// few shared with the library's.
+
+#define RBGO_NO_BUFFER_AVAILABLE 1
+#define RBGO_BUFFER_TOO_LARGE 2
+#define RBGO_INVALID_POINTER 3
+#define RBGO_ALLOCATION_FAILED 4
+#define RBGO_UNKNOWN_POOL 64
+#define RBGO_FAILED 65
+
+typedef struct {
+    uint64_t max_total_size;
+    uint64_t max_buffer_size;
+    uint64_t bytes_allocated;
+    uint64_t bytes_in_use;
+    uint64_t num_buffers;
+    uint64_t num_available;
+    uint64_t fallback_bytes;
+    uint64_t spilled_bytes;
+} rbgo_pool_stats_t;
+
+// An entry the Go side handed over with RBEntry.ExportC, which C releases,
+// whenever it's done with it, with rbgo_entry_release(token).
+typedef struct {
+    void *data;
+    uint64_t len;
+    uint64_t token;
+} rbgo_entry_t;
+
+uint8_t rbgo_pool_acquire(char *, uint64_t, void **);
+uint8_t rbgo_pool_release(char *, void *);
+uint8_t rbgo_pool_stats(char *, rbgo_pool_stats_t *);
+uint8_t rbgo_entry_release(uint64_t);
+
+#endif
@@ -0,0 +1,111 @@
+package rustybuffer
+
+import "errors"
+
+// The library version these bindings were written against. A library is
+// compatible if it has the same major version and at least the same minor
+// version, or for 0.x releases the same minor version.
+const (
+	BindingsVersionMajor = 0
+	BindingsVersionMinor = 1
+)
+
+var ErrIncompatibleLibrary = errors.New("rustybuffer: incompatible library version")
+
+// Capabilities are the optional features a build of the library supports.
+// Newer features can be missing from older (or differently configured)
+// libraries, so check for them with Has rather than assuming.
+type Capabilities uint64
+
+const (
+	// Stats reports allocator statistics. Without it Stats are all zero.
+	CapabilityStats Capabilities = 1 << iota
+
+	// Buffers can be acquired with an alignment larger than malloc's.
+	CapabilityAlignedAlloc
+
+	// Buffers can be backed by huge pages.
+	CapabilityHugePages
+
+	// The buffers currently handed out can be listed, which lets
+	// Pool.Reconcile check a leaked entry is still live before releasing
+	// it.
+	CapabilityLiveHandles
+
+	// Memory can be filled by the library, which is faster than Go for
+	// large buffers (see RBEntry.Fill).
+	CapabilityFill
+
+	// Memory can be compared by the library (see Equal and Compare).
+	CapabilityCompare
+
+	// Memory can be compared in constant time by the library (see
+	// ConstantTimeEqual).
+	CapabilityConstantTimeEqual
+
+	// CRC32C and xxHash64 can be computed by the library (see
+	// RBEntry.Checksum).
+	CapabilityChecksum
+
+	// LZ4 frames can be compressed and decompressed by the library (see
+	// Pool.Compress). The pure Go port can't.
+	CapabilityLZ4
+
+	// Several ranges can be copied in one call to the library (see Copy).
+	CapabilityCopy
+
+	// Cached buffers can be freed on demand (see FreeOSMemory).
+	CapabilityTrim
+
+	// Transforms can be applied by the library, several in one call (see
+	// RBEntry.Transform).
+	CapabilityTransform
+
+	// The library reports what happens in it as it happens, trims in the
+	// background included (see SubscribeLibraryEvents).
+	CapabilityEvents
+
+	// Memory can be filled with random bytes from the OS (see
+	// RBEntry.FillRandom).
+	CapabilityFillRandom
+
+	// The sizes of the buffers cached for reuse can be listed (see
+	// Pool.FragmentationReport).
+	CapabilityCachedSizes
+
+	// Memory can be written to a file descriptor and checksummed in one
+	// pass (see WritevWithChecksum).
+	CapabilityWritevChecksum
+)
+
+func (caps Capabilities) Has(cap Capabilities) bool {
+	return caps&cap == cap
+}
+
+// Library describes the allocator library in use, as returned by
+// LibraryInfo.
+type Library struct {
+	Version      string
+	Major        uint32
+	Minor        uint32
+	Patch        uint32
+	Capabilities Capabilities
+
+	// Native is true for the Rust library and false for the pure Go port
+	// used in builds without cgo.
+	Native bool
+}
+
+func (lib Library) Has(cap Capabilities) bool {
+	return lib.Capabilities.Has(cap)
+}
+
+func versionCompatible(major uint32, minor uint32) bool {
+	if major != BindingsVersionMajor {
+		return false
+	}
+	if major == 0 {
+		return minor == BindingsVersionMinor
+	}
+	return minor >= BindingsVersionMinor
+}
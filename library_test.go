@@ -0,0 +1,44 @@
+package rustybuffer
+
+import "testing"
+
+func TestLibraryInfo(t *testing.T) {
+	info, err := LibraryInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Major != BindingsVersionMajor || info.Minor != BindingsVersionMinor {
+		t.Fatalf("unexpected version %s", info.Version)
+	}
+	if !info.Has(CapabilityStats) {
+		t.Fatal("expected the library to report stats")
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	caps := CapabilityStats | CapabilityHugePages
+	if !caps.Has(CapabilityStats) || caps.Has(CapabilityAlignedAlloc) {
+		t.Fatal("unexpected capability check")
+	}
+	if !caps.Has(CapabilityStats | CapabilityHugePages) {
+		t.Fatal("expected both capabilities")
+	}
+}
+
+func TestVersionCompatible(t *testing.T) {
+	cases := []struct {
+		major, minor uint32
+		ok           bool
+	}{
+		{BindingsVersionMajor, BindingsVersionMinor, true},
+		{BindingsVersionMajor + 1, BindingsVersionMinor, false},
+		{BindingsVersionMajor, BindingsVersionMinor + 1, BindingsVersionMajor != 0},
+	}
+
+	for _, c := range cases {
+		if versionCompatible(c.major, c.minor) != c.ok {
+			t.Errorf("versionCompatible(%d, %d) != %v", c.major, c.minor, c.ok)
+		}
+	}
+}
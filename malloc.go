@@ -0,0 +1,47 @@
+//go:build cgo
+
+package rustybuffer
+
+import "unsafe"
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+type mallocAllocator struct {
+	tracker *sizeTracker
+}
+
+// NewMallocAllocator returns an Allocator that gets every buffer from the
+// C library's calloc and frees it on release, with the same limits the Rust
+// library enforces.
+func NewMallocAllocator(max_total_size uint64, max_buffer_size uint64) Allocator {
+	return &mallocAllocator{
+		tracker: newSizeTracker(max_total_size, max_buffer_size),
+	}
+}
+
+func (alloc *mallocAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	return alloc.tracker.acquire(size, func(size uint64) unsafe.Pointer {
+		return C.calloc(1, C.size_t(size))
+	})
+}
+
+func (alloc *mallocAllocator) Release(data unsafe.Pointer) error {
+	return alloc.tracker.release(data, func(data unsafe.Pointer) {
+		C.free(data)
+	})
+}
+
+func (alloc *mallocAllocator) Stats() Stats {
+	return alloc.tracker.stats()
+}
+
+func (alloc *mallocAllocator) FreeExtents() ([]uint64, []uint64, error) {
+	return cacheExtents(alloc.tracker.stats()), nil, nil
+}
+
+func (alloc *mallocAllocator) LiveHandles() ([]uintptr, error) {
+	return alloc.tracker.liveHandles(), nil
+}
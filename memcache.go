@@ -0,0 +1,97 @@
+package rustybuffer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MemcacheItem is a value read from memcached into pooled memory. Value is
+// only valid until Entry is released.
+type MemcacheItem struct {
+	Key   string
+	Flags uint32
+	CAS   uint64
+	Value []byte
+	Entry RBEntry
+}
+
+// ReadMemcacheValue reads the next value of a get or gets response, in the
+// text protocol, into pooled memory. Once there are no more it reads the
+// END that finishes the response and fails with ErrCacheMiss, so the values
+// of a multi-key get are read until it does.
+func (pool *Pool) ReadMemcacheValue(r *bufio.Reader) (MemcacheItem, error) {
+	line, err := readCacheLine(r)
+	if err != nil {
+		return MemcacheItem{}, err
+	}
+	if string(line) == "END" {
+		return MemcacheItem{}, ErrCacheMiss
+	}
+
+	// ERROR, CLIENT_ERROR or SERVER_ERROR, the latter two with a message.
+	if kind, _, _ := bytes.Cut(line, []byte(" ")); bytes.HasSuffix(kind, []byte("ERROR")) {
+		return MemcacheItem{}, fmt.Errorf("rustybuffer: memcached: %s", line)
+	}
+
+	// VALUE <key> <flags> <bytes> [<cas unique>]
+	fields := bytes.Fields(line)
+	if len(fields) < 4 || len(fields) > 5 || string(fields[0]) != "VALUE" {
+		return MemcacheItem{}, fmt.Errorf("%w: expected a memcached value, got %q", ErrInvalidData, line)
+	}
+	item := MemcacheItem{Key: string(fields[1])}
+	flags, err := strconv.ParseUint(string(fields[2]), 10, 32)
+	if err != nil {
+		return MemcacheItem{}, fmt.Errorf("%w: memcached flags %q", ErrInvalidData, fields[2])
+	}
+	item.Flags = uint32(flags)
+	length, err := strconv.ParseUint(string(fields[3]), 10, 64)
+	if err != nil {
+		return MemcacheItem{}, fmt.Errorf("%w: memcached value length %q", ErrInvalidData, fields[3])
+	}
+	if len(fields) == 5 {
+		if item.CAS, err = strconv.ParseUint(string(fields[4]), 10, 64); err != nil {
+			return MemcacheItem{}, fmt.Errorf("%w: memcached cas %q", ErrInvalidData, fields[4])
+		}
+	}
+
+	item.Entry, item.Value, err = pool.readCacheValue(r, length)
+	if err != nil {
+		return MemcacheItem{}, err
+	}
+	return item, nil
+}
+
+// Keys can't hold whitespace or control characters.
+func invalidMemcacheKeyRune(r rune) bool {
+	return r <= ' ' || r == 0x7f
+}
+
+// MemcacheGet sends gets key on rw and reads the value into pooled memory,
+// failing with ErrCacheMiss if there isn't one.
+func (pool *Pool) MemcacheGet(rw *bufio.ReadWriter, key string) (MemcacheItem, error) {
+	if len(key) == 0 || len(key) > 250 || strings.ContainsFunc(key, invalidMemcacheKeyRune) {
+		return MemcacheItem{}, fmt.Errorf("rustybuffer: invalid memcached key %q", key)
+	}
+	if _, err := fmt.Fprintf(rw, "gets %s\r\n", key); err != nil {
+		return MemcacheItem{}, err
+	}
+	if err := rw.Flush(); err != nil {
+		return MemcacheItem{}, err
+	}
+
+	item, err := pool.ReadMemcacheValue(rw.Reader)
+	if err != nil {
+		return MemcacheItem{}, err
+	}
+	if line, err := readCacheLine(rw.Reader); err != nil || string(line) != "END" {
+		item.Entry.Release()
+		if err == nil {
+			err = fmt.Errorf("%w: expected END after the value, got %q", ErrInvalidData, line)
+		}
+		return MemcacheItem{}, err
+	}
+	return item, nil
+}
@@ -0,0 +1,64 @@
+package rustybuffer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMemcacheGet(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	var sent bytes.Buffer
+	replies := "VALUE blob 42 5 99\r\nabcde\r\nEND\r\nEND\r\nSERVER_ERROR out of memory\r\nVALUE blob 1 1\r\nx\r\nVALUE\r\n"
+	rw := bufio.NewReadWriter(bufio.NewReader(strings.NewReader(replies)), bufio.NewWriter(&sent))
+
+	item, err := pool.MemcacheGet(rw, "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Key != "blob" || item.Flags != 42 || item.CAS != 99 || string(item.Value) != "abcde" ||
+		sent.String() != "gets blob\r\n" {
+		t.Fatalf("unexpected item %+v for %q", item, sent.String())
+	}
+	item.Entry.Release()
+
+	if _, err := pool.MemcacheGet(rw, "blob"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("expected ErrCacheMiss, got %v", err)
+	}
+	if _, err := pool.MemcacheGet(rw, "blob"); err == nil || !strings.Contains(err.Error(), "out of memory") {
+		t.Fatalf("expected the server error, got %v", err)
+	}
+	if _, err := pool.MemcacheGet(rw, "blob"); !errors.Is(err, ErrInvalidData) {
+		t.Fatalf("expected ErrInvalidData for a value without END, got %v", err)
+	}
+	if _, err := pool.MemcacheGet(rw, "two words"); err == nil {
+		t.Fatal("expected an invalid key to be refused")
+	}
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+}
+
+func TestReadMemcacheValues(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	r := bufio.NewReader(strings.NewReader("VALUE a 0 1\r\n1\r\nVALUE b 0 2\r\n22\r\nEND\r\n"))
+	var keys []string
+	for {
+		item, err := pool.ReadMemcacheValue(r)
+		if errors.Is(err, ErrCacheMiss) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, item.Key+"="+string(item.Value))
+		item.Entry.Release()
+	}
+	if strings.Join(keys, " ") != "a=1 b=22" {
+		t.Fatalf("unexpected values %q", keys)
+	}
+}
@@ -0,0 +1,77 @@
+package rustybuffer
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+)
+
+// AdjustMemoryLimit sets the Go runtime's memory limit (see
+// debug.SetMemoryLimit) to what's left of total, the memory the whole
+// process may use (e.g., its container's limit), once the pools have taken
+// what they've allocated outside the Go heap. Without it the garbage
+// collector can't see pooled memory and lets the heap grow until the
+// process is killed. So that a full pool doesn't leave the collector
+// running flat out, the limit is never set below a quarter of total. It
+// returns the limit set.
+//
+// The Rust library's memory is counted once however many pools share it,
+// memory the runtime already sees (from NewHeapAllocator, say, or the
+// ExhaustionHeap policy) isn't counted at all, and allocators it doesn't
+// know (including those wrapped by Pool.Use) are assumed to be outside
+// the heap.
+func AdjustMemoryLimit(total int64, pools ...*Pool) int64 {
+	var external uint64 = 0
+	counted_rust := false
+	for _, pool := range pools {
+		if _, rust := pool.alloc.(rustAllocator); rust {
+			if counted_rust {
+				continue
+			}
+			counted_rust = true
+		}
+		external += offHeapBytes(pool.alloc)
+	}
+
+	limit := total / 4
+	if external < uint64(total) {
+		limit = max(limit, total-int64(external))
+	}
+	debug.SetMemoryLimit(limit)
+
+	return limit
+}
+
+// AdjustMemoryLimitEvery calls AdjustMemoryLimit now and every interval
+// until ctx is done, then puts the memory limit back to what it was.
+func AdjustMemoryLimitEvery(ctx context.Context, interval time.Duration, total int64, pools ...*Pool) {
+	previous := debug.SetMemoryLimit(-1)
+	defer debug.SetMemoryLimit(previous)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	AdjustMemoryLimit(total, pools...)
+	for {
+		select {
+		case <-ticker.C:
+			AdjustMemoryLimit(total, pools...)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// offHeapBytes is how much of alloc's memory the Go runtime can't see.
+func offHeapBytes(alloc Allocator) uint64 {
+	switch alloc.(type) {
+	case *heapAllocator, *deterministicAllocator, *simulationAllocator, unownedAllocator:
+		return 0
+	case rustAllocator:
+		// Without cgo the library is a Go port on the Go heap.
+		if info, err := LibraryInfo(); err != nil || !info.Native {
+			return 0
+		}
+	}
+	return alloc.Stats().BytesAllocated
+}
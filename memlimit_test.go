@@ -0,0 +1,91 @@
+package rustybuffer
+
+import (
+	"context"
+	"math"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+func TestAdjustMemoryLimit(t *testing.T) {
+	defer debug.SetMemoryLimit(debug.SetMemoryLimit(-1))
+
+	heap := NewPool(WithAllocator(NewHeapAllocator(1<<30, 1<<30)))
+	entry, err := heap.AllocBuffers([]uint64{1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	// Go heap memory is already counted by the runtime.
+	if limit := AdjustMemoryLimit(1<<30, heap); limit != 1<<30 || debug.SetMemoryLimit(-1) != 1<<30 {
+		t.Fatalf("unexpected limit %d", limit)
+	}
+
+	malloc := NewPool(WithAllocator(NewMallocAllocator(1<<30, 1<<30)))
+	if _, on_heap := malloc.alloc.(*heapAllocator); on_heap {
+		t.Skip("malloc is the Go heap without cgo")
+	}
+	big, err := malloc.AllocBuffers([]uint64{1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit := AdjustMemoryLimit(1<<30, heap, malloc); limit != 1<<30-1<<20 {
+		t.Fatalf("unexpected limit %d", limit)
+	}
+
+	// Never less than a quarter.
+	if limit := AdjustMemoryLimit(1<<21, malloc); limit != 1<<21-1<<20 {
+		t.Fatalf("unexpected limit %d", limit)
+	}
+	if limit := AdjustMemoryLimit(1<<20, malloc); limit != 1<<18 {
+		t.Fatalf("unexpected limit %d", limit)
+	}
+
+	big.Release()
+	if limit := AdjustMemoryLimit(1<<30, malloc); limit != 1<<30 {
+		t.Fatalf("unexpected limit %d", limit)
+	}
+}
+
+func TestAdjustMemoryLimitRust(t *testing.T) {
+	if info, err := LibraryInfo(); err != nil || !info.Native {
+		t.Skip("the Rust library isn't available")
+	}
+	defer debug.SetMemoryLimit(debug.SetMemoryLimit(-1))
+
+	// Every Rust backed pool shares one allocator, which is only counted
+	// once.
+	allocated := int64(NewRustAllocator().Stats().BytesAllocated)
+	if limit := AdjustMemoryLimit(1<<40, NewPool(), NewPool(), defaultPool); limit != 1<<40-allocated {
+		t.Fatalf("unexpected limit %d with %d allocated", limit, allocated)
+	}
+}
+
+func TestAdjustMemoryLimitEvery(t *testing.T) {
+	previous := debug.SetMemoryLimit(math.MaxInt64 - 1)
+	defer debug.SetMemoryLimit(previous)
+
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<30, 1<<30)))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		AdjustMemoryLimitEvery(ctx, time.Millisecond, 1<<30, pool)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for debug.SetMemoryLimit(-1) != 1<<30 {
+		if time.Now().After(deadline) {
+			t.Fatal("the limit wasn't set")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64-1 {
+		t.Fatalf("the limit wasn't restored: %d", limit)
+	}
+}
@@ -0,0 +1,190 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"math"
+)
+
+// The metrics below follow runtime/metrics: each has a stable name, a
+// path ending in its unit, and a kind that never changes, so an agent can
+// list them with AllMetrics and read whichever it knows how to handle
+// without depending on this package's Stats or on any metrics library.
+// Names are only ever added, never reused for something else.
+
+// MetricKind is the type of a metric's value.
+type MetricKind int
+
+const (
+	// The metric doesn't exist, or isn't supported.
+	MetricKindBad MetricKind = iota
+	MetricKindUint64
+	MetricKindFloat64
+)
+
+func (kind MetricKind) String() string {
+	switch kind {
+	case MetricKindBad:
+		return "bad"
+	case MetricKindUint64:
+		return "uint64"
+	case MetricKindFloat64:
+		return "float64"
+	default:
+		return fmt.Sprintf("MetricKind(%d)", int(kind))
+	}
+}
+
+// MetricDescription describes a metric, see AllMetrics.
+type MetricDescription struct {
+	// The metric's name, e.g., "/rustybuffer/memory/in-use:bytes".
+	Name        string
+	Description string
+	Kind        MetricKind
+
+	// Whether the metric is a running total that only ever goes up, so
+	// rates come from the difference between two reads, rather than a
+	// gauge of how things are now.
+	Cumulative bool
+}
+
+// MetricValue is a metric's value, of the kind its description gives.
+type MetricValue struct {
+	kind MetricKind
+	bits uint64
+}
+
+// Kind is the value's kind, MetricKindBad if the metric doesn't exist.
+func (value MetricValue) Kind() MetricKind {
+	return value.kind
+}
+
+// Uint64 returns the value of a MetricKindUint64 metric, and panics for
+// any other kind.
+func (value MetricValue) Uint64() uint64 {
+	if value.kind != MetricKindUint64 {
+		panic("rustybuffer: Uint64 of a " + value.kind.String() + " metric value")
+	}
+	return value.bits
+}
+
+// Float64 returns the value of a MetricKindFloat64 metric, and panics for
+// any other kind.
+func (value MetricValue) Float64() float64 {
+	if value.kind != MetricKindFloat64 {
+		panic("rustybuffer: Float64 of a " + value.kind.String() + " metric value")
+	}
+	return math.Float64frombits(value.bits)
+}
+
+// MetricSample is a metric's name and, once read, its value.
+type MetricSample struct {
+	Name  string
+	Value MetricValue
+}
+
+type metric struct {
+	MetricDescription
+	read func(snapshot *Snapshot) MetricValue
+}
+
+func uint64Metric(read func(snapshot *Snapshot) uint64) func(*Snapshot) MetricValue {
+	return func(snapshot *Snapshot) MetricValue {
+		return MetricValue{MetricKindUint64, read(snapshot)}
+	}
+}
+
+func float64Metric(read func(snapshot *Snapshot) float64) func(*Snapshot) MetricValue {
+	return func(snapshot *Snapshot) MetricValue {
+		return MetricValue{MetricKindFloat64, math.Float64bits(read(snapshot))}
+	}
+}
+
+var allMetrics = []metric{
+	{MetricDescription{"/rustybuffer/acquires/failed:calls", "AllocBuffers calls that failed, for whatever reason.", MetricKindUint64, true},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.AcquireFailures })},
+	{MetricDescription{"/rustybuffer/acquires/total:bytes", "Bytes acquired by AllocBuffers calls that succeeded.", MetricKindUint64, true},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.BytesAcquired })},
+	{MetricDescription{"/rustybuffer/acquires/total:calls", "AllocBuffers calls that succeeded.", MetricKindUint64, true},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Acquires })},
+	{MetricDescription{"/rustybuffer/buffers/available:buffers", "Buffers the allocator holds for reuse.", MetricKindUint64, false},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.NumAvailable })},
+	{MetricDescription{"/rustybuffer/buffers/held:buffers", "Buffers the allocator holds, in use or not.", MetricKindUint64, false},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.NumBuffers })},
+	{MetricDescription{"/rustybuffer/cgo/calls:calls", "Calls into the Rust library by the whole process, not just the pool.", MetricKindUint64, true},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return CgoCallCounts().Total() })},
+	{MetricDescription{"/rustybuffer/limits/buffer:bytes", "The biggest buffer the allocator hands out.", MetricKindUint64, false},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.MaxBufferSize })},
+	{MetricDescription{"/rustybuffer/limits/total:bytes", "The most the allocator holds at once.", MetricKindUint64, false},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.MaxTotalSize })},
+	{MetricDescription{"/rustybuffer/memory/allocated:bytes", "Bytes the allocator holds, in use or cached for reuse.", MetricKindUint64, false},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.BytesAllocated })},
+	{MetricDescription{"/rustybuffer/memory/fallback:bytes", "Bytes in use taken from the Go heap when the allocator was exhausted.", MetricKindUint64, false},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.FallbackBytes })},
+	{MetricDescription{"/rustybuffer/memory/huge-pages:bytes", "Bytes in use mapped in huge pages.", MetricKindUint64, false},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.HugePageBytes })},
+	{MetricDescription{"/rustybuffer/memory/in-use:bytes", "Bytes the allocator has handed out.", MetricKindUint64, false},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.BytesInUse })},
+	{MetricDescription{"/rustybuffer/memory/spilled:bytes", "Bytes in use mapped from temporary files.", MetricKindUint64, false},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.SpilledBytes })},
+	{MetricDescription{"/rustybuffer/memory/utilization:ratio", "The fraction of the bytes the allocator holds that are in use.", MetricKindFloat64, false},
+		float64Metric(func(snapshot *Snapshot) float64 {
+			if snapshot.Stats.BytesAllocated == 0 {
+				return 0
+			}
+			return float64(snapshot.Stats.BytesInUse) / float64(snapshot.Stats.BytesAllocated)
+		})},
+	{MetricDescription{"/rustybuffer/quarantine/held:buffers", "Released buffers held in quarantine.", MetricKindUint64, false},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.NumQuarantined })},
+	{MetricDescription{"/rustybuffer/quarantine/held:bytes", "Released bytes held in quarantine.", MetricKindUint64, false},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.QuarantinedBytes })},
+	{MetricDescription{"/rustybuffer/quarantine/violations:releases", "Quarantined buffers found written to after being released.", MetricKindUint64, true},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.QuarantineViolations })},
+	{MetricDescription{"/rustybuffer/wipes/failed:releases", "Releases whose memory wasn't all zeros when read back after being wiped.", MetricKindUint64, true},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.WipesFailed })},
+	{MetricDescription{"/rustybuffer/wipes/verified:releases", "Releases whose memory was verified wiped.", MetricKindUint64, true},
+		uint64Metric(func(snapshot *Snapshot) uint64 { return snapshot.Stats.WipesVerified })},
+}
+
+var metricsByName = func() map[string]*metric {
+	by_name := make(map[string]*metric, len(allMetrics))
+	for idx := range allMetrics {
+		by_name[allMetrics[idx].Name] = &allMetrics[idx]
+	}
+	return by_name
+}()
+
+// AllMetrics describes every metric Pool.ReadMetrics supports, sorted by
+// name.
+func AllMetrics() []MetricDescription {
+	descriptions := make([]MetricDescription, len(allMetrics))
+	for idx, metric := range allMetrics {
+		descriptions[idx] = metric.MetricDescription
+	}
+	return descriptions
+}
+
+// ReadMetrics sets the value of each of samples from a single snapshot of
+// the pool, so they're consistent with each other. A sample whose name
+// isn't one of AllMetrics gets a MetricKindBad value. The samples can be
+// reused from one read to the next.
+func (pool *Pool) ReadMetrics(samples []MetricSample) {
+	snapshot := pool.Snapshot()
+	for idx := range samples {
+		metric := metricsByName[samples[idx].Name]
+		if metric == nil {
+			samples[idx].Value = MetricValue{}
+			continue
+		}
+		samples[idx].Value = metric.read(&snapshot)
+	}
+}
+
+// Metrics reads every metric, in AllMetrics' order.
+func (pool *Pool) Metrics() []MetricSample {
+	samples := make([]MetricSample, len(allMetrics))
+	for idx, metric := range allMetrics {
+		samples[idx].Name = metric.Name
+	}
+	pool.ReadMetrics(samples)
+	return samples
+}
@@ -0,0 +1,68 @@
+package rustybuffer
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestAllMetrics(t *testing.T) {
+	descriptions := AllMetrics()
+	if !sort.SliceIsSorted(descriptions, func(i, j int) bool { return descriptions[i].Name < descriptions[j].Name }) {
+		t.Fatal("expected the metrics sorted by name")
+	}
+	for _, description := range descriptions {
+		if !strings.HasPrefix(description.Name, "/rustybuffer/") || !strings.Contains(description.Name, ":") {
+			t.Errorf("malformed metric name %q", description.Name)
+		}
+		if description.Kind == MetricKindBad || description.Description == "" {
+			t.Errorf("incomplete description %+v", description)
+		}
+	}
+}
+
+func TestReadMetrics(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1000, 100)))
+	entry, err := pool.AllocBuffers([]uint64{30, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	if _, err := pool.AllocBuffers([]uint64{200}); err == nil {
+		t.Fatal("expected the acquire to fail")
+	}
+
+	samples := []MetricSample{
+		{Name: "/rustybuffer/acquires/total:calls"},
+		{Name: "/rustybuffer/acquires/failed:calls"},
+		{Name: "/rustybuffer/memory/in-use:bytes"},
+		{Name: "/rustybuffer/limits/buffer:bytes"},
+		{Name: "/rustybuffer/memory/utilization:ratio"},
+		{Name: "/rustybuffer/no/such:metric"},
+	}
+	pool.ReadMetrics(samples)
+
+	for idx, expected := range []uint64{1, 1, 40, 100} {
+		if value := samples[idx].Value; value.Kind() != MetricKindUint64 || value.Uint64() != expected {
+			t.Errorf("expected %s to be %d, got %v", samples[idx].Name, expected, value)
+		}
+	}
+	if value := samples[4].Value; value.Kind() != MetricKindFloat64 || value.Float64() != 1 {
+		t.Errorf("expected full utilization, got %v", value)
+	}
+	if kind := samples[5].Value.Kind(); kind != MetricKindBad {
+		t.Errorf("expected a bad value for an unknown metric, got %v", kind)
+	}
+	expectPanic(t, "Uint64 of a bad metric value", func() { samples[5].Value.Uint64() })
+	expectPanic(t, "Float64 of a uint64 metric value", func() { samples[0].Value.Float64() })
+
+	all := pool.Metrics()
+	if len(all) != len(AllMetrics()) {
+		t.Fatalf("expected every metric, got %v", all)
+	}
+	for _, sample := range all {
+		if sample.Value.Kind() != metricsByName[sample.Name].Kind {
+			t.Errorf("%s has a %v value", sample.Name, sample.Value.Kind())
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//go:build cgo && rustybuffer_mimalloc
+
+package rustybuffer
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+#cgo LDFLAGS: -lmimalloc
+#include <mimalloc.h>
+*/
+import "C"
+
+// mimallocAllocator gets its memory from mimalloc's default heap. mimalloc
+// heaps can only allocate on the thread that created them, and goroutines
+// move between threads, so unlike the jemalloc allocator there's no per
+// pool isolation.
+type mimallocAllocator struct {
+	tracker *sizeTracker
+
+	// The usable size of every live buffer, i.e., including mimalloc's size
+	// class rounding.
+	usable atomic.Uint64
+}
+
+// NewMimallocAllocator returns an Allocator backed by mimalloc, with the
+// same limits the Rust library enforces. BytesAllocated in its Stats
+// includes mimalloc's size class rounding (mi_usable_size).
+//
+// Requires the rustybuffer_mimalloc build tag and libmimalloc to link
+// against.
+func NewMimallocAllocator(max_total_size uint64, max_buffer_size uint64) Allocator {
+	return &mimallocAllocator{
+		tracker: newSizeTracker(max_total_size, max_buffer_size),
+	}
+}
+
+func (alloc *mimallocAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	return alloc.tracker.acquire(size, func(size uint64) unsafe.Pointer {
+		data := C.mi_zalloc(C.size_t(size))
+		if data != nil {
+			alloc.usable.Add(uint64(C.mi_usable_size(data)))
+		}
+		return data
+	})
+}
+
+func (alloc *mimallocAllocator) Release(data unsafe.Pointer) error {
+	return alloc.tracker.release(data, func(data unsafe.Pointer) {
+		alloc.usable.Add(-uint64(C.mi_usable_size(data)))
+		C.mi_free(data)
+	})
+}
+
+func (alloc *mimallocAllocator) Stats() Stats {
+	stats := alloc.tracker.stats()
+	stats.BytesAllocated = alloc.usable.Load()
+	return stats
+}
+
+// Trim has mimalloc return the memory it's holding on to back to the OS.
+// mimalloc doesn't say how much that was, so it always returns 0.
+func (alloc *mimallocAllocator) Trim() uint64 {
+	C.mi_collect(C.bool(true))
+	return 0
+}
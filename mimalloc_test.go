@@ -0,0 +1,39 @@
+//go:build cgo && rustybuffer_mimalloc
+
+package rustybuffer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMimallocAllocator(t *testing.T) {
+	pool := NewPool(WithAllocator(NewMimallocAllocator(1024*1024, 64*1024)))
+
+	entry, err := pool.AllocBuffers([]uint64{1000, 3001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, buf := range entry.Buffers {
+		for _, b := range buf {
+			if b != 0 {
+				t.Fatal("memory was not zeroed")
+			}
+		}
+	}
+
+	stats := pool.Stats()
+	if stats.BytesInUse != 4001 || stats.BytesAllocated < 4001 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	entry.Release()
+	stats = pool.Stats()
+	if stats.BytesInUse != 0 || stats.BytesAllocated != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	if _, err := pool.AllocBuffers([]uint64{128 * 1024}); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+}
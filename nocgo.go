@@ -0,0 +1,412 @@
+//go:build !cgo
+
+package rustybuffer
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// Without cgo the Rust library can't be linked, so this file provides a
+// pure Go port of it with identical semantics: buffers are cached after
+// release, reused smallest-fit first, zeroed on reuse and evicted largest
+// and smallest first when the total size limit is reached. The memory
+// comes from the Go heap.
+
+var goBuffers = newGoBufferCache()
+
+// max_total_size - The total number of bytes hat RustyBuffers will allocate
+// max_buffer_size - The maximum number of bytes in a single buffer
+//
+// Configure is safe to call at any time, including while other goroutines
+// are acquiring and releasing: the library's one lock orders it with
+// everything else. The new limits apply to acquires from then on, buffers
+// already handed out are left alone.
+func Configure(max_total_size uint64, max_buffer_size uint64) {
+	goBuffers.configure(max_total_size, max_buffer_size)
+}
+
+// rustAllocator is the Allocator backed by the Rust library, or in this
+// build the pure Go port of it. There is a single allocator per process so
+// every rustAllocator shares the limits set by Configure.
+type rustAllocator struct{}
+
+// NewRustAllocator returns the default Allocator. This build doesn't use
+// cgo, so it is the pure Go port of the Rust library's buffer cache.
+func NewRustAllocator() Allocator {
+	return rustAllocator{}
+}
+
+func (rustAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	data, err := goBuffers.acquire(size)
+	if errors.Is(err, ErrNoBufferAvailable) {
+		emitLibraryEvent(LibraryEvent{LibraryEventPressure, size})
+	}
+	return data, err
+}
+
+func (rustAllocator) Release(data unsafe.Pointer) error {
+	err := goBuffers.release(data)
+	if errors.Is(err, ErrInvalidPointer) {
+		emitLibraryEvent(LibraryEvent{LibraryEventInvalidRelease, uint64(uintptr(data))})
+	}
+	return err
+}
+
+func (rustAllocator) Stats() Stats {
+	return goBuffers.stats()
+}
+
+func (rustAllocator) LiveHandles() ([]uintptr, error) {
+	return goBuffers.liveHandles(), nil
+}
+
+func (rustAllocator) FreeExtents() ([]uint64, []uint64, error) {
+	return cacheExtents(goBuffers.stats()), goBuffers.cachedSizes(), nil
+}
+
+func (rustAllocator) Trim() uint64 {
+	bytes_freed := goBuffers.trim()
+	emitLibraryEvent(LibraryEvent{LibraryEventTrimmed, bytes_freed})
+	return bytes_freed
+}
+
+// The pure Go port reports its events itself.
+func reportEvents() bool {
+	return true
+}
+
+// It has no threads of its own to trim on.
+func trimAsync() bool {
+	return false
+}
+
+// What the pure Go port can do.
+const goCapabilities = CapabilityStats | CapabilityLiveHandles | CapabilityFill |
+	CapabilityCompare | CapabilityConstantTimeEqual | CapabilityChecksum |
+	CapabilityCopy | CapabilityTrim | CapabilityTransform | CapabilityEvents |
+	CapabilityFillRandom | CapabilityCachedSizes
+
+// LibraryInfo describes the pure Go port, which always matches the
+// bindings.
+func LibraryInfo() (Library, error) {
+	return Library{
+		Version:      fmt.Sprintf("%d.%d.0", BindingsVersionMajor, BindingsVersionMinor),
+		Major:        BindingsVersionMajor,
+		Minor:        BindingsVersionMinor,
+		Capabilities: goCapabilities,
+		Native:       false,
+	}, nil
+}
+
+func fillBytes(buf []byte, value byte) {
+	goFill(buf, value)
+}
+
+func fillRandom(buf []byte) error {
+	return goFillRandom(buf)
+}
+
+func copySegments(segments []copySegment, size uint64) {
+	goCopySegments(segments)
+}
+
+func compareBytes(a []byte, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func constantTimeEqual(a []byte, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+func checksumBuffers(algo ChecksumAlgorithm, buffers [][]byte, size uint64) uint64 {
+	return goChecksum(algo, buffers)
+}
+
+func writevChecksum(fd uintptr, algo ChecksumAlgorithm, buffers [][]byte) (uint64, uint64, error) {
+	return goWritevChecksum(fd, algo, buffers)
+}
+
+func transformNames() []string {
+	return goTransformNames()
+}
+
+func transformBuffers(buffers [][]byte, steps []TransformStep, size uint64) ([]uint64, error) {
+	return goTransformBuffers(buffers, steps)
+}
+
+// Compression isn't ported, and checkCompression never lets these be
+// called.
+
+func compressBound(size uint64) (uint64, error) {
+	panic("rustybuffer: compression needs the Rust library")
+}
+
+func compressInto(src []byte, dst []byte) (uint64, error) {
+	panic("rustybuffer: compression needs the Rust library")
+}
+
+func decompressedSize(src []byte) (uint64, error) {
+	panic("rustybuffer: compression needs the Rust library")
+}
+
+func decompressInto(src []byte, dst []byte) (uint64, error) {
+	panic("rustybuffer: compression needs the Rust library")
+}
+
+// NewMallocAllocator can't use the C library's malloc without cgo, so in
+// this build it is the same as NewHeapAllocator.
+func NewMallocAllocator(max_total_size uint64, max_buffer_size uint64) Allocator {
+	return NewHeapAllocator(max_total_size, max_buffer_size)
+}
+
+type availableBuffer struct {
+	size uint64
+	addr uintptr
+}
+
+func (buff availableBuffer) less(other availableBuffer) bool {
+	if buff.size != other.size {
+		return buff.size < other.size
+	}
+	return buff.addr < other.addr
+}
+
+type goBufferCache struct {
+	mu              sync.Mutex
+	buffers         map[uintptr][]byte
+	available       []availableBuffer // Sorted by (size, addr)
+	bytes_allocated uint64
+	bytes_in_use    uint64
+	max_total_size  uint64
+	max_buffer_size uint64
+}
+
+func newGoBufferCache() *goBufferCache {
+	return &goBufferCache{
+		buffers:         make(map[uintptr][]byte),
+		max_total_size:  1024 * 1024 * 1024, // 1 GiB
+		max_buffer_size: 10 * 1024 * 1024,   // 10MiB
+	}
+}
+
+func (cache *goBufferCache) configure(max_total_size uint64, max_buffer_size uint64) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.max_total_size = max_total_size
+	cache.max_buffer_size = max_buffer_size
+}
+
+func (cache *goBufferCache) acquire(size uint64) (unsafe.Pointer, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if size > cache.max_buffer_size {
+		return nil, newError(codeBufferTooLarge,
+			"requested %d bytes but max_buffer_size is %d",
+			size, cache.max_buffer_size)
+	}
+
+	// First the easy case when we have an existing buffer that can handle
+	// the request, searching for the smallest buffer that can hold size
+	// bytes.
+	idx := sort.Search(len(cache.available), func(i int) bool {
+		return cache.available[i].size >= size
+	})
+	if idx < len(cache.available) {
+		buff := cache.available[idx]
+		cache.available = append(cache.available[:idx], cache.available[idx+1:]...)
+		cache.bytes_in_use += buff.size
+
+		data := cache.buffers[buff.addr]
+		clear(data)
+		if debugAssertions {
+			cache.check()
+		}
+		return unsafe.Pointer(unsafe.SliceData(data)), nil
+	}
+
+	// Next, see if we can allocate a new buffer for this request.
+	if cache.canAllocate(size) {
+		data := make([]byte, size)
+		ptr := unsafe.Pointer(unsafe.SliceData(data))
+
+		cache.bytes_allocated += size
+		cache.bytes_in_use += size
+		cache.buffers[uintptr(ptr)] = data
+		if debugAssertions {
+			cache.check()
+		}
+
+		return ptr, nil
+	}
+
+	return nil, newError(codeNoBufferAvailable,
+		"requested %d bytes with %d of max_total_size %d in use",
+		size, cache.bytes_in_use, cache.max_total_size)
+}
+
+// canAllocate mirrors RustyBuffers::can_allocate, freeing the largest and
+// then smallest available buffers until there's room for size bytes.
+func (cache *goBufferCache) canAllocate(size uint64) bool {
+	// Sizes are limited by max_buffer_size but that can be anything, so
+	// the additions here need to be checked.
+	total := cache.bytes_allocated + size
+	if total < size {
+		return false
+	}
+
+	if total <= cache.max_total_size {
+		return true
+	}
+
+	if in_use := cache.bytes_in_use + size; in_use < size || in_use > cache.max_total_size {
+		return false
+	}
+
+	free_at_least := total - cache.max_total_size
+
+	var bytes_freed uint64 = 0
+	for bytes_freed < free_at_least {
+		last := cache.available[len(cache.available)-1]
+		cache.available = cache.available[:len(cache.available)-1]
+		delete(cache.buffers, last.addr)
+		bytes_freed += last.size
+
+		if bytes_freed >= free_at_least {
+			break
+		}
+
+		first := cache.available[0]
+		cache.available = cache.available[1:]
+		delete(cache.buffers, first.addr)
+		bytes_freed += first.size
+	}
+
+	cache.bytes_allocated -= bytes_freed
+
+	return true
+}
+
+func (cache *goBufferCache) release(data unsafe.Pointer) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	buff, ok := cache.buffers[uintptr(data)]
+	if !ok {
+		return newError(codeInvalidPointer,
+			"%p was not acquired from rustybuffer", data)
+	}
+
+	entry := availableBuffer{uint64(len(buff)), uintptr(data)}
+	idx := sort.Search(len(cache.available), func(i int) bool {
+		return !cache.available[i].less(entry)
+	})
+	if debugAssertions {
+		assertf(idx == len(cache.available) || cache.available[idx] != entry,
+			"%p released twice", data)
+	}
+	cache.available = append(cache.available, availableBuffer{})
+	copy(cache.available[idx+1:], cache.available[idx:])
+	cache.available[idx] = entry
+	cache.bytes_in_use -= entry.size
+	if debugAssertions {
+		cache.check()
+	}
+
+	return nil
+}
+
+// trim mirrors RustyBuffers::trim, dropping every available buffer so the
+// garbage collector can have it.
+func (cache *goBufferCache) trim() uint64 {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	var bytes_freed uint64 = 0
+	for _, buff := range cache.available {
+		delete(cache.buffers, buff.addr)
+		bytes_freed += buff.size
+	}
+	cache.available = nil
+	cache.bytes_allocated -= bytes_freed
+	if debugAssertions {
+		cache.check()
+	}
+
+	return bytes_freed
+}
+
+func (cache *goBufferCache) liveHandles() []uintptr {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	available := make(map[uintptr]bool, len(cache.available))
+	for _, buff := range cache.available {
+		available[buff.addr] = true
+	}
+
+	live := make([]uintptr, 0, len(cache.buffers)-len(cache.available))
+	for addr := range cache.buffers {
+		if !available[addr] {
+			live = append(live, addr)
+		}
+	}
+	return live
+}
+
+func (cache *goBufferCache) cachedSizes() []uint64 {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	sizes := make([]uint64, len(cache.available))
+	for idx, buff := range cache.available {
+		sizes[idx] = buff.size
+	}
+	return sizes
+}
+
+// check asserts the cache is consistent, with the lock held.
+func (cache *goBufferCache) check() {
+	var total uint64 = 0
+	for _, buff := range cache.buffers {
+		total += uint64(len(buff))
+	}
+	assertf(total == cache.bytes_allocated,
+		"%d buffers add up to %d bytes but %d are allocated",
+		len(cache.buffers), total, cache.bytes_allocated)
+	assertf(cache.bytes_in_use <= cache.bytes_allocated,
+		"%d bytes in use of %d allocated", cache.bytes_in_use, cache.bytes_allocated)
+
+	var available uint64 = 0
+	for idx, buff := range cache.available {
+		assertf(idx == 0 || cache.available[idx-1].less(buff),
+			"available buffers out of order at %d", idx)
+		_, ok := cache.buffers[buff.addr]
+		assertf(ok, "available buffer %#x isn't allocated", buff.addr)
+		available += buff.size
+	}
+	assertf(available+cache.bytes_in_use == cache.bytes_allocated,
+		"%d available and %d in use don't add up to %d allocated",
+		available, cache.bytes_in_use, cache.bytes_allocated)
+}
+
+func (cache *goBufferCache) stats() Stats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return Stats{
+		MaxTotalSize:   cache.max_total_size,
+		MaxBufferSize:  cache.max_buffer_size,
+		BytesAllocated: cache.bytes_allocated,
+		BytesInUse:     cache.bytes_in_use,
+		NumBuffers:     uint64(len(cache.buffers)),
+		NumAvailable:   uint64(len(cache.available)),
+	}
+}
@@ -0,0 +1,90 @@
+//go:build !cgo
+
+package rustybuffer
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"unsafe"
+)
+
+func TestGoBufferCacheReuse(t *testing.T) {
+	cache := newGoBufferCache()
+	cache.configure(1024, 512)
+
+	small, _ := cache.acquire(100)
+	large, _ := cache.acquire(300)
+	if err := cache.release(small); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.release(large); err != nil {
+		t.Fatal(err)
+	}
+
+	// The smallest buffer that fits is reused.
+	data, err := cache.acquire(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != small {
+		t.Fatal("expected the 100 byte buffer to be reused")
+	}
+	if cache.stats().BytesInUse != 100 || cache.stats().NumAvailable != 1 {
+		t.Fatalf("unexpected stats: %+v", cache.stats())
+	}
+}
+
+func TestGoBufferCacheEviction(t *testing.T) {
+	cache := newGoBufferCache()
+	cache.configure(1024, 512)
+
+	var held = make([]unsafe.Pointer, 0)
+	for i := 0; i < 4; i++ {
+		data, err := cache.acquire(256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, data)
+	}
+
+	if _, err := cache.acquire(10); !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+
+	for _, data := range held[:2] {
+		if err := cache.release(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Needs more than the 256 bytes sitting in one cached buffer, so both
+	// cached buffers are evicted to make room.
+	if _, err := cache.acquire(300); err != nil {
+		t.Fatal(err)
+	}
+	stats := cache.stats()
+	if stats.BytesAllocated != 812 || stats.NumAvailable != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	if _, err := cache.acquire(600); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+}
+
+func TestGoBufferCacheOverflow(t *testing.T) {
+	cache := newGoBufferCache()
+	cache.configure(1024, math.MaxUint64)
+
+	data, err := cache.acquire(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.release(data)
+
+	// Unchecked, bytes_allocated plus the size wraps around to fit.
+	if cache.canAllocate(math.MaxUint64 - 50) {
+		t.Fatal("expected a size that wraps around not to fit")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !rustybuffer_debug
+
+package rustybuffer
+
+import "unsafe"
+
+// See debug.go.
+const debugAssertions = false
+
+func assertf(cond bool, format string, args ...any) {}
+
+func debugAcquired(data unsafe.Pointer, size uint64) {}
+
+func debugReleased(data unsafe.Pointer) {}
@@ -0,0 +1,47 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// ViewAs returns a pointer to a T laid out at the start of the entry's
+// idx'th buffer, for reading and writing fixed-layout binary headers
+// without casting by hand. The buffer must be at least as big as T
+// (ErrShortView otherwise) and suitably aligned for it, and T mustn't
+// contain pointers, since the garbage collector can't see pooled memory.
+// Fields are in the machine's byte order with Go's padding, so T is best
+// declared with explicit padding and sized fields. The pointer mustn't be
+// used once the entry is released.
+func ViewAs[T any](entry *RBEntry, idx int) (*T, error) {
+	if idx < 0 || idx >= len(entry.Buffers) {
+		return nil, fmt.Errorf("rustybuffer: buffer %d out of range with %d buffers",
+			idx, len(entry.Buffers))
+	}
+	return overlay[T](entry.View(idx))
+}
+
+// overlay is ViewAs for the start of a view.
+func overlay[T any](view View) (*T, error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if !pointerFree(typ) {
+		return nil, fmt.Errorf("rustybuffer: %s contains pointers and can't overlay pooled memory", typ)
+	}
+
+	if _, err := view.window(0, int(typ.Size())); err != nil {
+		return nil, err
+	}
+	if len(view.buf) == 0 {
+		// Only zero sized types fit, and they can live anywhere.
+		return new(T), nil
+	}
+
+	addr := uintptr(unsafe.Pointer(unsafe.SliceData(view.buf)))
+	if addr%uintptr(typ.Align()) != 0 {
+		return nil, fmt.Errorf("rustybuffer: %#x isn't aligned to %d bytes for %s",
+			addr, typ.Align(), typ)
+	}
+
+	return (*T)(unsafe.Pointer(unsafe.SliceData(view.buf))), nil
+}
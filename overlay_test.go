@@ -0,0 +1,59 @@
+package rustybuffer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestViewAs(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	type header struct {
+		Magic   uint32
+		Version uint16
+		Flags   uint16
+		Length  uint64
+	}
+
+	entry, err := pool.AllocBuffers([]uint64{64, 8, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	hdr, err := ViewAs[header](&entry, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr.Magic = 0xCAFEF00D
+	hdr.Length = 42
+	again, err := ViewAs[header](&entry, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != hdr || again.Magic != 0xCAFEF00D || again.Length != 42 {
+		t.Fatal("expected the same header in pooled memory")
+	}
+	if entry.Buffers[0][8] != 42 && entry.Buffers[0][15] != 42 {
+		t.Fatal("the length isn't in the buffer")
+	}
+
+	if _, err := ViewAs[header](&entry, 1); !errors.Is(err, ErrShortView) {
+		t.Fatalf("expected ErrShortView, got %v", err)
+	}
+	if _, err := ViewAs[struct{}](&entry, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ViewAs[header](&entry, 3); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Fatalf("expected an out of range error, got %v", err)
+	}
+	if _, err := ViewAs[[]byte](&entry, 0); err == nil || !strings.Contains(err.Error(), "pointers") {
+		t.Fatalf("expected an error for a pointer, got %v", err)
+	}
+
+	if _, err := overlay[uint64](entry.View(0).Slice(1, 9)); err == nil ||
+		!strings.Contains(err.Error(), "aligned") {
+		t.Fatalf("expected an alignment error, got %v", err)
+	}
+}
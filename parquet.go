@@ -0,0 +1,116 @@
+package rustybuffer
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// ParquetAllocator implements the arrow/memory.Allocator interface for
+// Arrow's Parquet reader so that column chunks are read and decompressed
+// into pooled memory, then given back a row group at a time:
+//
+//	mem := rustybuffer.NewParquetAllocator(pool)
+//	props := parquet.NewReaderProperties(mem)
+//	rdr, err := file.NewParquetReader(f, file.WithReadProps(props))
+//	...
+//	for idx := 0; idx < rdr.NumRowGroups(); idx++ {
+//		// read the row group's columns ...
+//		mem.EndRowGroup()
+//	}
+//
+// Buffers are zeroed and aligned to ArrowAlignment bytes, and, as with
+// ArrowAllocator, come from the Go heap when pool can't supply them. A
+// ParquetAllocator is safe for concurrent use.
+type ParquetAllocator struct {
+	pool *Pool
+
+	mu      sync.Mutex
+	entries map[*uint8]RBEntry
+}
+
+func NewParquetAllocator(pool *Pool) *ParquetAllocator {
+	return &ParquetAllocator{
+		pool:    pool,
+		entries: make(map[*uint8]RBEntry),
+	}
+}
+
+func (alloc *ParquetAllocator) Allocate(size int) []byte {
+	if size <= 0 {
+		return make([]byte, 0)
+	}
+
+	padded := uint64(size) + ArrowAlignment - 1
+	entry, err := alloc.pool.AllocBuffers([]uint64{padded})
+	if err != nil {
+		return alignSlice(make([]byte, padded), size, ArrowAlignment)
+	}
+	buf := alignSlice(entry.Buffers[0], size, ArrowAlignment)
+	clear(buf)
+
+	alloc.mu.Lock()
+	alloc.entries[unsafe.SliceData(buf)] = entry
+	alloc.mu.Unlock()
+
+	return buf
+}
+
+func (alloc *ParquetAllocator) Reallocate(size int, b []byte) []byte {
+	if size <= cap(b) {
+		grown := b[:size]
+		if size > len(b) {
+			clear(grown[len(b):])
+		}
+		return grown
+	}
+
+	buf := alloc.Allocate(size)
+	copy(buf, b)
+	alloc.Free(b)
+	return buf
+}
+
+// Free gives back a buffer from Allocate, ignoring buffers it didn't hand
+// out and those already given back by EndRowGroup.
+func (alloc *ParquetAllocator) Free(b []byte) {
+	if cap(b) == 0 {
+		return
+	}
+
+	alloc.mu.Lock()
+	entry, ok := alloc.entries[unsafe.SliceData(b)]
+	delete(alloc.entries, unsafe.SliceData(b))
+	alloc.mu.Unlock()
+
+	if ok {
+		entry.Release()
+	}
+}
+
+// EndRowGroup gives back every buffer not yet freed, once the reader is
+// done with a row group, so that buffers the reader keeps cached (e.g., a
+// column's dictionary page) don't outlive it. Nothing read from the row
+// group may be used afterwards; copy out what needs to be kept first.
+func (alloc *ParquetAllocator) EndRowGroup() {
+	alloc.mu.Lock()
+	entries := alloc.entries
+	alloc.entries = make(map[*uint8]RBEntry)
+	alloc.mu.Unlock()
+
+	for _, entry := range entries {
+		entry.Release()
+	}
+}
+
+// CurrentAlloc is the number of pooled bytes handed out and not yet given
+// back, padding included, like arrow/memory.CheckedAllocator's.
+func (alloc *ParquetAllocator) CurrentAlloc() int {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	total := 0
+	for _, entry := range alloc.entries {
+		total += len(entry.Buffers[0])
+	}
+	return total
+}
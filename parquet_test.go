@@ -0,0 +1,52 @@
+package rustybuffer
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestParquetAllocator(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(4096, 4096)))
+	mem := NewParquetAllocator(pool)
+
+	page := mem.Allocate(100)
+	if len(page) != 100 || uintptr(unsafe.Pointer(unsafe.SliceData(page)))%ArrowAlignment != 0 {
+		t.Fatal("expected 100 aligned bytes")
+	}
+	for idx := range page {
+		page[idx] = byte(idx)
+	}
+	page = mem.Reallocate(1000, page)
+	if len(page) != 1000 || page[99] != 99 || page[100] != 0 {
+		t.Fatal("reallocating didn't keep the contents or zero the rest")
+	}
+	if mem.CurrentAlloc() != 1000+ArrowAlignment-1 {
+		t.Fatalf("unexpected current allocation %d", mem.CurrentAlloc())
+	}
+	mem.Free(page)
+	if mem.CurrentAlloc() != 0 || pool.Stats().BytesInUse != 0 {
+		t.Fatalf("expected the page to be freed: %+v", pool.Stats())
+	}
+
+	// Whatever the reader keeps from a row group goes back at its end, and
+	// freeing it afterwards is harmless.
+	dictionary := mem.Allocate(200)
+	values := mem.Allocate(300)
+	mem.Free(values)
+	mem.EndRowGroup()
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("expected the row group's buffers to be released: %+v", pool.Stats())
+	}
+	mem.Free(dictionary)
+
+	// Buffers the pool can't supply come from the heap.
+	huge := mem.Allocate(8192)
+	if len(huge) != 8192 || mem.CurrentAlloc() != 0 {
+		t.Fatal("expected a heap fallback")
+	}
+	mem.Free(huge)
+
+	if len(mem.Allocate(0)) != 0 {
+		t.Fatal("expected an empty buffer")
+	}
+}
@@ -0,0 +1,140 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"unsafe"
+)
+
+// OpenPersistentSegment opens the segment at path, or creates it with
+// size and block_size if there isn't one, so that a pool backed by a file
+// on disk (rather than /dev/shm) can pick up where a previous run left
+// off: a restarted process finds what it wrote with Buffers and takes it
+// back with Pool.Adopt, e.g., to keep a cache warm or a queue of work
+// that survives a crash. size and block_size are ignored for an existing
+// segment, which should be checked with Repair before it's used. Two
+// processes creating the same segment at once can see the other's half
+// made segment and fail to open it.
+func OpenPersistentSegment(path string, size uint64, block_size uint64) (*SharedSegment, error) {
+	seg, err := OpenSharedSegment(path)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return seg, err
+	}
+
+	seg, err = CreateSharedSegment(path, size, block_size)
+	if errors.Is(err, fs.ErrExist) {
+		return OpenSharedSegment(path)
+	}
+	return seg, err
+}
+
+// Sync flushes the segment to its file's storage, so what's been written
+// survives the machine going down, not just the process.
+func (seg *SharedSegment) Sync() error {
+	file, err := os.OpenFile(seg.path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("rustybuffer: syncing segment: %w", err)
+	}
+	defer file.Close()
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("rustybuffer: syncing segment: %w", err)
+	}
+	return nil
+}
+
+// SharedBuffer describes a buffer acquired from a shared segment, by any
+// process.
+type SharedBuffer struct {
+	Handle SharedHandle
+
+	// The process that acquired or last adopted the buffer.
+	Owner int
+
+	// Set if Owner isn't running anymore and this mapping hasn't adopted
+	// the buffer, i.e., it's waiting to be adopted or reclaimed.
+	Orphaned bool
+}
+
+// Buffers lists the buffers acquired from the segment, in order.
+func (seg *SharedSegment) Buffers() []SharedBuffer {
+	seg.lock()
+	defer seg.unlock()
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	var buffers []SharedBuffer
+	for idx := uint64(0); idx < seg.blocks; idx++ {
+		record := seg.record(idx)
+		if record.state != blockHead {
+			continue
+		}
+
+		_, owned := seg.owned[idx]
+		buffers = append(buffers, SharedBuffer{
+			Handle:   SharedHandle{seg.id, seg.data + idx*seg.block_size, record.length, record.generation},
+			Owner:    int(record.owner),
+			Orphaned: !owned && !processAlive(int(record.owner)),
+		})
+		idx += max(record.run, 1) - 1
+	}
+	return buffers
+}
+
+// Adopt takes ownership of a buffer in the segment backing the pool that
+// was acquired by a process that's no longer running, typically this
+// program's previous run, returning an entry for it with a single buffer
+// holding what was written. Releasing the entry gives the memory back to
+// the segment as usual. Buffers whose owner is still running, including
+// this process, can't be adopted.
+func (pool *Pool) Adopt(handle SharedHandle) (RBEntry, error) {
+	seg, ok := pool.alloc.(*SharedSegment)
+	if !ok || seg.id != handle.Segment {
+		return RBEntry{}, fmt.Errorf("rustybuffer: pool isn't backed by segment %#x", handle.Segment)
+	}
+
+	idx, err := seg.adopt(handle)
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	data := seg.block(idx)
+	debugAcquired(data, handle.Length)
+	state := newEntryState(pool, data, handle.Length, seg)
+	if pool.sites != nil {
+		pool.sites.track(data, handle.Length, 1)
+	}
+
+	return RBEntry{data, [][]uint8{unsafe.Slice((*byte)(data), handle.Length)}, state}, nil
+}
+
+// adopt makes this mapping the owner of handle's buffer, returning its
+// first block.
+func (seg *SharedSegment) adopt(handle SharedHandle) (uint64, error) {
+	idx, err := seg.handleBlock(handle)
+	if err != nil {
+		return 0, err
+	}
+
+	seg.lock()
+	defer seg.unlock()
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	record := seg.record(idx)
+	if record.state != blockHead || record.generation != handle.Generation ||
+		record.length != handle.Length {
+		return 0, fmt.Errorf("%w: block %d of segment %#x", ErrStaleHandle, idx, seg.id)
+	}
+	if _, owned := seg.owned[idx]; owned || processAlive(int(record.owner)) {
+		return 0, fmt.Errorf("rustybuffer: block %d of segment %#x is still owned by process %d",
+			idx, seg.id, record.owner)
+	}
+
+	record.owner = uint32(os.Getpid())
+	seg.owned[idx] = record.generation
+
+	return idx, nil
+}
@@ -0,0 +1,116 @@
+//go:build unix
+
+package rustybuffer
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+// deadPid returns the pid of a process that's been and gone.
+func deadPid(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("can't run a process: %v", err)
+	}
+	return cmd.ProcessState.Pid()
+}
+
+func TestPersistentSegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segment")
+
+	// The previous run, which wrote something and crashed.
+	previous, err := OpenPersistentSegment(path, 16*1024, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer previous.Close()
+	data, err := previous.Acquire(1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(unsafe.Slice((*byte)(data), 1500), "warm cache")
+	if err := previous.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	idx, _ := previous.index(data)
+	previous.record(idx).owner = uint32(deadPid(t))
+
+	// This run.
+	seg, err := OpenPersistentSegment(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.Close()
+	if seg.ID() != previous.ID() {
+		t.Fatal("reopening created a new segment")
+	}
+	pool := NewPool(WithAllocator(seg))
+
+	buffers := seg.Buffers()
+	if len(buffers) != 1 || !buffers[0].Orphaned || buffers[0].Handle.Length != 1500 {
+		t.Fatalf("unexpected buffers %+v", buffers)
+	}
+
+	entry, err := pool.Adopt(buffers[0].Handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Buffers) != 1 || !bytes.HasPrefix(entry.Buffers[0], []byte("warm cache")) {
+		t.Fatal("the adopted entry doesn't have what was written")
+	}
+	if buffers := seg.Buffers(); len(buffers) != 1 || buffers[0].Orphaned {
+		t.Fatalf("adopted buffer is still orphaned: %+v", buffers)
+	}
+	if _, err := pool.Adopt(buffers[0].Handle); err == nil {
+		t.Fatal("adopted a buffer twice")
+	}
+
+	entry.Release()
+	if seg.Stats().NumBuffers != 0 || len(seg.Buffers()) != 0 {
+		t.Fatalf("releasing the adopted entry didn't free it: %+v", seg.Stats())
+	}
+	if _, err := pool.Adopt(buffers[0].Handle); !errors.Is(err, ErrStaleHandle) {
+		t.Fatalf("expected ErrStaleHandle, got %v", err)
+	}
+}
+
+func TestPersistentSegmentLiveOwner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segment")
+	seg, err := OpenPersistentSegment(path, 16*1024, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.Close()
+	pool := NewPool(WithAllocator(seg))
+
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	// Still owned by this process, through another mapping.
+	other, err := OpenSharedSegment(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	buffers := other.Buffers()
+	if len(buffers) != 1 || buffers[0].Orphaned {
+		t.Fatalf("unexpected buffers %+v", buffers)
+	}
+	if _, err := NewPool(WithAllocator(other)).Adopt(buffers[0].Handle); err == nil {
+		t.Fatal("adopted a buffer from a running process")
+	}
+	if err := other.Release(unsafe.Pointer(&entry.Buffers[0][0])); !errors.Is(err, ErrInvalidPointer) {
+		t.Fatalf("expected ErrInvalidPointer releasing another mapping's buffer, got %v", err)
+	}
+	if _, err := NewPool().Adopt(buffers[0].Handle); err == nil {
+		t.Fatal("adopted into a pool not backed by the segment")
+	}
+}
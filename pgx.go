@@ -0,0 +1,232 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Rows staged by CopySource and CopySink are packed into pooled chunks of
+// this size. Values larger than a chunk get an entry of their own.
+const copyChunkSize = 1024 * 1024
+
+// copyChunks packs byte strings into pooled chunks so that many small,
+// short-lived values cost one acquire per chunk rather than one per value.
+type copyChunks struct {
+	entries []RBEntry
+	free    []byte
+}
+
+func (chunks *copyChunks) copyBytes(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return b[:0:0], nil
+	}
+
+	if len(b) > copyChunkSize {
+		entry, err := allocBuffers([]uint64{uint64(len(b))})
+		if err != nil {
+			return nil, err
+		}
+		chunks.entries = append(chunks.entries, entry)
+		copy(entry.Buffers[0], b)
+		return entry.Buffers[0], nil
+	}
+
+	if len(b) > len(chunks.free) {
+		entry, err := allocBuffers([]uint64{copyChunkSize})
+		if err != nil {
+			return nil, err
+		}
+		chunks.entries = append(chunks.entries, entry)
+		chunks.free = entry.Buffers[0]
+	}
+
+	ret := chunks.free[:len(b):len(b)]
+	copy(ret, b)
+	chunks.free = chunks.free[len(b):]
+	return ret, nil
+}
+
+func (chunks *copyChunks) release() {
+	for idx := range chunks.entries {
+		chunks.entries[idx].Release()
+	}
+	chunks.entries = nil
+	chunks.free = nil
+}
+
+// CopySource stages rows in pooled memory for pgx's CopyFrom. It implements
+// pgx.CopyFromSource:
+//
+//	src := rustybuffer.NewCopySource()
+//	defer src.Release()
+//	for ... {
+//		src.Append(id, payload)
+//	}
+//	conn.CopyFrom(ctx, pgx.Identifier{"blobs"}, []string{"id", "data"}, src)
+//
+// []byte and string values are copied into pooled chunks, every other
+// value is kept as is. The staged rows are valid until Release.
+type CopySource struct {
+	chunks copyChunks
+	rows   [][]any
+	curr   int
+}
+
+func NewCopySource() *CopySource {
+	return &CopySource{curr: -1}
+}
+
+// Append stages one row. The caller may reuse any []byte values as soon as
+// Append returns.
+func (src *CopySource) Append(values ...any) error {
+	row := make([]any, len(values))
+	for idx, value := range values {
+		switch v := value.(type) {
+		case []byte:
+			staged, err := src.chunks.copyBytes(v)
+			if err != nil {
+				return err
+			}
+			row[idx] = staged
+		case string:
+			staged, err := src.chunks.copyBytes(
+				unsafe.Slice(unsafe.StringData(v), len(v)))
+			if err != nil {
+				return err
+			}
+			row[idx] = unsafe.String(unsafe.SliceData(staged), len(staged))
+		default:
+			row[idx] = value
+		}
+	}
+
+	src.rows = append(src.rows, row)
+	return nil
+}
+
+// Len returns the number of staged rows.
+func (src *CopySource) Len() int {
+	return len(src.rows)
+}
+
+func (src *CopySource) Next() bool {
+	if src.curr+1 >= len(src.rows) {
+		return false
+	}
+	src.curr += 1
+	return true
+}
+
+func (src *CopySource) Values() ([]any, error) {
+	if src.curr < 0 || src.curr >= len(src.rows) {
+		return nil, fmt.Errorf("rustybuffer: Values called without a row")
+	}
+	return src.rows[src.curr], nil
+}
+
+func (src *CopySource) Err() error {
+	return nil
+}
+
+// Rewind restarts iteration so the staged rows can be copied again, e.g.
+// when retrying a failed transaction.
+func (src *CopySource) Rewind() {
+	src.curr = -1
+}
+
+// Release returns all staged row data to the pool.
+func (src *CopySource) Release() {
+	src.chunks.release()
+	src.rows = nil
+	src.curr = -1
+}
+
+// CopySink collects the output of pgconn's CopyTo in pooled chunks. It is an
+// io.Writer; each Write is kept as one chunk in the order received.
+type CopySink struct {
+	chunks copyChunks
+	data   [][]byte
+	size   int
+}
+
+func NewCopySink() *CopySink {
+	return &CopySink{}
+}
+
+func (sink *CopySink) Write(p []byte) (int, error) {
+	staged, err := sink.chunks.copyBytes(p)
+	if err != nil {
+		return 0, err
+	}
+	sink.data = append(sink.data, staged)
+	sink.size += len(p)
+	return len(p), nil
+}
+
+// Chunks returns the collected data. They alias pooled memory and are only
+// valid until Release.
+func (sink *CopySink) Chunks() [][]byte {
+	return sink.data
+}
+
+// Len returns the total number of bytes written.
+func (sink *CopySink) Len() int {
+	return sink.size
+}
+
+func (sink *CopySink) Release() {
+	sink.chunks.release()
+	sink.data = nil
+	sink.size = 0
+}
+
+// PooledBytes is a sql.Scanner (which pgx honours) for large bytea/jsonb
+// columns. Scanned values are copied into a pooled buffer that is reused
+// from row to row while it's big enough, so scanning a large result set
+// doesn't allocate a fresh []byte per row.
+type PooledBytes struct {
+	Bytes []byte
+	Valid bool
+
+	entry RBEntry
+}
+
+func (pb *PooledBytes) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		pb.Bytes = nil
+		pb.Valid = false
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = unsafe.Slice(unsafe.StringData(v), len(v))
+	default:
+		return fmt.Errorf("rustybuffer: cannot scan %T into PooledBytes", src)
+	}
+
+	if pb.entry.data == nil || len(pb.entry.Buffers[0]) < len(data) {
+		pb.entry.Release()
+		entry, err := allocBuffers([]uint64{uint64(max(len(data), 1))})
+		if err != nil {
+			pb.Bytes = nil
+			pb.Valid = false
+			return err
+		}
+		pb.entry = entry
+	}
+
+	pb.Bytes = pb.entry.Buffers[0][:len(data)]
+	copy(pb.Bytes, data)
+	pb.Valid = true
+	return nil
+}
+
+// Release returns the scan buffer to the pool.
+func (pb *PooledBytes) Release() {
+	pb.entry.Release()
+	pb.entry = RBEntry{}
+	pb.Bytes = nil
+	pb.Valid = false
+}
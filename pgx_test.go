@@ -0,0 +1,110 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestCopySource(t *testing.T) {
+	Configure(64*1024*1024, 4*1024*1024)
+	src := NewCopySource()
+	defer src.Release()
+
+	payload := []byte("payload")
+	big := bytes.Repeat([]byte{7}, copyChunkSize+1)
+	for i := 0; i < 3; i++ {
+		if err := src.Append(i, payload, fmt.Sprint("row", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.Append(3, big, "big"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Staged values must not alias the caller's memory.
+	payload[0] = 'X'
+
+	if len(src.chunks.entries) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(src.chunks.entries))
+	}
+
+	for pass := 0; pass < 2; pass++ {
+		count := 0
+		for src.Next() {
+			values, err := src.Values()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if values[0] != count {
+				t.Fatalf("unexpected id %v", values[0])
+			}
+			if count < 3 {
+				if string(values[1].([]byte)) != "payload" {
+					t.Fatalf("unexpected payload %q", values[1])
+				}
+				if values[2].(string) != fmt.Sprint("row", count) {
+					t.Fatalf("unexpected name %q", values[2])
+				}
+			} else if !bytes.Equal(values[1].([]byte), big) {
+				t.Fatal("big payload was not staged")
+			}
+			count += 1
+		}
+		if count != 4 || src.Err() != nil {
+			t.Fatalf("expected 4 rows, got %d", count)
+		}
+		src.Rewind()
+	}
+}
+
+func TestCopySink(t *testing.T) {
+	Configure(64*1024*1024, 4*1024*1024)
+	sink := NewCopySink()
+	defer sink.Release()
+
+	line := []byte("1\thello\n")
+	for i := 0; i < 3; i++ {
+		if _, err := sink.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	line[0] = '9'
+
+	if sink.Len() != 3*len("1\thello\n") || len(sink.Chunks()) != 3 {
+		t.Fatal("unexpected sink contents")
+	}
+	for _, chunk := range sink.Chunks() {
+		if string(chunk) != "1\thello\n" {
+			t.Fatalf("unexpected chunk %q", chunk)
+		}
+	}
+}
+
+func TestPooledBytesScan(t *testing.T) {
+	Configure(64*1024*1024, 4*1024*1024)
+	var pb PooledBytes
+	defer pb.Release()
+
+	if err := pb.Scan([]byte("a larger first value")); err != nil {
+		t.Fatal(err)
+	}
+	first := pb.entry.data
+
+	if err := pb.Scan("small"); err != nil {
+		t.Fatal(err)
+	}
+	if string(pb.Bytes) != "small" || !pb.Valid {
+		t.Fatalf("unexpected scan result %q", pb.Bytes)
+	}
+	if pb.entry.data != first {
+		t.Fatal("expected the scan buffer to be reused")
+	}
+
+	if err := pb.Scan(nil); err != nil || pb.Valid {
+		t.Fatal("expected NULL to scan as invalid")
+	}
+	if err := pb.Scan(42); err == nil {
+		t.Fatal("expected an error scanning an int")
+	}
+}
@@ -0,0 +1,152 @@
+package rustybuffer
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// RegisterGoBuffer pins buf with pinner and wraps it in an entry, so that
+// memory from the Go heap can be used with everything that takes pooled
+// memory (Checksum, Copy, Compress and so on) without first copying it
+// into a pool. Pinning is what lets the Rust library be handed its address
+// inside other Go memory, as Copy does.
+//
+// The entry's Release does nothing, the memory stays the Go heap's. It's
+// pinned until pinner.Unpin, after which the entry and any views of it
+// mustn't be used.
+func RegisterGoBuffer(pinner *runtime.Pinner, buf []byte) RBEntry {
+	data := unsafe.Pointer(unsafe.SliceData(buf))
+	if data != nil {
+		pinner.Pin(data)
+	}
+
+	// Not newEntryState: the default pool's leak tracking and finalizer
+	// have nothing to do with Go memory.
+	state := &entryState{
+		pool:   defaultPool,
+		handle: nextHandle(),
+		data:   data,
+		size:   uint64(len(buf)),
+		alloc:  unownedAllocator{},
+
+		entryPins: &entryPins{},
+	}
+	return RBEntry{data, [][]uint8{buf}, state}
+}
+
+// Pin returns the address of the entry's memory for lending to a C library
+// (zlib, OpenSSL and the like) along with a func to call once the library
+// is done with it. While the entry is pinned, releasing it (or any copy)
+// marks it released as usual but holds on to the memory until the last
+// unpin, so a library still writing to it can't scribble on someone
+// else's buffers. The entry can be pinned any number of times, unpinning
+// more than once does nothing, and pinning a released entry panics.
+func (entry *RBEntry) Pin() (unsafe.Pointer, func()) {
+	state := entry.state
+	if state == nil || entry.data == nil {
+		panic("rustybuffer: pin of a released entry")
+	}
+
+	state.pin_mu.Lock()
+	state.pins++
+	released := state.released.Load()
+	if released {
+		state.pins--
+	}
+	state.pin_mu.Unlock()
+	if released {
+		panic("rustybuffer: pin of a released entry")
+	}
+
+	// The unpin holds on to the entry, so it isn't finalized while it's
+	// pinned.
+	var once sync.Once
+	return state.data, func() {
+		once.Do(func() { state.unpin() })
+	}
+}
+
+// entryPins is whether an entry's been released, and its pins, shared by
+// every copy of the entry. It's allocated apart from the entry's state so
+// that a pool's registries can pin an entry without holding on to the
+// state, which would keep a leaked entry from ever being found.
+type entryPins struct {
+	released atomic.Bool
+
+	// Outstanding pins from RBEntry.Pin, how many of them are read only
+	// views, and whether they were done away with by Pool.ForceRelease.
+	pin_mu sync.Mutex
+	pins   int
+	views  int
+	forced bool
+
+	// The entry, if it was released while pinned, for the last unpin to
+	// give back its memory.
+	release_pinned *entryState
+}
+
+// tryPin pins the entry if it hasn't been released, so its memory can be
+// read until it's unpinned.
+func (pins *entryPins) tryPin() bool {
+	pins.pin_mu.Lock()
+	defer pins.pin_mu.Unlock()
+
+	if pins.released.Load() {
+		return false
+	}
+	pins.pins++
+	return true
+}
+
+// deferRelease reports whether a newly released entry is pinned, in which
+// case the last unpin releases its memory.
+func (state *entryState) deferRelease() bool {
+	state.pin_mu.Lock()
+	defer state.pin_mu.Unlock()
+
+	if state.pins == 0 {
+		return false
+	}
+	state.release_pinned = state
+	return true
+}
+
+func (pins *entryPins) unpin() {
+	pins.pin_mu.Lock()
+	if pins.forced {
+		pins.pin_mu.Unlock()
+		return
+	}
+	pins.pins--
+	var state *entryState
+	if pins.pins == 0 {
+		state, pins.release_pinned = pins.release_pinned, nil
+	}
+	pins.pin_mu.Unlock()
+
+	if state == nil {
+		return
+	}
+	entry := RBEntry{data: state.data, state: state}
+	if err := entry.releaseMemory(); err != nil {
+		panic("a thing broke")
+	}
+}
+
+// unownedAllocator owns the memory of entries that wrap someone else's,
+// from RegisterGoBuffer or ImportHandle, which is to say it doesn't.
+type unownedAllocator struct{}
+
+func (unownedAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	return nil, newError(codeAllocationFailed, "unowned memory can't be acquired")
+}
+
+func (unownedAllocator) Release(data unsafe.Pointer) error {
+	return nil
+}
+
+func (unownedAllocator) Stats() Stats {
+	return Stats{}
+}
@@ -0,0 +1,134 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestRegisterGoBuffer(t *testing.T) {
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	buf := bytes.Repeat([]byte("rustybuffer "), checksumNativeThreshold/4)
+	registered := RegisterGoBuffer(&pinner, buf)
+	if len(registered.Buffers) != 1 || &registered.Buffers[0][0] != &buf[0] {
+		t.Fatal("the entry doesn't wrap the buffer")
+	}
+
+	sum, err := registered.Checksum(ChecksumCRC32C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != goChecksum(ChecksumCRC32C, [][]byte{buf}) {
+		t.Fatalf("unexpected checksum %#x", sum)
+	}
+
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<24, 1<<24)))
+	pooled, err := pool.AllocBuffers([]uint64{uint64(len(buf))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pooled.Release()
+
+	ranges := []Range{{SrcOffset: 0, DstOffset: 0, Length: uint64(len(buf))}}
+	if err := Copy(pooled, registered, ranges); err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(pooled.View(0), registered.View(0)) {
+		t.Fatal("copy doesn't match")
+	}
+
+	// Releasing leaves the Go memory alone, and is safe to do again.
+	registered.Release()
+	registered.Release()
+	if !bytes.HasPrefix(buf, []byte("rustybuffer ")) {
+		t.Fatal("the buffer was modified")
+	}
+
+	empty := RegisterGoBuffer(&pinner, nil)
+	if sum, err := empty.Checksum(ChecksumXXH64); err != nil || sum != goChecksum(ChecksumXXH64, nil) {
+		t.Fatalf("unexpected checksum %#x: %v", sum, err)
+	}
+	empty.Release()
+}
+
+func TestEntryPin(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc))
+
+	entry, err := pool.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	view := entry.View(0)
+
+	data, unpin := entry.Pin()
+	if data != unsafe.Pointer(&entry.Buffers[0][0]) {
+		t.Fatal("pinned the wrong address")
+	}
+	_, unpin_again := entry.Pin()
+
+	// Released, but the memory stays put until it's unpinned.
+	copied := entry
+	entry.Release()
+	copied.Release()
+	if alloc.Stats().BytesInUse != 100 {
+		t.Fatalf("pinned memory was released: %+v", alloc.Stats())
+	}
+	expectPanic(t, "after its entry was released", func() { view.Bytes() })
+	expectPanic(t, "pin of a released entry", func() { copied.Pin() })
+
+	unpin()
+	unpin()
+	if alloc.Stats().BytesInUse != 100 {
+		t.Fatalf("released with a pin outstanding: %+v", alloc.Stats())
+	}
+	unpin_again()
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("unpinning didn't release: %+v", alloc.Stats())
+	}
+
+	// Unpinned first, the release is immediate.
+	entry, err = pool.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, unpin = entry.Pin()
+	unpin()
+	entry.Release()
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", alloc.Stats())
+	}
+}
+
+func TestEntryPinConcurrent(t *testing.T) {
+	alloc := NewHeapAllocator(1<<20, 1<<20)
+	pool := NewPool(WithAllocator(alloc))
+
+	for round := 0; round < 100; round++ {
+		entry, err := pool.AllocBuffers([]uint64{64})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for idx := 0; idx < 4; idx++ {
+			wg.Add(1)
+			go func(entry RBEntry) {
+				defer wg.Done()
+				defer func() { recover() }()
+				_, unpin := entry.Pin()
+				unpin()
+			}(entry)
+		}
+		entry.Release()
+		wg.Wait()
+	}
+
+	if alloc.Stats().BytesInUse != 0 || alloc.Stats().NumBuffers != 0 {
+		t.Fatalf("unexpected stats: %+v", alloc.Stats())
+	}
+}
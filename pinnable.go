@@ -0,0 +1,39 @@
+package rustybuffer
+
+import "unsafe"
+
+// PinnableSlice is a value a storage engine's cgo binding has pinned in
+// its block cache or memtable rather than copied out, e.g., grocksdb's
+// *PinnableSliceHandle: Data is the value, without copying it, and
+// Destroy unpins it.
+type PinnableSlice interface {
+	Data() []byte
+	Destroy()
+}
+
+// AdoptPinnableSlice wraps a pinned value in an entry whose release unpins
+// it, so read paths can hand storage engine values around as entries and
+// views of them like pooled memory. The entry shows up in the pool's call
+// site stats and leak tracking, and with a leak finalizer a value that's
+// never released is unpinned when it's collected, but it isn't counted in
+// the pool's Stats, the memory being the engine's. The value must not be
+// used, through the entry or slice, once the entry is released.
+//
+// An empty value has nothing to point at, so it's unpinned straight away
+// and an empty entry returned.
+func (pool *Pool) AdoptPinnableSlice(slice PinnableSlice) (RBEntry, error) {
+	value := slice.Data()
+	if len(value) == 0 {
+		slice.Destroy()
+		return RBEntry{}, nil
+	}
+
+	data := unsafe.Pointer(unsafe.SliceData(value))
+	size := uint64(len(value))
+	debugAcquired(data, size)
+	state := newEntryState(pool, data, size, &externalAllocator{free: slice.Destroy})
+	if pool.sites != nil {
+		pool.sites.track(data, size, 1)
+	}
+	return RBEntry{data, [][]uint8{value[:len(value):len(value)]}, state}, nil
+}
@@ -0,0 +1,79 @@
+package rustybuffer
+
+import (
+	"log"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// pinnedValue is a PinnableSlice over Go memory.
+type pinnedValue struct {
+	value     []byte
+	destroyed *atomic.Int32
+}
+
+func (pinned pinnedValue) Data() []byte {
+	return pinned.value
+}
+
+func (pinned pinnedValue) Destroy() {
+	pinned.destroyed.Add(1)
+}
+
+func TestAdoptPinnableSlice(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)), WithCallSiteStats())
+
+	var destroyed atomic.Int32
+	entry, err := pool.AdoptPinnableSlice(pinnedValue{[]byte("row value"), &destroyed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.View(0).CopyString() != "row value" {
+		t.Fatalf("unexpected value %q", entry.Buffers[0])
+	}
+	sites := pool.CallSiteStats()
+	if len(sites) != 1 || sites[0].Bytes != 9 || !strings.Contains(sites[0].Site, "TestAdoptPinnableSlice") {
+		t.Fatalf("unexpected call site stats: %+v", sites)
+	}
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("the engine's memory was counted: %+v", pool.Stats())
+	}
+
+	entry.Release()
+	entry.Release()
+	if destroyed.Load() != 1 || len(pool.CallSiteStats()) != 0 {
+		t.Fatalf("expected one unpin, got %d", destroyed.Load())
+	}
+
+	entry, err = pool.AdoptPinnableSlice(pinnedValue{nil, &destroyed})
+	if err != nil || len(entry.Buffers) != 0 || destroyed.Load() != 2 {
+		t.Fatalf("expected an empty value to be unpinned at once: %v", err)
+	}
+}
+
+func leakPinnedValue(pool *Pool, destroyed *atomic.Int32) {
+	if _, err := pool.AdoptPinnableSlice(pinnedValue{make([]byte, 10), destroyed}); err != nil {
+		panic(err)
+	}
+}
+
+func TestAdoptPinnableSliceLeak(t *testing.T) {
+	var output syncBuffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&output)
+
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)), WithLeakFinalizer())
+	var destroyed atomic.Int32
+	leakPinnedValue(pool, &destroyed)
+	for idx := 0; idx < 100 && destroyed.Load() == 0; idx++ {
+		runtime.GC()
+	}
+	if destroyed.Load() != 1 {
+		t.Fatal("expected the leaked value to be unpinned")
+	}
+	if !strings.Contains(output.String(), "leakPinnedValue") {
+		t.Fatalf("expected the leak to be logged: %q", output.String())
+	}
+}
@@ -0,0 +1,338 @@
+package rustybuffer
+
+import (
+	"io"
+	"sync"
+)
+
+// A pipe buffers at most this many chunks, written and not yet read,
+// before its writes block.
+const pipeMaxChunks = 4
+
+// pipe is what a PipeReader and PipeWriter share.
+type pipe struct {
+	pool       *Pool
+	chunk_size uint64
+
+	// Held by a write for as long as it takes, so that concurrent writes
+	// don't interleave.
+	write_mu sync.Mutex
+
+	mu   sync.Mutex
+	cond sync.Cond
+
+	// The chunks written and not yet read, oldest first.
+	chunks []*pipeChunk
+
+	// Set once either end is closed, to what the other end sees.
+	read_err  error
+	write_err error
+}
+
+// pipeChunk is one chunk in a pipe: one the pipe acquired and is copying
+// writes into, or an entry handed over whole by WriteEntry.
+type pipeChunk struct {
+	entry RBEntry
+
+	// The unread bytes, consumed from the front. The chunk being written
+	// to has one buffer, whose capacity runs to the end of the chunk, so
+	// writes extend it.
+	buffers [][]byte
+	open    bool
+}
+
+// unread reports whether the chunk has bytes left to read.
+func (chunk *pipeChunk) unread() bool {
+	for _, buffer := range chunk.buffers {
+		if len(buffer) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// PipeReader is the read half of a pipe, see Pool.Pipe.
+type PipeReader struct {
+	pipe *pipe
+}
+
+// PipeWriter is the write half of a pipe, see Pool.Pipe.
+type PipeWriter struct {
+	pipe *pipe
+}
+
+// Pipe is Pipe on the default pool.
+func Pipe(chunk_size uint64) (*PipeReader, *PipeWriter) {
+	return defaultPool.Pipe(chunk_size)
+}
+
+// Pipe is io.Pipe buffered in chunks of chunk_size bytes from the pool, or
+// 64KiB if it's zero, for streaming between goroutines without copying
+// everything through a heap buffer. Writes are copied into the chunks and
+// return as soon as they're buffered, blocking while the pipe holds as
+// many chunks as it buffers, and reads copy out of them, giving each chunk
+// back to the pool once it's read.
+//
+// Entries can also be passed through the pipe whole, with no copying at
+// all: WriteEntry hands one over to the reader, and ReadEntry hands the
+// reader the next chunk, whichever end filled it. What's read as bytes and
+// what's read as entries can be mixed freely, the pipe carries the one
+// stream of bytes either way.
+//
+// Like io.Pipe's, the halves are safe for concurrent use, parallel writes
+// and parallel reads being run one at a time.
+func (pool *Pool) Pipe(chunk_size uint64) (*PipeReader, *PipeWriter) {
+	if chunk_size == 0 {
+		chunk_size = defaultAppendChunkSize
+	}
+	p := &pipe{pool: pool, chunk_size: chunk_size}
+	p.cond.L = &p.mu
+	return &PipeReader{p}, &PipeWriter{p}
+}
+
+// seal stops the newest chunk being written to, with the lock held.
+func (p *pipe) seal() {
+	if len(p.chunks) > 0 {
+		p.chunks[len(p.chunks)-1].open = false
+	}
+}
+
+// waitForRoom waits, with the lock held, until the pipe can buffer
+// another chunk, or the reader's gone.
+func (p *pipe) waitForRoom() error {
+	for len(p.chunks) >= pipeMaxChunks && p.read_err == nil {
+		p.cond.Wait()
+	}
+	return p.read_err
+}
+
+// Write copies b into the pipe, acquiring chunks as it fills them.
+// Without a reader, it fails with io.ErrClosedPipe or the error the
+// reader was closed with.
+func (w *PipeWriter) Write(b []byte) (int, error) {
+	p := w.pipe
+	p.write_mu.Lock()
+	defer p.write_mu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	written := 0
+	for len(b) > 0 {
+		if p.write_err != nil {
+			return written, io.ErrClosedPipe
+		}
+		if p.read_err != nil {
+			return written, p.read_err
+		}
+
+		if last := len(p.chunks) - 1; last >= 0 && p.chunks[last].open {
+			chunk := p.chunks[last]
+			buffer := chunk.buffers[0]
+			count := copy(buffer[len(buffer):cap(buffer)], b)
+			chunk.buffers[0] = buffer[:len(buffer)+count]
+			chunk.open = len(chunk.buffers[0]) < cap(chunk.buffers[0])
+			written += count
+			b = b[count:]
+			p.cond.Broadcast()
+			continue
+		}
+
+		if err := p.waitForRoom(); err != nil {
+			return written, err
+		}
+
+		// The acquire might block, and the reader has to be able to
+		// drain the pipe meanwhile.
+		p.mu.Unlock()
+		entry, err := p.pool.AllocBuffers([]uint64{p.chunk_size})
+		p.mu.Lock()
+		if err != nil {
+			return written, err
+		}
+		if p.write_err != nil {
+			entry.Release()
+			return written, io.ErrClosedPipe
+		}
+		if p.read_err != nil {
+			entry.Release()
+			return written, p.read_err
+		}
+		p.chunks = append(p.chunks, &pipeChunk{
+			entry:   entry,
+			buffers: [][]byte{entry.Buffers[0][:0:p.chunk_size]},
+			open:    true,
+		})
+	}
+	return written, nil
+}
+
+// WriteEntry hands entry over to the reader, without copying it: its
+// buffers, in order, are the next bytes in the pipe. The pipe owns the
+// entry from then on, even if it fails, in which case it's released.
+func (w *PipeWriter) WriteEntry(entry RBEntry) error {
+	p := w.pipe
+	p.write_mu.Lock()
+	defer p.write_mu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.write_err != nil {
+		entry.Release()
+		return io.ErrClosedPipe
+	}
+	if err := p.waitForRoom(); err != nil {
+		entry.Release()
+		return err
+	}
+
+	p.seal()
+	p.chunks = append(p.chunks, &pipeChunk{
+		entry:   entry,
+		buffers: append([][]byte(nil), entry.Buffers...),
+	})
+	p.cond.Broadcast()
+	return nil
+}
+
+// Close closes the writer: reads get what's buffered and then io.EOF.
+func (w *PipeWriter) Close() error {
+	return w.CloseWithError(nil)
+}
+
+// CloseWithError closes the writer: reads get what's buffered and then
+// err, or io.EOF if it's nil. Closing again does nothing.
+func (w *PipeWriter) CloseWithError(err error) error {
+	if err == nil {
+		err = io.EOF
+	}
+
+	p := w.pipe
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.write_err == nil {
+		p.write_err = err
+	}
+	p.seal()
+	p.cond.Broadcast()
+	return nil
+}
+
+// waitForData waits, with the lock held, until there's something to read
+// or there won't be.
+func (p *pipe) waitForData() error {
+	for {
+		if p.read_err != nil {
+			return io.ErrClosedPipe
+		}
+		for len(p.chunks) > 0 && !p.chunks[0].open && !p.chunks[0].unread() {
+			p.chunks[0].entry.Release()
+			p.chunks = p.chunks[1:]
+			p.cond.Broadcast()
+		}
+		if len(p.chunks) > 0 && p.chunks[0].unread() {
+			return nil
+		}
+		if p.write_err != nil {
+			return p.write_err
+		}
+		p.cond.Wait()
+	}
+}
+
+// Read copies what's next in the pipe into b, waiting until there's
+// something if there isn't. Once the writer is closed and everything
+// buffered has been read, it returns io.EOF or the error the writer was
+// closed with.
+func (r *PipeReader) Read(b []byte) (int, error) {
+	p := r.pipe
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if err := p.waitForData(); err != nil {
+		return 0, err
+	}
+
+	read := 0
+	for read < len(b) && len(p.chunks) > 0 {
+		chunk := p.chunks[0]
+		for len(chunk.buffers) > 0 && read < len(b) {
+			count := copy(b[read:], chunk.buffers[0])
+			chunk.buffers[0] = chunk.buffers[0][count:]
+			read += count
+			if len(chunk.buffers[0]) > 0 || chunk.open {
+				break
+			}
+			chunk.buffers = chunk.buffers[1:]
+		}
+		if chunk.open || chunk.unread() {
+			break
+		}
+		chunk.entry.Release()
+		p.chunks = p.chunks[1:]
+		p.cond.Broadcast()
+	}
+	return read, nil
+}
+
+// ReadEntry hands over the next chunk in the pipe whole, without copying
+// it, as an entry whose buffers hold what's unread of it, waiting until
+// there is one if there isn't. The caller owns the entry and has to
+// release it. Once the writer is closed and everything buffered has been
+// read, it fails like Read.
+func (r *PipeReader) ReadEntry() (RBEntry, error) {
+	p := r.pipe
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.waitForData(); err != nil {
+		return RBEntry{}, err
+	}
+
+	chunk := p.chunks[0]
+	p.chunks = p.chunks[1:]
+	p.cond.Broadcast()
+
+	entry := chunk.entry
+	entry.Buffers = make([][]byte, 0, len(chunk.buffers))
+	for _, buffer := range chunk.buffers {
+		if len(buffer) > 0 {
+			entry.Buffers = append(entry.Buffers, buffer[:len(buffer):len(buffer)])
+		}
+	}
+	return entry, nil
+}
+
+// Close closes the reader, releasing whatever's buffered: writes fail
+// with io.ErrClosedPipe.
+func (r *PipeReader) Close() error {
+	return r.CloseWithError(nil)
+}
+
+// CloseWithError closes the reader, releasing whatever's buffered: writes
+// fail with err, or io.ErrClosedPipe if it's nil. Closing again does
+// nothing.
+func (r *PipeReader) CloseWithError(err error) error {
+	if err == nil {
+		err = io.ErrClosedPipe
+	}
+
+	p := r.pipe
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.read_err == nil {
+		p.read_err = err
+	}
+	for _, chunk := range p.chunks {
+		chunk.entry.Release()
+	}
+	p.chunks = nil
+	p.cond.Broadcast()
+	return nil
+}
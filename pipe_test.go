@@ -0,0 +1,202 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"unsafe"
+)
+
+func TestPipe(t *testing.T) {
+	alloc := NewHeapAllocator(1<<20, 1<<20)
+	pool := NewPool(WithAllocator(alloc))
+	r, w := pool.Pipe(1000)
+
+	data := make([]byte, 100_000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	go func() {
+		// Uneven writes, so chunks fill across them.
+		rest := data
+		for len(rest) > 0 {
+			count := len(rest)
+			if count > 777 {
+				count = 777
+			}
+			if _, err := w.Write(rest[:count]); err != nil {
+				t.Error(err)
+			}
+			rest = rest[count:]
+		}
+		w.Close()
+	}()
+
+	read, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(read, data) {
+		t.Fatalf("unexpected read of %d bytes (%v)", len(read), err)
+	}
+	if stats := alloc.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("expected every chunk to be released, got %+v", stats)
+	}
+}
+
+func TestPipeEntries(t *testing.T) {
+	alloc := NewHeapAllocator(1<<20, 1<<20)
+	pool := NewPool(WithAllocator(alloc))
+	r, w := pool.Pipe(8)
+
+	handed, err := pool.AllocBuffers([]uint64{3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(handed.Buffers[0], "abc")
+	copy(handed.Buffers[1], "defg")
+
+	if _, err := w.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteEntry(handed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("xy")); err != nil {
+		t.Fatal(err)
+	}
+	w.CloseWithError(errors.New("done"))
+
+	// Half read as bytes, the rest of the first chunk comes whole.
+	b := make([]byte, 4)
+	if n, err := r.Read(b); n != 4 || err != nil || string(b) != "0123" {
+		t.Fatalf("unexpected read %q (%v)", b[:n], err)
+	}
+	entry, err := r.ReadEntry()
+	if err != nil || string(flatten(entry)) != "4567" {
+		t.Fatalf("unexpected entry %q (%v)", flatten(entry), err)
+	}
+	entry.Release()
+	if entry, err = r.ReadEntry(); err != nil || string(flatten(entry)) != "89" {
+		t.Fatalf("unexpected entry %q (%v)", flatten(entry), err)
+	}
+	entry.Release()
+
+	// The handed over entry is the one that comes out.
+	if entry, err = r.ReadEntry(); err != nil || entry.Handle() != handed.Handle() || string(flatten(entry)) != "abcdefg" {
+		t.Fatalf("unexpected entry %q (%v)", flatten(entry), err)
+	}
+	entry.Release()
+
+	if read, err := io.ReadAll(r); err == nil || err.Error() != "done" || string(read) != "xy" {
+		t.Fatalf("unexpected read %q (%v)", read, err)
+	}
+	if _, err := r.ReadEntry(); err == nil || err.Error() != "done" {
+		t.Fatalf("expected the writer's error, got %v", err)
+	}
+	if stats := alloc.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("expected every chunk to be released, got %+v", stats)
+	}
+}
+
+func TestPipeBackpressure(t *testing.T) {
+	alloc := NewHeapAllocator(1<<20, 1<<20)
+	r, w := NewPool(WithAllocator(alloc)).Pipe(100)
+
+	done := make(chan error)
+	go func() {
+		_, err := w.Write(make([]byte, 100*pipeMaxChunks+1))
+		done <- err
+	}()
+
+	// The last byte can't be buffered until a chunk's read.
+	b := make([]byte, 100)
+	if n, err := io.ReadFull(r, b); n != 100 || err != nil {
+		t.Fatalf("unexpected read %d (%v)", n, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if stats := alloc.Stats(); stats.BytesInUse != 100*pipeMaxChunks {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestPipeClose(t *testing.T) {
+	alloc := NewHeapAllocator(1<<20, 1<<20)
+	pool := NewPool(WithAllocator(alloc))
+	r, w := pool.Pipe(0)
+
+	if _, err := w.Write([]byte("buffered")); err != nil {
+		t.Fatal(err)
+	}
+	handed, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteEntry(handed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the reader gives back what's buffered and stops the writer.
+	r.CloseWithError(errors.New("gone"))
+	if stats := alloc.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("expected the buffered chunks to be released, got %+v", stats)
+	}
+	if _, err := w.Write([]byte("more")); err == nil || err.Error() != "gone" {
+		t.Fatalf("expected the reader's error, got %v", err)
+	}
+	handed, err = pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteEntry(handed); err == nil || alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("expected the entry to be refused and released, got %v", err)
+	}
+	if _, err := r.Read(make([]byte, 1)); err != io.ErrClosedPipe {
+		t.Fatalf("expected a closed pipe, got %v", err)
+	}
+
+	w.Close()
+	if _, err := w.Write([]byte("more")); err != io.ErrClosedPipe {
+		t.Fatalf("expected a closed pipe, got %v", err)
+	}
+}
+
+// gatedAllocator holds each acquire until it's let through.
+type gatedAllocator struct {
+	Allocator
+	acquiring chan struct{}
+	proceed   chan struct{}
+}
+
+func (alloc gatedAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	alloc.acquiring <- struct{}{}
+	<-alloc.proceed
+	return alloc.Allocator.Acquire(size)
+}
+
+func TestPipeCloseDuringAcquire(t *testing.T) {
+	alloc := NewHeapAllocator(1<<20, 1<<20)
+	gated := gatedAllocator{alloc, make(chan struct{}), make(chan struct{})}
+	r, w := NewPool(WithAllocator(gated)).Pipe(100)
+
+	done := make(chan error)
+	go func() {
+		_, err := w.Write([]byte("late"))
+		done <- err
+	}()
+
+	// The writer's closed while the write waits for its chunk, which it
+	// mustn't then buffer.
+	<-gated.acquiring
+	w.Close()
+	close(gated.proceed)
+	if err := <-done; err != io.ErrClosedPipe {
+		t.Fatalf("expected a closed pipe, got %v", err)
+	}
+	if stats := alloc.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("expected the chunk to be released, got %+v", stats)
+	}
+	if _, err := r.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
@@ -0,0 +1,377 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// The pool used by the package level functions (AllocBuffers, BufferPool,
+// etc.), backed by the Rust library.
+var defaultPool = NewPool()
+
+// Pool carves multi-buffer entries out of memory acquired from an
+// Allocator. Pools are safe for concurrent use, as are allocators (see
+// Allocator), and configured only by NewPool's options.
+type Pool struct {
+	alloc  Allocator
+	policy ExhaustionPolicy
+
+	// Whether acquires are stuck with policy, see NewSecurePool.
+	fixed_policy bool
+
+	// How long a blocked acquire waits before everything defers to it,
+	// see WithBoundedWait. Zero means forever.
+	max_wait time.Duration
+
+	// Where ExhaustionHeap and ExhaustionSpill get their memory.
+	heap  Allocator
+	spill Allocator
+
+	// Requests larger than this skip the allocator and go straight to
+	// spill. Zero means never.
+	spill_threshold uint64
+
+	// Where acquires WithHugePages get their memory.
+	huge_pages *hugePageAllocator
+
+	// Whether entries get a finalizer, see WithLeakFinalizer.
+	finalize atomic.Bool
+
+	// Live entries if the pool tracks leaks, see WithLeakTracking.
+	tracker *leakTracker
+
+	watermarks *watermarks
+
+	// Rate limits on bytes acquired, see WithRateLimit.
+	rate      *tokenBucket
+	tag_rates map[string]*tokenBucket
+
+	// Caps on single acquires, see WithTagMaxBufferSize.
+	tag_max_sizes map[string]uint64
+
+	// What each goroutine holds, if the pool limits it.
+	goroutines *goroutineLimits
+
+	// Every live entry with labels.
+	labels *labelRegistry
+
+	// Where every live entry was acquired, see WithCallSiteStats.
+	sites *siteRegistry
+
+	// Acquires by size, see WithSizeClassStats.
+	size_classes *sizeClassRegistry
+
+	// Acquires by size in the current window, see WithSizeClassLearning.
+	learner *sizeClassLearner
+
+	// Copies of every live entry, see WithShadowVerification.
+	shadows *shadowRegistry
+
+	// Every live entry, see WithCheckpoints.
+	checkpoints *checkpointRegistry
+
+	// Every entry holding memory, see WithEntryDiagnostics.
+	entries *entryRegistry
+
+	// What each tag holds and has held, see WithUsageAccounting.
+	usage *usageAccountant
+
+	// Frozen entries, see WithScrubbing.
+	scrubber *scrubber
+
+	// The locks of entries' buffers, see RBEntry.Lock, once there are any.
+	buffer_locks atomic.Pointer[bufferLockTable]
+
+	// Entries with provenance, see WithAuditTrail.
+	audit *auditTrail
+
+	// Where every live entry is, see WithFaultDiagnostics.
+	faults *faultRegistry
+
+	// Where taint violations go, see WithTaintViolations.
+	taint_report func(TaintViolation)
+
+	// Whether released memory is zeroed and then checked, see
+	// WithVerifiedWipe, and how often the check passed and failed.
+	verify_wipes   bool
+	wipes_verified atomic.Uint64
+	wipes_failed   atomic.Uint64
+
+	// Where released memory waits before it's reused, see WithQuarantine.
+	// It wraps alloc.
+	quarantine *quarantineAllocator
+
+	// Whether acquires and writes are held up, see Quiesce.
+	quiesce quiescence
+
+	// Running totals of AllocBuffers calls, see Pool.Snapshot.
+	acquires         atomic.Uint64
+	acquire_failures atomic.Uint64
+	bytes_acquired   atomic.Uint64
+}
+
+type PoolOption func(pool *Pool)
+
+// WithAllocator selects the Allocator a pool acquires memory from. The
+// default is the Rust library.
+func WithAllocator(alloc Allocator) PoolOption {
+	return func(pool *Pool) {
+		pool.alloc = alloc
+	}
+}
+
+func NewPool(opts ...PoolOption) *Pool {
+	pool := &Pool{
+		alloc:  NewRustAllocator(),
+		policy: ExhaustionError,
+		heap:   NewHeapAllocator(math.MaxUint64, math.MaxInt),
+		spill:  newSpillAllocator(""),
+		labels: newLabelRegistry(),
+
+		huge_pages: newHugePageAllocator(),
+	}
+
+	for _, opt := range opts {
+		opt(pool)
+	}
+	if pool.quarantine != nil {
+		pool.quarantine.Allocator = pool.alloc
+		pool.alloc = pool.quarantine
+	}
+
+	return pool
+}
+
+// AllocBuffers acquires a single allocation large enough for all of sizes
+// and returns an entry with one buffer per size. If the sizes add up to
+// more than can be addressed on this platform it fails with
+// ErrBufferTooLarge. If the pool is full the pool's ExhaustionPolicy
+// applies, unless opts says otherwise. Acquires over the pool's rate
+// limits fail with ErrRateLimited or wait (see WithRateLimit), and those
+// over the goroutine limit fail with ErrGoroutineLimit. While the pool is
+// quiesced acquires wait for it to be resumed, see Quiesce.
+//
+// Either the whole entry is built or nothing is held: if anything fails
+// after the memory was acquired it's released before returning the error.
+func (pool *Pool) AllocBuffers(sizes []uint64, opts ...AcquireOption) (RBEntry, error) {
+	num_bytes, err := totalSize(sizes)
+	if err != nil {
+		pool.acquire_failures.Add(1)
+		return RBEntry{}, err
+	}
+
+	options := pool.acquireOptions(opts)
+	if err := pool.quiesce.admit(options.ctx); err != nil {
+		pool.acquire_failures.Add(1)
+		return RBEntry{}, err
+	}
+	admitted, err := pool.admit(num_bytes, options)
+	if err != nil {
+		pool.acquire_failures.Add(1)
+		return RBEntry{}, err
+	}
+
+	// Zero length buffers all share one address which would muddle the
+	// allocator's bookkeeping, so always ask for at least a byte.
+	alloc, data, err := pool.acquire(max(num_bytes, 1), options)
+	if err != nil {
+		admitted.refund()
+		pool.acquire_failures.Add(1)
+		return RBEntry{}, err
+	}
+
+	buffers, err := carveBuffers(data, max(num_bytes, 1), sizes)
+	if err != nil {
+		if release_err := alloc.Release(data); release_err != nil {
+			err = fmt.Errorf("%w (and releasing it failed: %w)", err, release_err)
+		}
+		releases.notify()
+		admitted.refund()
+		pool.acquire_failures.Add(1)
+		return RBEntry{}, err
+	}
+	debugAcquired(data, max(num_bytes, 1))
+	pool.acquires.Add(1)
+	pool.bytes_acquired.Add(num_bytes)
+	admitted.acquired(data)
+
+	pool.checkWatermarks()
+
+	state := newEntryState(pool, data, num_bytes, alloc)
+	if pool.sites != nil {
+		pool.sites.track(data, num_bytes, 1)
+	}
+	if pool.size_classes != nil {
+		pool.size_classes.track(data, num_bytes)
+	}
+	if pool.learner != nil {
+		pool.learner.registry.track(data, num_bytes)
+	}
+	if pool.checkpoints != nil {
+		pool.checkpoints.track(state, sizes)
+	}
+	if pool.entries != nil {
+		pool.entries.track(state)
+	}
+	if pool.usage != nil {
+		pool.usage.track(data, num_bytes, options.tag, time.Now())
+	}
+	if pool.shadows != nil {
+		pool.shadows.track(state)
+	}
+	if pool.faults != nil {
+		pool.faults.track(state)
+	}
+	if options.labels != nil {
+		labels := &entryLabels{labels: maps.Clone(options.labels)}
+		state.labels.Store(labels)
+		pool.labelled(state, labels)
+	}
+	if options.taint != nil {
+		state.taint.Store(options.taint)
+	}
+	if options.provenance != nil {
+		provenance := *options.provenance
+		provenance.Acquired = time.Now()
+		state.provenance = &provenance
+		if pool.audit != nil {
+			pool.audit.track(state)
+		}
+	}
+
+	return RBEntry{data, buffers, state}, nil
+}
+
+// forget drops everything the pool knows about a released entry besides
+// leak tracking, returning its labels.
+func (pool *Pool) forget(data unsafe.Pointer) map[string]string {
+	if pool.goroutines != nil {
+		pool.goroutines.forget(data)
+	}
+	if pool.sites != nil {
+		pool.sites.forget(data)
+	}
+	if pool.size_classes != nil {
+		pool.size_classes.forget(data)
+	}
+	if pool.learner != nil {
+		pool.learner.registry.forget(data)
+	}
+	if pool.checkpoints != nil {
+		pool.checkpoints.forget(data)
+	}
+	if pool.entries != nil {
+		pool.entries.forget(data)
+	}
+	if pool.usage != nil {
+		pool.usage.forget(data, time.Now())
+	}
+	if pool.scrubber != nil {
+		pool.scrubber.forget(data)
+	}
+	if table := pool.buffer_locks.Load(); table != nil {
+		table.forgetLocks(data)
+	}
+	if pool.faults != nil {
+		pool.faults.forget(data)
+	}
+	if pool.shadows != nil {
+		pool.shadows.forget(data)
+	}
+	return pool.labels.forget(data)
+}
+
+// WithEntry allocates an entry for fn and releases it once fn returns,
+// even if it panics (the panic then carries on up the stack). The entry
+// must not be used after fn returns.
+func (pool *Pool) WithEntry(sizes []uint64, fn func(entry RBEntry) error) error {
+	entry, err := pool.AllocBuffers(sizes)
+	if err != nil {
+		return err
+	}
+	defer entry.Release()
+
+	return fn(entry)
+}
+
+// carveBuffers slices one buffer per size out of the num_bytes at data,
+// making sure none of them would reach past the end.
+func carveBuffers(data unsafe.Pointer, num_bytes uint64, sizes []uint64) ([][]uint8, error) {
+	if data == nil {
+		return nil, newError(codeAllocationFailed,
+			"the allocator returned nil for %d bytes", num_bytes)
+	}
+
+	// Slicing (rather than unsafe.Add) keeps zero length buffers at the end
+	// from pointing one past the allocation, which the GC rejects if the
+	// memory came from the Go heap.
+	whole := unsafe.Slice((*uint8)(data), num_bytes)
+
+	var curr_offset uint64 = 0
+	var buffers = make([][]uint8, len(sizes))
+	for idx, size := range sizes {
+		if size > num_bytes-curr_offset {
+			return nil, newError(codeBufferTooLarge,
+				"buffer %d of %d bytes at offset %d overruns the %d byte entry",
+				idx, size, curr_offset, num_bytes)
+		}
+
+		end := curr_offset + size
+		buffers[idx] = whole[curr_offset:end:end]
+		curr_offset = end
+
+		if debugAssertions {
+			start := uintptr(unsafe.Pointer(unsafe.SliceData(buffers[idx])))
+			assertf(start >= uintptr(data) && start+uintptr(size) <= uintptr(data)+uintptr(num_bytes),
+				"buffer %d at %#x of %d bytes is outside the %d bytes at %p",
+				idx, start, size, num_bytes, data)
+		}
+	}
+
+	if debugAssertions {
+		assertf(curr_offset <= num_bytes,
+			"buffers end at offset %d of a %d byte entry", curr_offset, num_bytes)
+	}
+
+	return buffers, nil
+}
+
+// totalSize sums sizes, refusing totals that would wrap or that don't fit
+// in an int (and so can't be sliced), which on 32-bit platforms is a lot
+// less than a uint64. Every offset and individual size within the entry is
+// then in range too.
+func totalSize(sizes []uint64) (uint64, error) {
+	var num_bytes uint64 = 0
+	for _, size := range sizes {
+		sum, carry := bits.Add64(num_bytes, size, 0)
+		if carry != 0 || sum > math.MaxInt {
+			return 0, newError(codeBufferTooLarge,
+				"buffer sizes add up to more than %d bytes", uint64(math.MaxInt))
+		}
+		num_bytes = sum
+	}
+
+	return num_bytes, nil
+}
+
+func (pool *Pool) Stats() Stats {
+	stats := pool.alloc.Stats()
+	stats.FallbackBytes = pool.heap.Stats().BytesInUse
+	stats.SpilledBytes = pool.spill.Stats().BytesInUse
+	stats.HugePageBytes = pool.huge_pages.Stats().BytesInUse
+	stats.WipesVerified = pool.wipes_verified.Load()
+	stats.WipesFailed = pool.wipes_failed.Load()
+	if pool.size_classes != nil {
+		stats.SizeClasses = pool.size_classes.stats()
+	}
+	if pool.quarantine != nil {
+		pool.quarantine.stats(&stats)
+	}
+	stats.WaitQueue = waiting.stats(pool)
+	return stats
+}
@@ -0,0 +1,189 @@
+package rustybuffer
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"unsafe"
+)
+
+func TestPoolAllocBuffers(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024*1024, 1024*1024)))
+
+	entry, err := pool.AllocBuffers([]uint64{5, 10, 0, 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entry.Buffers) != 4 {
+		t.Fatalf("expected 4 buffers, got %d", len(entry.Buffers))
+	}
+	var offset uintptr = 0
+	for idx, size := range []int{5, 10, 0, 15} {
+		buf := entry.Buffers[idx]
+		if len(buf) != size {
+			t.Fatalf("buffer %d has length %d", idx, len(buf))
+		}
+		ptr := uintptr(unsafe.Pointer(unsafe.SliceData(buf)))
+		if size > 0 && ptr != uintptr(entry.data)+offset {
+			t.Fatalf("buffer %d is not at offset %d", idx, offset)
+		}
+		offset += uintptr(size)
+	}
+
+	if pool.Stats().BytesInUse != 30 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+
+	entry.Release()
+	if entry.data != nil || len(entry.Buffers) != 0 {
+		t.Fatal("release did not clear the entry")
+	}
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+
+	// Releasing again is a no-op.
+	entry.Release()
+}
+
+func TestPoolEmptyEntry(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024*1024, 1024*1024)))
+
+	first, err := pool.AllocBuffers(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.AllocBuffers([]uint64{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.data == second.data {
+		t.Fatal("empty entries must not share an address")
+	}
+	first.Release()
+	second.Release()
+}
+
+func TestPoolErrors(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024*1024, 1024)))
+
+	_, err := pool.AllocBuffers([]uint64{1000, 1000})
+	if !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+}
+
+func TestPoolSizeOverflow(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(math.MaxUint64, math.MaxUint64)))
+
+	cases := [][]uint64{
+		{math.MaxUint64, 2},
+		{1 << 63, 1 << 63},
+		{math.MaxInt, 1},
+	}
+	for _, sizes := range cases {
+		_, err := pool.AllocBuffers(sizes)
+		if !errors.Is(err, ErrBufferTooLarge) {
+			t.Fatalf("expected ErrBufferTooLarge for %v, got %v", sizes, err)
+		}
+	}
+
+	if pool.Stats().NumBuffers != 0 {
+		t.Fatal("nothing should have been allocated")
+	}
+}
+
+// nilAllocator is broken, claiming to have acquired memory at nil.
+type nilAllocator struct {
+	Allocator
+	released int
+}
+
+func (alloc *nilAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	return nil, nil
+}
+
+func (alloc *nilAllocator) Release(data unsafe.Pointer) error {
+	alloc.released++
+	return nil
+}
+
+func TestPoolRollback(t *testing.T) {
+	alloc := &nilAllocator{}
+	pool := NewPool(WithAllocator(alloc))
+
+	_, err := pool.AllocBuffers([]uint64{10})
+	if !errors.Is(err, ErrAllocationFailed) {
+		t.Fatalf("expected ErrAllocationFailed, got %v", err)
+	}
+	if alloc.released != 1 {
+		t.Fatal("the failed acquire wasn't released")
+	}
+
+	data := unsafe.Pointer(unsafe.SliceData(make([]byte, 10)))
+	_, err = carveBuffers(data, 10, []uint64{5, 6})
+	if !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+}
+
+func TestPoolWithEntry(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	expected := errors.New("oops")
+	err := pool.WithEntry([]uint64{10, 20}, func(entry RBEntry) error {
+		if len(entry.Buffers) != 2 || pool.Stats().BytesInUse != 30 {
+			t.Fatalf("unexpected entry: %v", entry.Buffers)
+		}
+		return expected
+	})
+	if err != expected {
+		t.Fatalf("expected the callback's error, got %v", err)
+	}
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("entry wasn't released: %+v", pool.Stats())
+	}
+
+	func() {
+		defer func() {
+			if recover() != "boom" {
+				t.Fatal("the panic wasn't passed on")
+			}
+		}()
+		pool.WithEntry([]uint64{10}, func(entry RBEntry) error {
+			panic("boom")
+		})
+	}()
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("entry wasn't released: %+v", pool.Stats())
+	}
+
+	_, err = pool.AllocBuffers([]uint64{1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pool.WithEntry([]uint64{10}, func(entry RBEntry) error {
+		t.Fatal("called without an entry")
+		return nil
+	})
+	if !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+}
+
+func TestPoolTrailingEmptyBuffer(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	entry, err := pool.AllocBuffers([]uint64{10, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	// A pointer one past the end of a Go heap allocation crashes the GC.
+	ptr := uintptr(unsafe.Pointer(unsafe.SliceData(entry.Buffers[1])))
+	if ptr >= uintptr(entry.data)+10 {
+		t.Fatal("empty buffer points past the end of the entry")
+	}
+}
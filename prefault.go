@@ -0,0 +1,63 @@
+package rustybuffer
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Prefault faults in every page of the entry's memory now, so the first
+// write to each doesn't take a page fault in the middle of whatever
+// latency sensitive work the entry is for. On Linux the kernel populates
+// the pages in one madvise call where it can (5.14 and later), and
+// elsewhere, or failing that, a word of each page is touched in a way
+// that doesn't change it, even with another goroutine writing to it.
+// Memory that isn't the pool's own (see AdoptExternal and
+// RegisterGoBuffer) may be read only, so it's only read, which faults it
+// in without making it writable. Prefaulting a released entry is an error.
+func (entry *RBEntry) Prefault() error {
+	if entry.data == nil || (entry.state != nil && entry.state.released.Load()) {
+		return errors.New("rustybuffer: prefault of a released entry")
+	}
+	if entry.state == nil || entry.state.size == 0 {
+		return nil
+	}
+
+	writable := true
+	switch entry.state.alloc.(type) {
+	case *externalAllocator, unownedAllocator:
+		writable = false
+	}
+
+	// Every page holding some of the entry, which are all mapped.
+	page := uintptr(os.Getpagesize())
+	start := uintptr(entry.data) &^ (page - 1)
+	end := (uintptr(entry.data) + uintptr(entry.state.size) + page - 1) &^ (page - 1)
+	if populatePages(start, end-start, writable) {
+		return nil
+	}
+
+	touchPages(entry.data, entry.state.size, writable)
+	return nil
+}
+
+// touchPages reads or, if writable, atomically adds zero to a word in each
+// page of the size bytes at data, that's aligned and within them.
+func touchPages(data unsafe.Pointer, size uint64, writable bool) {
+	page := uintptr(os.Getpagesize())
+	first := uintptr(data)
+	last := first + uintptr(size)
+	for at := first &^ (page - 1); at < last; at += page {
+		word := (max(at, first) + 3) &^ 3
+		if word+4 > last {
+			continue
+		}
+		addr := (*uint32)(unsafe.Add(data, word-first))
+		if writable {
+			atomic.AddUint32(addr, 0)
+		} else {
+			atomic.LoadUint32(addr)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package rustybuffer
+
+import "syscall"
+
+// MADV_POPULATE_READ and MADV_POPULATE_WRITE, which the syscall package
+// doesn't define.
+const (
+	madvPopulateRead  = 22
+	madvPopulateWrite = 23
+)
+
+// populatePages has the kernel fault in the size bytes of pages at addr,
+// reporting whether it could.
+func populatePages(addr uintptr, size uintptr, writable bool) bool {
+	advice := madvPopulateRead
+	if writable {
+		advice = madvPopulateWrite
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_MADVISE, addr, size, uintptr(advice))
+	return errno == 0
+}
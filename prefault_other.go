@@ -0,0 +1,9 @@
+//go:build !linux
+
+package rustybuffer
+
+// populatePages can't have the kernel fault in pages on this platform, so
+// they're touched instead.
+func populatePages(addr uintptr, size uintptr, writable bool) bool {
+	return false
+}
@@ -0,0 +1,62 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+func TestPrefault(t *testing.T) {
+	for name, pool := range map[string]*Pool{
+		"rust": NewPool(),
+		"heap": NewPool(WithAllocator(NewHeapAllocator(1<<24, 1<<24))),
+	} {
+		entry, err := pool.AllocBuffers([]uint64{3, uint64(os.Getpagesize()) * 5, 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fillPattern(entry, 3)
+		before := flatten(entry)
+
+		if err := entry.Prefault(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(flatten(entry), before) {
+			t.Fatalf("%s: prefaulting changed the entry", name)
+		}
+
+		entry.Release()
+		if err := entry.Prefault(); err == nil {
+			t.Fatalf("%s: expected prefaulting a released entry to fail", name)
+		}
+	}
+}
+
+func TestTouchPages(t *testing.T) {
+	buf := make([]byte, os.Getpagesize()*3+13)
+	for idx := range buf {
+		buf[idx] = byte(idx * 7)
+	}
+	before := append([]byte(nil), buf...)
+
+	// Unaligned starts, lengths too short for a word and the whole thing.
+	for _, rng := range [][2]int{{0, len(buf)}, {1, len(buf)}, {5, 8}, {len(buf) - 3, len(buf)}} {
+		for _, writable := range []bool{true, false} {
+			part := buf[rng[0]:rng[1]]
+			touchPages(unsafe.Pointer(unsafe.SliceData(part)), uint64(len(part)), writable)
+		}
+	}
+	if !bytes.Equal(buf, before) {
+		t.Fatal("touching changed the memory")
+	}
+
+	// Memory that isn't the pool's is only read.
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	entry := RegisterGoBuffer(&pinner, buf)
+	if err := entry.Prefault(); err != nil {
+		t.Fatal(err)
+	}
+}
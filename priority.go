@@ -0,0 +1,242 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Priority orders acquires blocked by ExhaustionBlock on the same pool:
+// while a higher priority acquire is waiting, lower priority ones leave
+// whatever's released to it. Acquires of the same priority all try again
+// on every release, as they always have.
+type Priority int
+
+const (
+	// PriorityLow is for background and batch work that can wait.
+	PriorityLow Priority = -1
+
+	// PriorityNormal is the default.
+	PriorityNormal Priority = 0
+
+	// PriorityHigh is for latency critical requests.
+	PriorityHigh Priority = 1
+)
+
+func (priority Priority) String() string {
+	switch priority {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(priority))
+	}
+}
+
+// WithPriority sets the Priority of one acquire. It only matters with
+// ExhaustionBlock.
+//
+// So that a steady stream of high priority work can't starve everything
+// else, a blocked acquire goes up a priority for every priorityAging it's
+// been waiting, up to PriorityHigh, or past it, see WithBoundedWait.
+func WithPriority(priority Priority) AcquireOption {
+	return func(opts *acquireOptions) {
+		opts.priority = priority
+	}
+}
+
+// WithBoundedWait makes the pool's wait queue fair for a flood of high
+// priority traffic from one tag (see WithTag) that would otherwise have
+// everything else racing it for each release, however long it's waited.
+// An acquire blocked by ExhaustionBlock for max_wait outranks every other
+// acquire, of any priority, so that nothing new can take memory ahead of
+// it; only others waiting as long compete with it. And until then, a
+// waiter whose tag is outnumbered in the queue ages faster, by as many
+// times as there are waiters on the pool for each of its tag's, so a
+// minority tag comes up for its turn before the majority does.
+func WithBoundedWait(max_wait time.Duration) PoolOption {
+	return func(pool *Pool) {
+		pool.max_wait = max_wait
+	}
+}
+
+// How long a blocked acquire waits before it's treated as a priority
+// higher. A variable so the tests don't have to wait as long.
+var priorityAging = 100 * time.Millisecond
+
+// waiter is an acquire that's blocked, or deferring to one that outranks
+// it.
+type waiter struct {
+	pool     *Pool
+	priority Priority
+	tag      string
+	size     uint64
+	since    time.Time
+}
+
+// starving reports whether w has waited its pool's WithBoundedWait.
+func (w *waiter) starving(now time.Time) bool {
+	return w.pool.max_wait != 0 && now.Sub(w.since) >= w.pool.max_wait
+}
+
+// effective is the waiter's priority after aging, which goes share times
+// as fast as usual.
+func (w *waiter) effective(now time.Time, share int) Priority {
+	if w.starving(now) {
+		return max(w.priority, PriorityHigh) + 1
+	}
+	waited := Priority(now.Sub(w.since) * time.Duration(share) / priorityAging)
+	return min(w.priority+waited, max(w.priority, PriorityHigh))
+}
+
+// waitQueue is every waiter, whichever pool it's waiting on.
+type waitQueue struct {
+	mu      sync.Mutex
+	waiters map[*waiter]struct{}
+
+	// How many waiters there are, so acquires needn't take the lock when
+	// there aren't any.
+	count atomic.Int64
+}
+
+var waiting waitQueue
+
+func (queue *waitQueue) join(pool *Pool, options acquireOptions, size uint64) *waiter {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if queue.waiters == nil {
+		queue.waiters = make(map[*waiter]struct{})
+	}
+	w := &waiter{pool, options.priority, options.tag, size, time.Now()}
+	queue.waiters[w] = struct{}{}
+	queue.count.Add(1)
+
+	return w
+}
+
+// leave removes w, and wakes anything that was deferring to it so it can
+// have the memory w didn't need.
+func (queue *waitQueue) leave(w *waiter) {
+	queue.mu.Lock()
+	delete(queue.waiters, w)
+	queue.count.Add(-1)
+	queue.mu.Unlock()
+
+	releases.notify()
+}
+
+// outranked reports whether anything waiting on pool has a higher
+// effective priority than self, or than a new acquire of priority if self
+// is nil.
+func (queue *waitQueue) outranked(pool *Pool, priority Priority, self *waiter) bool {
+	count := queue.count.Load()
+	if count == 0 || (count == 1 && self != nil) {
+		return false
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	shares := queue.sharesLocked(pool)
+	now := time.Now()
+	mine := priority
+	if self != nil {
+		mine = self.effective(now, shares[self.tag])
+	}
+	for w := range queue.waiters {
+		if w != self && w.pool == pool && w.effective(now, shares[w.tag]) > mine {
+			return true
+		}
+	}
+	return false
+}
+
+// sharesLocked returns how much faster than usual each tag's waiters on
+// pool age: one time for all of them without WithBoundedWait.
+func (queue *waitQueue) sharesLocked(pool *Pool) map[string]int {
+	tags := make(map[string]int)
+	total := 0
+	for w := range queue.waiters {
+		if w.pool == pool {
+			tags[w.tag]++
+			total++
+		}
+	}
+	for tag, count := range tags {
+		if pool.max_wait == 0 {
+			tags[tag] = 1
+		} else {
+			tags[tag] = total / count
+		}
+	}
+	return tags
+}
+
+// WaitQueueStats is what's waiting on a pool with one tag and priority,
+// see Stats.WaitQueue.
+type WaitQueueStats struct {
+	Tag      string
+	Priority Priority
+
+	// The acquires waiting, the bytes they're waiting for, and how long
+	// the first of them has been.
+	Waiters     int
+	Bytes       uint64
+	LongestWait time.Duration
+
+	// The waiters that have waited the pool's WithBoundedWait, which
+	// everything else defers to.
+	Starving int
+}
+
+// stats returns what's waiting on pool, highest priority first, then by
+// tag.
+func (queue *waitQueue) stats(pool *Pool) []WaitQueueStats {
+	if queue.count.Load() == 0 {
+		return nil
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	type key struct {
+		tag      string
+		priority Priority
+	}
+	now := time.Now()
+	groups := make(map[key]*WaitQueueStats)
+	for w := range queue.waiters {
+		if w.pool != pool {
+			continue
+		}
+		group := groups[key{w.tag, w.priority}]
+		if group == nil {
+			group = &WaitQueueStats{Tag: w.tag, Priority: w.priority}
+			groups[key{w.tag, w.priority}] = group
+		}
+		group.Waiters++
+		group.Bytes += w.size
+		group.LongestWait = max(group.LongestWait, now.Sub(w.since))
+		if w.starving(now) {
+			group.Starving++
+		}
+	}
+
+	var stats []WaitQueueStats
+	for _, group := range groups {
+		stats = append(stats, *group)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Priority != stats[j].Priority {
+			return stats[i].Priority > stats[j].Priority
+		}
+		return stats[i].Tag < stats[j].Tag
+	})
+	return stats
+}
@@ -0,0 +1,256 @@
+package rustybuffer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitForWaiters waits until count acquires are blocked.
+func waitForWaiters(t *testing.T, count int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for waiting.count.Load() != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d waiters, have %d", count, waiting.count.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type priorityResult struct {
+	priority Priority
+	entry    RBEntry
+	err      error
+}
+
+func acquireWithPriority(pool *Pool, size uint64, priority Priority, opts ...AcquireOption) <-chan priorityResult {
+	results := make(chan priorityResult, 1)
+	go func() {
+		opts = append(opts, WithPriority(priority))
+		entry, err := pool.AllocBuffers([]uint64{size}, opts...)
+		results <- priorityResult{priority, entry, err}
+	}()
+	return results
+}
+
+func TestPriorityOrder(t *testing.T) {
+	defer func(aging time.Duration) { priorityAging = aging }(priorityAging)
+	priorityAging = time.Hour
+
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithExhaustionPolicy(ExhaustionBlock))
+
+	held, err := pool.AllocBuffers([]uint64{1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The low priority acquire has been waiting longer, but there's only
+	// room for one of them.
+	low := acquireWithPriority(pool, 600, PriorityLow)
+	waitForWaiters(t, 1)
+	high := acquireWithPriority(pool, 600, PriorityHigh)
+	waitForWaiters(t, 2)
+
+	held.Release()
+
+	result := <-high
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	select {
+	case result := <-low:
+		t.Fatalf("low priority acquire wasn't blocked: %v", result.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	result.entry.Release()
+	result = <-low
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	result.entry.Release()
+
+	waitForWaiters(t, 0)
+}
+
+func TestPriorityNewAcquiresDefer(t *testing.T) {
+	defer func(aging time.Duration) { priorityAging = aging }(priorityAging)
+	priorityAging = time.Hour
+
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithExhaustionPolicy(ExhaustionBlock))
+
+	held, err := pool.AllocBuffers([]uint64{1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release()
+
+	high := acquireWithPriority(pool, 1024, PriorityHigh)
+	waitForWaiters(t, 1)
+
+	// There's room for this, but it has to wait behind the high priority
+	// acquire, until its context runs out.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = pool.AllocBuffers([]uint64{10}, WithContext(ctx))
+	if !errors.Is(err, ErrNoBufferAvailable) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ErrNoBufferAvailable and a timeout, got %v", err)
+	}
+
+	// Other policies aren't affected.
+	entry, err := pool.AllocBuffers([]uint64{10}, OnExhaustion(ExhaustionError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+
+	held.Release()
+	result := <-high
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	result.entry.Release()
+}
+
+func TestPriorityAging(t *testing.T) {
+	defer func(aging time.Duration) { priorityAging = aging }(priorityAging)
+	priorityAging = 20 * time.Millisecond
+
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithExhaustionPolicy(ExhaustionBlock))
+
+	held, err := pool.AllocBuffers([]uint64{1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := pool.AllocBuffers([]uint64{24})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The high priority acquire can't be satisfied while held is, but once
+	// the low priority one has waited long enough it's let through anyway.
+	ctx, cancel := context.WithCancel(context.Background())
+	high := acquireWithPriority(pool, 1024, PriorityHigh, WithContext(ctx))
+	waitForWaiters(t, 1)
+	low := acquireWithPriority(pool, 24, PriorityLow)
+	waitForWaiters(t, 2)
+
+	small.Release()
+
+	select {
+	case result := <-low:
+		if result.err != nil {
+			t.Fatal(result.err)
+		}
+		result.entry.Release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("low priority acquire was starved")
+	}
+
+	cancel()
+	if result := <-high; !errors.Is(result.err, context.Canceled) {
+		t.Fatalf("expected the high priority acquire to be cancelled, got %v", result.err)
+	}
+	held.Release()
+
+	waitForWaiters(t, 0)
+}
+
+func TestPriorityString(t *testing.T) {
+	for priority, expected := range map[Priority]string{
+		PriorityLow:    "low",
+		PriorityNormal: "normal",
+		PriorityHigh:   "high",
+		Priority(5):    "Priority(5)",
+	} {
+		if priority.String() != expected {
+			t.Fatalf("expected %q, got %q", expected, priority.String())
+		}
+	}
+}
+
+func TestBoundedWait(t *testing.T) {
+	defer func(aging time.Duration) { priorityAging = aging }(priorityAging)
+	priorityAging = time.Hour
+
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithExhaustionPolicy(ExhaustionBlock), WithBoundedWait(20*time.Millisecond))
+
+	held, err := pool.AllocBuffers([]uint64{1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	low := acquireWithPriority(pool, 600, PriorityLow, WithTag("batch"))
+	waitForWaiters(t, 1)
+	time.Sleep(30 * time.Millisecond)
+
+	stats := pool.Stats().WaitQueue
+	if len(stats) != 1 || stats[0].Tag != "batch" || stats[0].Priority != PriorityLow ||
+		stats[0].Waiters != 1 || stats[0].Bytes != 600 || stats[0].Starving != 1 || stats[0].LongestWait < 20*time.Millisecond {
+		t.Fatalf("unexpected wait queue %+v", stats)
+	}
+
+	// Having waited its bound, the low priority acquire holds off even
+	// high priority ones there's room for.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = pool.AllocBuffers([]uint64{10}, WithPriority(PriorityHigh), WithContext(ctx))
+	if !errors.Is(err, ErrNoBufferAvailable) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the high priority acquire to wait, got %v", err)
+	}
+
+	held.Release()
+	result := <-low
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	result.entry.Release()
+
+	waitForWaiters(t, 0)
+	if stats := pool.Stats().WaitQueue; stats != nil {
+		t.Fatalf("expected an empty wait queue, got %+v", stats)
+	}
+}
+
+func TestBoundedWaitTagAging(t *testing.T) {
+	fair := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)), WithBoundedWait(time.Hour))
+	unfair := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	var queue waitQueue
+	var minorities []*waiter
+	for _, pool := range []*Pool{fair, unfair} {
+		minorities = append(minorities, queue.join(pool, acquireOptions{priority: PriorityLow, tag: "minority"}, 10))
+		for i := 0; i < 3; i++ {
+			queue.join(pool, acquireOptions{priority: PriorityHigh, tag: "flood"}, 10)
+		}
+	}
+
+	if shares := queue.sharesLocked(fair); shares["minority"] != 4 || shares["flood"] != 1 {
+		t.Fatalf("unexpected shares %v", shares)
+	}
+	if shares := queue.sharesLocked(unfair); shares["minority"] != 1 || shares["flood"] != 1 {
+		t.Fatalf("unexpected shares without WithBoundedWait %v", shares)
+	}
+
+	// Outnumbered four to one, the minority tag ages four times as fast.
+	minority := minorities[0]
+	now := minority.since.Add(priorityAging / 2)
+	if priority := minority.effective(now, 4); priority != PriorityHigh {
+		t.Fatalf("expected the minority waiter to have aged to high, got %v", priority)
+	}
+	if priority := minority.effective(now, 1); priority != PriorityLow {
+		t.Fatalf("expected the waiter not to have aged yet, got %v", priority)
+	}
+	if priority := minority.effective(now.Add(time.Hour), 1); priority != PriorityHigh+1 {
+		t.Fatalf("expected the waiter to be starving, got %v", priority)
+	}
+	if priority := minorities[1].effective(now.Add(time.Hour), 1); priority != PriorityHigh {
+		t.Fatalf("expected no starving without WithBoundedWait, got %v", priority)
+	}
+}
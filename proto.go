@@ -0,0 +1,81 @@
+package rustybuffer
+
+import (
+	"io"
+	"unsafe"
+)
+
+// MarshalAppend runs an append-style encoder into a pooled buffer with room
+// for size bytes. It's intended for protobuf's MarshalAppend:
+//
+//	opts := proto.MarshalOptions{}
+//	entry, data, err := rustybuffer.MarshalAppend(opts.Size(msg),
+//		func(b []byte) ([]byte, error) { return opts.MarshalAppend(b, msg) })
+//	defer entry.Release()
+//
+// The returned data aliases the entry and is only valid until it is
+// released. If the encoder outgrows size and reallocates, the pooled buffer
+// is released and the (heap) result is returned with an empty entry.
+func MarshalAppend(
+	size int,
+	marshal func(b []byte) ([]byte, error),
+) (RBEntry, []byte, error) {
+	if size <= 0 {
+		data, err := marshal(nil)
+		return RBEntry{}, data, err
+	}
+
+	entry, err := allocBuffers([]uint64{uint64(size)})
+	if err != nil {
+		return RBEntry{}, nil, err
+	}
+
+	buf := entry.Buffers[0]
+	data, err := marshal(buf[:0])
+	if err != nil {
+		entry.Release()
+		return RBEntry{}, nil, err
+	}
+
+	if unsafe.SliceData(data) != unsafe.SliceData(buf) {
+		entry.Release()
+		return RBEntry{}, data, nil
+	}
+
+	return entry, data, nil
+}
+
+// UnmarshalFrom reads exactly size bytes of wire data from r into pooled
+// scratch space and passes them to unmarshal, e.g. proto.Unmarshal or
+// proto.UnmarshalOptions.Unmarshal bound to a message.
+//
+// The entry holding the wire bytes is returned so that decoders which
+// alias their input (vtprotobuf's UnmarshalVTUnsafe and friends) can keep
+// using it; release it once the message is no longer needed.
+func UnmarshalFrom(
+	r io.Reader,
+	size int,
+	unmarshal func(b []byte) error,
+) (RBEntry, error) {
+	if size <= 0 {
+		return RBEntry{}, unmarshal(nil)
+	}
+
+	entry, err := allocBuffers([]uint64{uint64(size)})
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	buf := entry.Buffers[0]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		entry.Release()
+		return RBEntry{}, err
+	}
+
+	if err := unmarshal(buf); err != nil {
+		entry.Release()
+		return RBEntry{}, err
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,84 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"unsafe"
+)
+
+func TestMarshalAppend(t *testing.T) {
+	Configure(64*1024*1024, 1024*1024)
+
+	values := []uint64{1, 300, 70000}
+	size := 0
+	for _, v := range values {
+		size += binary.PutUvarint(make([]byte, binary.MaxVarintLen64), v)
+	}
+
+	entry, data, err := MarshalAppend(size, func(b []byte) ([]byte, error) {
+		for _, v := range values {
+			b = binary.AppendUvarint(b, v)
+		}
+		return b, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	if entry.data == nil {
+		t.Fatal("expected a pooled entry")
+	}
+	if unsafe.SliceData(data) != unsafe.SliceData(entry.Buffers[0]) {
+		t.Fatal("expected data to alias the pooled buffer")
+	}
+	if len(data) != size {
+		t.Fatalf("expected %d bytes, got %d", size, len(data))
+	}
+}
+
+func TestMarshalAppendOutgrowsSize(t *testing.T) {
+	Configure(64*1024*1024, 1024*1024)
+
+	entry, data, err := MarshalAppend(2, func(b []byte) ([]byte, error) {
+		return append(b, "more than two bytes"...), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.data != nil {
+		t.Fatal("expected the pooled buffer to be released")
+	}
+	if string(data) != "more than two bytes" {
+		t.Fatalf("unexpected data: %q", data)
+	}
+}
+
+func TestUnmarshalFrom(t *testing.T) {
+	Configure(64*1024*1024, 1024*1024)
+
+	wire := []byte("hello pooled world")
+	var got string
+	entry, err := UnmarshalFrom(bytes.NewReader(wire), len(wire),
+		func(b []byte) error {
+			got = string(b)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	if got != string(wire) {
+		t.Fatalf("unexpected payload: %q", got)
+	}
+
+	_, err = UnmarshalFrom(bytes.NewReader(wire), len(wire)+1,
+		func(b []byte) error { return nil })
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected a short read error, got %v", err)
+	}
+}
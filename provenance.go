@@ -0,0 +1,154 @@
+package rustybuffer
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Provenance is where an entry's data came from, for compliance audits,
+// see WithProvenance.
+type Provenance struct {
+	// The system the data came from, e.g., "billing-db".
+	Source string `json:"source"`
+
+	// How sensitive it is, e.g., "pii" or "confidential".
+	Classification string `json:"classification"`
+
+	// When the entry was acquired, recorded by the pool.
+	Acquired time.Time `json:"acquired"`
+}
+
+// WithProvenance records where the acquired entry's data comes from and
+// how it's classified. Pools created with WithAuditTrail audit it.
+func WithProvenance(source string, classification string) AcquireOption {
+	return func(opts *acquireOptions) {
+		opts.provenance = &Provenance{Source: source, Classification: classification}
+	}
+}
+
+// Provenance returns what WithProvenance recorded for the entry, if the
+// entry was acquired with it.
+func (entry *RBEntry) Provenance() (Provenance, bool) {
+	if entry.state == nil || entry.state.provenance == nil {
+		return Provenance{}, false
+	}
+	return *entry.state.provenance, true
+}
+
+// AuditRecord is one entry in an AuditReport.
+type AuditRecord struct {
+	Handle Handle `json:"handle"`
+	Size   uint64 `json:"size"`
+	Provenance
+
+	// When the entry's memory was zeroed, as it was released, or the zero
+	// time if it's still live.
+	Wiped time.Time `json:"wiped"`
+
+	// Whether the memory was read back and found to be all zeros, see
+	// WithVerifiedWipe.
+	WipeVerified bool `json:"wipe_verified"`
+}
+
+// AuditReport is what classified data a pool has held, see AuditReport.
+type AuditReport struct {
+	// Every live entry with provenance, then the most recently released
+	// ones, each in the order they were acquired.
+	Records []AuditRecord `json:"records"`
+
+	// How many released entries' records were dropped to keep the trail
+	// within its limit.
+	Dropped uint64 `json:"dropped"`
+}
+
+// WithAuditTrail has the pool keep an audit trail of the entries acquired
+// with WithProvenance, for AuditReport: what they held, when they were
+// acquired and when their memory was wiped. So that the trail can say so,
+// the pool zeroes every entry's memory when it's released, before it goes
+// back to the allocator, whether it's released explicitly or reclaimed as
+// a leak. The records of the last keep released entries are kept, and
+// every live entry's.
+func WithAuditTrail(keep int) PoolOption {
+	return func(pool *Pool) {
+		pool.audit = &auditTrail{
+			keep: keep,
+			live: make(map[unsafe.Pointer]AuditRecord),
+		}
+	}
+}
+
+// AuditReport reports on the entries in the pool's audit trail. It's empty
+// unless the pool was created with WithAuditTrail.
+func (pool *Pool) AuditReport() AuditReport {
+	if pool.audit == nil {
+		return AuditReport{}
+	}
+	return pool.audit.report()
+}
+
+type auditTrail struct {
+	keep int
+
+	mu       sync.Mutex
+	live     map[unsafe.Pointer]AuditRecord
+	released []AuditRecord
+	dropped  uint64
+}
+
+// track adds a newly acquired entry, if it has provenance.
+func (trail *auditTrail) track(state *entryState) {
+	if state.provenance == nil {
+		return
+	}
+
+	trail.mu.Lock()
+	defer trail.mu.Unlock()
+
+	trail.live[state.data] = AuditRecord{
+		Handle:     state.handle,
+		Size:       state.size,
+		Provenance: *state.provenance,
+	}
+}
+
+// wiped moves a released entry whose memory has just been zeroed from the
+// live records to the released ones, if it's in the trail.
+func (trail *auditTrail) wiped(data unsafe.Pointer, verified bool) {
+	trail.mu.Lock()
+	record, ok := trail.live[data]
+	delete(trail.live, data)
+	trail.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	record.Wiped = time.Now()
+	record.WipeVerified = verified
+
+	trail.mu.Lock()
+	defer trail.mu.Unlock()
+
+	trail.released = append(trail.released, record)
+	if excess := len(trail.released) - max(trail.keep, 0); excess > 0 {
+		trail.released = append(trail.released[:0], trail.released[excess:]...)
+		trail.dropped += uint64(excess)
+	}
+}
+
+func (trail *auditTrail) report() AuditReport {
+	trail.mu.Lock()
+	defer trail.mu.Unlock()
+
+	records := make([]AuditRecord, 0, len(trail.live)+len(trail.released))
+	for _, record := range trail.live {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Handle < records[j].Handle
+	})
+	records = append(records, trail.released...)
+
+	return AuditReport{Records: records, Dropped: trail.dropped}
+}
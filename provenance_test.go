@@ -0,0 +1,80 @@
+package rustybuffer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestAuditTrail(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)), WithAuditTrail(2))
+	before := time.Now()
+
+	plain, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Release()
+	if _, ok := plain.Provenance(); ok {
+		t.Fatal("unexpected provenance")
+	}
+
+	var entries []RBEntry
+	for _, source := range []string{"billing", "crm", "hr"} {
+		entry, err := pool.AllocBuffers([]uint64{16, 16}, WithProvenance(source, "pii"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(entry.Buffers[1], "secret")
+		entries = append(entries, entry)
+	}
+	provenance, ok := entries[0].Provenance()
+	if !ok || provenance.Source != "billing" || provenance.Classification != "pii" ||
+		provenance.Acquired.Before(before) {
+		t.Fatalf("unexpected provenance %+v", provenance)
+	}
+
+	if report := pool.AuditReport(); len(report.Records) != 3 || !report.Records[0].Wiped.IsZero() {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// Released entries are wiped before the memory is given back.
+	memory := unsafe.Slice((*byte)(entries[0].data), 32)
+	for _, entry := range entries {
+		entry.Release()
+	}
+	for _, b := range memory {
+		if b != 0 {
+			t.Fatal("released memory wasn't wiped")
+		}
+	}
+
+	report := pool.AuditReport()
+	if len(report.Records) != 2 || report.Dropped != 1 ||
+		report.Records[0].Source != "crm" || report.Records[1].Source != "hr" ||
+		report.Records[1].Size != 32 || report.Records[1].Wiped.Before(report.Records[1].Acquired) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encoded), `"source":"hr","classification":"pii"`) {
+		t.Fatalf("unexpected JSON %s", encoded)
+	}
+}
+
+func TestAuditTrailDisabled(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+	entry, err := pool.AllocBuffers([]uint64{10}, WithProvenance("billing", "pii"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+	if report := pool.AuditReport(); len(report.Records) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
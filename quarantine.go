@@ -0,0 +1,279 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// QuarantineOptions configure WithQuarantine.
+type QuarantineOptions struct {
+	// How long released memory is held before it can be reused. Zero
+	// means it's only let go to keep under MaxBytes.
+	Hold time.Duration
+
+	// The most bytes held at once, past which the oldest memory is let go
+	// early. Zero means no limit.
+	MaxBytes uint64
+
+	// The pattern released memory is overwritten with, repeated, while
+	// it's held. If it's empty the memory is left as the release left it.
+	Poison []byte
+}
+
+// WithQuarantine has the pool hold on to the memory of released entries
+// for a while before its allocator can hand it out again, poisoned with
+// opts.Poison, so something still reading an entry after releasing it
+// sees an obvious pattern rather than another entry's data. When memory is
+// let go the poison is checked, and if something has written over it the
+// write after free is logged and counted in Stats.QuarantineViolations.
+//
+// Held memory is let go by the pool's later acquires and releases once
+// it's been held for opts.Hold, or to keep under opts.MaxBytes, and all of
+// it when an acquire would otherwise fail or by FlushQuarantine. Until
+// then it counts towards the allocator's BytesInUse. The quarantine wraps
+// the pool's allocator whatever the order of the options, inside any
+// interceptors added with Use, and like them doesn't cover the Go heap or
+// spill files exhaustion falls back to.
+func WithQuarantine(opts QuarantineOptions) PoolOption {
+	return func(pool *Pool) {
+		pool.quarantine = &quarantineAllocator{
+			hold:      opts.Hold,
+			max_bytes: opts.MaxBytes,
+			poison:    append([]byte(nil), opts.Poison...),
+			sizes:     make(map[unsafe.Pointer]uint64),
+		}
+	}
+}
+
+type quarantined struct {
+	data     unsafe.Pointer
+	size     uint64
+	released time.Time
+}
+
+// quarantineAllocator releases memory to the allocator it wraps, the
+// pool's, only once it's been through the pool's quarantine.
+type quarantineAllocator struct {
+	Allocator
+
+	hold      time.Duration
+	max_bytes uint64
+	poison    []byte
+
+	mu sync.Mutex
+
+	// The sizes of the buffers handed out, needed to poison them.
+	sizes map[unsafe.Pointer]uint64
+
+	// Released memory oldest first, and how much that is.
+	held  []quarantined
+	bytes uint64
+
+	// How much has ever been taken off the front of held, so that a
+	// scrub can find what it's checking while the lock isn't held.
+	taken uint64
+
+	violations uint64
+}
+
+func (alloc *quarantineAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	alloc.letGo(alloc.expired(time.Now()))
+
+	data, err := alloc.Allocator.Acquire(size)
+	if err != nil {
+		// The memory held may be all that's stopping the acquire.
+		let_go := alloc.flush()
+		if len(let_go) == 0 {
+			return nil, err
+		}
+		alloc.letGo(let_go)
+		if data, err = alloc.Allocator.Acquire(size); err != nil {
+			return nil, err
+		}
+	}
+
+	alloc.mu.Lock()
+	alloc.sizes[data] = size
+	alloc.mu.Unlock()
+
+	return data, nil
+}
+
+func (alloc *quarantineAllocator) Release(data unsafe.Pointer) error {
+	alloc.mu.Lock()
+	size, ok := alloc.sizes[data]
+	delete(alloc.sizes, data)
+	alloc.mu.Unlock()
+
+	if !ok {
+		return alloc.Allocator.Release(data)
+	}
+
+	if len(alloc.poison) > 0 {
+		poison(unsafe.Slice((*byte)(data), size), alloc.poison)
+	}
+
+	now := time.Now()
+	alloc.mu.Lock()
+	alloc.held = append(alloc.held, quarantined{data, size, now})
+	alloc.bytes += size
+	alloc.mu.Unlock()
+
+	alloc.letGo(alloc.expired(now))
+	return nil
+}
+
+// Trim lets go of everything held before trimming the wrapped allocator,
+// if it can be.
+func (alloc *quarantineAllocator) Trim() uint64 {
+	alloc.letGo(alloc.flush())
+	if trim, ok := alloc.Allocator.(trimmer); ok {
+		return trim.Trim()
+	}
+	return 0
+}
+
+// expired takes the memory that's been held long enough, or is the oldest
+// past the byte limit, off the quarantine.
+func (alloc *quarantineAllocator) expired(now time.Time) []quarantined {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	count := 0
+	for _, held := range alloc.held {
+		over := alloc.max_bytes > 0 && alloc.bytes > alloc.max_bytes
+		if !over && (alloc.hold == 0 || now.Sub(held.released) < alloc.hold) {
+			break
+		}
+		alloc.bytes -= held.size
+		count++
+	}
+	return alloc.take(count)
+}
+
+// flush takes everything off the quarantine.
+func (alloc *quarantineAllocator) flush() []quarantined {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	alloc.bytes = 0
+	return alloc.take(len(alloc.held))
+}
+
+// take removes the oldest count entries of held, with the lock held.
+func (alloc *quarantineAllocator) take(count int) []quarantined {
+	if count == 0 {
+		return nil
+	}
+	taken := append([]quarantined(nil), alloc.held[:count]...)
+	alloc.held = append(alloc.held[:0], alloc.held[count:]...)
+	alloc.taken += uint64(count)
+	return taken
+}
+
+// letGo checks the poison of memory taken off the quarantine and releases
+// it to the wrapped allocator.
+func (alloc *quarantineAllocator) letGo(let_go []quarantined) {
+	for _, held := range let_go {
+		if len(alloc.poison) > 0 && !poisoned(unsafe.Slice((*byte)(held.data), held.size), alloc.poison) {
+			alloc.mu.Lock()
+			alloc.violations++
+			alloc.mu.Unlock()
+			log.Printf("rustybuffer: %d byte entry at %p was written to in quarantine, after being released", held.size, held.data)
+		}
+		if err := alloc.Allocator.Release(held.data); err != nil {
+			log.Printf("rustybuffer: releasing %d byte entry at %p from quarantine: %v", held.size, held.data, err)
+		}
+	}
+	if len(let_go) > 0 {
+		releases.notify()
+	}
+}
+
+// poison overwrites memory with pattern, repeated.
+func poison(memory []byte, pattern []byte) {
+	if len(pattern) == 1 {
+		fillBytes(memory, pattern[0])
+		return
+	}
+	filled := copy(memory, pattern)
+	for filled < len(memory) {
+		filled += copy(memory[filled:], memory[:filled])
+	}
+}
+
+// poisoned checks memory still holds pattern, repeated.
+func poisoned(memory []byte, pattern []byte) bool {
+	for len(memory) > len(pattern) {
+		if !bytes.Equal(memory[:len(pattern)], pattern) {
+			return false
+		}
+		memory = memory[len(pattern):]
+	}
+	return bytes.Equal(memory, pattern[:len(memory)])
+}
+
+// scrub checks the poison of everything held, one at a time with the lock
+// held so none of it's let go meanwhile, yielding between them. Poison
+// found written over is counted as a violation, passed to found, and then
+// restored, so it isn't counted again when it's let go. It returns how
+// many were checked and their bytes.
+func (alloc *quarantineAllocator) scrub(found func(quarantined)) (uint64, uint64) {
+	if len(alloc.poison) == 0 {
+		return 0, 0
+	}
+
+	alloc.mu.Lock()
+	first := alloc.taken
+	count := uint64(len(alloc.held))
+	alloc.mu.Unlock()
+
+	var checked, checked_bytes uint64
+	for position := first; position < first+count; position++ {
+		alloc.mu.Lock()
+		if position < alloc.taken {
+			// Let go already, and checked as it was.
+			alloc.mu.Unlock()
+			continue
+		}
+		held := alloc.held[position-alloc.taken]
+		memory := unsafe.Slice((*byte)(held.data), held.size)
+		intact := poisoned(memory, alloc.poison)
+		if !intact {
+			alloc.violations++
+			poison(memory, alloc.poison)
+		}
+		alloc.mu.Unlock()
+
+		checked++
+		checked_bytes += held.size
+		if !intact {
+			found(held)
+		}
+		runtime.Gosched()
+	}
+	return checked, checked_bytes
+}
+
+func (alloc *quarantineAllocator) stats(stats *Stats) {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	stats.QuarantinedBytes = alloc.bytes
+	stats.NumQuarantined = uint64(len(alloc.held))
+	stats.QuarantineViolations = alloc.violations
+}
+
+// FlushQuarantine lets go of all the memory the pool's quarantine holds,
+// checking its poison, so it can be reused straight away. It does nothing
+// if the pool has no quarantine, see WithQuarantine.
+func (pool *Pool) FlushQuarantine() {
+	if pool.quarantine == nil {
+		return
+	}
+	pool.quarantine.letGo(pool.quarantine.flush())
+}
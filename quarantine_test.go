@@ -0,0 +1,157 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestQuarantinePoisons(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc), WithQuarantine(QuarantineOptions{Hold: time.Hour, Poison: []byte{0xde, 0xad, 0xbe, 0xef}}))
+
+	entry, err := pool.AllocBuffers([]uint64{6, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Fill(1)
+	lingering := entry.Buffers[0]
+	entry.Release()
+
+	if expected := []byte{0xde, 0xad, 0xbe, 0xef, 0xde, 0xad}; !bytes.Equal(lingering, expected) {
+		t.Fatalf("expected %x, got %x", expected, lingering)
+	}
+
+	// The memory's still held by the allocator, but not by the pool.
+	stats := pool.Stats()
+	if stats.QuarantinedBytes != 10 || stats.NumQuarantined != 1 || alloc.Stats().BytesInUse != 10 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	pool.FlushQuarantine()
+	stats = pool.Stats()
+	if stats.QuarantinedBytes != 0 || stats.NumQuarantined != 0 || stats.QuarantineViolations != 0 || alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestQuarantineHold(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithQuarantine(QuarantineOptions{Hold: 20 * time.Millisecond}), WithAllocator(alloc))
+
+	for i := 0; i < 3; i++ {
+		entry, err := pool.AllocBuffers([]uint64{10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Release()
+	}
+	if stats := pool.Stats(); stats.NumQuarantined != 3 || alloc.Stats().BytesInUse != 30 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// Once they've been held long enough the next acquire lets them go.
+	time.Sleep(30 * time.Millisecond)
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	if stats := pool.Stats(); stats.NumQuarantined != 0 || alloc.Stats().BytesInUse != 10 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestQuarantineMaxBytes(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc), WithQuarantine(QuarantineOptions{MaxBytes: 25}))
+
+	for i := 0; i < 4; i++ {
+		entry, err := pool.AllocBuffers([]uint64{10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Release()
+	}
+
+	// The oldest are let go to keep it under 25 bytes.
+	if stats := pool.Stats(); stats.QuarantinedBytes != 20 || stats.NumQuarantined != 2 || alloc.Stats().BytesInUse != 20 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestQuarantineExhaustion(t *testing.T) {
+	alloc := NewHeapAllocator(100, 100)
+	pool := NewPool(WithAllocator(alloc), WithQuarantine(QuarantineOptions{Hold: time.Hour}))
+
+	entry, err := pool.AllocBuffers([]uint64{80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+
+	// Letting go of the quarantined memory makes room.
+	entry, err = pool.AllocBuffers([]uint64{80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	if stats := pool.Stats(); stats.NumQuarantined != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	if _, err := pool.AllocBuffers([]uint64{80}); err == nil {
+		t.Fatal("expected the acquire to fail")
+	}
+}
+
+func TestQuarantineViolations(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)), WithQuarantine(QuarantineOptions{Poison: []byte{0xa5}}))
+
+	entry, err := pool.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lingering := entry.Buffers[0]
+	entry.Release()
+	if lingering[0] != 0xa5 || lingering[99] != 0xa5 {
+		t.Fatalf("expected poison, got %x", lingering)
+	}
+
+	lingering[50] = 1
+	pool.FlushQuarantine()
+	if stats := pool.Stats(); stats.QuarantineViolations != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestQuarantineInterceptors(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)), WithQuarantine(QuarantineOptions{Hold: time.Hour}))
+
+	var log []string
+	pool.Use(recording("outer", &log))
+
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+	if len(log) != 2 || pool.Stats().NumQuarantined != 1 {
+		t.Fatalf("unexpected log %v and stats %+v", log, pool.Stats())
+	}
+}
+
+func TestQuarantineTrim(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)), WithQuarantine(QuarantineOptions{Hold: time.Hour}))
+
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+
+	FreeOSMemory(pool)
+	if stats := pool.Stats(); stats.NumQuarantined != 0 || stats.BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
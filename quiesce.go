@@ -0,0 +1,138 @@
+package rustybuffer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// quiescence is the state of Pool.Quiesce.
+type quiescence struct {
+	// Set while the pool is quiesced, so acquires can skip the lock.
+	quiesced atomic.Bool
+
+	mu sync.Mutex
+
+	// Open while the pool is quiesced, closed by resume.
+	resumed chan struct{}
+
+	// Writes in flight, see View.BeginWrite, and a channel closed once
+	// there are none while Quiesce is waiting for them.
+	writers int
+	idle    chan struct{}
+}
+
+// wait waits, with the lock held, until the pool isn't quiesced or ctx is
+// done.
+func (quiesce *quiescence) wait(ctx context.Context) error {
+	for quiesce.resumed != nil {
+		resumed := quiesce.resumed
+		quiesce.mu.Unlock()
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			quiesce.mu.Lock()
+			return ctx.Err()
+		}
+		quiesce.mu.Lock()
+	}
+	return nil
+}
+
+// admit waits for the pool to be resumed before an acquire, if it's
+// quiesced.
+func (quiesce *quiescence) admit(ctx context.Context) error {
+	if !quiesce.quiesced.Load() {
+		return nil
+	}
+
+	quiesce.mu.Lock()
+	defer quiesce.mu.Unlock()
+
+	return quiesce.wait(ctx)
+}
+
+// Quiesce brings the pool to a standstill, for a moment when its entries
+// are consistent enough to checkpoint (see Pool.Checkpoint) or copy
+// elsewhere: acquires wait until it's resumed, as do writes about to
+// start (see View.BeginWrite), and Quiesce waits for the writes already
+// started to finish. It returns a func resuming the pool, which does
+// nothing if called again. If ctx is done first the pool is resumed and
+// ctx's error returned. A second Quiesce waits for the first to be
+// resumed.
+//
+// Only writes that say so with BeginWrite are waited for: writes through
+// an entry's Buffers, and through views without BeginWrite, carry on
+// regardless.
+func (pool *Pool) Quiesce(ctx context.Context) (func(), error) {
+	quiesce := &pool.quiesce
+	quiesce.mu.Lock()
+	if err := quiesce.wait(ctx); err != nil {
+		quiesce.mu.Unlock()
+		return nil, err
+	}
+
+	resumed := make(chan struct{})
+	quiesce.resumed = resumed
+	quiesce.quiesced.Store(true)
+	var once sync.Once
+	resume := func() {
+		once.Do(func() {
+			quiesce.mu.Lock()
+			defer quiesce.mu.Unlock()
+
+			quiesce.quiesced.Store(false)
+			quiesce.resumed = nil
+			quiesce.idle = nil
+			close(resumed)
+		})
+	}
+
+	if quiesce.writers == 0 {
+		quiesce.mu.Unlock()
+		return resume, nil
+	}
+	idle := make(chan struct{})
+	quiesce.idle = idle
+	quiesce.mu.Unlock()
+
+	select {
+	case <-idle:
+		return resume, nil
+	case <-ctx.Done():
+		resume()
+		return nil, ctx.Err()
+	}
+}
+
+// BeginWrite declares a write to the view's memory, for Pool.Quiesce,
+// returning a func to call when it's done, which does nothing if called
+// again. While the entry's pool is quiesced it waits for the pool to be
+// resumed first. Views that aren't of an entry's memory (see ViewOf)
+// don't wait.
+func (view View) BeginWrite() func() {
+	view.check()
+	if view.state == nil {
+		return func() {}
+	}
+
+	quiesce := &view.state.pool.quiesce
+	quiesce.mu.Lock()
+	quiesce.wait(context.Background())
+	quiesce.writers++
+	quiesce.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			quiesce.mu.Lock()
+			defer quiesce.mu.Unlock()
+
+			quiesce.writers--
+			if quiesce.writers == 0 && quiesce.idle != nil {
+				close(quiesce.idle)
+				quiesce.idle = nil
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package rustybuffer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuiesce(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	entry, err := pool.AllocBuffers([]uint64{16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	// Quiesce waits for the write in flight.
+	done := entry.View(0).BeginWrite()
+	quiesced := make(chan func())
+	go func() {
+		resume, err := pool.Quiesce(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		quiesced <- resume
+	}()
+	select {
+	case <-quiesced:
+		t.Fatal("quiesced with a write in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	done()
+	done()
+	resume := <-quiesced
+
+	// Acquires and new writes wait for the pool to be resumed.
+	acquired := make(chan error)
+	go func() {
+		entry, err := pool.AllocBuffers([]uint64{16})
+		if err == nil {
+			entry.Release()
+		}
+		acquired <- err
+	}()
+	wrote := make(chan struct{})
+	go func() {
+		entry.View(0).BeginWrite()()
+		close(wrote)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired while quiesced")
+	case <-wrote:
+		t.Fatal("started a write while quiesced")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := pool.AllocBuffers([]uint64{16}, WithContext(ctx)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the acquire to time out, got %v", err)
+	}
+
+	resume()
+	resume()
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+	<-wrote
+}
+
+func TestQuiesceTimeout(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	entry, err := pool.AllocBuffers([]uint64{16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	done := entry.View(0).BeginWrite()
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Quiesce(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected Quiesce to time out, got %v", err)
+	}
+
+	// Giving up resumes the pool.
+	other, err := pool.AllocBuffers([]uint64{16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Release()
+}
+
+func TestQuiesceViewOf(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	resume, err := pool.Quiesce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resume()
+
+	// Views of plain Go memory have no pool to wait for.
+	ViewOf(make([]byte, 4)).BeginWrite()()
+}
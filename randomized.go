@@ -0,0 +1,89 @@
+package rustybuffer
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"unsafe"
+)
+
+// NewRandomizedAllocator returns an Allocator that carves buffers out of one
+// max_total_size arena, like NewDeterministicAllocator, except that each
+// buffer lands at a random, 16 byte aligned, offset anywhere in the free
+// space, chosen with crypto/rand. Which buffer sits next to which is
+// unpredictable, so an attacker can't groom the arena into putting a
+// buffer they can overflow next to one they want to read or corrupt, as
+// they can with allocators that reuse space in a predictable order.
+//
+// The price is fragmentation: free space is split up rather than kept
+// together, so an arena that's mostly free can still refuse a large
+// buffer, and it should be sized well beyond what's in use at once. The
+// arena is allocated from the Go heap up front.
+func NewRandomizedAllocator(max_total_size uint64, max_buffer_size uint64) Allocator {
+	alloc := NewDeterministicAllocator(max_total_size, max_buffer_size, 0).(*deterministicAllocator)
+	alloc.rng = rand.New(cryptoSource{})
+	alloc.scatter = true
+	return alloc
+}
+
+// scatterAcquire places rounded bytes at an offset chosen uniformly from
+// every aligned offset they'd fit at, with the lock held.
+func (alloc *deterministicAllocator) scatterAcquire(size uint64, rounded uint64) (unsafe.Pointer, error) {
+	var placements uint64 = 0
+	for _, hole := range alloc.free {
+		if hole.size >= rounded {
+			placements += (hole.size-rounded)/deterministicAlignment + 1
+		}
+	}
+	if placements == 0 {
+		return nil, newError(codeNoBufferAvailable,
+			"requested %d bytes with %d of max_total_size %d in use",
+			size, alloc.bytes_in_use, len(alloc.arena))
+	}
+
+	pick := randomBelow(alloc.rng, placements)
+	for idx, hole := range alloc.free {
+		if hole.size < rounded {
+			continue
+		}
+		count := (hole.size-rounded)/deterministicAlignment + 1
+		if pick >= count {
+			pick -= count
+			continue
+		}
+
+		offset := hole.offset + pick*deterministicAlignment
+		alloc.carve(idx, offset, rounded)
+		return alloc.handOut(offset, rounded), nil
+	}
+	panic("rustybuffer: randomized placement fell off the end of the free list")
+}
+
+// randomBelow returns a uniformly random number in [0, n).
+func randomBelow(rng *rand.Rand, n uint64) uint64 {
+	// Reject the top of the range that would bias the modulus.
+	limit := ^uint64(0) - ^uint64(0)%n
+	for {
+		if value := rng.Uint64(); value < limit {
+			return value % n
+		}
+	}
+}
+
+// cryptoSource is a rand.Source reading from crypto/rand, so that its
+// numbers can't be predicted from earlier ones.
+type cryptoSource struct{}
+
+func (cryptoSource) Int63() int64 {
+	return int64(cryptoSource{}.Uint64() >> 1)
+}
+
+func (cryptoSource) Uint64() uint64 {
+	var buf [8]byte
+	if _, err := crand.Read(buf[:]); err != nil {
+		panic("rustybuffer: reading random bytes: " + err.Error())
+	}
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+func (cryptoSource) Seed(seed int64) {}
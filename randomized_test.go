@@ -0,0 +1,80 @@
+package rustybuffer
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestRandomizedAllocator(t *testing.T) {
+	alloc := NewRandomizedAllocator(64*1024, 64*1024)
+
+	// The first buffer in an empty arena lands somewhere different nearly
+	// every time.
+	offsets := make(map[uint64]bool)
+	for i := 0; i < 20; i++ {
+		data, err := alloc.Acquire(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset, ok := ArenaOffset(alloc, data)
+		if !ok || offset%deterministicAlignment != 0 || offset+100 > 64*1024 {
+			t.Fatalf("bad offset %d", offset)
+		}
+		offsets[offset] = true
+		if err := alloc.Release(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(offsets) < 10 {
+		t.Fatalf("only %d distinct offsets in 20 tries", len(offsets))
+	}
+
+	// Fill it with small buffers, which don't overlap.
+	var held []unsafe.Pointer
+	seen := make(map[uint64]bool)
+	for {
+		data, err := alloc.Acquire(48)
+		if err != nil {
+			break
+		}
+		offset, _ := ArenaOffset(alloc, data)
+		for at := offset; at < offset+48; at += deterministicAlignment {
+			if seen[at] {
+				t.Fatalf("buffers overlap at %d", at)
+			}
+			seen[at] = true
+		}
+		held = append(held, data)
+	}
+	if len(held) < 64*1024/48/3 {
+		t.Fatalf("only fit %d buffers", len(held))
+	}
+
+	// Once they're all released the free space is whole again.
+	for _, data := range held {
+		if err := alloc.Release(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := alloc.Acquire(64 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alloc.Release(data)
+	if stats := alloc.Stats(); stats.BytesInUse != 0 || stats.NumBuffers != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRandomBelow(t *testing.T) {
+	rng := NewRandomizedAllocator(0, 0).(*deterministicAllocator).rng
+	counts := make([]int, 3)
+	for i := 0; i < 3000; i++ {
+		counts[randomBelow(rng, 3)]++
+	}
+	for value, count := range counts {
+		if count < 800 {
+			t.Fatalf("%d only came up %d times", value, count)
+		}
+	}
+}
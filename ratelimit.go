@@ -0,0 +1,218 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// ErrRateLimited is returned, wrapped, by acquires over a pool's rate
+// limit.
+var ErrRateLimited = errors.New("rustybuffer: rate limited")
+
+// RateLimit is a token bucket limit on the bytes acquired from a pool.
+type RateLimit struct {
+	// How many bytes can be acquired per second on average.
+	BytesPerSecond float64
+
+	// How many bytes can be acquired at once after a quiet spell, which
+	// is also the largest single acquire allowed. Zero means
+	// BytesPerSecond.
+	Burst uint64
+
+	// Over budget acquires wait for the budget to recover, for as long as
+	// their context (see WithContext) allows, rather than failing with
+	// ErrRateLimited.
+	Wait bool
+}
+
+// WithRateLimit limits the bytes acquired from the pool, so that a single
+// misbehaving caller can't take all of it faster than watermarks can warn
+// about. Acquires are limited whatever their tag.
+func WithRateLimit(limit RateLimit) PoolOption {
+	return func(pool *Pool) {
+		pool.rate = newTokenBucket(limit)
+	}
+}
+
+// WithTagRateLimit limits the bytes acquired with tag (see WithTag), on top
+// of any limit on the whole pool.
+func WithTagRateLimit(tag string, limit RateLimit) PoolOption {
+	return func(pool *Pool) {
+		if pool.tag_rates == nil {
+			pool.tag_rates = make(map[string]*tokenBucket)
+		}
+		pool.tag_rates[tag] = newTokenBucket(limit)
+	}
+}
+
+// WithTagMaxBufferSize caps the size of a single acquire with tag (see
+// WithTag), below the allocator's max_buffer_size, failing larger ones
+// with ErrBufferTooLarge even if the pool has room. It's for multi-tenant
+// servers, tagging acquires by tenant, so that an untrusted tenant can't
+// reserve a huge buffer. Untagged acquires are capped by the "" tag.
+func WithTagMaxBufferSize(tag string, size uint64) PoolOption {
+	return func(pool *Pool) {
+		if pool.tag_max_sizes == nil {
+			pool.tag_max_sizes = make(map[string]uint64)
+		}
+		pool.tag_max_sizes[tag] = size
+	}
+}
+
+// WithTag says which component (or tenant) an acquire is for, to apply
+// its rate limit and size cap (see WithTagRateLimit and
+// WithTagMaxBufferSize).
+func WithTag(tag string) AcquireOption {
+	return func(opts *acquireOptions) {
+		opts.tag = tag
+	}
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	burst := float64(limit.Burst)
+	if limit.Burst == 0 {
+		burst = limit.BytesPerSecond
+	}
+	return &tokenBucket{
+		limit:  limit,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes size bytes from the bucket, returning how long to wait
+// before using them. Waiting clients take their bytes up front, driving
+// the bucket negative, so that everyone after them waits their turn too.
+func (bucket *tokenBucket) reserve(size uint64, now time.Time) (time.Duration, error) {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	if float64(size) > bucket.burst {
+		return 0, fmt.Errorf("%w: %d bytes is more than the burst of %.0f",
+			ErrRateLimited, size, bucket.burst)
+	}
+
+	elapsed := now.Sub(bucket.last).Seconds()
+	if elapsed > 0 {
+		bucket.tokens = min(bucket.burst, bucket.tokens+elapsed*bucket.limit.BytesPerSecond)
+		bucket.last = now
+	}
+
+	if bucket.tokens >= float64(size) {
+		bucket.tokens -= float64(size)
+		return 0, nil
+	}
+	if !bucket.limit.Wait || bucket.limit.BytesPerSecond <= 0 {
+		return 0, fmt.Errorf("%w: %d bytes with %.0f left of a %.0f byte/s limit",
+			ErrRateLimited, size, max(bucket.tokens, 0), bucket.limit.BytesPerSecond)
+	}
+
+	bucket.tokens -= float64(size)
+	delay := -bucket.tokens / bucket.limit.BytesPerSecond
+	return time.Duration(delay * float64(time.Second)), nil
+}
+
+// refund gives back bytes reserved by an acquire that didn't happen.
+func (bucket *tokenBucket) refund(size uint64) {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	bucket.tokens = min(bucket.burst, bucket.tokens+float64(size))
+}
+
+// admission is what an acquire took from the pool's rate limits and its
+// goroutine's limit.
+type admission struct {
+	size    uint64
+	buckets []*tokenBucket
+
+	goroutines *goroutineLimits
+	goroutine  uint64
+}
+
+func (admitted admission) refund() {
+	for _, bucket := range admitted.buckets {
+		bucket.refund(admitted.size)
+	}
+	if admitted.goroutines != nil {
+		admitted.goroutines.cancel(admitted.goroutine, admitted.size)
+	}
+}
+
+// acquired records what holds the admitted bytes, once they're acquired.
+func (admitted admission) acquired(data unsafe.Pointer) {
+	if admitted.goroutines != nil {
+		admitted.goroutines.own(admitted.goroutine, data, admitted.size)
+	}
+}
+
+// admit applies the pool's goroutine and rate limits to an acquire of size
+// bytes, waiting if they say to. If the acquire then fails the caller
+// refunds what was admitted.
+func (pool *Pool) admit(size uint64, options acquireOptions) (admission, error) {
+	if max_size, ok := pool.tag_max_sizes[options.tag]; ok && size > max_size {
+		return admission{}, newError(codeBufferTooLarge,
+			"requested %d bytes but max_buffer_size for tag %q is %d", size, options.tag, max_size)
+	}
+
+	admitted := admission{size: size}
+	if pool.goroutines != nil {
+		goroutine := goroutineID()
+		if err := pool.goroutines.reserve(goroutine, size); err != nil {
+			return admission{}, err
+		}
+		admitted.goroutines = pool.goroutines
+		admitted.goroutine = goroutine
+	}
+	if pool.rate == nil && pool.tag_rates == nil {
+		return admitted, nil
+	}
+
+	buckets := make([]*tokenBucket, 0, 2)
+	if bucket, ok := pool.tag_rates[options.tag]; ok {
+		buckets = append(buckets, bucket)
+	}
+	if pool.rate != nil {
+		buckets = append(buckets, pool.rate)
+	}
+
+	now := time.Now()
+	var delay time.Duration = 0
+	for _, bucket := range buckets {
+		wait, err := bucket.reserve(size, now)
+		if err != nil {
+			admitted.refund()
+			return admission{}, err
+		}
+		admitted.buckets = append(admitted.buckets, bucket)
+		delay = max(delay, wait)
+	}
+
+	if delay == 0 {
+		return admitted, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return admitted, nil
+	case <-options.ctx.Done():
+		admitted.refund()
+		return admission{}, fmt.Errorf("%w: waiting %s for %d bytes: %w",
+			ErrRateLimited, delay, size, options.ctx.Err())
+	}
+}
@@ -0,0 +1,167 @@
+package rustybuffer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A rate that won't refill noticeably during a test.
+const slowRate = 0.001
+
+func TestRateLimitReject(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)),
+		WithRateLimit(RateLimit{BytesPerSecond: slowRate, Burst: 1000}))
+
+	entry, err := pool.AllocBuffers([]uint64{300, 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Releasing doesn't give the budget back.
+	entry.Release()
+
+	_, err = pool.AllocBuffers([]uint64{600})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+
+	entry, err = pool.AllocBuffers([]uint64{400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+
+	// More than the burst can never be acquired.
+	pool = NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)),
+		WithRateLimit(RateLimit{BytesPerSecond: 1 << 30, Burst: 1000, Wait: true}))
+	_, err = pool.AllocBuffers([]uint64{1001})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+}
+
+func TestRateLimitTags(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)),
+		WithRateLimit(RateLimit{BytesPerSecond: slowRate, Burst: 1000}),
+		WithTagRateLimit("batch", RateLimit{BytesPerSecond: slowRate, Burst: 100}))
+
+	entry, err := pool.AllocBuffers([]uint64{100}, WithTag("batch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+
+	_, err = pool.AllocBuffers([]uint64{1}, WithTag("batch"))
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+
+	// Other tags only have the pool's limit, which the batch acquire
+	// counted against, and the rejected one didn't.
+	entry, err = pool.AllocBuffers([]uint64{900}, WithTag("interactive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+
+	_, err = pool.AllocBuffers([]uint64{1})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+}
+
+func TestRateLimitWait(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)),
+		WithRateLimit(RateLimit{BytesPerSecond: 10000, Burst: 1000, Wait: true}))
+
+	entry, err := pool.AllocBuffers([]uint64{1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+
+	// 500 bytes at 10000 bytes/s takes 50ms to come back.
+	start := time.Now()
+	entry, err = pool.AllocBuffers([]uint64{500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Fatalf("only waited %s", waited)
+	}
+
+	// A wait that would outlast the context fails, and doesn't use up the
+	// budget.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = pool.AllocBuffers([]uint64{1000}, WithContext(ctx))
+	if !errors.Is(err, ErrRateLimited) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ErrRateLimited and a timeout, got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	start = time.Now()
+	entry, err = pool.AllocBuffers([]uint64{1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+	if waited := time.Since(start); waited > 80*time.Millisecond {
+		t.Fatalf("waited %s, the cancelled acquire used up the budget", waited)
+	}
+}
+
+func TestRateLimitRefund(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(500, 500)),
+		WithRateLimit(RateLimit{BytesPerSecond: slowRate, Burst: 1000}))
+
+	held, err := pool.AllocBuffers([]uint64{400})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Acquires that fail don't count against the limit.
+	_, err = pool.AllocBuffers([]uint64{400})
+	if !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+	held.Release()
+
+	entry, err := pool.AllocBuffers([]uint64{400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+}
+
+func TestTagMaxBufferSize(t *testing.T) {
+	pool := NewPool(
+		WithAllocator(NewHeapAllocator(1<<20, 1<<20)),
+		WithTagMaxBufferSize("tenant", 1000),
+		WithTagMaxBufferSize("", 5000),
+	)
+
+	_, err := pool.AllocBuffers([]uint64{600, 600}, WithTag("tenant"))
+	if !errors.Is(err, ErrBufferTooLarge) || !strings.Contains(err.Error(), `"tenant"`) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+	if _, err := pool.AllocBuffers([]uint64{6000}); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected untagged acquires to be capped, got %v", err)
+	}
+
+	// Up to the cap is fine, and other tags are only limited by the
+	// allocator.
+	for _, acquire := range []struct {
+		size uint64
+		tag  string
+	}{{1000, "tenant"}, {5000, ""}, {100000, "internal"}} {
+		entry, err := pool.AllocBuffers([]uint64{acquire.size}, WithTag(acquire.tag))
+		if err != nil {
+			t.Fatalf("%q: %v", acquire.tag, err)
+		}
+		entry.Release()
+	}
+}
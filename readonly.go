@@ -0,0 +1,103 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+// readOnlyMapper is implemented by allocators whose memory can be mapped a
+// second time, read only, see RBEntry.ReadOnlyView.
+type readOnlyMapper interface {
+	mapReadOnly(data unsafe.Pointer, size uint64) ([]byte, error)
+}
+
+// ReadOnlyView is a second mapping of an entry's memory that can only be
+// read, for handing to code that isn't trusted with the entry itself, a
+// plugin say. The hardware enforces it: writing through the view crashes
+// rather than changing the entry, and the mapping can't be made writable.
+// Writes to the entry show through the view.
+type ReadOnlyView struct {
+	// The entry's buffers, as seen through the view.
+	Buffers [][]byte
+
+	mapping []byte
+	unpin   func()
+	once    sync.Once
+}
+
+// ReadOnlyView maps the entry's memory again, read only. Only memory in a
+// file can be mapped twice, so the entry has to come from a pool backed
+// by a SharedSegment (used directly, not through interceptors), and start
+// on a page boundary, which every buffer does when the segment's block
+// size is a multiple of the page size. Anything else fails. The view is
+// of the file the segment was created or opened with, even if its path
+// has since been unlinked and reused.
+//
+// The entry is pinned (see RBEntry.Pin) until the view is closed, so the
+// memory can't be reused underneath it even if the entry is released.
+func (entry *RBEntry) ReadOnlyView() (*ReadOnlyView, error) {
+	if entry.state == nil || entry.data == nil || entry.state.released.Load() {
+		return nil, fmt.Errorf("rustybuffer: read only view of a released entry")
+	}
+	if err := entry.checkTaint("ReadOnlyView"); err != nil {
+		return nil, err
+	}
+	mapper, ok := entry.state.alloc.(readOnlyMapper)
+	if !ok {
+		return nil, fmt.Errorf("rustybuffer: entries from %T can't be mapped read only",
+			entry.state.alloc)
+	}
+
+	data, unpin := entry.Pin()
+	mapping, err := mapper.mapReadOnly(data, entry.state.size)
+	if err != nil {
+		unpin()
+		return nil, err
+	}
+
+	state := entry.state
+	state.pin_mu.Lock()
+	state.views++
+	state.pin_mu.Unlock()
+	view := &ReadOnlyView{mapping: mapping, unpin: func() {
+		state.pin_mu.Lock()
+		if !state.forced {
+			state.views--
+		}
+		state.pin_mu.Unlock()
+		unpin()
+	}}
+	view.Buffers = make([][]byte, len(entry.Buffers))
+	for idx, buffer := range entry.Buffers {
+		offset := uintptr(unsafe.Pointer(unsafe.SliceData(buffer))) - uintptr(data)
+		view.Buffers[idx] = mapping[offset : offset+uintptr(len(buffer)) : offset+uintptr(len(buffer))]
+	}
+	return view, nil
+}
+
+// Close unmaps the view and unpins the entry. The view's buffers mustn't
+// be used afterwards. Closing more than once does nothing.
+func (view *ReadOnlyView) Close() error {
+	var err error
+	view.once.Do(func() {
+		err = unmapSegment(view.mapping)
+		view.Buffers = nil
+		view.unpin()
+	})
+	return err
+}
+
+func (seg *SharedSegment) mapReadOnly(data unsafe.Pointer, size uint64) ([]byte, error) {
+	if _, ok := seg.index(data); !ok {
+		return nil, fmt.Errorf("rustybuffer: %p was not acquired from this mapping of the segment", data)
+	}
+	offset := uint64(uintptr(data) - uintptr(unsafe.Pointer(unsafe.SliceData(seg.mapping))))
+	if page := uint64(os.Getpagesize()); offset%page != 0 {
+		return nil, fmt.Errorf("rustybuffer: %p isn't on a page boundary, "+
+			"the segment's block size %d has to be a multiple of %d",
+			data, seg.block_size, page)
+	}
+	return mapSegmentReadOnly(seg.file, offset, max(size, 1))
+}
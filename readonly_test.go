@@ -0,0 +1,125 @@
+//go:build linux || darwin
+
+package rustybuffer
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestReadOnlyView(t *testing.T) {
+	page := uint64(os.Getpagesize())
+	seg, err := CreateSharedSegment(filepath.Join(t.TempDir(), "segment"), 8*page, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.Close()
+	pool := NewPool(WithAllocator(seg), WithEntryDiagnostics())
+
+	entry, err := pool.AllocBuffers([]uint64{100, 0, page})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(entry.Buffers[0], "before")
+
+	view, err := entry.ReadOnlyView()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer view.Close()
+	copy(entry.Buffers[2], "after")
+	if string(view.Buffers[0][:6]) != "before" || string(view.Buffers[2][:5]) != "after" ||
+		len(view.Buffers[1]) != 0 || uint64(len(view.Buffers[2])) != page {
+		t.Fatal("the view doesn't match the entry")
+	}
+	if err := syscall.Mprotect(view.mapping, syscall.PROT_READ|syscall.PROT_WRITE); err == nil {
+		t.Fatal("the view could be made writable")
+	}
+
+	// The memory is held until the view is closed.
+	entry.Release()
+	if stats := seg.Stats(); stats.NumBuffers != 1 {
+		t.Fatalf("released under the view: %+v", stats)
+	}
+	if diagnostics := pool.EntryDiagnostics(); len(diagnostics) != 1 || diagnostics[0].Pins != 1 || diagnostics[0].Views != 1 {
+		t.Fatalf("unexpected diagnostics %+v", diagnostics)
+	}
+	if err := view.Close(); err != nil {
+		t.Fatal(err)
+	}
+	view.Close()
+	if stats := seg.Stats(); stats.NumBuffers != 0 {
+		t.Fatalf("not released with the view: %+v", stats)
+	}
+}
+
+func TestReadOnlyViewUnsupported(t *testing.T) {
+	entry, err := NewPool(WithAllocator(NewHeapAllocator(1024, 1024))).AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	if _, err := entry.ReadOnlyView(); err == nil {
+		t.Fatal("expected heap memory to be refused")
+	}
+
+	// Blocks smaller than a page don't all start on one.
+	seg, err := CreateSharedSegment(filepath.Join(t.TempDir(), "segment"), 64*1024, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.Close()
+	pool := NewPool(WithAllocator(seg))
+	first, _ := pool.AllocBuffers([]uint64{10})
+	defer first.Release()
+	second, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Release()
+	if _, err := second.ReadOnlyView(); err == nil {
+		t.Fatal("expected an unaligned buffer to be refused")
+	}
+
+	second.Release()
+	if _, err := second.ReadOnlyView(); err == nil {
+		t.Fatal("expected a released entry to be refused")
+	}
+}
+
+func TestReadOnlyViewReplacedPath(t *testing.T) {
+	page := uint64(os.Getpagesize())
+	path := filepath.Join(t.TempDir(), "segment")
+	seg, err := CreateSharedSegment(path, 4*page, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.Close()
+	entry, err := NewPool(WithAllocator(seg)).AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	copy(entry.Buffers[0], "segment")
+
+	// The view is of the segment's file, not whatever's at its path now.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	other, err := CreateSharedSegment(path, 4*page, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	view, err := entry.ReadOnlyView()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer view.Close()
+	if string(view.Buffers[0][:7]) != "segment" {
+		t.Fatalf("the view is of another file: %q", view.Buffers[0])
+	}
+}
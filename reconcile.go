@@ -0,0 +1,192 @@
+package rustybuffer
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Reclamation describes a leaked entry found by Pool.Reconcile.
+type Reclamation struct {
+	// The entry, and the size of its memory.
+	Entry Handle
+	Size  uint64
+
+	// Where the entry was acquired, and its labels (see WithLabels).
+	Site   string
+	Labels map[string]string
+
+	// Set if the memory couldn't be released, e.g. because its allocator
+	// says it wasn't live anymore.
+	Err error
+}
+
+// site is where the entry was acquired, with its labels if it had any.
+func (reclamation Reclamation) site() string {
+	if len(reclamation.Labels) == 0 {
+		return reclamation.Site
+	}
+	return reclamation.Site + " (" + formatLabels(reclamation.Labels) + ")"
+}
+
+// WithLeakTracking has the pool remember every live entry so that
+// Reconcile can recover the memory of those that were garbage collected
+// without being released. Each one recovered is passed to report, or
+// logged if report is nil.
+//
+// Unlike WithLeakFinalizer nothing is released behind the program's back
+// in a finalizer: the leaks are only reclaimed when Reconcile is called,
+// which can be done periodically with ReconcileEvery. The same caveat
+// applies though, an entry dropped while its buffers are still in use
+// will be reclaimed from under them.
+func WithLeakTracking(report func(Reclamation)) PoolOption {
+	return func(pool *Pool) {
+		pool.tracker = &leakTracker{
+			report: report,
+			live:   make(map[unsafe.Pointer]trackedEntry),
+		}
+	}
+}
+
+type trackedEntry struct {
+	handle  Handle
+	alloc   Allocator
+	size    uint64
+	callers []uintptr
+}
+
+// leakTracker holds on to the pointers of live entries, not the entries
+// themselves, so that the garbage collector can still find them
+// unreachable.
+type leakTracker struct {
+	report func(Reclamation)
+
+	mu      sync.Mutex
+	live    map[unsafe.Pointer]trackedEntry
+	orphans []unsafe.Pointer
+}
+
+func (tracker *leakTracker) track(data unsafe.Pointer, entry trackedEntry) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.live[data] = entry
+}
+
+func (tracker *leakTracker) forget(data unsafe.Pointer) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	delete(tracker.live, data)
+}
+
+// orphan records that the entry owning data was collected unreleased.
+func (tracker *leakTracker) orphan(data unsafe.Pointer) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.orphans = append(tracker.orphans, data)
+}
+
+// Reconcile releases the memory of every entry that's been garbage
+// collected without being released since the last call, and reports each
+// of them as set up by WithLeakTracking. Where the allocator can list what
+// it has handed out (see CapabilityLiveHandles for the Rust library) each
+// leak is checked against that first, so memory the allocator no longer
+// thinks is live isn't released twice. Pools without leak tracking have
+// nothing to reconcile.
+func (pool *Pool) Reconcile() []Reclamation {
+	if pool.tracker == nil {
+		return nil
+	}
+	tracker := pool.tracker
+
+	tracker.mu.Lock()
+	orphans := tracker.orphans
+	tracker.orphans = nil
+	entries := make([]trackedEntry, len(orphans))
+	for idx, data := range orphans {
+		entries[idx] = tracker.live[data]
+		delete(tracker.live, data)
+	}
+	tracker.mu.Unlock()
+
+	reclaimed := make([]Reclamation, 0, len(orphans))
+	for idx, data := range orphans {
+		entry := entries[idx]
+		reclamation := Reclamation{
+			Entry: entry.handle,
+			Size:  entry.size,
+			Site:  callersSite(entry.callers),
+		}
+
+		if !allocatorHolds(entry.alloc, data) {
+			reclamation.Err = newError(codeInvalidPointer,
+				"%p is not live in its allocator", data)
+		} else {
+			debugReleased(data)
+			pool.wipe(data, entry.size)
+			if err := entry.alloc.Release(data); err != nil {
+				reclamation.Err = err
+			}
+			reclamation.Labels = pool.forget(data)
+		}
+
+		if tracker.report != nil {
+			tracker.report(reclamation)
+		} else if reclamation.Err != nil {
+			log.Printf("rustybuffer: failed to reclaim leaked entry %s acquired at %s: %v",
+				reclamation.Entry, reclamation.site(), reclamation.Err)
+		} else {
+			log.Printf("rustybuffer: reclaimed %d byte entry %s acquired at %s",
+				reclamation.Size, reclamation.Entry, reclamation.site())
+		}
+
+		reclaimed = append(reclaimed, reclamation)
+	}
+
+	if len(reclaimed) > 0 {
+		releases.notify()
+		pool.checkWatermarks()
+	}
+
+	return reclaimed
+}
+
+// ReconcileEvery calls Reconcile every interval until ctx is done.
+func (pool *Pool) ReconcileEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pool.Reconcile()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// allocatorHolds checks alloc still has data handed out, assuming it does
+// if alloc can't say.
+func allocatorHolds(alloc Allocator, data unsafe.Pointer) bool {
+	lister, ok := alloc.(handleLister)
+	if !ok {
+		return true
+	}
+
+	live, err := lister.LiveHandles()
+	if err != nil {
+		return true
+	}
+
+	for _, addr := range live {
+		if addr == uintptr(data) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package rustybuffer
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestReconcile(t *testing.T) {
+	var reported []Reclamation
+	pool := NewPool(
+		WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithLeakTracking(func(reclamation Reclamation) {
+			reported = append(reported, reclamation)
+		}),
+	)
+
+	kept, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kept.Release()
+
+	leakEntry(t, pool)
+
+	// Nothing is released until Reconcile says so.
+	var reclaimed []Reclamation
+	for idx := 0; idx < 100 && len(reclaimed) == 0; idx++ {
+		if pool.Stats().BytesInUse != 110 {
+			t.Fatalf("unexpected stats: %+v", pool.Stats())
+		}
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+		reclaimed = pool.Reconcile()
+	}
+
+	if len(reclaimed) != 1 || len(reported) != 1 {
+		t.Fatalf("expected one reclamation, got %v", reclaimed)
+	}
+	if reclaimed[0].Size != 100 || reclaimed[0].Err != nil {
+		t.Fatalf("unexpected reclamation: %+v", reclaimed[0])
+	}
+	if reclaimed[0].Entry == 0 || reclaimed[0].Entry == kept.Handle() {
+		t.Fatalf("unexpected entry: %+v", reclaimed[0])
+	}
+	if !strings.Contains(reclaimed[0].Site, "leakEntry") {
+		t.Fatalf("unexpected site: %s", reclaimed[0].Site)
+	}
+	if pool.Stats().BytesInUse != 10 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+
+	if len(pool.Reconcile()) != 0 {
+		t.Fatal("reclaimed twice")
+	}
+}
+
+func TestLiveHandles(t *testing.T) {
+	for name, alloc := range testAllocators() {
+		lister, ok := alloc.(handleLister)
+		if !ok {
+			continue
+		}
+
+		data, err := alloc.Acquire(100)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !allocatorHolds(alloc, data) {
+			t.Fatalf("%s: acquired buffer isn't live", name)
+		}
+
+		if err := alloc.Release(data); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		live, err := lister.LiveHandles()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, addr := range live {
+			if addr == uintptr(data) {
+				t.Fatalf("%s: released buffer is live", name)
+			}
+		}
+	}
+
+	if allocatorHolds(NewHeapAllocator(1024, 1024), unsafe.Pointer(&struct{}{})) {
+		t.Fatal("unknown pointer is live")
+	}
+}
+
+// The pool's registries hold on to what they need of an entry, not the
+// entry, so a leak is found whatever they keep track of.
+func TestReconcileRegistries(t *testing.T) {
+	for name, opt := range map[string]PoolOption{
+		"shadows":     WithShadowVerification(nil),
+		"checkpoints": WithCheckpoints(),
+		"entries":     WithForceRelease(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			pool := NewPool(
+				WithAllocator(NewHeapAllocator(1024, 1024)),
+				WithLeakTracking(func(Reclamation) {}),
+				opt,
+			)
+			leakEntry(t, pool, WithLabels(map[string]string{"tenant": "a"}))
+
+			var reclaimed []Reclamation
+			for idx := 0; idx < 100 && len(reclaimed) == 0; idx++ {
+				runtime.GC()
+				time.Sleep(time.Millisecond)
+				reclaimed = pool.Reconcile()
+			}
+			if len(reclaimed) != 1 || reclaimed[0].Err != nil {
+				t.Fatalf("expected one reclamation, got %v", reclaimed)
+			}
+			if stats := pool.Stats(); stats.BytesInUse != 0 {
+				t.Fatalf("unexpected stats: %+v", stats)
+			}
+			if mismatches := pool.VerifyShadows(); len(mismatches) != 0 {
+				t.Fatalf("unexpected mismatches: %v", mismatches)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package rustybuffer
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// The key Redacted hashes with, random per process so that a hash of a
+// short value (a card number, say) can't be looked up in a table of
+// every possible one.
+var redactionKey = func() uint64 {
+	var key [8]byte
+	rand.Read(key[:])
+	return binary.LittleEndian.Uint64(key[:])
+}()
+
+// RedactedEntry summarises an entry's contents without revealing them, for
+// logging, see RBEntry.Redacted. It formats as a single line with %v and
+// as a group with log/slog.
+type RedactedEntry struct {
+	Handle   Handle
+	Released bool
+
+	// The entry's size in bytes, across its buffers.
+	Len uint64
+
+	// A keyed xxHash64 of the contents, which is the same for the same
+	// contents within a process, so that log lines can be correlated, and
+	// different in every other process.
+	Hash uint64
+
+	// The first and last bytes, masked: letters become x or X, digits 9,
+	// other printable ASCII is kept and everything else is a dot. Tail is
+	// empty if Head covers the whole entry.
+	Head string
+	Tail string
+}
+
+// Redacted summarises the entry for logging: its length, a hash and the
+// shape of its first and last bytes, at most max_bytes of them between
+// the two, masked so that no letter or digit of the contents is shown.
+// It's for logging payloads that might hold personal data in place of the
+// payload itself.
+func (entry *RBEntry) Redacted(max_bytes int) RedactedEntry {
+	redacted := RedactedEntry{Handle: entry.Handle()}
+	if entry.state != nil && entry.state.released.Load() || entry.data == nil {
+		redacted.Released = true
+		return redacted
+	}
+
+	var size uint64 = 0
+	for _, buffer := range entry.Buffers {
+		size += uint64(len(buffer))
+	}
+	redacted.Len = size
+	hash, _ := transformBuffers(entry.Buffers, []TransformStep{{"xxh64", redactionKey}}, size)
+	redacted.Hash = hash[0]
+
+	max_bytes = max(max_bytes, 0)
+	if size <= uint64(max_bytes) {
+		redacted.Head = maskBytes(entry.Buffers, 0, size)
+		return redacted
+	}
+	head := uint64(max_bytes+1) / 2
+	tail := uint64(max_bytes) - head
+	redacted.Head = maskBytes(entry.Buffers, 0, head)
+	redacted.Tail = maskBytes(entry.Buffers, size-tail, tail)
+	return redacted
+}
+
+// maskBytes masks length bytes from offset, counting through buffers as if
+// they were one.
+func maskBytes(buffers [][]byte, offset uint64, length uint64) string {
+	var masked strings.Builder
+	masked.Grow(int(length))
+	for _, buffer := range buffers {
+		if length == 0 {
+			break
+		}
+		if offset >= uint64(len(buffer)) {
+			offset -= uint64(len(buffer))
+			continue
+		}
+		count := min(length, uint64(len(buffer))-offset)
+		for _, b := range buffer[offset : offset+count] {
+			switch {
+			case b >= 'a' && b <= 'z':
+				masked.WriteByte('x')
+			case b >= 'A' && b <= 'Z':
+				masked.WriteByte('X')
+			case b >= '0' && b <= '9':
+				masked.WriteByte('9')
+			case b >= ' ' && b <= '~':
+				masked.WriteByte(b)
+			default:
+				masked.WriteByte('.')
+			}
+		}
+		offset = 0
+		length -= count
+	}
+	return masked.String()
+}
+
+func (redacted RedactedEntry) String() string {
+	if redacted.Released {
+		return fmt.Sprintf("RBEntry{%s released}", redacted.Handle)
+	}
+	if redacted.Tail == "" {
+		return fmt.Sprintf("RBEntry{%s %d bytes hash=%016x %q}",
+			redacted.Handle, redacted.Len, redacted.Hash, redacted.Head)
+	}
+	return fmt.Sprintf("RBEntry{%s %d bytes hash=%016x %q...%q}",
+		redacted.Handle, redacted.Len, redacted.Hash, redacted.Head, redacted.Tail)
+}
+
+// LogValue logs the summary as a group.
+func (redacted RedactedEntry) LogValue() slog.Value {
+	if redacted.Released {
+		return slog.GroupValue(
+			slog.String("handle", redacted.Handle.String()),
+			slog.Bool("released", true))
+	}
+	return slog.GroupValue(
+		slog.String("handle", redacted.Handle.String()),
+		slog.Uint64("len", redacted.Len),
+		slog.String("hash", fmt.Sprintf("%016x", redacted.Hash)),
+		slog.String("head", redacted.Head),
+		slog.String("tail", redacted.Tail))
+}
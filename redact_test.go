@@ -0,0 +1,44 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedacted(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+	entry, err := pool.AllocBuffers([]uint64{13, 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	copy(entry.Buffers[0], "Card 4111-11\x00")
+	copy(entry.Buffers[1], "11-1111 Jo\nE")
+
+	redacted := entry.Redacted(10)
+	if redacted.Len != 25 || redacted.Head != "Xxxx " || redacted.Tail != " Xx.X" {
+		t.Fatalf("unexpected summary %+v", redacted)
+	}
+	if whole := entry.Redacted(100); whole.Head != "Xxxx 9999-99.99-9999 Xx.X" || whole.Tail != "" ||
+		whole.Hash != redacted.Hash {
+		t.Fatalf("unexpected summary %+v", whole)
+	}
+	if line := redacted.String(); !strings.Contains(line, `25 bytes`) ||
+		!strings.HasSuffix(line, `"Xxxx "..." Xx.X"}`) {
+		t.Fatalf("unexpected line %q", line)
+	}
+
+	var logged bytes.Buffer
+	slog.New(slog.NewTextHandler(&logged, nil)).Info("payload", "entry", redacted)
+	if !strings.Contains(logged.String(), "entry.len=25") || strings.Contains(logged.String(), "4111") {
+		t.Fatalf("unexpected log line %q", logged.String())
+	}
+
+	handle := entry.Handle()
+	entry.Release()
+	if released := entry.Redacted(10); !released.Released || released.String() != "RBEntry{"+handle.String()+" released}" {
+		t.Fatalf("unexpected summary %+v", released)
+	}
+}
@@ -0,0 +1,106 @@
+package rustybuffer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ErrCacheMiss is returned for a key a cache doesn't have: a nil reply from
+// Redis or no value from memcached.
+var ErrCacheMiss = errors.New("rustybuffer: cache miss")
+
+// The clients don't let callers choose where replies are read to, so values
+// are read into pooled memory straight off the connection instead, which
+// suits a connection set aside from the client's pool for large values.
+
+// ReadRedisBulk reads a bulk string reply, in RESP2 or RESP3, into pooled
+// memory, returning the entry holding it and the value. A nil reply fails
+// with ErrCacheMiss and an error reply with the error's message. The value
+// is only valid until the entry is released.
+func (pool *Pool) ReadRedisBulk(r *bufio.Reader) (RBEntry, []byte, error) {
+	line, err := readCacheLine(r)
+	if err != nil {
+		return RBEntry{}, nil, err
+	}
+	if len(line) == 0 {
+		return RBEntry{}, nil, fmt.Errorf("%w: empty redis reply", ErrInvalidData)
+	}
+
+	switch line[0] {
+	case '$':
+	case '_':
+		return RBEntry{}, nil, ErrCacheMiss
+	case '-':
+		return RBEntry{}, nil, fmt.Errorf("rustybuffer: redis: %s", line[1:])
+	default:
+		return RBEntry{}, nil, fmt.Errorf("%w: expected a redis bulk string, got %q", ErrInvalidData, line)
+	}
+
+	length, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || length < -1 {
+		return RBEntry{}, nil, fmt.Errorf("%w: redis bulk string length %q", ErrInvalidData, line[1:])
+	}
+	if length == -1 {
+		return RBEntry{}, nil, ErrCacheMiss
+	}
+	return pool.readCacheValue(r, uint64(length))
+}
+
+// RedisGet sends GET key on rw and reads the value into pooled memory, see
+// ReadRedisBulk.
+func (pool *Pool) RedisGet(rw *bufio.ReadWriter, key string) (RBEntry, []byte, error) {
+	if _, err := fmt.Fprintf(rw, "*2\r\n$3\r\nGET\r\n$%d\r\n%s\r\n", len(key), key); err != nil {
+		return RBEntry{}, nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		return RBEntry{}, nil, err
+	}
+	return pool.ReadRedisBulk(rw.Reader)
+}
+
+// readCacheLine reads a line ending in CRLF, returning it without the CRLF,
+// or io.EOF if the connection's closed before it starts. It's only valid
+// until the next read.
+func readCacheLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("%w: reply line longer than %d bytes", ErrInvalidData, r.Size())
+	}
+	if err == io.EOF && len(line) == 0 {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("%w: reply line %q doesn't end in CRLF", ErrInvalidData, line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// readCacheValue reads a length byte value followed by CRLF into pooled
+// memory.
+func (pool *Pool) readCacheValue(r *bufio.Reader, length uint64) (RBEntry, []byte, error) {
+	entry, err := pool.AllocBuffers([]uint64{length})
+	if err != nil {
+		return RBEntry{}, nil, err
+	}
+
+	var crlf [2]byte
+	value := entry.Buffers[0]
+	if _, err = io.ReadFull(r, value); err == nil {
+		_, err = io.ReadFull(r, crlf[:])
+	}
+	if err != nil {
+		entry.Release()
+		return RBEntry{}, nil, unexpectedEOF(err)
+	}
+	if crlf != [2]byte{'\r', '\n'} {
+		entry.Release()
+		return RBEntry{}, nil, fmt.Errorf("%w: %d byte value isn't followed by CRLF", ErrInvalidData, length)
+	}
+	return entry, value, nil
+}
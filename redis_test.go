@@ -0,0 +1,63 @@
+package rustybuffer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRedisGet(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	var sent bytes.Buffer
+	replies := "$12\r\nhello\r\nworld\r\n$-1\r\n_\r\n-WRONGTYPE not a string\r\n"
+	rw := bufio.NewReadWriter(bufio.NewReader(strings.NewReader(replies)), bufio.NewWriter(&sent))
+
+	entry, value, err := pool.RedisGet(rw, "greeting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "hello\r\nworld" || sent.String() != "*2\r\n$3\r\nGET\r\n$8\r\ngreeting\r\n" {
+		t.Fatalf("unexpected value %q for %q", value, sent.String())
+	}
+	if pool.Stats().BytesInUse != 12 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+	entry.Release()
+
+	for _, expected := range []error{ErrCacheMiss, ErrCacheMiss, nil} {
+		_, _, err := pool.RedisGet(rw, "missing")
+		if expected != nil && !errors.Is(err, expected) {
+			t.Fatalf("expected %v, got %v", expected, err)
+		}
+		if expected == nil && (err == nil || !strings.Contains(err.Error(), "WRONGTYPE")) {
+			t.Fatalf("expected the error reply, got %v", err)
+		}
+	}
+}
+
+func TestReadRedisBulkErrors(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	for reply, expected := range map[string]error{
+		"":                io.EOF,
+		"$5\r\nabc":       io.ErrUnexpectedEOF,
+		"$3\r\nabcde":     ErrInvalidData,
+		"$x\r\n":          ErrInvalidData,
+		"+OK\r\n":         ErrInvalidData,
+		"$3\nabc\r\n":     ErrInvalidData,
+		"$2000000\r\nabc": ErrBufferTooLarge,
+		"$-5\r\n":         ErrInvalidData,
+	} {
+		_, _, err := pool.ReadRedisBulk(bufio.NewReader(strings.NewReader(reply)))
+		if !errors.Is(err, expected) {
+			t.Fatalf("%q: expected %v, got %v", reply, expected, err)
+		}
+	}
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+}
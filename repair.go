@@ -0,0 +1,135 @@
+package rustybuffer
+
+import (
+	"sync/atomic"
+)
+
+// RepairReport describes what SharedSegment.Repair found wrong and put
+// right.
+type RepairReport struct {
+	// The pid of a dead process this mapping took the segment's lock from,
+	// or zero.
+	BrokenLock int
+
+	// Block records that made no sense, e.g., a buffer that runs past the
+	// end of the segment or a block claimed by two, reset to free.
+	Records uint64
+
+	// Orphaned buffers given back to the segment.
+	Reclaimed []SharedBuffer
+
+	// Set if the counts of bytes and buffers in use, or the last
+	// generation, disagreed with the records and were recomputed.
+	Counters bool
+}
+
+// Repaired reports whether anything needed repairing.
+func (report RepairReport) Repaired() bool {
+	return report.BrokenLock != 0 || report.Records != 0 || len(report.Reclaimed) != 0 ||
+		report.Counters
+}
+
+// Repair checks the segment's bookkeeping and puts right anything a
+// process that crashed part way through updating it left behind, so a
+// crash never leaves the segment unusable. It's meant to be called once
+// the segment is opened, before the pool backed by it is used, but is
+// safe to call at any time.
+//
+// With reclaim_orphans set, buffers whose owner isn't running anymore are
+// given back to the segment; otherwise they're left for Pool.Adopt.
+func (seg *SharedSegment) Repair(reclaim_orphans bool) RepairReport {
+	seg.lock()
+	defer seg.unlock()
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	report := RepairReport{BrokenLock: int(seg.broken_lock.Swap(0))}
+
+	var bytes_in_use, buffers_in_use, generation uint64
+	for idx := uint64(0); idx < seg.blocks; {
+		record := seg.record(idx)
+		switch record.state {
+		case blockFree:
+			if *record != (blockRecord{}) {
+				*record = blockRecord{}
+				report.Records++
+			}
+			idx++
+			continue
+		case blockHead:
+		default:
+			// Tails are only reached here when their head is gone.
+			*record = blockRecord{}
+			report.Records++
+			idx++
+			continue
+		}
+
+		run := record.run
+		if !seg.validRun(idx, record) {
+			delete(seg.owned, idx)
+			*record = blockRecord{}
+			report.Records++
+			idx++
+			continue
+		}
+
+		// The process acquiring the buffer stopped before marking all of
+		// it taken.
+		for block := idx + 1; block < idx+run; block++ {
+			if tail := seg.record(block); *tail != (blockRecord{state: blockTail}) {
+				*tail = blockRecord{state: blockTail}
+				report.Records++
+			}
+		}
+
+		_, owned := seg.owned[idx]
+		if reclaim_orphans && !owned && !processAlive(int(record.owner)) {
+			report.Reclaimed = append(report.Reclaimed, SharedBuffer{
+				Handle:   SharedHandle{seg.id, seg.data + idx*seg.block_size, record.length, record.generation},
+				Owner:    int(record.owner),
+				Orphaned: true,
+			})
+			for block := idx; block < idx+run; block++ {
+				*seg.record(block) = blockRecord{}
+			}
+			idx += run
+			continue
+		}
+
+		bytes_in_use += run * seg.block_size
+		buffers_in_use++
+		generation = max(generation, record.generation)
+		idx += run
+	}
+
+	if atomic.LoadUint64(seg.word(48)) != bytes_in_use {
+		atomic.StoreUint64(seg.word(48), bytes_in_use)
+		report.Counters = true
+	}
+	if atomic.LoadUint64(seg.word(56)) != buffers_in_use {
+		atomic.StoreUint64(seg.word(56), buffers_in_use)
+		report.Counters = true
+	}
+	if atomic.LoadUint64(seg.word(40)) < generation {
+		atomic.StoreUint64(seg.word(40), generation)
+		report.Counters = true
+	}
+
+	return report
+}
+
+// validRun reports whether the buffer whose head is at idx fits in the
+// segment without overlapping another.
+func (seg *SharedSegment) validRun(idx uint64, record *blockRecord) bool {
+	if record.run == 0 || record.run > seg.blocks-idx || record.generation == 0 ||
+		record.length > record.run*seg.block_size {
+		return false
+	}
+	for block := idx + 1; block < idx+record.run; block++ {
+		if seg.record(block).state == blockHead {
+			return false
+		}
+	}
+	return true
+}
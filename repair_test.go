@@ -0,0 +1,80 @@
+//go:build unix
+
+package rustybuffer
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRepairSegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segment")
+	seg, err := CreateSharedSegment(path, 16*1024, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.Close()
+
+	if report := seg.Repair(true); report.Repaired() {
+		t.Fatalf("repaired a new segment: %+v", report)
+	}
+
+	// A live buffer, an orphan, and the wreckage of a crash: a lock held by
+	// a dead process, an acquire that didn't get to its tails, a stray tail
+	// and counters that don't match.
+	if _, err := seg.Acquire(2000); err != nil {
+		t.Fatal(err)
+	}
+	orphan, err := seg.Acquire(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := deadPid(t)
+	idx, _ := seg.index(orphan)
+	seg.record(idx).owner = uint32(dead)
+	delete(seg.owned, idx)
+
+	*seg.record(5) = blockRecord{blockHead, uint32(dead), 3, 2500, 99}
+	*seg.record(10) = blockRecord{state: blockTail}
+	*seg.record(12) = blockRecord{blockHead, uint32(dead), 100, 10, 100}
+	atomic.StoreUint64(seg.word(56), 7)
+	atomic.StoreUint64(seg.word(32), uint64(dead))
+
+	report := seg.Repair(false)
+	if report.BrokenLock != dead {
+		t.Fatalf("expected the lock to be taken from %d, got %+v", dead, report)
+	}
+	if report.Records != 4 || !report.Counters || len(report.Reclaimed) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if buffers := seg.Buffers(); len(buffers) != 3 || !buffers[1].Orphaned || !buffers[2].Orphaned {
+		t.Fatalf("unexpected buffers %+v", buffers)
+	}
+	if stats := seg.Stats(); stats.NumBuffers != 3 || stats.BytesInUse != 6*1024 {
+		t.Fatalf("counters weren't recomputed: %+v", stats)
+	}
+	if generation := atomic.LoadUint64(seg.word(40)); generation != 99 {
+		t.Fatalf("expected generation 99, got %d", generation)
+	}
+
+	report = seg.Repair(true)
+	if report.Records != 0 || len(report.Reclaimed) != 2 || report.Reclaimed[0].Owner != dead {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if stats := seg.Stats(); stats.NumBuffers != 1 || stats.BytesInUse != 2*1024 {
+		t.Fatalf("orphans weren't reclaimed: %+v", stats)
+	}
+	if report := seg.Repair(true); report.Repaired() {
+		t.Fatalf("repaired a repaired segment: %+v", report)
+	}
+
+	// The segment carries on as normal.
+	data, err := seg.Acquire(14 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := seg.Release(data); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,87 @@
+package rustybuffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how AcquireWithRetry backs off while a pool is
+// full. The delay before each retry starts at InitialDelay and is
+// multiplied by Multiplier after every attempt, up to MaxDelay. Each delay
+// is then randomly shortened by up to Jitter (0 to 1) of itself so that
+// callers that failed together don't all retry together.
+//
+// Retrying stops after MaxAttempts attempts or once Budget has been spent,
+// whichever comes first. Zero means no limit for either, although the
+// context always applies.
+type RetryPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	Jitter       float64
+	MaxAttempts  int
+	Budget       time.Duration
+}
+
+// DefaultRetryPolicy retries for up to a second.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialDelay: time.Millisecond,
+	MaxDelay:     100 * time.Millisecond,
+	Multiplier:   2,
+	Jitter:       0.5,
+	Budget:       time.Second,
+}
+
+// AcquireWithRetry is AcquireWithRetry on the default pool.
+func AcquireWithRetry(ctx context.Context, sizes []uint64, policy RetryPolicy) (RBEntry, error) {
+	return defaultPool.AcquireWithRetry(ctx, sizes, policy)
+}
+
+// AcquireWithRetry calls AllocBuffers until it succeeds, backing off
+// according to policy while the pool is full. Other errors aren't going to
+// go away and are returned immediately, as is the last ErrNoBufferAvailable
+// once policy or ctx says to stop. The pool's ExhaustionPolicy doesn't
+// apply, retrying is the policy.
+func (pool *Pool) AcquireWithRetry(
+	ctx context.Context,
+	sizes []uint64,
+	policy RetryPolicy,
+) (RBEntry, error) {
+	start := time.Now()
+	delay := policy.InitialDelay
+
+	for attempt := 1; ; attempt++ {
+		entry, err := pool.AllocBuffers(sizes, OnExhaustion(ExhaustionError))
+		if err == nil || !errors.Is(err, ErrNoBufferAvailable) {
+			return entry, err
+		}
+
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return RBEntry{}, err
+		}
+
+		sleep := delay
+		if policy.Jitter > 0 {
+			sleep -= time.Duration(rand.Float64() * policy.Jitter * float64(sleep))
+		}
+		if policy.Budget > 0 && time.Since(start)+sleep > policy.Budget {
+			return RBEntry{}, err
+		}
+
+		timer := time.NewTimer(sleep)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return RBEntry{}, fmt.Errorf("%w: %w", err, ctx.Err())
+		}
+
+		delay = time.Duration(float64(delay) * policy.Multiplier)
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package rustybuffer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAcquireWithRetry(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	first, err := pool.AllocBuffers([]uint64{1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		first.Release()
+	}()
+
+	entry, err := pool.AcquireWithRetry(context.Background(), []uint64{512}, DefaultRetryPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+}
+
+func TestAcquireWithRetryGivesUp(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	first, err := pool.AllocBuffers([]uint64{1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Release()
+
+	policy := RetryPolicy{
+		InitialDelay: time.Millisecond,
+		Multiplier:   2,
+		MaxAttempts:  3,
+	}
+	_, err = pool.AcquireWithRetry(context.Background(), []uint64{1}, policy)
+	if !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+
+	policy = RetryPolicy{
+		InitialDelay: time.Millisecond,
+		Multiplier:   2,
+		Budget:       10 * time.Millisecond,
+	}
+	start := time.Now()
+	_, err = pool.AcquireWithRetry(context.Background(), []uint64{1}, policy)
+	if !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("budget was ignored")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pool.AcquireWithRetry(ctx, []uint64{1}, DefaultRetryPolicy)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// Errors that retrying can't fix come straight back.
+	_, err = pool.AcquireWithRetry(context.Background(), []uint64{2048}, DefaultRetryPolicy)
+	if !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+)
+
+var (
+	// ErrRingFull is returned by Ring.Reserve when every slot holds a
+	// payload the consumer hasn't released yet.
+	ErrRingFull = errors.New("rustybuffer: ring is full")
+
+	// ErrRingEmpty is returned by Ring.Receive when there's nothing to
+	// receive.
+	ErrRingEmpty = errors.New("rustybuffer: ring is empty")
+)
+
+// A ring lives in a shared segment laid out as follows, with every field in
+// the host's byte order (both ends are on the same host) and every u64
+// accessed atomically:
+//
+//	0    u64  magic, 0x524252494e470001 ("RBRING" and version 1), stored
+//	          last when the ring is created
+//	8    u32  slot count, a power of two
+//	12   u32  unused
+//	16   u64  slot size in bytes
+//	64   u64  enqueue position, on a cache line of its own
+//	128  u64  dequeue position, likewise
+//	192  the descriptors, one per slot:
+//	       u64  sequence
+//	       u64  payload length
+//	     then, from the next multiple of 64, the payload slots.
+//
+// It's a bounded queue after Dmitry Vyukov's: slot i starts with sequence
+// i. A producer claims position p when slot p%n's sequence is p by stepping
+// enqueue from p to p+1, writes the payload and length, then stores p+1 as
+// the sequence. A consumer claims position p when the sequence is p+1 by
+// stepping dequeue, reads the payload, then stores p+n, making it the
+// producers' again. Any number of producers and consumers can share a
+// ring, so it's SPSC, MPSC or MPMC as the processes using it choose.
+const (
+	ringMagic        uint64 = 0x52425249_4e470001 // "RBRING" then 0x0001
+	ringEnqueue             = 64
+	ringDequeue             = 128
+	ringDescriptors         = 192
+	ringDescriptor          = 16
+	ringPayloadAlign        = 64
+)
+
+// Ring is a queue of payloads in memory shared with other processes,
+// e.g., a Rust backend mapping the same segment, so large payloads can be
+// exchanged without serialising or copying them: producers write straight
+// into a slot with Reserve and consumers read straight out of it with
+// Receive. A Ring is safe for concurrent use, as is the same segment
+// opened by several processes.
+//
+// A slot that's been reserved and not committed holds up every payload
+// behind it, and likewise for one received and not released, so a process
+// that dies between the two wedges the ring.
+type Ring struct {
+	mapping   []byte
+	slots     uint64
+	slot_size uint64
+	payload   uint64
+}
+
+// RingSlot is a slot claimed from a ring, by Reserve to fill and Commit or
+// by Receive to read and Release. It must be finished with exactly once.
+type RingSlot struct {
+	ring *Ring
+	pos  uint64
+	buf  []byte
+}
+
+// ringSize returns the size of a ring's segment, and the offset of its
+// payload slots.
+func ringSize(slots uint64, slot_size uint64) (uint64, uint64, bool) {
+	payload := ringDescriptors + slots*ringDescriptor
+	payload = (payload + ringPayloadAlign - 1) &^ (ringPayloadAlign - 1)
+	hi, size := bits.Mul64(slots, slot_size)
+	if hi != 0 || payload+size < size {
+		return 0, 0, false
+	}
+	return payload + size, payload, true
+}
+
+// CreateRing creates a ring with slots slots of slot_size bytes each in a
+// new file at path, e.g., under /dev/shm, for other processes to OpenRing.
+// slots must be a power of two.
+func CreateRing(path string, slots uint32, slot_size uint64) (*Ring, error) {
+	if slots == 0 || slots&(slots-1) != 0 {
+		return nil, fmt.Errorf("rustybuffer: ring slot count %d isn't a power of two", slots)
+	}
+	size, payload, ok := ringSize(uint64(slots), slot_size)
+	if !ok {
+		return nil, fmt.Errorf("rustybuffer: ring of %d %d byte slots is too large", slots, slot_size)
+	}
+
+	mapping, err := mapSegment(path, size, true)
+	if err != nil {
+		return nil, err
+	}
+
+	ring := &Ring{mapping, uint64(slots), slot_size, payload}
+	*(*uint32)(unsafe.Pointer(&mapping[8])) = slots
+	*(*uint64)(unsafe.Pointer(&mapping[16])) = slot_size
+	for idx := uint64(0); idx < ring.slots; idx++ {
+		atomic.StoreUint64(ring.sequence(idx), idx)
+	}
+	atomic.StoreUint64(ring.word(0), ringMagic)
+
+	return ring, nil
+}
+
+// OpenRing opens the ring another process created at path.
+func OpenRing(path string) (*Ring, error) {
+	mapping, err := mapSegment(path, 0, false)
+	if err != nil {
+		return nil, err
+	}
+
+	fail := func(format string, args ...any) (*Ring, error) {
+		unmapSegment(mapping)
+		return nil, fmt.Errorf("rustybuffer: %s isn't a ring: "+format, append([]any{path}, args...)...)
+	}
+	if len(mapping) < ringDescriptors {
+		return fail("only %d bytes", len(mapping))
+	}
+	if magic := atomic.LoadUint64((*uint64)(unsafe.Pointer(&mapping[0]))); magic != ringMagic {
+		return fail("magic %#x, expected %#x", magic, ringMagic)
+	}
+
+	slots := uint64(*(*uint32)(unsafe.Pointer(&mapping[8])))
+	slot_size := *(*uint64)(unsafe.Pointer(&mapping[16]))
+	size, payload, ok := ringSize(slots, slot_size)
+	if slots == 0 || slots&(slots-1) != 0 || !ok || size != uint64(len(mapping)) {
+		return fail("%d slots of %d bytes don't fit %d bytes", slots, slot_size, len(mapping))
+	}
+
+	return &Ring{mapping, slots, slot_size, payload}, nil
+}
+
+// Close unmaps the ring. Slots claimed from it mustn't be used afterwards.
+// The file stays until it's removed (with os.Remove), which processes
+// that still have it mapped don't notice.
+func (ring *Ring) Close() error {
+	return unmapSegment(ring.mapping)
+}
+
+// SlotSize is the most a payload can hold.
+func (ring *Ring) SlotSize() uint64 {
+	return ring.slot_size
+}
+
+func (ring *Ring) word(offset uint64) *uint64 {
+	return (*uint64)(unsafe.Pointer(&ring.mapping[offset]))
+}
+
+func (ring *Ring) sequence(idx uint64) *uint64 {
+	return ring.word(ringDescriptors + idx*ringDescriptor)
+}
+
+func (ring *Ring) length(idx uint64) *uint64 {
+	return ring.word(ringDescriptors + idx*ringDescriptor + 8)
+}
+
+func (ring *Ring) slot(idx uint64) []byte {
+	start := ring.payload + idx*ring.slot_size
+	return ring.mapping[start : start+ring.slot_size : start+ring.slot_size]
+}
+
+// Reserve claims the next free slot for a payload, returning ErrRingFull
+// if there isn't one. Write the payload into the slot's Bytes, then
+// Commit it.
+func (ring *Ring) Reserve() (RingSlot, error) {
+	enqueue := ring.word(ringEnqueue)
+	for {
+		pos := atomic.LoadUint64(enqueue)
+		idx := pos & (ring.slots - 1)
+		dif := int64(atomic.LoadUint64(ring.sequence(idx)) - pos)
+		switch {
+		case dif == 0:
+			if atomic.CompareAndSwapUint64(enqueue, pos, pos+1) {
+				return RingSlot{ring, pos, ring.slot(idx)}, nil
+			}
+		case dif < 0:
+			return RingSlot{}, ErrRingFull
+		}
+	}
+}
+
+// Receive claims the oldest committed payload, returning ErrRingEmpty if
+// there isn't one. Read it from the slot's Bytes, then Release it.
+func (ring *Ring) Receive() (RingSlot, error) {
+	dequeue := ring.word(ringDequeue)
+	for {
+		pos := atomic.LoadUint64(dequeue)
+		idx := pos & (ring.slots - 1)
+		dif := int64(atomic.LoadUint64(ring.sequence(idx)) - (pos + 1))
+		switch {
+		case dif == 0:
+			if atomic.CompareAndSwapUint64(dequeue, pos, pos+1) {
+				length := min(atomic.LoadUint64(ring.length(idx)), ring.slot_size)
+				return RingSlot{ring, pos, ring.slot(idx)[:length]}, nil
+			}
+		case dif < 0:
+			return RingSlot{}, ErrRingEmpty
+		}
+	}
+}
+
+// Send copies payload into the next free slot and commits it.
+func (ring *Ring) Send(payload []byte) error {
+	if uint64(len(payload)) > ring.slot_size {
+		return fmt.Errorf("%w: %d byte payload with %d byte slots",
+			ErrShortView, len(payload), ring.slot_size)
+	}
+	slot, err := ring.Reserve()
+	if err != nil {
+		return err
+	}
+	copy(slot.buf, payload)
+	return slot.Commit(len(payload))
+}
+
+// Bytes is the slot's memory: all of it for a reserved slot, the payload
+// for a received one. It mustn't be used once the slot is committed or
+// released.
+func (slot RingSlot) Bytes() []byte {
+	return slot.buf
+}
+
+// Commit publishes the first length bytes of a reserved slot to consumers.
+func (slot RingSlot) Commit(length int) error {
+	if length < 0 || length > len(slot.buf) {
+		return fmt.Errorf("%w: %d byte payload with %d byte slots",
+			ErrShortView, length, len(slot.buf))
+	}
+
+	idx := slot.pos & (slot.ring.slots - 1)
+	atomic.StoreUint64(slot.ring.length(idx), uint64(length))
+	atomic.StoreUint64(slot.ring.sequence(idx), slot.pos+1)
+	return nil
+}
+
+// Release hands a received slot back to producers.
+func (slot RingSlot) Release() {
+	idx := slot.pos & (slot.ring.slots - 1)
+	atomic.StoreUint64(slot.ring.sequence(idx), slot.pos+slot.ring.slots)
+}
@@ -0,0 +1,173 @@
+//go:build unix
+
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ring")
+	producer, err := CreateRing(path, 4, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+
+	// Another mapping of the segment, as another process would have.
+	consumer, err := OpenRing(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	if consumer.SlotSize() != 64 {
+		t.Fatalf("unexpected slot size %d", consumer.SlotSize())
+	}
+
+	if _, err := consumer.Receive(); !errors.Is(err, ErrRingEmpty) {
+		t.Fatalf("expected ErrRingEmpty, got %v", err)
+	}
+
+	// Written in place, and read in place.
+	slot, err := producer.Reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slot.Bytes()) != 64 {
+		t.Fatalf("unexpected slot of %d bytes", len(slot.Bytes()))
+	}
+	copy(slot.Bytes(), "hello")
+	if _, err := consumer.Receive(); !errors.Is(err, ErrRingEmpty) {
+		t.Fatal("received a payload before it was committed")
+	}
+	if err := slot.Commit(5); err != nil {
+		t.Fatal(err)
+	}
+
+	received, err := consumer.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(received.Bytes()) != "hello" {
+		t.Fatalf("unexpected payload %q", received.Bytes())
+	}
+	received.Release()
+
+	for idx := 0; idx < 4; idx++ {
+		if err := producer.Send([]byte{byte(idx)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := producer.Send(nil); !errors.Is(err, ErrRingFull) {
+		t.Fatalf("expected ErrRingFull, got %v", err)
+	}
+	if err := producer.Send(make([]byte, 65)); !errors.Is(err, ErrShortView) {
+		t.Fatalf("expected ErrShortView, got %v", err)
+	}
+	for idx := 0; idx < 4; idx++ {
+		received, err := consumer.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(received.Bytes()) != 1 || received.Bytes()[0] != byte(idx) {
+			t.Fatalf("payload %d out of order: %v", idx, received.Bytes())
+		}
+		received.Release()
+	}
+}
+
+func TestRingInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := CreateRing(filepath.Join(dir, "ring"), 3, 64); err == nil {
+		t.Fatal("expected an error for 3 slots")
+	}
+
+	path := filepath.Join(dir, "exists")
+	ring, err := CreateRing(path, 2, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring.Close()
+	if _, err := CreateRing(path, 2, 8); err == nil {
+		t.Fatal("expected an error creating over an existing ring")
+	}
+
+	pool := filepath.Join(dir, "other")
+	other, err := mapSegment(pool, 4096, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmapSegment(other)
+	if _, err := OpenRing(pool); err == nil {
+		t.Fatal("expected an error opening something that isn't a ring")
+	}
+}
+
+func TestRingConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ring")
+	ring, err := CreateRing(path, 8, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+
+	const producers = 4
+	const messages = 1000
+
+	var wg sync.WaitGroup
+	for producer := 0; producer < producers; producer++ {
+		wg.Add(1)
+		go func(producer int) {
+			defer wg.Done()
+
+			consumer, err := OpenRing(path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer consumer.Close()
+
+			for idx := 0; idx < messages; {
+				err := consumer.Send([]byte(fmt.Sprintf("%d:%d", producer, idx)))
+				if errors.Is(err, ErrRingFull) {
+					runtime.Gosched()
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				idx++
+			}
+		}(producer)
+	}
+
+	next := make([]int, producers)
+	for received := 0; received < producers*messages; {
+		slot, err := ring.Receive()
+		if errors.Is(err, ErrRingEmpty) {
+			runtime.Gosched()
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var producer, idx int
+		if _, err := fmt.Sscanf(string(slot.Bytes()), "%d:%d", &producer, &idx); err != nil {
+			t.Fatalf("bad payload %q: %v", slot.Bytes(), err)
+		}
+		slot.Release()
+		if idx != next[producer] {
+			t.Fatalf("producer %d's message %d arrived when %d was expected", producer, idx, next[producer])
+		}
+		next[producer]++
+		received++
+	}
+	wg.Wait()
+}
@@ -0,0 +1,628 @@
+//go:build cgo
+
+package rustybuffer
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"runtime"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// The library is either linked statically (rust_static.go), linked from a
+// system install with the rustybuffer_system build tag (rust_system.go), or
+// loaded at runtime with the rustybuffer_dynamic build tag
+// (rust_dynamic.go).
+
+/*
+#include <stdint.h>
+#include "./lib/rustybuffer.h"
+
+// The Rust side keeps the last error per thread, so it has to be fetched
+// in the same cgo call as the failure, before the goroutine can move.
+
+static uint8_t
+rb_acquire(uint64_t size, void **data, char *err, uint64_t err_len)
+{
+    uint8_t res = rustybuffer_acquire(size, data);
+    if (res != 0) {
+        rustybuffer_last_error(err, err_len);
+    }
+    return res;
+}
+
+static uint8_t
+rb_lz4_compress(const void *src, uint64_t src_len, void *dst, uint64_t dst_len,
+    uint64_t *out_len, char *err, uint64_t err_len)
+{
+    uint8_t res = rustybuffer_lz4_compress(src, src_len, dst, dst_len, out_len);
+    if (res != 0) {
+        rustybuffer_last_error(err, err_len);
+    }
+    return res;
+}
+
+static uint8_t
+rb_lz4_content_size(const void *src, uint64_t src_len, uint64_t *size,
+    char *err, uint64_t err_len)
+{
+    uint8_t res = rustybuffer_lz4_content_size(src, src_len, size);
+    if (res != 0) {
+        rustybuffer_last_error(err, err_len);
+    }
+    return res;
+}
+
+static uint8_t
+rb_lz4_decompress(const void *src, uint64_t src_len, void *dst, uint64_t dst_len,
+    uint64_t *out_len, char *err, uint64_t err_len)
+{
+    uint8_t res = rustybuffer_lz4_decompress(src, src_len, dst, dst_len, out_len);
+    if (res != 0) {
+        rustybuffer_last_error(err, err_len);
+    }
+    return res;
+}
+
+static uint8_t
+rb_transform(const rustybuffer_span_t *spans, uint64_t span_count,
+    rustybuffer_transform_step_t *steps, uint64_t step_count, char *err, uint64_t err_len)
+{
+    uint8_t res = rustybuffer_transform(spans, span_count, steps, step_count);
+    if (res != 0) {
+        rustybuffer_last_error(err, err_len);
+    }
+    return res;
+}
+
+// Exported from Go in events_cgo.go.
+extern void rbgo_library_event(uint32_t, uint64_t);
+
+static void
+rb_report_events(void)
+{
+    rustybuffer_set_event_callback(rbgo_library_event);
+}
+
+static uint8_t
+rb_fill_random(void *data, uint64_t len, char *err, uint64_t err_len)
+{
+    uint8_t res = rustybuffer_fill_random(data, len);
+    if (res != 0) {
+        rustybuffer_last_error(err, err_len);
+    }
+    return res;
+}
+
+static uint8_t
+rb_release(void *data, char *err, uint64_t err_len)
+{
+    uint8_t res = rustybuffer_release(data);
+    if (res != 0) {
+        rustybuffer_last_error(err, err_len);
+    }
+    return res;
+}
+*/
+import "C"
+
+var libraryCheck struct {
+	once sync.Once
+	info Library
+	err  error
+}
+
+// ensureLibrary makes sure the library is loaded and compatible before the
+// first call into it, and records what it's capable of.
+func ensureLibrary() error {
+	libraryCheck.once.Do(func() {
+		if err := loadLibrary(); err != nil {
+			libraryCheck.err = err
+			return
+		}
+		libraryCheck.info = queryLibrary()
+		libraryCheck.err = checkLibraryVersion()
+	})
+
+	return libraryCheck.err
+}
+
+// LibraryInfo returns the version and capabilities of the Rust library,
+// loading it first if need be. The error is the reason the library can't
+// be used, in which case the returned info may still describe it.
+func LibraryInfo() (Library, error) {
+	err := ensureLibrary()
+	return libraryCheck.info, err
+}
+
+func queryLibrary() Library {
+	version := uint32(C.rustybuffer_version())
+	info := Library{
+		Major:        version >> 16 & 0xFF,
+		Minor:        version >> 8 & 0xFF,
+		Patch:        version & 0xFF,
+		Capabilities: Capabilities(C.rustybuffer_capabilities()),
+		Native:       true,
+	}
+	info.Version = fmt.Sprintf("%d.%d.%d", info.Major, info.Minor, info.Patch)
+
+	return info
+}
+
+func checkLibraryVersion() error {
+	info := libraryCheck.info
+	if !versionCompatible(info.Major, info.Minor) {
+		return fmt.Errorf(
+			"%w: found %s, these bindings need %d.%d",
+			ErrIncompatibleLibrary,
+			info.Version,
+			BindingsVersionMajor, BindingsVersionMinor,
+		)
+	}
+
+	return nil
+}
+
+// max_total_size - The total number of bytes hat RustyBuffers will allocate
+// max_buffer_size - The maximum number of bytes in a single buffer
+//
+// Configure is safe to call at any time, including while other goroutines
+// are acquiring and releasing: the library's one lock orders it with
+// everything else. The new limits apply to acquires from then on, buffers
+// already handed out are left alone.
+func Configure(max_total_size uint64, max_buffer_size uint64) {
+	if err := ensureLibrary(); err != nil {
+		panic(err)
+	}
+
+	c_max_total := C.uint64_t(max_total_size)
+	c_max_buffer := C.uint64_t(max_buffer_size)
+	res := C.rustybuffer_config(c_max_total, c_max_buffer)
+	if res != 0 {
+		panic("something something return (nil, err) thing")
+	}
+}
+
+// rustAllocator is the Allocator backed by the Rust library. There is a
+// single allocator per process so every rustAllocator shares the limits set
+// by Configure.
+type rustAllocator struct{}
+
+// NewRustAllocator returns the default Allocator, backed by the Rust
+// library's buffer cache.
+func NewRustAllocator() Allocator {
+	return rustAllocator{}
+}
+
+func (rustAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	if err := ensureLibrary(); err != nil {
+		return nil, err
+	}
+
+	var data unsafe.Pointer
+	var c_err [256]C.char
+	countCgo(cgoAcquire, 1)
+	res := C.rb_acquire(C.uint64_t(size), &data, &c_err[0], C.uint64_t(len(c_err)))
+	if res != 0 {
+		return nil, rustError(res, &c_err)
+	}
+
+	return data, nil
+}
+
+func (rustAllocator) Release(data unsafe.Pointer) error {
+	if err := ensureLibrary(); err != nil {
+		return err
+	}
+
+	var c_err [256]C.char
+	countCgo(cgoRelease, 1)
+	res := C.rb_release(data, &c_err[0], C.uint64_t(len(c_err)))
+	if res != 0 {
+		return rustError(res, &c_err)
+	}
+
+	return nil
+}
+
+func rustError(res C.uint8_t, c_err *[256]C.char) error {
+	return &Error{uint8(res), C.GoString(&c_err[0])}
+}
+
+func (rustAllocator) Stats() Stats {
+	if err := ensureLibrary(); err != nil {
+		panic(err)
+	}
+
+	if !libraryCheck.info.Has(CapabilityStats) {
+		return Stats{}
+	}
+
+	var c_stats C.rustybuffer_stats_t
+	countCgo(cgoStats, 1)
+	if res := C.rustybuffer_stats(&c_stats); res != 0 {
+		panic("rustybuffer_stats failed")
+	}
+
+	return Stats{
+		MaxTotalSize:   uint64(c_stats.max_total_size),
+		MaxBufferSize:  uint64(c_stats.max_buffer_size),
+		BytesAllocated: uint64(c_stats.bytes_allocated),
+		BytesInUse:     uint64(c_stats.bytes_in_use),
+		NumBuffers:     uint64(c_stats.num_buffers),
+		NumAvailable:   uint64(c_stats.num_available),
+	}
+}
+
+// fillBytes uses the library's vectorised fill for buffers big enough to
+// be worth the cgo call.
+func fillBytes(buf []byte, value byte) {
+	if len(buf) < fillNativeThreshold || ensureLibrary() != nil ||
+		!libraryCheck.info.Has(CapabilityFill) {
+		goFill(buf, value)
+		return
+	}
+
+	countCgo(cgoFill, 1)
+	C.rustybuffer_fill(unsafe.Pointer(unsafe.SliceData(buf)), C.uint64_t(len(buf)), C.uint8_t(value))
+}
+
+// fillRandom has the library read random bytes from the OS straight into
+// buf, when it can.
+func fillRandom(buf []byte) error {
+	if ensureLibrary() != nil || !libraryCheck.info.Has(CapabilityFillRandom) {
+		return goFillRandom(buf)
+	}
+
+	var c_err [256]C.char
+	countCgo(cgoFillRandom, 1)
+	res := C.rb_fill_random(unsafe.Pointer(unsafe.SliceData(buf)), C.uint64_t(len(buf)),
+		&c_err[0], C.uint64_t(len(c_err)))
+	if res != 0 {
+		return rustError(res, &c_err)
+	}
+	return nil
+}
+
+// copySegments hands every segment to the library in one call once
+// there are enough bytes to be worth it. The segments are passed as
+// addresses, so each buffer is kept alive by segments until the call
+// returns.
+func copySegments(segments []copySegment, size uint64) {
+	if size < copyNativeThreshold || ensureLibrary() != nil ||
+		!libraryCheck.info.Has(CapabilityCopy) {
+		goCopySegments(segments)
+		return
+	}
+
+	c_segments := make([]C.rustybuffer_copy_segment_t, len(segments))
+	for idx, seg := range segments {
+		c_segments[idx] = C.rustybuffer_copy_segment_t{
+			dst: C.uint64_t(uintptr(unsafe.Pointer(unsafe.SliceData(seg.dst)))),
+			src: C.uint64_t(uintptr(unsafe.Pointer(unsafe.SliceData(seg.src)))),
+			len: C.uint64_t(len(seg.dst)),
+		}
+	}
+
+	countCgo(cgoCopy, 1)
+	C.rustybuffer_copy(unsafe.SliceData(c_segments), C.uint64_t(len(c_segments)))
+	runtime.KeepAlive(segments)
+}
+
+// compareBytes uses the library's comparison once both buffers are big
+// enough to be worth the cgo call.
+func compareBytes(a []byte, b []byte) int {
+	if min(len(a), len(b)) < compareNativeThreshold || ensureLibrary() != nil ||
+		!libraryCheck.info.Has(CapabilityCompare) {
+		return bytes.Compare(a, b)
+	}
+
+	countCgo(cgoCompare, 1)
+	return int(C.rustybuffer_compare(
+		unsafe.Pointer(unsafe.SliceData(a)), C.uint64_t(len(a)),
+		unsafe.Pointer(unsafe.SliceData(b)), C.uint64_t(len(b)),
+	))
+}
+
+// constantTimeEqual always uses the library when it can, the size of the
+// buffers isn't secret but their contents are.
+func constantTimeEqual(a []byte, b []byte) bool {
+	if len(a) == 0 || ensureLibrary() != nil ||
+		!libraryCheck.info.Has(CapabilityConstantTimeEqual) {
+		return subtle.ConstantTimeCompare(a, b) == 1
+	}
+
+	countCgo(cgoConstantTimeEqual, 1)
+	return C.rustybuffer_constant_time_equal(
+		unsafe.Pointer(unsafe.SliceData(a)),
+		unsafe.Pointer(unsafe.SliceData(b)),
+		C.uint64_t(len(a)),
+	) == 1
+}
+
+// checksumBuffers has the library checksum buffers totalling size bytes
+// if that's worth the cgo calls.
+func checksumBuffers(algo ChecksumAlgorithm, buffers [][]byte, size uint64) uint64 {
+	if size < checksumNativeThreshold || ensureLibrary() != nil ||
+		!libraryCheck.info.Has(CapabilityChecksum) {
+		return goChecksum(algo, buffers)
+	}
+
+	// One call per buffer, and for xxHash one each to start and finish.
+	calls := uint64(len(buffers))
+	if algo != ChecksumCRC32C {
+		calls += 2
+	}
+	countCgo(cgoChecksum, calls)
+	if calls > 1 {
+		cgoCalls.batchable_checksums.Add(calls - 1)
+	}
+
+	if algo == ChecksumCRC32C {
+		var crc C.uint32_t = 0
+		for _, buffer := range buffers {
+			crc = C.rustybuffer_crc32c(crc,
+				unsafe.Pointer(unsafe.SliceData(buffer)), C.uint64_t(len(buffer)))
+		}
+		return uint64(crc)
+	}
+
+	var state C.rustybuffer_xxh64_t
+	C.rustybuffer_xxh64_reset(&state, 0)
+	for _, buffer := range buffers {
+		C.rustybuffer_xxh64_update(&state,
+			unsafe.Pointer(unsafe.SliceData(buffer)), C.uint64_t(len(buffer)))
+	}
+	return uint64(C.rustybuffer_xxh64_digest(&state))
+}
+
+// writevChecksum has the library write and checksum buffers in one call.
+// The spans are addresses, so buffers is kept alive until it returns.
+func writevChecksum(fd uintptr, algo ChecksumAlgorithm, buffers [][]byte) (uint64, uint64, error) {
+	if ensureLibrary() != nil || !libraryCheck.info.Has(CapabilityWritevChecksum) {
+		return goWritevChecksum(fd, algo, buffers)
+	}
+
+	spans := make([]C.rustybuffer_span_t, len(buffers))
+	for idx, buffer := range buffers {
+		spans[idx] = C.rustybuffer_span_t{
+			data: C.uint64_t(uintptr(unsafe.Pointer(unsafe.SliceData(buffer)))),
+			len:  C.uint64_t(len(buffer)),
+		}
+	}
+
+	var written, checksum C.uint64_t
+	countCgo(cgoWritevChecksum, 1)
+	res := C.rustybuffer_writev_checksum(C.int32_t(fd), unsafe.SliceData(spans), C.uint64_t(len(spans)),
+		C.uint32_t(algo), &written, &checksum)
+	runtime.KeepAlive(buffers)
+	if res != 0 {
+		return uint64(written), 0, syscall.Errno(res)
+	}
+	return uint64(written), uint64(checksum), nil
+}
+
+// The library's transforms, by name and in the order it lists them,
+// looked up the first time they're needed.
+var libraryTransforms struct {
+	once  sync.Once
+	names []string
+	ids   map[string]uint64
+}
+
+func nativeTransforms() ([]string, map[string]uint64) {
+	libraryTransforms.once.Do(func() {
+		libraryTransforms.ids = make(map[string]uint64)
+		var name [64]C.char
+		for id := uint64(0); ; id++ {
+			countCgo(cgoTransform, 1)
+			size := C.rustybuffer_transform_name(C.uint64_t(id), &name[0], C.uint64_t(len(name)))
+			if size == 0 {
+				break
+			}
+			libraryTransforms.names = append(libraryTransforms.names, C.GoString(&name[0]))
+			libraryTransforms.ids[C.GoString(&name[0])] = id
+		}
+	})
+	return libraryTransforms.names, libraryTransforms.ids
+}
+
+func transformNames() []string {
+	if ensureLibrary() != nil || !libraryCheck.info.Has(CapabilityTransform) {
+		return goTransformNames()
+	}
+	names, _ := nativeTransforms()
+	return names
+}
+
+// transformBuffers has the library apply every step in one call, if the
+// buffers total enough bytes to be worth it or a step is one only the
+// library has. The buffers are passed as addresses, so they're kept alive
+// until the call returns.
+func transformBuffers(buffers [][]byte, steps []TransformStep, size uint64) ([]uint64, error) {
+	if ensureLibrary() != nil || !libraryCheck.info.Has(CapabilityTransform) {
+		return goTransformBuffers(buffers, steps)
+	}
+
+	_, ids := nativeTransforms()
+	native := size >= transformNativeThreshold
+	c_steps := make([]C.rustybuffer_transform_step_t, len(steps))
+	for idx, step := range steps {
+		id, ok := ids[step.Name]
+		if !ok {
+			return nil, fmt.Errorf("rustybuffer: unknown transform %q", step.Name)
+		}
+		if _, ok := goTransforms[step.Name]; !ok {
+			native = true
+		}
+		c_steps[idx] = C.rustybuffer_transform_step_t{id: C.uint64_t(id), arg: C.uint64_t(step.Arg)}
+	}
+	if !native {
+		return goTransformBuffers(buffers, steps)
+	}
+
+	spans := make([]C.rustybuffer_span_t, len(buffers))
+	for idx, buffer := range buffers {
+		spans[idx] = C.rustybuffer_span_t{
+			data: C.uint64_t(uintptr(unsafe.Pointer(unsafe.SliceData(buffer)))),
+			len:  C.uint64_t(len(buffer)),
+		}
+	}
+
+	var c_err [256]C.char
+	countCgo(cgoTransform, 1)
+	res := C.rb_transform(unsafe.SliceData(spans), C.uint64_t(len(spans)),
+		unsafe.SliceData(c_steps), C.uint64_t(len(c_steps)), &c_err[0], C.uint64_t(len(c_err)))
+	runtime.KeepAlive(buffers)
+	if res != 0 {
+		return nil, rustError(res, &c_err)
+	}
+
+	results := make([]uint64, len(steps))
+	for idx := range c_steps {
+		results[idx] = uint64(c_steps[idx].result)
+	}
+	return results, nil
+}
+
+// The LZ4 functions are only called once checkCompression has made sure
+// the library has them.
+
+func compressBound(size uint64) (uint64, error) {
+	countCgo(cgoCompress, 1)
+	bound := uint64(C.rustybuffer_lz4_bound(C.uint64_t(size)))
+	if bound == 0 {
+		return 0, newError(codeBufferTooLarge, "%d bytes is too much to compress", size)
+	}
+	return bound, nil
+}
+
+func compressInto(src []byte, dst []byte) (uint64, error) {
+	var size C.uint64_t
+	var c_err [256]C.char
+	countCgo(cgoCompress, 1)
+	res := C.rb_lz4_compress(
+		unsafe.Pointer(unsafe.SliceData(src)), C.uint64_t(len(src)),
+		unsafe.Pointer(unsafe.SliceData(dst)), C.uint64_t(len(dst)),
+		&size, &c_err[0], C.uint64_t(len(c_err)))
+	if res != 0 {
+		return 0, rustError(res, &c_err)
+	}
+	return uint64(size), nil
+}
+
+func decompressedSize(src []byte) (uint64, error) {
+	var size C.uint64_t
+	var c_err [256]C.char
+	countCgo(cgoCompress, 1)
+	res := C.rb_lz4_content_size(unsafe.Pointer(unsafe.SliceData(src)), C.uint64_t(len(src)),
+		&size, &c_err[0], C.uint64_t(len(c_err)))
+	if res != 0 {
+		return 0, rustError(res, &c_err)
+	}
+	return uint64(size), nil
+}
+
+func decompressInto(src []byte, dst []byte) (uint64, error) {
+	var size C.uint64_t
+	var c_err [256]C.char
+	countCgo(cgoCompress, 1)
+	res := C.rb_lz4_decompress(
+		unsafe.Pointer(unsafe.SliceData(src)), C.uint64_t(len(src)),
+		unsafe.Pointer(unsafe.SliceData(dst)), C.uint64_t(len(dst)),
+		&size, &c_err[0], C.uint64_t(len(c_err)))
+	if res != 0 {
+		return 0, rustError(res, &c_err)
+	}
+	return uint64(size), nil
+}
+
+// Trim frees the library's cached buffers, or does nothing if it can't.
+func (rustAllocator) Trim() uint64 {
+	if ensureLibrary() != nil || !libraryCheck.info.Has(CapabilityTrim) {
+		return 0
+	}
+	countCgo(cgoTrim, 1)
+	return uint64(C.rustybuffer_trim())
+}
+
+// reportEvents has the library report its events, returning false if it
+// can't.
+func reportEvents() bool {
+	if ensureLibrary() != nil || !libraryCheck.info.Has(CapabilityEvents) {
+		return false
+	}
+	countCgo(cgoEvents, 1)
+	C.rb_report_events()
+	return true
+}
+
+// trimAsync trims on a thread of the library's own, returning false if it
+// can't.
+func trimAsync() bool {
+	if ensureLibrary() != nil || !libraryCheck.info.Has(CapabilityEvents) {
+		return false
+	}
+	countCgo(cgoTrim, 1)
+	C.rustybuffer_trim_async()
+	return true
+}
+
+func (rustAllocator) FreeExtents() ([]uint64, []uint64, error) {
+	if err := ensureLibrary(); err != nil {
+		return nil, nil, err
+	}
+
+	if !libraryCheck.info.Has(CapabilityCachedSizes) {
+		return nil, nil, fmt.Errorf("rustybuffer: library %s can't list cached buffers",
+			libraryCheck.info.Version)
+	}
+
+	// As for LiveHandles, buffers can be cached between asking how many
+	// there are and fetching them.
+	countCgo(cgoCachedSizes, 1)
+	count := C.rustybuffer_cached_sizes(nil, 0)
+	for {
+		countCgo(cgoCachedSizes, 1)
+		sizes := make([]C.uint64_t, count+count/4+16)
+		count = C.rustybuffer_cached_sizes(&sizes[0], C.uint64_t(len(sizes)))
+		if int(count) <= len(sizes) {
+			cached := make([]uint64, count)
+			for idx := range cached {
+				cached[idx] = uint64(sizes[idx])
+			}
+			return cacheExtents(rustAllocator{}.Stats()), cached, nil
+		}
+	}
+}
+
+func (rustAllocator) LiveHandles() ([]uintptr, error) {
+	if err := ensureLibrary(); err != nil {
+		return nil, err
+	}
+
+	if !libraryCheck.info.Has(CapabilityLiveHandles) {
+		return nil, fmt.Errorf("rustybuffer: library %s can't list live handles",
+			libraryCheck.info.Version)
+	}
+
+	// Buffers can be acquired between asking how many there are and
+	// fetching them, so leave some room and try again if it wasn't enough.
+	countCgo(cgoLiveHandles, 1)
+	count := C.rustybuffer_live_handles(nil, 0)
+	for {
+		countCgo(cgoLiveHandles, 1)
+		handles := make([]C.uint64_t, count+count/4+16)
+		count = C.rustybuffer_live_handles(&handles[0], C.uint64_t(len(handles)))
+		if int(count) <= len(handles) {
+			live := make([]uintptr, count)
+			for idx := range live {
+				live[idx] = uintptr(handles[idx])
+			}
+			return live, nil
+		}
+	}
+}
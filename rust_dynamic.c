@@ -0,0 +1,396 @@
+//go:build cgo && rustybuffer_dynamic
+
+// Stand-ins for the Rust library's exports which forward to a copy of the
+// library loaded with dlopen (LoadLibrary on Windows). rust_dynamic.go makes
+// sure the library is loaded before any of these are called.
+
+#include <stdio.h>
+#include <stdint.h>
+#include <string.h>
+
+#ifdef _WIN32
+#include <windows.h>
+#else
+#include <dlfcn.h>
+#endif
+
+#include "./lib/rustybuffer.h"
+
+static uint32_t (*version_fn)(void);
+static uint64_t (*capabilities_fn)(void);
+static uint8_t (*config_fn)(uint64_t, uint64_t);
+static uint8_t (*acquire_fn)(uint64_t, void **);
+static uint8_t (*release_fn)(void *);
+static uint8_t (*stats_fn)(rustybuffer_stats_t *);
+static uint64_t (*live_handles_fn)(uint64_t *, uint64_t);
+static uint64_t (*cached_sizes_fn)(uint64_t *, uint64_t);
+static uint64_t (*trim_fn)(void);
+static void (*trim_async_fn)(void);
+static void (*set_event_callback_fn)(rustybuffer_event_callback_t);
+static void (*fill_fn)(void *, uint64_t, uint8_t);
+static uint8_t (*fill_random_fn)(void *, uint64_t);
+static void (*copy_fn)(const rustybuffer_copy_segment_t *, uint64_t);
+static int32_t (*compare_fn)(const void *, uint64_t, const void *, uint64_t);
+static uint8_t (*constant_time_equal_fn)(const void *, const void *, uint64_t);
+static uint32_t (*crc32c_fn)(uint32_t, const void *, uint64_t);
+static void (*xxh64_reset_fn)(rustybuffer_xxh64_t *, uint64_t);
+static void (*xxh64_update_fn)(rustybuffer_xxh64_t *, const void *, uint64_t);
+static uint64_t (*xxh64_digest_fn)(const rustybuffer_xxh64_t *);
+static int32_t (*writev_checksum_fn)(int32_t, const rustybuffer_span_t *, uint64_t, uint32_t,
+    uint64_t *, uint64_t *);
+static uint64_t (*transform_name_fn)(uint64_t, char *, uint64_t);
+static uint8_t (*transform_fn)(const rustybuffer_span_t *, uint64_t,
+    rustybuffer_transform_step_t *, uint64_t);
+static uint64_t (*lz4_bound_fn)(uint64_t);
+static uint8_t (*lz4_compress_fn)(const void *, uint64_t, void *, uint64_t, uint64_t *);
+static uint8_t (*lz4_content_size_fn)(const void *, uint64_t, uint64_t *);
+static uint8_t (*lz4_decompress_fn)(const void *, uint64_t, void *, uint64_t, uint64_t *);
+static uint64_t (*last_error_fn)(char *, uint64_t);
+
+#ifdef _WIN32
+typedef HMODULE library_t;
+
+static library_t
+open_library(const char *path, char *err, size_t err_len)
+{
+    HMODULE handle = LoadLibraryA(path);
+    if (handle == NULL) {
+        snprintf(err, err_len, "LoadLibrary failed with error %lu",
+            (unsigned long) GetLastError());
+    }
+    return handle;
+}
+
+static void *
+library_symbol(library_t handle, const char *name)
+{
+    return (void *) GetProcAddress(handle, name);
+}
+
+static void
+close_library(library_t handle)
+{
+    FreeLibrary(handle);
+}
+#else
+typedef void *library_t;
+
+static library_t
+open_library(const char *path, char *err, size_t err_len)
+{
+    void *handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+    if (handle == NULL) {
+        snprintf(err, err_len, "%s", dlerror());
+    }
+    return handle;
+}
+
+static void *
+library_symbol(library_t handle, const char *name)
+{
+    return dlsym(handle, name);
+}
+
+static void
+close_library(library_t handle)
+{
+    dlclose(handle);
+}
+#endif
+
+static void *
+load_symbol(library_t handle, const char *name, char *err, size_t err_len)
+{
+    void *sym = library_symbol(handle, name);
+    if (sym == NULL) {
+        snprintf(err, err_len, "missing symbol %s", name);
+    }
+    return sym;
+}
+
+int
+rustybuffer_dlopen(const char *path, char *err, size_t err_len)
+{
+    library_t handle = open_library(path, err, err_len);
+    if (handle == NULL) {
+        return -1;
+    }
+
+    void *version = load_symbol(handle, "rustybuffer_version", err, err_len);
+    void *config = load_symbol(handle, "rustybuffer_config", err, err_len);
+    void *acquire = load_symbol(handle, "rustybuffer_acquire", err, err_len);
+    void *release = load_symbol(handle, "rustybuffer_release", err, err_len);
+    if (!version || !config || !acquire || !release) {
+        close_library(handle);
+        return -1;
+    }
+
+    // Optional, older libraries lack these. rustybuffer_capabilities is
+    // expected to report as much so the bindings can work around them.
+    capabilities_fn = library_symbol(handle, "rustybuffer_capabilities");
+    stats_fn = library_symbol(handle, "rustybuffer_stats");
+    live_handles_fn = library_symbol(handle, "rustybuffer_live_handles");
+    cached_sizes_fn = library_symbol(handle, "rustybuffer_cached_sizes");
+    trim_fn = library_symbol(handle, "rustybuffer_trim");
+    trim_async_fn = library_symbol(handle, "rustybuffer_trim_async");
+    set_event_callback_fn = library_symbol(handle, "rustybuffer_set_event_callback");
+    fill_fn = library_symbol(handle, "rustybuffer_fill");
+    fill_random_fn = library_symbol(handle, "rustybuffer_fill_random");
+    copy_fn = library_symbol(handle, "rustybuffer_copy");
+    compare_fn = library_symbol(handle, "rustybuffer_compare");
+    constant_time_equal_fn = library_symbol(handle, "rustybuffer_constant_time_equal");
+    crc32c_fn = library_symbol(handle, "rustybuffer_crc32c");
+    xxh64_reset_fn = library_symbol(handle, "rustybuffer_xxh64_reset");
+    xxh64_update_fn = library_symbol(handle, "rustybuffer_xxh64_update");
+    xxh64_digest_fn = library_symbol(handle, "rustybuffer_xxh64_digest");
+    writev_checksum_fn = library_symbol(handle, "rustybuffer_writev_checksum");
+    transform_name_fn = library_symbol(handle, "rustybuffer_transform_name");
+    transform_fn = library_symbol(handle, "rustybuffer_transform");
+    lz4_bound_fn = library_symbol(handle, "rustybuffer_lz4_bound");
+    lz4_compress_fn = library_symbol(handle, "rustybuffer_lz4_compress");
+    lz4_content_size_fn = library_symbol(handle, "rustybuffer_lz4_content_size");
+    lz4_decompress_fn = library_symbol(handle, "rustybuffer_lz4_decompress");
+    last_error_fn = library_symbol(handle, "rustybuffer_last_error");
+
+    version_fn = version;
+    config_fn = config;
+    acquire_fn = acquire;
+    release_fn = release;
+
+    return 0;
+}
+
+uint32_t
+rustybuffer_version(void)
+{
+    return version_fn();
+}
+
+uint64_t
+rustybuffer_capabilities(void)
+{
+    // Libraries that predate capabilities still had stats.
+    if (capabilities_fn == NULL) {
+        return stats_fn != NULL ? 1 : 0;
+    }
+    return capabilities_fn();
+}
+
+uint8_t
+rustybuffer_config(uint64_t max_total_size, uint64_t max_buffer_size)
+{
+    return config_fn(max_total_size, max_buffer_size);
+}
+
+uint8_t
+rustybuffer_acquire(uint64_t size, void **data)
+{
+    return acquire_fn(size, data);
+}
+
+uint8_t
+rustybuffer_release(void *data)
+{
+    return release_fn(data);
+}
+
+uint8_t
+rustybuffer_stats(rustybuffer_stats_t *stats)
+{
+    if (stats_fn == NULL) {
+        return 255;
+    }
+    return stats_fn(stats);
+}
+
+uint64_t
+rustybuffer_live_handles(uint64_t *handles, uint64_t len)
+{
+    if (live_handles_fn == NULL) {
+        return 0;
+    }
+    return live_handles_fn(handles, len);
+}
+
+uint64_t
+rustybuffer_cached_sizes(uint64_t *sizes, uint64_t len)
+{
+    if (cached_sizes_fn == NULL) {
+        return 0;
+    }
+    return cached_sizes_fn(sizes, len);
+}
+
+uint64_t
+rustybuffer_trim(void)
+{
+    if (trim_fn == NULL) {
+        return 0;
+    }
+    return trim_fn();
+}
+
+void
+rustybuffer_trim_async(void)
+{
+    if (trim_async_fn == NULL) {
+        return;
+    }
+    trim_async_fn();
+}
+
+void
+rustybuffer_set_event_callback(rustybuffer_event_callback_t callback)
+{
+    if (set_event_callback_fn == NULL) {
+        return;
+    }
+    set_event_callback_fn(callback);
+}
+
+void
+rustybuffer_fill(void *data, uint64_t len, uint8_t value)
+{
+    if (fill_fn == NULL) {
+        memset(data, value, len);
+        return;
+    }
+    fill_fn(data, len, value);
+}
+
+// Only called when the library reports CapabilityFillRandom.
+
+uint8_t
+rustybuffer_fill_random(void *data, uint64_t len)
+{
+    return fill_random_fn(data, len);
+}
+
+void
+rustybuffer_copy(const rustybuffer_copy_segment_t *segments, uint64_t count)
+{
+    if (copy_fn == NULL) {
+        for (uint64_t idx = 0; idx < count; idx++) {
+            memmove((void *) (uintptr_t) segments[idx].dst,
+                (const void *) (uintptr_t) segments[idx].src, segments[idx].len);
+        }
+        return;
+    }
+    copy_fn(segments, count);
+}
+
+int32_t
+rustybuffer_compare(const void *a, uint64_t a_len, const void *b, uint64_t b_len)
+{
+    if (compare_fn == NULL) {
+        uint64_t len = a_len < b_len ? a_len : b_len;
+        int res = len > 0 ? memcmp(a, b, len) : 0;
+        if (res != 0) {
+            return res < 0 ? -1 : 1;
+        }
+        return a_len < b_len ? -1 : (a_len > b_len ? 1 : 0);
+    }
+    return compare_fn(a, a_len, b, b_len);
+}
+
+uint8_t
+rustybuffer_constant_time_equal(const void *a, const void *b, uint64_t len)
+{
+    // The bindings check the capability and compare in Go instead, a
+    // memcmp here wouldn't be constant time.
+    if (constant_time_equal_fn == NULL) {
+        return 0;
+    }
+    return constant_time_equal_fn(a, b, len);
+}
+
+// The checksums are only called when the library reports
+// CapabilityChecksum, so these are never missing when used.
+
+uint32_t
+rustybuffer_crc32c(uint32_t crc, const void *data, uint64_t len)
+{
+    return crc32c_fn(crc, data, len);
+}
+
+void
+rustybuffer_xxh64_reset(rustybuffer_xxh64_t *state, uint64_t seed)
+{
+    xxh64_reset_fn(state, seed);
+}
+
+void
+rustybuffer_xxh64_update(rustybuffer_xxh64_t *state, const void *data, uint64_t len)
+{
+    xxh64_update_fn(state, data, len);
+}
+
+uint64_t
+rustybuffer_xxh64_digest(const rustybuffer_xxh64_t *state)
+{
+    return xxh64_digest_fn(state);
+}
+
+// Likewise only called when the library reports CapabilityWritevChecksum.
+
+int32_t
+rustybuffer_writev_checksum(int32_t fd, const rustybuffer_span_t *spans, uint64_t span_count,
+    uint32_t algo, uint64_t *written, uint64_t *checksum)
+{
+    return writev_checksum_fn(fd, spans, span_count, algo, written, checksum);
+}
+
+// Likewise only called when the library reports CapabilityTransform.
+
+uint64_t
+rustybuffer_transform_name(uint64_t id, char *buf, uint64_t len)
+{
+    return transform_name_fn(id, buf, len);
+}
+
+uint8_t
+rustybuffer_transform(const rustybuffer_span_t *spans, uint64_t span_count,
+    rustybuffer_transform_step_t *steps, uint64_t step_count)
+{
+    return transform_fn(spans, span_count, steps, step_count);
+}
+
+// Likewise only called when the library reports CapabilityLZ4.
+
+uint64_t
+rustybuffer_lz4_bound(uint64_t len)
+{
+    return lz4_bound_fn(len);
+}
+
+uint8_t
+rustybuffer_lz4_compress(const void *src, uint64_t src_len, void *dst, uint64_t dst_len,
+    uint64_t *out_len)
+{
+    return lz4_compress_fn(src, src_len, dst, dst_len, out_len);
+}
+
+uint8_t
+rustybuffer_lz4_content_size(const void *src, uint64_t src_len, uint64_t *size)
+{
+    return lz4_content_size_fn(src, src_len, size);
+}
+
+uint8_t
+rustybuffer_lz4_decompress(const void *src, uint64_t src_len, void *dst, uint64_t dst_len,
+    uint64_t *out_len)
+{
+    return lz4_decompress_fn(src, src_len, dst, dst_len, out_len);
+}
+
+uint64_t
+rustybuffer_last_error(char *buf, uint64_t len)
+{
+    if (last_error_fn == NULL) {
+        if (buf != NULL && len > 0) {
+            buf[0] = '\0';
+        }
+        return 0;
+    }
+    return last_error_fn(buf, len);
+}
@@ -0,0 +1,103 @@
+//go:build cgo && rustybuffer_dynamic
+
+package rustybuffer
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+/*
+#cgo !windows LDFLAGS: -ldl
+#include <stdlib.h>
+
+int rustybuffer_dlopen(const char *path, char *err, size_t err_len);
+*/
+import "C"
+
+// The library loaded when the first call into it is made without an
+// explicit LoadLibrary, named the way cargo names a cdylib on this
+// platform. The dynamic loader's usual search rules apply to paths without
+// a slash. Overridden by $RUSTYBUFFER_LIBRARY.
+var DefaultLibraryPath = func() string {
+	switch runtime.GOOS {
+	case "windows":
+		return "rustybuffer.dll"
+	case "darwin":
+		return "librustybuffer.dylib"
+	default:
+		return "librustybuffer.so"
+	}
+}()
+
+var library struct {
+	mu     sync.Mutex
+	loaded bool
+	err    error
+}
+
+// LoadLibrary loads the Rust library from path and checks that its version
+// is compatible with these bindings. It must be called before anything else
+// in the package touches the Rust allocator, otherwise the library is
+// loaded from $RUSTYBUFFER_LIBRARY or DefaultLibraryPath on first use.
+// Loading a second library is an error.
+func LoadLibrary(path string) error {
+	// This stands in for ensureLibrary's check, taking the locks in the
+	// same order, so that it never loads the default library afterwards.
+	checked := false
+	libraryCheck.once.Do(func() {
+		checked = true
+
+		library.mu.Lock()
+		defer library.mu.Unlock()
+
+		if library.err = loadLibraryLocked(path); library.err != nil {
+			libraryCheck.err = library.err
+			return
+		}
+		libraryCheck.info = queryLibrary()
+		libraryCheck.err = checkLibraryVersion()
+	})
+
+	if checked {
+		return libraryCheck.err
+	}
+	if libraryCheck.err != nil {
+		return libraryCheck.err
+	}
+	return fmt.Errorf("rustybuffer: library already loaded")
+}
+
+func loadLibrary() error {
+	library.mu.Lock()
+	defer library.mu.Unlock()
+
+	if library.loaded || library.err != nil {
+		return library.err
+	}
+
+	path := os.Getenv("RUSTYBUFFER_LIBRARY")
+	if path == "" {
+		path = DefaultLibraryPath
+	}
+
+	library.err = loadLibraryLocked(path)
+	return library.err
+}
+
+func loadLibraryLocked(path string) error {
+	c_path := C.CString(path)
+	defer C.free(unsafe.Pointer(c_path))
+
+	var c_err [256]C.char
+	if C.rustybuffer_dlopen(c_path, &c_err[0], C.size_t(len(c_err))) != 0 {
+		return fmt.Errorf("rustybuffer: loading %s: %s", path, C.GoString(&c_err[0]))
+	}
+
+	library.loaded = true
+	library.err = nil
+	return nil
+}
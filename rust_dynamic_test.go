@@ -0,0 +1,58 @@
+//go:build cgo && rustybuffer_dynamic
+
+package rustybuffer
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestLoadLibraryMissing(t *testing.T) {
+	library.mu.Lock()
+	err := loadLibraryLocked("/nonexistent/librustybuffer.so")
+	library.mu.Unlock()
+
+	if err == nil || !strings.Contains(err.Error(), "/nonexistent") {
+		t.Fatalf("expected a load error naming the path, got %v", err)
+	}
+}
+
+func TestLoadLibraryTwice(t *testing.T) {
+	if err := ensureLibrary(); err != nil {
+		t.Skipf("library not available: %v", err)
+	}
+
+	if err := LoadLibrary(DefaultLibraryPath); err == nil {
+		t.Fatal("expected loading a second library to fail")
+	}
+}
+
+func TestLoadLibraryFirst(t *testing.T) {
+	if path := os.Getenv("RUSTYBUFFER_LOAD_FIRST"); path != "" {
+		if err := LoadLibrary(path); err != nil {
+			os.Exit(3)
+		}
+		if _, err := LibraryInfo(); err != nil {
+			os.Exit(4)
+		}
+		os.Exit(0)
+	}
+
+	if err := ensureLibrary(); err != nil {
+		t.Skipf("library not available: %v", err)
+	}
+	path := os.Getenv("RUSTYBUFFER_LIBRARY")
+	if path == "" {
+		path = DefaultLibraryPath
+	}
+
+	// This process has loaded the library already, so the load has to be
+	// the first thing a fresh one does.
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLoadLibraryFirst$", "-test.timeout=30s")
+	cmd.Env = append(os.Environ(), "RUSTYBUFFER_LOAD_FIRST="+path)
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("expected loading the library first to succeed, got %v", err)
+	}
+}
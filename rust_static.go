@@ -0,0 +1,17 @@
+//go:build cgo && !rustybuffer_dynamic && !rustybuffer_system
+
+package rustybuffer
+
+// Rust's std needs a few system libraries on Windows (x86_64-pc-windows-gnu,
+// which is what cgo's mingw toolchain can link against).
+
+/*
+#cgo LDFLAGS: ./lib/librustybuffer.a
+#cgo windows LDFLAGS: -lws2_32 -luserenv -lbcrypt -lntdll
+*/
+import "C"
+
+// loadLibrary is a no-op, the library is linked into the binary.
+func loadLibrary() error {
+	return nil
+}
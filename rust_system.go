@@ -0,0 +1,18 @@
+//go:build cgo && rustybuffer_system
+
+package rustybuffer
+
+// Links a system-installed librustybuffer found with pkg-config (see the
+// Makefile's install target). To use a library at an explicit path without
+// a .pc file, point PKG_CONFIG_PATH at a directory holding one, or skip
+// pkg-config altogether with the rustybuffer_dynamic tag and LoadLibrary.
+
+/*
+#cgo pkg-config: rustybuffer
+*/
+import "C"
+
+// loadLibrary is a no-op, the library is linked into the binary.
+func loadLibrary() error {
+	return nil
+}
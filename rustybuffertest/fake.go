@@ -0,0 +1,199 @@
+package rustybuffertest
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/davisp/rustybuffer"
+)
+
+// Call records one call made to a FakeAllocator.
+type Call struct {
+	// PointAcquire or PointRelease.
+	Point string
+
+	// The size acquired or released.
+	Size uint64
+
+	// The memory acquired or released, nil for failed acquires.
+	Data unsafe.Pointer
+
+	Err error
+}
+
+// FakeAllocator is a pure Go Allocator with fixed limits that records
+// every call made to it. Its memory comes from the Go heap, so tests using
+// it never touch the Rust library: build them with CGO_ENABLED=0 or the
+// rustybuffer_dynamic tag and the library doesn't even have to exist.
+type FakeAllocator struct {
+	mu              sync.Mutex
+	max_total_size  uint64
+	max_buffer_size uint64
+	bytes_in_use    uint64
+	buffers         map[unsafe.Pointer][]byte
+	stacks          map[unsafe.Pointer][]uintptr
+	calls           []Call
+}
+
+func NewFakeAllocator(max_total_size uint64, max_buffer_size uint64) *FakeAllocator {
+	return &FakeAllocator{
+		max_total_size:  max_total_size,
+		max_buffer_size: max_buffer_size,
+		buffers:         make(map[unsafe.Pointer][]byte),
+		stacks:          make(map[unsafe.Pointer][]uintptr),
+	}
+}
+
+func (fake *FakeAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	call := Call{Point: PointAcquire, Size: size}
+	switch {
+	case size > fake.max_buffer_size:
+		call.Err = rustybuffer.ErrBufferTooLarge
+	case fake.bytes_in_use+size > fake.max_total_size:
+		call.Err = rustybuffer.ErrNoBufferAvailable
+	default:
+		buf := make([]byte, size)
+		call.Data = unsafe.Pointer(unsafe.SliceData(buf))
+		fake.buffers[call.Data] = buf
+		fake.bytes_in_use += size
+
+		stack := make([]uintptr, 64)
+		fake.stacks[call.Data] = stack[:runtime.Callers(2, stack)]
+	}
+
+	fake.calls = append(fake.calls, call)
+	return call.Data, call.Err
+}
+
+func (fake *FakeAllocator) Release(data unsafe.Pointer) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	call := Call{Point: PointRelease, Data: data}
+	if buf, ok := fake.buffers[data]; ok {
+		call.Size = uint64(len(buf))
+		delete(fake.buffers, data)
+		delete(fake.stacks, data)
+		fake.bytes_in_use -= call.Size
+	} else {
+		call.Err = rustybuffer.ErrInvalidPointer
+	}
+
+	fake.calls = append(fake.calls, call)
+	return call.Err
+}
+
+func (fake *FakeAllocator) Stats() rustybuffer.Stats {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return rustybuffer.Stats{
+		MaxTotalSize:   fake.max_total_size,
+		MaxBufferSize:  fake.max_buffer_size,
+		BytesAllocated: fake.bytes_in_use,
+		BytesInUse:     fake.bytes_in_use,
+		NumBuffers:     uint64(len(fake.buffers)),
+	}
+}
+
+// Calls returns every call made so far, oldest first.
+func (fake *FakeAllocator) Calls() []Call {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return append([]Call(nil), fake.calls...)
+}
+
+// Count returns how many calls were made at point, and how many of those
+// failed.
+func (fake *FakeAllocator) Count(point string) (calls int, failures int) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	for _, call := range fake.calls {
+		if call.Point == point {
+			calls++
+			if call.Err != nil {
+				failures++
+			}
+		}
+	}
+	return calls, failures
+}
+
+// LiveBuffer is memory acquired from a FakeAllocator and not released.
+type LiveBuffer struct {
+	Data unsafe.Pointer
+	Size uint64
+
+	// The goroutine's stack when it was acquired.
+	Stack string
+}
+
+// Live returns every buffer that's been acquired but not released.
+func (fake *FakeAllocator) Live() []LiveBuffer {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	live := make([]LiveBuffer, 0, len(fake.buffers))
+	for data, buf := range fake.buffers {
+		live = append(live, LiveBuffer{data, uint64(len(buf)), formatStack(fake.stacks[data])})
+	}
+	return live
+}
+
+func formatStack(stack []uintptr) string {
+	var builder strings.Builder
+	frames := runtime.CallersFrames(stack)
+	for {
+		frame, more := frames.Next()
+		builder.WriteString(frame.Function)
+		builder.WriteString("\n\t")
+		builder.WriteString(frame.File)
+		builder.WriteString(":")
+		builder.WriteString(strconv.Itoa(frame.Line))
+		builder.WriteString("\n")
+		if !more {
+			return builder.String()
+		}
+	}
+}
+
+// Reset forgets the calls made so far. Memory still acquired stays that
+// way.
+func (fake *FakeAllocator) Reset() {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.calls = nil
+}
+
+// FakePool is a rustybuffer.Pool backed by a FakeAllocator. It embeds the
+// *rustybuffer.Pool, so pass fake.Pool to code under test wherever it
+// expects a real one, and check fake.Allocator's calls afterwards.
+type FakePool struct {
+	*rustybuffer.Pool
+	Allocator *FakeAllocator
+}
+
+// NewFakePool creates a pool with the given capacity, applying opts on top
+// (e.g., an exhaustion policy).
+func NewFakePool(
+	max_total_size uint64,
+	max_buffer_size uint64,
+	opts ...rustybuffer.PoolOption,
+) *FakePool {
+	fake := NewFakeAllocator(max_total_size, max_buffer_size)
+	opts = append([]rustybuffer.PoolOption{rustybuffer.WithAllocator(fake)}, opts...)
+
+	return &FakePool{
+		Pool:      rustybuffer.NewPool(opts...),
+		Allocator: fake,
+	}
+}
@@ -0,0 +1,74 @@
+package rustybuffertest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/davisp/rustybuffer"
+)
+
+func TestFakePool(t *testing.T) {
+	fake := NewFakePool(100, 60)
+
+	first, err := fake.AllocBuffers([]uint64{10, 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Buffers) != 2 || len(first.Buffers[1]) != 20 {
+		t.Fatalf("unexpected buffers: %v", first.Buffers)
+	}
+
+	second, err := fake.AllocBuffers([]uint64{60})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fake.AllocBuffers([]uint64{20})
+	if !errors.Is(err, rustybuffer.ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+	_, err = fake.AllocBuffers([]uint64{70})
+	if !errors.Is(err, rustybuffer.ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+
+	if stats := fake.Stats(); stats.BytesInUse != 90 || stats.NumBuffers != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	first.Release()
+	second.Release()
+
+	calls, failures := fake.Allocator.Count(PointAcquire)
+	if calls != 4 || failures != 2 {
+		t.Fatalf("unexpected acquires: %d calls, %d failures", calls, failures)
+	}
+	calls, failures = fake.Allocator.Count(PointRelease)
+	if calls != 2 || failures != 0 {
+		t.Fatalf("unexpected releases: %d calls, %d failures", calls, failures)
+	}
+
+	history := fake.Allocator.Calls()
+	if len(history) != 6 || history[0].Size != 30 || history[4].Data != history[0].Data {
+		t.Fatalf("unexpected calls: %+v", history)
+	}
+
+	fake.Allocator.Reset()
+	if len(fake.Allocator.Calls()) != 0 {
+		t.Fatal("calls weren't reset")
+	}
+}
+
+func TestFakePoolOptions(t *testing.T) {
+	fake := NewFakePool(10, 10, rustybuffer.WithExhaustionPolicy(rustybuffer.ExhaustionHeap))
+
+	entry, err := fake.AllocBuffers([]uint64{20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	if fake.Stats().FallbackBytes != 20 {
+		t.Fatalf("unexpected stats: %+v", fake.Stats())
+	}
+}
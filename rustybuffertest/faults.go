@@ -0,0 +1,127 @@
+// Package rustybuffertest has helpers for testing code that uses
+// rustybuffer, in particular its handling of exhausted pools and other
+// failures that are hard to provoke for real.
+package rustybuffertest
+
+import (
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/davisp/rustybuffer"
+)
+
+// The points faults can be injected at.
+const (
+	PointAcquire = "acquire"
+	PointRelease = "release"
+)
+
+type failPoint struct {
+	every_n int
+	err     error
+	delay   time.Duration
+	calls   int
+}
+
+// FaultyAllocator wraps an Allocator, failing or delaying calls to it on
+// demand. Hand it to rustybuffer.WithAllocator to test how code copes with
+// a pool that misbehaves.
+type FaultyAllocator struct {
+	alloc rustybuffer.Allocator
+
+	mu     sync.Mutex
+	points map[string]*failPoint
+}
+
+func NewFaultyAllocator(alloc rustybuffer.Allocator) *FaultyAllocator {
+	return &FaultyAllocator{
+		alloc:  alloc,
+		points: make(map[string]*failPoint),
+	}
+}
+
+// SetFailPoint makes every every_n'th call at point (PointAcquire or
+// PointRelease) fail with err, without calling the wrapped allocator. An
+// every_n of 1 fails every call and 0 turns the failures off again. A nil
+// err fails acquires with ErrNoBufferAvailable and releases with
+// ErrInvalidPointer.
+func (faulty *FaultyAllocator) SetFailPoint(point string, every_n int, err error) {
+	faulty.mu.Lock()
+	defer faulty.mu.Unlock()
+
+	fp := faulty.point(point)
+	fp.every_n = every_n
+	fp.err = err
+	fp.calls = 0
+}
+
+// SetDelay makes every call at point sleep for delay first.
+func (faulty *FaultyAllocator) SetDelay(point string, delay time.Duration) {
+	faulty.mu.Lock()
+	defer faulty.mu.Unlock()
+
+	faulty.point(point).delay = delay
+}
+
+// Clear removes every fail point and delay.
+func (faulty *FaultyAllocator) Clear() {
+	faulty.mu.Lock()
+	defer faulty.mu.Unlock()
+
+	faulty.points = make(map[string]*failPoint)
+}
+
+func (faulty *FaultyAllocator) point(point string) *failPoint {
+	fp, ok := faulty.points[point]
+	if !ok {
+		fp = &failPoint{}
+		faulty.points[point] = fp
+	}
+	return fp
+}
+
+// inject applies point's delay and returns an error if this call should
+// fail.
+func (faulty *FaultyAllocator) inject(point string, default_err error) error {
+	faulty.mu.Lock()
+	fp, ok := faulty.points[point]
+	if !ok {
+		faulty.mu.Unlock()
+		return nil
+	}
+
+	fp.calls++
+	delay := fp.delay
+	var err error
+	if fp.every_n > 0 && fp.calls%fp.every_n == 0 {
+		err = fp.err
+		if err == nil {
+			err = default_err
+		}
+	}
+	faulty.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return err
+}
+
+func (faulty *FaultyAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	if err := faulty.inject(PointAcquire, rustybuffer.ErrNoBufferAvailable); err != nil {
+		return nil, err
+	}
+	return faulty.alloc.Acquire(size)
+}
+
+func (faulty *FaultyAllocator) Release(data unsafe.Pointer) error {
+	if err := faulty.inject(PointRelease, rustybuffer.ErrInvalidPointer); err != nil {
+		return err
+	}
+	return faulty.alloc.Release(data)
+}
+
+func (faulty *FaultyAllocator) Stats() rustybuffer.Stats {
+	return faulty.alloc.Stats()
+}
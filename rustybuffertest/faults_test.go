@@ -0,0 +1,71 @@
+package rustybuffertest
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/davisp/rustybuffer"
+)
+
+func TestFailPoints(t *testing.T) {
+	faulty := NewFaultyAllocator(rustybuffer.NewHeapAllocator(1024, 1024))
+	pool := rustybuffer.NewPool(rustybuffer.WithAllocator(faulty))
+
+	faulty.SetFailPoint(PointAcquire, 2, nil)
+	for idx := 1; idx <= 4; idx++ {
+		entry, err := pool.AllocBuffers([]uint64{10})
+		if idx%2 == 0 {
+			if !errors.Is(err, rustybuffer.ErrNoBufferAvailable) {
+				t.Fatalf("acquire %d: expected ErrNoBufferAvailable, got %v", idx, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("acquire %d: %v", idx, err)
+		}
+		entry.Release()
+	}
+
+	injected := errors.New("injected")
+	faulty.SetFailPoint(PointAcquire, 1, injected)
+	if _, err := pool.AllocBuffers([]uint64{10}); err != injected {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+
+	faulty.Clear()
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	faulty.SetFailPoint(PointRelease, 1, nil)
+	data := unsafe.Pointer(unsafe.SliceData(entry.Buffers[0]))
+	if err := faulty.Release(data); !errors.Is(err, rustybuffer.ErrInvalidPointer) {
+		t.Fatalf("expected ErrInvalidPointer, got %v", err)
+	}
+	faulty.Clear()
+	entry.Release()
+
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+}
+
+func TestDelay(t *testing.T) {
+	faulty := NewFaultyAllocator(rustybuffer.NewHeapAllocator(1024, 1024))
+	faulty.SetDelay(PointAcquire, 10*time.Millisecond)
+
+	start := time.Now()
+	data, err := faulty.Acquire(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("acquire wasn't delayed")
+	}
+	if err := faulty.Release(data); err != nil {
+		t.Fatal(err)
+	}
+}
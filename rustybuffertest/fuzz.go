@@ -0,0 +1,139 @@
+package rustybuffertest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/davisp/rustybuffer"
+)
+
+type fuzzEntry struct {
+	entry   rustybuffer.RBEntry
+	pattern byte
+}
+
+// fuzzInput hands out the fuzzer's bytes, zeros once they run out.
+type fuzzInput []byte
+
+func (input *fuzzInput) next() byte {
+	if len(*input) == 0 {
+		return 0
+	}
+	b := (*input)[0]
+	*input = (*input)[1:]
+	return b
+}
+
+// Fuzz interprets data as a sequence of pool operations against alloc:
+// acquiring multi-buffer entries, releasing them in any order, releasing
+// copies of them and checking their contents. After every step it checks
+// that fresh memory is zeroed, that no entry's memory was overwritten by
+// another's and that the allocator's accounting covers what's live. At the
+// end everything is released and the allocator must be back where it
+// started. The first violation is returned.
+//
+// It's meant to be driven by go test -fuzz:
+//
+//	func FuzzPool(f *testing.F) {
+//		f.Fuzz(func(t *testing.T, data []byte) {
+//			alloc := rustybuffer.NewHeapAllocator(1<<20, 1<<16)
+//			if err := rustybuffertest.Fuzz(alloc, data); err != nil {
+//				t.Fatal(err)
+//			}
+//		})
+//	}
+func Fuzz(alloc rustybuffer.Allocator, data []byte) error {
+	input := fuzzInput(data)
+	pool := rustybuffer.NewPool(rustybuffer.WithAllocator(alloc))
+	start := alloc.Stats().BytesInUse
+
+	var live []fuzzEntry
+	for step := 0; len(input) > 0; step++ {
+		op := input.next()
+
+		switch op % 4 {
+		case 0:
+			sizes := make([]uint64, input.next()%4+1)
+			for idx := range sizes {
+				sizes[idx] = uint64(input.next())<<8 | uint64(input.next())
+			}
+
+			entry, err := pool.AllocBuffers(sizes)
+			if err != nil {
+				if !errors.Is(err, rustybuffer.ErrNoBufferAvailable) &&
+					!errors.Is(err, rustybuffer.ErrBufferTooLarge) {
+					return fmt.Errorf("step %d: acquiring %v: unexpected error %w", step, sizes, err)
+				}
+				continue
+			}
+
+			for idx, buf := range entry.Buffers {
+				if uint64(len(buf)) != sizes[idx] {
+					return fmt.Errorf("step %d: buffer %d has %d bytes, wanted %d",
+						step, idx, len(buf), sizes[idx])
+				}
+				for _, b := range buf {
+					if b != 0 {
+						return fmt.Errorf("step %d: acquired memory wasn't zeroed", step)
+					}
+				}
+				fill(buf, op)
+			}
+			live = append(live, fuzzEntry{entry, op})
+		case 1, 2:
+			if len(live) == 0 {
+				continue
+			}
+			idx := int(input.next()) % len(live)
+
+			// Releasing a copy first must make the real release a no-op.
+			if op%4 == 2 {
+				copied := live[idx].entry
+				copied.Release()
+			}
+			live[idx].entry.Release()
+			live = append(live[:idx], live[idx+1:]...)
+		case 3:
+		}
+
+		if err := checkLive(alloc, start, live); err != nil {
+			return fmt.Errorf("step %d: %w", step, err)
+		}
+	}
+
+	for idx := range live {
+		live[idx].entry.Release()
+	}
+	if in_use := alloc.Stats().BytesInUse; in_use != start {
+		return fmt.Errorf("%d bytes in use after releasing everything, started with %d",
+			in_use, start)
+	}
+
+	return nil
+}
+
+func fill(buf []byte, pattern byte) {
+	for idx := range buf {
+		buf[idx] = pattern
+	}
+}
+
+func checkLive(alloc rustybuffer.Allocator, start uint64, live []fuzzEntry) error {
+	var total uint64 = 0
+	for _, entry := range live {
+		for _, buf := range entry.entry.Buffers {
+			total += uint64(len(buf))
+			for _, b := range buf {
+				if b != entry.pattern {
+					return fmt.Errorf("entry %s was overwritten", entry.entry.Handle())
+				}
+			}
+		}
+	}
+
+	// Allocators round up, cache and share, so only a lower bound holds.
+	if in_use := alloc.Stats().BytesInUse; in_use < start+total {
+		return fmt.Errorf("%d bytes in use but %d are live", in_use, start+total)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package rustybuffertest
+
+import (
+	"testing"
+
+	"github.com/davisp/rustybuffer"
+)
+
+func FuzzPool(f *testing.F) {
+	f.Add([]byte{0, 1, 0, 16, 0, 0, 0, 1, 0, 1, 0})
+	f.Add([]byte{4, 3, 1, 0, 2, 0, 3, 0, 8, 2, 0, 3})
+	f.Add([]byte{0, 0, 255, 255, 0, 0, 0, 0, 1, 1, 0, 6})
+	f.Add([]byte{0, 1, 0, 10, 0, 0, 3})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		allocs := map[string]rustybuffer.Allocator{
+			"heap":          rustybuffer.NewHeapAllocator(1<<20, 1<<16),
+			"deterministic": rustybuffer.NewDeterministicAllocator(1<<20, 1<<16, 7),
+			"randomized":    rustybuffer.NewRandomizedAllocator(1<<20, 1<<16),
+			"fake":          NewFakeAllocator(1<<20, 1<<16),
+			"rust":          rustybuffer.NewRustAllocator(),
+		}
+		for name, alloc := range allocs {
+			if err := Fuzz(alloc, data); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+	})
+}
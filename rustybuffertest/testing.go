@@ -0,0 +1,30 @@
+package rustybuffertest
+
+import (
+	"testing"
+
+	"github.com/davisp/rustybuffer"
+)
+
+// The capacity of pools from NewPool, big enough not to get in the way.
+const (
+	TestMaxTotalSize  = 1 << 30
+	TestMaxBufferSize = 1 << 30
+)
+
+// NewPool returns a FakePool for the duration of one test, isolated from
+// every other pool. When the test finishes it fails if any entry acquired
+// from the pool hasn't been released, listing where each was acquired.
+func NewPool(t testing.TB, opts ...rustybuffer.PoolOption) *FakePool {
+	t.Helper()
+
+	fake := NewFakePool(TestMaxTotalSize, TestMaxBufferSize, opts...)
+	t.Cleanup(func() {
+		for _, buf := range fake.Allocator.Live() {
+			t.Errorf("rustybuffertest: %d byte entry was never released, acquired at:\n%s",
+				buf.Size, buf.Stack)
+		}
+	})
+
+	return fake
+}
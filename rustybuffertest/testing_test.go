@@ -0,0 +1,57 @@
+package rustybuffertest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// recordingTB captures the failures NewPool reports.
+type recordingTB struct {
+	testing.TB
+	cleanups []func()
+	errors   []string
+}
+
+func (tb *recordingTB) Helper() {}
+
+func (tb *recordingTB) Cleanup(fn func()) {
+	tb.cleanups = append(tb.cleanups, fn)
+}
+
+func (tb *recordingTB) Errorf(format string, args ...any) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func (tb *recordingTB) finish() {
+	for idx := len(tb.cleanups) - 1; idx >= 0; idx-- {
+		tb.cleanups[idx]()
+	}
+}
+
+func TestNewPool(t *testing.T) {
+	pool := NewPool(t)
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+}
+
+func leak(pool *FakePool) {
+	pool.AllocBuffers([]uint64{10})
+}
+
+func TestNewPoolLeak(t *testing.T) {
+	tb := &recordingTB{TB: t}
+	leak(NewPool(tb))
+	tb.finish()
+
+	if len(tb.errors) != 1 {
+		t.Fatalf("expected one leak, got %v", tb.errors)
+	}
+	if !strings.Contains(tb.errors[0], "10 byte entry") ||
+		!strings.Contains(tb.errors[0], "rustybuffertest.leak") {
+		t.Fatalf("unexpected report: %s", tb.errors[0])
+	}
+}
@@ -0,0 +1,224 @@
+package rustybuffer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// WithScrubbing has the pool check its memory for corruption in the
+// background, with Scrub or ScrubEvery, rather than only when it's reused:
+// the poison of released memory held in quarantine (see WithQuarantine)
+// and the checksums of frozen entries (see RBEntry.Freeze). Every
+// corruption found is passed to report, or logged if it's nil.
+//
+// Scrubbing checks one piece of memory at a time, yielding to other
+// goroutines in between, so it gets the CPU when the process has nothing
+// better to do with it. It only reads memory nothing's meant to be
+// writing, so it needs no cooperation from the pool's users.
+func WithScrubbing(report func(Corruption)) PoolOption {
+	return func(pool *Pool) {
+		pool.scrubber = &scrubber{report: report, frozen: make(map[unsafe.Pointer]*frozenEntry)}
+	}
+}
+
+// Corruption is memory found written to when nothing should have, see
+// WithScrubbing.
+type Corruption struct {
+	// "quarantine" for released memory whose poison was written over,
+	// which was then restored, or "frozen" for a frozen entry whose
+	// checksum changed, which it's then frozen with.
+	Kind string
+
+	// The frozen entry, which memory in quarantine no longer has.
+	Handle Handle
+
+	Addr uintptr
+	Size uint64
+
+	// A frozen entry's CRC32C when it was frozen and when it was checked.
+	Expected uint64
+	Actual   uint64
+}
+
+// ScrubResult is what one Scrub checked and found.
+type ScrubResult struct {
+	Start time.Time
+	End   time.Time
+
+	// Pieces of released memory in quarantine checked, and their bytes.
+	Quarantined      uint64
+	QuarantinedBytes uint64
+
+	// Frozen entries checked, and their bytes.
+	Frozen      uint64
+	FrozenBytes uint64
+
+	Corruptions []Corruption
+}
+
+// frozenEntry is an entry's checksum when it was frozen, and what a scrub
+// needs of the entry to check it. It pins the entry through its pins
+// rather than holding on to its state, so that a frozen entry that's
+// leaked is still found.
+type frozenEntry struct {
+	handle   Handle
+	data     unsafe.Pointer
+	size     uint64
+	buffers  [][]byte
+	pins     *entryPins
+	checksum uint64
+}
+
+type scrubber struct {
+	report func(Corruption)
+
+	// Held while scrubbing, so two scrubs at once don't both report what
+	// they find.
+	scrubbing sync.Mutex
+
+	mu     sync.Mutex
+	frozen map[unsafe.Pointer]*frozenEntry
+}
+
+func (scrubber *scrubber) forget(data unsafe.Pointer) {
+	scrubber.mu.Lock()
+	defer scrubber.mu.Unlock()
+
+	delete(scrubber.frozen, data)
+}
+
+// Freeze declares that the entry won't be written to again, for its pool's
+// scrubbing to check it isn't (see WithScrubbing): its CRC32C is taken now
+// and compared by every Scrub until it's released. Freezing it again
+// takes the checksum again. Entries of pools created without
+// WithScrubbing, or released, can't be frozen.
+func (entry *RBEntry) Freeze() error {
+	state := entry.state
+	if state == nil || entry.data == nil || state.released.Load() {
+		return errors.New("rustybuffer: freeze of a released entry")
+	}
+	if state.pool.scrubber == nil {
+		return errors.New("rustybuffer: freeze of an entry of a pool created without WithScrubbing")
+	}
+
+	checksum, err := entry.Checksum(ChecksumCRC32C)
+	if err != nil {
+		return err
+	}
+
+	scrubber := state.pool.scrubber
+	scrubber.mu.Lock()
+	defer scrubber.mu.Unlock()
+
+	scrubber.frozen[entry.data] = &frozenEntry{
+		handle:   state.handle,
+		data:     entry.data,
+		size:     state.size,
+		buffers:  append([][]byte(nil), entry.Buffers...),
+		pins:     state.entryPins,
+		checksum: checksum,
+	}
+	return nil
+}
+
+// Scrub checks the pool's released memory in quarantine and its frozen
+// entries once each, reporting and returning whatever corruption it finds.
+// Pools created without WithScrubbing have nothing to scrub.
+func (pool *Pool) Scrub() (ScrubResult, error) {
+	scrubber := pool.scrubber
+	if scrubber == nil {
+		return ScrubResult{}, errors.New("rustybuffer: scrub of a pool created without WithScrubbing")
+	}
+
+	scrubber.scrubbing.Lock()
+	defer scrubber.scrubbing.Unlock()
+
+	result := ScrubResult{Start: time.Now()}
+	if pool.quarantine != nil {
+		result.Quarantined, result.QuarantinedBytes = pool.quarantine.scrub(func(held quarantined) {
+			result.Corruptions = append(result.Corruptions, Corruption{
+				Kind: "quarantine",
+				Addr: uintptr(held.data),
+				Size: held.size,
+			})
+		})
+	}
+
+	scrubber.mu.Lock()
+	frozen := make([]*frozenEntry, 0, len(scrubber.frozen))
+	for _, entry := range scrubber.frozen {
+		frozen = append(frozen, entry)
+	}
+	scrubber.mu.Unlock()
+
+	for _, frozen := range frozen {
+		// Pinned, the entry can be released meanwhile but its memory
+		// can't be reused.
+		if !frozen.pins.tryPin() {
+			continue
+		}
+		var size uint64
+		for _, buffer := range frozen.buffers {
+			size += uint64(len(buffer))
+		}
+		checksum := checksumBuffers(ChecksumCRC32C, frozen.buffers, size)
+		frozen.pins.unpin()
+
+		result.Frozen++
+		result.FrozenBytes += frozen.size
+
+		// An entry frozen again meanwhile was written to legitimately.
+		scrubber.mu.Lock()
+		expected := frozen.checksum
+		corrupted := checksum != expected && scrubber.frozen[frozen.data] == frozen
+		if corrupted {
+			frozen.checksum = checksum
+		}
+		scrubber.mu.Unlock()
+		if corrupted {
+			result.Corruptions = append(result.Corruptions, Corruption{
+				Kind:     "frozen",
+				Handle:   frozen.handle,
+				Addr:     uintptr(frozen.data),
+				Size:     frozen.size,
+				Expected: expected,
+				Actual:   checksum,
+			})
+		}
+		runtime.Gosched()
+	}
+	result.End = time.Now()
+
+	for _, corruption := range result.Corruptions {
+		if scrubber.report != nil {
+			scrubber.report(corruption)
+		} else if corruption.Kind == "frozen" {
+			log.Printf("rustybuffer: frozen entry %v (%d bytes at %#x) was written to, its CRC32C went from %#x to %#x",
+				corruption.Handle, corruption.Size, corruption.Addr, corruption.Expected, corruption.Actual)
+		} else {
+			log.Printf("rustybuffer: %d byte entry at %#x was written to in quarantine, after being released",
+				corruption.Size, corruption.Addr)
+		}
+	}
+	return result, nil
+}
+
+// ScrubEvery calls Scrub every interval, until ctx is done.
+func (pool *Pool) ScrubEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pool.Scrub()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
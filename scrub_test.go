@@ -0,0 +1,166 @@
+package rustybuffer
+
+import (
+	"context"
+	"io"
+	"log"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestScrubQuarantine(t *testing.T) {
+	var found []Corruption
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)),
+		WithQuarantine(QuarantineOptions{Hold: time.Hour, Poison: []byte{0xde, 0xad}}),
+		WithScrubbing(func(corruption Corruption) { found = append(found, corruption) }))
+
+	var stale [][]byte
+	for idx := 0; idx < 3; idx++ {
+		entry, err := pool.AllocBuffers([]uint64{100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stale = append(stale, entry.Buffers[0])
+		entry.Release()
+	}
+
+	result, err := pool.Scrub()
+	if err != nil || result.Quarantined != 3 || result.QuarantinedBytes != 300 || len(result.Corruptions) != 0 {
+		t.Fatalf("unexpected result %+v (%v)", result, err)
+	}
+
+	// A write after free is found before the memory's reused.
+	stale[1][50] = 1
+	result, err = pool.Scrub()
+	if err != nil || len(result.Corruptions) != 1 || len(found) != 1 || found[0].Kind != "quarantine" ||
+		found[0].Size != 100 || pool.Stats().QuarantineViolations != 1 {
+		t.Fatalf("unexpected result %+v (%v)", result, err)
+	}
+
+	// The poison's restored, so it's counted once.
+	pool.FlushQuarantine()
+	if result, err := pool.Scrub(); err != nil || result.Quarantined != 0 || pool.Stats().QuarantineViolations != 1 {
+		t.Fatalf("unexpected result %+v (%v)", result, err)
+	}
+}
+
+func TestScrubFrozen(t *testing.T) {
+	var found []Corruption
+	alloc := NewHeapAllocator(1<<20, 1<<20)
+	pool := NewPool(WithAllocator(alloc),
+		WithScrubbing(func(corruption Corruption) { found = append(found, corruption) }))
+
+	entry, err := pool.AllocBuffers([]uint64{10, 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillPattern(entry, 1)
+	if err := entry.Freeze(); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err := pool.Scrub(); err != nil || result.Frozen != 1 || result.FrozenBytes != 30 || len(result.Corruptions) != 0 {
+		t.Fatalf("unexpected result %+v (%v)", result, err)
+	}
+
+	entry.Buffers[1][5] ^= 0xff
+	if result, err := pool.Scrub(); err != nil || len(result.Corruptions) != 1 {
+		t.Fatalf("unexpected result %+v (%v)", result, err)
+	}
+	if len(found) != 1 || found[0].Kind != "frozen" || found[0].Handle != entry.Handle() || found[0].Expected == found[0].Actual {
+		t.Fatalf("unexpected corruption %+v", found)
+	}
+
+	// Once reported, the entry's checked against what it holds now, and
+	// freezing it again after a legitimate write isn't corruption.
+	entry.Buffers[0][0] ^= 0xff
+	if err := entry.Freeze(); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := pool.Scrub(); err != nil || len(result.Corruptions) != 0 {
+		t.Fatalf("unexpected result %+v (%v)", result, err)
+	}
+
+	entry.Release()
+	if result, err := pool.Scrub(); err != nil || result.Frozen != 0 || alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected result %+v (%v)", result, err)
+	}
+	if err := entry.Freeze(); err == nil {
+		t.Fatal("expected freezing a released entry to fail")
+	}
+}
+
+func freezeLeakedEntry(t *testing.T, pool *Pool) {
+	entry, err := pool.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Freeze(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Scrubbing doesn't keep a frozen entry alive, so one that's leaked is
+// still finalized.
+func TestScrubFrozenLeak(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithLeakFinalizer(), WithScrubbing(nil))
+
+	freezeLeakedEntry(t, pool)
+	for idx := 0; idx < 100 && pool.Stats().BytesInUse != 0; idx++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	if pool.Stats().BytesInUse != 0 {
+		t.Fatal("the frozen entry was never finalized")
+	}
+	if result, err := pool.Scrub(); err != nil || result.Frozen != 0 {
+		t.Fatalf("unexpected result %+v (%v)", result, err)
+	}
+}
+
+func TestScrubErrors(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	if _, err := pool.Scrub(); err == nil {
+		t.Fatal("expected a scrub without WithScrubbing to fail")
+	}
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	if err := entry.Freeze(); err == nil {
+		t.Fatal("expected a freeze without WithScrubbing to fail")
+	}
+}
+
+func TestScrubEvery(t *testing.T) {
+	found := make(chan Corruption, 1)
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithScrubbing(func(corruption Corruption) { found <- corruption }))
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	if err := entry.Freeze(); err != nil {
+		t.Fatal(err)
+	}
+	entry.Buffers[0][0] = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pool.ScrubEvery(ctx, time.Millisecond)
+	select {
+	case corruption := <-found:
+		if corruption.Handle != entry.Handle() {
+			t.Fatalf("unexpected corruption %+v", corruption)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the scrubber to find the corruption")
+	}
+}
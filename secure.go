@@ -0,0 +1,43 @@
+package rustybuffer
+
+// SecurePool is a Pool configured for keys, passwords and other secrets,
+// see NewSecurePool.
+type SecurePool struct {
+	*Pool
+}
+
+// NewSecurePool returns a pool for secrets, with every protection this
+// package offers turned on so they don't have to be assembled piece by
+// piece. Each buffer gets a mapping of its own, which is:
+//
+//   - locked into RAM, so it's never written to swap;
+//   - excluded from core dumps, on Linux;
+//   - flush against an inaccessible guard page, with another before it, so
+//     overrunning or underrunning it crashes rather than reading or
+//     overwriting something else;
+//   - zeroed when it's released, before the mapping is removed.
+//
+// Memory is never handed out from anywhere else: the exhaustion policy is
+// always ExhaustionError (acquires can't override it to fall back to the
+// heap or a spill file) and WithAllocator, WithExhaustionPolicy and
+// WithSpillThreshold among opts are ignored. Compare secrets with the
+// pool's Equal. Acquires fail on platforms other than Linux and macOS, and
+// when the process can't lock any more memory (see RLIMIT_MEMLOCK).
+func NewSecurePool(max_total_size uint64, max_buffer_size uint64, opts ...PoolOption) *SecurePool {
+	pool := NewPool(opts...)
+	pool.alloc = newSecureAllocator(max_total_size, max_buffer_size)
+	if pool.quarantine != nil {
+		pool.quarantine.Allocator = pool.alloc
+		pool.alloc = pool.quarantine
+	}
+	pool.policy = ExhaustionError
+	pool.fixed_policy = true
+	pool.spill_threshold = 0
+	return &SecurePool{pool}
+}
+
+// Equal reports whether a and b hold the same bytes in time that depends
+// only on their lengths, see ConstantTimeEqual.
+func (pool *SecurePool) Equal(a View, b View) bool {
+	return ConstantTimeEqual(a, b)
+}
@@ -0,0 +1,11 @@
+package rustybuffer
+
+import "syscall"
+
+// MADV_DONTDUMP, which the syscall package doesn't define.
+const madvDontDump = 0x10
+
+// excludeFromDumps keeps mapping out of core dumps.
+func excludeFromDumps(mapping []byte) error {
+	return syscall.Madvise(mapping, madvDontDump)
+}
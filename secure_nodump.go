@@ -0,0 +1,9 @@
+//go:build darwin
+
+package rustybuffer
+
+// excludeFromDumps would keep mapping out of core dumps but only Linux can
+// do that for part of a process, so it's left in them.
+func excludeFromDumps(mapping []byte) error {
+	return nil
+}
@@ -0,0 +1,36 @@
+//go:build !(linux || darwin)
+
+package rustybuffer
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// secureAllocator would map every buffer separately, locked and between
+// guard pages, but that's only implemented on Linux and macOS, so every
+// Acquire fails.
+type secureAllocator struct {
+	max_total_size  uint64
+	max_buffer_size uint64
+}
+
+func newSecureAllocator(max_total_size uint64, max_buffer_size uint64) *secureAllocator {
+	return &secureAllocator{max_total_size, max_buffer_size}
+}
+
+func (alloc *secureAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	return nil, newError(codeAllocationFailed,
+		"secure memory is not supported on %s", runtime.GOOS)
+}
+
+func (alloc *secureAllocator) Release(data unsafe.Pointer) error {
+	return newError(codeInvalidPointer, "%p was not acquired from this allocator", data)
+}
+
+func (alloc *secureAllocator) Stats() Stats {
+	return Stats{
+		MaxTotalSize:  alloc.max_total_size,
+		MaxBufferSize: alloc.max_buffer_size,
+	}
+}
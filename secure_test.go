@@ -0,0 +1,93 @@
+//go:build linux || darwin
+
+package rustybuffer
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func newTestSecurePool(t *testing.T) *SecurePool {
+	pool := NewSecurePool(8192, 8192, WithExhaustionPolicy(ExhaustionHeap))
+	entry, err := pool.AllocBuffers([]uint64{16})
+	if err != nil && strings.Contains(err.Error(), "RLIMIT_MEMLOCK") {
+		t.Skipf("can't lock memory: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+	return pool
+}
+
+func TestSecurePool(t *testing.T) {
+	pool := newTestSecurePool(t)
+
+	entry, err := pool.AllocBuffers([]uint64{100, 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, buffer := range entry.Buffers {
+		for idx := range buffer {
+			if buffer[idx] != 0 {
+				t.Fatal("acquired memory wasn't zeroed")
+			}
+			buffer[idx] = 0xAA
+		}
+	}
+
+	// The buffer ends within 16 bytes of the guard page.
+	end := uintptr(unsafe.Pointer(&entry.Buffers[1][19])) + 1
+	page := uintptr(os.Getpagesize())
+	if gap := (page - end%page) % page; gap >= 16 {
+		t.Fatalf("buffer ends %d bytes before a page boundary", gap)
+	}
+
+	if !pool.Equal(entry.View(0), entry.View(0)) || pool.Equal(entry.View(0), entry.View(1)) {
+		t.Fatal("Equal is wrong")
+	}
+
+	// Full pools fail whatever the policy says.
+	for _, policy := range []ExhaustionPolicy{ExhaustionError, ExhaustionHeap, ExhaustionSpill} {
+		if _, err := pool.AllocBuffers([]uint64{8100}, OnExhaustion(policy)); !errors.Is(err, ErrNoBufferAvailable) {
+			t.Fatalf("%v: expected ErrNoBufferAvailable, got %v", policy, err)
+		}
+	}
+	if stats := pool.Stats(); stats.FallbackBytes != 0 || stats.SpilledBytes != 0 || stats.BytesInUse != 120 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := pool.Stats(); stats.BytesInUse != 0 || stats.NumBuffers != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSecurePoolGuardPage(t *testing.T) {
+	if os.Getenv("RUSTYBUFFER_OVERRUN") != "" {
+		pool := NewSecurePool(8192, 4096)
+		entry, err := pool.AllocBuffers([]uint64{100})
+		if err != nil {
+			os.Exit(3)
+		}
+		buffer := unsafe.Slice(unsafe.SliceData(entry.Buffers[0]), 200)
+		buffer[199] = 1
+		os.Exit(0)
+	}
+
+	newTestSecurePool(t)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSecurePoolGuardPage$")
+	cmd.Env = append(os.Environ(), "RUSTYBUFFER_OVERRUN=1")
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() == 3 {
+		t.Fatalf("expected overrunning the buffer to crash, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+//go:build linux || darwin
+
+package rustybuffer
+
+import (
+	"math"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// secureAllocator maps every buffer separately, between two guard pages,
+// locked into RAM and excluded from core dumps.
+type secureAllocator struct {
+	tracker *sizeTracker
+
+	// Each buffer's whole mapping, guard pages included, only touched with
+	// the tracker's lock held.
+	mappings map[unsafe.Pointer][]byte
+}
+
+func newSecureAllocator(max_total_size uint64, max_buffer_size uint64) *secureAllocator {
+	return &secureAllocator{
+		tracker:  newSizeTracker(max_total_size, max_buffer_size),
+		mappings: make(map[unsafe.Pointer][]byte),
+	}
+}
+
+func (alloc *secureAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	var map_err error
+	data, err := alloc.tracker.acquire(size, func(size uint64) unsafe.Pointer {
+		data, err := alloc.mapBuffer(size)
+		if err != nil {
+			map_err = err
+			return nil
+		}
+		return data
+	})
+	if map_err != nil {
+		return nil, map_err
+	}
+	return data, err
+}
+
+// mapBuffer maps size bytes laid out as a guard page, the buffer, padded
+// in front so it ends 16 byte aligned and as close to the second guard
+// page as that allows, then the second guard page.
+func (alloc *secureAllocator) mapBuffer(size uint64) (unsafe.Pointer, error) {
+	page := uint64(os.Getpagesize())
+	pages := (size + page - 1) / page
+	if pages > (math.MaxInt-2*page)/page {
+		return nil, newError(codeBufferTooLarge,
+			"requested %d bytes but can only map %d", size, uint64(math.MaxInt))
+	}
+
+	mapping, err := syscall.Mmap(-1, 0, int((pages+2)*page),
+		syscall.PROT_NONE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, newError(codeAllocationFailed, "mapping %d secure bytes: %v", size, err)
+	}
+
+	usable := mapping[page : (pages+1)*page]
+	fail := func(action string, err error) (unsafe.Pointer, error) {
+		syscall.Munmap(mapping)
+		return nil, newError(codeAllocationFailed, "%s %d secure bytes: %v", action, size, err)
+	}
+	if err := syscall.Mprotect(usable, syscall.PROT_READ|syscall.PROT_WRITE); err != nil {
+		return fail("protecting", err)
+	}
+	if err := syscall.Mlock(usable); err != nil {
+		return fail("locking (is RLIMIT_MEMLOCK too low?)", err)
+	}
+	if err := excludeFromDumps(usable); err != nil {
+		return fail("excluding from core dumps", err)
+	}
+
+	offset := (uint64(len(usable)) - size) &^ 15
+	data := unsafe.Pointer(&usable[offset])
+	alloc.mappings[data] = mapping
+	return data, nil
+}
+
+func (alloc *secureAllocator) Release(data unsafe.Pointer) error {
+	var unmap_err error
+	err := alloc.tracker.release(data, func(data unsafe.Pointer) {
+		mapping := alloc.mappings[data]
+		delete(alloc.mappings, data)
+
+		page := os.Getpagesize()
+		clear(mapping[page : len(mapping)-page])
+		if err := syscall.Munmap(mapping); err != nil {
+			unmap_err = newError(codeInvalidPointer, "unmapping secure buffer %p: %v", data, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return unmap_err
+}
+
+func (alloc *secureAllocator) Stats() Stats {
+	return alloc.tracker.stats()
+}
+
+func (alloc *secureAllocator) LiveHandles() ([]uintptr, error) {
+	return alloc.tracker.liveHandles(), nil
+}
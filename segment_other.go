@@ -0,0 +1,32 @@
+//go:build !unix
+
+package rustybuffer
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// mapSegment would map a file shared with other processes, but that's
+// only implemented on unix platforms.
+func mapSegment(path string, size uint64, create bool) ([]byte, error) {
+	return nil, fmt.Errorf("rustybuffer: shared segments are not supported on %s", runtime.GOOS)
+}
+
+func mapSegmentFile(path string, size uint64, create bool) ([]byte, *os.File, error) {
+	return nil, nil, fmt.Errorf("rustybuffer: shared segments are not supported on %s", runtime.GOOS)
+}
+
+func mapSegmentReadOnly(file *os.File, offset uint64, size uint64) ([]byte, error) {
+	return nil, fmt.Errorf("rustybuffer: shared segments are not supported on %s", runtime.GOOS)
+}
+
+func unmapSegment(mapping []byte) error {
+	return nil
+}
+
+// processAlive can't tell, so assumes pid is running.
+func processAlive(pid int) bool {
+	return true
+}
@@ -0,0 +1,109 @@
+//go:build unix
+
+package rustybuffer
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"syscall"
+)
+
+// mapSegment maps the file at path into memory, shared with every other
+// process that maps it. With create the file is made (it mustn't exist
+// already) and sized to size bytes, which read as zeros. Otherwise size is
+// ignored and the whole of an existing file is mapped. Paths under
+// /dev/shm on Linux never touch a disk.
+func mapSegment(path string, size uint64, create bool) ([]byte, error) {
+	mapping, file, err := mapSegmentFile(path, size, create)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return mapping, nil
+}
+
+// mapSegmentFile is mapSegment, also returning the file the mapping is
+// of, opened read only, for mapSegmentReadOnly. It's opened along with the
+// mapping, and checked to be the same file, so it stays the segment's
+// even if the path is later unlinked and reused.
+func mapSegmentFile(path string, size uint64, create bool) ([]byte, *os.File, error) {
+	flags := os.O_RDWR
+	if create {
+		flags |= os.O_CREATE | os.O_EXCL
+	}
+	file, err := os.OpenFile(path, flags, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("rustybuffer: opening segment: %w", err)
+	}
+	defer file.Close()
+
+	fail := func(err error) ([]byte, *os.File, error) {
+		if create {
+			os.Remove(path)
+		}
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return fail(fmt.Errorf("rustybuffer: opening segment: %w", err))
+	}
+	if create {
+		if size > math.MaxInt {
+			return fail(fmt.Errorf("rustybuffer: a %d byte segment is too large to map", size))
+		}
+		if err := file.Truncate(int64(size)); err != nil {
+			return fail(fmt.Errorf("rustybuffer: sizing segment: %w", err))
+		}
+	} else {
+		size = uint64(info.Size())
+	}
+	if size == 0 {
+		return fail(fmt.Errorf("rustybuffer: segment %s is empty", path))
+	}
+
+	read_only, err := os.Open(path)
+	if err != nil {
+		return fail(fmt.Errorf("rustybuffer: opening segment read only: %w", err))
+	}
+	if read_info, err := read_only.Stat(); err != nil || !os.SameFile(info, read_info) {
+		read_only.Close()
+		return fail(fmt.Errorf("rustybuffer: segment %s was replaced while it was opened", path))
+	}
+
+	mapping, err := syscall.Mmap(int(file.Fd()), 0, int(size),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		read_only.Close()
+		return fail(fmt.Errorf("rustybuffer: mapping %d byte segment: %w", size, err))
+	}
+
+	return mapping, read_only, nil
+}
+
+// mapSegmentReadOnly maps size bytes of file, opened read only, from
+// offset, which must be a multiple of the page size, read only. With the
+// file read only the mapping can't be made writable with mprotect.
+func mapSegmentReadOnly(file *os.File, offset uint64, size uint64) ([]byte, error) {
+	if offset > math.MaxInt64 || size > math.MaxInt {
+		return nil, fmt.Errorf("rustybuffer: %d bytes at %d are too large to map", size, offset)
+	}
+
+	mapping, err := syscall.Mmap(int(file.Fd()), int64(offset), int(size),
+		syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("rustybuffer: mapping segment read only: %w", err)
+	}
+	return mapping, nil
+}
+
+func unmapSegment(mapping []byte) error {
+	return syscall.Munmap(mapping)
+}
+
+// processAlive reports whether pid is a running process, as far as this
+// one can tell.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
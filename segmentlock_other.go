@@ -0,0 +1,12 @@
+//go:build !unix || aix || (solaris && !illumos)
+
+package rustybuffer
+
+import "os"
+
+// Without flock, segments are locked with the lock word alone.
+func lockSegmentFile(file *os.File) bool {
+	return false
+}
+
+func unlockSegmentFile(file *os.File) {}
@@ -0,0 +1,28 @@
+//go:build unix && !aix && !(solaris && !illumos)
+
+package rustybuffer
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockSegmentFile takes an exclusive flock of a segment's file, reporting
+// whether it could. The kernel drops the lock of a process that dies, so
+// unlike the pid in the lock word it works across PID namespaces, where
+// the holder's pid means nothing.
+func lockSegmentFile(file *os.File) bool {
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err == nil {
+			return true
+		}
+		if err != syscall.EINTR {
+			panic("rustybuffer: locking shared segment: " + err.Error())
+		}
+	}
+}
+
+func unlockSegmentFile(file *os.File) {
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
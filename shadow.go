@@ -0,0 +1,228 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Mismatches show at most this many bytes from where they start.
+const shadowMismatchBytes = 16
+
+// WithShadowVerification has the pool keep a copy of every entry's memory
+// on the Go heap, a shadow, updated by the write APIs (Fill, FillRange,
+// FillRandom, Clear, Transform, Copy and the View Put methods). Memory
+// that stops matching its shadow was written some other way, usually
+// through a raw pointer or slice kept after its entry was released and
+// its memory handed to another. Shadows are compared when their entry is
+// released and by VerifyShadows, and every mismatch is passed to report,
+// or logged if it's nil, before the shadow is brought back into line.
+//
+// Writing an entry's Buffers directly is a write the shadow can't see, so
+// code that does has to call SyncShadow afterwards to not be reported.
+// Shadows double the memory the pool's entries use, and every write
+// through the APIs is made twice: it's for chasing corruption, not for
+// production.
+func WithShadowVerification(report func(ShadowMismatch)) PoolOption {
+	return func(pool *Pool) {
+		pool.shadows = &shadowRegistry{report: report, live: make(map[unsafe.Pointer]*shadowCopy)}
+	}
+}
+
+// ShadowMismatch is an entry whose memory no longer matches its shadow,
+// see WithShadowVerification.
+type ShadowMismatch struct {
+	Handle Handle
+
+	// The differing bytes are somewhere in the Length bytes starting
+	// Offset bytes into the entry, counting through its buffers in order,
+	// of which the first ones are shown as the shadow holds them and as
+	// the entry's memory does.
+	Offset   uint64
+	Length   uint64
+	Expected []byte
+	Actual   []byte
+}
+
+// shadowCopy is the shadow of an entry's memory. Its lock is held across
+// each write through the APIs, so a comparison never sees memory that's
+// been written but not its shadow. It doesn't point back at the entry, so
+// the registry holding it doesn't keep a leaked entry alive.
+type shadowCopy struct {
+	mu     sync.Mutex
+	handle Handle
+	data   unsafe.Pointer
+	shadow []byte
+}
+
+type shadowRegistry struct {
+	report func(ShadowMismatch)
+
+	// Held while shadows are compared, so their entries can't be released
+	// part way through.
+	mu   sync.Mutex
+	live map[unsafe.Pointer]*shadowCopy
+}
+
+func (registry *shadowRegistry) track(state *entryState) {
+	shadow := &shadowCopy{handle: state.handle, data: state.data, shadow: make([]byte, state.size)}
+	copy(shadow.shadow, unsafe.Slice((*byte)(state.data), state.size))
+	state.shadow = shadow
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.live[state.data] = shadow
+}
+
+// release compares the shadow of a released entry's memory for the last
+// time and drops it, before the memory is given back.
+func (registry *shadowRegistry) release(data unsafe.Pointer) {
+	registry.mu.Lock()
+	shadow := registry.live[data]
+	delete(registry.live, data)
+	registry.mu.Unlock()
+
+	if shadow == nil {
+		return
+	}
+	if mismatch, ok := shadow.verify(); ok {
+		registry.mismatched(mismatch)
+	}
+}
+
+// forget drops the shadow of a leaked entry, whose memory was given back
+// without comparing it.
+func (registry *shadowRegistry) forget(data unsafe.Pointer) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	delete(registry.live, data)
+}
+
+func (registry *shadowRegistry) mismatched(mismatch ShadowMismatch) {
+	if registry.report != nil {
+		registry.report(mismatch)
+		return
+	}
+	log.Printf("rustybuffer: entry %s was written to behind its shadow's back in the %d bytes at offset %d (expected %x, found %x)",
+		mismatch.Handle, mismatch.Length, mismatch.Offset, mismatch.Expected, mismatch.Actual)
+}
+
+// verify compares the entry's memory to its shadow, bringing the shadow in
+// line with it if it's changed.
+func (shadow *shadowCopy) verify() (ShadowMismatch, bool) {
+	shadow.mu.Lock()
+	defer shadow.mu.Unlock()
+
+	memory := unsafe.Slice((*byte)(shadow.data), len(shadow.shadow))
+	if bytes.Equal(memory, shadow.shadow) {
+		return ShadowMismatch{}, false
+	}
+
+	first := 0
+	for memory[first] == shadow.shadow[first] {
+		first++
+	}
+	last := len(memory) - 1
+	for memory[last] == shadow.shadow[last] {
+		last--
+	}
+	shown := min(last+1, first+shadowMismatchBytes)
+	mismatch := ShadowMismatch{
+		Handle:   shadow.handle,
+		Offset:   uint64(first),
+		Length:   uint64(last + 1 - first),
+		Expected: append([]byte(nil), shadow.shadow[first:shown]...),
+		Actual:   append([]byte(nil), memory[first:shown]...),
+	}
+	copy(shadow.shadow, memory)
+	return mismatch, true
+}
+
+// shadowing returns the entry's shadow, locked for a write through the
+// APIs, or nil if it has none. Passing what was written to wrote unlocks
+// it.
+func (state *entryState) shadowing() *shadowCopy {
+	if state == nil || state.shadow == nil {
+		return nil
+	}
+	state.shadow.mu.Lock()
+	return state.shadow
+}
+
+// wrote copies the parts of the entry's memory that were written to the
+// shadow, and unlocks it.
+func (shadow *shadowCopy) wrote(written ...[]byte) {
+	if shadow == nil {
+		return
+	}
+	defer shadow.mu.Unlock()
+
+	for _, buf := range written {
+		if len(buf) == 0 {
+			continue
+		}
+		// Anything outside the entry, a Buffers slice pointed elsewhere
+		// say, has no shadow.
+		offset := uintptr(unsafe.Pointer(unsafe.SliceData(buf))) - uintptr(shadow.data)
+		if offset < uintptr(len(shadow.shadow)) && uintptr(len(buf)) <= uintptr(len(shadow.shadow))-offset {
+			copy(shadow.shadow[offset:], buf)
+		}
+	}
+}
+
+// SyncShadow brings the entry's shadow in line with its memory, after its
+// Buffers have been written directly, so the writes aren't reported as
+// stray. It does nothing if the pool doesn't keep shadows, see
+// WithShadowVerification.
+func (entry *RBEntry) SyncShadow() {
+	if shadow := entry.state.shadowing(); shadow != nil {
+		shadow.wrote(unsafe.Slice((*byte)(entry.state.data), entry.state.size))
+	}
+}
+
+// VerifyShadows compares every live entry with its shadow, returning the
+// mismatches, oldest entry first, having reported each of them as
+// WithShadowVerification says. It finds nothing if the pool doesn't keep
+// shadows.
+func (pool *Pool) VerifyShadows() []ShadowMismatch {
+	registry := pool.shadows
+	if registry == nil {
+		return nil
+	}
+
+	registry.mu.Lock()
+	var mismatches []ShadowMismatch
+	for _, shadow := range registry.live {
+		if mismatch, ok := shadow.verify(); ok {
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	registry.mu.Unlock()
+
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Handle < mismatches[j].Handle })
+	for _, mismatch := range mismatches {
+		registry.mismatched(mismatch)
+	}
+	return mismatches
+}
+
+// VerifyShadowsEvery calls VerifyShadows every interval until ctx is done.
+func (pool *Pool) VerifyShadowsEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pool.VerifyShadows()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestShadowWriteAPIs(t *testing.T) {
+	var mismatches []ShadowMismatch
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)), WithShadowVerification(func(mismatch ShadowMismatch) {
+		mismatches = append(mismatches, mismatch)
+	}))
+
+	entry, err := pool.AllocBuffers([]uint64{16, 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Fill(1)
+	entry.FillRange(10, 10, 2)
+	if err := entry.FillRandom(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Transform(TransformStep{Name: "xor", Arg: 0xff}); err != nil {
+		t.Fatal(err)
+	}
+	view := entry.View(1)
+	if err := view.PutUint32(4, binary.LittleEndian, 0xdeadbeef); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := view.PutUvarint(8, 300); err != nil {
+		t.Fatal(err)
+	}
+	if err := Copy(entry, entry, []Range{{SrcOffset: 0, DstOffset: 8, Length: 16}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Direct writes are fine once the shadow's told of them.
+	entry.Buffers[0][3] = 42
+	entry.SyncShadow()
+
+	if found := pool.VerifyShadows(); len(found) != 0 {
+		t.Fatalf("unexpected mismatches %+v", found)
+	}
+	entry.Release()
+	if len(mismatches) != 0 {
+		t.Fatalf("unexpected mismatches %+v", mismatches)
+	}
+}
+
+func TestShadowStrayWrites(t *testing.T) {
+	var mismatches []ShadowMismatch
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)), WithShadowVerification(func(mismatch ShadowMismatch) {
+		mismatches = append(mismatches, mismatch)
+	}))
+
+	entry, err := pool.AllocBuffers([]uint64{10, 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Clear()
+	leaked := entry.Buffers[1]
+	leaked[5] = 1
+	leaked[20] = 2
+
+	found := pool.VerifyShadows()
+	expected := ShadowMismatch{Handle: entry.Handle(), Offset: 15, Length: 16,
+		Expected: make([]byte, 16), Actual: append(append([]byte{1}, make([]byte, 14)...), 2)}
+	if len(found) != 1 || len(mismatches) != 1 || found[0].Offset != expected.Offset || found[0].Length != expected.Length ||
+		!bytes.Equal(found[0].Expected, expected.Expected) || !bytes.Equal(found[0].Actual, expected.Actual) {
+		t.Fatalf("expected %+v, got %+v", expected, found)
+	}
+
+	// Each stray write is reported once.
+	if found := pool.VerifyShadows(); len(found) != 0 {
+		t.Fatalf("unexpected mismatches %+v", found)
+	}
+
+	// And they're caught on release too.
+	leaked[0] = 3
+	entry.Release()
+	if len(mismatches) != 2 || mismatches[1].Offset != 10 || mismatches[1].Length != 1 {
+		t.Fatalf("unexpected mismatches %+v", mismatches)
+	}
+}
+
+func TestShadowDisabled(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	entry.Buffers[0][0] = 1
+	entry.SyncShadow()
+	if found := pool.VerifyShadows(); found != nil {
+		t.Fatalf("unexpected mismatches %+v", found)
+	}
+}
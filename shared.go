@@ -0,0 +1,534 @@
+package rustybuffer
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// ErrStaleHandle is returned, wrapped, by ImportHandle when a handle's
+// memory has been released (and perhaps reused) since it was exported.
+var ErrStaleHandle = errors.New("rustybuffer: stale shared handle")
+
+// A shared segment is laid out as follows, in the host's byte order with
+// every u64 accessed atomically:
+//
+//	0    u64  magic, 0x5242534547000001 ("RBSEG" and version 1), stored
+//	          last when the segment is created
+//	8    u64  segment id, random and never zero
+//	16   u64  block size
+//	24   u64  block count
+//	32   u64  lock, the pid of the process holding it or zero, see lock
+//	40   u64  the last generation handed out
+//	48   u64  bytes in use, in whole blocks
+//	56   u64  buffers in use
+//	64   the block records, one per block:
+//	       u32  state: free, the head of a buffer, or part of one
+//	       u32  the pid of the process that acquired it (heads only)
+//	       u64  the buffer's length in blocks (heads only)
+//	       u64  the requested size in bytes (heads only)
+//	       u64  the buffer's generation (heads only)
+//	     then, from the next page boundary, the blocks.
+//
+// Buffers are runs of whole blocks, found first fit with the lock held.
+const (
+	segmentMagic   uint64 = 0x52425345_47000001
+	segmentRecords        = 64
+	segmentRecord         = 32
+	segmentAlign          = 4096
+
+	blockFree uint32 = 0
+	blockHead uint32 = 1
+	blockTail uint32 = 2
+)
+
+// SharedSegment is an Allocator whose memory is a file mapped by every
+// process using it, e.g., under /dev/shm, so entries from a pool backed by
+// one can be referred to from other processes with Export and
+// ImportHandle instead of copying their bytes. Its bookkeeping is in the
+// segment too and shared by every process: Stats describes all of them.
+type SharedSegment struct {
+	path    string
+	mapping []byte
+
+	// The segment's file, opened read only, for ReadOnlyView and locking.
+	file *os.File
+
+	id         uint64
+	block_size uint64
+	blocks     uint64
+	data       uint64
+
+	// The buffers this mapping acquired or adopted, by first block, with
+	// their generations. Taken with the segment's lock held.
+	mu    sync.Mutex
+	owned map[uint64]uint64
+
+	// Held along with the segment's lock, which the goroutines of one
+	// mapping can't tell apart.
+	lock_mu sync.Mutex
+
+	// The pid of the last dead process this mapping took the lock from.
+	broken_lock atomic.Uint64
+}
+
+// The segments open in this process, for Export and ImportHandle. The same
+// segment can be open more than once.
+var sharedSegments struct {
+	mu   sync.Mutex
+	open []*SharedSegment
+}
+
+// segmentSize returns the size of a segment with blocks blocks, and the
+// offset of the first.
+func segmentSize(block_size uint64, blocks uint64) (uint64, uint64, bool) {
+	if blocks > (^uint64(0)-segmentRecords)/segmentRecord {
+		return 0, 0, false
+	}
+	data := segmentRecords + blocks*segmentRecord
+	data = (data + segmentAlign - 1) &^ (segmentAlign - 1)
+	if block_size != 0 && blocks > (^uint64(0)-data)/block_size {
+		return 0, 0, false
+	}
+	return data + blocks*block_size, data, true
+}
+
+// CreateSharedSegment creates a segment of size bytes, in blocks of
+// block_size, in a new file at path. Every buffer takes at least one
+// block, so block_size trades wasted space against the bookkeeping.
+func CreateSharedSegment(path string, size uint64, block_size uint64) (*SharedSegment, error) {
+	if block_size == 0 || block_size%arenaAlignment != 0 {
+		return nil, fmt.Errorf("rustybuffer: segment block size %d isn't a multiple of %d",
+			block_size, arenaAlignment)
+	}
+	blocks := size / block_size
+	if blocks == 0 {
+		return nil, fmt.Errorf("rustybuffer: a %d byte segment doesn't fit a %d byte block",
+			size, block_size)
+	}
+	total, data, ok := segmentSize(block_size, blocks)
+	if !ok {
+		return nil, fmt.Errorf("rustybuffer: a %d byte segment is too large", size)
+	}
+
+	var id uint64
+	for id == 0 {
+		if err := binary.Read(rand.Reader, binary.LittleEndian, &id); err != nil {
+			return nil, fmt.Errorf("rustybuffer: choosing a segment id: %w", err)
+		}
+	}
+
+	mapping, file, err := mapSegmentFile(path, total, true)
+	if err != nil {
+		return nil, err
+	}
+
+	seg := &SharedSegment{
+		path:       path,
+		mapping:    mapping,
+		file:       file,
+		id:         id,
+		block_size: block_size,
+		blocks:     blocks,
+		data:       data,
+		owned:      make(map[uint64]uint64),
+	}
+	atomic.StoreUint64(seg.word(8), id)
+	atomic.StoreUint64(seg.word(16), block_size)
+	atomic.StoreUint64(seg.word(24), blocks)
+	atomic.StoreUint64(seg.word(0), segmentMagic)
+
+	seg.register()
+	return seg, nil
+}
+
+// OpenSharedSegment opens the segment another process created at path.
+func OpenSharedSegment(path string) (*SharedSegment, error) {
+	mapping, file, err := mapSegmentFile(path, 0, false)
+	if err != nil {
+		return nil, err
+	}
+
+	fail := func(format string, args ...any) (*SharedSegment, error) {
+		unmapSegment(mapping)
+		file.Close()
+		return nil, fmt.Errorf("rustybuffer: %s isn't a shared segment: "+format,
+			append([]any{path}, args...)...)
+	}
+	if len(mapping) < segmentRecords {
+		return fail("only %d bytes", len(mapping))
+	}
+	if magic := atomic.LoadUint64((*uint64)(unsafe.Pointer(&mapping[0]))); magic != segmentMagic {
+		return fail("magic %#x, expected %#x", magic, segmentMagic)
+	}
+
+	seg := &SharedSegment{path: path, mapping: mapping, file: file, owned: make(map[uint64]uint64)}
+	seg.id = atomic.LoadUint64(seg.word(8))
+	seg.block_size = atomic.LoadUint64(seg.word(16))
+	seg.blocks = atomic.LoadUint64(seg.word(24))
+	total, data, ok := segmentSize(seg.block_size, seg.blocks)
+	if !ok || seg.block_size == 0 || total != uint64(len(mapping)) {
+		return fail("%d blocks of %d bytes don't fit %d bytes",
+			seg.blocks, seg.block_size, len(mapping))
+	}
+	seg.data = data
+
+	seg.register()
+	return seg, nil
+}
+
+func (seg *SharedSegment) register() {
+	sharedSegments.mu.Lock()
+	defer sharedSegments.mu.Unlock()
+
+	sharedSegments.open = append(sharedSegments.open, seg)
+}
+
+// openSegment returns a mapping of the segment with id, if there is one.
+func openSegment(id uint64) (*SharedSegment, bool) {
+	sharedSegments.mu.Lock()
+	defer sharedSegments.mu.Unlock()
+
+	for _, seg := range sharedSegments.open {
+		if seg.id == id {
+			return seg, true
+		}
+	}
+	return nil, false
+}
+
+// Close unmaps the segment. Entries from it, and imported from it, mustn't
+// be used afterwards, and buffers this process still has acquired stay
+// acquired.
+func (seg *SharedSegment) Close() error {
+	sharedSegments.mu.Lock()
+	for idx, open := range sharedSegments.open {
+		if open == seg {
+			sharedSegments.open = append(sharedSegments.open[:idx], sharedSegments.open[idx+1:]...)
+			break
+		}
+	}
+	sharedSegments.mu.Unlock()
+
+	seg.file.Close()
+	return unmapSegment(seg.mapping)
+}
+
+// ID is the segment's id, the same in every process that opens it.
+func (seg *SharedSegment) ID() uint64 {
+	return seg.id
+}
+
+func (seg *SharedSegment) word(offset uint64) *uint64 {
+	return (*uint64)(unsafe.Pointer(&seg.mapping[offset]))
+}
+
+// blockRecord is a block's record in the segment.
+type blockRecord struct {
+	state      uint32
+	owner      uint32
+	run        uint64
+	length     uint64
+	generation uint64
+}
+
+func (seg *SharedSegment) record(idx uint64) *blockRecord {
+	return (*blockRecord)(unsafe.Pointer(&seg.mapping[segmentRecords+idx*segmentRecord]))
+}
+
+func (seg *SharedSegment) block(idx uint64) unsafe.Pointer {
+	return unsafe.Pointer(&seg.mapping[seg.data+idx*seg.block_size])
+}
+
+// index returns the block data starts, if it's the start of one.
+func (seg *SharedSegment) index(data unsafe.Pointer) (uint64, bool) {
+	base := uintptr(unsafe.Pointer(&seg.mapping[seg.data]))
+	addr := uintptr(data)
+	if addr < base || addr >= base+uintptr(seg.blocks*seg.block_size) {
+		return 0, false
+	}
+	offset := uint64(addr - base)
+	return offset / seg.block_size, offset%seg.block_size == 0
+}
+
+// lock takes the lock every process shares, spinning until it's free.
+// It's only held for bookkeeping, never while waiting on anything else.
+// lock takes the segment's lock: a flock of its file, and its lock word
+// set to this process's pid while it's held, so that finding the word
+// already set means whoever held it last died holding it, part way
+// through something Repair may need to sort out.
+//
+// Where flock isn't available the word is the lock, and a holder that's
+// not running is found with processAlive, which can be fooled by
+// processes in different PID namespaces.
+func (seg *SharedSegment) lock() {
+	seg.lock_mu.Lock()
+
+	lock := seg.word(32)
+	pid := uint64(os.Getpid())
+	if lockSegmentFile(seg.file) {
+		if holder := atomic.SwapUint64(lock, pid); holder != 0 {
+			seg.broken_lock.Store(holder)
+		}
+		return
+	}
+
+	for spins := 1; !atomic.CompareAndSwapUint64(lock, 0, pid); spins++ {
+		if spins > 100 {
+			runtime.Gosched()
+		}
+
+		// A process that died holding the lock would hang everyone else,
+		// so every so often check and take it over, leaving Repair to sort
+		// out whatever it was in the middle of.
+		if spins%10000 == 0 {
+			holder := atomic.LoadUint64(lock)
+			if holder != 0 && !processAlive(int(holder)) &&
+				atomic.CompareAndSwapUint64(lock, holder, pid) {
+				seg.broken_lock.Store(holder)
+				return
+			}
+		}
+	}
+}
+
+func (seg *SharedSegment) unlock() {
+	atomic.StoreUint64(seg.word(32), 0)
+	unlockSegmentFile(seg.file)
+	seg.lock_mu.Unlock()
+}
+
+func (seg *SharedSegment) Acquire(size uint64) (unsafe.Pointer, error) {
+	max_size := seg.blocks * seg.block_size
+	if size > max_size {
+		return nil, newError(codeBufferTooLarge,
+			"requested %d bytes but max_buffer_size is %d", size, max_size)
+	}
+	run := max((size+seg.block_size-1)/seg.block_size, 1)
+
+	seg.lock()
+	defer seg.unlock()
+
+	var start, free uint64 = 0, 0
+	for idx := uint64(0); idx < seg.blocks && free < run; {
+		record := seg.record(idx)
+		if record.state == blockHead {
+			idx += max(record.run, 1)
+			start, free = idx, 0
+			continue
+		}
+		free++
+		idx++
+	}
+	if free < run {
+		return nil, newError(codeNoBufferAvailable,
+			"requested %d bytes with %d of max_total_size %d in use",
+			size, atomic.LoadUint64(seg.word(48)), max_size)
+	}
+
+	generation := atomic.AddUint64(seg.word(40), 1)
+	*seg.record(start) = blockRecord{blockHead, uint32(os.Getpid()), run, size, generation}
+	for idx := start + 1; idx < start+run; idx++ {
+		*seg.record(idx) = blockRecord{state: blockTail}
+	}
+	atomic.AddUint64(seg.word(48), run*seg.block_size)
+	atomic.AddUint64(seg.word(56), 1)
+
+	seg.mu.Lock()
+	seg.owned[start] = generation
+	seg.mu.Unlock()
+
+	data := seg.block(start)
+	clear(unsafe.Slice((*byte)(data), run*seg.block_size))
+
+	return data, nil
+}
+
+func (seg *SharedSegment) Release(data unsafe.Pointer) error {
+	idx, ok := seg.index(data)
+	if !ok {
+		return newError(codeInvalidPointer,
+			"%p was not acquired from this mapping of the segment", data)
+	}
+
+	seg.lock()
+	defer seg.unlock()
+
+	seg.mu.Lock()
+	generation, owned := seg.owned[idx]
+	delete(seg.owned, idx)
+	seg.mu.Unlock()
+
+	record := seg.record(idx)
+	if !owned || record.state != blockHead || record.generation != generation {
+		return newError(codeInvalidPointer,
+			"%p was not acquired from this mapping of the segment", data)
+	}
+
+	run := record.run
+	for block := idx; block < idx+run; block++ {
+		*seg.record(block) = blockRecord{}
+	}
+	atomic.AddUint64(seg.word(48), -run*seg.block_size)
+	atomic.AddUint64(seg.word(56), ^uint64(0))
+
+	return nil
+}
+
+func (seg *SharedSegment) Stats() Stats {
+	in_use := atomic.LoadUint64(seg.word(48))
+	return Stats{
+		MaxTotalSize:   seg.blocks * seg.block_size,
+		MaxBufferSize:  seg.blocks * seg.block_size,
+		BytesAllocated: in_use,
+		BytesInUse:     in_use,
+		NumBuffers:     atomic.LoadUint64(seg.word(56)),
+		NumAvailable:   0,
+	}
+}
+
+// SharedHandle refers to an entry's memory in a shared segment, from any
+// process that has the segment open. The generation changes every time
+// the memory is acquired, so a handle to memory that's since been
+// released and reused is caught rather than read.
+type SharedHandle struct {
+	Segment    uint64
+	Offset     uint64
+	Length     uint64
+	Generation uint64
+}
+
+// Encoded handles start with this, so the format can change.
+const sharedHandleVersion = 1
+
+// Encoded handles are a version byte then the fields as little endian
+// u64s.
+const sharedHandleSize = 1 + 4*8
+
+func (handle SharedHandle) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 1, sharedHandleSize)
+	buf[0] = sharedHandleVersion
+	buf = binary.LittleEndian.AppendUint64(buf, handle.Segment)
+	buf = binary.LittleEndian.AppendUint64(buf, handle.Offset)
+	buf = binary.LittleEndian.AppendUint64(buf, handle.Length)
+	buf = binary.LittleEndian.AppendUint64(buf, handle.Generation)
+	return buf, nil
+}
+
+func (handle *SharedHandle) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] != sharedHandleVersion {
+		return fmt.Errorf("%w: unknown shared handle version", ErrInvalidData)
+	}
+	if len(data) != sharedHandleSize {
+		return fmt.Errorf("%w: %d byte shared handle, expected %d",
+			ErrInvalidData, len(data), sharedHandleSize)
+	}
+
+	handle.Segment = binary.LittleEndian.Uint64(data[1:])
+	handle.Offset = binary.LittleEndian.Uint64(data[9:])
+	handle.Length = binary.LittleEndian.Uint64(data[17:])
+	handle.Generation = binary.LittleEndian.Uint64(data[25:])
+	return nil
+}
+
+// MarshalText encodes the handle as unpadded URL safe base64, for JSON and
+// other text formats.
+func (handle SharedHandle) MarshalText() ([]byte, error) {
+	buf, _ := handle.MarshalBinary()
+	return []byte(base64.RawURLEncoding.EncodeToString(buf)), nil
+}
+
+func (handle *SharedHandle) UnmarshalText(text []byte) error {
+	buf, err := base64.RawURLEncoding.DecodeString(string(text))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	return handle.UnmarshalBinary(buf)
+}
+
+// Export returns a handle to the entry's memory, all of its buffers as
+// one, for another process to ImportHandle. The entry must come from a
+// pool backed by a SharedSegment, and stays this process's to release:
+// the importer only borrows it.
+func (entry *RBEntry) Export() (SharedHandle, error) {
+	if entry.state != nil && entry.state.released.Load() || entry.data == nil {
+		return SharedHandle{}, fmt.Errorf("rustybuffer: export of a released entry")
+	}
+	if err := entry.checkTaint("Export"); err != nil {
+		return SharedHandle{}, err
+	}
+
+	sharedSegments.mu.Lock()
+	defer sharedSegments.mu.Unlock()
+
+	for _, seg := range sharedSegments.open {
+		idx, ok := seg.index(entry.data)
+		if !ok {
+			continue
+		}
+
+		seg.lock()
+		record := *seg.record(idx)
+		seg.unlock()
+		if record.state != blockHead {
+			break
+		}
+		return SharedHandle{seg.id, seg.data + idx*seg.block_size, record.length, record.generation}, nil
+	}
+
+	return SharedHandle{}, fmt.Errorf("rustybuffer: entry %s isn't from a shared segment",
+		entry.Handle())
+}
+
+// handleBlock returns the first block of handle's buffer.
+func (seg *SharedSegment) handleBlock(handle SharedHandle) (uint64, error) {
+	if handle.Offset < seg.data || (handle.Offset-seg.data)%seg.block_size != 0 ||
+		handle.Offset >= uint64(len(seg.mapping)) {
+		return 0, fmt.Errorf("%w: offset %d isn't a block of segment %#x",
+			ErrInvalidData, handle.Offset, seg.id)
+	}
+	return (handle.Offset - seg.data) / seg.block_size, nil
+}
+
+// ImportHandle returns an entry for the memory another process exported,
+// from a segment this process has open. The entry has a single buffer and
+// borrows the memory: releasing it does nothing, and it mustn't be used
+// once the exporter releases the original.
+func ImportHandle(handle SharedHandle) (RBEntry, error) {
+	seg, ok := openSegment(handle.Segment)
+	if !ok {
+		return RBEntry{}, fmt.Errorf("rustybuffer: segment %#x isn't open", handle.Segment)
+	}
+
+	idx, err := seg.handleBlock(handle)
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	seg.lock()
+	record := *seg.record(idx)
+	seg.unlock()
+	if record.state != blockHead || record.generation != handle.Generation ||
+		record.length != handle.Length {
+		return RBEntry{}, fmt.Errorf("%w: block %d of segment %#x", ErrStaleHandle, idx, seg.id)
+	}
+
+	data := seg.block(idx)
+	state := &entryState{
+		pool:   defaultPool,
+		handle: nextHandle(),
+		data:   data,
+		size:   handle.Length,
+		alloc:  unownedAllocator{},
+
+		entryPins: &entryPins{},
+	}
+	return RBEntry{data, [][]uint8{unsafe.Slice((*byte)(data), handle.Length)}, state}, nil
+}
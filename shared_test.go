@@ -0,0 +1,191 @@
+//go:build unix
+
+package rustybuffer
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSharedSegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segment")
+	seg, err := CreateSharedSegment(path, 64*1024, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.Close()
+	pool := NewPool(WithAllocator(seg))
+
+	entry, err := pool.AllocBuffers([]uint64{100, 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(entry.Buffers[0], "shared")
+
+	// Another mapping of the segment, as another process would have.
+	other, err := OpenSharedSegment(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if other.ID() != seg.ID() {
+		t.Fatal("the segment's id changed")
+	}
+	if stats := other.Stats(); stats.BytesInUse != 3*1024 || stats.NumBuffers != 1 {
+		t.Fatalf("stats aren't shared: %+v", stats)
+	}
+
+	handle, err := entry.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handle.Segment != seg.ID() || handle.Length != 2100 {
+		t.Fatalf("unexpected handle %+v", handle)
+	}
+
+	// Through the wire and back.
+	var decoded SharedHandle
+	encoded, _ := handle.MarshalBinary()
+	if err := decoded.UnmarshalBinary(encoded); err != nil || decoded != handle {
+		t.Fatalf("binary round trip gave %+v: %v", decoded, err)
+	}
+	text, _ := handle.MarshalText()
+	decoded = SharedHandle{}
+	if err := decoded.UnmarshalText(text); err != nil || decoded != handle {
+		t.Fatalf("text round trip gave %+v: %v", decoded, err)
+	}
+	encoded[0] = 99
+	if err := decoded.UnmarshalBinary(encoded); !errors.Is(err, ErrInvalidData) {
+		t.Fatalf("expected ErrInvalidData, got %v", err)
+	}
+
+	imported, err := ImportHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Buffers) != 1 || len(imported.Buffers[0]) != 2100 ||
+		!bytes.HasPrefix(imported.Buffers[0], []byte("shared")) {
+		t.Fatal("the imported entry doesn't have the exported bytes")
+	}
+
+	// Importers only borrow.
+	imported.Release()
+	if seg.Stats().NumBuffers != 1 {
+		t.Fatalf("releasing an import freed the memory: %+v", seg.Stats())
+	}
+
+	entry.Release()
+	if _, err := ImportHandle(handle); !errors.Is(err, ErrStaleHandle) {
+		t.Fatalf("expected ErrStaleHandle, got %v", err)
+	}
+	again, err := pool.AllocBuffers([]uint64{2100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Release()
+	if _, err := ImportHandle(handle); !errors.Is(err, ErrStaleHandle) {
+		t.Fatalf("expected ErrStaleHandle for reused memory, got %v", err)
+	}
+}
+
+func TestSharedSegmentFull(t *testing.T) {
+	seg, err := CreateSharedSegment(filepath.Join(t.TempDir(), "segment"), 4*1024, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.Close()
+	pool := NewPool(WithAllocator(seg))
+
+	var entries []RBEntry
+	for idx := 0; idx < 4; idx++ {
+		entry, err := pool.AllocBuffers([]uint64{1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if _, err := pool.AllocBuffers([]uint64{1}); !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+
+	// Freeing two neighbours makes room for a buffer spanning both.
+	entries[1].Release()
+	entries[2].Release()
+	if _, err := pool.AllocBuffers([]uint64{2048}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.AllocBuffers([]uint64{8192}); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+}
+
+func TestSharedSegmentInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := CreateSharedSegment(filepath.Join(dir, "odd"), 4096, 1000); err == nil {
+		t.Fatal("expected an error for an unaligned block size")
+	}
+
+	path := filepath.Join(dir, "ring")
+	ring, err := CreateRing(path, 2, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring.Close()
+	if _, err := OpenSharedSegment(path); err == nil {
+		t.Fatal("expected an error opening a ring as a segment")
+	}
+
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+	entry, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	if _, err := entry.Export(); err == nil {
+		t.Fatal("expected an error exporting from the heap")
+	}
+	if _, err := ImportHandle(SharedHandle{Segment: 1}); err == nil {
+		t.Fatal("expected an error importing from an unknown segment")
+	}
+}
+
+func TestSharedSegmentLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segment")
+	seg, err := CreateSharedSegment(path, 64*1024, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.Close()
+	other, err := OpenSharedSegment(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// Separate mappings exclude each other as separate processes would.
+	seg.lock()
+	locked := make(chan struct{})
+	go func() {
+		other.lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("both mappings hold the lock")
+	case <-time.After(10 * time.Millisecond):
+	}
+	seg.unlock()
+	<-locked
+	other.unlock()
+
+	if lock := atomic.LoadUint64(seg.word(32)); lock != 0 {
+		t.Fatalf("the lock word wasn't cleared: %d", lock)
+	}
+	if report := seg.Repair(false); report.BrokenLock != 0 || report.Repaired() {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
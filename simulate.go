@@ -0,0 +1,425 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// SimulationConfig describes the allocator a simulation models.
+type SimulationConfig struct {
+	MaxTotalSize  uint64
+	MaxBufferSize uint64
+
+	// Requests are rounded up to the smallest of these that holds them,
+	// in place of the exact sizes the Rust library allocates. Requests
+	// bigger than every class, or all of them if there are none, get
+	// exactly what they asked for.
+	SizeClasses []uint64
+}
+
+// SimulationReport summarises everything a simulation allocator has seen.
+// The gap between requested bytes and bytes in use is what size class
+// rounding and reusing cached buffers bigger than a request cost, the gap
+// between bytes in use and bytes allocated is what the cache holds on to.
+type SimulationReport struct {
+	Acquires uint64
+	Releases uint64
+
+	// Acquires that failed because the modelled allocator was full.
+	Failures uint64
+
+	// What the caller asked for, not counting rounding.
+	RequestedBytes     uint64
+	PeakRequestedBytes uint64
+
+	PeakBytesInUse     uint64
+	PeakBytesAllocated uint64
+
+	// Cached buffers freed to make room for new ones.
+	Evictions uint64
+}
+
+// simulationAllocator models the Rust library's buffer cache without
+// allocating any memory. Every buffer is a synthetic address, see
+// simulatedAddresses, that's never read or written.
+type simulationAllocator struct {
+	mu              sync.Mutex
+	max_total_size  uint64
+	max_buffer_size uint64
+	classes         []uint64
+
+	bytes_allocated uint64
+	bytes_in_use    uint64
+
+	// Each buffer's size, the requested size for those in use, and what's
+	// cached sorted by (size, address).
+	buffers   map[unsafe.Pointer]uint64
+	requested map[unsafe.Pointer]uint64
+	available []simulatedBuffer
+
+	addresses simulatedAddresses
+
+	report SimulationReport
+}
+
+// Synthetic addresses are handed out this far apart, so that they're
+// aligned like the Rust library's buffers.
+const simulatedAddressStride = 64
+
+// And come from regions of this many bytes of address space.
+const simulatedRegionSize = 1 << 20
+
+// simulatedAddresses hands out the addresses of a simulation's buffers.
+// Each is a distinct address in a region reserved with
+// reserveSimulatedRegion, which on unix and Windows can't be read or
+// written, so a stray access faults rather than scribbling on something
+// real. A buffer's bytes run past its address into whatever follows, so
+// they must never be touched, but only a region per few thousand buffers
+// is reserved however big they are, and pools far bigger than the address
+// space can be modelled.
+type simulatedAddresses struct {
+	region unsafe.Pointer
+	used   uintptr
+
+	// Addresses of evicted buffers, for reuse.
+	free []unsafe.Pointer
+}
+
+// take returns an address no buffer is using.
+func (addrs *simulatedAddresses) take() (unsafe.Pointer, error) {
+	if count := len(addrs.free); count > 0 {
+		data := addrs.free[count-1]
+		addrs.free = addrs.free[:count-1]
+		return data, nil
+	}
+
+	if addrs.region == nil || addrs.used == simulatedRegionSize {
+		region, err := reserveSimulatedRegion(simulatedRegionSize)
+		if err != nil {
+			return nil, err
+		}
+		addrs.region = region
+		addrs.used = 0
+	}
+
+	data := unsafe.Add(addrs.region, addrs.used)
+	addrs.used += simulatedAddressStride
+	return data, nil
+}
+
+// give hands back an address taken for an evicted buffer.
+func (addrs *simulatedAddresses) give(data unsafe.Pointer) {
+	addrs.free = append(addrs.free, data)
+}
+
+type simulatedBuffer struct {
+	size uint64
+	data unsafe.Pointer
+}
+
+func (buff simulatedBuffer) less(other simulatedBuffer) bool {
+	if buff.size != other.size {
+		return buff.size < other.size
+	}
+	return uintptr(buff.data) < uintptr(other.data)
+}
+
+// NewSimulationAllocator returns an Allocator that models the occupancy
+// and fragmentation config would have, without allocating any memory, to
+// answer questions like "would 12GB have been enough?" for a workload
+// without the hardware to run it. It follows the Rust library's caching
+// policy: released buffers are kept, reused smallest-fit first and
+// evicted largest and smallest first when there's no room.
+//
+// Acquire returns synthetic addresses that nothing backs, so code using a
+// simulation must not read or write its buffers. They take up next to no
+// address space, so a simulation can model a pool far bigger than the
+// machine's, but each buffer is still a slice starting at its address, so
+// none can run past the end of the address space, which on 32-bit
+// platforms caps them at a gigabyte or two.
+func NewSimulationAllocator(config SimulationConfig) Allocator {
+	classes := append([]uint64(nil), config.SizeClasses...)
+	sort.Slice(classes, func(i, j int) bool { return classes[i] < classes[j] })
+
+	return &simulationAllocator{
+		max_total_size:  config.MaxTotalSize,
+		max_buffer_size: config.MaxBufferSize,
+		classes:         classes,
+		buffers:         make(map[unsafe.Pointer]uint64),
+		requested:       make(map[unsafe.Pointer]uint64),
+	}
+}
+
+// NewSimulationPool returns a Pool backed by NewSimulationAllocator. The
+// ExhaustionHeap and ExhaustionSpill policies still allocate real memory
+// for what the model can't hold, so a simulation is best left with the
+// default ExhaustionError policy, or ExhaustionBlock for a concurrent
+// workload.
+func NewSimulationPool(config SimulationConfig, opts ...PoolOption) *Pool {
+	return NewPool(append([]PoolOption{WithAllocator(NewSimulationAllocator(config))}, opts...)...)
+}
+
+// Simulation returns what a simulation allocator, or the allocator of a
+// Pool from NewSimulationPool, has seen so far. It's false for any other
+// allocator.
+func Simulation(alloc Allocator) (SimulationReport, bool) {
+	sim, ok := alloc.(*simulationAllocator)
+	if !ok {
+		return SimulationReport{}, false
+	}
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	return sim.report, true
+}
+
+// round returns the size class for size.
+func (alloc *simulationAllocator) round(size uint64) uint64 {
+	idx := sort.Search(len(alloc.classes), func(i int) bool {
+		return alloc.classes[i] >= size
+	})
+	if idx < len(alloc.classes) {
+		return alloc.classes[idx]
+	}
+	return size
+}
+
+func (alloc *simulationAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	if size > alloc.max_buffer_size {
+		return nil, newError(codeBufferTooLarge,
+			"requested %d bytes but max_buffer_size is %d",
+			size, alloc.max_buffer_size)
+	}
+
+	rounded := alloc.round(size)
+
+	idx := sort.Search(len(alloc.available), func(i int) bool {
+		return alloc.available[i].size >= rounded
+	})
+	if idx < len(alloc.available) {
+		buff := alloc.available[idx]
+		alloc.available = append(alloc.available[:idx], alloc.available[idx+1:]...)
+		alloc.bytes_in_use += buff.size
+		alloc.acquired(buff.data, size)
+		return buff.data, nil
+	}
+
+	if !alloc.canAllocate(rounded) {
+		alloc.report.Failures++
+		return nil, newError(codeNoBufferAvailable,
+			"requested %d bytes with %d of max_total_size %d in use",
+			size, alloc.bytes_in_use, alloc.max_total_size)
+	}
+
+	data, err := alloc.addresses.take()
+	if err != nil {
+		return nil, err
+	}
+	if rounded > uint64(^uintptr(0)-uintptr(data)) {
+		alloc.addresses.give(data)
+		return nil, newError(codeBufferTooLarge,
+			"requested %d bytes but a buffer at %p can only be %d",
+			size, data, uint64(^uintptr(0)-uintptr(data)))
+	}
+
+	alloc.buffers[data] = rounded
+	alloc.bytes_allocated += rounded
+	alloc.bytes_in_use += rounded
+	alloc.acquired(data, size)
+
+	return data, nil
+}
+
+// acquired updates the report for a successful acquire, with the lock
+// held.
+func (alloc *simulationAllocator) acquired(data unsafe.Pointer, size uint64) {
+	alloc.requested[data] = size
+
+	report := &alloc.report
+	report.Acquires++
+	report.RequestedBytes += size
+	report.PeakRequestedBytes = max(report.PeakRequestedBytes, report.RequestedBytes)
+	report.PeakBytesInUse = max(report.PeakBytesInUse, alloc.bytes_in_use)
+	report.PeakBytesAllocated = max(report.PeakBytesAllocated, alloc.bytes_allocated)
+}
+
+// canAllocate mirrors RustyBuffers::can_allocate, freeing the largest and then
+// smallest cached buffers until there's room for size bytes.
+func (alloc *simulationAllocator) canAllocate(size uint64) bool {
+	total := alloc.bytes_allocated + size
+	if total < size {
+		return false
+	}
+	if total <= alloc.max_total_size {
+		return true
+	}
+	if alloc.bytes_in_use+size > alloc.max_total_size || alloc.bytes_in_use+size < size {
+		return false
+	}
+
+	free_at_least := total - alloc.max_total_size
+
+	var bytes_freed uint64 = 0
+	for bytes_freed < free_at_least {
+		bytes_freed += alloc.evict(len(alloc.available) - 1)
+		if bytes_freed >= free_at_least {
+			break
+		}
+		bytes_freed += alloc.evict(0)
+	}
+
+	alloc.bytes_allocated -= bytes_freed
+
+	return true
+}
+
+// evict frees the idx'th cached buffer and returns its size.
+func (alloc *simulationAllocator) evict(idx int) uint64 {
+	buff := alloc.available[idx]
+	alloc.available = append(alloc.available[:idx], alloc.available[idx+1:]...)
+	delete(alloc.buffers, buff.data)
+	alloc.report.Evictions++
+	alloc.addresses.give(buff.data)
+
+	return buff.size
+}
+
+// Trim evicts every cached buffer, as the Rust library's trim would.
+func (alloc *simulationAllocator) Trim() uint64 {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	var bytes_freed uint64 = 0
+	for len(alloc.available) > 0 {
+		bytes_freed += alloc.evict(len(alloc.available) - 1)
+	}
+	alloc.bytes_allocated -= bytes_freed
+
+	return bytes_freed
+}
+
+func (alloc *simulationAllocator) Release(data unsafe.Pointer) error {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	size, ok := alloc.buffers[data]
+	requested, in_use := alloc.requested[data]
+	if !ok || !in_use {
+		return newError(codeInvalidPointer,
+			"%p was not acquired from this simulation", data)
+	}
+
+	delete(alloc.requested, data)
+	alloc.bytes_in_use -= size
+	alloc.report.Releases++
+	alloc.report.RequestedBytes -= requested
+
+	buff := simulatedBuffer{size, data}
+	idx := sort.Search(len(alloc.available), func(i int) bool {
+		return !alloc.available[i].less(buff)
+	})
+	alloc.available = append(alloc.available, simulatedBuffer{})
+	copy(alloc.available[idx+1:], alloc.available[idx:])
+	alloc.available[idx] = buff
+
+	return nil
+}
+
+func (alloc *simulationAllocator) LiveHandles() ([]uintptr, error) {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	live := make([]uintptr, 0, len(alloc.requested))
+	for data := range alloc.requested {
+		live = append(live, uintptr(data))
+	}
+	return live, nil
+}
+
+func (alloc *simulationAllocator) FreeExtents() ([]uint64, []uint64, error) {
+	stats := alloc.Stats()
+
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	cached := make([]uint64, len(alloc.available))
+	for idx, buff := range alloc.available {
+		cached[idx] = buff.size
+	}
+	return cacheExtents(stats), cached, nil
+}
+
+func (alloc *simulationAllocator) Stats() Stats {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	return Stats{
+		MaxTotalSize:   alloc.max_total_size,
+		MaxBufferSize:  alloc.max_buffer_size,
+		BytesAllocated: alloc.bytes_allocated,
+		BytesInUse:     alloc.bytes_in_use,
+		NumBuffers:     uint64(len(alloc.buffers)),
+		NumAvailable:   uint64(len(alloc.available)),
+	}
+}
+
+// TraceEvent is one acquire or release from a recorded workload, for
+// Replay. Acquires have non-nil Sizes, releases have nil, and a release
+// is matched to the acquire with the same ID.
+type TraceEvent struct {
+	ID    uint64
+	Sizes []uint64
+}
+
+// Replay runs a recorded workload against pool, in order and from a
+// single goroutine, so the pool's ExhaustionPolicy doesn't apply: there's
+// nothing to wait for. Acquires that fail because the pool is full are
+// skipped, along with their release, which a simulation counts in its
+// report's Failures; anything else, including a trace that releases what
+// it never acquired, stops the replay with an error. Whatever the trace
+// leaves acquired is released at the end.
+func Replay(pool *Pool, trace []TraceEvent) error {
+	live := make(map[uint64]RBEntry)
+	failed := make(map[uint64]bool)
+	defer func() {
+		for _, entry := range live {
+			entry.Release()
+		}
+	}()
+
+	for idx, event := range trace {
+		if event.Sizes == nil {
+			entry, ok := live[event.ID]
+			if !ok && !failed[event.ID] {
+				return fmt.Errorf("trace event %d releases %d which isn't acquired", idx, event.ID)
+			}
+			delete(live, event.ID)
+			delete(failed, event.ID)
+			entry.Release()
+			continue
+		}
+
+		if _, ok := live[event.ID]; ok || failed[event.ID] {
+			return fmt.Errorf("trace event %d acquires %d which is already acquired", idx, event.ID)
+		}
+
+		entry, err := pool.AllocBuffers(event.Sizes, OnExhaustion(ExhaustionError))
+		if errors.Is(err, ErrNoBufferAvailable) {
+			failed[event.ID] = true
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("trace event %d: %w", idx, err)
+		}
+		live[event.ID] = entry
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package rustybuffer
+
+import "unsafe"
+
+// Without a way to reserve address space, regions come from the Go heap.
+// Nothing reads or writes them, and they're never freed, so they're as
+// good as reserved, though an access won't fault.
+func reserveSimulatedRegion(size int) (unsafe.Pointer, error) {
+	return unsafe.Pointer(unsafe.SliceData(make([]byte, size))), nil
+}
@@ -0,0 +1,205 @@
+package rustybuffer
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"unsafe"
+)
+
+func TestSimulationAllocator(t *testing.T) {
+	// Gigabytes, or megabytes where buffers that big can't be sliced.
+	var unit uint64 = 1 << 30
+	if strconv.IntSize == 32 {
+		unit = 1 << 20
+	}
+
+	pool := NewSimulationPool(SimulationConfig{
+		MaxTotalSize:  12 * unit,
+		MaxBufferSize: 4 * unit,
+		SizeClasses:   []uint64{1 * unit, 2 * unit, 4 * unit},
+	})
+
+	// 12GB, with no memory behind it.
+	var entries []RBEntry
+	for _, size := range []uint64{3 * unit, 3 * unit, 1 * unit} {
+		entry, err := pool.AllocBuffers([]uint64{size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	_, err := pool.AllocBuffers([]uint64{4 * unit})
+	if !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+
+	stats := pool.Stats()
+	if stats.BytesInUse != 9*unit || stats.NumBuffers != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// Releasing a 3GB request frees a 4GB buffer that a 2GB request reuses.
+	entries[0].Release()
+	entry, err := pool.AllocBuffers([]uint64{2 * unit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries[0] = entry
+	if stats = pool.Stats(); stats.BytesInUse != 9*unit || stats.NumBuffers != 3 {
+		t.Fatalf("unexpected stats after reuse: %+v", stats)
+	}
+
+	for idx := range entries {
+		entries[idx].Release()
+	}
+
+	report, ok := Simulation(pool.alloc)
+	if !ok {
+		t.Fatal("not a simulation")
+	}
+	expected := SimulationReport{
+		Acquires:           4,
+		Releases:           4,
+		Failures:           1,
+		PeakRequestedBytes: 7 * unit,
+		PeakBytesInUse:     9 * unit,
+		PeakBytesAllocated: 9 * unit,
+	}
+	if report != expected {
+		t.Fatalf("unexpected report:\n%+v\n%+v", report, expected)
+	}
+
+	if _, ok := Simulation(NewHeapAllocator(1, 1)); ok {
+		t.Fatal("the heap allocator isn't a simulation")
+	}
+}
+
+func TestSimulationBeyondAddressSpace(t *testing.T) {
+	if strconv.IntSize == 32 {
+		t.Skip("buffers this big can't be sliced on 32-bit platforms")
+	}
+
+	// 20PiB, in more buffers than one region has addresses for.
+	const count = 20000
+	pool := NewSimulationPool(SimulationConfig{
+		MaxTotalSize:  count << 40,
+		MaxBufferSize: 1 << 40,
+	})
+
+	entries := make([]RBEntry, 0, count)
+	defer func() {
+		for idx := range entries {
+			entries[idx].Release()
+		}
+	}()
+	for len(entries) < count {
+		entry, err := pool.AllocBuffers([]uint64{1 << 40})
+		if err != nil {
+			t.Fatalf("acquire %d: %v", len(entries), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if stats := pool.Stats(); stats.BytesInUse != count<<40 || stats.NumBuffers != count {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestSimulationEviction(t *testing.T) {
+	alloc := NewSimulationAllocator(SimulationConfig{
+		MaxTotalSize:  1 << 20,
+		MaxBufferSize: 1 << 20,
+	})
+
+	small, err := alloc.Acquire(1 << 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := alloc.Acquire(1 << 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alloc.Release(small)
+	alloc.Release(large)
+
+	// Neither cached buffer fits, so the largest goes to make room.
+	if _, err = alloc.Acquire(3 << 18); err != nil {
+		t.Fatal(err)
+	}
+	stats := alloc.Stats()
+	if stats.NumBuffers != 2 || stats.NumAvailable != 1 || stats.BytesAllocated != 3<<18+1<<10 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if report, _ := Simulation(alloc); report.Evictions != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	var bogus uint8
+	if err = alloc.Release(unsafe.Pointer(&bogus)); !errors.Is(err, ErrInvalidPointer) {
+		t.Fatalf("expected ErrInvalidPointer, got %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	pool := NewSimulationPool(SimulationConfig{
+		MaxTotalSize:  100,
+		MaxBufferSize: 100,
+	})
+
+	trace := []TraceEvent{
+		{ID: 1, Sizes: []uint64{40, 20}},
+		{ID: 2, Sizes: []uint64{30}},
+		{ID: 3, Sizes: []uint64{30}},
+		{ID: 2},
+		{ID: 3},
+		{ID: 4, Sizes: []uint64{40}},
+	}
+	if err := Replay(pool, trace); err != nil {
+		t.Fatal(err)
+	}
+
+	report, _ := Simulation(pool.alloc)
+	if report.Failures != 1 || report.Acquires != 3 || report.Releases != 3 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if report.PeakRequestedBytes != 100 || report.Evictions != 1 {
+		t.Fatalf("unexpected peak: %+v", report)
+	}
+
+	err := Replay(pool, []TraceEvent{{ID: 1}})
+	if err == nil {
+		t.Fatal("expected an error releasing something never acquired")
+	}
+	err = Replay(pool, []TraceEvent{{ID: 1, Sizes: []uint64{1}}, {ID: 1, Sizes: []uint64{1}}})
+	if err == nil {
+		t.Fatal("expected an error acquiring the same ID twice")
+	}
+	if stats := pool.Stats(); stats.BytesInUse != 0 {
+		t.Fatalf("replay left buffers acquired: %+v", stats)
+	}
+}
+
+func TestFreeOSMemorySimulation(t *testing.T) {
+	pool := NewSimulationPool(SimulationConfig{MaxTotalSize: 1 << 20, MaxBufferSize: 1 << 20})
+	if _, err := pool.AllocBuffers([]uint64{4096}); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := pool.AllocBuffers([]uint64{8192})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+
+	FreeOSMemory(pool)
+
+	stats := pool.Stats()
+	if stats.NumAvailable != 0 || stats.BytesAllocated != 4096 {
+		t.Fatalf("cache wasn't trimmed: %+v", stats)
+	}
+	report, _ := Simulation(pool.alloc)
+	if report.Evictions != 1 {
+		t.Fatalf("expected 1 eviction, got %d", report.Evictions)
+	}
+}
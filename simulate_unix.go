@@ -0,0 +1,21 @@
+//go:build unix
+
+package rustybuffer
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// reserveSimulatedRegion maps size bytes that can't be read or written, so
+// they take up address space but no memory.
+func reserveSimulatedRegion(size int) (unsafe.Pointer, error) {
+	mapping, err := syscall.Mmap(-1, 0, size,
+		syscall.PROT_NONE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, newError(codeAllocationFailed,
+			"reserving %d bytes of address space: %v", size, err)
+	}
+
+	return unsafe.Pointer(unsafe.SliceData(mapping)), nil
+}
@@ -0,0 +1,25 @@
+package rustybuffer
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procVirtualAlloc = syscall.NewLazyDLL("kernel32.dll").NewProc("VirtualAlloc")
+
+const (
+	memReserve   = 0x2000
+	pageNoAccess = 0x01
+)
+
+// reserveSimulatedRegion reserves size bytes that can't be read or
+// written, so they take up address space but no memory.
+func reserveSimulatedRegion(size int) (unsafe.Pointer, error) {
+	addr, _, err := procVirtualAlloc.Call(0, uintptr(size), memReserve, pageNoAccess)
+	if addr == 0 {
+		return nil, newError(codeAllocationFailed,
+			"reserving %d bytes of address space: %v", size, err)
+	}
+
+	return *(*unsafe.Pointer)(unsafe.Pointer(&addr)), nil
+}
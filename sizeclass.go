@@ -0,0 +1,169 @@
+package rustybuffer
+
+import (
+	"math/bits"
+	"sync"
+	"unsafe"
+)
+
+// WithSizeClassStats has the pool break its acquires down by size, for
+// Stats.SizeClasses and Stats.Recommend. Sizes are grouped into power of
+// two classes, each holding the requests bigger than the class below.
+func WithSizeClassStats() PoolOption {
+	return func(pool *Pool) {
+		pool.size_classes = &sizeClassRegistry{live: make(map[unsafe.Pointer]uint64)}
+	}
+}
+
+// SizeClassStats is what a pool has acquired in one size class, see
+// WithSizeClassStats.
+type SizeClassStats struct {
+	// The largest request in the class, and the largest one seen.
+	Size           uint64
+	LargestRequest uint64
+
+	// Entries acquired in the class, how many are live, and the most that
+	// have been live at once.
+	Acquires  uint64
+	InUse     uint64
+	PeakInUse uint64
+
+	// Bytes requested by the live entries.
+	BytesInUse uint64
+
+	// InUse as a fraction of PeakInUse: how much of what the class has
+	// needed it needs now.
+	Occupancy float64
+
+	// The fraction of the class's bytes that would be wasted if every
+	// request in it were rounded up to Size.
+	Fragmentation float64
+}
+
+type sizeClass struct {
+	acquires        uint64
+	in_use          uint64
+	peak_in_use     uint64
+	bytes_in_use    uint64
+	bytes_requested uint64
+	largest         uint64
+}
+
+type sizeClassRegistry struct {
+	mu      sync.Mutex
+	classes [64]sizeClass
+	live    map[unsafe.Pointer]uint64
+}
+
+// sizeClassIndex is the power of two class for size, which is at most
+// math.MaxInt.
+func sizeClassIndex(size uint64) int {
+	return bits.Len64(max(size, 1) - 1)
+}
+
+func (registry *sizeClassRegistry) track(data unsafe.Pointer, size uint64) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.live[data] = size
+	class := &registry.classes[sizeClassIndex(size)]
+	class.acquires++
+	class.in_use++
+	class.peak_in_use = max(class.peak_in_use, class.in_use)
+	class.bytes_in_use += size
+	class.bytes_requested += size
+	class.largest = max(class.largest, size)
+}
+
+func (registry *sizeClassRegistry) forget(data unsafe.Pointer) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	size, ok := registry.live[data]
+	if !ok {
+		return
+	}
+	delete(registry.live, data)
+
+	class := &registry.classes[sizeClassIndex(size)]
+	class.in_use--
+	class.bytes_in_use -= size
+}
+
+// stats returns the classes that have seen an acquire, or since reset
+// have entries live, smallest first.
+func (registry *sizeClassRegistry) stats() []SizeClassStats {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	return registry.statsLocked()
+}
+
+func (registry *sizeClassRegistry) statsLocked() []SizeClassStats {
+	var stats []SizeClassStats
+	for idx, class := range registry.classes {
+		if class.acquires == 0 && class.in_use == 0 {
+			continue
+		}
+		size := uint64(1) << idx
+		class_stats := SizeClassStats{
+			Size:           size,
+			LargestRequest: class.largest,
+			Acquires:       class.acquires,
+			InUse:          class.in_use,
+			PeakInUse:      class.peak_in_use,
+			BytesInUse:     class.bytes_in_use,
+			Occupancy:      float64(class.in_use) / float64(class.peak_in_use),
+		}
+		if class.acquires > 0 {
+			class_stats.Fragmentation = 1 - float64(class.bytes_requested)/(float64(class.acquires)*float64(size))
+		}
+		stats = append(stats, class_stats)
+	}
+	return stats
+}
+
+// reset starts the stats afresh, with the live entries as though each had
+// just been acquired bar counting the acquire.
+func (registry *sizeClassRegistry) reset() {
+	registry.classes = [64]sizeClass{}
+	for _, size := range registry.live {
+		class := &registry.classes[sizeClassIndex(size)]
+		class.in_use++
+		class.peak_in_use++
+		class.bytes_in_use += size
+		class.largest = max(class.largest, size)
+	}
+}
+
+// Recommended size class boundaries are rounded up to this.
+const sizeClassAlignment = 64
+
+// SizeClassRecommendation is a size class Recommend suggests and how many
+// buffers of it the workload has needed at once.
+type SizeClassRecommendation struct {
+	Size     uint64
+	Capacity int
+}
+
+// Recommend suggests size classes for the demand stats has seen, smallest
+// first, or nothing if it has no SizeClasses. Each of the observed classes
+// is shrunk to fit its largest request, rounded up to 64 bytes, with the
+// capacity to hold its peak. The sizes can be tried out with a simulation
+// (see SimulationConfig.SizeClasses), and the sizes and capacities warmed
+// up with Pool.Warm.
+func (stats Stats) Recommend() []SizeClassRecommendation {
+	var recommended []SizeClassRecommendation
+	for _, class := range stats.SizeClasses {
+		size := (class.LargestRequest + sizeClassAlignment - 1) &^ (sizeClassAlignment - 1)
+		size = max(size, sizeClassAlignment)
+
+		// The small classes all round up to the same one.
+		if last := len(recommended) - 1; last >= 0 && recommended[last].Size == size {
+			recommended[last].Capacity += int(class.PeakInUse)
+			continue
+		}
+		recommended = append(recommended, SizeClassRecommendation{size, int(class.PeakInUse)})
+	}
+	return recommended
+}
@@ -0,0 +1,57 @@
+package rustybuffer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSizeClassStats(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)), WithSizeClassStats())
+
+	var entries []RBEntry
+	for _, sizes := range [][]uint64{{10}, {20, 10}, {600}, {1000}, {1000}, {1024}} {
+		entry, err := pool.AllocBuffers(sizes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	entries[3].Release()
+	entries[4].Release()
+
+	stats := pool.Stats().SizeClasses
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 size classes, got %+v", stats)
+	}
+	expected := SizeClassStats{
+		Size:           1024,
+		LargestRequest: 1024,
+		Acquires:       4,
+		InUse:          2,
+		PeakInUse:      4,
+		BytesInUse:     1624,
+		Occupancy:      0.5,
+		Fragmentation:  1 - 3624.0/4096,
+	}
+	if stats[2] != expected {
+		t.Fatalf("expected %+v, got %+v", expected, stats[2])
+	}
+	if stats[0].Size != 16 || stats[1].Size != 32 || stats[1].Fragmentation != 2.0/32 {
+		t.Fatalf("unexpected small classes %+v", stats[:2])
+	}
+
+	recommended := pool.Stats().Recommend()
+	if !reflect.DeepEqual(recommended, []SizeClassRecommendation{{64, 2}, {1024, 4}}) {
+		t.Fatalf("unexpected recommendations %+v", recommended)
+	}
+
+	for _, entry := range entries {
+		entry.Release()
+	}
+	if stats := pool.Stats().SizeClasses; stats[2].InUse != 0 || stats[2].BytesInUse != 0 {
+		t.Fatalf("unexpected stats after releasing everything %+v", stats)
+	}
+	if NewPool(WithAllocator(NewHeapAllocator(1024, 1024))).Stats().Recommend() != nil {
+		t.Fatal("expected no recommendations without size class stats")
+	}
+}
@@ -0,0 +1,165 @@
+package rustybuffer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// WithSizeClassLearning has the pool learn its size classes from the
+// requests it sees: acquires are broken down by size, as for
+// WithSizeClassStats, over windows ended by LearnSizeClasses (or
+// LearnSizeClassesEvery), each of which yields the classes Stats.Recommend
+// would suggest for it. They're passed to report, or logged if it's nil,
+// and with apply set the pool is warmed for them (see Pool.Warm) too, so
+// its allocator holds what the last window needed ready for the next.
+//
+// Entries live when a window ends count towards the next one's demand, so
+// a long lived working set isn't forgotten for not being acquired again.
+func WithSizeClassLearning(apply bool, report func(LearnedSizeClasses)) PoolOption {
+	return func(pool *Pool) {
+		pool.learner = &sizeClassLearner{
+			apply:    apply,
+			report:   report,
+			registry: sizeClassRegistry{live: make(map[unsafe.Pointer]uint64)},
+			start:    time.Now(),
+		}
+	}
+}
+
+// LearnedSizeClasses is the size class configuration learnt over one
+// window, see WithSizeClassLearning.
+type LearnedSizeClasses struct {
+	Start time.Time
+	End   time.Time
+
+	// Acquires made in the window.
+	Acquires uint64
+
+	// The recommended classes, smallest first.
+	Classes []SizeClassRecommendation
+
+	// Whether the pool was warmed for them, and if that failed, why.
+	Applied  bool
+	ApplyErr error
+}
+
+// Sizes returns the classes' sizes, for SimulationConfig.SizeClasses.
+func (learned LearnedSizeClasses) Sizes() []uint64 {
+	sizes := make([]uint64, len(learned.Classes))
+	for idx, class := range learned.Classes {
+		sizes[idx] = class.Size
+	}
+	return sizes
+}
+
+// Capacities returns the classes' sizes and capacities, for Pool.Warm.
+func (learned LearnedSizeClasses) Capacities() map[uint64]int {
+	capacities := make(map[uint64]int, len(learned.Classes))
+	for _, class := range learned.Classes {
+		capacities[class.Size] += class.Capacity
+	}
+	return capacities
+}
+
+type sizeClassLearner struct {
+	apply  bool
+	report func(LearnedSizeClasses)
+
+	// Held while a window is ended, so two ending at once don't both
+	// warm the pool.
+	learning sync.Mutex
+
+	registry sizeClassRegistry
+	start    time.Time
+}
+
+// LearnSizeClasses ends the current learning window, returning what was
+// learnt over it after reporting it and, if the pool learns with apply
+// set, warming the pool for it. Only the buffers the classes need beyond
+// those already live in them are warmed. A pool too full to warm isn't an
+// error, but is reported in ApplyErr. Pools created without
+// WithSizeClassLearning have nothing to learn.
+func (pool *Pool) LearnSizeClasses() (LearnedSizeClasses, error) {
+	learner := pool.learner
+	if learner == nil {
+		return LearnedSizeClasses{}, errors.New("rustybuffer: learning size classes of a pool created without WithSizeClassLearning")
+	}
+
+	learner.learning.Lock()
+	defer learner.learning.Unlock()
+
+	registry := &learner.registry
+	registry.mu.Lock()
+	stats := registry.statsLocked()
+	learned := LearnedSizeClasses{Start: learner.start, End: time.Now()}
+	for _, class := range stats {
+		learned.Acquires += class.Acquires
+	}
+	registry.reset()
+	learner.start = learned.End
+	registry.mu.Unlock()
+
+	learned.Classes = Stats{SizeClasses: stats}.Recommend()
+	if learner.apply && len(learned.Classes) > 0 {
+		learned.Applied = true
+		learned.ApplyErr = pool.Warm(warmBeyondLive(stats, learned.Classes))
+	}
+
+	if learner.report != nil {
+		learner.report(learned)
+	} else {
+		log.Printf("rustybuffer: learnt size classes %v from %d acquires over %v",
+			learned.Classes, learned.Acquires, learned.End.Sub(learned.Start))
+		if learned.ApplyErr != nil {
+			log.Printf("rustybuffer: applying learnt size classes: %v", learned.ApplyErr)
+		}
+	}
+	return learned, nil
+}
+
+// warmBeyondLive returns how many buffers of each recommended class need
+// warming, given the entries live in the classes they were recommended
+// for, which are already the allocator's to cache once released.
+func warmBeyondLive(stats []SizeClassStats, classes []SizeClassRecommendation) map[uint64]int {
+	capacities := make(map[uint64]int, len(classes))
+	for _, class := range classes {
+		capacities[class.Size] = class.Capacity
+	}
+	sizes := make([]uint64, 0, len(classes))
+	for _, class := range classes {
+		sizes = append(sizes, class.Size)
+	}
+	for _, class := range stats {
+		idx := sort.Search(len(sizes), func(i int) bool { return sizes[i] >= class.LargestRequest })
+		if idx < len(sizes) {
+			capacities[sizes[idx]] -= int(class.InUse)
+		}
+	}
+	for size, capacity := range capacities {
+		if capacity <= 0 {
+			delete(capacities, size)
+		}
+	}
+	return capacities
+}
+
+// LearnSizeClassesEvery calls LearnSizeClasses every interval, until ctx
+// is done, for learning windows of that length.
+func (pool *Pool) LearnSizeClassesEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pool.LearnSizeClasses()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
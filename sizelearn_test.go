@@ -0,0 +1,127 @@
+package rustybuffer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLearnSizeClasses(t *testing.T) {
+	var reports []LearnedSizeClasses
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)),
+		WithSizeClassLearning(false, func(learned LearnedSizeClasses) { reports = append(reports, learned) }))
+
+	var entries []RBEntry
+	for _, size := range []uint64{10, 30, 600, 1000, 1000} {
+		entry, err := pool.AllocBuffers([]uint64{size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	entries[3].Release()
+	entries[4].Release()
+
+	learned, err := pool.LearnSizeClasses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if learned.Acquires != 5 || learned.Applied || len(reports) != 1 || !learned.End.After(learned.Start) {
+		t.Fatalf("unexpected learnt classes %+v", learned)
+	}
+	if !reflect.DeepEqual(learned.Classes, []SizeClassRecommendation{{64, 2}, {1024, 3}}) {
+		t.Fatalf("unexpected classes %+v", learned.Classes)
+	}
+	if !reflect.DeepEqual(learned.Sizes(), []uint64{64, 1024}) || !reflect.DeepEqual(learned.Capacities(), map[uint64]int{64: 2, 1024: 3}) {
+		t.Fatalf("unexpected sizes %v and capacities %v", learned.Sizes(), learned.Capacities())
+	}
+
+	// The next window starts with what's still live, and nothing else.
+	learned, err = pool.LearnSizeClasses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if learned.Acquires != 0 || !reflect.DeepEqual(learned.Classes, []SizeClassRecommendation{{64, 2}, {640, 1}}) {
+		t.Fatalf("unexpected second window %+v", learned)
+	}
+
+	for _, entry := range entries[:3] {
+		entry.Release()
+	}
+	if learned, _ := pool.LearnSizeClasses(); learned.Classes != nil {
+		t.Fatalf("expected nothing learnt from an idle window, got %+v", learned)
+	}
+
+	if _, err := NewPool(WithAllocator(NewHeapAllocator(1024, 1024))).LearnSizeClasses(); err == nil {
+		t.Fatal("expected an error learning without WithSizeClassLearning")
+	}
+}
+
+func TestLearnSizeClassesApply(t *testing.T) {
+	alloc := NewHeapAllocator(4096, 4096)
+	pool := NewPool(WithAllocator(alloc), WithSizeClassLearning(true, func(LearnedSizeClasses) {}))
+
+	live, err := pool.AllocBuffers([]uint64{1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Release()
+	for i := 0; i < 2; i++ {
+		entry, err := pool.AllocBuffers([]uint64{1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer entry.Release()
+	}
+	learned, err := pool.LearnSizeClasses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// All three are still live, so there's nothing more to warm.
+	if !learned.Applied || learned.ApplyErr != nil {
+		t.Fatalf("unexpected apply %+v", learned)
+	}
+	if stats := alloc.Stats(); stats.BytesInUse != 3000 {
+		t.Fatalf("expected warming to hand everything back, got %+v", stats)
+	}
+}
+
+func TestLearnSizeClassesApplyFull(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(2048, 2048)), WithSizeClassLearning(true, func(LearnedSizeClasses) {}))
+
+	entry, err := pool.AllocBuffers([]uint64{1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := pool.AllocBuffers([]uint64{1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+	other.Release()
+
+	// The two buffers of 1024 the window needed don't fit alongside it.
+	hog, err := pool.AllocBuffers([]uint64{1500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hog.Release()
+	learned, err := pool.LearnSizeClasses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !learned.Applied || learned.ApplyErr == nil {
+		t.Fatalf("expected warming a full pool to fail, got %+v", learned)
+	}
+}
+
+func TestWarmBeyondLive(t *testing.T) {
+	stats := []SizeClassStats{
+		{Size: 16, LargestRequest: 10, InUse: 1},
+		{Size: 32, LargestRequest: 30, InUse: 3},
+		{Size: 1024, LargestRequest: 1000, InUse: 1},
+	}
+	classes := []SizeClassRecommendation{{64, 3}, {1024, 4}}
+	if warm := warmBeyondLive(stats, classes); !reflect.DeepEqual(warm, map[uint64]int{1024: 3}) {
+		t.Fatalf("unexpected buffers to warm %v", warm)
+	}
+}
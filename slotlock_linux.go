@@ -0,0 +1,38 @@
+package rustybuffer
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// Open file description locks, which unlike the process locks F_SETLK
+// takes aren't all dropped when the process closes any descriptor of the
+// file, so separate mappings in one process don't interfere.
+const (
+	fOFDGetlk = 36
+	fOFDSetlk = 37
+)
+
+// lockSlot takes a shared lock on the byte at offset of file, held until
+// unlockSlot or the file is closed, which the kernel does for a process
+// that dies, reporting whether it could.
+func lockSlot(file *os.File, offset int64) bool {
+	lock := syscall.Flock_t{Type: syscall.F_RDLCK, Whence: io.SeekStart, Start: offset, Len: 1}
+	return syscall.FcntlFlock(file.Fd(), fOFDSetlk, &lock) == nil
+}
+
+func unlockSlot(file *os.File, offset int64) {
+	lock := syscall.Flock_t{Type: syscall.F_UNLCK, Whence: io.SeekStart, Start: offset, Len: 1}
+	syscall.FcntlFlock(file.Fd(), fOFDSetlk, &lock)
+}
+
+// slotLocked reports whether another descriptor of file holds a lock on
+// the byte at offset, and whether it could tell.
+func slotLocked(file *os.File, offset int64) (bool, bool) {
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart, Start: offset, Len: 1}
+	if err := syscall.FcntlFlock(file.Fd(), fOFDGetlk, &lock); err != nil {
+		return false, false
+	}
+	return lock.Type != syscall.F_UNLCK, true
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package rustybuffer
+
+import "os"
+
+// Open file description locks are only implemented on Linux, elsewhere
+// whether a slot's process is alive is up to processAlive.
+func lockSlot(file *os.File, offset int64) bool {
+	return false
+}
+
+func unlockSlot(file *os.File, offset int64) {}
+
+func slotLocked(file *os.File, offset int64) (bool, bool) {
+	return false, false
+}
@@ -0,0 +1,154 @@
+package rustybuffer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Snapshot is a pool's Stats at a point in time along with running totals
+// of its acquires, so two snapshots show what happened in between, see
+// Delta.
+type Snapshot struct {
+	Time  time.Time
+	Stats Stats
+
+	// Entries acquired with AllocBuffers, and their bytes.
+	Acquires      uint64
+	BytesAcquired uint64
+
+	// AllocBuffers calls that failed, for whatever reason.
+	AcquireFailures uint64
+}
+
+// Snapshot takes a snapshot of the pool.
+func (pool *Pool) Snapshot() Snapshot {
+	return Snapshot{
+		Time:            time.Now(),
+		Stats:           pool.Stats(),
+		Acquires:        pool.acquires.Load(),
+		BytesAcquired:   pool.bytes_acquired.Load(),
+		AcquireFailures: pool.acquire_failures.Load(),
+	}
+}
+
+// SnapshotDelta is what changed between two snapshots: the totals that
+// only ever go up as how much they went up by, and the rest as how much
+// they grew, or shrank if negative.
+type SnapshotDelta struct {
+	Elapsed time.Duration
+
+	Acquires        uint64
+	BytesAcquired   uint64
+	AcquireFailures uint64
+	WipesVerified   uint64
+	WipesFailed     uint64
+
+	BytesAllocated int64
+	BytesInUse     int64
+	NumBuffers     int64
+	NumAvailable   int64
+	FallbackBytes  int64
+	SpilledBytes   int64
+}
+
+// Delta is what changed from snapshot a to the later snapshot b, of the
+// same pool.
+func Delta(a Snapshot, b Snapshot) SnapshotDelta {
+	return SnapshotDelta{
+		Elapsed:         b.Time.Sub(a.Time),
+		Acquires:        b.Acquires - a.Acquires,
+		BytesAcquired:   b.BytesAcquired - a.BytesAcquired,
+		AcquireFailures: b.AcquireFailures - a.AcquireFailures,
+		WipesVerified:   b.Stats.WipesVerified - a.Stats.WipesVerified,
+		WipesFailed:     b.Stats.WipesFailed - a.Stats.WipesFailed,
+		BytesAllocated:  int64(b.Stats.BytesAllocated - a.Stats.BytesAllocated),
+		BytesInUse:      int64(b.Stats.BytesInUse - a.Stats.BytesInUse),
+		NumBuffers:      int64(b.Stats.NumBuffers - a.Stats.NumBuffers),
+		NumAvailable:    int64(b.Stats.NumAvailable - a.Stats.NumAvailable),
+		FallbackBytes:   int64(b.Stats.FallbackBytes - a.Stats.FallbackBytes),
+		SpilledBytes:    int64(b.Stats.SpilledBytes - a.Stats.SpilledBytes),
+	}
+}
+
+// SnapshotRing keeps a pool's most recent snapshots in memory, taken by
+// Record or every so often by RecordEvery, so an operator can see what's
+// changed lately without external monitoring. It's safe for concurrent
+// use.
+type SnapshotRing struct {
+	pool *Pool
+
+	mu        sync.Mutex
+	snapshots []Snapshot
+	next      int
+	full      bool
+}
+
+// NewSnapshotRing returns a ring keeping pool's last keep snapshots.
+func NewSnapshotRing(pool *Pool, keep int) *SnapshotRing {
+	return &SnapshotRing{
+		pool:      pool,
+		snapshots: make([]Snapshot, max(keep, 1)),
+	}
+}
+
+// Record takes a snapshot, replacing the oldest if the ring is full.
+func (ring *SnapshotRing) Record() {
+	snapshot := ring.pool.Snapshot()
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	ring.snapshots[ring.next] = snapshot
+	ring.next = (ring.next + 1) % len(ring.snapshots)
+	ring.full = ring.full || ring.next == 0
+}
+
+// RecordEvery calls Record now and every interval until ctx is done.
+func (ring *SnapshotRing) RecordEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ring.Record()
+	for {
+		select {
+		case <-ticker.C:
+			ring.Record()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Snapshots returns the snapshots kept, oldest first.
+func (ring *SnapshotRing) Snapshots() []Snapshot {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	if !ring.full {
+		return append([]Snapshot(nil), ring.snapshots[:ring.next]...)
+	}
+	return append(append([]Snapshot(nil), ring.snapshots[ring.next:]...), ring.snapshots[:ring.next]...)
+}
+
+// Since is what's changed in the pool over the last window: from the
+// oldest snapshot kept that's no older than window to a snapshot taken
+// now. If there's no such snapshot it's measured from the newest one
+// there is, covering more than window, and if the ring's empty nothing
+// has changed.
+func (ring *SnapshotRing) Since(window time.Duration) SnapshotDelta {
+	now := ring.pool.Snapshot()
+	snapshots := ring.Snapshots()
+	if len(snapshots) == 0 {
+		return Delta(now, now)
+	}
+
+	from := snapshots[len(snapshots)-1]
+	for _, snapshot := range snapshots {
+		if now.Time.Sub(snapshot.Time) <= window {
+			from = snapshot
+			break
+		}
+	}
+	return Delta(from, now)
+}
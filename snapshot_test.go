@@ -0,0 +1,87 @@
+package rustybuffer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSnapshotDelta(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	before := pool.Snapshot()
+	entry, err := pool.AllocBuffers([]uint64{100, 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := pool.AllocBuffers([]uint64{50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done.Release()
+	if _, err := pool.AllocBuffers([]uint64{2000}); err == nil {
+		t.Fatal("expected an oversized acquire to fail")
+	}
+	after := pool.Snapshot()
+
+	delta := Delta(before, after)
+	expected := SnapshotDelta{
+		Elapsed:         after.Time.Sub(before.Time),
+		Acquires:        2,
+		BytesAcquired:   350,
+		AcquireFailures: 1,
+		BytesAllocated:  300,
+		BytesInUse:      300,
+		NumBuffers:      1,
+	}
+	if delta != expected {
+		t.Fatalf("expected %+v, got %+v", expected, delta)
+	}
+
+	entry.Release()
+	if delta := Delta(after, pool.Snapshot()); delta.BytesInUse != -300 || delta.Acquires != 0 {
+		t.Fatalf("expected the release to shrink the pool: %+v", delta)
+	}
+}
+
+func TestSnapshotRing(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+	ring := NewSnapshotRing(pool, 3)
+	if delta := ring.Since(time.Minute); delta.Acquires != 0 {
+		t.Fatalf("expected nothing to have changed: %+v", delta)
+	}
+
+	for idx := 0; idx < 5; idx++ {
+		ring.Record()
+		entry, err := pool.AllocBuffers([]uint64{10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Release()
+	}
+	snapshots := ring.Snapshots()
+	if len(snapshots) != 3 || snapshots[0].Acquires != 2 || snapshots[2].Acquires != 4 {
+		t.Fatalf("expected the last 3 snapshots, oldest first: %+v", snapshots)
+	}
+	if delta := ring.Since(time.Minute); delta.Acquires != 3 || delta.BytesAcquired != 30 {
+		t.Fatalf("expected 3 acquires since the oldest snapshot: %+v", delta)
+	}
+	if delta := ring.Since(0); delta.Acquires != 1 {
+		t.Fatalf("expected 1 acquire since the newest snapshot: %+v", delta)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recorded := make(chan struct{})
+	go func() {
+		ring.RecordEvery(ctx, time.Millisecond)
+		close(recorded)
+	}()
+	for len(ring.Snapshots()) == 3 && ring.Snapshots()[2].Acquires == 4 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-recorded
+	if snapshots := ring.Snapshots(); snapshots[2].Acquires != 5 {
+		t.Fatalf("expected RecordEvery to record: %+v", snapshots)
+	}
+}
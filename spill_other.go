@@ -0,0 +1,34 @@
+//go:build !unix
+
+package rustybuffer
+
+import (
+	"math"
+	"runtime"
+	"unsafe"
+)
+
+// spillAllocator would back buffers with temporary files but memory
+// mapping them is only implemented on unix platforms, so every Acquire
+// fails.
+type spillAllocator struct{}
+
+func newSpillAllocator(dir string) *spillAllocator {
+	return &spillAllocator{}
+}
+
+func (alloc *spillAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	return nil, newError(codeAllocationFailed,
+		"spilling to disk is not supported on %s", runtime.GOOS)
+}
+
+func (alloc *spillAllocator) Release(data unsafe.Pointer) error {
+	return newError(codeInvalidPointer, "%p was not spilled to disk", data)
+}
+
+func (alloc *spillAllocator) Stats() Stats {
+	return Stats{
+		MaxTotalSize:  math.MaxUint64,
+		MaxBufferSize: math.MaxInt,
+	}
+}
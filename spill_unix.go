@@ -0,0 +1,117 @@
+//go:build unix
+
+package rustybuffer
+
+import (
+	"math"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// spillAllocator backs each buffer with its own temporary file, mapped
+// into memory. The file is unlinked as soon as it's mapped, so the space
+// goes back to the filesystem once the mapping is gone, even if the
+// process dies.
+type spillAllocator struct {
+	dir string
+
+	mu           sync.Mutex
+	bytes_in_use uint64
+	mappings     map[unsafe.Pointer][]byte
+}
+
+// newSpillAllocator creates files in dir, or os.TempDir() if it's empty.
+func newSpillAllocator(dir string) *spillAllocator {
+	return &spillAllocator{
+		dir:      dir,
+		mappings: make(map[unsafe.Pointer][]byte),
+	}
+}
+
+func (alloc *spillAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	if size > math.MaxInt {
+		return nil, newError(codeBufferTooLarge,
+			"requested %d bytes but can only map %d", size, uint64(math.MaxInt))
+	}
+
+	file, err := os.CreateTemp(alloc.dir, "rustybuffer-spill-*")
+	if err != nil {
+		return nil, newError(codeAllocationFailed,
+			"creating a spill file for %d bytes: %v", size, err)
+	}
+	defer file.Close()
+
+	if err = os.Remove(file.Name()); err != nil {
+		return nil, newError(codeAllocationFailed,
+			"unlinking spill file %s: %v", file.Name(), err)
+	}
+
+	// A freshly truncated file reads as zeros, as Acquire promises.
+	if err = file.Truncate(int64(size)); err != nil {
+		return nil, newError(codeAllocationFailed,
+			"growing spill file to %d bytes: %v", size, err)
+	}
+
+	mapping, err := syscall.Mmap(int(file.Fd()), 0, int(size),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, newError(codeAllocationFailed,
+			"mapping %d byte spill file: %v", size, err)
+	}
+
+	data := unsafe.Pointer(unsafe.SliceData(mapping))
+
+	alloc.mu.Lock()
+	alloc.mappings[data] = mapping
+	alloc.bytes_in_use += size
+	alloc.mu.Unlock()
+
+	return data, nil
+}
+
+func (alloc *spillAllocator) Release(data unsafe.Pointer) error {
+	alloc.mu.Lock()
+	mapping, ok := alloc.mappings[data]
+	delete(alloc.mappings, data)
+	alloc.bytes_in_use -= uint64(len(mapping))
+	alloc.mu.Unlock()
+
+	if !ok {
+		return newError(codeInvalidPointer,
+			"%p was not spilled to disk", data)
+	}
+
+	if err := syscall.Munmap(mapping); err != nil {
+		return newError(codeInvalidPointer,
+			"unmapping spill file at %p: %v", data, err)
+	}
+
+	return nil
+}
+
+func (alloc *spillAllocator) LiveHandles() ([]uintptr, error) {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	live := make([]uintptr, 0, len(alloc.mappings))
+	for data := range alloc.mappings {
+		live = append(live, uintptr(data))
+	}
+	return live, nil
+}
+
+func (alloc *spillAllocator) Stats() Stats {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	return Stats{
+		MaxTotalSize:   math.MaxUint64,
+		MaxBufferSize:  math.MaxInt,
+		BytesAllocated: alloc.bytes_in_use,
+		BytesInUse:     alloc.bytes_in_use,
+		NumBuffers:     uint64(len(alloc.mappings)),
+		NumAvailable:   0,
+	}
+}
@@ -0,0 +1,75 @@
+package rustybuffer
+
+import "io"
+
+// BlobReader is the read side of an SQLite incremental blob handle, as
+// provided by zombiezen.com/go/sqlite, crawshaw.io/sqlite and
+// ncruces/go-sqlite3.
+type BlobReader interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// ReadBlob reads an entire blob into pooled memory in chunk_size pieces.
+// Each chunk is one of the returned entry's Buffers (the last one may be
+// shorter), so a blob is never materialized on the Go heap and never read
+// with a single huge sqlite3_blob_read call. A blob that ends before its
+// Size says fails with io.ErrUnexpectedEOF.
+func ReadBlob(blob BlobReader, chunk_size int) (RBEntry, error) {
+	if chunk_size <= 0 {
+		panic("rustybuffer: chunk_size must be positive")
+	}
+
+	if blob.Size() < 0 {
+		return RBEntry{}, ErrBufferTooLarge
+	}
+
+	remaining := uint64(blob.Size())
+	sizes := make([]uint64, 0, remaining/uint64(chunk_size)+1)
+	for remaining > 0 {
+		size := min(remaining, uint64(chunk_size))
+		sizes = append(sizes, size)
+		remaining -= size
+	}
+
+	if len(sizes) == 0 {
+		return RBEntry{}, nil
+	}
+
+	entry, err := allocBuffers(sizes)
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	// ReaderAt allows io.EOF alongside a full read at the end, but a blob
+	// that turns out shorter than its Size has to fail.
+	var offset int64 = 0
+	for _, buf := range entry.Buffers {
+		n, err := blob.ReadAt(buf, offset)
+		if n == len(buf) {
+			err = nil
+		} else if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			entry.Release()
+			return RBEntry{}, err
+		}
+		offset += int64(len(buf))
+	}
+
+	return entry, nil
+}
+
+// WriteBlob writes the entry's buffers back to back into blob starting at
+// offset, one sqlite3_blob_write per buffer. Incremental blobs can't grow so
+// the blob must already be large enough (e.g., created with zeroblob(N)).
+func WriteBlob(blob io.WriterAt, offset int64, entry RBEntry) error {
+	for _, buf := range entry.Buffers {
+		if _, err := blob.WriteAt(buf, offset); err != nil {
+			return err
+		}
+		offset += int64(len(buf))
+	}
+	return nil
+}
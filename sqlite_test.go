@@ -0,0 +1,85 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type fakeBlob struct {
+	data  []byte
+	reads int
+}
+
+func (blob *fakeBlob) ReadAt(p []byte, off int64) (int, error) {
+	blob.reads += 1
+	return copy(p, blob.data[off:]), nil
+}
+
+// shortBlob says it's bigger than it is, as a blob changed underneath its
+// handle might.
+type shortBlob struct {
+	fakeBlob
+}
+
+func (blob *shortBlob) ReadAt(p []byte, off int64) (int, error) {
+	n, _ := blob.fakeBlob.ReadAt(p, off)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (blob *shortBlob) Size() int64 {
+	return int64(len(blob.data)) + 100
+}
+
+func (blob *fakeBlob) WriteAt(p []byte, off int64) (int, error) {
+	return copy(blob.data[off:], p), nil
+}
+
+func (blob *fakeBlob) Size() int64 {
+	return int64(len(blob.data))
+}
+
+func TestReadWriteBlob(t *testing.T) {
+	Configure(64*1024*1024, 1024*1024)
+
+	data := make([]byte, 10_000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	src := &fakeBlob{data: data}
+
+	entry, err := ReadBlob(src, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	if len(entry.Buffers) != 3 || len(entry.Buffers[2]) != 10_000-8192 {
+		t.Fatalf("unexpected chunking: %d buffers", len(entry.Buffers))
+	}
+	if src.reads != 3 {
+		t.Fatalf("expected 3 reads, got %d", src.reads)
+	}
+
+	dst := &fakeBlob{data: make([]byte, len(data))}
+	if err := WriteBlob(dst, 0, entry); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.data, data) {
+		t.Fatal("blob round trip failed")
+	}
+
+	empty, err := ReadBlob(&fakeBlob{}, 4096)
+	if err != nil || empty.data != nil {
+		t.Fatal("expected an empty entry for an empty blob")
+	}
+
+	short := &shortBlob{fakeBlob{data: data}}
+	if _, err := ReadBlob(short, 4096); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package rustybuffer
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// SubPool creates a pool that acquires from this one's allocator, its
+// interceptors and all, but holds at most max_bytes at once, so a share of
+// the pool can be handed to a subsystem without it being able to take the
+// rest. What a sub-pool holds counts against both its own cap and its
+// parent's limits, and sub-pools of sub-pools nest, for budgets from the
+// service down to each worker out of the one allocator. The sub-pool has
+// its own stats and policies, which opts set as for NewPool, and name is
+// given in the errors of acquires over its cap.
+//
+// Like Use, SubPool must be called before the parent is used by more than
+// one goroutine, and interceptors added to the parent afterwards don't
+// apply to it.
+func (pool *Pool) SubPool(name string, max_bytes uint64, opts ...PoolOption) *Pool {
+	alloc := &subPoolAllocator{
+		name:      name,
+		parent:    pool.alloc,
+		max_bytes: max_bytes,
+		sizes:     make(map[unsafe.Pointer]uint64),
+	}
+	return NewPool(append([]PoolOption{WithAllocator(alloc)}, opts...)...)
+}
+
+// subPoolAllocator caps what's acquired through it from its parent pool's
+// allocator.
+type subPoolAllocator struct {
+	name      string
+	parent    Allocator
+	max_bytes uint64
+
+	mu           sync.Mutex
+	bytes_in_use uint64
+	sizes        map[unsafe.Pointer]uint64
+}
+
+func (alloc *subPoolAllocator) Acquire(size uint64) (unsafe.Pointer, error) {
+	// The bytes are reserved up front so racing acquires can't both fit.
+	alloc.mu.Lock()
+	if size > alloc.max_bytes-alloc.bytes_in_use {
+		in_use := alloc.bytes_in_use
+		alloc.mu.Unlock()
+		return nil, newError(codeNoBufferAvailable,
+			"requested %d bytes with %d of sub-pool %q's %d in use",
+			size, in_use, alloc.name, alloc.max_bytes)
+	}
+	alloc.bytes_in_use += size
+	alloc.mu.Unlock()
+
+	data, err := alloc.parent.Acquire(size)
+
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	if err != nil {
+		alloc.bytes_in_use -= size
+		return nil, err
+	}
+	alloc.sizes[data] = size
+	return data, nil
+}
+
+func (alloc *subPoolAllocator) Release(data unsafe.Pointer) error {
+	// It's forgotten first, as once the parent has it back it can be
+	// handed to another acquire.
+	alloc.mu.Lock()
+	size, ok := alloc.sizes[data]
+	delete(alloc.sizes, data)
+	alloc.mu.Unlock()
+	if !ok {
+		return newError(codeInvalidPointer,
+			"%p was not acquired from sub-pool %q", data, alloc.name)
+	}
+
+	err := alloc.parent.Release(data)
+
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	if err != nil {
+		alloc.sizes[data] = size
+		return err
+	}
+	alloc.bytes_in_use -= size
+	return nil
+}
+
+// Stats describes what the sub-pool holds, which it doesn't cache, under
+// its own cap and its parent's buffer size limit.
+func (alloc *subPoolAllocator) Stats() Stats {
+	parent := alloc.parent.Stats()
+
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	return Stats{
+		MaxTotalSize:   alloc.max_bytes,
+		MaxBufferSize:  parent.MaxBufferSize,
+		BytesAllocated: alloc.bytes_in_use,
+		BytesInUse:     alloc.bytes_in_use,
+		NumBuffers:     uint64(len(alloc.sizes)),
+	}
+}
+
+func (alloc *subPoolAllocator) LiveHandles() ([]uintptr, error) {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	live := make([]uintptr, 0, len(alloc.sizes))
+	for data := range alloc.sizes {
+		live = append(live, uintptr(data))
+	}
+	return live, nil
+}
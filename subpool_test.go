@@ -0,0 +1,89 @@
+package rustybuffer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSubPoolCaps(t *testing.T) {
+	alloc := NewHeapAllocator(1000, 500)
+	parent := NewPool(WithAllocator(alloc))
+	service := parent.SubPool("service", 600)
+	worker := service.SubPool("worker", 300)
+
+	entry, err := worker.AllocBuffers([]uint64{200, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	// The worker's at its cap.
+	_, err = worker.AllocBuffers([]uint64{1})
+	if !errors.Is(err, ErrNoBufferAvailable) || !strings.Contains(err.Error(), `sub-pool "worker"`) {
+		t.Fatalf("expected the worker's cap, got %v", err)
+	}
+
+	// Which counts against the service's.
+	_, err = service.AllocBuffers([]uint64{301})
+	if !errors.Is(err, ErrNoBufferAvailable) || !strings.Contains(err.Error(), `sub-pool "service"`) {
+		t.Fatalf("expected the service's cap, got %v", err)
+	}
+	other, err := service.AllocBuffers([]uint64{300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Release()
+
+	// And the parent's allocator's.
+	_, err = parent.AllocBuffers([]uint64{401})
+	if !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected the parent's limit, got %v", err)
+	}
+
+	if stats := worker.Stats(); stats.MaxTotalSize != 300 || stats.BytesInUse != 300 || stats.NumBuffers != 1 || stats.MaxBufferSize != 500 {
+		t.Fatalf("unexpected worker stats: %+v", stats)
+	}
+	if stats := service.Stats(); stats.BytesInUse != 600 || stats.NumBuffers != 2 {
+		t.Fatalf("unexpected service stats: %+v", stats)
+	}
+	if stats := parent.Stats(); stats.BytesInUse != 600 {
+		t.Fatalf("unexpected parent stats: %+v", stats)
+	}
+}
+
+func TestSubPoolRelease(t *testing.T) {
+	alloc := NewHeapAllocator(1000, 1000)
+	parent := NewPool(WithAllocator(alloc))
+	child := parent.SubPool("child", 100)
+
+	for i := 0; i < 3; i++ {
+		entry, err := child.AllocBuffers([]uint64{100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Release()
+	}
+	if stats := child.Stats(); stats.BytesInUse != 0 || alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if snapshot := child.Snapshot(); snapshot.Acquires != 3 || parent.Snapshot().Acquires != 0 {
+		t.Fatalf("expected the acquires counted by the child, got %+v", snapshot)
+	}
+}
+
+func TestSubPoolPolicies(t *testing.T) {
+	parent := NewPool(WithAllocator(NewHeapAllocator(1000, 1000)))
+	child := parent.SubPool("child", 100, WithExhaustionPolicy(ExhaustionHeap))
+
+	// Past its cap the child falls back to the Go heap, as the parent
+	// wouldn't.
+	entry, err := child.AllocBuffers([]uint64{200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	if stats := child.Stats(); stats.FallbackBytes != 200 || stats.BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,99 @@
+package rustybuffer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+)
+
+// ErrTainted is returned, wrapped, when a tainted entry is passed to
+// something that hands its memory outside the process's Go code, see
+// WithTaint.
+var ErrTainted = errors.New("rustybuffer: tainted entry")
+
+// WithTaint marks the acquired entry as holding untrusted data, network
+// input say, for the reason given. A tainted entry can't be handed over to
+// C (ExportC), other processes (Export) or untrusted code (ReadOnlyView)
+// until it's been validated and Sanitize called, or wiped with Clear:
+// those fail with ErrTainted and the violation is reported, see
+// WithTaintViolations. It enforces a data flow policy that's otherwise
+// hard to check in a large codebase.
+func WithTaint(reason string) AcquireOption {
+	return func(opts *acquireOptions) {
+		opts.taint = &reason
+	}
+}
+
+// TaintViolation is a tainted entry being passed somewhere it mustn't go
+// before it's sanitized.
+type TaintViolation struct {
+	Handle Handle
+
+	// The reason given to WithTaint.
+	Reason string
+
+	// What the entry was passed to, e.g., "ExportC".
+	Operation string
+
+	// The function, file and line that passed it, the first one outside
+	// of this package.
+	Site string
+}
+
+// WithTaintViolations has the pool call report with every taint violation
+// (see WithTaint) rather than logging it.
+func WithTaintViolations(report func(TaintViolation)) PoolOption {
+	return func(pool *Pool) {
+		pool.taint_report = report
+	}
+}
+
+// Tainted reports whether the entry was acquired WithTaint and hasn't
+// been sanitized or cleared since.
+func (entry *RBEntry) Tainted() bool {
+	return entry.state != nil && entry.state.taint.Load() != nil
+}
+
+// Sanitize declares the entry's contents validated, lifting the taint from
+// WithTaint for every copy of the entry.
+func (entry *RBEntry) Sanitize() {
+	if entry.state != nil {
+		entry.state.taint.Store(nil)
+	}
+}
+
+// Clear zeroes every buffer in the entry, which also lifts any taint.
+func (entry *RBEntry) Clear() {
+	entry.Fill(0)
+	entry.Sanitize()
+}
+
+// checkTaint fails, reporting the violation, if the entry is tainted.
+func (entry *RBEntry) checkTaint(operation string) error {
+	if entry.state == nil {
+		return nil
+	}
+	reason := entry.state.taint.Load()
+	if reason == nil {
+		return nil
+	}
+
+	callers := make([]uintptr, 16)
+	callers = callers[:runtime.Callers(2, callers)]
+	violation := TaintViolation{
+		Handle:    entry.state.handle,
+		Reason:    *reason,
+		Operation: operation,
+		Site:      callersSite(callers),
+	}
+	if report := entry.state.pool.taint_report; report != nil {
+		report(violation)
+	} else {
+		log.Printf("rustybuffer: tainted entry %s (%s) passed to %s at %s",
+			violation.Handle, violation.Reason, operation, violation.Site)
+	}
+
+	return fmt.Errorf("%w: %s of entry %s (%s) before it was sanitized",
+		ErrTainted, operation, violation.Handle, violation.Reason)
+}
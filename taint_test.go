@@ -0,0 +1,82 @@
+package rustybuffer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTaint(t *testing.T) {
+	var violations []TaintViolation
+	pool := NewPool(
+		WithAllocator(NewHeapAllocator(1<<20, 1<<20)),
+		WithTaintViolations(func(violation TaintViolation) {
+			violations = append(violations, violation)
+		}),
+	)
+
+	entry, err := pool.AllocBuffers([]uint64{16}, WithTaint("network input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	copy(entry.Buffers[0], "untrusted")
+	copied := entry
+	if !copied.Tainted() {
+		t.Fatal("expected the entry to be tainted")
+	}
+
+	if _, err := entry.ExportC(); !errors.Is(err, ErrTainted) {
+		t.Fatalf("expected ErrTainted, got %v", err)
+	}
+	if len(violations) != 1 || violations[0].Handle != entry.Handle() ||
+		violations[0].Reason != "network input" || violations[0].Operation != "ExportC" ||
+		!strings.Contains(violations[0].Site, "TestTaint") {
+		t.Fatalf("unexpected violations %+v", violations)
+	}
+
+	// Sanitizing any copy lifts the taint from them all.
+	copied.Sanitize()
+	if entry.Tainted() {
+		t.Fatal("expected the entry to be sanitized")
+	}
+	exported, err := entry.ExportC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exportedEntryRelease(exported.Token) != 0 {
+		t.Fatal("releasing the exported entry failed")
+	}
+	if len(violations) != 1 {
+		t.Fatalf("unexpected violations %+v", violations)
+	}
+}
+
+func TestTaintClear(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+	entry, err := pool.AllocBuffers([]uint64{16, 4}, WithTaint("network input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	entry.Fill(0xFF)
+
+	entry.Clear()
+	if entry.Tainted() {
+		t.Fatal("expected Clear to lift the taint")
+	}
+	for _, buffer := range entry.Buffers {
+		if !allZero(buffer) {
+			t.Fatal("expected Clear to zero the entry")
+		}
+	}
+
+	untainted, err := pool.AllocBuffers([]uint64{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer untainted.Release()
+	if untainted.Tainted() {
+		t.Fatal("unexpected taint")
+	}
+}
@@ -0,0 +1,390 @@
+package rustybuffer
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrTierClosed is returned by a Tier, and its entries, once it's been
+// closed.
+var ErrTierClosed = errors.New("rustybuffer: tier closed")
+
+// TierOptions configure NewTier.
+type TierOptions struct {
+	// Where hot entries live, the default pool if nil.
+	Pool *Pool
+
+	// The directory the cold tier's file is created in, os.TempDir() if
+	// it's empty.
+	Dir string
+
+	// How many bytes of entries to keep in the pool. Acquiring or faulting
+	// in an entry past this migrates the least recently used entries to
+	// the cold tier until it's back under, unless they're in use. Zero
+	// means no limit, leaving migration to Demote.
+	HotBytes uint64
+}
+
+// Tier keeps a working set of entries in a pool and the rest in a file,
+// migrating entries that haven't been used lately to the file and faulting
+// them back in when they're next used, so the pool only holds the hot set.
+// Entries are used through TieredEntry.View, which keeps them in the pool
+// until the view is done with; the entries themselves are never handed
+// out. Tiers are safe for concurrent use, though migrations hold a lock
+// for as long as the file IO takes.
+type Tier struct {
+	pool      *Pool
+	hot_limit uint64
+
+	mu   sync.Mutex
+	file *os.File
+
+	// Hot entries, the most recently used at the front.
+	lru *list.List
+
+	// The file's free space sorted by offset, and its size.
+	free      []span
+	file_size uint64
+
+	stats TierStats
+}
+
+// TierStats describes what a Tier holds and has moved.
+type TierStats struct {
+	HotEntries  uint64
+	HotBytes    uint64
+	ColdEntries uint64
+	ColdBytes   uint64
+
+	// Entries migrated to the file, and faulted back in.
+	Demotions  uint64
+	Promotions uint64
+}
+
+// TieredEntry is an entry managed by a Tier, in its pool or its file.
+type TieredEntry struct {
+	tier  *Tier
+	sizes []uint64
+	size  uint64
+
+	// The entry while it's hot, or where it is in the file while it's
+	// cold.
+	entry  RBEntry
+	offset uint64
+	hot    bool
+
+	// Its place in the tier's LRU while hot, when it was last used and
+	// how many views of it are outstanding.
+	elem     *list.Element
+	used     time.Time
+	in_use   int
+	released bool
+}
+
+// NewTier creates a tier, and its file, which is removed by Close.
+func NewTier(opts TierOptions) (*Tier, error) {
+	pool := opts.Pool
+	if pool == nil {
+		pool = defaultPool
+	}
+	file, err := os.CreateTemp(opts.Dir, "rustybuffer-tier-*")
+	if err != nil {
+		return nil, fmt.Errorf("rustybuffer: creating a tier file: %w", err)
+	}
+	return &Tier{pool: pool, hot_limit: opts.HotBytes, file: file, lru: list.New()}, nil
+}
+
+// Alloc acquires an entry like AllocBuffers, hot, migrating others to make
+// room for it.
+func (tier *Tier) Alloc(sizes []uint64) (*TieredEntry, error) {
+	size, err := totalSize(sizes)
+	if err != nil {
+		return nil, err
+	}
+
+	tier.mu.Lock()
+	defer tier.mu.Unlock()
+
+	if tier.file == nil {
+		return nil, ErrTierClosed
+	}
+	if err := tier.makeRoom(size); err != nil {
+		return nil, err
+	}
+	entry, err := tier.pool.AllocBuffers(sizes)
+	if err != nil {
+		return nil, err
+	}
+
+	tiered := &TieredEntry{tier: tier, sizes: append([]uint64(nil), sizes...), size: size}
+	tier.promoted(tiered, entry)
+	return tiered, nil
+}
+
+// makeRoom demotes the least recently used entries not in use until size
+// more bytes fit under the hot limit, or there's nothing left to demote.
+// It's called with the lock held.
+func (tier *Tier) makeRoom(size uint64) error {
+	if tier.hot_limit == 0 {
+		return nil
+	}
+	for elem := tier.lru.Back(); elem != nil && tier.stats.HotBytes+size > tier.hot_limit; {
+		tiered := elem.Value.(*TieredEntry)
+		elem = elem.Prev()
+		if tiered.in_use > 0 {
+			continue
+		}
+		if err := tier.demote(tiered); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promoted makes entry the hot copy of tiered, with the lock held.
+func (tier *Tier) promoted(tiered *TieredEntry, entry RBEntry) {
+	tiered.entry = entry
+	tiered.hot = true
+	tiered.used = time.Now()
+	tiered.elem = tier.lru.PushFront(tiered)
+	tier.stats.HotEntries++
+	tier.stats.HotBytes += tiered.size
+}
+
+// demote writes tiered to the file and releases its entry, with the lock
+// held. If the write fails it stays hot.
+func (tier *Tier) demote(tiered *TieredEntry) error {
+	offset := tier.reserve(tiered.size)
+	at := int64(offset)
+	for _, buffer := range tiered.entry.Buffers {
+		if _, err := tier.file.WriteAt(buffer, at); err != nil {
+			tier.unreserve(offset, tiered.size)
+			return fmt.Errorf("rustybuffer: demoting an entry to the tier file: %w", err)
+		}
+		at += int64(len(buffer))
+	}
+
+	tier.lru.Remove(tiered.elem)
+	tiered.elem = nil
+	tiered.entry.Release()
+	tiered.offset = offset
+	tiered.hot = false
+	tier.stats.HotEntries--
+	tier.stats.HotBytes -= tiered.size
+	tier.stats.ColdEntries++
+	tier.stats.ColdBytes += tiered.size
+	tier.stats.Demotions++
+	return nil
+}
+
+// promote reads a cold entry back into the pool, with the lock held.
+func (tier *Tier) promote(tiered *TieredEntry) error {
+	if err := tier.makeRoom(tiered.size); err != nil {
+		return err
+	}
+	entry, err := tier.pool.AllocBuffers(tiered.sizes)
+	if err != nil {
+		return err
+	}
+	at := int64(tiered.offset)
+	for _, buffer := range entry.Buffers {
+		if _, err := tier.file.ReadAt(buffer, at); err != nil {
+			entry.Release()
+			return fmt.Errorf("rustybuffer: faulting an entry in from the tier file: %w", err)
+		}
+		at += int64(len(buffer))
+	}
+
+	tier.unreserve(tiered.offset, tiered.size)
+	tier.stats.ColdEntries--
+	tier.stats.ColdBytes -= tiered.size
+	tier.stats.Promotions++
+	tier.promoted(tiered, entry)
+	return nil
+}
+
+// reserve finds size bytes of the file for a demoted entry, the first
+// hole big enough or else the end, with the lock held.
+func (tier *Tier) reserve(size uint64) uint64 {
+	for idx, free := range tier.free {
+		if free.size >= size {
+			tier.free[idx] = span{free.offset + size, free.size - size}
+			if tier.free[idx].size == 0 {
+				tier.free = append(tier.free[:idx], tier.free[idx+1:]...)
+			}
+			return free.offset
+		}
+	}
+	offset := tier.file_size
+	tier.file_size += size
+	return offset
+}
+
+// unreserve gives size bytes at offset back as a hole, merging it with its
+// neighbours, with the lock held.
+func (tier *Tier) unreserve(offset uint64, size uint64) {
+	if size == 0 {
+		return
+	}
+	idx := sort.Search(len(tier.free), func(i int) bool { return tier.free[i].offset > offset })
+	tier.free = append(tier.free, span{})
+	copy(tier.free[idx+1:], tier.free[idx:])
+	tier.free[idx] = span{offset, size}
+
+	if idx+1 < len(tier.free) && offset+size == tier.free[idx+1].offset {
+		tier.free[idx].size += tier.free[idx+1].size
+		tier.free = append(tier.free[:idx+1], tier.free[idx+2:]...)
+	}
+	if idx > 0 && tier.free[idx-1].offset+tier.free[idx-1].size == offset {
+		tier.free[idx-1].size += tier.free[idx].size
+		tier.free = append(tier.free[:idx], tier.free[idx+1:]...)
+	}
+}
+
+// Demote migrates every hot entry that hasn't been used for idle, and
+// isn't in use, to the file, returning how many it moved.
+func (tier *Tier) Demote(idle time.Duration) (int, error) {
+	tier.mu.Lock()
+	defer tier.mu.Unlock()
+
+	if tier.file == nil {
+		return 0, ErrTierClosed
+	}
+	demoted := 0
+	cutoff := time.Now().Add(-idle)
+	for elem := tier.lru.Back(); elem != nil; {
+		tiered := elem.Value.(*TieredEntry)
+		elem = elem.Prev()
+		if !tiered.used.Before(cutoff) {
+			break
+		}
+		if tiered.in_use > 0 {
+			continue
+		}
+		if err := tier.demote(tiered); err != nil {
+			return demoted, err
+		}
+		demoted++
+	}
+	return demoted, nil
+}
+
+// Stats returns what the tier holds and has moved.
+func (tier *Tier) Stats() TierStats {
+	tier.mu.Lock()
+	defer tier.mu.Unlock()
+
+	return tier.stats
+}
+
+// Close releases every entry, hot or cold, and removes the file. The tier
+// and its entries can't be used afterwards.
+func (tier *Tier) Close() error {
+	tier.mu.Lock()
+	defer tier.mu.Unlock()
+
+	if tier.file == nil {
+		return nil
+	}
+	for elem := tier.lru.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*TieredEntry).entry.Release()
+	}
+	tier.lru.Init()
+	tier.stats = TierStats{}
+
+	err := tier.file.Close()
+	if remove_err := os.Remove(tier.file.Name()); err == nil {
+		err = remove_err
+	}
+	tier.file = nil
+	return err
+}
+
+// fault makes tiered hot, faulting it in if need be, and marks it used,
+// with the lock held.
+func (tiered *TieredEntry) fault() error {
+	tier := tiered.tier
+	if tier.file == nil {
+		return ErrTierClosed
+	}
+	if tiered.released {
+		return errors.New("rustybuffer: use of a released tiered entry")
+	}
+	if !tiered.hot {
+		return tier.promote(tiered)
+	}
+	tiered.used = time.Now()
+	tier.lru.MoveToFront(tiered.elem)
+	return nil
+}
+
+// Touch marks the entry as used, faulting it in if it's cold, so it's
+// among the last to be migrated to the file.
+func (tiered *TieredEntry) Touch() error {
+	tiered.tier.mu.Lock()
+	defer tiered.tier.mu.Unlock()
+
+	return tiered.fault()
+}
+
+// View faults the entry in if it's cold, marks it used and returns a view
+// of its idx'th buffer, which stays valid, the entry in the pool, until
+// done is called. Calling done more than once does nothing.
+func (tiered *TieredEntry) View(idx int) (View, func(), error) {
+	tier := tiered.tier
+	tier.mu.Lock()
+	defer tier.mu.Unlock()
+
+	if err := tiered.fault(); err != nil {
+		return View{}, nil, err
+	}
+	view := tiered.entry.View(idx)
+	tiered.in_use++
+
+	var once sync.Once
+	done := func() {
+		once.Do(func() {
+			tier.mu.Lock()
+			defer tier.mu.Unlock()
+			tiered.in_use--
+		})
+	}
+	return view, done, nil
+}
+
+// Hot reports whether the entry is in the pool rather than the file.
+func (tiered *TieredEntry) Hot() bool {
+	tiered.tier.mu.Lock()
+	defer tiered.tier.mu.Unlock()
+
+	return tiered.hot
+}
+
+// Release gives the entry's memory back to the pool, or its space in the
+// file back to the tier. Releasing it more than once does nothing, and it
+// mustn't be released while a view of it is in use.
+func (tiered *TieredEntry) Release() {
+	tier := tiered.tier
+	tier.mu.Lock()
+	defer tier.mu.Unlock()
+
+	if tiered.released || tier.file == nil {
+		return
+	}
+	tiered.released = true
+	if tiered.hot {
+		tier.lru.Remove(tiered.elem)
+		tiered.entry.Release()
+		tier.stats.HotEntries--
+		tier.stats.HotBytes -= tiered.size
+		return
+	}
+	tier.unreserve(tiered.offset, tiered.size)
+	tier.stats.ColdEntries--
+	tier.stats.ColdBytes -= tiered.size
+}
@@ -0,0 +1,140 @@
+package rustybuffer
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTier(t *testing.T) {
+	alloc := NewHeapAllocator(1<<20, 1<<20)
+	tier, err := NewTier(TierOptions{
+		Pool:     NewPool(WithAllocator(alloc)),
+		Dir:      t.TempDir(),
+		HotBytes: 300,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tier.Close()
+
+	var entries []*TieredEntry
+	for idx := 0; idx < 3; idx++ {
+		entry, err := tier.Alloc([]uint64{50, 50})
+		if err != nil {
+			t.Fatal(err)
+		}
+		view, done, err := entry.View(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		view.Bytes()[0] = byte('a' + idx)
+		done()
+		entries = append(entries, entry)
+	}
+
+	// Using the first entry makes the second the least recently used.
+	if err := entries[0].Touch(); err != nil {
+		t.Fatal(err)
+	}
+	fourth, err := tier.Alloc([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fourth.Release()
+	if entries[1].Hot() || !entries[0].Hot() || !entries[2].Hot() {
+		t.Fatal("expected the least recently used entry to be demoted")
+	}
+	expected := TierStats{HotEntries: 3, HotBytes: 300, ColdEntries: 1, ColdBytes: 100, Demotions: 1}
+	if stats := tier.Stats(); stats != expected || alloc.Stats().BytesInUse != 300 {
+		t.Fatalf("expected %+v, got %+v and %+v", expected, stats, alloc.Stats())
+	}
+
+	// Viewing it faults it back in, demoting the next least recently used.
+	view, done, err := entries[1].View(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Bytes()[0] != 'b' || !entries[1].Hot() || entries[2].Hot() {
+		t.Fatalf("unexpected fault in: %q", view.Bytes())
+	}
+
+	// An entry in use isn't demoted, even if it's the oldest.
+	time.Sleep(time.Millisecond)
+	if demoted, err := tier.Demote(0); err != nil || demoted != 2 || !entries[1].Hot() {
+		t.Fatalf("expected 2 entries demoted, got %d: %v", demoted, err)
+	}
+	done()
+	done()
+	if demoted, err := tier.Demote(0); err != nil || demoted != 1 {
+		t.Fatalf("expected 1 entry demoted, got %d: %v", demoted, err)
+	}
+
+	for idx, entry := range entries {
+		view, done, err := entry.View(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if view.Bytes()[0] != byte('a'+idx) {
+			t.Fatalf("entry %d came back as %q", idx, view.Bytes())
+		}
+		done()
+	}
+
+	entries[0].Release()
+	entries[0].Release()
+	if _, _, err := entries[0].View(0); err == nil {
+		t.Fatal("expected viewing a released entry to fail")
+	}
+
+	if err := tier.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("expected Close to release everything, got %+v", alloc.Stats())
+	}
+	if _, err := tier.Alloc([]uint64{1}); !errors.Is(err, ErrTierClosed) {
+		t.Fatalf("expected ErrTierClosed, got %v", err)
+	}
+	if err := entries[1].Touch(); !errors.Is(err, ErrTierClosed) {
+		t.Fatalf("expected ErrTierClosed, got %v", err)
+	}
+}
+
+func TestTierReusesFileSpace(t *testing.T) {
+	tier, err := NewTier(TierOptions{Pool: NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20))), Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tier.Close()
+
+	var entries []*TieredEntry
+	for idx := 0; idx < 3; idx++ {
+		entry, err := tier.Alloc([]uint64{64})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	tier.Demote(-time.Hour)
+	entries[0].Release()
+	entries[1].Touch()
+	if len(tier.free) != 1 || tier.free[0] != (span{0, 128}) || tier.file_size != 192 {
+		t.Fatalf("expected the holes to merge, got %+v of %d", tier.free, tier.file_size)
+	}
+
+	entry, err := tier.Alloc([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	// The oldest goes first, into the start of the hole, leaving the rest
+	// too small for the new entry.
+	tier.Demote(-time.Hour)
+	if entries[1].offset != 0 || entry.offset != 192 || tier.file_size != 292 ||
+		len(tier.free) != 1 || tier.free[0] != (span{64, 64}) {
+		t.Fatalf("unexpected placement at %d and %d, leaving %+v of %d",
+			entries[1].offset, entry.offset, tier.free, tier.file_size)
+	}
+}
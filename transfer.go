@@ -0,0 +1,65 @@
+package rustybuffer
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrTransferRedeemed is returned when redeeming an EntryTransfer that's
+// already been redeemed.
+var ErrTransferRedeemed = errors.New("rustybuffer: transfer already redeemed")
+
+// EntryTransfer hands an entry from one goroutine to another, see
+// RBEntry.Transfer.
+type EntryTransfer struct {
+	mu       sync.Mutex
+	entry    RBEntry
+	redeemed bool
+}
+
+// Transfer gives the entry up so that another goroutine can take it over
+// with Redeem, e.g., after sending the transfer down a channel. The
+// entry is cleared, as if released, so the sender can't carry on using it
+// by mistake, though other copies of it aren't.
+//
+// Redeeming moves the entry to the redeeming goroutine for the pool's
+// GoroutineLimit, and the transfer's lock orders everything the sender
+// did to the entry before everything the receiver does after, for the
+// race detector as much as for the memory model. None of that is true of
+// a copy of the entry sent some other way.
+func (entry *RBEntry) Transfer() *EntryTransfer {
+	transfer := &EntryTransfer{entry: *entry}
+	entry.data = nil
+	entry.Buffers = make([][]uint8, 0)
+	return transfer
+}
+
+// Redeem returns the transferred entry, once. It fails if the entry has
+// been released in the meantime, or if taking it would put the goroutine
+// over the pool's GoroutineLimit, in which case the transfer can still be
+// redeemed by another goroutine.
+func (transfer *EntryTransfer) Redeem() (RBEntry, error) {
+	transfer.mu.Lock()
+	defer transfer.mu.Unlock()
+
+	if transfer.redeemed {
+		return RBEntry{}, ErrTransferRedeemed
+	}
+
+	state := transfer.entry.state
+	if state != nil && state.released.Load() {
+		return RBEntry{}, errors.New("rustybuffer: transferred entry was released")
+	}
+	if state != nil && state.pool.goroutines != nil {
+		err := state.pool.goroutines.transfer(transfer.entry.data, goroutineID())
+		if err != nil {
+			return RBEntry{}, err
+		}
+	}
+
+	transfer.redeemed = true
+	entry := transfer.entry
+	transfer.entry = RBEntry{}
+
+	return entry, nil
+}
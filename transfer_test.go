@@ -0,0 +1,87 @@
+package rustybuffer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransfer(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)),
+		WithGoroutineLimit(GoroutineLimit{MaxEntries: 1}))
+
+	entry, err := pool.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := entry.data
+	entry.Buffers[0][0] = 42
+
+	transfers := make(chan *EntryTransfer)
+	received := make(chan RBEntry)
+	go func() {
+		entry, err := (<-transfers).Redeem()
+		if err != nil {
+			t.Error(err)
+		}
+		received <- entry
+	}()
+
+	transfers <- entry.Transfer()
+	if entry.data != nil || len(entry.Buffers) != 0 {
+		t.Fatal("the sender's entry wasn't cleared")
+	}
+	got := <-received
+	if got.data != data || got.Buffers[0][0] != 42 {
+		t.Fatalf("unexpected entry: %v", got)
+	}
+
+	// The entry now counts against the other goroutine, so this one can
+	// acquire another.
+	other, err := pool.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// And at its limit it can't redeem another transfer until it's
+	// released what it has.
+	transfer := got.Transfer()
+	if _, err := transfer.Redeem(); !errors.Is(err, ErrGoroutineLimit) {
+		t.Fatalf("expected ErrGoroutineLimit, got %v", err)
+	}
+	other.Release()
+	got, err = transfer.Redeem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transfer.Redeem(); !errors.Is(err, ErrTransferRedeemed) {
+		t.Fatalf("expected ErrTransferRedeemed, got %v", err)
+	}
+
+	got.Release()
+	if len(pool.goroutines.held) != 0 || len(pool.goroutines.owners) != 0 {
+		t.Fatalf("still tracking %v and %v", pool.goroutines.held, pool.goroutines.owners)
+	}
+}
+
+func TestTransferReleased(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1<<20, 1<<20)))
+
+	entry, err := pool.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := entry
+	transfer := entry.Transfer()
+
+	// Releasing the transferred entry is a no-op, but releasing another
+	// copy isn't.
+	entry.Release()
+	if pool.Stats().BytesInUse != 100 {
+		t.Fatalf("unexpected stats: %+v", pool.Stats())
+	}
+	copied.Release()
+
+	if _, err := transfer.Redeem(); err == nil {
+		t.Fatal("expected redeeming a released entry to fail")
+	}
+}
@@ -0,0 +1,102 @@
+package rustybuffer
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// TransformStep is one stage of RBEntry.Transform: the transform, by
+// name, and its argument.
+type TransformStep struct {
+	Name string
+	Arg  uint64
+}
+
+// Below this many bytes in total transforming in Go is quicker than
+// calling into the Rust library.
+const transformNativeThreshold = 64 * 1024
+
+// Transforms lists the transforms RBEntry.Transform can apply. They're the
+// Rust library's, or the pure Go port's when it can't. Both have:
+//
+//   - "crc32c": the CRC32C of the memory, which is left alone.
+//   - "xxh64": the xxHash64 of the memory seeded with Arg, likewise.
+//   - "xor": XOR the memory with the 8 little endian bytes of Arg,
+//     repeated, e.g., to mask or unmask a WebSocket payload with its
+//     4 byte key twice over.
+//   - "fill": set every byte of the memory to the low byte of Arg.
+func Transforms() []string {
+	return transformNames()
+}
+
+// Transform applies each of steps in turn to the entry's buffers, in place
+// and as if they were one, returning each step's result (a checksum, say,
+// or zero for steps that only change the memory). Large entries go
+// through every stage in a single call to the Rust library rather than
+// a round trip through Go per stage. Nothing is changed if a step names a
+// transform that doesn't exist.
+func (entry *RBEntry) Transform(steps ...TransformStep) ([]uint64, error) {
+	var size uint64 = 0
+	for _, buffer := range entry.Buffers {
+		size += uint64(len(buffer))
+	}
+
+	shadow := entry.state.shadowing()
+	defer shadow.wrote(entry.Buffers...)
+
+	return transformBuffers(entry.Buffers, steps, size)
+}
+
+// The pure Go transforms, in the order the Rust library lists them.
+var goTransformOrder = []string{"crc32c", "xxh64", "xor", "fill"}
+
+var goTransforms = map[string]func(buffers [][]byte, arg uint64) uint64{
+	"crc32c": func(buffers [][]byte, arg uint64) uint64 {
+		return goChecksum(ChecksumCRC32C, buffers)
+	},
+	"xxh64": func(buffers [][]byte, arg uint64) uint64 {
+		var state xxh64State
+		state.reset(arg)
+		for _, buffer := range buffers {
+			state.update(buffer)
+		}
+		return state.digest()
+	},
+	"xor": func(buffers [][]byte, arg uint64) uint64 {
+		var key [8]byte
+		binary.LittleEndian.PutUint64(key[:], arg)
+		offset := 0
+		for _, buffer := range buffers {
+			for idx := range buffer {
+				buffer[idx] ^= key[offset%8]
+				offset++
+			}
+		}
+		return 0
+	},
+	"fill": func(buffers [][]byte, arg uint64) uint64 {
+		for _, buffer := range buffers {
+			goFill(buffer, byte(arg))
+		}
+		return 0
+	},
+}
+
+func goTransformNames() []string {
+	return append([]string(nil), goTransformOrder...)
+}
+
+// goTransformBuffers is Transform in pure Go.
+func goTransformBuffers(buffers [][]byte, steps []TransformStep) ([]uint64, error) {
+	for _, step := range steps {
+		if _, ok := goTransforms[step.Name]; !ok {
+			return nil, fmt.Errorf("rustybuffer: unknown transform %q", step.Name)
+		}
+	}
+
+	results := make([]uint64, len(steps))
+	for idx, step := range steps {
+		results[idx] = goTransforms[step.Name](buffers, step.Arg)
+	}
+	return results, nil
+}
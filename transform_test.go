@@ -0,0 +1,83 @@
+package rustybuffer
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestTransforms(t *testing.T) {
+	names := Transforms()
+	if !reflect.DeepEqual(names, goTransformOrder) {
+		t.Fatalf("expected %v, got %v", goTransformOrder, names)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	// Small enough to stay in Go and big enough for the library.
+	for _, size := range []uint64{1000, 3 * transformNativeThreshold} {
+		entry, err := NewPool().AllocBuffers([]uint64{size / 3, size - size/3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer entry.Release()
+		for _, buffer := range entry.Buffers {
+			for idx := range buffer {
+				buffer[idx] = byte(idx * 7)
+			}
+		}
+		original := bytes.Join(entry.Buffers, nil)
+		crc, _ := entry.Checksum(ChecksumCRC32C)
+
+		const key = 0x0807060504030201
+		results, err := entry.Transform(
+			TransformStep{Name: "crc32c"},
+			TransformStep{Name: "xor", Arg: key},
+			TransformStep{Name: "xxh64", Arg: 42},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results[0] != crc || results[1] != 0 {
+			t.Fatalf("%d bytes: unexpected results %v", size, results)
+		}
+
+		// Transforms carry on across buffers as if they were one.
+		masked := bytes.Join(entry.Buffers, nil)
+		for idx := range masked {
+			if masked[idx] != original[idx]^byte(idx%8+1) {
+				t.Fatalf("%d bytes: byte %d wasn't masked", size, idx)
+			}
+		}
+		expected, _ := goTransformBuffers([][]byte{masked}, []TransformStep{{Name: "xxh64", Arg: 42}})
+		if results[2] != expected[0] {
+			t.Fatalf("%d bytes: expected xxh64 %#x, got %#x", size, expected[0], results[2])
+		}
+
+		if _, err := entry.Transform(TransformStep{Name: "xor", Arg: key}); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(bytes.Join(entry.Buffers, nil), original) {
+			t.Fatalf("%d bytes: unmasking didn't restore the data", size)
+		}
+
+		if _, err := entry.Transform(
+			TransformStep{Name: "fill", Arg: 0xAA},
+			TransformStep{Name: "decrypt"},
+		); err == nil {
+			t.Fatalf("%d bytes: expected an unknown transform to fail", size)
+		}
+		if !bytes.Equal(bytes.Join(entry.Buffers, nil), original) {
+			t.Fatalf("%d bytes: a failed transform changed the data", size)
+		}
+
+		if _, err := entry.Transform(TransformStep{Name: "fill", Arg: 0x1AA}); err != nil {
+			t.Fatal(err)
+		}
+		for _, buffer := range entry.Buffers {
+			if bytes.Count(buffer, []byte{0xAA}) != len(buffer) {
+				t.Fatalf("%d bytes: fill didn't fill", size)
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package rustybuffer
+
+import (
+	"runtime/debug"
+)
+
+// FreeOSMemory gives the memory the pools have cached for reuse back to the
+// OS now, rather than waiting for it to be evicted, then does the same for
+// the Go heap with debug.FreeOSMemory. It's for operators who want an
+// explicit lever after a burst, say, and returns the bytes the allocators
+// freed, not counting the Go heap.
+//
+// The Rust library's cache backs every pool that uses it, so it's always
+// trimmed, once, whichever pools are given. Other allocators are trimmed if
+// they cache anything and know how to let it go. Buffers still in use are
+// left alone, and the pools carry on as normal afterwards, only with their
+// next acquires allocating afresh.
+func FreeOSMemory(pools ...*Pool) uint64 {
+	bytes_freed := rustAllocator{}.Trim()
+	for _, pool := range pools {
+		if _, rust := pool.alloc.(rustAllocator); rust {
+			continue
+		}
+		if trim, ok := pool.alloc.(trimmer); ok {
+			bytes_freed += trim.Trim()
+		}
+	}
+
+	debug.FreeOSMemory()
+
+	return bytes_freed
+}
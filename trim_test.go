@@ -0,0 +1,37 @@
+package rustybuffer
+
+import (
+	"testing"
+)
+
+func TestFreeOSMemory(t *testing.T) {
+	if info, err := LibraryInfo(); err != nil || !info.Has(CapabilityTrim) {
+		t.Skip("the library can't trim")
+	}
+
+	pool := NewPool()
+	entry, err := pool.AllocBuffers([]uint64{64 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+
+	if stats := pool.Stats(); stats.NumAvailable == 0 {
+		t.Fatal("expected the released buffer to be cached")
+	}
+
+	if freed := FreeOSMemory(pool, NewPool()); freed < 64*1024 {
+		t.Fatalf("only freed %d bytes", freed)
+	}
+	stats := pool.Stats()
+	if stats.NumAvailable != 0 || stats.BytesAllocated != stats.BytesInUse {
+		t.Fatalf("cache wasn't trimmed: %+v", stats)
+	}
+
+	// The pool carries on as normal.
+	entry, err = pool.AllocBuffers([]uint64{1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Release()
+}
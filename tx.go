@@ -0,0 +1,103 @@
+package rustybuffer
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrTxDone is returned by a Tx that's already been committed or rolled
+// back.
+var ErrTxDone = errors.New("rustybuffer: transaction already committed or rolled back")
+
+// Tx stages several acquires so that they're all kept or all released,
+// which saves multi-step setup from leaking what it acquired before a
+// later step failed:
+//
+//	tx := pool.Begin()
+//	defer tx.Rollback()
+//	header, err := tx.AllocBuffers(header_sizes)
+//	...
+//	body, err := tx.AllocBuffers(body_sizes)
+//	...
+//	tx.Commit()
+//
+// Staged entries can be used straight away, but belong to the Tx until
+// it's committed. Txs are safe for concurrent use.
+type Tx struct {
+	pool *Pool
+
+	mu      sync.Mutex
+	entries []RBEntry
+	done    bool
+}
+
+// Begin starts a Tx on the pool.
+func (pool *Pool) Begin() *Tx {
+	return &Tx{pool: pool}
+}
+
+// AllocBuffers is Pool.AllocBuffers, staging the entry in the Tx. A
+// failed acquire leaves the Tx as it was, to be rolled back or carried on
+// with.
+func (tx *Tx) AllocBuffers(sizes []uint64, opts ...AcquireOption) (RBEntry, error) {
+	if tx.isDone() {
+		return RBEntry{}, ErrTxDone
+	}
+
+	// The acquire can block, so it's made without the lock, which means
+	// the Tx can be finished in the meantime.
+	entry, err := tx.pool.AllocBuffers(sizes, opts...)
+	if err != nil {
+		return RBEntry{}, err
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		entry.Release()
+		return RBEntry{}, ErrTxDone
+	}
+	tx.entries = append(tx.entries, entry)
+
+	return entry, nil
+}
+
+func (tx *Tx) isDone() bool {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	return tx.done
+}
+
+// Commit hands the staged entries over to the caller, who's responsible
+// for releasing them from then on, and returns them in the order they were
+// acquired.
+func (tx *Tx) Commit() ([]RBEntry, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	tx.done = true
+
+	entries := tx.entries
+	tx.entries = nil
+	return entries, nil
+}
+
+// Rollback releases every staged entry, newest first. Rolling back a Tx
+// that's done is a no-op, so it can always be deferred.
+func (tx *Tx) Rollback() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return
+	}
+	tx.done = true
+
+	releaseGroup(tx.entries)
+	tx.entries = nil
+}
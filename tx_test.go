@@ -0,0 +1,93 @@
+package rustybuffer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTxCommit(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc))
+
+	tx := pool.Begin()
+	defer tx.Rollback()
+
+	first, err := tx.AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tx.AllocBuffers([]uint64{200, 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed acquire doesn't spoil the rest.
+	if _, err := tx.AllocBuffers([]uint64{2048}); !errors.Is(err, ErrBufferTooLarge) {
+		t.Fatalf("expected ErrBufferTooLarge, got %v", err)
+	}
+
+	entries, err := tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].data != first.data || entries[1].data != second.data {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+
+	// Rolling back after committing leaves the entries alone.
+	tx.Rollback()
+	if alloc.Stats().BytesInUse != 600 {
+		t.Fatalf("unexpected stats: %+v", alloc.Stats())
+	}
+
+	if _, err := tx.AllocBuffers([]uint64{1}); !errors.Is(err, ErrTxDone) {
+		t.Fatalf("expected ErrTxDone, got %v", err)
+	}
+	if _, err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Fatalf("expected ErrTxDone, got %v", err)
+	}
+
+	for _, entry := range entries {
+		entry.Release()
+	}
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", alloc.Stats())
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	alloc := NewHeapAllocator(1024, 1024)
+	pool := NewPool(WithAllocator(alloc))
+
+	setup := func() error {
+		tx := pool.Begin()
+		defer tx.Rollback()
+
+		if _, err := tx.AllocBuffers([]uint64{500}); err != nil {
+			return err
+		}
+		if _, err := tx.AllocBuffers([]uint64{500}); err != nil {
+			return err
+		}
+		// The pool is full.
+		if _, err := tx.AllocBuffers([]uint64{500}); err != nil {
+			return err
+		}
+
+		_, err := tx.Commit()
+		return err
+	}
+
+	if err := setup(); !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+	if alloc.Stats().BytesInUse != 0 {
+		t.Fatalf("rollback didn't release everything: %+v", alloc.Stats())
+	}
+
+	tx := pool.Begin()
+	tx.Rollback()
+	if _, err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Fatalf("expected ErrTxDone, got %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package rustybuffer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// WithUsageAccounting has the pool account for how much memory each tag
+// (see WithTag) holds and for how long, in byte-seconds, for charging its
+// users for what they actually use rather than what they might: a
+// tenant holding 1GiB for a second is charged as much as one holding 1MiB
+// for 17 minutes. Acquires without a tag are accounted to "".
+//
+// Usage is accounted over periods ended by ReportUsage (or
+// ReportUsageEvery), each of which is passed to report, or logged if it's
+// nil. Entries live when a period ends are charged to the next one from
+// then on.
+func WithUsageAccounting(report func(UsageReport)) PoolOption {
+	return func(pool *Pool) {
+		pool.usage = &usageAccountant{
+			report: report,
+			live:   make(map[unsafe.Pointer]usageEntry),
+			tags:   make(map[string]*tagUsage),
+			start:  time.Now(),
+		}
+	}
+}
+
+// UsageReport is what each tag used over one accounting period, see
+// WithUsageAccounting.
+type UsageReport struct {
+	Start time.Time
+	End   time.Time
+
+	// Every tag that held memory in the period, most used first.
+	Tags []TagUsage
+}
+
+// TagUsage is what one tag used over an accounting period.
+type TagUsage struct {
+	Tag string
+
+	// The bytes the tag's entries held, integrated over the period.
+	ByteSeconds float64
+
+	// ByteSeconds spread over the whole period: what the tag held on
+	// average.
+	AverageBytes float64
+
+	// The most the tag held at once in the period, and what it held as
+	// it ended.
+	PeakBytes  uint64
+	BytesInUse uint64
+
+	// Entries the tag acquired in the period.
+	Acquires uint64
+}
+
+// Total returns the byte-seconds used by every tag in the report.
+func (report UsageReport) Total() float64 {
+	total := 0.0
+	for _, usage := range report.Tags {
+		total += usage.ByteSeconds
+	}
+	return total
+}
+
+type usageEntry struct {
+	tag  string
+	size uint64
+}
+
+// tagUsage is one tag's running account, brought up to date whenever what
+// it holds changes.
+type tagUsage struct {
+	byte_seconds float64
+	bytes_in_use uint64
+	peak_bytes   uint64
+	acquires     uint64
+	updated      time.Time
+}
+
+// advance charges the tag for what it's held since it was last updated.
+func (usage *tagUsage) advance(now time.Time) {
+	if elapsed := now.Sub(usage.updated); elapsed > 0 {
+		usage.byte_seconds += float64(usage.bytes_in_use) * elapsed.Seconds()
+	}
+	usage.updated = now
+}
+
+type usageAccountant struct {
+	report func(UsageReport)
+
+	mu    sync.Mutex
+	live  map[unsafe.Pointer]usageEntry
+	tags  map[string]*tagUsage
+	start time.Time
+}
+
+func (accountant *usageAccountant) track(data unsafe.Pointer, size uint64, tag string, now time.Time) {
+	accountant.mu.Lock()
+	defer accountant.mu.Unlock()
+
+	accountant.live[data] = usageEntry{tag, size}
+	usage := accountant.tags[tag]
+	if usage == nil {
+		usage = &tagUsage{updated: now}
+		accountant.tags[tag] = usage
+	}
+	usage.advance(now)
+	usage.bytes_in_use += size
+	usage.peak_bytes = max(usage.peak_bytes, usage.bytes_in_use)
+	usage.acquires++
+}
+
+func (accountant *usageAccountant) forget(data unsafe.Pointer, now time.Time) {
+	accountant.mu.Lock()
+	defer accountant.mu.Unlock()
+
+	entry, ok := accountant.live[data]
+	if !ok {
+		return
+	}
+	delete(accountant.live, data)
+
+	usage := accountant.tags[entry.tag]
+	usage.advance(now)
+	usage.bytes_in_use -= entry.size
+}
+
+// usage returns the current period up to now, starting a new one there if
+// end is set.
+func (accountant *usageAccountant) usage(now time.Time, end bool) UsageReport {
+	accountant.mu.Lock()
+	defer accountant.mu.Unlock()
+
+	report := UsageReport{Start: accountant.start, End: now}
+	period := now.Sub(accountant.start).Seconds()
+	for tag, usage := range accountant.tags {
+		usage.advance(now)
+		tag_usage := TagUsage{
+			Tag:         tag,
+			ByteSeconds: usage.byte_seconds,
+			PeakBytes:   usage.peak_bytes,
+			BytesInUse:  usage.bytes_in_use,
+			Acquires:    usage.acquires,
+		}
+		if period > 0 {
+			tag_usage.AverageBytes = usage.byte_seconds / period
+		}
+		report.Tags = append(report.Tags, tag_usage)
+
+		if end {
+			if usage.bytes_in_use == 0 {
+				delete(accountant.tags, tag)
+				continue
+			}
+			usage.byte_seconds = 0
+			usage.peak_bytes = usage.bytes_in_use
+			usage.acquires = 0
+		}
+	}
+	if end {
+		accountant.start = now
+	}
+
+	sort.Slice(report.Tags, func(i, j int) bool {
+		if report.Tags[i].ByteSeconds != report.Tags[j].ByteSeconds {
+			return report.Tags[i].ByteSeconds > report.Tags[j].ByteSeconds
+		}
+		return report.Tags[i].Tag < report.Tags[j].Tag
+	})
+	return report
+}
+
+var errNoUsageAccounting = errors.New("rustybuffer: usage of a pool created without WithUsageAccounting")
+
+// Usage returns what each tag has used so far in the current accounting
+// period, without ending it.
+func (pool *Pool) Usage() (UsageReport, error) {
+	if pool.usage == nil {
+		return UsageReport{}, errNoUsageAccounting
+	}
+	return pool.usage.usage(time.Now(), false), nil
+}
+
+// ReportUsage ends the current accounting period, returning what each tag
+// used over it after reporting it. Pools created without
+// WithUsageAccounting have nothing to report.
+func (pool *Pool) ReportUsage() (UsageReport, error) {
+	accountant := pool.usage
+	if accountant == nil {
+		return UsageReport{}, errNoUsageAccounting
+	}
+
+	report := accountant.usage(time.Now(), true)
+	if accountant.report != nil {
+		accountant.report(report)
+	} else {
+		for _, usage := range report.Tags {
+			log.Printf("rustybuffer: tag %q used %.0f byte-seconds (%.0f bytes on average, peaking at %d) over %v",
+				usage.Tag, usage.ByteSeconds, usage.AverageBytes, usage.PeakBytes, report.End.Sub(report.Start))
+		}
+	}
+	return report, nil
+}
+
+// ReportUsageEvery calls ReportUsage every interval, until ctx is done,
+// for accounting periods of that length.
+func (pool *Pool) ReportUsageEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pool.ReportUsage()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package rustybuffer
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestUsageAccounting(t *testing.T) {
+	start := time.Now()
+	at := func(seconds float64) time.Time {
+		return start.Add(time.Duration(seconds * float64(time.Second)))
+	}
+	accountant := &usageAccountant{
+		live:  make(map[unsafe.Pointer]usageEntry),
+		tags:  make(map[string]*tagUsage),
+		start: start,
+	}
+	a, b, c := new(byte), new(byte), new(byte)
+
+	// A tenant holding a lot briefly and one holding a little for longer.
+	accountant.track(unsafe.Pointer(a), 1000, "burst", at(0))
+	accountant.forget(unsafe.Pointer(a), at(1))
+	accountant.track(unsafe.Pointer(b), 100, "steady", at(0))
+	accountant.track(unsafe.Pointer(c), 100, "steady", at(5))
+
+	report := accountant.usage(at(10), false)
+	if len(report.Tags) != 2 || report.Tags[0].Tag != "steady" || report.Tags[0].ByteSeconds != 1500 ||
+		report.Tags[0].PeakBytes != 200 || report.Tags[0].BytesInUse != 200 || report.Tags[0].Acquires != 2 ||
+		report.Tags[0].AverageBytes != 150 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Tags[1].Tag != "burst" || report.Tags[1].ByteSeconds != 1000 || report.Tags[1].PeakBytes != 1000 ||
+		report.Tags[1].BytesInUse != 0 || report.Total() != 2500 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// Ending the period there, what's still live is charged to the next.
+	accountant.usage(at(10), true)
+	accountant.forget(unsafe.Pointer(b), at(12))
+	report = accountant.usage(at(20), true)
+	if !report.Start.Equal(at(10)) || len(report.Tags) != 1 || report.Tags[0].Tag != "steady" ||
+		report.Tags[0].ByteSeconds != 1200 || report.Tags[0].PeakBytes != 200 || report.Tags[0].Acquires != 0 ||
+		report.Tags[0].BytesInUse != 100 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestPoolUsage(t *testing.T) {
+	var reports []UsageReport
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)),
+		WithUsageAccounting(func(report UsageReport) { reports = append(reports, report) }))
+
+	entry, err := pool.AllocBuffers([]uint64{100}, WithTag("indexer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	untagged, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	untagged.Release()
+
+	// Pinned memory is still held, and charged for.
+	_, unpin := entry.Pin()
+	entry.Release()
+	time.Sleep(10 * time.Millisecond)
+	unpin()
+
+	usage, err := pool.Usage()
+	if err != nil || len(usage.Tags) != 2 || usage.Tags[0].Tag != "indexer" || usage.Tags[0].ByteSeconds < 1 ||
+		usage.Tags[0].BytesInUse != 0 || usage.Tags[1].Tag != "" || usage.Tags[1].Acquires != 1 {
+		t.Fatalf("unexpected usage %+v (%v)", usage, err)
+	}
+
+	report, err := pool.ReportUsage()
+	if err != nil || len(reports) != 1 || len(report.Tags) != 2 {
+		t.Fatalf("unexpected report %+v (%v)", report, err)
+	}
+	if usage, err := pool.Usage(); err != nil || len(usage.Tags) != 0 {
+		t.Fatalf("expected a fresh period, got %+v (%v)", usage, err)
+	}
+
+	if _, err := NewPool().ReportUsage(); err == nil {
+		t.Fatal("expected a pool without usage accounting to fail")
+	}
+}
@@ -0,0 +1,76 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// View is a window onto pooled memory, usually one of an entry's buffers
+// or part of one. Views are values like entries and share the entry's
+// release state, so using a view after any copy of its entry has been
+// released panics rather than reading memory that's been handed to
+// someone else. The zero View is empty.
+type View struct {
+	buf   []byte
+	state *entryState
+}
+
+// View returns a view of the entry's idx'th buffer.
+func (entry *RBEntry) View(idx int) View {
+	return View{entry.Buffers[idx], entry.state}
+}
+
+// ViewOf wraps memory that doesn't belong to an entry, e.g., to compare
+// pooled memory against a Go slice.
+func ViewOf(buf []byte) View {
+	return View{buf, nil}
+}
+
+// Bytes returns the memory the view covers. It must not be used once the
+// entry is released.
+func (view View) Bytes() []byte {
+	view.check()
+	return view.buf
+}
+
+// UnsafeString returns the view's bytes as a string without copying them,
+// for using pooled memory as map keys, log fields and the like. Go assumes
+// strings never change, so the bytes mustn't be modified while the string
+// is in use, and the string mustn't be used (or kept, in a map say) once
+// the entry is released. Like Bytes, it panics if the entry already has
+// been. When in doubt use CopyString.
+func (view View) UnsafeString() string {
+	view.check()
+	if len(view.buf) == 0 {
+		return ""
+	}
+	return unsafe.String(unsafe.SliceData(view.buf), len(view.buf))
+}
+
+// CopyString returns a copy of the view's bytes as a string, which is safe
+// to keep after the entry is released.
+func (view View) CopyString() string {
+	view.check()
+	return string(view.buf)
+}
+
+// Len is the size of the view in bytes.
+func (view View) Len() int {
+	return len(view.buf)
+}
+
+// Slice returns a view of bytes [start:end) of the view.
+func (view View) Slice(start int, end int) View {
+	view.check()
+	if start < 0 || end < start || end > len(view.buf) {
+		panic(fmt.Sprintf("rustybuffer: view slice [%d:%d] out of range with length %d",
+			start, end, len(view.buf)))
+	}
+	return View{view.buf[start:end:end], view.state}
+}
+
+func (view View) check() {
+	if view.state != nil && view.state.released.Load() {
+		panic("rustybuffer: use of a view after its entry was released")
+	}
+}
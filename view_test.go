@@ -0,0 +1,86 @@
+package rustybuffer
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func expectPanic(t *testing.T, contains string, fn func()) {
+	t.Helper()
+
+	defer func() {
+		msg, _ := recover().(string)
+		if !strings.Contains(msg, contains) {
+			t.Fatalf("expected a panic about %q, got %q", contains, msg)
+		}
+	}()
+	fn()
+}
+
+func TestView(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	entry, err := pool.AllocBuffers([]uint64{4, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	view := entry.View(1)
+	if view.Len() != 8 {
+		t.Fatalf("unexpected length %d", view.Len())
+	}
+	copy(view.Bytes(), "abcdefgh")
+
+	sub := view.Slice(2, 5)
+	if string(sub.Bytes()) != "cde" {
+		t.Fatalf("unexpected slice %q", sub.Bytes())
+	}
+	if cap(sub.Bytes()) != 3 {
+		t.Fatal("a slice can reach past its end")
+	}
+	expectPanic(t, "out of range", func() { view.Slice(4, 9) })
+
+	copied := entry
+	copied.Release()
+	expectPanic(t, "after its entry was released", func() { sub.Bytes() })
+
+	if ViewOf([]byte("abc")).Len() != 3 || (View{}).Len() != 0 {
+		t.Fatal("unexpected length")
+	}
+}
+
+func TestViewStrings(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(1024, 1024)))
+
+	entry, err := pool.AllocBuffers([]uint64{8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	view := entry.View(0)
+	copy(view.Bytes(), "key:1234")
+
+	key := view.Slice(0, 3).UnsafeString()
+	if key != "key" || unsafe.StringData(key) != &view.Bytes()[0] {
+		t.Fatalf("expected %q without a copy", key)
+	}
+	counts := map[string]int{key: 1}
+	if counts["key"] != 1 {
+		t.Fatal("unsafe string didn't work as a map key")
+	}
+
+	copied := view.Slice(4, 8).CopyString()
+	if copied != "1234" || unsafe.StringData(copied) == &view.Bytes()[4] {
+		t.Fatalf("expected a copy of %q", copied)
+	}
+	if (View{}).UnsafeString() != "" {
+		t.Fatal("expected an empty string")
+	}
+
+	entry.Release()
+	if copied != "1234" {
+		t.Fatal("the copy changed after release")
+	}
+	expectPanic(t, "after its entry was released", func() { view.UnsafeString() })
+	expectPanic(t, "after its entry was released", func() { view.CopyString() })
+}
@@ -0,0 +1,482 @@
+// Package wal is a circular write-ahead log whose records are staged in
+// pooled memory, as an example of building a durable structure on
+// rustybuffer: appends are copied into a pooled staging buffer, written
+// out in one go when it fills or the log is flushed, and read back into
+// pooled memory to be replayed.
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sync"
+
+	"github.com/davisp/rustybuffer"
+)
+
+var (
+	// ErrFull is returned by Append when the record doesn't fit in the
+	// space that hasn't been checkpointed.
+	ErrFull = errors.New("wal: log is full")
+
+	// ErrCorrupt is returned by Open for a file that isn't a log.
+	ErrCorrupt = errors.New("wal: corrupt log")
+
+	// ErrClosed is returned by a closed log.
+	ErrClosed = errors.New("wal: log is closed")
+)
+
+// A log file is a header area then capacity bytes of records, little
+// endian throughout:
+//
+//	0     the header, in one of two 512 byte slots written alternately so
+//	      a torn header write leaves the other intact:
+//	        u64  magic, 0x57414c0000000001 ("WAL" and version 1)
+//	        u64  capacity
+//	        u64  the offset of the oldest live record
+//	        u64  its sequence number
+//	        u64  the header's generation, the newer slot being the larger
+//	        u32  CRC32C of the above
+//	4096  the records, each 8 byte aligned:
+//	        u32  payload length, or 0xFFFFFFFF for a wrap marker
+//	        u32  CRC32C of the sequence number and payload
+//	        u64  sequence number
+//	             the payload, padded to a multiple of 8
+//
+// A record that doesn't fit before the end of the file goes at the start,
+// after a wrap marker if there's room for one. Sequence numbers count up
+// from one without gaps, so records left over from the log's last lap
+// around the file (or a torn write) end recovery: they never have the next
+// number.
+const (
+	magic         uint64 = 0x57414c00_00000001 // "WAL" then 0x0001
+	headerSlot           = 512
+	headerArea           = 4096
+	headerSize           = 44
+	recordHeader         = 16
+	wrapMarker    uint32 = 0xFFFFFFFF
+	maxPayload           = 1<<31 - 1
+	defaultStaged        = 1 << 20
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configure Open.
+type Options struct {
+	// The pool staging and replay buffers come from, a pool on the Rust
+	// library if nil.
+	Pool *rustybuffer.Pool
+
+	// The size of the staging buffer, 1MiB by default. No record can be
+	// larger.
+	StagingSize int
+}
+
+// Log is a write-ahead log. It's safe for concurrent use.
+type Log struct {
+	file     *os.File
+	capacity uint64
+	pool     *rustybuffer.Pool
+
+	mu         sync.Mutex
+	generation uint64
+
+	// The live records, oldest first, and the offset the next goes at.
+	records []position
+	tail    uint64
+	next    uint64
+
+	// Records appended but not yet written, for the file from stage_at.
+	staging  rustybuffer.RBEntry
+	staged   int
+	stage_at uint64
+
+	closed bool
+}
+
+// position is where a live record is.
+type position struct {
+	seq    uint64
+	offset uint64
+}
+
+// Open opens the log at path, recovering every record written to it that
+// hasn't been checkpointed, or creates one with room for capacity bytes of
+// records (rounded down to a multiple of 8) if there isn't a log at path.
+// capacity is ignored for an existing log.
+func Open(path string, capacity int64, opts Options) (*Log, error) {
+	if opts.Pool == nil {
+		opts.Pool = rustybuffer.NewPool()
+	}
+	if opts.StagingSize <= 0 {
+		opts.StagingSize = defaultStaged
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("wal: %w", err)
+	}
+	log := &Log{file: file, pool: opts.Pool}
+	if err := log.recover(uint64(capacity) &^ 7); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	log.staging, err = opts.Pool.AllocBuffers([]uint64{uint64(opts.StagingSize) &^ 7})
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("wal: acquiring the staging buffer: %w", err)
+	}
+	log.stage_at = log.tail
+	return log, nil
+}
+
+// recover reads the header, creating it if this is a new log, then scans
+// the records after the head.
+func (log *Log) recover(capacity uint64) error {
+	info, err := log.file.Stat()
+	if err != nil {
+		return fmt.Errorf("wal: %w", err)
+	}
+	if info.Size() == 0 {
+		if capacity < recordHeader {
+			return fmt.Errorf("wal: a capacity of %d bytes can't hold a record", capacity)
+		}
+		if err := log.file.Truncate(int64(headerArea + capacity)); err != nil {
+			return fmt.Errorf("wal: %w", err)
+		}
+		log.capacity = capacity
+		log.next = 1
+		return log.writeHeader(0, 1)
+	}
+
+	var header [2 * headerSlot]byte
+	if _, err := log.file.ReadAt(header[:], 0); err != nil {
+		return fmt.Errorf("%w: reading the header: %v", ErrCorrupt, err)
+	}
+	var head, seq uint64
+	found := false
+	for slot := 0; slot < 2; slot++ {
+		fields := header[slot*headerSlot:]
+		if binary.LittleEndian.Uint64(fields) != magic ||
+			crc32.Checksum(fields[:headerSize-4], castagnoli) != binary.LittleEndian.Uint32(fields[headerSize-4:]) {
+			continue
+		}
+		generation := binary.LittleEndian.Uint64(fields[32:])
+		if found && generation < log.generation {
+			continue
+		}
+		found = true
+		log.capacity = binary.LittleEndian.Uint64(fields[8:])
+		head = binary.LittleEndian.Uint64(fields[16:])
+		seq = binary.LittleEndian.Uint64(fields[24:])
+		log.generation = generation
+	}
+	if !found {
+		return fmt.Errorf("%w: no valid header", ErrCorrupt)
+	}
+	if info.Size() != int64(headerArea+log.capacity) || head >= log.capacity || head%8 != 0 {
+		return fmt.Errorf("%w: header says %d bytes from %d, the file has %d",
+			ErrCorrupt, log.capacity, head, info.Size()-headerArea)
+	}
+
+	var hdr [recordHeader]byte
+	offset, used := head, uint64(0)
+	for used < log.capacity {
+		if log.capacity-offset < recordHeader {
+			used += log.capacity - offset
+			offset = 0
+		}
+		if _, err := log.file.ReadAt(hdr[:], int64(headerArea+offset)); err != nil {
+			return fmt.Errorf("wal: %w", err)
+		}
+		length := binary.LittleEndian.Uint32(hdr[0:])
+		if binary.LittleEndian.Uint64(hdr[8:]) != seq {
+			break
+		}
+		if length == wrapMarker {
+			if binary.LittleEndian.Uint32(hdr[4:]) != recordCRC(seq, nil) {
+				break
+			}
+			used += log.capacity - offset
+			offset = 0
+			continue
+		}
+		size := recordSize(int(length))
+		if uint64(length) > maxPayload || size > log.capacity-offset || used+size > log.capacity {
+			break
+		}
+		valid, err := log.check(offset, seq, hdr)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			break
+		}
+		log.records = append(log.records, position{seq, offset})
+		offset += size
+		used += size
+		seq++
+	}
+	if len(log.records) == 0 {
+		// Nothing's live, so the wrap markers skipped aren't either.
+		offset = head
+	}
+	log.tail = offset % log.capacity
+	log.next = seq
+	return nil
+}
+
+// check reads the payload of the record at offset into pooled memory and
+// checks it against its header.
+func (log *Log) check(offset uint64, seq uint64, hdr [recordHeader]byte) (bool, error) {
+	valid := false
+	length := binary.LittleEndian.Uint32(hdr[0:])
+	err := log.pool.WithEntry([]uint64{uint64(length)}, func(entry rustybuffer.RBEntry) error {
+		payload := entry.Buffers[0]
+		if _, err := log.file.ReadAt(payload, int64(headerArea+offset+recordHeader)); err != nil {
+			return fmt.Errorf("wal: %w", err)
+		}
+		valid = binary.LittleEndian.Uint32(hdr[4:]) == recordCRC(seq, payload)
+		return nil
+	})
+	return valid, err
+}
+
+// writeHeader records where the oldest live record is, in the older slot.
+func (log *Log) writeHeader(head uint64, seq uint64) error {
+	var fields [headerSize]byte
+	binary.LittleEndian.PutUint64(fields[0:], magic)
+	binary.LittleEndian.PutUint64(fields[8:], log.capacity)
+	binary.LittleEndian.PutUint64(fields[16:], head)
+	binary.LittleEndian.PutUint64(fields[24:], seq)
+	binary.LittleEndian.PutUint64(fields[32:], log.generation+1)
+	binary.LittleEndian.PutUint32(fields[40:], crc32.Checksum(fields[:headerSize-4], castagnoli))
+
+	slot := int64((log.generation + 1) % 2)
+	if _, err := log.file.WriteAt(fields[:], slot*headerSlot); err != nil {
+		return fmt.Errorf("wal: writing the header: %w", err)
+	}
+	if err := log.file.Sync(); err != nil {
+		return fmt.Errorf("wal: writing the header: %w", err)
+	}
+	log.generation++
+	return nil
+}
+
+func recordSize(length int) uint64 {
+	return recordHeader + (uint64(length)+7)&^7
+}
+
+func recordCRC(seq uint64, payload []byte) uint32 {
+	var seq_bytes [8]byte
+	binary.LittleEndian.PutUint64(seq_bytes[:], seq)
+	return crc32.Update(crc32.Checksum(seq_bytes[:], castagnoli), castagnoli, payload)
+}
+
+// used is the bytes between the oldest live record and the tail, wrap and
+// all.
+func (log *Log) used() uint64 {
+	if len(log.records) == 0 {
+		return 0
+	}
+	used := (log.tail + log.capacity - log.records[0].offset) % log.capacity
+	if used == 0 {
+		return log.capacity
+	}
+	return used
+}
+
+// Append stages a record holding payload, returning its sequence number.
+// It's durable once Flush returns. If the staging buffer fills, what's in
+// it is written out first, though not synced. Records that don't fit in
+// the space left fail with ErrFull; Checkpoint frees space.
+func (log *Log) Append(payload []byte) (uint64, error) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	if log.closed {
+		return 0, ErrClosed
+	}
+	size := recordSize(len(payload))
+	if len(payload) > maxPayload || size > uint64(len(log.staging.Buffers[0])) {
+		return 0, fmt.Errorf("wal: a %d byte record won't fit in the %d byte staging buffer",
+			len(payload), len(log.staging.Buffers[0]))
+	}
+
+	offset, waste := log.tail, uint64(0)
+	if size > log.capacity-offset {
+		waste, offset = log.capacity-offset, 0
+	}
+	if log.used()+waste+size > log.capacity {
+		return 0, fmt.Errorf("%w: %d bytes needed, %d of %d in use", ErrFull, waste+size,
+			log.used(), log.capacity)
+	}
+
+	seq := log.next
+	if waste >= recordHeader {
+		if err := log.stage(seq, wrapMarker, nil, recordHeader); err != nil {
+			return 0, err
+		}
+	}
+	// What's staged goes to one stretch of the file, so a record that
+	// doesn't follow on from it (having wrapped) waits for it to go first.
+	if log.stage_at+uint64(log.staged) != offset {
+		if err := log.write(); err != nil {
+			return 0, err
+		}
+		log.stage_at = offset
+	}
+	if err := log.stage(seq, uint32(len(payload)), payload, size); err != nil {
+		return 0, err
+	}
+
+	log.records = append(log.records, position{seq, offset})
+	log.tail = (offset + size) % log.capacity
+	log.next++
+	return seq, nil
+}
+
+// stage copies a record into the staging buffer, writing out what's there
+// first if it doesn't fit.
+func (log *Log) stage(seq uint64, length uint32, payload []byte, size uint64) error {
+	staging := log.staging.Buffers[0]
+	if uint64(len(staging)-log.staged) < size {
+		if err := log.write(); err != nil {
+			return err
+		}
+	}
+
+	record := staging[log.staged : log.staged+int(size)]
+	binary.LittleEndian.PutUint32(record[0:], length)
+	binary.LittleEndian.PutUint32(record[4:], recordCRC(seq, payload))
+	binary.LittleEndian.PutUint64(record[8:], seq)
+	copy(record[recordHeader:], payload)
+	clear(record[recordHeader+len(payload):])
+	log.staged += int(size)
+	return nil
+}
+
+// write writes out the staging buffer.
+func (log *Log) write() error {
+	if log.staged == 0 {
+		return nil
+	}
+	staged := log.staging.Buffers[0][:log.staged]
+	if _, err := log.file.WriteAt(staged, int64(headerArea+log.stage_at)); err != nil {
+		return fmt.Errorf("wal: %w", err)
+	}
+	log.stage_at = (log.stage_at + uint64(log.staged)) % log.capacity
+	log.staged = 0
+	return nil
+}
+
+// Flush writes out every staged record and syncs the file, after which
+// they survive a crash.
+func (log *Log) Flush() error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	if log.closed {
+		return ErrClosed
+	}
+	return log.flush()
+}
+
+func (log *Log) flush() error {
+	if err := log.write(); err != nil {
+		return err
+	}
+	if err := log.file.Sync(); err != nil {
+		return fmt.Errorf("wal: %w", err)
+	}
+	return nil
+}
+
+// Checkpoint drops every record up to and including seq, once whatever
+// they log is safely stored elsewhere, freeing their space. Staged records
+// are flushed first.
+func (log *Log) Checkpoint(seq uint64) error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	if log.closed {
+		return ErrClosed
+	}
+	if err := log.flush(); err != nil {
+		return err
+	}
+
+	keep := 0
+	for keep < len(log.records) && log.records[keep].seq <= seq {
+		keep++
+	}
+	if keep == 0 {
+		return nil
+	}
+	head, next := log.tail, log.next
+	if keep < len(log.records) {
+		head, next = log.records[keep].offset, log.records[keep].seq
+	}
+	if err := log.writeHeader(head, next); err != nil {
+		return err
+	}
+	log.records = append(log.records[:0], log.records[keep:]...)
+	return nil
+}
+
+// Replay calls fn with each live record, oldest first, flushing any that
+// are staged. The payload is pooled memory only valid until fn returns. If
+// fn fails Replay stops and returns its error.
+func (log *Log) Replay(fn func(seq uint64, payload []byte) error) error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	if log.closed {
+		return ErrClosed
+	}
+	if err := log.flush(); err != nil {
+		return err
+	}
+
+	var hdr [recordHeader]byte
+	for _, record := range log.records {
+		if _, err := log.file.ReadAt(hdr[:], int64(headerArea+record.offset)); err != nil {
+			return fmt.Errorf("wal: %w", err)
+		}
+		length := binary.LittleEndian.Uint32(hdr[0:])
+		err := log.pool.WithEntry([]uint64{uint64(length)}, func(entry rustybuffer.RBEntry) error {
+			payload := entry.Buffers[0]
+			if _, err := log.file.ReadAt(payload, int64(headerArea+record.offset+recordHeader)); err != nil {
+				return fmt.Errorf("wal: %w", err)
+			}
+			if binary.LittleEndian.Uint32(hdr[4:]) != recordCRC(record.seq, payload) {
+				return fmt.Errorf("%w: record %d changed since it was written", ErrCorrupt, record.seq)
+			}
+			return fn(record.seq, payload)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the log, releases its staging buffer and closes the file.
+func (log *Log) Close() error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	if log.closed {
+		return ErrClosed
+	}
+	log.closed = true
+	err := log.flush()
+	log.staging.Release()
+	if close_err := log.file.Close(); err == nil && close_err != nil {
+		err = fmt.Errorf("wal: %w", close_err)
+	}
+	return err
+}
@@ -0,0 +1,208 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/davisp/rustybuffer"
+)
+
+func testOptions(staging int) Options {
+	pool := rustybuffer.NewPool(rustybuffer.WithAllocator(rustybuffer.NewHeapAllocator(1<<22, 1<<22)))
+	return Options{Pool: pool, StagingSize: staging}
+}
+
+func replay(t *testing.T, log *Log) []string {
+	t.Helper()
+	var records []string
+	err := log.Replay(func(seq uint64, payload []byte) error {
+		records = append(records, fmt.Sprintf("%d:%s", seq, payload))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func expectRecords(t *testing.T, log *Log, expected ...string) {
+	t.Helper()
+	if records := replay(t, log); !reflect.DeepEqual(records, expected) {
+		t.Fatalf("expected records %q, got %q", expected, records)
+	}
+}
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	opts := testOptions(64)
+
+	log, err := Open(path, 4096, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx, payload := range []string{"one", "", "three, which is longer than the rest", "four"} {
+		seq, err := log.Append([]byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq != uint64(idx+1) {
+			t.Fatalf("expected sequence number %d, got %d", idx+1, seq)
+		}
+	}
+	expectRecords(t, log, "1:one", "2:", "3:three, which is longer than the rest", "4:four")
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", opts.Pool.Stats())
+	}
+	if _, err := log.Append(nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	log, err = Open(path, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRecords(t, log, "1:one", "2:", "3:three, which is longer than the rest", "4:four")
+	if err := log.Checkpoint(2); err != nil {
+		t.Fatal(err)
+	}
+	if seq, err := log.Append([]byte("five")); err != nil || seq != 5 {
+		t.Fatalf("expected record 5, got %d: %v", seq, err)
+	}
+	log.Close()
+
+	log, err = Open(path, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	expectRecords(t, log, "3:three, which is longer than the rest", "4:four", "5:five")
+
+	if _, err := log.Append(make([]byte, 64)); err == nil {
+		t.Fatal("expected a record larger than the staging buffer to be refused")
+	}
+}
+
+func TestLogWraps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	opts := testOptions(256)
+
+	// Room for four 24 byte records and a bit.
+	log, err := Open(path, 100, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := 1; idx <= 4; idx++ {
+		if _, err := log.Append([]byte(fmt.Sprint("r", idx))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := log.Append([]byte("r5")); !errors.Is(err, ErrFull) {
+		t.Fatalf("expected ErrFull, got %v", err)
+	}
+
+	// Laps around the file, checkpointing as it goes, with and without a
+	// wrap marker at the end.
+	for idx := 5; idx <= 40; idx++ {
+		if err := log.Checkpoint(uint64(idx - 3)); err != nil {
+			t.Fatal(err)
+		}
+		payload := fmt.Sprint("r", idx)
+		if idx%3 == 0 {
+			payload += "-longer"
+		}
+		if _, err := log.Append([]byte(payload)); err != nil {
+			t.Fatalf("record %d: %v", idx, err)
+		}
+		if idx%5 == 0 {
+			if err := log.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expected := replay(t, log)
+	if len(expected) != 3 || expected[2] != "40:r40" {
+		t.Fatalf("unexpected records %q", expected)
+	}
+	log.Close()
+
+	log, err = Open(path, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	expectRecords(t, log, expected...)
+	if err := log.Checkpoint(38); err != nil {
+		t.Fatal(err)
+	}
+	if seq, err := log.Append([]byte("r41")); err != nil || seq != 41 {
+		t.Fatalf("expected record 41, got %d: %v", seq, err)
+	}
+}
+
+func TestLogRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	opts := testOptions(1024)
+
+	log, err := Open(path, 4096, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"kept", "also kept", "torn"} {
+		log.Append([]byte(payload))
+	}
+	log.Close()
+
+	// Tear the last record, as a crash part way through writing it would.
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte("T"), headerArea+24+32+recordHeader); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	log, err = Open(path, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRecords(t, log, "1:kept", "2:also kept")
+	if seq, err := log.Append([]byte("replaced")); err != nil || seq != 3 {
+		t.Fatalf("expected record 3, got %d: %v", seq, err)
+	}
+	log.Checkpoint(1)
+	log.Close()
+
+	// A torn header falls back on the previous one.
+	file, err = os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{0xff}, 20); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	log, err = Open(path, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRecords(t, log, "1:kept", "2:also kept", "3:replaced")
+	log.Close()
+
+	if err := os.WriteFile(path, make([]byte, 8192), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, 0, opts); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+	if opts.Pool.Stats().BytesInUse != 0 {
+		t.Fatalf("unexpected stats: %+v", opts.Pool.Stats())
+	}
+}
@@ -0,0 +1,60 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"unsafe"
+)
+
+// Warm readies the pool for its expected working set, count buffers of
+// each size in size_classes, before traffic arrives: every buffer is
+// acquired at once, each of its pages written to so the OS commits them,
+// then all are released again. Allocators that cache released memory (the
+// Rust library's) then hand the same buffers straight back, so the first
+// acquires after a deploy don't pay for growing the allocator or page
+// faults. For allocators that don't it only warms the process's heap.
+//
+// If the working set doesn't fit in the pool, everything acquired so far
+// is released and the error returned; the pool is then only partly warm.
+// The buffers never leave the pool, so they don't count towards its call
+// site stats, rate limits and the like.
+func (pool *Pool) Warm(size_classes map[uint64]int) error {
+	sizes := make([]uint64, 0, len(size_classes))
+	for size := range size_classes {
+		sizes = append(sizes, size)
+	}
+	// Largest first, so a pool too small for the working set says so
+	// before acquiring much.
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] > sizes[j] })
+
+	var acquired []unsafe.Pointer
+	defer func() {
+		for _, data := range acquired {
+			pool.alloc.Release(data)
+		}
+		releases.notify()
+	}()
+
+	page := uint64(os.Getpagesize())
+	for _, size := range sizes {
+		if size == 0 {
+			continue
+		}
+		for idx := 0; idx < size_classes[size]; idx++ {
+			data, err := pool.alloc.Acquire(size)
+			if err != nil {
+				return fmt.Errorf("rustybuffer: warming %d buffers of %d bytes: %w",
+					size_classes[size], size, err)
+			}
+			acquired = append(acquired, data)
+
+			memory := unsafe.Slice((*byte)(data), size)
+			for offset := uint64(0); offset < size; offset += page {
+				memory[offset] = 0
+			}
+			memory[size-1] = 0
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package rustybuffer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWarm(t *testing.T) {
+	alloc := NewRustAllocator()
+	if _, err := LibraryInfo(); err != nil {
+		t.Skipf("no library: %v", err)
+	}
+	Configure(1<<24, 1<<20)
+	FreeOSMemory()
+	pool := NewPool(WithAllocator(alloc))
+	if err := pool.Warm(map[uint64]int{4096: 4, 65536: 2, 0: 3}); err != nil {
+		t.Fatal(err)
+	}
+	stats := pool.Stats()
+	if stats.BytesInUse != 0 || stats.NumAvailable != 6 || stats.BytesAllocated != 4*4096+2*65536 {
+		t.Fatalf("expected the working set to be cached: %+v", stats)
+	}
+
+	// The warmed buffers are handed straight back.
+	var entries []RBEntry
+	for idx := 0; idx < 4; idx++ {
+		entry, err := pool.AllocBuffers([]uint64{4096})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if stats := pool.Stats(); stats.BytesAllocated != 4*4096+2*65536 || stats.NumAvailable != 2 {
+		t.Fatalf("expected the cached buffers to be reused: %+v", stats)
+	}
+	for idx := range entries {
+		entries[idx].Release()
+	}
+	FreeOSMemory()
+}
+
+func TestWarmTooLarge(t *testing.T) {
+	pool := NewPool(WithAllocator(NewHeapAllocator(8192, 8192)))
+	if err := pool.Warm(map[uint64]int{4096: 3}); !errors.Is(err, ErrNoBufferAvailable) {
+		t.Fatalf("expected ErrNoBufferAvailable, got %v", err)
+	}
+	if stats := pool.Stats(); stats.BytesInUse != 0 || stats.BytesAllocated != 0 {
+		t.Fatalf("expected everything to be released: %+v", stats)
+	}
+}
@@ -0,0 +1,133 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Watermark is a pool utilization level, see WithWatermarks.
+type Watermark int
+
+const (
+	WatermarkNone Watermark = iota
+	WatermarkSoft
+	WatermarkHard
+)
+
+func (mark Watermark) String() string {
+	switch mark {
+	case WatermarkSoft:
+		return "soft"
+	case WatermarkHard:
+		return "hard"
+	default:
+		return "none"
+	}
+}
+
+// WatermarkEvent is passed to OnWatermark callbacks when utilization
+// crosses a watermark, either Rising above it or falling back below.
+type WatermarkEvent struct {
+	Watermark Watermark
+	Rising    bool
+	Stats     Stats
+}
+
+type watermarks struct {
+	mu        sync.Mutex
+	soft      float64
+	hard      float64
+	level     Watermark
+	callbacks []func(event WatermarkEvent)
+}
+
+// WithWatermarks sets soft and hard watermarks as fractions of the
+// allocator's MaxTotalSize, e.g. 0.75 and 0.9. Callbacks registered with
+// OnWatermark are told whenever the bytes in use cross either of them, so
+// that caches built on the pool can start evicting before acquires fail.
+//
+// Allocators are shared (all the Rust backed pools share the one library)
+// so utilization includes everything acquired from the allocator, not
+// just from this pool.
+func WithWatermarks(soft float64, hard float64) PoolOption {
+	return func(pool *Pool) {
+		pool.watermarks = &watermarks{
+			soft: soft,
+			hard: hard,
+		}
+	}
+}
+
+// OnWatermark registers fn to be called when utilization crosses one of
+// the pool's watermarks. Nothing is ever called for pools without
+// WithWatermarks. Callbacks run on the goroutine that acquired or released
+// the memory, so they should be quick, and can run concurrently.
+func (pool *Pool) OnWatermark(fn func(event WatermarkEvent)) {
+	if pool.watermarks == nil {
+		return
+	}
+
+	pool.watermarks.mu.Lock()
+	defer pool.watermarks.mu.Unlock()
+
+	pool.watermarks.callbacks = append(pool.watermarks.callbacks, fn)
+}
+
+// SetWatermarks moves the pool's watermarks, e.g., from an operator's
+// control socket (see ListenControl). Callbacks are told straight away if
+// that crosses one. It fails for pools created without WithWatermarks.
+func (pool *Pool) SetWatermarks(soft float64, hard float64) error {
+	if pool.watermarks == nil {
+		return fmt.Errorf("rustybuffer: pool has no watermarks")
+	}
+	if soft < 0 || hard < soft {
+		return fmt.Errorf("rustybuffer: invalid watermarks %g and %g", soft, hard)
+	}
+
+	pool.watermarks.mu.Lock()
+	pool.watermarks.soft = soft
+	pool.watermarks.hard = hard
+	pool.watermarks.mu.Unlock()
+
+	pool.checkWatermarks()
+	return nil
+}
+
+// checkWatermarks fires callbacks for every watermark crossed since the
+// last check.
+func (pool *Pool) checkWatermarks() {
+	marks := pool.watermarks
+	if marks == nil {
+		return
+	}
+
+	stats := pool.alloc.Stats()
+	if stats.MaxTotalSize == 0 {
+		return
+	}
+
+	utilization := float64(stats.BytesInUse) / float64(stats.MaxTotalSize)
+
+	marks.mu.Lock()
+	level := WatermarkNone
+	if utilization >= marks.hard {
+		level = WatermarkHard
+	} else if utilization >= marks.soft {
+		level = WatermarkSoft
+	}
+	prev := marks.level
+	marks.level = level
+	callbacks := marks.callbacks
+	marks.mu.Unlock()
+
+	for mark := prev + 1; mark <= level; mark++ {
+		for _, fn := range callbacks {
+			fn(WatermarkEvent{mark, true, stats})
+		}
+	}
+	for mark := prev; mark > level; mark-- {
+		for _, fn := range callbacks {
+			fn(WatermarkEvent{mark, false, stats})
+		}
+	}
+}
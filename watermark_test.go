@@ -0,0 +1,91 @@
+package rustybuffer
+
+import "testing"
+
+func TestWatermarks(t *testing.T) {
+	pool := NewPool(
+		WithAllocator(NewHeapAllocator(1000, 1000)),
+		WithWatermarks(0.5, 0.9),
+	)
+
+	var events []WatermarkEvent
+	pool.OnWatermark(func(event WatermarkEvent) {
+		events = append(events, event)
+	})
+
+	expect := func(expected ...WatermarkEvent) {
+		t.Helper()
+		if len(events) != len(expected) {
+			t.Fatalf("expected %v, got %v", expected, events)
+		}
+		for idx := range expected {
+			if events[idx].Watermark != expected[idx].Watermark ||
+				events[idx].Rising != expected[idx].Rising {
+				t.Fatalf("expected %v, got %v", expected, events)
+			}
+		}
+		events = nil
+	}
+
+	first, err := pool.AllocBuffers([]uint64{400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect()
+
+	second, err := pool.AllocBuffers([]uint64{200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(WatermarkEvent{Watermark: WatermarkSoft, Rising: true})
+
+	third, err := pool.AllocBuffers([]uint64{300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(WatermarkEvent{Watermark: WatermarkHard, Rising: true})
+
+	// Dropping from hard to none crosses both.
+	first.Release()
+	third.Release()
+	expect(
+		WatermarkEvent{Watermark: WatermarkHard, Rising: false},
+		WatermarkEvent{Watermark: WatermarkSoft, Rising: false},
+	)
+
+	second.Release()
+	expect()
+}
+
+func TestSetWatermarks(t *testing.T) {
+	pool := NewPool(
+		WithAllocator(NewHeapAllocator(1000, 1000)),
+		WithWatermarks(0.5, 0.9),
+	)
+
+	var events []WatermarkEvent
+	pool.OnWatermark(func(event WatermarkEvent) {
+		events = append(events, event)
+	})
+
+	entry, err := pool.AllocBuffers([]uint64{400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+
+	// Lowering the soft watermark crosses it without an acquire.
+	if err := pool.SetWatermarks(0.25, 0.9); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Watermark != WatermarkSoft || !events[0].Rising {
+		t.Fatalf("unexpected events %v", events)
+	}
+
+	if err := pool.SetWatermarks(0.9, 0.5); err == nil {
+		t.Fatal("set a hard watermark below the soft one")
+	}
+	if err := NewPool().SetWatermarks(0.5, 0.9); err == nil {
+		t.Fatal("set watermarks on a pool without them")
+	}
+}
@@ -0,0 +1,42 @@
+package rustybuffer
+
+import "io"
+
+// WebSocketReader is the read side of gorilla/websocket's *Conn.
+type WebSocketReader interface {
+	NextReader() (messageType int, r io.Reader, err error)
+}
+
+// ReadWebSocketMessage reads the next message from conn into pooled memory
+// and returns its type along with the entry holding the payload. Like
+// gorilla's ReadMessage, but the payload never touches the Go heap, which
+// matters once messages are megabytes long. An echo server looks like:
+//
+//	for {
+//		mt, entry, msg, err := rustybuffer.ReadWebSocketMessage(conn)
+//		if err != nil {
+//			return err
+//		}
+//		// WriteMessage copies msg into the connection's write buffer so
+//		// the entry can go back to the pool as soon as it returns.
+//		err = conn.WriteMessage(mt, msg)
+//		entry.Release()
+//		if err != nil {
+//			return err
+//		}
+//	}
+func ReadWebSocketMessage(
+	conn WebSocketReader,
+) (int, RBEntry, []byte, error) {
+	message_type, r, err := conn.NextReader()
+	if err != nil {
+		return 0, RBEntry{}, nil, err
+	}
+
+	entry, msg, err := readAllPooled(r, 0)
+	if err != nil {
+		return 0, RBEntry{}, nil, err
+	}
+
+	return message_type, entry, msg, nil
+}
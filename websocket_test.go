@@ -0,0 +1,42 @@
+package rustybuffer
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type fakeWebSocket struct {
+	messages []string
+}
+
+func (ws *fakeWebSocket) NextReader() (int, io.Reader, error) {
+	if len(ws.messages) == 0 {
+		return 0, nil, io.EOF
+	}
+	msg := ws.messages[0]
+	ws.messages = ws.messages[1:]
+	return 2, strings.NewReader(msg), nil
+}
+
+func TestReadWebSocketMessage(t *testing.T) {
+	Configure(64*1024*1024, 1024*1024)
+	ws := &fakeWebSocket{messages: []string{"first", "second"}}
+
+	for _, expect := range []string{"first", "second"} {
+		mt, entry, msg, err := ReadWebSocketMessage(ws)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mt != 2 || string(msg) != expect {
+			t.Fatalf("unexpected message %d %q", mt, msg)
+		}
+		entry.Release()
+	}
+
+	_, _, _, err := ReadWebSocketMessage(ws)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package rustybuffer
+
+import (
+	"encoding/binary"
+	"log"
+	"unsafe"
+)
+
+// WithVerifiedWipe has the pool zero every entry's memory when it's
+// released, then read it back to check every byte is still zero before
+// giving it back to the allocator. Reading it back is what keeps the wipe
+// from being optimised away as a dead store, and a non-zero byte means
+// something wrote to the entry while it was being released, i.e., a use
+// after free. Such memory is zeroed again, the failure is logged and both
+// outcomes are counted in Stats (WipesVerified and WipesFailed) and, for
+// entries with provenance, in the pool's audit trail. It costs a write
+// and a read of every byte released.
+func WithVerifiedWipe() PoolOption {
+	return func(pool *Pool) {
+		pool.verify_wipes = true
+	}
+}
+
+// wipe zeroes the size bytes at data before they're released, if the pool
+// audits or verifies its wipes.
+func (pool *Pool) wipe(data unsafe.Pointer, size uint64) {
+	if pool.audit == nil && !pool.verify_wipes {
+		return
+	}
+
+	memory := unsafe.Slice((*byte)(data), size)
+	clear(memory)
+	verified := false
+	if pool.verify_wipes {
+		if verified = allZero(memory); verified {
+			pool.wipes_verified.Add(1)
+		} else {
+			pool.wipes_failed.Add(1)
+			log.Printf("rustybuffer: %d byte entry at %p was written to while being released", size, data)
+			clear(memory)
+		}
+	}
+
+	if pool.audit != nil {
+		pool.audit.wiped(data, verified)
+	}
+}
+
+// wipe wipes the entry's memory as its pool says to, unless the memory is
+// someone else's (see AdoptExternal) and may well be read only.
+func (state *entryState) wipe() {
+	if _, external := state.alloc.(*externalAllocator); external {
+		return
+	}
+	state.pool.wipe(state.data, state.size)
+}
+
+// allZero reports whether every byte of memory is zero, a word at a time.
+func allZero(memory []byte) bool {
+	idx := 0
+	for ; idx+8 <= len(memory); idx += 8 {
+		if binary.LittleEndian.Uint64(memory[idx:]) != 0 {
+			return false
+		}
+	}
+	for ; idx < len(memory); idx++ {
+		if memory[idx] != 0 {
+			return false
+		}
+	}
+	return true
+}
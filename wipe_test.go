@@ -0,0 +1,56 @@
+package rustybuffer
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestVerifiedWipe(t *testing.T) {
+	pool := NewPool(
+		WithAllocator(NewHeapAllocator(1<<20, 1<<20)),
+		WithVerifiedWipe(),
+		WithAuditTrail(10),
+	)
+
+	entry, err := pool.AllocBuffers([]uint64{100, 3}, WithProvenance("billing", "pii"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, buffer := range entry.Buffers {
+		for idx := range buffer {
+			buffer[idx] = 0xFF
+		}
+	}
+	memory := unsafe.Slice((*byte)(entry.data), 103)
+	entry.Release()
+	if !allZero(memory) {
+		t.Fatal("released memory wasn't wiped")
+	}
+
+	other, err := pool.AllocBuffers([]uint64{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Release()
+
+	if stats := pool.Stats(); stats.WipesVerified != 2 || stats.WipesFailed != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if report := pool.AuditReport(); len(report.Records) != 1 || !report.Records[0].WipeVerified {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestAllZero(t *testing.T) {
+	memory := make([]byte, 37)
+	if !allZero(memory) || !allZero(nil) {
+		t.Fatal("zeros reported as non-zero")
+	}
+	for _, idx := range []int{0, 7, 8, 31, 32, 36} {
+		memory[idx] = 1
+		if allZero(memory) {
+			t.Fatalf("non-zero byte %d missed", idx)
+		}
+		memory[idx] = 0
+	}
+}
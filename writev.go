@@ -0,0 +1,76 @@
+package rustybuffer
+
+import (
+	"fmt"
+	"hash/crc32"
+)
+
+// WritevWithChecksum writes the entry's buffers, in order, to the file
+// descriptor fd (os.File.Fd, say) and computes algo over them in the same
+// pass, for storage engines that would otherwise read a multi-GB entry
+// from memory twice, once to checksum it and again to write it. Each batch
+// is checksummed just before it's written, while it's still in cache. It
+// returns the bytes written, which on failure is how far it got, and the
+// checksum, the same as Checksum's.
+//
+// The Rust library does both in one call with writev where it can (see
+// CapabilityWritevChecksum), elsewhere it's done a batch at a time from
+// Go. Only unix descriptors can be written to. Pipes and sockets have to
+// be in blocking mode, EAGAIN fails the write like any other error, and
+// tainted entries can't be written at all (see WithTaint).
+func WritevWithChecksum(fd uintptr, entry *RBEntry, algo ChecksumAlgorithm) (int64, uint64, error) {
+	if algo != ChecksumCRC32C && algo != ChecksumXXH64 {
+		return 0, 0, fmt.Errorf("rustybuffer: unknown checksum algorithm %v", algo)
+	}
+	if entry.state != nil && entry.state.released.Load() {
+		return 0, 0, fmt.Errorf("rustybuffer: write of released entry %s", entry.state.handle)
+	}
+	if err := entry.checkTaint("WritevWithChecksum"); err != nil {
+		return 0, 0, err
+	}
+
+	written, checksum, err := writevChecksum(fd, algo, entry.Buffers)
+	if err != nil {
+		return int64(written), 0, fmt.Errorf("rustybuffer: writing entry %s to descriptor %d: %w",
+			entry.Handle(), fd, err)
+	}
+	return int64(written), checksum, nil
+}
+
+// Go writes at most this many bytes at a time, checksumming each batch
+// first, as the Rust library does.
+const writevBatchBytes = 256 * 1024
+
+// goWritevChecksum is writevChecksum in pure Go, one write per batch.
+func goWritevChecksum(fd uintptr, algo ChecksumAlgorithm, buffers [][]byte) (uint64, uint64, error) {
+	var crc uint32
+	var state xxh64State
+	state.reset(0)
+
+	var written uint64
+	for _, buffer := range buffers {
+		for len(buffer) > 0 {
+			batch := buffer[:min(len(buffer), writevBatchBytes)]
+			buffer = buffer[len(batch):]
+			if algo == ChecksumCRC32C {
+				crc = crc32.Update(crc, castagnoli, batch)
+			} else {
+				state.update(batch)
+			}
+
+			for len(batch) > 0 {
+				count, err := writeDescriptor(fd, batch)
+				written += uint64(count)
+				if err != nil {
+					return written, 0, err
+				}
+				batch = batch[count:]
+			}
+		}
+	}
+
+	if algo == ChecksumCRC32C {
+		return written, uint64(crc), nil
+	}
+	return written, state.digest(), nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package rustybuffer
+
+import "errors"
+
+func writeDescriptor(fd uintptr, data []byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
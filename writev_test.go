@@ -0,0 +1,85 @@
+//go:build unix
+
+package rustybuffer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestWritevWithChecksum(t *testing.T) {
+	entry, err := NewPool(WithAllocator(NewHeapAllocator(1<<21, 1<<21))).AllocBuffers([]uint64{300_000, 0, 700_000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Release()
+	fillPattern(entry, 3)
+
+	for _, algo := range []ChecksumAlgorithm{ChecksumCRC32C, ChecksumXXH64} {
+		expected, err := entry.Checksum(algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, write := range map[string]func(*os.File) (int64, uint64, error){
+			"native": func(file *os.File) (int64, uint64, error) {
+				return WritevWithChecksum(file.Fd(), &entry, algo)
+			},
+			"go": func(file *os.File) (int64, uint64, error) {
+				written, checksum, err := goWritevChecksum(file.Fd(), algo, entry.Buffers)
+				return int64(written), checksum, err
+			},
+		} {
+			path := filepath.Join(t.TempDir(), "out")
+			file, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			written, checksum, err := write(file)
+			file.Close()
+			if err != nil {
+				t.Fatalf("%s %v: %v", name, algo, err)
+			}
+			if written != 1_000_000 || checksum != expected {
+				t.Fatalf("%s %v: wrote %d bytes with checksum %x, expected %x", name, algo, written, checksum, expected)
+			}
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(contents, flatten(entry)) {
+				t.Fatalf("%s %v: the file doesn't match the entry", name, algo)
+			}
+		}
+	}
+}
+
+func TestWritevWithChecksumErrors(t *testing.T) {
+	entry, err := NewPool(WithAllocator(NewHeapAllocator(1024, 1024))).AllocBuffers([]uint64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Opened read only, so every write fails.
+	file, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if written, _, err := WritevWithChecksum(file.Fd(), &entry, ChecksumCRC32C); !errors.Is(err, syscall.EBADF) || written != 0 {
+		t.Fatalf("expected EBADF, got %d bytes and %v", written, err)
+	}
+	if _, _, err := WritevWithChecksum(file.Fd(), &entry, ChecksumAlgorithm(9)); err == nil {
+		t.Fatal("expected an unknown algorithm to fail")
+	}
+
+	copied := entry
+	entry.Release()
+	if _, _, err := WritevWithChecksum(file.Fd(), &copied, ChecksumCRC32C); err == nil {
+		t.Fatal("expected writing a released entry to fail")
+	}
+}
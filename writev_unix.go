@@ -0,0 +1,16 @@
+//go:build unix
+
+package rustybuffer
+
+import "syscall"
+
+// writeDescriptor writes some of data to fd, retrying if interrupted.
+func writeDescriptor(fd uintptr, data []byte) (int, error) {
+	for {
+		count, err := syscall.Write(int(fd), data)
+		if err == syscall.EINTR {
+			continue
+		}
+		return max(count, 0), err
+	}
+}